@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/service"
+)
+
+// exportCallGraph collects calls from entity types to services from all running games by the admin API, and prints
+// the merged call graph as a table (default), Graphviz dot or JSON, so hot or failing service dependencies can be found
+func exportCallGraph(args []string) {
+	flags := flag.NewFlagSet("callgraph", flag.ExitOnError)
+	format := flags.String("format", "text", "output format: text, dot or json")
+	flags.Parse(args)
+	if *format != "text" && *format != "dot" && *format != "json" {
+		showMsgAndQuit("unknown format: %s", *format)
+	}
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+	if conf.GetAdminToken() == "" {
+		showMsgAndQuit("admin_token is not configured, can not request call graphs of games")
+	}
+
+	merged := map[[2]string]*service.CallEdge{}
+	games := 0
+	for gameid := uint16(1); int(gameid) <= conf.GetDeployment().DesiredGames; gameid++ {
+		var res struct {
+			Edges []*service.CallEdge `json:"edges"`
+		}
+		if err := requestGameAdmin(http.MethodGet, gameid, "callgraph", nil, &res); err != nil {
+			showMsg("get call graph of game%d failed: %s", gameid, err)
+			continue
+		}
+		games++
+		for _, edge := range res.Edges {
+			mergeCallEdge(merged, edge)
+		}
+	}
+
+	edges := make([]*service.CallEdge, 0, len(merged))
+	for _, edge := range merged {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Service < edges[j].Service
+	})
+
+	switch *format {
+	case "json":
+		out, err := json.MarshalIndent(edges, "", "  ")
+		checkErrorOrQuit(err, "encode call graph failed")
+		os.Stdout.Write(append(out, '\n'))
+	case "dot":
+		os.Stdout.WriteString(callGraphToDot(edges))
+	default:
+		fmt.Printf("%-24s %-24s %10s %8s %10s %10s\n", "CALLER", "SERVICE", "CALLS", "ERRORS", "AVG(ms)", "MAX(ms)")
+		for _, edge := range edges {
+			fmt.Printf("%-24s %-24s %10d %7.2f%% %10.2f %10.2f\n", edge.Caller, edge.Service, edge.Calls, edge.ErrorRate()*100,
+				avgCallDurationMS(edge), edge.MaxDurationSeconds*1000)
+		}
+	}
+	showMsg("%d dependencies from %d games", len(edges), games)
+}
+
+func mergeCallEdge(merged map[[2]string]*service.CallEdge, edge *service.CallEdge) {
+	key := [2]string{edge.Caller, edge.Service}
+	m := merged[key]
+	if m == nil {
+		m = &service.CallEdge{Caller: edge.Caller, Service: edge.Service, Results: map[string]int64{}}
+		merged[key] = m
+	}
+	m.Calls += edge.Calls
+	m.Errors += edge.Errors
+	for result, n := range edge.Results {
+		m.Results[result] += n
+	}
+	m.ResultCalls += edge.ResultCalls
+	m.DurationSeconds += edge.DurationSeconds
+	if edge.MaxDurationSeconds > m.MaxDurationSeconds {
+		m.MaxDurationSeconds = edge.MaxDurationSeconds
+	}
+}
+
+func avgCallDurationMS(edge *service.CallEdge) float64 {
+	if edge.ResultCalls == 0 {
+		return 0
+	}
+	return edge.DurationSeconds / float64(edge.ResultCalls) * 1000
+}
+
+// callGraphToDot draws edges with calls and error rates, failing edges are red
+func callGraphToDot(edges []*service.CallEdge) string {
+	var b strings.Builder
+	b.WriteString("digraph goworld {\n\trankdir=LR;\n")
+	for _, edge := range edges {
+		color := "black"
+		if edge.Errors > 0 {
+			color = "red"
+		}
+		label := fmt.Sprintf("%d calls\\n%.2f%% errors", edge.Calls, edge.ErrorRate()*100)
+		fmt.Fprintf(&b, "\t%s -> %s [color=%s, label=%s];\n", dotQuote(edge.Caller), dotQuote(edge.Service), color, dotQuote(label))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
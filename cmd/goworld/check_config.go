@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// checkConfig validates the config file without starting any server, so that configs can be checked before deploying
+func checkConfig(configFile string) {
+	if configFile == "" {
+		configFile = conf.GetConfigFilePath()
+	}
+
+	problems := config.Validate(configFile)
+	if len(problems) == 0 {
+		for _, overlap := range config.New(configFile).CheckCPUOverlaps() {
+			showMsg("warning: %s", overlap)
+		}
+		showMsg("%s is valid", configFile)
+		return
+	}
+
+	for _, problem := range problems {
+		showMsg("invalid: %s", problem)
+	}
+	showMsgAndQuit("%s is NOT valid: %d problems found", configFile, len(problems))
+}
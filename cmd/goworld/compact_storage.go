@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// compactStorage compacts the storage which supports compaction, e.g. goworld compact-storage filesystem
+//
+// Storages are named as in migrate-storage, the storage of the [storage] section is compacted if no name is given.
+func compactStorage(args []string) {
+	flags := flag.NewFlagSet("compact-storage", flag.ExitOnError)
+	flags.Parse(args)
+
+	name := "storage"
+	if flags.NArg() > 1 {
+		showMsgAndQuit("too many arguments for compact-storage")
+	} else if flags.NArg() == 1 {
+		name = flags.Arg(0)
+	}
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.NumGamesRunning > 0 {
+		showMsgAndQuit("games are running, stop the server before compacting storage")
+	}
+
+	cfg := getNamedStorageConfig(name)
+	es, err := storage.OpenStorage(cfg)
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	compactor, ok := es.(storagecommon.Compactor)
+	if !ok {
+		showMsgAndQuit("storage %s (%s) does not support compaction", name, cfg.Type)
+	}
+
+	showMsg("compacting storage %s (%s) ...", name, cfg.Type)
+	results, err := compactor.Compact()
+	for _, result := range results {
+		showMsg("%s: %d entities, %d files moved, %d files removed, %d bytes reclaimed", result.TypeName, result.Entities, result.MovedFiles, result.RemovedFiles, result.ReclaimedBytes)
+	}
+	checkErrorOrQuit(err, "compact storage failed")
+	showMsg("storage %s is compacted, %d entity types", name, len(results))
+}
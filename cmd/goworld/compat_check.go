@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// compatCheck checks if the protocols of two binaries (dispatcher, gate or game) are compatible
+func compatCheck(oldBinary string, newBinary string) {
+	oldCorpus := loadCompatCorpus(oldBinary)
+	newCorpus := loadCompatCorpus(newBinary)
+
+	problems := proto.CheckCompatCorpus(oldCorpus, newCorpus)
+	if len(problems) == 0 {
+		showMsg("%s and %s are compatible (protocol version %d, %d message types, %d messages)",
+			oldBinary, newBinary, newCorpus.ProtocolVersion, len(newCorpus.MsgTypes), len(newCorpus.Messages))
+		return
+	}
+
+	for _, problem := range problems {
+		showMsg("incompatible: %s", problem)
+	}
+	showMsgAndQuit("%s and %s are NOT compatible: %d problems found", oldBinary, newBinary, len(problems))
+}
+
+func loadCompatCorpus(binary string) *proto.CompatCorpus {
+	var stdout bytes.Buffer
+	cmd := exec.Command(binary, "-compat-corpus")
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	checkErrorOrQuit(cmd.Run(), "dump compat corpus of "+binary+" failed")
+
+	var corpus proto.CompatCorpus
+	checkErrorOrQuit(json.Unmarshal(stdout.Bytes(), &corpus), "parse compat corpus of "+binary+" failed")
+	return &corpus
+}
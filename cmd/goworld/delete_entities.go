@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+// deleteEntities deletes entities listed in the file from the storage in bulk, e.g. accounts banned in a ban wave:
+// goworld delete-entities --dry-run Account banned.txt
+//
+// Entities are moved to trash and can be restored by restore-entity within the trash retention, or deleted permanently
+// with --purge. Entities to be deleted are reported by --dry-run, and the deletion is executed with --plan=<hash> of
+// the dry run.
+func deleteEntities(args []string) {
+	flags := flag.NewFlagSet("delete-entities", flag.ExitOnError)
+	purge := flags.Bool("purge", false, "delete entities permanently, including entities in trash")
+	pf := addPlanFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		showMsgAndQuit("usage: goworld delete-entities [--purge] [--dry-run|--plan=<hash>] <type> <ids-file>")
+	}
+	typeName, idsFile := flags.Arg(0), flags.Arg(1)
+
+	eids, err := readEntityIDs(idsFile)
+	checkErrorOrQuit(err, "read entity IDs failed")
+
+	err = os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.NumGamesRunning > 0 {
+		showMsgAndQuit("games are running, stop the server before deleting entities")
+	}
+
+	es, err := storage.OpenStorage(conf.GetStorage())
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	plan, err := storage.PlanDelete(es, fmt.Sprintf("delete-entities %s purge=%v", typeName, *purge), typeName, eids, *purge)
+	checkErrorOrQuit(err, "plan deletion failed")
+	approvePlan(plan, pf)
+
+	deleted := 0
+	for _, eid := range eids {
+		checkErrorOrQuit(storage.DeleteFrom(es, typeName, eid, *purge), fmt.Sprintf("delete %s %s failed", typeName, eid))
+		deleted += 1
+		if deleted%1000 == 0 {
+			showMsg("deleting %s: %d/%d", typeName, deleted, len(eids))
+		}
+	}
+	showMsg("%d %s are deleted, %d skipped", plan.Count("delete")+plan.Count("purge"), typeName, plan.Count("skip"))
+}
+
+// readEntityIDs reads entity IDs from the file, one ID per line, empty lines and lines starting with # are ignored
+func readEntityIDs(file string) ([]common.EntityID, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var eids []common.EntityID
+	seen := common.EntityIDSet{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		eid := common.EntityID(line)
+		if len(eid) != common.ENTITYID_LENGTH {
+			return nil, errors.Errorf("invalid entity ID: %s", line)
+		}
+		if !seen.Contains(eid) {
+			seen.Add(eid)
+			eids = append(eids, eid)
+		}
+	}
+	return eids, scanner.Err()
+}
@@ -35,7 +35,10 @@ func (env *Env) GetGateBinary() string {
 	return filepath.Join(env.GetGateDir(), "gate"+BinaryExtension)
 }
 
-var env Env
+var (
+	env  Env
+	conf *config.Config // config of the detected goworld directory
+)
 
 func getGoSearchPaths() []string {
 	var paths []string
@@ -111,5 +114,11 @@ func detectGoWorldPath() {
 
 	showMsg("goworld directory found: %s", env.GoWorldRoot)
 	configFile := filepath.Join(env.GoWorldRoot, "goworld.ini")
-	config.SetConfigFile(configFile)
+	for _, name := range []string{"goworld.ini", "goworld.yaml", "goworld.yml", "goworld.json"} {
+		if f := filepath.Join(env.GoWorldRoot, name); isfile(f) {
+			configFile = f
+			break
+		}
+	}
+	conf = config.New(configFile)
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+// Development mode
+//
+// `goworld dev` builds the server and runs dispatchers, game1 and gate1 in the foreground with the normal config. Logs of
+// all processes are written to the terminal with process names, and all processes are stopped by Ctrl-C. Deployment is
+// overridden to one game and one gate, so any example can be run with one command.
+
+// devProcess is a process run in development mode
+type devProcess struct {
+	name    string
+	cmd     *exec.Cmd
+	started chan struct{} // closed when the started tag is found in the output
+	exited  chan struct{}
+}
+
+var devOutputLock sync.Mutex
+
+func dev(sid ServerID) {
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.IsRunning() {
+		status()
+		showMsgAndQuit("server is already running, stop it before running in development mode")
+	}
+
+	build(sid)
+
+	// processes receive Ctrl-C from the terminal, and terminate gracefully by themselves
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGINT, syscall.SIGTERM)
+
+	var procs []*devProcess
+	stopAll := func() {
+		for i := len(procs) - 1; i >= 0; i-- {
+			procs[i].stop()
+		}
+	}
+
+	for _, dispid := range conf.GetDispatcherIDs() {
+		procs = append(procs, startDevProcess(fmt.Sprintf("dispatcher%d", dispid), env.GetDispatcherBinary(), consts.DISPATCHER_STARTED_TAG,
+			"-dispid", fmt.Sprint(dispid), "-set", fmt.Sprintf("dispatcher%d.log_stderr=1", dispid)))
+	}
+	procs = append(procs, startDevProcess("game1", filepath.Join(sid.Path(), sid.Name()+BinaryExtension), consts.GAME_STARTED_TAG,
+		"-gid", "1", "-set", "game1.log_stderr=1"))
+	procs = append(procs, startDevProcess("gate1", env.GetGateBinary(), consts.GATE_STARTED_TAG,
+		"-gid", "1", "-set", "gate1.log_stderr=1"))
+
+	for _, proc := range procs {
+		select {
+		case <-proc.started:
+		case <-proc.exited:
+			stopAll()
+			showMsgAndQuit("%s exited before started", proc.name)
+		}
+	}
+	showMsg("server %s is running in development mode, press Ctrl-C to stop", sid)
+
+	// wait until any process exits, and then stop all processes
+	exited := make(chan *devProcess, len(procs))
+	for _, proc := range procs {
+		go func(proc *devProcess) {
+			<-proc.exited
+			exited <- proc
+		}(proc)
+	}
+	proc := <-exited
+	showMsg("%s exited, stopping all processes ...", proc.name)
+	stopAll()
+}
+
+func startDevProcess(name string, exe string, startedTag string, args ...string) *devProcess {
+	args = append(args, "-set", "deployment.desired_games=1", "-set", "deployment.desired_gates=1")
+	args = append(args, configFileArgs()...)
+	proc := &devProcess{
+		name:    name,
+		cmd:     exec.Command(exe, args...),
+		started: make(chan struct{}),
+		exited:  make(chan struct{}),
+	}
+
+	output, err := proc.cmd.StdoutPipe()
+	checkErrorOrQuit(err, "create output pipe failed")
+	proc.cmd.Stderr = proc.cmd.Stdout
+
+	showMsg("start %s ...", name)
+	err = proc.cmd.Start()
+	checkErrorOrQuit(err, "start "+name+" failed")
+	go proc.copyOutput(output, startedTag)
+	return proc
+}
+
+// copyOutput writes the output of the process to stderr line by line, and checks the started tag
+func (proc *devProcess) copyOutput(output io.Reader, startedTag string) {
+	isStarted := false
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		devOutputLock.Lock()
+		fmt.Fprintf(os.Stderr, "%-12s| %s\n", proc.name, line)
+		devOutputLock.Unlock()
+
+		if !isStarted && strings.Contains(line, startedTag) {
+			isStarted = true
+			close(proc.started)
+		}
+	}
+
+	proc.cmd.Wait()
+	close(proc.exited)
+}
+
+// stop stops the process gracefully and waits until it exits
+func (proc *devProcess) stop() {
+	select {
+	case <-proc.exited:
+		return
+	default:
+	}
+
+	proc.cmd.Process.Signal(StopSignal)
+	select {
+	case <-proc.exited:
+	case <-time.After(time.Minute):
+		showMsg("%s is not stopped in time, killing it ...", proc.name)
+		proc.cmd.Process.Kill()
+		<-proc.exited
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 var arguments struct {
 	runInDaemonMode bool
+	restoreSnapshot string // snapshot restored by games
 }
 
 func parseArgs() {
@@ -29,17 +30,41 @@ func main() {
 		showMsg("no command to execute")
 		flag.Usage()
 		fmt.Fprintf(os.Stderr, "\tgoworld <build|start|stop|kill|reload|status> [server-id]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld dev <server-id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld compat-check <old-binary> <new-binary>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld check-config [config-file]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld migrate-storage --from <storage> --to <storage> [--types <types>] [--state <file>] [--dry-run|--plan=<hash>]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld compact-storage [storage]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld delete-entities [--purge] [--dry-run|--plan=<hash>] <type> <ids-file>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld restore-entity <type> <id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld list-trash <type>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld snapshot <snapshot-id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld restore --snapshot=<snapshot-id> <server-id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld types [--format=dot|json] <server-id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld callgraph [--format=text|dot|json]\n")
 		os.Exit(1)
 	}
 
 	cmd := args[0]
 
-	if cmd == "build" || cmd == "start" || cmd == "stop" || cmd == "reload" || cmd == "kill" {
+	if cmd == "build" || cmd == "start" || cmd == "stop" || cmd == "reload" || cmd == "kill" || cmd == "dev" {
 		if len(args) != 2 {
 			showMsgAndQuit("server id is not given")
 		}
 	}
 
+	if cmd == "compat-check" {
+		if len(args) != 3 {
+			showMsgAndQuit("old binary and new binary are not given")
+		}
+	}
+
+	if cmd == "check-config" {
+		if len(args) > 2 {
+			showMsgAndQuit("too many arguments for check-config")
+		}
+	}
+
 	if cmd == "build" {
 		build(ServerID(args[1]))
 	} else if cmd == "start" {
@@ -52,10 +77,38 @@ func main() {
 		stop(ServerID(args[1]))
 	} else if cmd == "reload" {
 		reload(ServerID(args[1]))
+	} else if cmd == "dev" {
+		dev(ServerID(args[1]))
 	} else if cmd == "kill" {
 		kill(ServerID(args[1]))
 	} else if cmd == "status" {
 		status()
+	} else if cmd == "compat-check" {
+		compatCheck(args[1], args[2])
+	} else if cmd == "check-config" {
+		configFile := ""
+		if len(args) == 2 {
+			configFile = args[1]
+		}
+		checkConfig(configFile)
+	} else if cmd == "migrate-storage" {
+		migrateStorage(args[1:])
+	} else if cmd == "compact-storage" {
+		compactStorage(args[1:])
+	} else if cmd == "delete-entities" {
+		deleteEntities(args[1:])
+	} else if cmd == "restore-entity" {
+		restoreEntity(args[1:])
+	} else if cmd == "list-trash" {
+		listTrash(args[1:])
+	} else if cmd == "snapshot" {
+		snapshot(args[1:])
+	} else if cmd == "restore" {
+		restore(args[1:])
+	} else if cmd == "types" {
+		exportTypes(args[1:])
+	} else if cmd == "callgraph" {
+		exportCallGraph(args[1:])
 	} else {
 		showMsgAndQuit("unknown command: %s", cmd)
 	}
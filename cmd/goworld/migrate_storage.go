@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// migrateStorage copies all entities from one storage to another, e.g. goworld migrate-storage --from filesystem --to mongodb
+//
+// Storages are named by sections [storage_<name>] in the config file, or by the type of the [storage] section.
+// Entities to be created or overwritten in the target storage are reported by --dry-run, and the migration is executed
+// with --plan=<hash> of the dry run.
+func migrateStorage(args []string) {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := flags.String("from", "", "source storage")
+	to := flags.String("to", "", "target storage")
+	types := flags.String("types", "", "comma separated entity types to migrate, all types if empty")
+	stateFile := flags.String("state", "migrate-storage.state", "file to save progress for resuming, removed after migration completes")
+	pf := addPlanFlags(flags)
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		showMsgAndQuit("source and target storages should be specified by --from and --to")
+	}
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.NumGamesRunning > 0 {
+		showMsgAndQuit("games are running, stop the server before migrating storage")
+	}
+
+	fromConfig, toConfig := getNamedStorageConfig(*from), getNamedStorageConfig(*to)
+	if fromConfig == toConfig {
+		showMsgAndQuit("source and target storages are the same")
+	}
+
+	fromStorage, err := storage.OpenStorage(fromConfig)
+	checkErrorOrQuit(err, "open source storage failed")
+	defer fromStorage.Close()
+	toStorage, err := storage.OpenStorage(toConfig)
+	checkErrorOrQuit(err, "open target storage failed")
+	defer toStorage.Close()
+
+	opts := storagecommon.MigrateOptions{
+		StateFile: *stateFile,
+		Progress: func(typeName string, migrated int, total int) {
+			showMsg("migrating %s: %d/%d", typeName, migrated, total)
+		},
+	}
+	if *types != "" {
+		opts.Types = strings.Split(*types, ",")
+	}
+
+	// the plan is approved before the interrupted migration started
+	if _, err := os.Stat(*stateFile); err != nil {
+		plan, err := storagecommon.PlanMigrate(fmt.Sprintf("migrate-storage %s to %s", *from, *to), fromStorage, toStorage, opts)
+		checkErrorOrQuit(err, "plan migration failed")
+		approvePlan(plan, pf)
+	} else if *pf.dryRun {
+		showMsgAndQuit("migration is interrupted, run without --dry-run to resume, or remove %s to start over", *stateFile)
+	}
+
+	showMsg("migrating storage from %s (%s) to %s (%s) ...", *from, fromConfig.Type, *to, toConfig.Type)
+	results, err := storagecommon.Migrate(fromStorage, toStorage, opts)
+	for _, result := range results {
+		showMsg("%s: %d entities, %d migrated, %d skipped", result.TypeName, result.Total, result.Migrated, result.Skipped)
+	}
+	checkErrorOrQuit(err, "migrate storage failed, run the same command again to resume")
+
+	os.Remove(*stateFile)
+	showMsg("storage is migrated from %s to %s, %d entity types verified", *from, *to, len(results))
+}
+
+func getNamedStorageConfig(name string) *config.StorageConfig {
+	if cfg := conf.GetStorageByName(name); cfg != nil {
+		return cfg
+	}
+	if cfg := conf.GetStorage(); cfg.Type == name || name == "storage" {
+		return cfg
+	}
+
+	showMsgAndQuit("storage %s is not found, it should be defined in section [storage_%s]", name, name)
+	return nil
+}
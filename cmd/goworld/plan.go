@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// planFlags are flags of destructive commands, which are executed only with the plan hash reported by the dry run
+type planFlags struct {
+	dryRun *bool
+	plan   *string
+}
+
+func addPlanFlags(flags *flag.FlagSet) planFlags {
+	return planFlags{
+		dryRun: flags.Bool("dry-run", false, "report what would change and the plan hash without changing anything"),
+		plan:   flags.String("plan", "", "hash of the plan reported by the dry run, required for execution"),
+	}
+}
+
+// approvePlan shows the plan and quits in the dry run, otherwise quits if the plan is not the approved one
+func approvePlan(plan *storagecommon.Plan, pf planFlags) {
+	if *pf.dryRun {
+		showPlan(plan)
+		showMsg("dry run: nothing is changed, run again with --plan=%s to execute", plan.Hash())
+		os.Exit(0)
+	}
+
+	if err := plan.Verify(*pf.plan); err != nil {
+		showPlan(plan)
+		showMsgAndQuit("%s", err)
+	}
+}
+
+func showPlan(plan *storagecommon.Plan) {
+	showMsg("plan of %s:", plan.Operation)
+	for _, change := range plan.Changes() {
+		samples := make([]string, len(change.Samples))
+		for i, eid := range change.Samples {
+			samples[i] = string(eid)
+		}
+		more := ""
+		if change.Count > len(change.Samples) {
+			more = ", ..."
+		}
+		showMsg("  %s %s: %d entities (%s%s)", change.Action, change.TypeName, change.Count, strings.Join(samples, ", "), more)
+	}
+	showMsg("plan hash: %s", plan.Hash())
+}
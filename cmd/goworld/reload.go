@@ -4,7 +4,6 @@ import (
 	"os"
 
 	"github.com/xiaonanln/goworld/engine/binutil"
-	"github.com/xiaonanln/goworld/engine/config"
 )
 
 func reload(sid ServerID) {
@@ -24,8 +23,8 @@ func reload(sid ServerID) {
 
 	if ss.NumGamesRunning == 0 {
 		showMsgAndQuit("no game is running")
-	} else if ss.NumGamesRunning != config.GetDeployment().DesiredGames {
-		showMsgAndQuit("found %d games, but should have %d", ss.NumGamesRunning, config.GetDeployment().DesiredGames)
+	} else if ss.NumGamesRunning != conf.GetDeployment().DesiredGames {
+		showMsgAndQuit("found %d games, but should have %d", ss.NumGamesRunning, conf.GetDeployment().DesiredGames)
 	}
 
 	stopGames(ss, binutil.FreezeSignal)
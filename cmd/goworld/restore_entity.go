@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+// restoreEntity restores the entity deleted by delete-entities from trash:
+// goworld restore-entity Account <id>
+func restoreEntity(args []string) {
+	if len(args) != 2 {
+		showMsgAndQuit("usage: goworld restore-entity <type> <id>")
+	}
+	typeName, eid := args[0], common.EntityID(args[1])
+	if len(eid) != common.ENTITYID_LENGTH {
+		showMsgAndQuit("invalid entity ID: %s", eid)
+	}
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.NumGamesRunning > 0 {
+		showMsgAndQuit("games are running, stop the server before restoring entities")
+	}
+
+	es, err := storage.OpenStorage(conf.GetStorage())
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	checkErrorOrQuit(storage.RestoreFrom(es, typeName, eid), fmt.Sprintf("restore %s %s failed", typeName, eid))
+	showMsg("%s %s is restored", typeName, eid)
+}
+
+// listTrash lists entities of the type in trash, which can be restored by restore-entity:
+// goworld list-trash Account
+func listTrash(args []string) {
+	if len(args) != 1 {
+		showMsgAndQuit("usage: goworld list-trash <type>")
+	}
+	typeName := args[0]
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	es, err := storage.OpenStorage(conf.GetStorage())
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	trashed, err := storage.ListTrash(es, typeName)
+	checkErrorOrQuit(err, "list trash failed")
+
+	retention := conf.GetStorage().TrashRetention
+	for _, te := range trashed {
+		fmt.Printf("%s\tdeleted at %s\tpurged after %s\n", te.EntityID, te.DeleteTime.Format(time.RFC3339), te.DeleteTime.Add(retention).Format(time.RFC3339))
+	}
+	showMsg("%d %s in trash, trash retention is %s", len(trashed), typeName, retention)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// snapshot writes the world state of the running cluster to the storage, e.g. goworld snapshot before-maintenance
+//
+// All games are requested to write their entities and service registrations by the admin API, so admin_token should
+// be configured. The snapshot can be restored later by goworld restore --snapshot=<snapshot-id> <server-id>.
+func snapshot(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	timeout := flags.Duration("timeout", time.Minute*10, "timeout of writing the snapshot")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		showMsgAndQuit("snapshot id is not given")
+	}
+	id := flags.Arg(0)
+	checkErrorOrQuit(storagecommon.ValidateSnapshotID(id), "invalid snapshot id")
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	desiredGames := conf.GetDeployment().DesiredGames
+	ss := detectServerStatus()
+	if ss.NumGamesRunning == 0 {
+		showMsgAndQuit("no game is running")
+	} else if ss.NumGamesRunning != desiredGames {
+		showMsgAndQuit("found %d games, but should have %d", ss.NumGamesRunning, desiredGames)
+	}
+	if conf.GetAdminToken() == "" {
+		showMsgAndQuit("admin_token is not configured, can not request games to write the snapshot")
+	}
+
+	es, err := storage.OpenStorage(conf.GetStorage())
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	manifestIDs, err := es.List(storagecommon.SnapshotManifestTypeName(id))
+	checkErrorOrQuit(err, "read snapshot failed")
+	if len(manifestIDs) > 0 {
+		showMsgAndQuit("snapshot %s already exists", id)
+	}
+
+	for gameid := uint16(1); int(gameid) <= desiredGames; gameid++ {
+		var res struct {
+			Entities int `json:"entities"`
+		}
+		err := postGameAdmin(gameid, "snapshot", url.Values{"id": {id}}, &res)
+		checkErrorOrQuit(err, fmt.Sprintf("snapshot game%d failed", gameid))
+		showMsg("game%d: writing %d entities ...", gameid, res.Entities)
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for len(manifestIDs) < desiredGames {
+		if time.Now().After(deadline) {
+			showMsgAndQuit("snapshot %s timeout: %d of %d games are written", id, len(manifestIDs), desiredGames)
+		}
+		time.Sleep(time.Second)
+		manifestIDs, err = es.List(storagecommon.SnapshotManifestTypeName(id))
+		checkErrorOrQuit(err, "read snapshot failed")
+	}
+
+	manifests, err := storagecommon.ReadSnapshotManifests(es, id)
+	checkErrorOrQuit(err, "read snapshot failed")
+	showSnapshot(id, manifests)
+}
+
+// restore starts the server from the snapshot, e.g. goworld restore --snapshot=before-maintenance <server-id>
+//
+// The snapshot can be restored by a different number of games, each game restores its partition of entities.
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	id := flags.String("snapshot", "", "snapshot to restore")
+	flags.Parse(args)
+	if *id == "" {
+		showMsgAndQuit("snapshot id is not given")
+	}
+	if flags.NArg() != 1 {
+		showMsgAndQuit("server id is not given")
+	}
+	checkErrorOrQuit(storagecommon.ValidateSnapshotID(*id), "invalid snapshot id")
+
+	err := os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+
+	ss := detectServerStatus()
+	if ss.IsRunning() {
+		status()
+		showMsgAndQuit("server is already running, stop the server before restoring snapshot")
+	}
+
+	es, err := storage.OpenStorage(conf.GetStorage())
+	checkErrorOrQuit(err, "open storage failed")
+	manifests, err := storagecommon.ReadSnapshotManifests(es, *id)
+	es.Close()
+	checkErrorOrQuit(err, "read snapshot failed")
+	showSnapshot(*id, manifests)
+
+	arguments.restoreSnapshot = *id
+	start(ServerID(flags.Arg(0)))
+}
+
+func showSnapshot(id string, manifests []*storagecommon.SnapshotManifest) {
+	entities, services := 0, 0
+	for _, manifest := range manifests {
+		entities += manifest.Entities
+		services += len(manifest.Services)
+	}
+	showMsg("snapshot %s: %d games, %d entities, %d services", id, len(manifests), entities, services)
+}
+
+// postGameAdmin calls the admin API of the game, and decodes the JSON result to res
+func postGameAdmin(gameid uint16, path string, params url.Values, res interface{}) error {
+	return requestGameAdmin(http.MethodPost, gameid, path, params, res)
+}
+
+func requestGameAdmin(method string, gameid uint16, path string, params url.Values, res interface{}) error {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/admin/%s?%s", config.DialAddr(conf.GetGame(gameid).HTTPAddr), path, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.GetAdminToken())
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return errors.Errorf("%s: %s", resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 )
 
@@ -31,7 +30,7 @@ func start(sid ServerID) {
 
 func startDispatchers() {
 	showMsg("start dispatchers ...")
-	dispatcherIds := config.GetDispatcherIDs()
+	dispatcherIds := conf.GetDispatcherIDs()
 	showMsg("dispatcher ids: %v", dispatcherIds)
 	for _, dispid := range dispatcherIds {
 		startDispatcher(dispid)
@@ -39,8 +38,9 @@ func startDispatchers() {
 }
 
 func startDispatcher(dispid uint16) {
-	cfg := config.GetDispatcher(dispid)
+	cfg := conf.GetDispatcher(dispid)
 	args := []string{"-dispid", strconv.Itoa(int(dispid))}
+	args = append(args, configFileArgs()...)
 	if arguments.runInDaemonMode {
 		args = append(args, "-d")
 	}
@@ -51,7 +51,7 @@ func startDispatcher(dispid uint16) {
 
 func startGames(sid ServerID, isRestore bool) {
 	showMsg("start games ...")
-	desiredGames := config.GetDeployment().DesiredGames
+	desiredGames := conf.GetDeployment().DesiredGames
 	showMsg("desired games = %d", desiredGames)
 	for gameid := uint16(1); int(gameid) <= desiredGames; gameid++ {
 		startGame(sid, gameid, isRestore)
@@ -63,20 +63,24 @@ func startGame(sid ServerID, gameid uint16, isRestore bool) {
 
 	gameExePath := filepath.Join(sid.Path(), sid.Name()+BinaryExtension)
 	args := []string{"-gid", strconv.Itoa(int(gameid))}
+	args = append(args, configFileArgs()...)
 	if isRestore {
 		args = append(args, "-restore")
 	}
+	if arguments.restoreSnapshot != "" {
+		args = append(args, "-snapshot", arguments.restoreSnapshot)
+	}
 	if arguments.runInDaemonMode {
 		args = append(args, "-d")
 	}
 	cmd := exec.Command(gameExePath, args...)
-	err := runCmdUntilTag(cmd, config.GetGame(gameid).LogFile, consts.GAME_STARTED_TAG, time.Second*600)
+	err := runCmdUntilTag(cmd, conf.GetGame(gameid).LogFile, consts.GAME_STARTED_TAG, time.Second*600)
 	checkErrorOrQuit(err, "start game failed, see game.log for error")
 }
 
 func startGates() {
 	showMsg("start gates ...")
-	desiredGates := config.GetDeployment().DesiredGates
+	desiredGates := conf.GetDeployment().DesiredGates
 	showMsg("desired gates = %d", desiredGates)
 	for gateid := uint16(1); int(gateid) <= desiredGates; gateid++ {
 		startGate(gateid)
@@ -87,14 +91,24 @@ func startGate(gateid uint16) {
 	showMsg("start gate %d ...", gateid)
 
 	args := []string{"-gid", strconv.Itoa(int(gateid))}
+	args = append(args, configFileArgs()...)
 	if arguments.runInDaemonMode {
 		args = append(args, "-d")
 	}
 	cmd := exec.Command(env.GetGateBinary(), args...)
-	err := runCmdUntilTag(cmd, config.GetGate(gateid).LogFile, consts.GATE_STARTED_TAG, time.Second*10)
+	err := runCmdUntilTag(cmd, conf.GetGate(gateid).LogFile, consts.GATE_STARTED_TAG, time.Second*10)
 	checkErrorOrQuit(err, "start gate failed, see gate.log for error")
 }
 
+// configFileArgs returns the arguments for passing the config file to processes which is not goworld.ini
+func configFileArgs() []string {
+	configFile := conf.GetConfigFilePath()
+	if filepath.Base(configFile) == "goworld.ini" {
+		return nil
+	}
+	return []string{"-configfile", configFile}
+}
+
 func runCmdUntilTag(cmd *exec.Cmd, logFile string, tag string, timeout time.Duration) (err error) {
 	clearLogFile(logFile)
 	err = cmd.Start()
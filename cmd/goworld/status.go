@@ -7,7 +7,6 @@ import (
 	"fmt"
 
 	"github.com/xiaonanln/goworld/cmd/goworld/process"
-	"github.com/xiaonanln/goworld/engine/config"
 )
 
 // ServerStatus represents the status of a server
@@ -93,8 +92,8 @@ func status() {
 
 func showServerStatus(ss *ServerStatus) {
 	showMsg("%d dispatcher running, %d/%d gates running, %d/%d games (%s) running", ss.NumDispatcherRunning,
-		ss.NumGatesRunning, config.GetDeployment().DesiredGates,
-		ss.NumGamesRunning, config.GetDeployment().DesiredGames,
+		ss.NumGatesRunning, conf.GetDeployment().DesiredGates,
+		ss.NumGamesRunning, conf.GetDeployment().DesiredGames,
 		ss.ServerID,
 	)
 
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// registeredTypes is the part of types dumped by the game binary by -dump-types, which is used for the graph
+type registeredTypes struct {
+	Types []struct {
+		Name          string `json:"name"`
+		GoType        string `json:"go_type"`
+		Service       bool   `json:"service"`
+		Persistent    bool   `json:"persistent"`
+		ClientMethods []struct {
+			Name string `json:"name"`
+		} `json:"client_methods"`
+	} `json:"types"`
+	Services []struct {
+		Name       string `json:"name"`
+		ShardCount int    `json:"shard_count"`
+	} `json:"services"`
+}
+
+// typeEdge is a dependency between entity types found in the source of the server
+type typeEdge struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Kind    string   `json:"kind"` // calls, creates or client
+	Methods []string `json:"methods,omitempty"`
+}
+
+const (
+	_GAME_NODE   = "game"   // calls outside of methods of entity types
+	_CLIENT_NODE = "client" // calls from clients
+)
+
+var (
+	// index of the method argument of service calls
+	serviceCallMethodArg = map[string]int{
+		"CallService":                   1,
+		"CallServiceWithResult":         1,
+		"CallServiceAny":                1,
+		"CallServiceAll":                1,
+		"CallServiceAnyWithResult":      1,
+		"CallServiceShardIndex":         2,
+		"CallServiceShardKey":           2,
+		"CallServiceShardKeyWithResult": 2,
+	}
+	// index of the type name argument of entity creations
+	createEntityTypeArg = map[string]int{
+		"CreateEntity":               0,
+		"CreateEntityLocally":        0,
+		"CreateEntityAnywhere":       0,
+		"CreateEntityAnywhereQueued": 0,
+		"CreateEntityOnGame":         1,
+		"LoadEntity":                 0,
+		"LoadEntityAnywhere":         0,
+		"LoadEntityOnGame":           0,
+		"LoadEntityLocally":          0,
+	}
+)
+
+// exportTypes exports entity types, client methods and services registered by the server, and the dependency graph
+// found in the source of the server in Graphviz dot (default) or JSON
+func exportTypes(args []string) {
+	flags := flag.NewFlagSet("types", flag.ExitOnError)
+	format := flags.String("format", "dot", "output format: dot or json")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		showMsgAndQuit("usage: goworld types [--format=dot|json] <server-id>")
+	}
+	if *format != "dot" && *format != "json" {
+		showMsgAndQuit("unknown format: %s", *format)
+	}
+
+	sid := ServerID(flags.Arg(0))
+	gameExePath := filepath.Join(sid.Path(), sid.Name()+BinaryExtension)
+	if !isfile(gameExePath) {
+		showMsgAndQuit("%s is not found, build the server first", gameExePath)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command(gameExePath, "-dump-types")
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	checkErrorOrQuit(cmd.Run(), "dump types of "+gameExePath+" failed")
+
+	var types registeredTypes
+	checkErrorOrQuit(json.Unmarshal(stdout.Bytes(), &types), "parse types of "+gameExePath+" failed")
+	edges, err := scanTypeEdges(sid.Path(), &types)
+	checkErrorOrQuit(err, "scan source of "+string(sid)+" failed")
+
+	if *format == "json" {
+		out, err := json.MarshalIndent(map[string]interface{}{
+			"registry": json.RawMessage(stdout.Bytes()),
+			"edges":    edges,
+		}, "", "  ")
+		checkErrorOrQuit(err, "encode types failed")
+		os.Stdout.Write(append(out, '\n'))
+	} else {
+		os.Stdout.WriteString(typesToDot(&types, edges))
+	}
+	showMsg("%d entity types, %d services, %d dependencies", len(types.Types), len(types.Services), len(edges))
+}
+
+// scanTypeEdges finds service calls and entity creations with constant names in methods of entity types in the
+// source directory, and client methods of entity types
+func scanTypeEdges(dir string, types *registeredTypes) ([]*typeEdge, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	entityTypes := map[string]string{} // Go type name -> entity type name
+	for _, t := range types.Types {
+		entityTypes[t.GoType[strings.LastIndexByte(t.GoType, '.')+1:]] = t.Name
+	}
+
+	edges := map[[3]string]*typeEdge{}
+	addEdge := func(from, to, kind, method string) {
+		key := [3]string{from, to, kind}
+		edge := edges[key]
+		if edge == nil {
+			edge = &typeEdge{From: from, To: to, Kind: kind}
+			edges[key] = edge
+		}
+		if method != "" && !containsString(edge.Methods, method) {
+			edge.Methods = append(edge.Methods, method)
+		}
+	}
+
+	for _, pkg := range pkgs {
+		consts := map[string]string{} // string constants of the package
+		for _, file := range pkg.Files {
+			collectStringConsts(file, consts)
+		}
+		stringArg := func(call *ast.CallExpr, i int) (string, bool) {
+			if i >= len(call.Args) {
+				return "", false
+			}
+			switch arg := call.Args[i].(type) {
+			case *ast.BasicLit:
+				if arg.Kind == token.STRING {
+					s, err := strconv.Unquote(arg.Value)
+					return s, err == nil
+				}
+			case *ast.Ident:
+				s, ok := consts[arg.Name]
+				return s, ok
+			}
+			return "", false
+		}
+
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+				caller := _GAME_NODE
+				if fn.Recv != nil && len(fn.Recv.List) == 1 {
+					recvType := fn.Recv.List[0].Type
+					if star, ok := recvType.(*ast.StarExpr); ok {
+						recvType = star.X
+					}
+					if ident, ok := recvType.(*ast.Ident); ok && entityTypes[ident.Name] != "" {
+						caller = entityTypes[ident.Name]
+					}
+				}
+
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					var name string
+					switch fun := call.Fun.(type) {
+					case *ast.SelectorExpr:
+						name = fun.Sel.Name
+					case *ast.Ident:
+						name = fun.Name
+					}
+					if i, ok := serviceCallMethodArg[name]; ok {
+						if serviceName, ok := stringArg(call, 0); ok {
+							method, _ := stringArg(call, i)
+							addEdge(caller, serviceName, "calls", method)
+						}
+					} else if i, ok := createEntityTypeArg[name]; ok {
+						if typeName, ok := stringArg(call, i); ok {
+							addEdge(caller, typeName, "creates", "")
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	for _, t := range types.Types {
+		for _, method := range t.ClientMethods {
+			addEdge(_CLIENT_NODE, t.Name, "client", method.Name)
+		}
+	}
+
+	sorted := make([]*typeEdge, 0, len(edges))
+	for _, edge := range edges {
+		sort.Strings(edge.Methods)
+		sorted = append(sorted, edge)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Kind < b.Kind
+	})
+	return sorted, nil
+}
+
+func collectStringConsts(file *ast.File, consts map[string]string) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if i >= len(vs.Values) {
+					break
+				}
+				if lit, ok := vs.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					if s, err := strconv.Unquote(lit.Value); err == nil {
+						consts[name.Name] = s
+					}
+				}
+			}
+		}
+	}
+}
+
+func typesToDot(types *registeredTypes, edges []*typeEdge) string {
+	shardCounts := map[string]int{}
+	for _, s := range types.Services {
+		shardCounts[s.Name] = s.ShardCount
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph goworld {\n\trankdir=LR;\n")
+	fmt.Fprintf(&b, "\t%s [shape=ellipse];\n", dotQuote(_CLIENT_NODE))
+	for _, t := range types.Types {
+		label := t.Name
+		shape := "box"
+		if t.Service {
+			label += fmt.Sprintf("\\nservice x%d", shardCounts[t.Name])
+			shape = "component"
+		} else if t.Persistent {
+			label += "\\npersistent"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=%s, label=%s];\n", dotQuote(t.Name), shape, dotQuote(label))
+	}
+	for _, edge := range edges {
+		style := "solid"
+		if edge.Kind == "creates" {
+			style = "dashed"
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [style=%s, label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), style, dotQuote(strings.Join(edge.Methods, "\\n")))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes the ID of dot, escape sequences like \n are kept
+func dotQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// gwbench runs the engine benchmarks and outputs results in JSON format
+//
+// Usage:
+//
+//	gwbench [-bench regexp] [-benchtime 1s] [-o report.json] [-baseline baseline.json] [-threshold 0.2]
+//
+// If baseline is given, gwbench exits with code 1 if any benchmark is regressed compared to the baseline.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/bench"
+)
+
+var args struct {
+	bench     string
+	benchtime string
+	output    string
+	baseline  string
+	threshold float64
+	list      bool
+}
+
+func parseArgs() {
+	testing.Init()
+	flag.StringVar(&args.bench, "bench", "", "run only benchmarks matching the regexp")
+	flag.StringVar(&args.benchtime, "benchtime", "1s", "run each benchmark for duration d or N times (Nx)")
+	flag.StringVar(&args.output, "o", "", "write JSON report to file instead of stdout")
+	flag.StringVar(&args.baseline, "baseline", "", "compare with the baseline JSON report")
+	flag.Float64Var(&args.threshold, "threshold", 0.2, "regression threshold compared to baseline (0.2 means 20%)")
+	flag.BoolVar(&args.list, "list", false, "list benchmarks and quit")
+	flag.Parse()
+}
+
+func main() {
+	parseArgs()
+
+	if args.list {
+		for _, bm := range bench.Benchmarks() {
+			fmt.Println(bm.Name)
+		}
+		return
+	}
+
+	if err := flag.Set("test.benchtime", args.benchtime); err != nil {
+		quit("invalid benchtime: %v", err)
+	}
+
+	var filter *regexp.Regexp
+	if args.bench != "" {
+		var err error
+		if filter, err = regexp.Compile(args.bench); err != nil {
+			quit("invalid bench regexp: %v", err)
+		}
+	}
+
+	report := bench.Run(filter, func(res bench.Result) {
+		fmt.Fprintf(os.Stderr, "%-40s %10d %12d ns/op %8d allocs/op %10d B/op\n", res.Name, res.N, res.NsPerOp, res.AllocsPerOp, res.BytesPerOp)
+	})
+
+	out := os.Stdout
+	if args.output != "" {
+		f, err := os.Create(args.output)
+		if err != nil {
+			quit("create %s failed: %v", args.output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := bench.WriteReport(out, report); err != nil {
+		quit("write report failed: %v", err)
+	}
+
+	if args.baseline != "" {
+		f, err := os.Open(args.baseline)
+		if err != nil {
+			quit("open baseline failed: %v", err)
+		}
+		baseline, err := bench.ReadReport(f)
+		f.Close()
+		if err != nil {
+			quit("read baseline failed: %v", err)
+		}
+
+		regressions := bench.Compare(baseline, report, args.threshold)
+		for _, regression := range regressions {
+			fmt.Fprintf(os.Stderr, "REGRESSION: %s\n", regression)
+		}
+		if len(regressions) > 0 {
+			if args.output != "" {
+				out.Close()
+			}
+			os.Exit(1)
+		}
+	}
+}
+
+func quit(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	os.Exit(2)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/netconnutil"
+)
+
+const (
+	loadTestMethod      = "GWLoadTest"
+	trafficTickInterval = time.Millisecond * 10
+)
+
+var (
+	migrateData = make([]byte, 512) // size of a typical migrate data
+)
+
+// fakeClient is a fake game or gate which connects to the dispatcher
+type fakeClient struct {
+	*proto.GoWorldConnection
+	gameid uint16
+	gateid uint16
+	rnd    *rand.Rand
+}
+
+func dialDispatcher(addr string) (*proto.GoWorldConnection, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second*5)
+	if err != nil {
+		return nil, err
+	}
+	conn = netconnutil.NewNoTempErrorConn(conn)
+	gwc := proto.NewGoWorldConnection(netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE))
+	gwc.SetAutoFlush(consts.DISPATCHER_CLIENT_FLUSH_INTERVAL)
+	return gwc, nil
+}
+
+// connectFakeGame connects to the dispatcher as a game owning the entities, and waits for the handshake to finish
+func connectFakeGame(addr string, gameid uint16, eids []common.EntityID) (*fakeClient, error) {
+	gwc, err := dialDispatcher(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// ban boot entity so that real clients are never booted on fake games
+	gwc.SendSetGameID(gameid, false, false, true, eids)
+	gwc.SetRecvDeadline(time.Now().Add(time.Second * 10))
+	for {
+		var msgtype proto.MsgType
+		pkt, err := gwc.Recv(&msgtype)
+		if err != nil {
+			gwc.Close()
+			return nil, errors.Wrapf(err, "game%d: wait for set game id ack failed", gameid)
+		}
+		pkt.Release()
+		if msgtype == proto.MT_SET_GAME_ID_ACK {
+			break
+		}
+	}
+	gwc.SetRecvDeadline(time.Time{})
+
+	return &fakeClient{GoWorldConnection: gwc, gameid: gameid, rnd: rand.New(rand.NewSource(int64(gameid)))}, nil
+}
+
+// connectFakeGate connects to the dispatcher as a gate
+func connectFakeGate(addr string, gateid uint16) (*fakeClient, error) {
+	gwc, err := dialDispatcher(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	gwc.SendSetGateID(gateid)
+	return &fakeClient{GoWorldConnection: gwc, gateid: gateid, rnd: rand.New(rand.NewSource(int64(gateid) << 16))}, nil
+}
+
+func (fc *fakeClient) String() string {
+	if fc.gameid > 0 {
+		return "FakeGame<" + fc.GoWorldConnection.String() + ">"
+	}
+	return "FakeGate<" + fc.GoWorldConnection.String() + ">"
+}
+
+// recvRoutine receives packets from the dispatcher and responses like real games and gates
+func (fc *fakeClient) recvRoutine(world *loadWorld, stats *loadStats) {
+	for {
+		var msgtype proto.MsgType
+		pkt, err := fc.Recv(&msgtype)
+		if err != nil {
+			if !fc.IsClosed() {
+				gwlog.Errorf("%s: recv failed: %v", fc, err)
+			}
+			return
+		}
+
+		switch msgtype {
+		case proto.MT_CALL_ENTITY_METHOD:
+			_ = pkt.ReadEntityID()
+			_ = pkt.ReadVarStr()
+			stats.addReceived(trafficRPC, 1, rpcLatency(pkt.ReadArgs()))
+		case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
+			_ = pkt.ReadEntityID()
+			_ = pkt.ReadVarStr()
+			stats.addReceived(trafficClientRPC, 1, rpcLatency(pkt.ReadArgs()))
+		case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
+			n := len(pkt.UnreadPayload()) / (common.ENTITYID_LENGTH + proto.SYNC_INFO_SIZE_PER_ENTITY)
+			stats.addReceived(trafficSync, int64(n), -1)
+		case proto.MT_MIGRATE_REQUEST:
+			// dispatcher blocked RPCs to the entity, now do the real migrate
+			eid := pkt.ReadEntityID()
+			_ = pkt.ReadEntityID() // spaceid
+			spaceGameID := pkt.ReadUint16()
+			fc.SendRealMigrate(eid, spaceGameID, migrateData)
+		case proto.MT_REAL_MIGRATE:
+			eid := pkt.ReadEntityID()
+			_ = pkt.ReadUint16() // target game
+			stats.addReceived(trafficMigrate, 1, world.finishMigrate(eid, fc.gameid))
+		}
+		pkt.Release()
+	}
+}
+
+func rpcLatency(args [][]byte) time.Duration {
+	if len(args) == 0 {
+		return -1
+	}
+
+	var sendTime int64
+	if err := netutil.MSG_PACKER.UnpackMsg(args[0], &sendTime); err != nil {
+		return -1
+	}
+	return time.Duration(time.Now().UnixNano() - sendTime)
+}
+
+// sendRoutine generates traffic of the kinds at the specified rate (messages per second)
+func (fc *fakeClient) sendRoutine(rate float64, mix trafficMix, kinds []string, numGames int, firstGameID uint16, world *loadWorld, stats *loadStats) {
+	if rate <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(trafficTickInterval)
+	defer ticker.Stop()
+
+	lastTime := time.Now()
+	var quota float64
+	for now := range ticker.C {
+		if fc.IsClosed() {
+			return
+		}
+
+		quota += rate * now.Sub(lastTime).Seconds()
+		lastTime = now
+		for ; quota >= 1; quota -= 1 {
+			kind := mix.choose(fc.rnd, kinds)
+			if fc.sendTraffic(kind, numGames, firstGameID, world) {
+				stats.addSent(kind)
+			}
+		}
+	}
+}
+
+func (fc *fakeClient) sendTraffic(kind string, numGames int, firstGameID uint16, world *loadWorld) bool {
+	switch kind {
+	case trafficRPC:
+		eid := world.randomEntity(fc.rnd)
+		if eid == "" {
+			return false
+		}
+		fc.SendCallEntityMethod(eid, loadTestMethod, []interface{}{time.Now().UnixNano(), "payload"})
+	case trafficClientRPC:
+		eid := world.randomEntity(fc.rnd)
+		if eid == "" {
+			return false
+		}
+		// gates append the client ID to RPC packets from clients
+		pkt := netutil.NewPacket()
+		pkt.AppendUint16(proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT)
+		pkt.AppendEntityID(eid)
+		pkt.AppendVarStr(loadTestMethod)
+		pkt.AppendArgs([]interface{}{time.Now().UnixNano(), "payload"})
+		pkt.AppendClientID(common.GenClientID())
+		fc.SendPacketRelease(pkt)
+	case trafficSync:
+		eid := world.randomEntity(fc.rnd)
+		if eid == "" {
+			return false
+		}
+		fc.SendSyncPositionYawFromClient(eid, fc.rnd.Float32()*1000, 0, fc.rnd.Float32()*1000, fc.rnd.Float32()*360)
+	case trafficMigrate:
+		if numGames < 2 {
+			return false
+		}
+		eid := world.startMigrate(fc.rnd, fc.gameid)
+		if eid == "" {
+			return false
+		}
+
+		targetGameID := fc.gameid
+		for targetGameID == fc.gameid {
+			targetGameID = firstGameID + uint16(fc.rnd.Intn(numGames))
+		}
+		fc.SendMigrateRequest(eid, common.GenEntityID(), targetGameID)
+	default:
+		return false
+	}
+	return true
+}
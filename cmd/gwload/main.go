@@ -0,0 +1,141 @@
+// gwload is a synthetic load generator which speaks the internal protocol directly to a dispatcher.
+//
+// It connects to the dispatcher as fake games and gates, and generates configurable mixes of RPC, sync and migration
+// traffic at target rates, so that dispatcher changes can be soak-tested without a full cluster of games and bots.
+//
+// gwload should be run against a dedicated dispatcher, since real games may create entities on fake games.
+package main
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+var args struct {
+	configFile  string
+	dispid      uint
+	addr        string
+	numGames    int
+	numGates    int
+	numEntities int
+	firstGameID uint
+	firstGateID uint
+	rate        float64
+	mix         string
+	duration    time.Duration
+	interval    time.Duration
+}
+
+func parseArgs() {
+	flag.StringVar(&args.configFile, "configfile", "", "set config file path")
+	flag.UintVar(&args.dispid, "dispid", 1, "dispatcher ID to connect in config file")
+	flag.StringVar(&args.addr, "addr", "", "dispatcher address to connect (override config file)")
+	flag.IntVar(&args.numGames, "games", 2, "number of fake games")
+	flag.IntVar(&args.numGates, "gates", 1, "number of fake gates")
+	flag.IntVar(&args.numEntities, "entities", 1000, "number of fake entities per game")
+	flag.UintVar(&args.firstGameID, "gameid", 1000, "game ID of the first fake game")
+	flag.UintVar(&args.firstGateID, "gateid", 1000, "gate ID of the first fake gate")
+	flag.Float64Var(&args.rate, "rate", 10000, "total messages per second")
+	flag.StringVar(&args.mix, "mix", "rpc=60,client_rpc=10,sync=25,migrate=5", "traffic mix weights")
+	flag.DurationVar(&args.duration, "duration", 0, "test duration (0 means run until interrupted)")
+	flag.DurationVar(&args.interval, "interval", time.Second*5, "statistics report interval")
+	flag.Parse()
+}
+
+func main() {
+	parseArgs()
+	gwlog.SetLevel(gwlog.InfoLevel)
+
+	mix, err := parseTrafficMix(args.mix)
+	if err != nil {
+		gwlog.Fatalf("invalid traffic mix: %v", err)
+	}
+
+	addr := args.addr
+	if addr == "" {
+		conf := config.Default()
+		if args.configFile != "" {
+			conf.SetConfigFile(args.configFile)
+		}
+		dispatcherConfig := conf.GetDispatcher(uint16(args.dispid))
+		if dispatcherConfig == nil {
+			gwlog.Fatalf("dispatcher %d is not found in config", args.dispid)
+		}
+		addr = dispatcherConfig.AdvertiseAddr
+	}
+
+	gameWeight, gateWeight := mix.totalWeight(gameTrafficKinds), mix.totalWeight(gateTrafficKinds)
+	if gameWeight > 0 && args.numGames <= 0 {
+		gwlog.Fatalf("traffic mix %s needs fake games", mix)
+	}
+	if gateWeight > 0 && args.numGates <= 0 {
+		gwlog.Fatalf("traffic mix %s needs fake gates", mix)
+	}
+
+	world := newLoadWorld()
+	stats := newLoadStats()
+	firstGameID := uint16(args.firstGameID)
+	var clients []*fakeClient
+
+	for i := 0; i < args.numGames; i++ {
+		gameid := firstGameID + uint16(i)
+		fc, err := connectFakeGame(addr, gameid, world.createEntities(gameid, args.numEntities))
+		if err != nil {
+			gwlog.Fatalf("connect dispatcher %s failed: %v", addr, err)
+		}
+		clients = append(clients, fc)
+	}
+	for i := 0; i < args.numGates; i++ {
+		fc, err := connectFakeGate(addr, uint16(args.firstGateID)+uint16(i))
+		if err != nil {
+			gwlog.Fatalf("connect dispatcher %s failed: %v", addr, err)
+		}
+		clients = append(clients, fc)
+	}
+
+	totalWeight := float64(gameWeight + gateWeight)
+	gwlog.Infof("Connected to dispatcher %s with %d games and %d gates, generating %.0f messages/s: %s", addr, args.numGames, args.numGates, args.rate, mix)
+	for _, fc := range clients {
+		go fc.recvRoutine(world, stats)
+		if fc.gameid > 0 {
+			go fc.sendRoutine(args.rate*float64(gameWeight)/totalWeight/float64(args.numGames), mix, gameTrafficKinds, args.numGames, firstGameID, world, stats)
+		} else {
+			go fc.sendRoutine(args.rate*float64(gateWeight)/totalWeight/float64(args.numGates), mix, gateTrafficKinds, args.numGames, firstGameID, world, stats)
+		}
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if args.duration > 0 {
+		timeout = time.After(args.duration)
+	}
+
+	ticker := time.NewTicker(args.interval)
+	defer ticker.Stop()
+	lastReportTime := time.Now()
+loop:
+	for {
+		select {
+		case now := <-ticker.C:
+			gwlog.Infof("%s", stats.report(now.Sub(lastReportTime)))
+			lastReportTime = now
+		case <-timeout:
+			break loop
+		case sig := <-sigChan:
+			gwlog.Infof("Received signal %s, quit ...", sig)
+			break loop
+		}
+	}
+
+	gwlog.Infof("%s", stats.report(time.Since(lastReportTime)))
+	for _, fc := range clients {
+		fc.Close()
+	}
+}
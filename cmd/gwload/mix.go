@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// traffic kinds generated by gwload
+const (
+	trafficRPC       = "rpc"        // entity RPC between games
+	trafficClientRPC = "client_rpc" // entity RPC from clients through gates
+	trafficSync      = "sync"       // position & yaw sync from clients through gates
+	trafficMigrate   = "migrate"    // entity migration between games
+)
+
+var (
+	gameTrafficKinds = []string{trafficRPC, trafficMigrate}
+	gateTrafficKinds = []string{trafficClientRPC, trafficSync}
+)
+
+// trafficMix is the weights of traffic kinds
+type trafficMix map[string]int
+
+// parseTrafficMix parses traffic mix in format like "rpc=60,client_rpc=10,sync=25,migrate=5"
+func parseTrafficMix(s string) (trafficMix, error) {
+	mix := trafficMix{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid traffic mix item: %s", item)
+		}
+
+		kind := strings.TrimSpace(kv[0])
+		if kind != trafficRPC && kind != trafficClientRPC && kind != trafficSync && kind != trafficMigrate {
+			return nil, errors.Errorf("unknown traffic kind: %s", kind)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || weight < 0 {
+			return nil, errors.Errorf("invalid weight of %s: %s", kind, kv[1])
+		}
+		mix[kind] = weight
+	}
+
+	if mix.totalWeight(gameTrafficKinds)+mix.totalWeight(gateTrafficKinds) == 0 {
+		return nil, errors.Errorf("traffic mix is empty")
+	}
+	return mix, nil
+}
+
+func (mix trafficMix) totalWeight(kinds []string) (total int) {
+	for _, kind := range kinds {
+		total += mix[kind]
+	}
+	return
+}
+
+// choose chooses a traffic kind from kinds randomly by weights
+func (mix trafficMix) choose(rnd *rand.Rand, kinds []string) string {
+	total := mix.totalWeight(kinds)
+	if total == 0 {
+		return ""
+	}
+
+	n := rnd.Intn(total)
+	for _, kind := range kinds {
+		if n < mix[kind] {
+			return kind
+		}
+		n -= mix[kind]
+	}
+	return ""
+}
+
+func (mix trafficMix) String() string {
+	kinds := make([]string, 0, len(mix))
+	for kind := range mix {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	items := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		items = append(items, kind+"="+strconv.Itoa(mix[kind]))
+	}
+	return strings.Join(items, ",")
+}
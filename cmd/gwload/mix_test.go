@@ -0,0 +1,29 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseTrafficMix(t *testing.T) {
+	mix, err := parseTrafficMix("rpc=60, sync=40,migrate=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mix[trafficRPC] != 60 || mix[trafficSync] != 40 || mix.totalWeight(gameTrafficKinds) != 60 {
+		t.Fatalf("wrong traffic mix: %s", mix)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		if kind := mix.choose(rnd, gameTrafficKinds); kind != trafficRPC {
+			t.Fatalf("should choose %s, but chose %s", trafficRPC, kind)
+		}
+	}
+
+	for _, s := range []string{"", "rpc", "rpc=x", "rpc=-1", "unknown=1", "migrate=0"} {
+		if _, err := parseTrafficMix(s); err == nil {
+			t.Errorf("traffic mix %q should be invalid", s)
+		}
+	}
+}
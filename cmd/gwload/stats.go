@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trafficStats is the statistics of one traffic kind
+type trafficStats struct {
+	sent, received   int64
+	totalLatency     time.Duration
+	maxLatency       time.Duration
+	numLatencySample int64
+}
+
+type loadStats struct {
+	sync.Mutex
+	kinds map[string]*trafficStats
+}
+
+func newLoadStats() *loadStats {
+	return &loadStats{kinds: map[string]*trafficStats{}}
+}
+
+func (ls *loadStats) get(kind string) *trafficStats {
+	ts := ls.kinds[kind]
+	if ts == nil {
+		ts = &trafficStats{}
+		ls.kinds[kind] = ts
+	}
+	return ts
+}
+
+func (ls *loadStats) addSent(kind string) {
+	ls.Lock()
+	ls.get(kind).sent += 1
+	ls.Unlock()
+}
+
+// addReceived records received messages, latency is ignored if it is negative
+func (ls *loadStats) addReceived(kind string, count int64, latency time.Duration) {
+	ls.Lock()
+	ts := ls.get(kind)
+	ts.received += count
+	if latency >= 0 {
+		ts.totalLatency += latency
+		ts.numLatencySample += 1
+		if latency > ts.maxLatency {
+			ts.maxLatency = latency
+		}
+	}
+	ls.Unlock()
+}
+
+// report returns the statistics since last report and resets them
+func (ls *loadStats) report(elapsed time.Duration) string {
+	ls.Lock()
+	kinds := ls.kinds
+	ls.kinds = map[string]*trafficStats{}
+	ls.Unlock()
+
+	var items []string
+	for _, kind := range []string{trafficRPC, trafficClientRPC, trafficSync, trafficMigrate} {
+		ts := kinds[kind]
+		if ts == nil {
+			continue
+		}
+
+		item := fmt.Sprintf("%s: sent %.0f/s recv %.0f/s", kind, float64(ts.sent)/elapsed.Seconds(), float64(ts.received)/elapsed.Seconds())
+		if ts.numLatencySample > 0 {
+			item += fmt.Sprintf(" latency avg %v max %v", ts.totalLatency/time.Duration(ts.numLatencySample), ts.maxLatency)
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return "no traffic"
+	}
+	return strings.Join(items, " | ")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// loadWorld tracks which fake game owns each fake entity
+type loadWorld struct {
+	sync.RWMutex
+	entityIDs []common.EntityID
+	owners    map[common.EntityID]uint16
+	migrating map[common.EntityID]time.Time
+}
+
+func newLoadWorld() *loadWorld {
+	return &loadWorld{
+		owners:    map[common.EntityID]uint16{},
+		migrating: map[common.EntityID]time.Time{},
+	}
+}
+
+// createEntities creates fake entities owned by the game
+func (w *loadWorld) createEntities(gameid uint16, n int) []common.EntityID {
+	eids := make([]common.EntityID, n)
+	w.Lock()
+	for i := range eids {
+		eids[i] = common.GenEntityID()
+		w.entityIDs = append(w.entityIDs, eids[i])
+		w.owners[eids[i]] = gameid
+	}
+	w.Unlock()
+	return eids
+}
+
+func (w *loadWorld) randomEntity(rnd *rand.Rand) common.EntityID {
+	w.RLock()
+	defer w.RUnlock()
+	if len(w.entityIDs) == 0 {
+		return ""
+	}
+	return w.entityIDs[rnd.Intn(len(w.entityIDs))]
+}
+
+// startMigrate picks a random entity owned by the game and marks it as migrating
+func (w *loadWorld) startMigrate(rnd *rand.Rand, gameid uint16) common.EntityID {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.entityIDs) == 0 {
+		return ""
+	}
+
+	for try := 0; try < 10; try++ {
+		eid := w.entityIDs[rnd.Intn(len(w.entityIDs))]
+		if _, ok := w.migrating[eid]; !ok && w.owners[eid] == gameid {
+			w.migrating[eid] = time.Now()
+			return eid
+		}
+	}
+	return ""
+}
+
+// finishMigrate sets the owner of the migrated entity and returns the migration latency
+func (w *loadWorld) finishMigrate(eid common.EntityID, gameid uint16) time.Duration {
+	w.Lock()
+	defer w.Unlock()
+	w.owners[eid] = gameid
+	startTime, ok := w.migrating[eid]
+	if !ok {
+		return -1
+	}
+	delete(w.migrating, eid)
+	return time.Since(startTime)
+}
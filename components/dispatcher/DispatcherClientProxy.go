@@ -5,6 +5,8 @@ import (
 	"net"
 
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwioutil"
@@ -16,9 +18,13 @@ import (
 
 type dispatcherClientProxy struct {
 	*proto.GoWorldConnection
-	owner  *DispatcherService
-	gameid uint16
-	gateid uint16
+	owner        *DispatcherService
+	gameid       uint16
+	gateid       uint16
+	isStandby    bool // the standby dispatcher mirroring this dispatcher
+	isActivePeer bool // the active dispatcher mirrored by this dispatcher
+
+	workerMessages sync.WaitGroup // messages of the connection queued to workers but not handled yet
 }
 
 func newDispatcherClientProxy(owner *DispatcherService, conn net.Conn) *dispatcherClientProxy {
@@ -66,8 +72,14 @@ func (dcp *dispatcherClientProxy) serve() {
 		//	gwlog.Debugf("%s.RecvPacket: msgtype=%v, payload=%v", dcp, msgtype, pkt.Payload())
 		//}
 
+		if msgtype == proto.MT_CLOCK_SYNC { // answered in the recv routine for accurate round trip time
+			dcp.SendClockSyncAck(int64(pkt.ReadUint64()), time.Now().UnixNano())
+			pkt.Release()
+			continue
+		}
+
 		// pass the packet to the dispatcher service
-		dcp.owner.messageQueue <- dispatcherMessage{dcp, proto.Message{msgtype, pkt}}
+		dcp.owner.postMessage(dcp, msgtype, pkt)
 	}
 }
 
@@ -76,6 +88,8 @@ func (dcp *dispatcherClientProxy) String() string {
 		return fmt.Sprintf("dispatcherClientProxy<game%d|%s>", dcp.gameid, dcp.RemoteAddr())
 	} else if dcp.gateid > 0 {
 		return fmt.Sprintf("dispatcherClientProxy<gate%d|%s>", dcp.gateid, dcp.RemoteAddr())
+	} else if dcp.isStandby || dcp.isActivePeer {
+		return fmt.Sprintf("dispatcherClientProxy<dispatcher%d|%s>", dispid, dcp.RemoteAddr())
 	} else {
 		return fmt.Sprintf("dispatcherClientProxy<%s>", dcp.RemoteAddr())
 	}
@@ -13,6 +13,8 @@ import (
 
 	"container/heap"
 
+	"sync"
+
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
@@ -20,13 +22,17 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 type entityDispatchInfo struct {
 	gameid             uint16
+	standbyGameID      uint16 // game to take over the service entity when its game is down, 0 if no standby
+	typeName           string // type name of entity if entity is loaded from storage
 	blockUntilTime     time.Time
 	pendingPacketQueue []*netutil.Packet
 }
@@ -80,13 +86,16 @@ func (info *entityDispatchInfo) unblock() {
 }
 
 type gameDispatchInfo struct {
+	lock               sync.Mutex // protects blocking states and pending packets when dispatched by workers
 	gameid             uint16
 	clientProxy        *dispatcherClientProxy
 	isBlocked          bool
 	blockUntilTime     time.Time // game can be blocked
 	pendingPacketQueue []*netutil.Packet
 	isBanBootEntity    bool
+	isDraining         bool // draining games are not chosen for new entities
 	lbcheapentry       *lbcheapentry
+	reconcileSuspects  common.EntityIDSet // entities found inconsistent in last reconciliation
 }
 
 func (gdi *gameDispatchInfo) setClientProxy(clientProxy *dispatcherClientProxy) {
@@ -117,10 +126,17 @@ func (gdi *gameDispatchInfo) isConnected() bool {
 }
 
 func (gdi *gameDispatchInfo) dispatchPacket(pkt *netutil.Packet) error {
+	gdi.lock.Lock()
+	defer gdi.lock.Unlock()
+
 	if gdi.checkBlocked() && gdi.clientProxy == nil {
 		// blocked from true -> false, and game is already disconnected before
-		// in this case, the game should be cleaned up
-		dispatcherService.handleGameDown(gdi)
+		// in this case, the game should be cleaned up by the main routine
+		post.Post(func() {
+			if gdi.clientProxy == nil {
+				dispatcherService.handleGameDown(gdi)
+			}
+		})
 	}
 
 	if !gdi.isBlocked && gdi.clientProxy != nil {
@@ -171,6 +187,7 @@ func (gdi *gameDispatchInfo) clearPendingPackets() {
 type dispatcherMessage struct {
 	dcp *dispatcherClientProxy
 	proto.Message
+	done chan struct{} // closed when the message is handled by the main routine, nil if not waited
 }
 
 // DispatcherService implements the dispatcher service
@@ -181,17 +198,34 @@ type DispatcherService struct {
 	bootGames             []uint16
 	gates                 map[uint16]*dispatcherClientProxy
 	messageQueue          chan dispatcherMessage
+	lock                  sync.RWMutex        // locked for reading by workers forwarding packets, and for writing otherwise
+	workers               []*dispatcherWorker // workers handling messages of entities and gates, nil if not configured
 	entityDispatchInfos   map[common.EntityID]*entityDispatchInfo
 	kvregRegisterMap      map[string]string
+	cronJobLastRuns       map[string]int64           // last granted run time of cron jobs
 	entitySyncInfosToGame map[uint16]*netutil.Packet // cache entity sync infos to gates
+	inputSyncInfosToGame  map[uint16]*netutil.Packet // cache entity sync infos with input sequence numbers to games
 	ticker                <-chan time.Time
-	lbcheap               lbcheap // heap for game load balancing
-	chooseGameIdx         int     // choose game in a round robin way
-	isDeploymentReady     bool    // whether or not the deployment is ready
-}
-
-func newDispatcherService(dispid uint16) *DispatcherService {
-	cfg := config.GetDispatcher(dispid)
+	lbcheap               lbcheap       // heap for game load balancing
+	chooseGameIdx         int           // choose game in a round robin way
+	isDeploymentReady     bool          // whether or not the deployment is ready
+	creationQueue         creationQueue // entity creations waiting for games to be not overloaded
+	lastAutoMigrateTime   time.Time
+
+	// hot-standby states
+	isStandby            bool                   // whether or not started as the hot-standby process
+	isActive             bool                   // whether or not serving games and gates
+	standby              *dispatcherClientProxy // the standby mirroring this dispatcher
+	standbyDirtyEntities common.EntityIDSet     // entities changed since last sync to standby
+	standbyDirtyKvregs   common.StringSet       // kvregs changed since last sync to standby
+	lastStandbySyncTime  time.Time
+	mirror               *dispatcherClientProxy // connection to the active dispatcher if this dispatcher is passive
+	mirrorGameIDs        []uint16               // games known by the active dispatcher
+	lastMirrorSyncTime   time.Time
+}
+
+func newDispatcherService(dispid uint16, isStandby bool) *DispatcherService {
+	cfg := conf.GetDispatcher(dispid)
 	ds := &DispatcherService{
 		dispid:                dispid,
 		config:                cfg,
@@ -200,97 +234,176 @@ func newDispatcherService(dispid uint16) *DispatcherService {
 		gates:                 map[uint16]*dispatcherClientProxy{},
 		entityDispatchInfos:   map[common.EntityID]*entityDispatchInfo{},
 		kvregRegisterMap:      map[string]string{},
+		cronJobLastRuns:       map[string]int64{},
 		entitySyncInfosToGame: map[uint16]*netutil.Packet{},
+		inputSyncInfosToGame:  map[uint16]*netutil.Packet{},
 		ticker:                time.Tick(consts.DISPATCHER_SERVICE_TICK_INTERVAL),
 		lbcheap:               nil,
 		isDeploymentReady:     false,
+		isStandby:             isStandby,
+	}
+
+	if cfg.Workers > 0 {
+		ds.workers = newDispatcherWorkers(cfg.Workers, consts.DISPATCHER_SERVICE_PACKET_QUEUE_SIZE)
 	}
 
 	ds.recalcBootGames()
+	metrics.NewGaugeFunc("goworld_dispatcher_queue_length", "Packets waiting to be handled by dispatcher", func() float64 {
+		n := len(ds.messageQueue)
+		for _, w := range ds.workers {
+			n += len(w.messageQueue)
+		}
+		return float64(n)
+	})
 
 	return ds
 }
 
+// onConfigChanged hot-applies changes of dispatcher config which are safe to apply without restarting
+func (service *DispatcherService) onConfigChanged(oldCfg, newCfg *config.DispatcherConfig) {
+	service.config = newCfg
+	if newCfg.LogLevel != oldCfg.LogLevel && logLevel == "" { // log level specified by -log is not changed
+		gwlog.Infof("%s: log level changed to %s", service, newCfg.LogLevel)
+		gwlog.SetLevels(newCfg.LogLevel)
+	}
+}
+
 func (service *DispatcherService) messageLoop() {
 	for {
 		select {
 		case msg := <-service.messageQueue:
-			dcp := msg.dcp
-			msgtype := msg.MsgType
-			pkt := msg.Packet
-			if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
-				service.handleDoSomethingOnSpecifiedClient(dcp, pkt)
-			} else {
-				switch msgtype {
-				case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
-					service.handleSyncPositionYawFromClient(dcp, pkt)
-				case proto.MT_SYNC_POSITION_YAW_ON_CLIENTS:
-					service.handleSyncPositionYawOnClients(dcp, pkt)
-				case proto.MT_CALL_ENTITY_METHOD:
-					service.handleCallEntityMethod(dcp, pkt)
-				case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
-					service.handleCallEntityMethodFromClient(dcp, pkt)
-				case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE:
-					service.handleQuerySpaceGameIDForMigrate(dcp, pkt)
-				case proto.MT_MIGRATE_REQUEST:
-					service.handleMigrateRequest(dcp, pkt)
-				case proto.MT_REAL_MIGRATE:
-					service.handleRealMigrate(dcp, pkt)
-				case proto.MT_CALL_FILTERED_CLIENTS:
-					service.handleCallFilteredClientProxies(dcp, pkt)
-				case proto.MT_NOTIFY_CLIENT_CONNECTED:
-					service.handleNotifyClientConnected(dcp, pkt)
-				case proto.MT_NOTIFY_CLIENT_DISCONNECTED:
-					service.handleNotifyClientDisconnected(dcp, pkt)
-				case proto.MT_LOAD_ENTITY_SOMEWHERE:
-					service.handleLoadEntitySomewhere(dcp, pkt)
-				case proto.MT_NOTIFY_CREATE_ENTITY:
-					eid := pkt.ReadEntityID()
-					service.handleNotifyCreateEntity(dcp, pkt, eid)
-				case proto.MT_NOTIFY_DESTROY_ENTITY:
-					eid := pkt.ReadEntityID()
-					service.handleNotifyDestroyEntity(dcp, pkt, eid)
-				case proto.MT_CREATE_ENTITY_SOMEWHERE:
-					service.handleCreateEntitySomewhere(dcp, pkt)
-				case proto.MT_GAME_LBC_INFO:
-					service.handleGameLBCInfo(dcp, pkt)
-				case proto.MT_CALL_NIL_SPACES:
-					service.handleCallNilSpaces(dcp, pkt)
-				case proto.MT_CANCEL_MIGRATE:
-					service.handleCancelMigrate(dcp, pkt)
-				case proto.MT_KVREG_REGISTER:
-					service.handleKvregRegister(dcp, pkt)
-				case proto.MT_SET_GAME_ID:
-					// this is a game server
-					service.handleSetGameID(dcp, pkt)
-				case proto.MT_SET_GATE_ID:
-					// this is a gate
-					service.handleSetGateID(dcp, pkt)
-				case proto.MT_START_FREEZE_GAME:
-					// freeze the game
-					service.handleStartFreezeGame(dcp, pkt)
-				default:
-					gwlog.TraceError("unknown msgtype %d from %s", msgtype, dcp)
-				}
-			}
-
-			pkt.Release()
-			break
+			service.handleMessageLocked(msg)
 		case <-service.ticker:
-			post.Tick()
-			service.sendEntitySyncInfosToGames()
-			break
+			service.tick()
 		}
 	}
 }
 
+// handleMessageLocked handles the message in the main routine with the write lock
+func (service *DispatcherService) handleMessageLocked(msg dispatcherMessage) {
+	service.lock.Lock()
+	defer func() {
+		service.lock.Unlock()
+		if msg.done != nil {
+			close(msg.done)
+		}
+	}()
+	service.handleMessage(nil, msg.dcp, msg.MsgType, msg.Packet)
+}
+
+// handleMessage handles the message in the main routine if w is nil, or in the worker
+func (service *DispatcherService) handleMessage(w *dispatcherWorker, dcp *dispatcherClientProxy, msgtype proto.MsgType, pkt *netutil.Packet) {
+	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		service.handleDoSomethingOnSpecifiedClient(dcp, pkt)
+	} else {
+		switch msgtype {
+		case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
+			service.handleSyncPositionYawFromClient(w, dcp, pkt)
+		case proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT:
+			service.handleSyncPositionYawWithSeqFromClient(w, dcp, pkt)
+		case proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.MT_SYNC_INPUT_ACK_ON_CLIENTS:
+			service.handleSyncPositionYawOnClients(dcp, pkt)
+		case proto.MT_CALL_ENTITY_METHOD, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ:
+			service.handleCallEntityMethod(dcp, pkt)
+		case proto.MT_CALL_ENTITY_METHOD_WITH_RESULT:
+			service.handleCallEntityMethodWithResult(dcp, pkt)
+		case proto.MT_CALL_ENTITY_METHOD_RESULT:
+			service.handleCallEntityMethodResult(dcp, pkt)
+		case proto.MT_ACK_ENTITY_RPCS:
+			service.handleAckEntityRPCs(dcp, pkt)
+		case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+			proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT:
+			service.handleCallEntityMethodFromClient(dcp, pkt)
+		case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE:
+			service.handleQuerySpaceGameIDForMigrate(dcp, pkt)
+		case proto.MT_MIGRATE_REQUEST:
+			service.handleMigrateRequest(dcp, pkt)
+		case proto.MT_REAL_MIGRATE:
+			service.handleRealMigrate(dcp, pkt)
+		case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY:
+			service.handleDoSomethingOnSpecifiedClient(dcp, pkt)
+		case proto.MT_CALL_FILTERED_CLIENTS:
+			service.handleCallFilteredClientProxies(w, dcp, pkt)
+		case proto.MT_NOTIFY_CLIENT_CONNECTED:
+			service.handleNotifyClientConnected(dcp, pkt)
+		case proto.MT_NOTIFY_CLIENT_DISCONNECTED:
+			service.handleNotifyClientDisconnected(dcp, pkt)
+		case proto.MT_NOTIFY_CLIENT_RESUMED, proto.MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION:
+			service.handleNotifyClientResumed(dcp, pkt)
+		case proto.MT_LOAD_ENTITY_SOMEWHERE:
+			service.handleLoadEntitySomewhere(dcp, pkt)
+		case proto.MT_NOTIFY_CREATE_ENTITY:
+			eid := pkt.ReadEntityID()
+			service.handleNotifyCreateEntity(dcp, pkt, eid)
+		case proto.MT_NOTIFY_DESTROY_ENTITY:
+			eid := pkt.ReadEntityID()
+			service.handleNotifyDestroyEntity(dcp, pkt, eid)
+		case proto.MT_CREATE_ENTITY_SOMEWHERE:
+			service.handleCreateEntitySomewhere(dcp, pkt)
+		case proto.MT_CREATE_ENTITY_ANYWHERE_QUEUED:
+			service.handleCreateEntityAnywhereQueued(dcp, pkt)
+		case proto.MT_GAME_LBC_INFO:
+			service.handleGameLBCInfo(dcp, pkt)
+		case proto.MT_CALL_NIL_SPACES:
+			service.handleCallNilSpaces(dcp, pkt)
+		case proto.MT_PUBLISH_CHANNEL:
+			service.handlePublishChannel(dcp, pkt)
+		case proto.MT_CLAIM_CRON_JOB:
+			service.handleClaimCronJob(dcp, pkt)
+		case proto.MT_CANCEL_MIGRATE:
+			service.handleCancelMigrate(dcp, pkt)
+		case proto.MT_KVREG_REGISTER:
+			service.handleKvregRegister(dcp, pkt)
+		case proto.MT_SET_GAME_ID:
+			// this is a game server
+			service.handleSetGameID(dcp, pkt)
+		case proto.MT_SET_GATE_ID:
+			// this is a gate
+			service.handleSetGateID(dcp, pkt)
+		case proto.MT_START_FREEZE_GAME:
+			// freeze the game
+			service.handleStartFreezeGame(dcp, pkt)
+		case proto.MT_SET_GAME_DRAINING:
+			service.handleSetGameDraining(dcp, pkt)
+		case proto.MT_RECONCILE_ENTITIES:
+			service.handleReconcileEntities(dcp, pkt)
+		case proto.MT_SYNC_SERVICE_SNAPSHOT:
+			service.handleSyncServiceSnapshot(dcp, pkt)
+		case proto.MT_SET_STANDBY:
+			service.handleSetStandby(dcp, pkt)
+		case proto.MT_SYNC_ROUTING_TO_STANDBY:
+			service.handleSyncRoutingToStandby(dcp, pkt)
+		default:
+			gwlog.TraceError("unknown msgtype %d from %s", msgtype, dcp)
+		}
+	}
+
+	pkt.Release()
+}
+
+func (service *DispatcherService) tick() {
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	post.Tick()
+	service.sendEntitySyncInfosToGames()
+	service.processCreationQueue()
+	service.checkAutoMigrate()
+	service.syncRoutingToStandby()
+	service.checkMirror()
+}
+
 func (service *DispatcherService) terminate() {
+	binutil.NotifyStopping()
 	gwlog.Infof("Dispatcher terminated gracefully.")
 	os.Exit(0)
 }
 
 func (service *DispatcherService) delEntityDispatchInfo(entityID common.EntityID) {
 	delete(service.entityDispatchInfos, entityID)
+	if service.standby != nil {
+		service.standbyDirtyEntities.Add(entityID)
+	}
 }
 
 func (service *DispatcherService) setEntityDispatcherInfoForWrite(entityID common.EntityID) (info *entityDispatchInfo) {
@@ -300,6 +413,9 @@ func (service *DispatcherService) setEntityDispatcherInfoForWrite(entityID commo
 		info = &entityDispatchInfo{}
 		service.entityDispatchInfos[entityID] = info
 	}
+	if service.standby != nil {
+		service.standbyDirtyEntities.Add(entityID) // gameid of the entity is likely to be changed
+	}
 
 	return
 }
@@ -310,15 +426,34 @@ func (service *DispatcherService) String() string {
 
 func (service *DispatcherService) run() {
 	binutil.PrintSupervisorTag(consts.DISPATCHER_STARTED_TAG)
+	binutil.NotifyReady()
+	isPaired := service.peerAddr() != ""
+	service.isActive = !isPaired
 	go gwutils.RepeatUntilPanicless(service.messageLoop)
-	netutil.ServeTCPForever(service.config.ListenAddr, service)
+	for _, w := range service.workers {
+		go w.loop(service)
+	}
+	if len(service.workers) > 0 {
+		gwlog.Infof("%s: messages of entities and gates are handled by %d workers", service, len(service.workers))
+	}
+	if isPaired {
+		service.waitUntilActive() // wait until the peer dispatcher is down
+	}
+	netutil.ServeTCPForever(service.listenAddr(), service)
 }
 
 // ServeTCPConnection handles dispatcher client connections to dispatcher
 func (service *DispatcherService) ServeTCPConnection(conn net.Conn) {
-	tcpConn := conn.(*net.TCPConn)
-	tcpConn.SetReadBuffer(consts.DISPATCHER_CLIENT_PROXY_READ_BUFFER_SIZE)
-	tcpConn.SetWriteBuffer(consts.DISPATCHER_CLIENT_PROXY_WRITE_BUFFER_SIZE)
+	cfg := conf.GetDispatcher(service.dispid)
+	opts := netutil.TCPOptions{
+		NoDelay:    cfg.TCPNoDelay,
+		SendBuffer: cfg.TCPSendBuffer,
+		RecvBuffer: cfg.TCPRecvBuffer,
+		KeepAlive:  time.Second * time.Duration(cfg.TCPKeepAlive),
+	}
+	if err := opts.Apply(conn.(*net.TCPConn)); err != nil {
+		gwlog.Warnf("%s: set TCP options of %s failed: %s", service, conn.RemoteAddr(), err)
+	}
 
 	client := newDispatcherClientProxy(service, conn)
 	client.serve()
@@ -351,7 +486,7 @@ func (service *DispatcherService) handleSetGameID(dcp *dispatcherClientProxy, pk
 	gdi := service.games[gameid]
 	if gdi == nil {
 		// new game connected, create dispatch info for the game
-		lbcheapentry := &lbcheapentry{gameid, len(service.lbcheap), 0, 0}
+		lbcheapentry := &lbcheapentry{gameid, len(service.lbcheap), 0, 0, 0, 0, false}
 		gdi = &gameDispatchInfo{gameid: gameid, isBanBootEntity: isBanBootEntity, lbcheapentry: lbcheapentry}
 		service.games[gameid] = gdi
 		heap.Push(&service.lbcheap, lbcheapentry)
@@ -369,7 +504,12 @@ func (service *DispatcherService) handleSetGameID(dcp *dispatcherClientProxy, pk
 	gdi.isBanBootEntity = isBanBootEntity
 	gdi.setClientProxy(dcp) // should be nil, unless reconnect
 	gdi.unblock()           // unlock game dispatch info if new game is connected
-	if oldIsBanBootEntity != isBanBootEntity {
+	wasDraining := gdi.isDraining
+	if wasDraining {
+		// the drained game is restarted
+		service.setGameDraining(gdi, false)
+	}
+	if oldIsBanBootEntity != isBanBootEntity || wasDraining {
 		service.recalcBootGames() // recalc if necessary
 	}
 
@@ -449,7 +589,7 @@ func (service *DispatcherService) checkDeploymentReady() {
 		return
 	}
 
-	deployCfg := config.GetDeployment()
+	deployCfg := conf.GetDeployment()
 	numGates := len(service.gates)
 	if numGates < deployCfg.DesiredGates {
 		gwlog.Infof("%s check deployment ready: %d/%d gates", service, numGates, deployCfg.DesiredGates)
@@ -567,6 +707,10 @@ func (service *DispatcherService) handleDispatcherClientDisconnect(dcp *dispatch
 		service.handleGateDisconnected(dcp)
 	} else if dcp.gameid > 0 {
 		service.handleGameDisconnected(dcp)
+	} else if dcp.isStandby {
+		service.handleStandbyDisconnected(dcp)
+	} else if dcp.isActivePeer {
+		service.handleActivePeerDisconnected(dcp)
 	}
 }
 
@@ -628,6 +772,9 @@ func (service *DispatcherService) cleanupEntitiesOfGame(gameid uint16) {
 	cleanEids := common.EntityIDSet{} // get all clean eids
 	for eid, dispatchInfo := range service.entityDispatchInfos {
 		if dispatchInfo.gameid == gameid {
+			if service.redirectToStandbyGame(eid, dispatchInfo) {
+				continue
+			}
 			cleanEids.Add(eid)
 		}
 	}
@@ -676,6 +823,16 @@ func (service *DispatcherService) handleNotifyClientDisconnected(dcp *dispatcher
 	}
 }
 
+func (service *DispatcherService) handleNotifyClientResumed(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	ownerEntityID := pkt.ReadEntityID() // owner entity's ID for the client
+	edi := service.entityDispatchInfos[ownerEntityID]
+	if edi != nil {
+		edi.dispatchPacket(pkt)
+	} else {
+		gwlog.Warnf("%s: client %s is resumed, but owner entity %s not found", service, dcp, ownerEntityID)
+	}
+}
+
 func (service *DispatcherService) handleLoadEntitySomewhere(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	//typeName := pkt.ReadVarStr()
 	//eid := pkt.ReadEntityID()
@@ -684,8 +841,10 @@ func (service *DispatcherService) handleLoadEntitySomewhere(dcp *dispatcherClien
 	}
 	gameid := pkt.ReadUint16() // the target game to create entity or 0 for anywhere
 	eid := pkt.ReadEntityID()  // field 1
+	typeName := pkt.ReadVarStr()
 
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(eid)
+	entityDispatchInfo.typeName = typeName
 
 	if entityDispatchInfo.gameid == 0 { // entity not loaded, try load now
 		var gdi *gameDispatchInfo
@@ -740,6 +899,9 @@ func (service *DispatcherService) handleKvregRegister(dcp *dispatcherClientProxy
 
 	if force || curinfo == "" {
 		service.kvregRegisterMap[srvid] = srvinfo
+		if service.standby != nil {
+			service.standbyDirtyKvregs.Add(srvid)
+		}
 		service.broadcastToGames(pkt)
 		gwlog.Infof("%s: kvreg register %s = %s, force %v, register ok", service, srvid, srvinfo, force)
 	} else {
@@ -747,6 +909,37 @@ func (service *DispatcherService) handleKvregRegister(dcp *dispatcherClientProxy
 	}
 }
 
+// handleSyncServiceSnapshot forwards the snapshot of the service entity to the standby game, and remembers the standby
+// game for redirecting calls when the game of the service entity is down
+func (service *DispatcherService) handleSyncServiceSnapshot(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	standbyGameID := pkt.ReadUint16()
+	_ = pkt.ReadUint16() // gameid
+	eid := pkt.ReadEntityID()
+
+	edi := service.entityDispatchInfos[eid]
+	if edi == nil || edi.gameid != dcp.gameid {
+		gwlog.Warnf("%s: service snapshot of %s is ignored, because the entity is not on %s", service, eid, dcp)
+		return
+	}
+
+	edi.standbyGameID = standbyGameID
+	service.dispatchPacketToGame(standbyGameID, pkt)
+}
+
+// redirectToStandbyGame redirects calls of the service entity to its standby game, which will recreate the entity
+func (service *DispatcherService) redirectToStandbyGame(eid common.EntityID, edi *entityDispatchInfo) bool {
+	standbyGame := service.games[edi.standbyGameID]
+	if standbyGame == nil || !standbyGame.isConnected() {
+		return false
+	}
+
+	gwlog.Warnf("%s: game%d is down, redirecting service entity %s to standby game%d", service, edi.gameid, eid, edi.standbyGameID)
+	edi = service.setEntityDispatcherInfoForWrite(eid)
+	edi.gameid, edi.standbyGameID = edi.standbyGameID, 0
+	edi.blockRPC(consts.DISPATCHER_LOAD_TIMEOUT) // calls are blocked until the entity is created on the standby game
+	return true
+}
+
 //func (service *DispatcherService) handleServiceDown(gameid uint16, serviceName string, eid common.EntityID) {
 //	gwlog.Warnf("%s: service %s: entity %s is down!", service, serviceName, eid)
 //	pkt := netutil.NewPacket()
@@ -757,8 +950,23 @@ func (service *DispatcherService) handleKvregRegister(dcp *dispatcherClientProxy
 //	pkt.Release()
 //}
 
+// traceRouting starts the span of routing the packet to the entity if the packet is traced, and propagates the span
+// to the game of the entity
+func traceRouting(pkt *netutil.Packet, entityID common.EntityID) *tracing.Span {
+	parent := pkt.TraceContext()
+	if !parent.IsValid() {
+		return nil
+	}
+
+	span := tracing.StartSpan("dispatcher: route call", tracing.SpanKindServer, parent)
+	span.SetAttr("goworld.entity_id", entityID)
+	proto.InjectTraceContext(pkt, span.Context())
+	return span
+}
+
 func (service *DispatcherService) handleCallEntityMethod(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	entityID := pkt.ReadEntityID()
+	defer traceRouting(pkt, entityID).End()
 
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleCallEntityMethod: dcp=%s, entityID=%s", service, dcp, entityID)
@@ -772,22 +980,95 @@ func (service *DispatcherService) handleCallEntityMethod(dcp *dispatcherClientPr
 	}
 }
 
+// handleCallEntityMethodWithResult dispatches the call to the game of the entity, or sends the error back to the calling
+// game if the call can not be dispatched
+func (service *DispatcherService) handleCallEntityMethodWithResult(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	entityID := pkt.ReadEntityID()
+	defer traceRouting(pkt, entityID).End()
+	srcGameID := pkt.ReadUint16()
+	callID := pkt.ReadUint32()
+
+	var err error
+	entityDispatchInfo := service.entityDispatchInfos[entityID]
+	if entityDispatchInfo != nil {
+		err = entityDispatchInfo.dispatchPacket(pkt)
+	} else {
+		err = errors.Errorf("dispatch info of entity %s is not found", entityID)
+	}
+
+	if err != nil {
+		gwlog.Warnf("%s: entity %s is called with result by game%d, but failed: %s", service, entityID, srcGameID, err)
+		resultPkt := proto.MakeCallEntityMethodResultPacket(srcGameID, callID, proto.RPC_ERROR_ENTITY_NOT_FOUND, err.Error(), nil)
+		service.dispatchPacketToGame(srcGameID, resultPkt)
+		resultPkt.Release()
+	}
+}
+
+func (service *DispatcherService) handleCallEntityMethodResult(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	gameid := pkt.ReadUint16()
+	if err := service.dispatchPacketToGame(gameid, pkt); err != nil {
+		gwlog.Warnf("%s: result of entity RPC is dropped: %s", service, err)
+	}
+}
+
+func (service *DispatcherService) handleAckEntityRPCs(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	gameid := pkt.ReadUint16()
+	if err := service.dispatchPacketToGame(gameid, pkt); err != nil {
+		gwlog.Warnf("%s: acks of entity RPCs are dropped: %s", service, err)
+	}
+}
+
 func (service *DispatcherService) handleCallNilSpaces(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	// send the packet to all games
 	exceptGameID := pkt.ReadUint16()
 	service.broadcastToGamesExcept(pkt, exceptGameID)
 }
 
+// handlePublishChannel sends the message to all games, subscribers of the channel are called by their games
+func (service *DispatcherService) handlePublishChannel(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	service.broadcastToGames(pkt)
+}
+
+// handleClaimCronJob grants the run of the cron job to the first game claiming it by sending the claim back
+func (service *DispatcherService) handleClaimCronJob(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	name := pkt.ReadVarStr()
+	runTime := int64(pkt.ReadUint64())
+
+	if runTime <= service.cronJobLastRuns[name] {
+		return // the run is already granted to another game
+	}
+	service.cronJobLastRuns[name] = runTime
+	gwlog.Infof("%s: cron job %s at %d is granted to %s", service, name, runTime, dcp)
+	dcp.SendPacket(pkt)
+}
+
 func (service *DispatcherService) handleSyncPositionYawOnClients(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	gateid := pkt.ReadUint16()
 	service.dispatcherClientOfGate(gateid).SendPacket(pkt)
 }
 
-func (service *DispatcherService) handleSyncPositionYawFromClient(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+func (service *DispatcherService) handleSyncPositionYawFromClient(w *dispatcherWorker, dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	// This sync packet contains position-yaw of multiple entities from a gate. Cache the packet to be send before flush?
+	syncInfosToGame := service.entitySyncInfosToGame
+	if w != nil {
+		syncInfosToGame = w.entitySyncInfosToGame
+	}
+	service.cacheSyncInfosToGames(pkt, proto.MT_SYNC_POSITION_YAW_FROM_CLIENT, proto.SYNC_INFO_SIZE_PER_ENTITY, syncInfosToGame)
+}
+
+func (service *DispatcherService) handleSyncPositionYawWithSeqFromClient(w *dispatcherWorker, dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	syncInfosToGame := service.inputSyncInfosToGame
+	if w != nil {
+		syncInfosToGame = w.inputSyncInfosToGame
+	}
+	service.cacheSyncInfosToGames(pkt, proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT, proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY, syncInfosToGame)
+}
+
+// cacheSyncInfosToGames splits sync infos of multiple entities to the cached packets of games which the entities belong to
+func (service *DispatcherService) cacheSyncInfosToGames(pkt *netutil.Packet, msgtype proto.MsgType, infoSize int, syncInfosToGame map[uint16]*netutil.Packet) {
 	payload := pkt.UnreadPayload()
 
-	for i := 0; i < len(payload); i += proto.SYNC_INFO_SIZE_PER_ENTITY + common.ENTITYID_LENGTH {
+	for i := 0; i < len(payload); i += infoSize + common.ENTITYID_LENGTH {
 		eid := common.EntityID(payload[i : i+common.ENTITYID_LENGTH]) // the first bytes of each entry is the EntityID
 
 		entityDispatchInfo := service.entityDispatchInfos[eid]
@@ -800,31 +1081,37 @@ func (service *DispatcherService) handleSyncPositionYawFromClient(dcp *dispatche
 
 		// put this sync info to the pending queue of target game
 		// concat to the end of queue
-		pkt := service.entitySyncInfosToGame[gameid]
+		pkt := syncInfosToGame[gameid]
 		if pkt == nil {
 			pkt = netutil.NewPacket()
-			pkt.AppendUint16(proto.MT_SYNC_POSITION_YAW_FROM_CLIENT)
-			service.entitySyncInfosToGame[gameid] = pkt
+			pkt.AppendUint16(uint16(msgtype))
+			syncInfosToGame[gameid] = pkt
 		}
-		pkt.AppendBytes(payload[i : i+proto.SYNC_INFO_SIZE_PER_ENTITY+common.ENTITYID_LENGTH])
+		pkt.AppendBytes(payload[i : i+infoSize+common.ENTITYID_LENGTH])
 	}
 }
 
 func (service *DispatcherService) sendEntitySyncInfosToGames() {
-	if len(service.entitySyncInfosToGame) == 0 {
-		return
+	service.sendSyncInfosToGames(service.entitySyncInfosToGame)
+	service.sendSyncInfosToGames(service.inputSyncInfosToGame)
+	for _, w := range service.workers { // workers are not handling messages with the write lock
+		service.sendSyncInfosToGames(w.entitySyncInfosToGame)
+		service.sendSyncInfosToGames(w.inputSyncInfosToGame)
 	}
+}
 
-	for gameid, pkt := range service.entitySyncInfosToGame {
+func (service *DispatcherService) sendSyncInfosToGames(syncInfosToGame map[uint16]*netutil.Packet) {
+	for gameid, pkt := range syncInfosToGame {
 		// send the entity sync infos to this game
 		service.games[gameid].dispatchPacket(pkt)
 		pkt.Release()
+		delete(syncInfosToGame, gameid)
 	}
-	service.entitySyncInfosToGame = map[uint16]*netutil.Packet{}
 }
 
 func (service *DispatcherService) handleCallEntityMethodFromClient(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	entityID := pkt.ReadEntityID()
+	defer traceRouting(pkt, entityID).End()
 
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleCallEntityMethodFromClient: entityID=%s, payload=%v", service, entityID, pkt.Payload())
@@ -843,8 +1130,18 @@ func (service *DispatcherService) handleDoSomethingOnSpecifiedClient(dcp *dispat
 	service.dispatcherClientOfGate(gid).SendPacket(pkt)
 }
 
-func (service *DispatcherService) handleCallFilteredClientProxies(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
-	service.broadcastToGates(pkt)
+func (service *DispatcherService) handleCallFilteredClientProxies(w *dispatcherWorker, dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	if w == nil {
+		service.broadcastToGates(pkt)
+		return
+	}
+
+	// the packet is posted to all workers, each of which sends it to gates of the worker
+	for gateid, gatedcp := range service.gates {
+		if gatedcp != nil && w.ownsGate(gateid, len(service.workers)) {
+			gatedcp.SendPacket(pkt)
+		}
+	}
 }
 
 func (service *DispatcherService) handleQuerySpaceGameIDForMigrate(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
@@ -937,7 +1234,7 @@ func (service *DispatcherService) broadcastToGates(pkt *netutil.Packet) {
 func (service *DispatcherService) recalcBootGames() {
 	var candidates []uint16
 	for gameid, gdi := range service.games {
-		if !gdi.isBanBootEntity {
+		if !gdi.isBanBootEntity && !gdi.isDraining {
 			candidates = append(candidates, gameid)
 		}
 	}
@@ -954,4 +1251,5 @@ func (service *DispatcherService) handleGameLBCInfo(dcp *dispatcherClientProxy,
 	gdi.lbcheapentry.update(lbcinfo)
 	heap.Fix(&service.lbcheap, gdi.lbcheapentry.heapidx)
 	service.lbcheap.validateHeapIndexes()
+	service.processCreationQueue()
 }
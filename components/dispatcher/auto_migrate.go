@@ -0,0 +1,67 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	autoMigrations = metrics.NewCounter("goworld_dispatcher_auto_migrations_total", "Entities requested to be migrated from hot games to cold games")
+)
+
+// checkAutoMigrate requests the hottest game to migrate entities to the coldest game if auto migration is enabled
+//
+// Every dispatcher receives load of all games, so only dispatcher1 makes decisions to avoid migrating too many entities.
+func (service *DispatcherService) checkAutoMigrate() {
+	deployCfg := conf.GetDeployment()
+	if !deployCfg.AutoMigrate || service.dispid != 1 || len(service.lbcheap) < 2 {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(service.lastAutoMigrateTime) < time.Duration(deployCfg.AutoMigrateIntervalMS)*time.Millisecond {
+		return
+	}
+	service.lastAutoMigrateTime = now
+
+	var hot, cold *lbcheapentry
+	for _, e := range service.lbcheap {
+		if gdi := service.games[e.gameid]; gdi == nil || gdi.isBlocked || gdi.isDraining {
+			continue
+		}
+
+		if hot == nil || e.origCPUPercent > hot.origCPUPercent {
+			hot = e
+		}
+		if cold == nil || e.origCPUPercent < cold.origCPUPercent {
+			cold = e
+		}
+	}
+
+	if hot == nil || hot == cold {
+		return
+	}
+	isHot := hot.origCPUPercent >= deployCfg.AutoMigrateCPUPercent || (deployCfg.AutoMigrateBacklog > 0 && hot.PacketBacklog >= deployCfg.AutoMigrateBacklog)
+	if !isHot || hot.origCPUPercent-cold.origCPUPercent < deployCfg.AutoMigrateCPUDiff || cold.origCPUPercent >= deployCfg.AutoMigrateCPUPercent {
+		return
+	}
+
+	// migrate half of the difference of entity counts to balance the games
+	count := (hot.EntityCount - cold.EntityCount) / 2
+	if count > deployCfg.AutoMigrateBatchSize {
+		count = deployCfg.AutoMigrateBatchSize
+	}
+	if count <= 0 {
+		return
+	}
+
+	gwlog.Infof("%s: game%d is hot (CPU %.1f%%, %d entities, %d packets backlog), migrating %d entities to game%d (CPU %.1f%%, %d entities)",
+		service, hot.gameid, hot.origCPUPercent, hot.EntityCount, hot.PacketBacklog, count, cold.gameid, cold.origCPUPercent, cold.EntityCount)
+	pkt := proto.MakeAutoMigrateEntitiesPacket(cold.gameid, count)
+	service.dispatchPacketToGame(hot.gameid, pkt)
+	pkt.Release()
+	autoMigrations.Add(float64(count))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Creation queue
+//
+// Entities created by CreateEntityAnywhereQueued are placed on the least loaded game immediately, unless CPU percent
+// of all games reaches overload_cpu_percent. Then creations wait in the queue, and are placed in order of priority
+// (FIFO for the same priority) when any game is no longer overloaded. Creations which are not placed before their
+// deadlines, or can not be queued because the queue is full, are rejected and the requesting game is notified.
+const (
+	_CREATION_QUEUE_SWEEP_INTERVAL = time.Second
+)
+
+var (
+	creationQueueLength = metrics.NewGauge("goworld_dispatcher_creation_queue_length", "Entity creations waiting for games to be not overloaded")
+	creationsQueued     = metrics.NewCounter("goworld_dispatcher_creations_queued_total", "Entity creations queued because all games are overloaded")
+	creationsRejected   = metrics.NewCounter("goworld_dispatcher_creations_rejected_total", "Queued entity creations rejected because of timeout or full queue")
+)
+
+type queuedCreation struct {
+	entityID  common.EntityID
+	requester uint16 // game which requested the creation
+	priority  int32
+	seq       uint64
+	deadline  time.Time
+	pkt       *netutil.Packet
+}
+
+type creationQueue struct {
+	items     []*queuedCreation
+	nextSeq   uint64
+	lastSweep time.Time
+}
+
+func (q *creationQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *creationQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority > q.items[j].priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *creationQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *creationQueue) Push(x interface{}) {
+	q.items = append(q.items, x.(*queuedCreation))
+}
+
+func (q *creationQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
+
+func (q *creationQueue) push(item *queuedCreation) {
+	item.seq = q.nextSeq
+	q.nextSeq += 1
+	heap.Push(q, item)
+	creationQueueLength.Set(float64(len(q.items)))
+}
+
+func (q *creationQueue) pop() *queuedCreation {
+	item := heap.Pop(q).(*queuedCreation)
+	creationQueueLength.Set(float64(len(q.items)))
+	return item
+}
+
+// takeExpired removes all expired creations from the queue
+func (q *creationQueue) takeExpired(now time.Time) (expired []*queuedCreation) {
+	items := q.items[:0]
+	for _, item := range q.items {
+		if now.After(item.deadline) {
+			expired = append(expired, item)
+		} else {
+			items = append(items, item)
+		}
+	}
+	for i := len(items); i < len(q.items); i++ {
+		q.items[i] = nil
+	}
+	q.items = items
+	heap.Init(q)
+	creationQueueLength.Set(float64(len(q.items)))
+	return
+}
+
+func (service *DispatcherService) handleCreateEntityAnywhereQueued(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	entityID := pkt.ReadEntityID()
+	_ = pkt.ReadVarStr() // typeName
+	priority := int32(pkt.ReadUint32())
+	timeout := time.Duration(pkt.ReadUint32()) * time.Millisecond
+	if timeout == 0 {
+		timeout = service.config.CreationQueueTimeout
+	}
+
+	if service.creationQueue.Len() == 0 {
+		if gdi := service.chooseNotOverloadedGame(); gdi != nil {
+			service.setEntityDispatcherInfoForWrite(entityID).gameid = gdi.gameid
+			gdi.dispatchPacket(pkt)
+			return
+		}
+	}
+
+	item := &queuedCreation{
+		entityID:  entityID,
+		requester: dcp.gameid,
+		priority:  priority,
+		deadline:  time.Now().Add(timeout),
+		pkt:       pkt,
+	}
+	if service.creationQueue.Len() >= service.config.CreationQueueMaxLen {
+		service.rejectCreation(item, "creation queue is full")
+		return
+	}
+
+	pkt.AddRefCount(1)
+	service.creationQueue.push(item)
+	creationsQueued.Inc()
+	// calls to the entity are blocked until it is created
+	service.setEntityDispatcherInfoForWrite(entityID).blockRPC(timeout)
+	gwlog.Warnf("%s: games are overloaded, creation of %s is queued with priority %d, %d creations queued", service, entityID, priority, service.creationQueue.Len())
+}
+
+// chooseNotOverloadedGame chooses the least loaded game, returns nil if all games are overloaded
+func (service *DispatcherService) chooseNotOverloadedGame() *gameDispatchInfo {
+	if len(service.lbcheap) == 0 {
+		return nil
+	}
+
+	if service.lbcheap[0].draining {
+		return nil // all games are draining
+	}
+	overloadCPUPercent := service.config.OverloadCPUPercent
+	if overloadCPUPercent > 0 && service.lbcheap[0].CPUPercent >= overloadCPUPercent {
+		return nil
+	}
+	return service.chooseGame()
+}
+
+// processCreationQueue places queued creations on games which are not overloaded, and rejects expired creations
+func (service *DispatcherService) processCreationQueue() {
+	q := &service.creationQueue
+	if q.Len() == 0 {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(q.lastSweep) >= _CREATION_QUEUE_SWEEP_INTERVAL {
+		q.lastSweep = now
+		for _, item := range q.takeExpired(now) {
+			service.dropQueuedCreation(item, "timeout")
+		}
+	}
+
+	for q.Len() > 0 {
+		if now.After(q.items[0].deadline) {
+			service.dropQueuedCreation(q.pop(), "timeout")
+			continue
+		}
+
+		gdi := service.chooseNotOverloadedGame()
+		if gdi == nil {
+			break
+		}
+
+		item := q.pop()
+		entityDispatchInfo := service.setEntityDispatcherInfoForWrite(item.entityID)
+		entityDispatchInfo.gameid = gdi.gameid
+		entityDispatchInfo.blockRPC(consts.DISPATCHER_LOAD_TIMEOUT) // calls are unblocked when the entity is created
+		gdi.dispatchPacket(item.pkt)
+		item.pkt.Release()
+	}
+}
+
+// dropQueuedCreation rejects the queued creation, and drops calls to the entity which are waiting for the creation
+func (service *DispatcherService) dropQueuedCreation(item *queuedCreation, reason string) {
+	service.rejectCreation(item, reason)
+	if edi := service.entityDispatchInfos[item.entityID]; edi != nil {
+		for _, pkt := range edi.pendingPacketQueue {
+			pkt.Release()
+		}
+		edi.pendingPacketQueue = nil
+	}
+	service.cleanupEntityInfo(item.entityID)
+	item.pkt.Release()
+}
+
+func (service *DispatcherService) rejectCreation(item *queuedCreation, reason string) {
+	gwlog.Warnf("%s: creation of %s requested by game%d is rejected: %s", service, item.entityID, item.requester, reason)
+	creationsRejected.Inc()
+	pkt := proto.MakeCreateEntityRejectedPacket(item.entityID, reason)
+	service.dispatchPacketToGame(item.requester, pkt)
+	pkt.Release()
+}
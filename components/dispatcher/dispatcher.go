@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 
@@ -17,14 +18,18 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 var (
 	dispidArg         int
 	dispid            uint16
 	configFile        = ""
+	conf              = config.Default() // config of the dispatcher
 	logLevel          string
 	runInDaemonMode   bool
+	compatCorpus      bool
+	isStandby         bool
 	sigChan           = make(chan os.Signal, 1)
 	dispatcherService *DispatcherService
 )
@@ -32,8 +37,11 @@ var (
 func parseArgs() {
 	flag.IntVar(&dispidArg, "dispid", 0, "set dispatcher ID")
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{Config: conf}, "set", "override config key: -set section.key=value, can be repeated")
 	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&runInDaemonMode, "d", false, "run in daemon mode")
+	flag.BoolVar(&compatCorpus, "compat-corpus", false, "dump protocol compat corpus and exit")
+	flag.BoolVar(&isStandby, "standby", false, "run as the hot-standby of the dispatcher")
 	flag.Parse()
 	dispid = uint16(dispidArg)
 }
@@ -44,6 +52,10 @@ func setupGCPercent() {
 
 func main() {
 	parseArgs()
+	if compatCorpus {
+		binutil.DumpCompatCorpusAndExit()
+	}
+
 	if runInDaemonMode {
 		daemoncontext := binutil.Daemonize()
 		defer daemoncontext.Release()
@@ -52,23 +64,49 @@ func main() {
 	setupGCPercent()
 
 	if configFile != "" {
-		config.SetConfigFile(configFile)
+		conf.SetConfigFile(configFile)
 	}
+	conf.SetLocalNode(config.NodeDispatcher, dispid)
 
-	validDispIds := config.GetDispatcherIDs()
+	validDispIds := conf.GetDispatcherIDs()
 	if dispid < validDispIds[0] || dispid > validDispIds[len(validDispIds)-1] {
-		gwlog.Fatalf("dispatcher ID must be one of %v, but is %v, use -dispid to specify", config.GetDispatcherIDs(), dispid)
+		gwlog.Fatalf("dispatcher ID must be one of %v, but is %v, use -dispid to specify", conf.GetDispatcherIDs(), dispid)
 	}
 
-	dispatcherConfig := config.GetDispatcher(dispid)
+	dispatcherConfig := conf.GetDispatcher(dispid)
+	if isStandby && dispatcherConfig.StandbyAddr == "" {
+		gwlog.Fatalf("dispatcher%d has no standby_addr configured, can not run as standby", dispid)
+	}
 
 	if logLevel == "" {
 		logLevel = dispatcherConfig.LogLevel
 	}
-	binutil.SetupGWLog("dispatcherService", logLevel, dispatcherConfig.LogFile, dispatcherConfig.LogStderr)
-	binutil.SetupHTTPServer(dispatcherConfig.HTTPAddr, nil)
+	binutil.SetupGWLog("dispatcherService", logLevel, dispatcherConfig.LogFile, dispatcherConfig.LogStderr, dispatcherConfig.LogFormat, gwlog.Rotation{
+		MaxSize:    int64(dispatcherConfig.LogRotateSize) << 20,
+		Interval:   dispatcherConfig.LogRotateInterval,
+		MaxBackups: dispatcherConfig.LogMaxBackups,
+	})
+	tracingConfig := conf.GetTracing()
+	tracing.Setup(fmt.Sprintf("dispatcher%d", dispid), tracingConfig.Endpoint, tracingConfig.SampleRate)
+	binutil.SetupMetrics(fmt.Sprintf("dispatcher%d", dispid), nil, conf.GetTelemetry())
+	if !isStandby { // standby does not serve HTTP to avoid conflicting with http_addr of the primary
+		binutil.SetupAdminAPI(conf.GetDebug())
+		binutil.SetupHTTPServer(dispatcherConfig.HTTPAddr, nil)
+	}
 
-	dispatcherService = newDispatcherService(dispid)
+	dispatcherService = newDispatcherService(dispid, isStandby)
+	conf.OnChange(fmt.Sprintf("dispatcher%d", dispid), func(old, new interface{}) {
+		post.Post(func() {
+			dispatcherService.onConfigChanged(old.(*config.DispatcherConfig), new.(*config.DispatcherConfig))
+		})
+	})
+	conf.Watch()
+	if !isStandby {
+		conf.RegisterLocalNode(dispatcherConfig.AdvertiseAddr)
+	}
+	binutil.SetupService(fmt.Sprintf("goworld_dispatcher%d", dispid), func() {
+		sigChan <- syscall.SIGTERM
+	})
 	setupSignals() // call setupSignals to avoid data race on `dispatcherService`
 	dispatcherService.run()
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"container/heap"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// handleSetGameDraining stops placing new entities on the draining game
+//
+// Entities of the draining game are migrated to other games by the game itself, and calls to them are still routed
+// to the game until they are migrated.
+func (service *DispatcherService) handleSetGameDraining(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	gdi := service.games[dcp.gameid]
+	if gdi == nil {
+		gwlog.Errorf("%s handleSetGameDraining: game%d not found", service, dcp.gameid)
+		return
+	}
+
+	gwlog.Infof("%s: game%d is draining", service, dcp.gameid)
+	service.setGameDraining(gdi, true)
+	service.recalcBootGames()
+}
+
+func (service *DispatcherService) setGameDraining(gdi *gameDispatchInfo, draining bool) {
+	gdi.isDraining = draining
+	gdi.lbcheapentry.draining = draining
+	heap.Fix(&service.lbcheap, gdi.lbcheapentry.heapidx)
+	service.lbcheap.validateHeapIndexes()
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"container/heap"
 
-	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
@@ -13,11 +12,16 @@ type lbcheapentry struct {
 	heapidx        int // index of this entry in the heap
 	CPUPercent     float64
 	origCPUPercent float64
+	EntityCount    int
+	PacketBacklog  int
+	draining       bool // draining games are sorted after all other games
 }
 
 func (e *lbcheapentry) update(info proto.GameLBCInfo) {
 	e.origCPUPercent = info.CPUPercent
 	e.CPUPercent = info.CPUPercent
+	e.EntityCount = info.EntityCount
+	e.PacketBacklog = info.PacketBacklog
 }
 
 type lbcheap []*lbcheapentry
@@ -27,6 +31,9 @@ func (h lbcheap) Len() int {
 }
 
 func (h lbcheap) Less(i, j int) bool {
+	if h[i].draining != h[j].draining {
+		return !h[i].draining
+	}
 	return h[i].CPUPercent < h[j].CPUPercent
 }
 
@@ -51,7 +58,7 @@ func (h *lbcheap) Pop() interface{} {
 }
 
 func (h lbcheap) validateHeapIndexes() {
-	if !config.Debug() {
+	if !conf.Debug() {
 		return
 	}
 
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"expvar"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// EntityReconcileReport is the result of the last entity reconciliation of a game
+//
+// Discrepancies between dispatcher routing state and the entities on game are only resolved if they are found in two
+// consecutive reconciliations, so entities which are being created, loaded or migrated are not touched:
+//   - Orphans (entities on game without routing) are adopted by routing to the game
+//   - Duplicates (entities on game but routed to another game) are destroyed on the game as stale copies
+//   - Missing entities (routed to the game but not on game) are reloaded if the entity type is known, or forgotten otherwise
+type EntityReconcileReport struct {
+	Time      time.Time
+	Entities  int
+	Suspects  int
+	Adopted   []common.EntityID
+	Destroyed []common.EntityID
+	Reloaded  []common.EntityID
+	Forgotten []common.EntityID
+}
+
+var (
+	entityReconcileReports atomic.Value // map[string]*EntityReconcileReport
+)
+
+func init() {
+	entityReconcileReports.Store(map[string]*EntityReconcileReport{})
+	expvar.Publish("EntityReconcileReports", expvar.Func(func() interface{} {
+		return entityReconcileReports.Load()
+	}))
+}
+
+func (service *DispatcherService) handleReconcileEntities(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	gameid := dcp.gameid
+	gdi := service.games[gameid]
+	if gdi == nil || gdi.clientProxy != dcp || gdi.isBlocked {
+		gwlog.Warnf("%s: ignore entity reconciliation from %s: game is not ready", service, dcp)
+		return
+	}
+
+	numEntities := pkt.ReadUint32()
+	reported := make(common.EntityIDSet, numEntities)
+	for i := uint32(0); i < numEntities; i++ {
+		reported.Add(pkt.ReadEntityID())
+	}
+
+	report := &EntityReconcileReport{
+		Time:     time.Now(),
+		Entities: len(reported),
+	}
+	now := report.Time
+	lastSuspects := gdi.reconcileSuspects
+	suspects := common.EntityIDSet{}
+	var staleEntities []common.EntityID
+
+	for eid := range reported {
+		edi := service.entityDispatchInfos[eid]
+		if edi != nil && (edi.gameid == gameid || now.Before(edi.blockUntilTime)) {
+			continue
+		}
+
+		suspects.Add(eid)
+		if !lastSuspects.Contains(eid) {
+			continue
+		}
+
+		if edi == nil || edi.gameid == 0 {
+			// orphan entity: adopt it
+			edi = service.setEntityDispatcherInfoForWrite(eid)
+			edi.gameid = gameid
+			report.Adopted = append(report.Adopted, eid)
+		} else {
+			// duplicate entity: the copy on the game is stale
+			staleEntities = append(staleEntities, eid)
+			report.Destroyed = append(report.Destroyed, eid)
+		}
+	}
+
+	for eid, edi := range service.entityDispatchInfos {
+		if edi.gameid != gameid || now.Before(edi.blockUntilTime) || reported.Contains(eid) {
+			continue
+		}
+
+		suspects.Add(eid)
+		if !lastSuspects.Contains(eid) {
+			continue
+		}
+
+		// missing entity: reload it if possible
+		typeName := edi.typeName
+		service.cleanupEntityInfo(eid)
+		if typeName != "" {
+			pkt := proto.AllocLoadEntitySomewherePacket(typeName, eid, 0)
+			pkt.ReadUint16() // skip msgtype
+			service.handleLoadEntitySomewhere(nil, pkt)
+			pkt.Release()
+			report.Reloaded = append(report.Reloaded, eid)
+		} else {
+			report.Forgotten = append(report.Forgotten, eid)
+		}
+	}
+
+	gdi.reconcileSuspects = suspects
+	report.Suspects = len(suspects)
+
+	if len(staleEntities) > 0 {
+		dcp.SendDestroyStaleEntities(staleEntities)
+	}
+
+	if len(report.Adopted)+len(report.Destroyed)+len(report.Reloaded)+len(report.Forgotten) > 0 {
+		gwlog.Warnf("%s: reconcile entities of game%d: %d entities, adopted %d, destroyed %d, reloaded %d, forgotten %d", service, gameid,
+			report.Entities, len(report.Adopted), len(report.Destroyed), len(report.Reloaded), len(report.Forgotten))
+	}
+
+	oldReports := entityReconcileReports.Load().(map[string]*EntityReconcileReport)
+	reports := make(map[string]*EntityReconcileReport, len(oldReports)+1)
+	for k, v := range oldReports {
+		reports[k] = v
+	}
+	reports[strconv.Itoa(int(gameid))] = report
+	entityReconcileReports.Store(reports)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"container/heap"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func init() {
+	conf = config.New("../../goworld.ini")
+}
+
+// testGameConn is a connection to the game, packets sent to the game are received back from it
+type testGameConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *testGameConn) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+func (c *testGameConn) Read(b []byte) (int, error) {
+	return c.buf.Read(b)
+}
+
+func (c *testGameConn) Flush() error {
+	return nil
+}
+
+func (c *testGameConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *testGameConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func newReconcileTestService(gameids ...uint16) *DispatcherService {
+	service := &DispatcherService{
+		games:               map[uint16]*gameDispatchInfo{},
+		entityDispatchInfos: map[common.EntityID]*entityDispatchInfo{},
+	}
+	for _, gameid := range gameids {
+		dcp := &dispatcherClientProxy{
+			GoWorldConnection: proto.NewGoWorldConnection(&testGameConn{}),
+			owner:             service,
+			gameid:            gameid,
+		}
+		entry := &lbcheapentry{gameid: gameid}
+		service.games[gameid] = &gameDispatchInfo{gameid: gameid, clientProxy: dcp, lbcheapentry: entry}
+		heap.Push(&service.lbcheap, entry)
+	}
+	return service
+}
+
+// reconcile handles the reconciliation of entities reported by the game, and returns the report
+func reconcile(service *DispatcherService, gameid uint16, eids ...common.EntityID) *EntityReconcileReport {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint32(uint32(len(eids)))
+	for _, eid := range eids {
+		pkt.AppendEntityID(eid)
+	}
+	service.handleReconcileEntities(service.games[gameid].clientProxy, pkt)
+	pkt.Release()
+	return entityReconcileReports.Load().(map[string]*EntityReconcileReport)[strconv.Itoa(int(gameid))]
+}
+
+// recvFromDispatcher returns message types and packets sent to the game
+func recvFromDispatcher(service *DispatcherService, gameid uint16) (msgtypes []proto.MsgType, pkts []*netutil.Packet) {
+	dcp := service.games[gameid].clientProxy
+	dcp.Flush("test")
+	for {
+		var msgtype proto.MsgType
+		pkt, err := dcp.Recv(&msgtype)
+		if err != nil {
+			return
+		}
+		msgtypes = append(msgtypes, msgtype)
+		pkts = append(pkts, pkt)
+	}
+}
+
+func setRouting(service *DispatcherService, eid common.EntityID, gameid uint16, typeName string) *entityDispatchInfo {
+	edi := service.setEntityDispatcherInfoForWrite(eid)
+	edi.gameid, edi.typeName = gameid, typeName
+	return edi
+}
+
+func TestReconcileTwoConsecutiveSuspects(t *testing.T) {
+	service := newReconcileTestService(1)
+	orphan, created := common.GenEntityID(), common.GenEntityID()
+
+	if report := reconcile(service, 1, orphan, created); report.Suspects != 2 || len(report.Adopted) != 0 {
+		t.Fatalf("suspects should not be resolved in the first reconciliation: %+v", report)
+	}
+
+	// the creation is notified between reconciliations
+	setRouting(service, created, 1, "")
+	if report := reconcile(service, 1, orphan, created); report.Suspects != 1 || len(report.Adopted) != 1 || report.Adopted[0] != orphan {
+		t.Fatalf("only entities suspected twice in a row should be resolved: %+v", report)
+	}
+}
+
+func TestReconcileAdoptOrphan(t *testing.T) {
+	service := newReconcileTestService(1)
+	orphan := common.GenEntityID()
+	reconcile(service, 1, orphan)
+	reconcile(service, 1, orphan)
+	if edi := service.entityDispatchInfos[orphan]; edi == nil || edi.gameid != 1 {
+		t.Fatalf("orphan entity should be routed to its game: %+v", edi)
+	}
+	if report := reconcile(service, 1, orphan); report.Suspects != 0 {
+		t.Fatalf("adopted entity should not be suspected: %+v", report)
+	}
+}
+
+func TestReconcileDestroyDuplicate(t *testing.T) {
+	service := newReconcileTestService(1, 2)
+	duplicate := common.GenEntityID()
+	setRouting(service, duplicate, 2, "Avatar")
+
+	reconcile(service, 1, duplicate)
+	if msgtypes, _ := recvFromDispatcher(service, 1); len(msgtypes) != 0 {
+		t.Fatalf("duplicate should not be destroyed in the first reconciliation: %v", msgtypes)
+	}
+	if report := reconcile(service, 1, duplicate); len(report.Destroyed) != 1 || report.Destroyed[0] != duplicate {
+		t.Fatalf("duplicate should be destroyed: %+v", report)
+	}
+
+	msgtypes, pkts := recvFromDispatcher(service, 1)
+	if len(msgtypes) != 1 || msgtypes[0] != proto.MT_DESTROY_STALE_ENTITIES || pkts[0].ReadUint32() != 1 || pkts[0].ReadEntityID() != duplicate {
+		t.Fatalf("stale copy should be destroyed on the game: %v", msgtypes)
+	}
+	if edi := service.entityDispatchInfos[duplicate]; edi == nil || edi.gameid != 2 {
+		t.Fatalf("routing to the owner should be kept: %+v", edi)
+	}
+}
+
+func TestReconcileMissing(t *testing.T) {
+	service := newReconcileTestService(1)
+	loaded, created := common.GenEntityID(), common.GenEntityID()
+	setRouting(service, loaded, 1, "Avatar")
+	setRouting(service, created, 1, "")
+
+	reconcile(service, 1)
+	report := reconcile(service, 1)
+	if len(report.Reloaded) != 1 || report.Reloaded[0] != loaded || len(report.Forgotten) != 1 || report.Forgotten[0] != created {
+		t.Fatalf("missing entities should be reloaded if loaded from storage, or forgotten otherwise: %+v", report)
+	}
+	if edi := service.entityDispatchInfos[created]; edi != nil {
+		t.Fatalf("forgotten entity should not be routed: %+v", edi)
+	}
+
+	edi := service.entityDispatchInfos[loaded]
+	if edi == nil || edi.gameid != 1 || edi.typeName != "Avatar" || !time.Now().Before(edi.blockUntilTime) {
+		t.Fatalf("reloaded entity should be routed to the game loading it: %+v", edi)
+	}
+	msgtypes, pkts := recvFromDispatcher(service, 1)
+	if len(msgtypes) != 1 || msgtypes[0] != proto.MT_LOAD_ENTITY_SOMEWHERE {
+		t.Fatalf("missing entity should be loaded: %v", msgtypes)
+	}
+	if pkts[0].ReadUint16(); pkts[0].ReadEntityID() != loaded || pkts[0].ReadVarStr() != "Avatar" {
+		t.Fatalf("wrong load entity packet")
+	}
+}
+
+func TestReconcileMigratingExempted(t *testing.T) {
+	service := newReconcileTestService(1, 2)
+	migratingIn, migratingOut := common.GenEntityID(), common.GenEntityID()
+	// migratingIn is routed to game2 but already created on game1, migratingOut is routed to game1 but already left
+	setRouting(service, migratingIn, 2, "").blockRPC(time.Minute)
+	setRouting(service, migratingOut, 1, "").blockRPC(time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if report := reconcile(service, 1, migratingIn); report.Suspects != 0 {
+			t.Fatalf("entities being migrated should not be suspected: %+v", report)
+		}
+	}
+	if msgtypes, _ := recvFromDispatcher(service, 1); len(msgtypes) != 0 {
+		t.Fatalf("entities being migrated should not be touched: %v", msgtypes)
+	}
+	if service.entityDispatchInfos[migratingIn].gameid != 2 || service.entityDispatchInfos[migratingOut].gameid != 1 {
+		t.Fatalf("routing of entities being migrated should not be changed")
+	}
+}
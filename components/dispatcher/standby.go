@@ -0,0 +1,219 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Hot-standby dispatcher
+//
+// A dispatcher configured with standby_addr can be backed by a standby process started with -standby. Only one of the
+// two is active and listening at any time. The passive one connects to the active one and mirrors its routing state
+// (known games, kvreg registrations and entity locations). When the active dispatcher is unreachable for
+// standby_failover_timeout_ms, the passive one takes over and starts listening. Games and gates switch between
+// advertise_addr and standby_addr when they fail to connect, and re-register their entities as reconnecting games.
+// Packets sent to the failed dispatcher before games and gates reconnect are lost.
+const (
+	_STANDBY_CONNECT_RETRY_INTERVAL = time.Millisecond * 200
+	_STANDBY_HEARTBEAT_INTERVAL     = time.Second
+	_STANDBY_SYNC_MAX_ENTITIES      = 65536            // max number of entities in one sync packet
+	_STANDBY_GAME_RECONNECT_TIMEOUT = time.Second * 10 // games are considered down if not reconnected after failover
+)
+
+// listenAddr returns the address this dispatcher process should listen on
+func (service *DispatcherService) listenAddr() string {
+	if service.isStandby {
+		return service.config.StandbyListenAddr
+	}
+	return service.config.ListenAddr
+}
+
+// peerAddr returns the address of the other dispatcher process of the active-standby pair
+func (service *DispatcherService) peerAddr() string {
+	if service.isStandby {
+		return service.config.AdvertiseAddr
+	}
+	return service.config.StandbyAddr
+}
+
+// waitUntilActive mirrors the active peer until it is unreachable for the failover timeout
+//
+// The primary dispatcher only waits if the standby is already active, so that restarting a failed primary does not
+// take over from the standby.
+func (service *DispatcherService) waitUntilActive() {
+	peerAddr := service.peerAddr()
+	deadline := time.Now()
+	if service.isStandby {
+		deadline = deadline.Add(service.config.FailoverTimeout)
+	}
+
+	for {
+		conn, err := netutil.ConnectTCP(peerAddr)
+		if err != nil {
+			if !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(_STANDBY_CONNECT_RETRY_INTERVAL)
+			continue
+		}
+
+		gwlog.Infof("%s: mirroring active dispatcher at %s", service, peerAddr)
+		dcp := newDispatcherClientProxy(service, conn)
+		dcp.isActivePeer = true
+		dcp.SendSetStandby(service.dispid)
+		dcp.serve() // serve until the connection to active dispatcher is lost
+		gwlog.Warnf("%s: lost connection to active dispatcher at %s", service, peerAddr)
+		deadline = time.Now().Add(service.config.FailoverTimeout)
+	}
+
+	gwlog.Warnf("%s: active dispatcher at %s is unreachable, taking over", service, peerAddr)
+	post.Post(service.takeOver)
+}
+
+// takeOver makes mirrored games wait for reconnection, so packets to their entities are queued instead of dropped
+func (service *DispatcherService) takeOver() {
+	service.isActive = true
+	service.mirror = nil
+	for _, gameid := range service.mirrorGameIDs {
+		if service.games[gameid] != nil {
+			continue // game already reconnected
+		}
+
+		// isBanBootEntity is restored when the game reconnects
+		lbcheapentry := &lbcheapentry{gameid, len(service.lbcheap), 0, 0, 0, 0, false}
+		gdi := &gameDispatchInfo{gameid: gameid, isBanBootEntity: true, lbcheapentry: lbcheapentry}
+		gdi.block(_STANDBY_GAME_RECONNECT_TIMEOUT)
+		service.games[gameid] = gdi
+		heap.Push(&service.lbcheap, lbcheapentry)
+	}
+	service.lbcheap.validateHeapIndexes()
+	service.mirrorGameIDs = nil
+	gwlog.Infof("%s: took over with %d games, %d kvregs, %d entities", service, len(service.games), len(service.kvregRegisterMap), len(service.entityDispatchInfos))
+}
+
+// checkMirror closes the connection to active dispatcher if it stops sending heartbeats
+func (service *DispatcherService) checkMirror() {
+	if service.mirror != nil && time.Since(service.lastMirrorSyncTime) > service.config.FailoverTimeout {
+		gwlog.Warnf("%s: no heartbeat from active dispatcher for %s", service, service.config.FailoverTimeout)
+		service.mirror.Close()
+	}
+}
+
+func (service *DispatcherService) handleSetStandby(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	dispid := pkt.ReadUint16()
+	if dispid != service.dispid {
+		gwlog.Errorf("%s: standby of dispatcher%d connected: %s", service, dispid, dcp)
+		dcp.Close()
+		return
+	}
+
+	if service.standby != nil {
+		gwlog.Warnf("%s: standby %s is replaced by %s", service, service.standby, dcp)
+		service.standby.Close()
+	}
+	dcp.isStandby = true
+	service.standby = dcp
+	gwlog.Infof("%s: standby connected: %s", service, dcp)
+
+	// send the full routing state to standby
+	entities := map[common.EntityID]uint16{}
+	for eid, edi := range service.entityDispatchInfos {
+		entities[eid] = edi.gameid
+		if len(entities) >= _STANDBY_SYNC_MAX_ENTITIES {
+			dcp.SendSyncRoutingToStandby(service.getGameIDs(), nil, entities)
+			entities = map[common.EntityID]uint16{}
+		}
+	}
+	dcp.SendSyncRoutingToStandby(service.getGameIDs(), service.kvregRegisterMap, entities)
+	service.standbyDirtyEntities = common.EntityIDSet{}
+	service.standbyDirtyKvregs = common.StringSet{}
+	service.lastStandbySyncTime = time.Now()
+}
+
+func (service *DispatcherService) handleActivePeerDisconnected(dcp *dispatcherClientProxy) {
+	if service.mirror == dcp {
+		service.mirror = nil
+	}
+}
+
+func (service *DispatcherService) handleStandbyDisconnected(dcp *dispatcherClientProxy) {
+	if service.standby != dcp {
+		return
+	}
+
+	gwlog.Warnf("%s: standby disconnected: %s", service, dcp)
+	service.standby = nil
+	service.standbyDirtyEntities = nil
+	service.standbyDirtyKvregs = nil
+}
+
+// syncRoutingToStandby sends changed routing state to standby, or a heartbeat if nothing is changed for a while
+func (service *DispatcherService) syncRoutingToStandby() {
+	if service.standby == nil {
+		return
+	}
+	if len(service.standbyDirtyEntities) == 0 && len(service.standbyDirtyKvregs) == 0 && time.Since(service.lastStandbySyncTime) < _STANDBY_HEARTBEAT_INTERVAL {
+		return
+	}
+
+	kvregs := make(map[string]string, len(service.standbyDirtyKvregs))
+	for srvid := range service.standbyDirtyKvregs {
+		kvregs[srvid] = service.kvregRegisterMap[srvid]
+	}
+	entities := make(map[common.EntityID]uint16, len(service.standbyDirtyEntities))
+	for eid := range service.standbyDirtyEntities {
+		gameid := uint16(0) // 0 for deleted entities
+		if edi := service.entityDispatchInfos[eid]; edi != nil {
+			gameid = edi.gameid
+		}
+		entities[eid] = gameid
+	}
+
+	service.standby.SendSyncRoutingToStandby(service.getGameIDs(), kvregs, entities)
+	service.standbyDirtyEntities = common.EntityIDSet{}
+	service.standbyDirtyKvregs = common.StringSet{}
+	service.lastStandbySyncTime = time.Now()
+}
+
+func (service *DispatcherService) handleSyncRoutingToStandby(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	if !dcp.isActivePeer || service.isActive {
+		gwlog.Errorf("%s: unexpected routing sync from %s", service, dcp)
+		return
+	}
+
+	service.mirror = dcp
+	service.lastMirrorSyncTime = time.Now()
+	numGames := pkt.ReadUint16()
+	gameids := make([]uint16, numGames)
+	for i := range gameids {
+		gameids[i] = pkt.ReadUint16()
+	}
+	service.mirrorGameIDs = gameids
+
+	for srvid, srvinfo := range pkt.ReadMapStringString() {
+		service.kvregRegisterMap[srvid] = srvinfo
+	}
+
+	numEntities := pkt.ReadUint32()
+	for i := uint32(0); i < numEntities; i++ {
+		eid := pkt.ReadEntityID()
+		gameid := pkt.ReadUint16()
+		if gameid == 0 {
+			service.delEntityDispatchInfo(eid)
+		} else {
+			service.setEntityDispatcherInfoForWrite(eid).gameid = gameid
+		}
+	}
+}
+
+func (service *DispatcherService) getGameIDs() (gameids []uint16) {
+	for gameid := range service.games {
+		gameids = append(gameids, gameid)
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Dispatcher workers
+//
+// If workers are configured, messages of entities are handled by the worker selected by the entity ID, and messages
+// to clients are handled by the worker selected by the gate ID, so that messages of an entity or a gate are handled in
+// order while all cores are used. Messages which only forward packets (e.g. RPC calls) are handled by workers
+// concurrently with the read lock of the dispatcher service, other messages of entities (e.g. creations and
+// migrations) are handled with the write lock. Messages which are not of entities or gates (e.g. games connected) are
+// handled by the main routine with the write lock after earlier messages of the connection are handled by workers, and
+// the connection sending the message waits until it is handled.
+
+type dispatcherWorker struct {
+	idx                   int
+	messageQueue          chan dispatcherMessage
+	entitySyncInfosToGame map[uint16]*netutil.Packet // cache entity sync infos to games, sent by the main routine
+	inputSyncInfosToGame  map[uint16]*netutil.Packet
+}
+
+func newDispatcherWorkers(n int, queueSize int) []*dispatcherWorker {
+	workers := make([]*dispatcherWorker, n)
+	for i := range workers {
+		workers[i] = &dispatcherWorker{
+			idx:                   i,
+			messageQueue:          make(chan dispatcherMessage, queueSize),
+			entitySyncInfosToGame: map[uint16]*netutil.Packet{},
+			inputSyncInfosToGame:  map[uint16]*netutil.Packet{},
+		}
+	}
+	return workers
+}
+
+func (w *dispatcherWorker) loop(service *DispatcherService) {
+	for msg := range w.messageQueue {
+		w.handleMessage(service, msg)
+	}
+}
+
+func (w *dispatcherWorker) handleMessage(service *DispatcherService, msg dispatcherMessage) {
+	defer func() {
+		if err := recover(); err != nil {
+			gwlog.TraceError("dispatcher worker %d: handle msgtype %d from %s paniced: %v", w.idx, msg.MsgType, msg.dcp, err)
+		}
+		msg.dcp.workerMessages.Done()
+	}()
+
+	if isForwardingMsgType(msg.MsgType) {
+		service.lock.RLock()
+		defer service.lock.RUnlock()
+	} else {
+		service.lock.Lock()
+		defer service.lock.Unlock()
+	}
+	service.handleMessage(w, msg.dcp, msg.MsgType, msg.Packet)
+}
+
+// postMessage passes the message received from the connection to the worker or the main routine
+func (service *DispatcherService) postMessage(dcp *dispatcherClientProxy, msgtype proto.MsgType, pkt *netutil.Packet) {
+	msg := dispatcherMessage{dcp: dcp, Message: proto.Message{MsgType: msgtype, Packet: pkt}}
+	if len(service.workers) == 0 {
+		service.messageQueue <- msg
+		return
+	}
+
+	if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
+		// every worker sends the packet to gates of the worker, so that it is ordered with other packets to gates
+		pkt.AddRefCount(int64(len(service.workers) - 1))
+		dcp.workerMessages.Add(len(service.workers))
+		for _, w := range service.workers {
+			w.messageQueue <- msg
+		}
+		return
+	}
+
+	if key, ok := workerKeyOf(dcp, msgtype, pkt); ok {
+		dcp.workerMessages.Add(1)
+		service.workers[key%uint32(len(service.workers))].messageQueue <- msg
+		return
+	}
+
+	// wait until earlier messages of the connection are handled by workers, and until the message is handled, so that
+	// messages of the connection are handled in order
+	dcp.workerMessages.Wait()
+	msg.done = make(chan struct{})
+	service.messageQueue <- msg
+	<-msg.done
+}
+
+// workerKeyOf returns the key for selecting the worker of the message, or false if it should be handled by the main routine
+func workerKeyOf(dcp *dispatcherClientProxy, msgtype proto.MsgType, pkt *netutil.Packet) (uint32, bool) {
+	payload := pkt.UnreadPayload()
+	switch msgtype {
+	case proto.MT_CALL_ENTITY_METHOD, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ, proto.MT_CALL_ENTITY_METHOD_WITH_RESULT,
+		proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+		proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT,
+		proto.MT_NOTIFY_CREATE_ENTITY, proto.MT_NOTIFY_DESTROY_ENTITY, proto.MT_CREATE_ENTITY_ANYWHERE_QUEUED,
+		proto.MT_MIGRATE_REQUEST, proto.MT_CANCEL_MIGRATE, proto.MT_REAL_MIGRATE, proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE,
+		proto.MT_NOTIFY_CLIENT_DISCONNECTED, proto.MT_NOTIFY_CLIENT_RESUMED, proto.MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION:
+		return entityWorkerKey(payload, 0)
+	case proto.MT_LOAD_ENTITY_SOMEWHERE, proto.MT_CREATE_ENTITY_SOMEWHERE:
+		return entityWorkerKey(payload, 2) // after the target game ID
+	case proto.MT_SYNC_SERVICE_SNAPSHOT:
+		return entityWorkerKey(payload, 4) // after the standby game ID and the game ID
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY, proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.MT_SYNC_INPUT_ACK_ON_CLIENTS:
+		return gateWorkerKey(payload)
+	case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT, proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT:
+		return uint32(dcp.gateid), true
+	}
+	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		return gateWorkerKey(payload)
+	}
+	return 0, false
+}
+
+func entityWorkerKey(payload []byte, offset int) (uint32, bool) {
+	if len(payload) < offset+common.ENTITYID_LENGTH {
+		return 0, false
+	}
+	h := fnv.New32a()
+	h.Write(payload[offset : offset+common.ENTITYID_LENGTH])
+	return h.Sum32(), true
+}
+
+func gateWorkerKey(payload []byte) (uint32, bool) {
+	if len(payload) < 2 {
+		return 0, false
+	}
+	return uint32(netutil.NETWORK_ENDIAN.Uint16(payload)), true
+}
+
+// isForwardingMsgType returns if messages of the type only forward packets without changing states other than
+// entities of the worker, so that workers can handle them concurrently
+func isForwardingMsgType(msgtype proto.MsgType) bool {
+	switch msgtype {
+	case proto.MT_CALL_ENTITY_METHOD, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ, proto.MT_CALL_ENTITY_METHOD_WITH_RESULT,
+		proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+		proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT, proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE,
+		proto.MT_NOTIFY_CLIENT_DISCONNECTED, proto.MT_NOTIFY_CLIENT_RESUMED, proto.MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION,
+		proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY, proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.MT_SYNC_INPUT_ACK_ON_CLIENTS,
+		proto.MT_SYNC_POSITION_YAW_FROM_CLIENT, proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT, proto.MT_CALL_FILTERED_CLIENTS:
+		return true
+	}
+	return msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP
+}
+
+// ownsGate returns if packets to the gate are sent by the worker
+func (w *dispatcherWorker) ownsGate(gateid uint16, numWorkers int) bool {
+	return uint32(gateid)%uint32(numWorkers) == uint32(w.idx)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	_TEST_WORKERS = 4
+)
+
+func newTestService() *DispatcherService {
+	return &DispatcherService{
+		messageQueue: make(chan dispatcherMessage, 100),
+		workers:      newDispatcherWorkers(_TEST_WORKERS, 100),
+	}
+}
+
+// serveTestQueues handles messages of workers and the main routine by the handler instead of the dispatcher service
+func serveTestQueues(service *DispatcherService, handle func(worker int, msg dispatcherMessage)) (stop func()) {
+	var wait sync.WaitGroup
+	for _, w := range service.workers {
+		wait.Add(1)
+		go func(w *dispatcherWorker) {
+			defer wait.Done()
+			for msg := range w.messageQueue {
+				handle(w.idx, msg)
+				msg.Packet.Release()
+				msg.dcp.workerMessages.Done()
+			}
+		}(w)
+	}
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for msg := range service.messageQueue {
+			handle(-1, msg)
+			msg.Packet.Release()
+			close(msg.done)
+		}
+	}()
+	return func() {
+		for _, w := range service.workers {
+			close(w.messageQueue)
+		}
+		close(service.messageQueue)
+		wait.Wait()
+	}
+}
+
+func entityKeyOf(eid common.EntityID) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(eid))
+	return h.Sum32()
+}
+
+func TestWorkerKeyOf(t *testing.T) {
+	eid := common.GenEntityID()
+	dcp := &dispatcherClientProxy{gateid: 7}
+	for _, c := range []struct {
+		msgtype proto.MsgType
+		prefix  int // bytes before the entity ID
+	}{
+		{proto.MT_CALL_ENTITY_METHOD, 0},
+		{proto.MT_CALL_ENTITY_METHOD_WITH_SEQ, 0},
+		{proto.MT_NOTIFY_CREATE_ENTITY, 0},
+		{proto.MT_REAL_MIGRATE, 0},
+		{proto.MT_LOAD_ENTITY_SOMEWHERE, 2},
+		{proto.MT_CREATE_ENTITY_SOMEWHERE, 2},
+		{proto.MT_SYNC_SERVICE_SNAPSHOT, 4},
+	} {
+		pkt := netutil.NewPacket()
+		for i := 0; i < c.prefix; i++ {
+			pkt.AppendByte(0xff)
+		}
+		pkt.AppendEntityID(eid)
+		key, ok := workerKeyOf(dcp, c.msgtype, pkt)
+		if !ok || key != entityKeyOf(eid) {
+			t.Errorf("msgtype %d should be keyed by the entity ID after %d bytes", c.msgtype, c.prefix)
+		}
+
+		// truncated payload is handled by the main routine
+		truncated := netutil.NewPacket()
+		truncated.AppendBytes(pkt.Payload()[:c.prefix+common.ENTITYID_LENGTH-1])
+		if _, ok := workerKeyOf(dcp, c.msgtype, truncated); ok {
+			t.Errorf("truncated msgtype %d should not be handled by workers", c.msgtype)
+		}
+		pkt.Release()
+		truncated.Release()
+	}
+
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(3)
+	for _, msgtype := range []proto.MsgType{proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY, proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START + 1} {
+		if key, ok := workerKeyOf(dcp, msgtype, pkt); !ok || key != 3 {
+			t.Errorf("msgtype %d should be keyed by the gate ID in payload", msgtype)
+		}
+	}
+	if key, ok := workerKeyOf(dcp, proto.MT_SYNC_POSITION_YAW_FROM_CLIENT, pkt); !ok || key != 7 {
+		t.Errorf("positions from clients should be keyed by the gate of the connection")
+	}
+	if _, ok := workerKeyOf(dcp, proto.MT_SET_GAME_ID, pkt); ok {
+		t.Errorf("MT_SET_GAME_ID should be handled by the main routine")
+	}
+	pkt.Release()
+}
+
+func TestWorkersEntityOrdering(t *testing.T) {
+	service := newTestService()
+	dcp := &dispatcherClientProxy{}
+
+	eids := make([]common.EntityID, 20)
+	for i := range eids {
+		eids[i] = common.GenEntityID()
+	}
+
+	var lock sync.Mutex
+	received := map[common.EntityID][]uint32{}
+	workerOf := map[common.EntityID]int{}
+	var handled, barrierViolations int64
+	posted := int64(0) // worker messages posted before the main routine message being handled
+	stop := serveTestQueues(service, func(worker int, msg dispatcherMessage) {
+		if worker < 0 {
+			if atomic.LoadInt64(&handled) != atomic.LoadInt64(&posted) {
+				atomic.AddInt64(&barrierViolations, 1)
+			}
+			return
+		}
+
+		time.Sleep(time.Microsecond * time.Duration(worker)) // workers run at different speeds
+		eid := msg.Packet.ReadEntityID()
+		seq := msg.Packet.ReadUint32()
+		lock.Lock()
+		received[eid] = append(received[eid], seq)
+		if w, ok := workerOf[eid]; ok && w != worker {
+			t.Errorf("entity %s is handled by worker %d and %d", eid, w, worker)
+		}
+		workerOf[eid] = worker
+		lock.Unlock()
+		atomic.AddInt64(&handled, 1)
+	})
+
+	const rounds = 50
+	for seq := uint32(0); seq < rounds; seq++ {
+		for _, eid := range eids {
+			pkt := netutil.NewPacket()
+			pkt.AppendEntityID(eid)
+			pkt.AppendUint32(seq)
+			atomic.AddInt64(&posted, 1)
+			service.postMessage(dcp, proto.MT_CALL_ENTITY_METHOD, pkt)
+		}
+		if seq%10 == 0 {
+			// messages to the main routine wait for earlier messages of the connection handled by workers
+			service.postMessage(dcp, proto.MT_SET_GAME_ID, netutil.NewPacket())
+		}
+	}
+	stop()
+
+	if barrierViolations > 0 {
+		t.Errorf("%d messages are handled by the main routine before earlier messages handled by workers", barrierViolations)
+	}
+	for _, eid := range eids {
+		seqs := received[eid]
+		if len(seqs) != rounds {
+			t.Fatalf("entity %s received %d messages, expected %d", eid, len(seqs), rounds)
+		}
+		for i, seq := range seqs {
+			if seq != uint32(i) {
+				t.Fatalf("messages of entity %s are reordered: %v", eid, seqs)
+			}
+		}
+	}
+}
+
+func TestWorkersCallFilteredClients(t *testing.T) {
+	service := newTestService()
+	dcp := &dispatcherClientProxy{}
+
+	var lock sync.Mutex
+	workers := map[int]int{}
+	stop := serveTestQueues(service, func(worker int, msg dispatcherMessage) {
+		if msg.MsgType == proto.MT_CALL_FILTERED_CLIENTS {
+			lock.Lock()
+			workers[worker] += 1
+			lock.Unlock()
+		}
+	})
+
+	pkt := netutil.NewPacket()
+	pkt.AppendBytes(make([]byte, 1024)) // large packets are returned to buffer pools when released
+	payloadCap := pkt.PayloadCap()
+	pkt.AddRefCount(1) // held by the test
+	service.postMessage(dcp, proto.MT_CALL_FILTERED_CLIENTS, pkt)
+	service.postMessage(dcp, proto.MT_SET_GAME_ID, netutil.NewPacket()) // waits until all workers handled the packet
+	stop()
+
+	if len(workers) != _TEST_WORKERS {
+		t.Fatalf("MT_CALL_FILTERED_CLIENTS should be handled by all %d workers: %v", _TEST_WORKERS, workers)
+	}
+	for w, n := range workers {
+		if n != 1 {
+			t.Fatalf("worker %d handled MT_CALL_FILTERED_CLIENTS %d times", w, n)
+		}
+	}
+	if pkt.PayloadCap() != payloadCap {
+		t.Fatalf("packet is released by workers while held by the test")
+	}
+	pkt.Release()
+	if pkt.PayloadCap() == payloadCap {
+		t.Fatalf("packet is not released after released by all workers")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -21,10 +22,13 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwvar"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/kvreg"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 const (
@@ -44,16 +48,22 @@ type GameService struct {
 	packetQueue                    chan proto.Message
 	runState                       xnsyncutil.AtomicInt
 	nextCollectEntitySyncInfosTime time.Time
+	nextExportSpaceStatsTime       time.Time
+	nextReconcileEntitiesTime      time.Time
+	nextCheckSimulationLODTime     time.Time
 	dispatcherStartFreezeAcks      []bool
 	positionSyncInterval           time.Duration
 	ticker                         <-chan time.Time
 	onlineGames                    common.Uint16Set
 	isDeploymentReady              bool
+	draining                       bool
+	drainDeadline                  time.Time
+	nextDrainCheckTime             time.Time
 }
 
 func newGameService(gameid uint16) *GameService {
-	//cfg := config.GetGame(gameid)
-	return &GameService{
+	//cfg := conf.GetGame(gameid)
+	gs := &GameService{
 		id: gameid,
 		//registeredServices: map[string]common.EntityIDSet{},
 		packetQueue: make(chan proto.Message, consts.GAME_SERVICE_PACKET_QUEUE_SIZE),
@@ -65,24 +75,78 @@ func newGameService(gameid uint16) *GameService {
 		//collectEntitySyncInfosRequest: make(chan struct{}),
 		//collectEntitySycnInfosReply:   make(chan interface{}),
 	}
+
+	metrics.NewGaugeFunc("goworld_game_queue_length", "Packets waiting to be handled by game", func() float64 {
+		return float64(len(gs.packetQueue))
+	})
+	return gs
 }
 
 func (gs *GameService) run() {
 	gs.runState.Store(rsRunning)
 	binutil.PrintSupervisorTag(consts.GAME_STARTED_TAG)
+	binutil.NotifyReady()
 	gwutils.RepeatUntilPanicless(gs.serveRoutine)
 }
 
-func (gs *GameService) serveRoutine() {
-	cfg := config.GetGame(gameid)
-	gs.config = cfg
-	gs.positionSyncInterval = time.Millisecond * time.Duration(cfg.PositionSyncIntervalMS)
+func (gs *GameService) setPositionSyncInterval(interval time.Duration) {
+	gs.positionSyncInterval = interval
 	if gs.positionSyncInterval < consts.GAME_SERVICE_TICK_INTERVAL {
 		gwlog.Warnf("%s: entity position sync interval is too small: %s, so reset to %s", gs, gs.positionSyncInterval, consts.GAME_SERVICE_TICK_INTERVAL)
 		gs.positionSyncInterval = consts.GAME_SERVICE_TICK_INTERVAL
 	}
+}
+
+// onConfigChanged hot-applies changes of game config which are safe to apply without restarting
+func (gs *GameService) onConfigChanged(oldCfg, newCfg *config.GameConfig) {
+	gs.config = newCfg
+	if newCfg.LogLevel != oldCfg.LogLevel && logLevel == "" { // log level specified by -log is not changed
+		gwlog.Infof("%s: log level changed to %s", gs, newCfg.LogLevel)
+		gwlog.SetLevels(newCfg.LogLevel)
+	}
+	if newCfg.SaveInterval != oldCfg.SaveInterval {
+		entity.SetSaveInterval(newCfg.SaveInterval)
+	}
+	if newCfg.PositionSyncIntervalMS != oldCfg.PositionSyncIntervalMS {
+		gs.setPositionSyncInterval(time.Millisecond * time.Duration(newCfg.PositionSyncIntervalMS))
+		gwlog.Infof("%s: position sync interval changed to %s", gs, gs.positionSyncInterval)
+	}
+	if newCfg.AttrPatchSync != oldCfg.AttrPatchSync || newCfg.AttrPatchPrecision != oldCfg.AttrPatchPrecision {
+		entity.SetAttrPatchSync(newCfg.AttrPatchSync, float32(newCfg.AttrPatchPrecision))
+	}
+}
+
+// startPacketSpan starts the span of handling the packet if the packet is traced, and makes it the current span, so
+// that entity methods called by the packet are traced in the same trace
+func startPacketSpan(msgtype proto.MsgType, pkt *netutil.Packet) *tracing.Span {
+	parent := pkt.TraceContext()
+	if !parent.IsValid() {
+		tracing.SetCurrent(parent)
+		return nil
+	}
+
+	span := tracing.StartSpan("game: handle call", tracing.SpanKindServer, parent)
+	span.SetAttr("goworld.msgtype", msgtype)
+	tracing.SetCurrent(span.Context())
+	return span
+}
+
+func endPacketSpan(span *tracing.Span) {
+	if span != nil {
+		tracing.SetCurrent(tracing.SpanContext{})
+		span.End()
+	}
+}
+
+func (gs *GameService) serveRoutine() {
+	cfg := conf.GetGame(gameid)
+	gs.config = cfg
+	gs.setPositionSyncInterval(time.Millisecond * time.Duration(cfg.PositionSyncIntervalMS))
 
 	gwlog.Infof("Read game %d config: \n%s\n", gameid, config.DumpPretty(cfg))
+	gs.nextExportSpaceStatsTime = time.Now().Add(consts.SPACE_STATS_EXPORT_INTERVAL)
+	gs.nextReconcileEntitiesTime = time.Now().Add(consts.ENTITY_RECONCILE_INTERVAL)
+	gs.nextCheckSimulationLODTime = time.Now().Add(consts.SIMULATION_LOD_CHECK_INTERVAL)
 
 	// here begins the main loop of Game
 	for {
@@ -90,20 +154,66 @@ func (gs *GameService) serveRoutine() {
 		select {
 		case item := <-gs.packetQueue:
 			msgtype, pkt := item.MsgType, item.Packet
+			span := startPacketSpan(msgtype, pkt)
 			switch msgtype {
 			case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
 				gs.HandleSyncPositionYawFromClient(pkt)
+			case proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT:
+				gs.HandleSyncPositionYawWithSeqFromClient(pkt)
+			case proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT:
+				eid := pkt.ReadEntityID()
+				seq := pkt.ReadUint32()
+				method := pkt.ReadVarStr()
+				args := pkt.ReadArgs()
+				clientid := pkt.ReadClientID()
+				entity.OnCallFromClientWithSeq(eid, method, args, clientid, seq)
 			case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
 				eid := pkt.ReadEntityID()
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				clientid := pkt.ReadClientID()
 				gs.HandleCallEntityMethod(eid, method, args, clientid)
+			case proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT:
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				typedArgs := pkt.ReadVarBytes()
+				clientid := pkt.ReadClientID()
+				entity.OnCallFromClientWithTypedArgs(eid, method, typedArgs, clientid)
 			case proto.MT_CALL_ENTITY_METHOD:
 				eid := pkt.ReadEntityID()
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				gs.HandleCallEntityMethod(eid, method, args, "")
+			case proto.MT_CALL_ENTITY_METHOD_WITH_SEQ:
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				args := pkt.ReadArgs()
+				src := proto.RPCSource{GameID: pkt.ReadUint16(), DispatcherID: pkt.ReadUint16(), Epoch: pkt.ReadUint32()}
+				seq := pkt.ReadUint64()
+				ackFloor := pkt.ReadUint64()
+				gs.HandleCallEntityMethodWithSeq(eid, method, args, src, seq, ackFloor)
+			case proto.MT_ACK_ENTITY_RPCS:
+				_ = pkt.ReadUint16() // gameid
+				dispid := pkt.ReadUint16()
+				seqs := make([]uint64, pkt.ReadUint32())
+				for i := range seqs {
+					seqs[i] = pkt.ReadUint64()
+				}
+				dispatchercluster.AckEntityRPCs(dispid, seqs)
+			case proto.MT_CALL_ENTITY_METHOD_WITH_RESULT:
+				eid := pkt.ReadEntityID()
+				srcGameID := pkt.ReadUint16()
+				callID := pkt.ReadUint32()
+				method := pkt.ReadVarStr()
+				args := pkt.ReadArgs()
+				entity.OnCallWithResult(eid, method, args, srcGameID, callID)
+			case proto.MT_CALL_ENTITY_METHOD_RESULT:
+				_ = pkt.ReadUint16() // gameid
+				callID := pkt.ReadUint32()
+				code := proto.RPCErrorCode(pkt.ReadUint16())
+				message := pkt.ReadVarStr()
+				results := pkt.ReadArgs()
+				entity.OnCallResult(callID, code, message, results)
 			case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE_ACK:
 				gs.HandleQuerySpaceGameIDForMigrateAck(pkt)
 			case proto.MT_MIGRATE_REQUEST_ACK:
@@ -119,6 +229,16 @@ func (gs *GameService) serveRoutine() {
 				eid := pkt.ReadEntityID()
 				clientid := pkt.ReadClientID()
 				gs.HandleNotifyClientDisconnected(eid, clientid)
+			case proto.MT_NOTIFY_CLIENT_RESUMED:
+				eid := pkt.ReadEntityID()
+				clientid := pkt.ReadClientID()
+				gs.HandleNotifyClientResumed(eid, clientid, 0, 0)
+			case proto.MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION:
+				eid := pkt.ReadEntityID()
+				clientid := pkt.ReadClientID()
+				gameid := pkt.ReadUint16()
+				syncVersion := pkt.ReadUint64()
+				gs.HandleNotifyClientResumed(eid, clientid, gameid, syncVersion)
 			case proto.MT_LOAD_ENTITY_SOMEWHERE:
 				_ = pkt.ReadUint16()
 				eid := pkt.ReadEntityID()
@@ -131,11 +251,37 @@ func (gs *GameService) serveRoutine() {
 				var data map[string]interface{}
 				pkt.ReadData(&data)
 				gs.HandleCreateEntitySomewhere(entityid, typeName, data)
+			case proto.MT_CREATE_ENTITY_ANYWHERE_QUEUED:
+				entityid := pkt.ReadEntityID()
+				typeName := pkt.ReadVarStr()
+				_ = pkt.ReadUint32() // priority
+				_ = pkt.ReadUint32() // timeout
+				var data map[string]interface{}
+				pkt.ReadData(&data)
+				gs.HandleCreateEntitySomewhere(entityid, typeName, data)
+			case proto.MT_CREATE_ENTITY_REJECTED:
+				entityid := pkt.ReadEntityID()
+				reason := pkt.ReadVarStr()
+				entity.OnCreateEntityRejected(entityid, reason)
+			case proto.MT_AUTO_MIGRATE_ENTITIES:
+				targetGameID := pkt.ReadUint16()
+				count := int(pkt.ReadUint32())
+				entity.AutoMigrateEntities(targetGameID, count)
 			case proto.MT_CALL_NIL_SPACES:
 				_ = pkt.ReadUint16() // ignore except gameid
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				gs.HandleCallNilSpaces(method, args)
+			case proto.MT_PUBLISH_CHANNEL:
+				channel := pkt.ReadVarStr()
+				toClients := pkt.ReadBool()
+				method := pkt.ReadVarStr()
+				args := pkt.ReadArgs()
+				entity.OnPublish(channel, toClients, method, args)
+			case proto.MT_CLAIM_CRON_JOB:
+				name := pkt.ReadVarStr()
+				runTime := int64(pkt.ReadUint64())
+				crontab.OnCronJobGranted(name, runTime)
 			case proto.MT_KVREG_REGISTER:
 				gs.HandleKvregRegister(pkt)
 			case proto.MT_NOTIFY_GATE_DISCONNECTED:
@@ -152,10 +298,15 @@ func (gs *GameService) serveRoutine() {
 				gs.handleNotifyDeploymentReady(pkt)
 			case proto.MT_SET_GAME_ID_ACK:
 				gs.handleSetGameIDAck(pkt)
+			case proto.MT_DESTROY_STALE_ENTITIES:
+				gs.handleDestroyStaleEntities(pkt)
+			case proto.MT_SYNC_SERVICE_SNAPSHOT:
+				gs.handleSyncServiceSnapshot(pkt)
 			default:
 				gwlog.TraceError("unknown msgtype: %v", msgtype)
 			}
 
+			endPacketSpan(span)
 			pkt.Release()
 		case <-gs.ticker:
 			isTick = true
@@ -169,6 +320,8 @@ func (gs *GameService) serveRoutine() {
 			}
 
 			timer.Tick()
+			timerwheel.Tick() // timers of entities
+			entity.TickSave()
 
 			//case <-gs.collectEntitySyncInfosRequest: //
 			//	gs.collectEntitySycnInfosReply <- 1
@@ -177,11 +330,28 @@ func (gs *GameService) serveRoutine() {
 		// after handling packets or firing timers, check the posted functions
 		post.Tick()
 		if isTick {
+			gs.flushRPCAcks()
 			now := time.Now()
 			if !gs.nextCollectEntitySyncInfosTime.After(now) {
 				gs.nextCollectEntitySyncInfosTime = now.Add(gs.positionSyncInterval)
+				entity.FlushAttrPatches()
 				entity.CollectEntitySyncInfos()
 			}
+			if !gs.nextExportSpaceStatsTime.After(now) {
+				gs.nextExportSpaceStatsTime = now.Add(consts.SPACE_STATS_EXPORT_INTERVAL)
+				entity.ExportSpaceStats()
+			}
+			if !gs.nextReconcileEntitiesTime.After(now) {
+				gs.nextReconcileEntitiesTime = now.Add(consts.ENTITY_RECONCILE_INTERVAL)
+				entity.ReconcileEntities()
+			}
+			if !gs.nextCheckSimulationLODTime.After(now) {
+				gs.nextCheckSimulationLODTime = now.Add(consts.SIMULATION_LOD_CHECK_INTERVAL)
+				entity.CheckSimulationLOD()
+			}
+			if gs.draining {
+				gs.checkDrain(now)
+			}
 		}
 	}
 }
@@ -253,7 +423,7 @@ func (gs *GameService) doFreeze() {
 	err := freeze()
 	if err != nil {
 		gwlog.Errorf("Game freeze failed: %s, server has to quit", err)
-		kvdb.Initialize() // restore kvdb module
+		kvdb.Initialize(conf) // restore kvdb module
 		gs.runState.Store(rsRunning)
 		return
 	}
@@ -332,6 +502,17 @@ func (gs *GameService) handleNotifyGameDisconnected(pkt *netutil.Packet) {
 
 	gs.onlineGames.Remove(gameid)
 	gwlog.Infof("%s notify game disconnected: %d online games left", gs, len(gs.onlineGames))
+	service.OnGameDown(gameid)
+}
+
+func (gs *GameService) handleSyncServiceSnapshot(pkt *netutil.Packet) {
+	_ = pkt.ReadUint16() // standby gameid
+	gameid := pkt.ReadUint16()
+	eid := pkt.ReadEntityID()
+	serviceID := pkt.ReadVarStr()
+	var data map[string]interface{}
+	pkt.ReadData(&data)
+	service.OnServiceSnapshot(gameid, eid, serviceID, data)
 }
 
 func (gs *GameService) handleNotifyDeploymentReady(pkt *netutil.Packet) {
@@ -376,6 +557,15 @@ func (gs *GameService) handleSetGameIDAck(pkt *netutil.Packet) {
 	}
 }
 
+func (gs *GameService) handleDestroyStaleEntities(pkt *netutil.Packet) {
+	eidsNum := pkt.ReadUint32()
+	eids := make([]common.EntityID, 0, eidsNum)
+	for i := uint32(0); i < eidsNum; i++ {
+		eids = append(eids, pkt.ReadEntityID())
+	}
+	entity.DestroyStaleEntities(eids)
+}
+
 func (gs *GameService) onDeploymentReady() {
 	if gs.isDeploymentReady {
 		// should never happen, because dispatcher never send deployment ready to a game more than once
@@ -387,6 +577,7 @@ func (gs *GameService) onDeploymentReady() {
 	gwlog.Infof("DEPLOYMENT IS READY!")
 	entity.OnGameReady()
 	service.OnDeploymentReady()
+	crontab.StartCronJobs()
 }
 
 func (gs *GameService) HandleSyncPositionYawFromClient(pkt *netutil.Packet) {
@@ -403,6 +594,22 @@ func (gs *GameService) HandleSyncPositionYawFromClient(pkt *netutil.Packet) {
 	}
 }
 
+// HandleSyncPositionYawWithSeqFromClient handles position & yaw syncs with input sequence numbers from clients
+func (gs *GameService) HandleSyncPositionYawWithSeqFromClient(pkt *netutil.Packet) {
+	payload := pkt.UnreadPayload()
+	payloadLen := len(payload)
+	for i := 0; i < payloadLen; i += proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY + common.ENTITYID_LENGTH {
+		eid := common.EntityID(payload[i : i+common.ENTITYID_LENGTH])
+		data := payload[i+common.ENTITYID_LENGTH : i+common.ENTITYID_LENGTH+proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY]
+		seq := netutil.NETWORK_ENDIAN.Uint32(data[0:4])
+		x := netutil.UnpackFloat32(netutil.NETWORK_ENDIAN, data[4:8])
+		y := netutil.UnpackFloat32(netutil.NETWORK_ENDIAN, data[8:12])
+		z := netutil.UnpackFloat32(netutil.NETWORK_ENDIAN, data[12:16])
+		yaw := netutil.UnpackFloat32(netutil.NETWORK_ENDIAN, data[16:20])
+		entity.OnSyncPositionYawWithSeqFromClient(eid, seq, entity.Coord(x), entity.Coord(y), entity.Coord(z), entity.Yaw(yaw))
+	}
+}
+
 func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method string, args [][]byte, clientid common.ClientID) {
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleCallEntityMethod: %s.%s(%v)", gs, entityID, method, args)
@@ -410,6 +617,21 @@ func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method s
 	entity.OnCall(entityID, method, args, clientid)
 }
 
+// HandleCallEntityMethodWithSeq handles entity RPC with sequence number, RPCs replayed after reconnection are dropped
+func (gs *GameService) HandleCallEntityMethodWithSeq(entityID common.EntityID, method string, args [][]byte, src proto.RPCSource, seq uint64, ackFloor uint64) {
+	if consts.DEBUG_PACKETS {
+		gwlog.Debugf("%s.HandleCallEntityMethodWithSeq: %s.%s(%v), seq=%d", gs, entityID, method, args, seq)
+	}
+	entity.OnCallWithSeq(entityID, method, args, src, seq, ackFloor)
+}
+
+// flushRPCAcks acks entity RPCs received since the last tick to sending games
+func (gs *GameService) flushRPCAcks() {
+	for src, seqs := range entity.TakeRPCAcks() {
+		dispatchercluster.SendAckEntityRPCs(src, seqs)
+	}
+}
+
 func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, bootEid common.EntityID, gateid uint16) {
 	client := entity.MakeGameClient(clientid, gateid)
 	if consts.DEBUG_PACKETS {
@@ -438,6 +660,16 @@ func (gs *GameService) HandleNotifyClientDisconnected(ownerID common.EntityID, c
 	entity.OnClientDisconnected(ownerID, clientid)
 }
 
+// HandleNotifyClientResumed handles the resumed session of the client, which is disconnected transiently
+//
+// syncVersion is the last sync version delivered to the client by the game, or 0 if unknown.
+func (gs *GameService) HandleNotifyClientResumed(ownerID common.EntityID, clientid common.ClientID, gameid uint16, syncVersion uint64) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s.handleNotifyClientResumed: %s.%s, sync version %d of game%d", gs, ownerID, clientid, syncVersion, gameid)
+	}
+	entity.OnClientResumed(ownerID, clientid, gameid, syncVersion)
+}
+
 func (gs *GameService) HandleQuerySpaceGameIDForMigrateAck(pkt *netutil.Packet) {
 	spaceid := pkt.ReadEntityID()
 	entityid := pkt.ReadEntityID()
@@ -469,7 +701,7 @@ func (gs *GameService) terminate() {
 }
 
 func (gs *GameService) startFreeze() {
-	dispatcherNum := len(config.GetDispatcherIDs())
+	dispatcherNum := len(conf.GetDispatcherIDs())
 	gs.dispatcherStartFreezeAcks = make([]bool, dispatcherNum)
 	dispatchercluster.SendStartFreezeGame()
 }
@@ -0,0 +1,94 @@
+package game
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/service"
+)
+
+const (
+	_ADMIN_DEFAULT_LIST_LIMIT = 1000
+)
+
+// setupAdminHandlers registers admin handlers of the game
+//
+//	GET  /admin/entities?type=Avatar&limit=100  lists entities on the game
+//	GET  /admin/entity?id=...                   dumps attributes, client and AOI of the entity
+//	GET  /admin/space?id=...                    lists entities in the space
+//	GET  /admin/timeline?id=...&since=1h        dumps recent events of the entity, in the last hour if since is set
+//	GET  /admin/types                           lists registered entity types, client methods and services
+//	GET  /admin/callgraph                       dumps calls from entity types to services on the game, with results
+//	GET  /admin/desync                          dumps divergences of client predictions if desync detection is enabled
+//	POST /admin/saveall                         saves all persistent entities on the game
+//	POST /admin/snapshot?id=...                 writes all entities on the game to the snapshot of the cluster
+func setupAdminHandlers() {
+	binutil.RegisterAdminHandler(http.MethodGet, "entities", func(params url.Values) (interface{}, error) {
+		limit := _ADMIN_DEFAULT_LIST_LIMIT
+		if s := params.Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				return nil, errors.Errorf("invalid limit: %s", s)
+			}
+		}
+		entities, total := entity.ListEntities(params.Get("type"), limit)
+		return map[string]interface{}{"total": total, "entities": entities}, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "entity", func(params url.Values) (interface{}, error) {
+		d := entity.InspectEntity(common.EntityID(params.Get("id")))
+		if d == nil {
+			return nil, errors.Errorf("entity %s is not found on game%d", params.Get("id"), gameid)
+		}
+		return d, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "space", func(params url.Values) (interface{}, error) {
+		d := entity.InspectSpace(common.EntityID(params.Get("id")))
+		if d == nil {
+			return nil, errors.Errorf("space %s is not found on game%d", params.Get("id"), gameid)
+		}
+		return d, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "timeline", func(params url.Values) (interface{}, error) {
+		var since time.Duration
+		if s := params.Get("since"); s != "" {
+			var err error
+			if since, err = time.ParseDuration(s); err != nil || since < 0 {
+				return nil, errors.Errorf("invalid since: %s", s)
+			}
+		}
+		events, ok := entity.GetTimeline(common.EntityID(params.Get("id")), since)
+		if !ok {
+			return nil, errors.Errorf("entity %s is not found on game%d", params.Get("id"), gameid)
+		}
+		return map[string]interface{}{"id": params.Get("id"), "events": events}, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "types", func(params url.Values) (interface{}, error) {
+		return getTypeRegistry(), nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "callgraph", func(params url.Values) (interface{}, error) {
+		return map[string]interface{}{"gameid": gameid, "edges": service.GetCallGraph()}, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodGet, "desync", func(params url.Values) (interface{}, error) {
+		return map[string]interface{}{"gameid": gameid, "clients": entity.GetDesyncStats()}, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodPost, "saveall", func(params url.Values) (interface{}, error) {
+		gwlog.Infof("admin: save all entities")
+		entity.SaveAllEntities()
+		return map[string]bool{"ok": true}, nil
+	})
+	binutil.RegisterAdminHandler(http.MethodPost, "snapshot", func(params url.Values) (interface{}, error) {
+		gwlog.Infof("admin: snapshot %s", params.Get("id"))
+		n, err := saveSnapshot(params.Get("id"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"gameid": gameid, "entities": n}, nil
+	})
+}
@@ -0,0 +1,70 @@
+package game
+
+import (
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Graceful drain
+//
+// A draining game tells dispatchers to stop placing new entities on it, migrates its entities to nil spaces of other
+// online games in batches, and terminates gracefully when all entities are migrated or drain_timeout is reached.
+const (
+	_DRAIN_CHECK_INTERVAL = time.Second
+)
+
+// drain starts draining the game, it can be called in any goroutine
+func drain() {
+	post.Post(func() {
+		gameService.startDrain()
+	})
+}
+
+func (gs *GameService) startDrain() {
+	if gs.draining || gs.runState.Load() != rsRunning {
+		return
+	}
+
+	deployCfg := conf.GetDeployment()
+	gs.draining = true
+	gs.drainDeadline = time.Now().Add(time.Second * time.Duration(deployCfg.DrainTimeout))
+	gwlog.Infof("%s: start draining, timeout = %ds", gs, deployCfg.DrainTimeout)
+	dispatchercluster.SendSetGameDraining()
+}
+
+func (gs *GameService) checkDrain(now time.Time) {
+	if now.Before(gs.nextDrainCheckTime) {
+		return
+	}
+	gs.nextDrainCheckTime = now.Add(_DRAIN_CHECK_INTERVAL)
+
+	var targetGameIDs []uint16
+	for gameid := range gs.onlineGames {
+		if gameid != gs.id {
+			targetGameIDs = append(targetGameIDs, gameid)
+		}
+	}
+	sort.Slice(targetGameIDs, func(i, j int) bool { return targetGameIDs[i] < targetGameIDs[j] })
+
+	remaining := entity.DrainEntities(targetGameIDs, conf.GetDeployment().DrainBatchSize)
+	if remaining > 0 && now.Before(gs.drainDeadline) {
+		if len(targetGameIDs) == 0 {
+			gwlog.Warnf("%s: draining, but no other games are online, %d entities are waiting", gs, remaining)
+		}
+		return
+	}
+
+	if remaining > 0 {
+		gwlog.Warnf("%s: drain timeout, %d entities are not migrated and will be destroyed", gs, remaining)
+	} else {
+		gwlog.Infof("%s: drained, terminating ...", gs)
+	}
+	gs.draining = false
+	signalChan <- syscall.SIGTERM
+}
@@ -11,8 +11,6 @@ import (
 	// for go tool pprof
 	_ "net/http/pprof"
 
-	"runtime"
-
 	"os/signal"
 
 	"syscall"
@@ -21,7 +19,9 @@ import (
 
 	"context"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/components/game/lbc"
+	"github.com/xiaonanln/goworld/engine/async"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -36,26 +36,51 @@ import (
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/service"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/backup"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 var (
 	gameid          uint16
+	conf            = config.Default() // config of the game, passed to engine packages
 	configFile      string
 	logLevel        string
 	restore         bool
+	snapshotID      string
 	runInDaemonMode bool
+	compatCorpus    bool
+	rpcArgsProto    bool
+	dumpTypes       bool
 	gameService     *GameService
 	signalChan      = make(chan os.Signal, 1)
 	gameCtx         = context.Background()
+	embedded        bool
+	gameStopped     = make(chan struct{}) // closed when the embedded game is terminated or freezed
 )
 
+// Options are the options of running the game server by RunContext
+type Options struct {
+	GameID     uint16
+	Config     *config.Config // replaces the default config if not nil
+	ConfigFile string         // the default config file is used if empty
+	Overrides  []string       // config overrides in the form of section.key=value
+	LogLevel   string         // the log level in config is used if empty
+	Restore    bool           // restore from freezed state
+	Snapshot   string         // restore from the snapshot of the cluster
+}
+
 func parseArgs() {
 	var gameidArg int
 	flag.IntVar(&gameidArg, "gid", 0, "set gameid")
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{Config: conf}, "set", "override config key: -set section.key=value, can be repeated")
 	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&restore, "restore", false, "restore from freezed state")
+	flag.StringVar(&snapshotID, "snapshot", "", "restore from the snapshot of the cluster")
 	flag.BoolVar(&runInDaemonMode, "d", false, "run in daemon mode")
+	flag.BoolVar(&compatCorpus, "compat-corpus", false, "dump protocol compat corpus and exit")
+	flag.BoolVar(&rpcArgsProto, "rpc-args-proto", false, "dump protobuf messages of typed arguments of client RPCs and exit")
+	flag.BoolVar(&dumpTypes, "dump-types", false, "dump registered entity types and services in JSON and exit")
 	flag.Parse()
 	gameid = uint16(gameidArg)
 }
@@ -66,6 +91,19 @@ func parseArgs() {
 func Run() {
 	rand.Seed(time.Now().UnixNano())
 	parseArgs()
+	if compatCorpus {
+		binutil.DumpCompatCorpusAndExit()
+	}
+	if rpcArgsProto {
+		if err := entity.GenerateRPCArgsProto(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if dumpTypes {
+		dumpTypesAndExit()
+	}
 
 	if runInDaemonMode {
 		daemoncontext := binutil.Daemonize()
@@ -73,47 +111,154 @@ func Run() {
 	}
 
 	if configFile != "" {
-		config.SetConfigFile(configFile)
+		conf.SetConfigFile(configFile)
+	}
+	if err := start(); err != nil {
+		gwlog.Errorf("%s", err)
+		os.Exit(1)
 	}
 
+	binutil.SetupService(fmt.Sprintf("goworld_game%d", gameid), func() {
+		signalChan <- syscall.SIGTERM
+	})
+	setupSignals()
+
+	gwlog.Infof("Game service start running ...")
+	gameService.run()
+}
+
+// RunContext runs the game server embedded in another program, and returns after the game is terminated
+//
+// The game is terminated gracefully when ctx is done, or frozen if FreezeGame is called. Signals and the
+// system service manager are left to the host program. The engine keeps its state in package level variables, so
+// only one game can run in a process, and it can not be restarted after returning.
+func RunContext(ctx context.Context, opts Options) error {
+	if embedded || gameService != nil {
+		return errors.Errorf("game is already running in this process")
+	}
+	embedded = true
+
+	gameid, restore, snapshotID, logLevel = opts.GameID, opts.Restore, opts.Snapshot, opts.LogLevel
+	if opts.Config != nil {
+		conf = opts.Config
+		config.SetDefault(conf) // for game code still reading the default config
+	}
+	if opts.ConfigFile != "" {
+		conf.SetConfigFile(opts.ConfigFile)
+	}
+	for _, override := range opts.Overrides {
+		if err := conf.ParseOverride(override); err != nil {
+			return err
+		}
+	}
+
+	gameCtx = ctx
+	if err := start(); err != nil {
+		return err
+	}
+
+	go handleSignals()
+	go func() {
+		select {
+		case <-ctx.Done():
+			signalChan <- syscall.SIGTERM
+		case <-gameStopped:
+		}
+	}()
+
+	gwlog.Infof("Game service start running ...")
+	go gameService.run()
+	<-gameStopped
+	return nil
+}
+
+// FreezeGame freezes the game embedded by RunContext, so that it can be restored by a new process
+func FreezeGame() {
+	signalChan <- binutil.FreezeSignal
+}
+
+// start initializes the engine and the game service
+func start() error {
+	conf.SetLocalNode(config.NodeGame, gameid)
+
 	if gameid <= 0 {
-		gwlog.Errorf("gameid %d is not valid, should be positive", gameid)
-		os.Exit(1)
+		return errors.Errorf("gameid %d is not valid, should be positive", gameid)
+	}
+	if restore && snapshotID != "" {
+		return errors.Errorf("can not restore from both freezed state and snapshot")
 	}
 
-	gameConfig := config.GetGame(gameid)
+	gameConfig := conf.GetGame(gameid)
 	if gameConfig == nil {
-		gwlog.Errorf("game %d's config is not found", gameid)
-		os.Exit(1)
+		return errors.Errorf("game %d's config is not found", gameid)
 	}
 
-	if gameConfig.GoMaxProcs > 0 {
-		gwlog.Infof("SET GOMAXPROCS = %d", gameConfig.GoMaxProcs)
-		runtime.GOMAXPROCS(gameConfig.GoMaxProcs)
-	}
 	if logLevel == "" {
 		logLevel = gameConfig.LogLevel
 	}
-	binutil.SetupGWLog(fmt.Sprintf("game%d", gameid), logLevel, gameConfig.LogFile, gameConfig.LogStderr)
-
+	binutil.SetupGWLog(fmt.Sprintf("game%d", gameid), logLevel, gameConfig.LogFile, gameConfig.LogStderr, gameConfig.LogFormat, gwlog.Rotation{
+		MaxSize:    int64(gameConfig.LogRotateSize) << 20,
+		Interval:   gameConfig.LogRotateInterval,
+		MaxBackups: gameConfig.LogMaxBackups,
+	})
+	binutil.SetupCPU(conf, fmt.Sprintf("game%d", gameid), gameConfig.GoMaxProcs, gameConfig.CPUAffinity, gameConfig.CPUAffinityAuto)
+
+	async.SetWorkerPoolSize(gameConfig.AsyncWorkerPoolSize)
 	gwlog.Infof("Initializing storage ...")
-	storage.Initialize()
+	storage.Initialize(conf)
 	gwlog.Infof("Initializing KVDB ...")
-	kvdb.Initialize()
+	kvdb.Initialize(conf)
 	gwlog.Infof("Initializing crontab ...")
 	crontab.Initialize()
+	if gameConfig.CronTimezone != "" {
+		loc, err := time.LoadLocation(gameConfig.CronTimezone) // validated by config
+		if err != nil {
+			gwlog.Panic(err)
+		}
+		crontab.SetCronJobLocation(loc)
+	}
+
+	if gameid == 1 {
+		// only game1 purges expired trash of entity storage
+		crontab.Register(0, 4, -1, -1, -1, purgeExpiredStorageTrash)
+		// game1 is also the coordinator of storage backups
+		backup.Setup(conf)
+	}
 
 	gwlog.Infof("Setup http server ...")
+	tracingConfig := conf.GetTracing()
+	tracing.Setup(fmt.Sprintf("game%d", gameid), tracingConfig.Endpoint, tracingConfig.SampleRate)
+	binutil.SetupMetrics(fmt.Sprintf("game%d", gameid), gameConfig.Labels, conf.GetTelemetry())
+	binutil.SetupAdminAPI(conf.GetDebug())
+	setupAdminHandlers()
+	binutil.SetupDrainHandler(drain)
 	binutil.SetupHTTPServer(gameConfig.HTTPAddr, nil)
 
 	entity.SetSaveInterval(gameConfig.SaveInterval)
+	entity.SetSensitiveAttrKey(gameConfig.SensitiveAttrKey)
+	entity.SetDefaultAOIImplementation(gameConfig.AOIImplementation, entity.Coord(gameConfig.AOITowerCellSize))
+	entity.SetAttrPatchSync(gameConfig.AttrPatchSync, float32(gameConfig.AttrPatchPrecision))
+	entity.SetResumeDiffSync(gameConfig.ResumeDiffSync, gameid)
+	entity.SetDesyncDetect(entity.Coord(gameConfig.DesyncDetectThreshold))
 
 	gwlog.Infof("Start game service ...")
 	gameService = newGameService(gameid)
+	conf.OnChange(fmt.Sprintf("game%d", gameid), func(old, new interface{}) {
+		post.Post(func() {
+			gameService.onConfigChanged(old.(*config.GameConfig), new.(*config.GameConfig))
+		})
+	})
+	conf.Watch()
 
 	if !restore {
 		gwlog.Infof("Creating nil space ...")
 		entity.CreateNilSpace(gameid) // create the nil space
+		if snapshotID != "" {
+			gwlog.Infof("Restoring snapshot %s ...", snapshotID)
+			if err := restoreSnapshot(snapshotID); err != nil {
+				gwlog.Fatalf("Restore from snapshot %s failed: %+v", snapshotID, err)
+			}
+		}
 	} else {
 		// restoring from freezed states
 		gwlog.Infof("Restoring freezed entities ...")
@@ -123,74 +268,92 @@ func Run() {
 		}
 	}
 
+	conf.RegisterLocalNode(gameConfig.HTTPAddr)
 	gwlog.Infof("Start dispatchercluster ...")
-	dispatchercluster.Initialize(gameid, dispatcherclient.GameDispatcherClientType, restore, gameConfig.BanBootEntity, &_GameDispatcherClientDelegate{})
+	dispatchercluster.Initialize(conf, gameid, dispatcherclient.GameDispatcherClientType, restore, gameConfig.BanBootEntity, &_GameDispatcherClientDelegate{})
 
-	gamelbc.Initialize(gameCtx, time.Second*1)
-
-	setupSignals()
+	gamelbc.Initialize(gameCtx, time.Second*1, func() int {
+		return len(gameService.packetQueue)
+	})
 
-	service.Setup(gameid)
-	gwlog.Infof("Game service start running ...")
-	gameService.run()
+	service.Setup(conf, gameid)
+	return nil
 }
 
 func setupSignals() {
 	gwlog.Infof("Setup signals ...")
-	signal.Ignore(syscall.Signal(12), syscall.SIGPIPE, syscall.Signal(10))
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, binutil.FreezeSignal)
+	signal.Ignore(syscall.SIGPIPE, syscall.Signal(10))
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, binutil.FreezeSignal, binutil.DrainSignal)
+	go handleSignals()
+}
 
-	go func() {
-		for {
-			sig := <-signalChan
-			if sig == syscall.SIGTERM || sig == syscall.SIGINT {
-				// terminating game ...
-				gwlog.Infof("Terminating game service ...")
-				gameService.terminate()
-				waitGameServiceStateSatisfied(func(rs int) bool {
-					return rs != rsTerminating
-				})
-				if gameService.runState.Load() != rsTerminated {
-					// game service is not terminated successfully, abort
-					gwlog.Errorf("Game service is not terminated successfully, back to running ...")
-					continue
-				}
-
-				waitEntityStorageFinish()
-
-				gwlog.Infof("Game %d shutdown gracefully.", gameid)
-				os.Exit(0)
-			} else if sig == binutil.FreezeSignal {
-				// SIGHUP => dump game and close
-				// freezing game ...
-				gwlog.Infof("Freezing game service ...")
-
-				post.Post(func() {
-					gameService.startFreeze()
-				})
-
-				waitGameServiceStateSatisfied(func(rs int) bool { // wait until not running
-					return rs != rsRunning
-				})
-				waitGameServiceStateSatisfied(func(rs int) bool {
-					return rs != rsFreezing
-				})
-
-				if gameService.runState.Load() != rsFreezed {
-					// game service is not freezed successfully, abort
-					gwlog.Errorf("Game service is not freezed successfully, back to running ...")
-					continue
-				}
-
-				waitEntityStorageFinish()
-
-				gwlog.Infof("Game %d freezed gracefully.", gameid)
-				os.Exit(0)
-			} else {
-				gwlog.Errorf("unexpected signal: %s", sig)
+func handleSignals() {
+	for {
+		sig := <-signalChan
+		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+			// terminating game ...
+			gwlog.Infof("Terminating game service ...")
+			binutil.NotifyStopping()
+			gameService.terminate()
+			waitGameServiceStateSatisfied(func(rs int) bool {
+				return rs != rsTerminating
+			})
+			if gameService.runState.Load() != rsTerminated {
+				// game service is not terminated successfully, abort
+				gwlog.Errorf("Game service is not terminated successfully, back to running ...")
+				continue
+			}
+
+			waitEntityStorageFinish()
+
+			gwlog.Infof("Game %d shutdown gracefully.", gameid)
+			exitGame()
+			return
+		} else if sig == binutil.FreezeSignal {
+			// SIGHUP => dump game and close
+			// freezing game ...
+			gwlog.Infof("Freezing game service ...")
+			binutil.NotifyStopping()
+
+			post.Post(func() {
+				gameService.startFreeze()
+			})
+
+			waitGameServiceStateSatisfied(func(rs int) bool { // wait until not running
+				return rs != rsRunning
+			})
+			waitGameServiceStateSatisfied(func(rs int) bool {
+				return rs != rsFreezing
+			})
+
+			if gameService.runState.Load() != rsFreezed {
+				// game service is not freezed successfully, abort
+				gwlog.Errorf("Game service is not freezed successfully, back to running ...")
+				continue
 			}
+
+			waitEntityStorageFinish()
+
+			gwlog.Infof("Game %d freezed gracefully.", gameid)
+			exitGame()
+			return
+		} else if sig == binutil.DrainSignal {
+			// SIGUSR2 => migrate entities to other games and terminate
+			gwlog.Infof("Draining game service ...")
+			drain()
+		} else {
+			gwlog.Errorf("unexpected signal: %s", sig)
 		}
-	}()
+	}
+}
+
+// exitGame exits the process, or stops RunContext if the game is embedded
+func exitGame() {
+	if embedded {
+		close(gameStopped)
+		return
+	}
+	os.Exit(0)
 }
 
 func waitGameServiceStateSatisfied(s func(rs int) bool) {
@@ -215,6 +378,13 @@ func waitEntityStorageFinish() {
 	gwlog.Infof("*** DB OK ***")
 }
 
+func purgeExpiredStorageTrash() {
+	retention := conf.GetStorage().TrashRetention
+	for _, typeName := range entity.GetPersistentEntityTypes() {
+		storage.PurgeTrash(typeName, retention, nil)
+	}
+}
+
 type _GameDispatcherClientDelegate struct {
 }
 
@@ -9,12 +9,16 @@ import (
 
 	"github.com/shirou/gopsutil/process"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
 
-func Initialize(ctx context.Context, collectInterval time.Duration) {
+// Initialize starts collecting load of the game and reporting to dispatchers, packetBacklog returns the number of
+// packets waiting to be handled by the game
+func Initialize(ctx context.Context, collectInterval time.Duration, packetBacklog func() int) {
 	pid := os.Getpid()
 	p, err := process.NewProcess(int32(pid))
 	if err != nil {
@@ -31,8 +35,13 @@ func Initialize(ctx context.Context, collectInterval time.Duration) {
 			}
 
 			gwlog.Debugf("gamelbc: cpu percent is %.3f%%", pcnt)
-			dispatchercluster.SendGameLBCInfo(proto.GameLBCInfo{
-				CPUPercent: pcnt,
+			backlog := packetBacklog()
+			post.Post(func() { // entities should be counted in the game routine
+				dispatchercluster.SendGameLBCInfo(proto.GameLBCInfo{
+					CPUPercent:    pcnt,
+					EntityCount:   len(entity.Entities()),
+					PacketBacklog: backlog,
+				})
 			})
 		}
 	})
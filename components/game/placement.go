@@ -0,0 +1,28 @@
+package game
+
+import (
+	"math/rand"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// ChooseGameByLabels returns a random online game with all labels of the selector (e.g. "tier:highmem, region:eu"), or
+// 0 if no online game matches
+func ChooseGameByLabels(selector string) uint16 {
+	labels, err := config.ParseLabels(selector)
+	if err != nil {
+		gwlog.Panicf("invalid label selector %q: %s", selector, err)
+	}
+
+	var candidates []uint16
+	for _, gameid := range conf.GetGamesByLabels(labels) {
+		if gameService.onlineGames.Contains(gameid) {
+			candidates = append(candidates, gameid)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"hash/fnv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Snapshots of the cluster
+//
+// `goworld snapshot <id>` requests all games to write states of their entities to the storage by POST
+// /admin/snapshot?id=<id>. Each game writes its entities, and then its manifest, so the snapshot is complete when
+// manifests of all games are written.
+//
+// `goworld restore --snapshot=<id>` starts games with -snapshot=<id>. Each game reads the snapshot and restores its
+// partition of entities before connecting dispatchers, so the snapshot can be restored by a different number of games.
+// Spaces are partitioned by their IDs, and entities are restored with their spaces, or partitioned by their IDs if they
+// are in the nil space. Clients are not restored.
+
+var (
+	snapshotting bool // a snapshot is being written
+)
+
+// saveSnapshot writes states of all entities on the game to the snapshot, and returns the number of entities
+func saveSnapshot(id string) (int, error) {
+	if err := storagecommon.ValidateSnapshotID(id); err != nil {
+		return 0, err
+	}
+	if snapshotting {
+		return 0, errors.Errorf("game%d is writing another snapshot", gameid)
+	}
+
+	st := time.Now()
+	entities, err := entity.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	manifest := &storagecommon.SnapshotManifest{
+		GameID:   gameid,
+		Time:     st.Unix(),
+		Entities: len(entities),
+		Services: service.GetLocalServiceEntities(),
+	}
+	gwlog.Infof("snapshot %s: %d entities and %d services are captured in %s, writing to storage ...", id, len(entities), len(manifest.Services), time.Since(st))
+
+	snapshotting = true
+	pending := len(entities)
+	writeManifest := func() {
+		storage.Save(storagecommon.SnapshotManifestTypeName(id), storagecommon.SnapshotManifestID(gameid), manifest.ToMap(), func() {
+			snapshotting = false
+			gwlog.Infof("snapshot %s: %d entities are written in %s", id, len(entities), time.Since(st))
+		})
+	}
+	for eid, se := range entities {
+		storage.Save(storagecommon.SnapshotTypeName(id), eid, se.ToMap(), func() {
+			pending -= 1
+			if pending == 0 {
+				writeManifest()
+			}
+		})
+	}
+	if pending == 0 {
+		writeManifest()
+	}
+	return len(entities), nil
+}
+
+// restoreSnapshot restores the partition of entities of the game from the snapshot
+func restoreSnapshot(id string) error {
+	st := time.Now()
+	es, err := storage.OpenStorage(conf.GetStorage())
+	if err != nil {
+		return err
+	}
+	defer es.Close()
+
+	manifests, err := storagecommon.ReadSnapshotManifests(es, id)
+	if err != nil {
+		return err
+	}
+	eids, err := es.List(storagecommon.SnapshotTypeName(id))
+	if err != nil {
+		return err
+	}
+
+	numGames := conf.GetDeployment().DesiredGames
+	entities := map[common.EntityID]*storagecommon.SnapshotEntity{}
+	for _, eid := range eids {
+		data, err := es.Read(storagecommon.SnapshotTypeName(id), eid)
+		if err != nil {
+			return err
+		}
+		se, err := storagecommon.ParseSnapshotEntity(data)
+		if err != nil {
+			return errors.Wrapf(err, "read %s failed", eid)
+		}
+		if snapshotPartition(eid, se, numGames) == gameid {
+			entities[eid] = se
+		}
+	}
+
+	if err := entity.RestoreSnapshot(entities); err != nil {
+		return err
+	}
+	for _, manifest := range manifests {
+		service.RestoreServiceEntities(manifest.Services)
+	}
+	gwlog.Infof("snapshot %s of %d games: %d of %d entities are restored in %s", id, len(manifests), len(entities), len(eids), time.Since(st))
+	return nil
+}
+
+// snapshotPartition returns the game restoring the entity, entities are restored on the same game with their spaces
+func snapshotPartition(eid common.EntityID, se *storagecommon.SnapshotEntity, numGames int) uint16 {
+	key := eid
+	if !se.SpaceID.IsNil() {
+		key = se.SpaceID
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return uint16(h.Sum32()%uint32(numGames)) + 1
+}
@@ -0,0 +1,33 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/service"
+)
+
+// typeRegistry lists entity types and services registered by the game, which is dumped by -dump-types and GET /admin/types
+type typeRegistry struct {
+	Types    []*entity.EntityTypeInfo `json:"types"`
+	Services []*service.ServiceInfo   `json:"services"`
+}
+
+func getTypeRegistry() *typeRegistry {
+	return &typeRegistry{
+		Types:    entity.ListEntityTypes(),
+		Services: service.ListServices(),
+	}
+}
+
+func dumpTypesAndExit() {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(getTypeRegistry()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"expvar"
 	"fmt"
 	"github.com/xiaonanln/netconnutil"
 	"net"
 	"time"
 
+	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
@@ -16,6 +18,11 @@ import (
 	"github.com/xiaonanln/goworld/engine/proto"
 )
 
+var (
+	droppedUnreliablePackets = expvar.NewInt("GateDroppedUnreliablePackets")
+	sentDatagrams            = expvar.NewInt("GateSentDatagrams")
+)
+
 type clientSyncInfo struct {
 	EntityID common.EntityID
 	X, Y, Z  float32
@@ -33,28 +40,115 @@ type ClientProxy struct {
 	filterProps    map[string]string
 	clientSyncInfo clientSyncInfo
 	heartbeatTime  time.Time
-	ownerEntityID  common.EntityID // owner entity's ID
+	ownerEntityID  common.EntityID            // owner entity's ID
+	entityTypes    map[common.EntityID]string // types of entities created on the client, for egress accounting
+	floodGuard     *floodGuard
+	scoring        *connScoring               // nil if connections are not scored
+	verdict        *netutil.ConnectionVerdict // the last verdict which is not allow of the connection scorer
+
+	pendingSessionChallenge   string // challenge sent to the client but not answered yet
+	sessionExpireTime         time.Time
+	nextSessionRotateTime     time.Time
+	noResume                  bool   // the session can not be resumed after the client is closed
+	protocolVersion           uint16 // client protocol version negotiated with the client
+	protocolVersionNegotiated bool   // the version is negotiated when announced or when other messages are received first
+	protocolVersionRejected   bool   // the client is being closed since its version is not supported
+	preferredCodec            string // codec selected if the client supports it
+	codec                     string // codec selected for the client, set by the serve routine
+	sendShardIndex            int    // index of the client in its send worker
+
+	datagramConn net.PacketConn // UDP socket of the KCP session for sending datagrams, nil if the client is not connected by KCP
+	datagramAddr net.Addr
+	channel      *ClientProxy // connection bound by the client for DELIVERY_UNORDERED_RELIABLE messages
+	channelOwner *ClientProxy // the client which this connection is bound to as its channel
+
+	// the last sync version of the game of the owner entity forwarded to the client, which is sent back to the game
+	// when the session is resumed, so that only changes after it are synced
+	syncGameID  uint16
+	syncVersion uint64
+
+	sendOffloading xnsyncutil.AtomicBool // the client is being flushed by an offload worker
 }
 
 func newClientProxy(_conn net.Conn, cfg *config.GateConfig) *ClientProxy {
+	if cfg.WriteTimeout > 0 {
+		_conn = netutil.NewWriteTimeoutConn(_conn, time.Second*time.Duration(cfg.WriteTimeout))
+	}
 	_conn = netconnutil.NewNoTempErrorConn(_conn)
-	var conn netutil.Connection = netutil.NetConn{_conn}
+	var conn netutil.Connection
 	if cfg.CompressConnection {
-		conn = netconnutil.NewSnappyConn(conn)
+		conn = netutil.NewCompressConn(_conn, gateService.compressOptions) // buffered by itself
+	} else {
+		conn = netconnutil.NewBufferedConn(netutil.NetConn{Conn: _conn}, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE)
 	}
-	conn = netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE)
 	gwc := proto.NewGoWorldConnection(conn)
 	return &ClientProxy{
 		GoWorldConnection: gwc,
 		clientid:          common.GenClientID(), // each client has its unique clientid
 		filterProps:       map[string]string{},
+		entityTypes:       map[common.EntityID]string{},
+		floodGuard:        newFloodGuard(cfg),
+		preferredCodec:    cfg.ClientCodec,
 	}
 }
 
+// setDatagramConn lets the client proxy send datagrams to the KCP session of the client by the socket of KCP listener
+func (cp *ClientProxy) setDatagramConn(conn net.PacketConn, addr net.Addr) {
+	cp.datagramConn, cp.datagramAddr = conn, addr
+}
+
 func (cp *ClientProxy) String() string {
 	return fmt.Sprintf("ClientProxy<%s@%s>", cp.clientid, cp.RemoteAddr())
 }
 
+// sendPacketWithDelivery sends the packet to client according to the delivery class, returns false if the packet is dropped
+//
+// Unordered reliable messages are sent on the channel connection if the client binds one, and unreliable messages are
+// sent in datagrams if the client is connected by KCP and supports datagrams. Otherwise they are sent in order with
+// other messages, and unreliable messages are dropped if the connection is congested.
+func (cp *ClientProxy) sendPacketWithDelivery(packet *netutil.Packet, delivery proto.DeliveryClass) bool {
+	switch delivery {
+	case proto.DELIVERY_UNORDERED_RELIABLE:
+		if cp.channel != nil {
+			cp.channel.SendPacket(packet)
+			return true
+		}
+	case proto.DELIVERY_UNRELIABLE:
+		if cp.sendDatagram(packet) {
+			return true
+		}
+		if cp.PendingPacketCount() >= consts.CLIENT_PROXY_CONGESTED_PENDING_PACKETS {
+			droppedUnreliablePackets.Add(1)
+			return false
+		}
+	}
+
+	cp.SendPacket(packet)
+	return true
+}
+
+// sendDatagram sends the packet in a datagram, returns false if the packet can not be sent in datagrams to the client
+func (cp *ClientProxy) sendDatagram(packet *netutil.Packet) bool {
+	if cp.datagramConn == nil || cp.protocolVersion < proto.CLIENT_PROTOCOL_VERSION_DATAGRAM || cp.SendCodec() != nil {
+		return false
+	}
+
+	payload := packet.Payload()
+	if proto.DATAGRAM_HEADER_SIZE+len(payload) > proto.DATAGRAM_MAX_SIZE {
+		return false
+	}
+
+	datagram := proto.AppendDatagram(make([]byte, 0, proto.DATAGRAM_HEADER_SIZE+len(payload)), payload)
+	if _, err := cp.datagramConn.WriteTo(datagram, cp.datagramAddr); err != nil {
+		// the message is lost like datagrams dropped by the network
+		droppedUnreliablePackets.Add(1)
+		gwlog.Debugf("%s: send datagram failed: %s", cp, err)
+		return true
+	}
+	sentDatagrams.Add(1)
+	return true
+}
+
 //func (cp *ClientProxy) SendPacket(packet *netutil.Packet) error {
 //	err := cp.GoWorldConnection.SendPacket(packet)
 //	if err != nil {
@@ -78,14 +172,41 @@ func (cp *ClientProxy) serve() {
 		}
 	}()
 
-	cp.SetAutoFlush(consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL)
+	if sendWorkers := gateService.sendWorkers; sendWorkers != nil {
+		sendWorkers.add(cp)
+		defer sendWorkers.remove(cp)
+	} else {
+		cp.SetAutoFlush(consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL)
+	}
 	//cp.SendSetClientClientID(cp.cp) // set the cp on the client side
+	if gateService.connectionScorer != nil {
+		cp.scoring = newConnScoring(cp, time.Now())
+	}
 
 	for {
 		var msgtype proto.MsgType
 		pkt, err := cp.Recv(&msgtype)
 		if pkt != nil {
-			gateService.clientPacketQueue <- clientProxyMessage{cp, proto.Message{msgtype, pkt}}
+			if cp.scoring != nil && cp.scoring.record(cp, msgtype, int(pkt.GetPayloadLen())) {
+				pkt.Release()
+				cp.noResume = true
+				break
+			}
+			ok, kick := cp.floodGuard.admit(int(pkt.GetPayloadLen()))
+			if ok {
+				if msgtype == proto.MT_SET_CLIENT_CODEC_FROM_CLIENT && !cp.selectCodec(pkt) {
+					pkt.Release()
+					continue
+				}
+				gateService.clientPacketQueue <- clientProxyMessage{cp, proto.Message{msgtype, pkt}}
+			} else {
+				pkt.Release()
+				if kick {
+					cp.noResume = true
+					gwlog.Warnf("%s is kicked for flooding", cp)
+					break
+				}
+			}
 		} else if err != nil && !gwioutil.IsTimeoutError(err) {
 			if netutil.IsConnectionError(err) {
 				break
@@ -95,3 +216,20 @@ func (cp *ClientProxy) serve() {
 		}
 	}
 }
+
+// newCompressOptions returns options of compressing client connections
+func newCompressOptions(cfg *config.GateConfig) netutil.CompressOptions {
+	opts := netutil.CompressOptions{
+		MinBytes:     cfg.CompressMinBytes,
+		MaxEntropy:   cfg.CompressMaxEntropy,
+		SkipMsgTypes: map[uint16]bool{},
+	}
+	for _, name := range cfg.CompressSkipMsgTypes {
+		msgtype, ok := proto.MsgTypeByName(name)
+		if !ok {
+			gwlog.Fatalf("compress_skip_msgtypes: unknown message type %s", name)
+		}
+		opts.SkipMsgTypes[uint16(msgtype)] = true
+	}
+	return opts
+}
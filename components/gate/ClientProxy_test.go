@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// testClientConn is a connection to the client, packets sent to the client are received back from it
+type testClientConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *testClientConn) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+func (c *testClientConn) Read(b []byte) (int, error) {
+	return c.buf.Read(b)
+}
+
+func (c *testClientConn) Flush() error {
+	return nil
+}
+
+func (c *testClientConn) Close() error {
+	return nil
+}
+
+func (c *testClientConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func newTestClientProxy() *ClientProxy {
+	return &ClientProxy{
+		GoWorldConnection: proto.NewGoWorldConnection(&testClientConn{}),
+		clientid:          common.GenClientID(),
+		filterProps:       map[string]string{},
+		entityTypes:       map[common.EntityID]string{},
+		protocolVersion:   proto.CLIENT_PROTOCOL_VERSION,
+	}
+}
+
+func newTestPacket(method string) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_CALL_ENTITY_METHOD_ON_CLIENT)
+	packet.AppendVarStr(method)
+	return packet
+}
+
+// sendWithDelivery sends the packet of the method with the delivery class to the client
+func sendWithDelivery(cp *ClientProxy, method string, delivery proto.DeliveryClass) bool {
+	packet := newTestPacket(method)
+	defer packet.Release()
+	return cp.sendPacketWithDelivery(packet, delivery)
+}
+
+// recvFromGate returns methods of packets sent to the client
+func recvFromGate(cp *ClientProxy) (methods []string) {
+	cp.Flush("test")
+	for {
+		var msgtype proto.MsgType
+		pkt, err := cp.Recv(&msgtype)
+		if err != nil {
+			return
+		}
+		if msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT {
+			methods = append(methods, pkt.ReadVarStr())
+		}
+		pkt.Release()
+	}
+}
+
+// listenDatagrams lets the client proxy send datagrams to the returned socket
+func listenDatagrams(t *testing.T, cp *ClientProxy) (clientConn net.PacketConn, gateConn net.PacketConn) {
+	var err error
+	if clientConn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if gateConn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	cp.setDatagramConn(gateConn, clientConn.LocalAddr())
+	return
+}
+
+func recvDatagram(t *testing.T, conn net.PacketConn) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, proto.DATAGRAM_MAX_SIZE)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgtype, packet, ok := proto.ParseDatagram(buf[:n])
+	if !ok || msgtype != proto.MT_CALL_ENTITY_METHOD_ON_CLIENT {
+		t.Fatalf("invalid datagram: %v", buf[:n])
+	}
+	defer packet.Release()
+	return packet.ReadVarStr()
+}
+
+func TestSendOrderedReliable(t *testing.T) {
+	cp, channel := newTestClientProxy(), newTestClientProxy()
+	(&GateService{}).bindClientChannel(cp, channel)
+	clientConn, gateConn := listenDatagrams(t, cp)
+	defer clientConn.Close()
+	defer gateConn.Close()
+
+	for _, method := range []string{"A", "B", "C"} {
+		if !sendWithDelivery(cp, method, proto.DELIVERY_ORDERED_RELIABLE) {
+			t.Fatalf("ordered reliable message should not be dropped")
+		}
+	}
+	if methods := recvFromGate(cp); len(methods) != 3 || methods[0] != "A" || methods[1] != "B" || methods[2] != "C" {
+		t.Fatalf("ordered reliable messages should be sent in order on the client connection: %v", methods)
+	}
+	if methods := recvFromGate(channel); len(methods) != 0 {
+		t.Fatalf("ordered reliable messages should not be sent on the channel: %v", methods)
+	}
+}
+
+func TestSendUnorderedReliable(t *testing.T) {
+	cp := newTestClientProxy()
+	sendWithDelivery(cp, "A", proto.DELIVERY_UNORDERED_RELIABLE)
+	if methods := recvFromGate(cp); len(methods) != 1 || methods[0] != "A" {
+		t.Fatalf("unordered reliable messages should be sent on the client connection without channel: %v", methods)
+	}
+
+	channel := newTestClientProxy()
+	(&GateService{}).bindClientChannel(cp, channel)
+	if channel.channelOwner != cp || !channel.noResume {
+		t.Fatalf("channel should be bound to the client")
+	}
+	sendWithDelivery(cp, "B", proto.DELIVERY_ORDERED_RELIABLE)
+	sendWithDelivery(cp, "C", proto.DELIVERY_UNORDERED_RELIABLE)
+	if methods := recvFromGate(cp); len(methods) != 1 || methods[0] != "B" {
+		t.Fatalf("only ordered messages should be sent on the client connection: %v", methods)
+	}
+	if methods := recvFromGate(channel); len(methods) != 1 || methods[0] != "C" {
+		t.Fatalf("unordered reliable messages should be sent on the channel: %v", methods)
+	}
+
+	(&GateService{}).unbindClientChannel(channel)
+	sendWithDelivery(cp, "D", proto.DELIVERY_UNORDERED_RELIABLE)
+	if methods := recvFromGate(cp); len(methods) != 1 || methods[0] != "D" {
+		t.Fatalf("unordered reliable messages should be sent on the client connection after the channel is closed: %v", methods)
+	}
+}
+
+func TestSendUnreliable(t *testing.T) {
+	cp := newTestClientProxy()
+	clientConn, gateConn := listenDatagrams(t, cp)
+	defer clientConn.Close()
+	defer gateConn.Close()
+
+	if !sendWithDelivery(cp, "A", proto.DELIVERY_UNRELIABLE) {
+		t.Fatalf("unreliable message should be sent in datagram")
+	}
+	if method := recvDatagram(t, clientConn); method != "A" {
+		t.Fatalf("wrong method in datagram: %s", method)
+	}
+	if methods := recvFromGate(cp); len(methods) != 0 {
+		t.Fatalf("unreliable messages sent in datagrams should not be sent on the client connection: %v", methods)
+	}
+
+	// clients which do not support datagrams receive unreliable messages on the client connection
+	cp.protocolVersion = proto.CLIENT_PROTOCOL_VERSION_NEGOTIATION
+	sendWithDelivery(cp, "B", proto.DELIVERY_UNRELIABLE)
+	if methods := recvFromGate(cp); len(methods) != 1 || methods[0] != "B" {
+		t.Fatalf("unreliable message should be sent on the client connection: %v", methods)
+	}
+
+	// messages too large for datagrams are sent on the client connection
+	cp.protocolVersion = proto.CLIENT_PROTOCOL_VERSION
+	sendWithDelivery(cp, string(make([]byte, proto.DATAGRAM_MAX_SIZE)), proto.DELIVERY_UNRELIABLE)
+	if methods := recvFromGate(cp); len(methods) != 1 {
+		t.Fatalf("large unreliable message should be sent on the client connection: %v", methods)
+	}
+}
+
+func TestSendUnreliableCongested(t *testing.T) {
+	cp := newTestClientProxy()
+	for i := 0; i < consts.CLIENT_PROXY_CONGESTED_PENDING_PACKETS; i++ {
+		sendWithDelivery(cp, "ordered", proto.DELIVERY_ORDERED_RELIABLE)
+	}
+
+	if sendWithDelivery(cp, "unreliable", proto.DELIVERY_UNRELIABLE) {
+		t.Fatalf("unreliable message should be dropped if the client connection is congested")
+	}
+	if !sendWithDelivery(cp, "reliable", proto.DELIVERY_UNORDERED_RELIABLE) {
+		t.Fatalf("reliable message should not be dropped if the client connection is congested")
+	}
+
+	// datagrams are not affected by the congestion of the client connection
+	clientConn, gateConn := listenDatagrams(t, cp)
+	defer clientConn.Close()
+	defer gateConn.Close()
+	if !sendWithDelivery(cp, "datagram", proto.DELIVERY_UNRELIABLE) || recvDatagram(t, clientConn) != "datagram" {
+		t.Fatalf("unreliable message should be sent in datagram")
+	}
+}
@@ -7,10 +7,15 @@ import (
 	"golang.org/x/net/websocket"
 
 	"net"
+	"net/http"
+	"strconv"
 
 	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 
 	"path"
+	"runtime"
 
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
@@ -19,15 +24,22 @@ import (
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/tracing"
 	"github.com/xtaci/kcp-go"
 )
 
+var (
+	connectedClients = metrics.NewGauge("goworld_gate_connected_clients", "Number of clients connected to gate")
+)
+
 type clientProxyMessage struct {
 	cp  *ClientProxy
 	msg proto.Message
@@ -43,16 +55,41 @@ type GateService struct {
 
 	filterTrees             map[string]*_FilterTree
 	pendingSyncPackets      []*netutil.Packet
+	pendingInputSyncPackets []*netutil.Packet // sync packets with input sequence numbers, created when needed
 	nextFlushSyncTime       time.Time
 	terminating             xnsyncutil.AtomicBool
 	terminated              *xnsyncutil.OneTimeCond
+	draining                xnsyncutil.AtomicBool
+	drainDeadline           time.Time
+	drainFinished           bool
 	tlsConfig               *tls.Config
+	kcpConn                 net.PacketConn // UDP socket of the KCP listener, which also sends datagrams to KCP clients
 	checkHeartbeatsInterval time.Duration
 	positionSyncInterval    time.Duration
+	syncPositionPrecision   float32 // positions are quantized for clients supporting it if positive
+	admissionLimiter        *admissionLimiter
+	reconnectBackoff        time.Duration
+	sessionSecret           []byte
+	sessionTokenTTL         time.Duration
+	sessionResumeTimeout    time.Duration
+	detachedClients         map[common.ClientID]*detachedClient
+	nextCheckSessionTime    time.Time
+	stuckConnectionTimeout  time.Duration // clients are closed if packets sent to them are not written in time if positive
+	nextCheckStuckTime      time.Time
+	// clients of protocol versions lower than minClientProtocolVersion are rejected
+	minClientProtocolVersion uint16
+	maxClientProtocolVersion uint16
+	clientUpdateURL          string             // sent to rejected clients
+	sendWorkers              *clientSendWorkers // flushes packets sent to clients if send_workers is configured
+	compressOptions          netutil.CompressOptions
+
+	// client connections are scored by their signals periodically if connection_scorer is configured
+	connectionScorer        netutil.ConnectionScorer
+	connectionScoreInterval time.Duration
 }
 
 func newGateService() *GateService {
-	dispIds := config.GetDispatcherIDs()
+	dispIds := conf.GetDispatcherIDs()
 	pendingSyncPackets := make([]*netutil.Packet, len(dispIds)) // one packet for each dispatcher
 	for i := range pendingSyncPackets {
 		pkt := netutil.NewPacket()
@@ -60,29 +97,96 @@ func newGateService() *GateService {
 		pendingSyncPackets[i] = pkt
 	}
 
-	return &GateService{
+	gs := &GateService{
 		//dispatcherClientPacketQueue: make(chan packetQueueItem, consts.DISPATCHER_CLIENT_PACKET_QUEUE_SIZE),
 		clientProxies:               map[common.ClientID]*ClientProxy{},
 		dispatcherClientPacketQueue: make(chan proto.Message, consts.GATE_SERVICE_PACKET_QUEUE_SIZE),
 		clientPacketQueue:           make(chan clientProxyMessage, consts.GATE_SERVICE_PACKET_QUEUE_SIZE),
 		ticker:                      time.Tick(consts.GATE_SERVICE_TICK_INTERVAL),
 		filterTrees:                 map[string]*_FilterTree{},
+		detachedClients:             map[common.ClientID]*detachedClient{},
 		pendingSyncPackets:          pendingSyncPackets,
+		pendingInputSyncPackets:     make([]*netutil.Packet, len(dispIds)),
 		terminated:                  xnsyncutil.NewOneTimeCond(),
 	}
+
+	metrics.NewGaugeFunc("goworld_gate_dispatcher_queue_length", "Packets from dispatchers waiting to be handled by gate", func() float64 {
+		return float64(len(gs.dispatcherClientPacketQueue))
+	})
+	metrics.NewGaugeFunc("goworld_gate_client_queue_length", "Packets from clients waiting to be handled by gate", func() float64 {
+		return float64(len(gs.clientPacketQueue))
+	})
+	return gs
 }
 
 func (gs *GateService) run() {
-	cfg := config.GetGate(args.gateid)
+	cfg := conf.GetGate(args.gateid)
 	gwlog.Infof("Compress connection: %v, encrypt connection: %v", cfg.CompressConnection, cfg.EncryptConnection)
+	if cfg.CompressConnection {
+		gs.compressOptions = newCompressOptions(cfg)
+		gwlog.Infof("%s: compress data of at least %d bytes and entropy up to %v, skipping %v", gs, cfg.CompressMinBytes, cfg.CompressMaxEntropy, cfg.CompressSkipMsgTypes)
+	}
+
+	gs.admissionLimiter = newAdmissionLimiter(cfg.AcceptRate)
+	gs.reconnectBackoff = time.Millisecond * time.Duration(cfg.ReconnectBackoffMS)
+	gwlog.Infof("%s: accept rate = %d, reconnect backoff = %s", gs, cfg.AcceptRate, gs.reconnectBackoff)
+
+	if cfg.SessionTokenTTL > 0 {
+		gs.sessionSecret = []byte(cfg.SessionSecret)
+		gs.sessionTokenTTL = time.Second * time.Duration(cfg.SessionTokenTTL)
+		gs.sessionResumeTimeout = time.Second * time.Duration(cfg.SessionResumeTimeout)
+		gwlog.Infof("%s: session token TTL = %s, session resume timeout = %s", gs, gs.sessionTokenTTL, gs.sessionResumeTimeout)
+	}
 
-	if cfg.EncryptConnection {
-		gs.setupTLSConfig(cfg)
+	if cfg.ConnectionScorer != "" {
+		gs.connectionScorer = netutil.GetConnectionScorer(cfg.ConnectionScorer)
+		if gs.connectionScorer == nil {
+			gwlog.Fatalf("%s: connection scorer %s is not registered", gs, cfg.ConnectionScorer)
+		}
+		gs.connectionScoreInterval = time.Second * time.Duration(cfg.ConnectionScoreInterval)
+		gwlog.Infof("%s: client connections are scored by %s every %s", gs, cfg.ConnectionScorer, gs.connectionScoreInterval)
+	}
+
+	if cfg.SendWorkers > 0 {
+		gs.sendWorkers = newClientSendWorkers(cfg.SendWorkers, consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL, cfg.SendOffloadWorkers, cfg.SendOffloadMinBytes)
+		gwlog.Infof("%s: packets sent to clients are flushed by %d send workers and %d offload workers", gs, cfg.SendWorkers, cfg.SendOffloadWorkers)
 	}
 
 	gs.listenAddr = cfg.ListenAddr
-	go netutil.ServeTCPForever(gs.listenAddr, gs)
-	go gs.serveKCP(gs.listenAddr)
+	acceptors := cfg.ListenAcceptors
+	if acceptors == 0 {
+		acceptors = runtime.NumCPU()
+	}
+	if acceptors > 1 && !netutil.ReusePortSupported {
+		gwlog.Warnf("%s: SO_REUSEPORT is not supported, listen_acceptors = %d is ignored", gs, acceptors)
+		acceptors = 1
+	}
+	if acceptors > 1 {
+		gwlog.Infof("%s: %d accept loops with SO_REUSEPORT", gs, acceptors)
+		go netutil.ServeTCPReusePortForever(gs.listenAddr, acceptors, gs)
+	} else {
+		go netutil.ServeTCPForever(gs.listenAddr, gs)
+	}
+	if cfg.ListenKCPPort >= 0 {
+		go gs.serveKCP(kcpListenAddr(cfg), cfg)
+	}
+	if cfg.ListenWSPort > 0 {
+		var wsTLSConfig *tls.Config
+		if cfg.EncryptConnection {
+			wsTLSConfig = gs.tlsConfig
+		}
+		go gs.serveWebSocket(wsListenAddr(cfg), cfg.ListenWSPath, wsTLSConfig)
+	}
+	if cfg.FallbackListenAddr != "" {
+		go gs.serveFallback(cfg.FallbackListenAddr, cfg.ListenWSPath)
+	}
+	for name, addr := range cfg.Transports {
+		listen := netutil.GetTransport(name)
+		if listen == nil {
+			gwlog.Fatalf("%s: transport %s is not registered", gs, name)
+		}
+		go gs.serveTransport(name, addr, listen)
+	}
 
 	if cfg.HeartbeatCheckInterval > 0 {
 		gs.checkHeartbeatsInterval = time.Second * time.Duration(cfg.HeartbeatCheckInterval)
@@ -90,31 +194,81 @@ func (gs *GateService) run() {
 	}
 	gs.positionSyncInterval = time.Millisecond * time.Duration(cfg.PositionSyncIntervalMS)
 	gwlog.Infof("%s: positionSyncInterval = %s", gs, gs.positionSyncInterval)
+	gs.syncPositionPrecision = float32(cfg.SyncPositionPrecision)
+	gs.stuckConnectionTimeout = time.Second * time.Duration(cfg.StuckConnectionTimeout)
+	gwlog.Infof("%s: write timeout = %ds, stuck connection timeout = %s", gs, cfg.WriteTimeout, gs.stuckConnectionTimeout)
+	gs.minClientProtocolVersion, gs.maxClientProtocolVersion = clientProtocolVersionWindow(cfg)
+	gs.clientUpdateURL = cfg.ClientUpdateURL
+	gwlog.Infof("%s: client protocol versions = %d ~ %d", gs, gs.minClientProtocolVersion, gs.maxClientProtocolVersion)
 	binutil.PrintSupervisorTag(consts.GATE_STARTED_TAG)
+	binutil.NotifyReady()
 	gwutils.RepeatUntilPanicless(gs.mainRoutine)
 }
 
+// onConfigChanged hot-applies changes of gate config which are safe to apply without restarting
+func (gs *GateService) onConfigChanged(oldCfg, newCfg *config.GateConfig) {
+	if newCfg.LogLevel != oldCfg.LogLevel && args.logLevel == "" { // log level specified by -log is not changed
+		gwlog.Infof("%s: log level changed to %s", gs, newCfg.LogLevel)
+		gwlog.SetLevels(newCfg.LogLevel)
+	}
+	if newCfg.PositionSyncIntervalMS != oldCfg.PositionSyncIntervalMS {
+		gs.positionSyncInterval = time.Millisecond * time.Duration(newCfg.PositionSyncIntervalMS)
+		gwlog.Infof("%s: position sync interval changed to %s", gs, gs.positionSyncInterval)
+	}
+	if newCfg.SyncPositionPrecision != oldCfg.SyncPositionPrecision {
+		gs.syncPositionPrecision = float32(newCfg.SyncPositionPrecision)
+		gwlog.Infof("%s: sync position precision changed to %v", gs, gs.syncPositionPrecision)
+	}
+	if newCfg.StuckConnectionTimeout != oldCfg.StuckConnectionTimeout {
+		gs.stuckConnectionTimeout = time.Second * time.Duration(newCfg.StuckConnectionTimeout)
+		gwlog.Infof("%s: stuck connection timeout changed to %s", gs, gs.stuckConnectionTimeout)
+	}
+	if newCfg.MinClientProtocolVersion != oldCfg.MinClientProtocolVersion || newCfg.MaxClientProtocolVersion != oldCfg.MaxClientProtocolVersion {
+		gs.minClientProtocolVersion, gs.maxClientProtocolVersion = clientProtocolVersionWindow(newCfg)
+		gwlog.Infof("%s: client protocol versions changed to %d ~ %d", gs, gs.minClientProtocolVersion, gs.maxClientProtocolVersion)
+	}
+	gs.clientUpdateURL = newCfg.ClientUpdateURL
+}
+
+// setupTLSConfig creates the TLS config of client connections, client certificates are verified if tls_client_ca is set
 func (gs *GateService) setupTLSConfig(cfg *config.GateConfig) {
-	cfgdir := config.GetConfigDir()
+	cfgdir := conf.GetConfigDir()
 	rsaCert := path.Join(cfgdir, cfg.RSACertificate)
 	rsaKey := path.Join(cfgdir, cfg.RSAKey)
-	cert, err := tls.LoadX509KeyPair(rsaCert, rsaKey)
+	certReloader, err := newCertReloader(rsaCert, rsaKey, time.Second*time.Duration(cfg.TLSCertReloadInterval))
 	if err != nil {
-		gwlog.Panic(errors.Wrap(err, "load RSA key & certificate failed"))
+		gwlog.Panic(err)
+	}
+
+	minVersion, err := config.ParseTLSVersion(cfg.TLSMinVersion)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	cipherSuites, err := config.ParseTLSCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		gwlog.Panic(err)
 	}
 
 	gs.tlsConfig = &tls.Config{
-		//MinVersion:       tls.VersionTLS12,
-		//CurvePreferences: []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-		Certificates: []tls.Certificate{cert},
-		//CipherSuites: []uint16{
-		//	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-		//	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		//	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-		//	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		//},
-		//PreferServerCipherSuites: true,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites, // only used by TLS 1.2 and lower
+		GetCertificate: certReloader.GetCertificate,
+	}
+
+	if cfg.TLSClientCA != "" {
+		caFile := path.Join(cfgdir, cfg.TLSClientCA)
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			gwlog.Panic(errors.Wrap(err, "load client CA failed"))
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			gwlog.Panicf("load client CA failed: no certificate found in %s", caFile)
+		}
+		gs.tlsConfig.ClientCAs = clientCAs
+		gs.tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
+	gwlog.Infof("%s: TLS min version = %s, cipher suites = %v, client CA = %s, certificate reload interval = %ds", gs, cfg.TLSMinVersion, cfg.TLSCipherSuites, cfg.TLSClientCA, cfg.TLSCertReloadInterval)
 }
 
 func (gs *GateService) String() string {
@@ -123,16 +277,27 @@ func (gs *GateService) String() string {
 
 // ServeTCPConnection handle TCP connections from clients
 func (gs *GateService) ServeTCPConnection(conn net.Conn) {
-	tcpConn := conn.(*net.TCPConn)
-	tcpConn.SetWriteBuffer(consts.CLIENT_PROXY_WRITE_BUFFER_SIZE)
-	tcpConn.SetReadBuffer(consts.CLIENT_PROXY_READ_BUFFER_SIZE)
-	tcpConn.SetNoDelay(consts.CLIENT_PROXY_SET_TCP_NO_DELAY)
+	cfg := conf.GetGate(args.gateid) // options of the reloaded config are applied to new connections
+	opts := netutil.TCPOptions{
+		NoDelay:    cfg.TCPNoDelay,
+		SendBuffer: cfg.TCPSendBuffer,
+		RecvBuffer: cfg.TCPRecvBuffer,
+		KeepAlive:  time.Second * time.Duration(cfg.TCPKeepAlive),
+	}
+	if err := opts.Apply(conn.(*net.TCPConn)); err != nil {
+		gwlog.Warnf("%s: set TCP options of %s failed: %s", gs, conn.RemoteAddr(), err)
+	}
 
 	gs.handleClientConnection(conn, false)
 }
 
-func (gs *GateService) serveKCP(addr string) {
-	kcpListener, err := kcp.ListenWithOptions(addr, nil, 10, 3)
+func (gs *GateService) serveKCP(addr string, cfg *config.GateConfig) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	gs.kcpConn = conn
+	kcpListener, err := kcp.ServeConn(nil, 10, 3, conn)
 	if err != nil {
 		gwlog.Panic(err)
 	}
@@ -145,18 +310,42 @@ func (gs *GateService) serveKCP(addr string) {
 			if err != nil {
 				gwlog.Panic(err)
 			}
-			gs.handleKCPConn(conn)
+			gs.handleKCPConn(conn, cfg)
 		}
 	})
 }
 
-func (gs *GateService) handleKCPConn(conn *kcp.UDPSession) {
+// serveTransport serves client connections of the custom transport registered by netutil.RegisterTransport
+func (gs *GateService) serveTransport(name string, addr string, listen netutil.TransportListenFunc) {
+	ln, err := listen(addr)
+	if err != nil {
+		gwlog.Panic(errors.Wrapf(err, "listen on transport %s failed", name))
+	}
+
+	gwlog.Infof("Listening on %s: %s ...", name, addr)
+
+	gwutils.RepeatUntilPanicless(func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if gwioutil.IsTimeoutError(err) {
+					continue
+				}
+				gwlog.Panic(err)
+			}
+			go gs.handleClientConnection(conn, false)
+		}
+	})
+}
+
+func (gs *GateService) handleKCPConn(conn *kcp.UDPSession, cfg *config.GateConfig) {
 	gwlog.Infof("KCP connection from %s", conn.RemoteAddr())
 
 	conn.SetReadBuffer(consts.CLIENT_PROXY_READ_BUFFER_SIZE)
 	conn.SetWriteBuffer(consts.CLIENT_PROXY_WRITE_BUFFER_SIZE)
-	// turn on turbo mode according to https://github.com/skywind3000/kcp/blob/master/README.en.md#protocol-configuration
-	conn.SetNoDelay(consts.KCP_NO_DELAY, consts.KCP_INTERNAL_UPDATE_TIMER_INTERVAL, consts.KCP_ENABLE_FAST_RESEND, consts.KCP_DISABLE_CONGESTION_CONTROL)
+	// turbo mode is on by default according to https://github.com/skywind3000/kcp/blob/master/README.en.md#protocol-configuration
+	conn.SetNoDelay(kcpNoDelayParams(cfg))
+	conn.SetWindowSize(cfg.KCPSendWindow, cfg.KCPRecvWindow) // non-positive sizes are ignored
 	conn.SetStreamMode(consts.KCP_SET_STREAM_MODE)
 	conn.SetWriteDelay(consts.KCP_SET_WRITE_DELAY)
 	conn.SetACKNoDelay(consts.KCP_SET_ACK_NO_DELAY)
@@ -164,6 +353,58 @@ func (gs *GateService) handleKCPConn(conn *kcp.UDPSession) {
 	gs.handleClientConnection(conn, false)
 }
 
+// serveWebSocket serves WebSocket connections from clients on the dedicated listener, which is secured by TLS if connections are encrypted
+func (gs *GateService) serveWebSocket(addr string, path string, tlsConfig *tls.Config) {
+	mux := http.NewServeMux()
+	mux.Handle(path, websocket.Handler(gs.handleWebSocketConn))
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	gwlog.Infof("Listening on WebSocket: %s%s, TLS: %v ...", addr, path, tlsConfig != nil)
+	var err error
+	if tlsConfig != nil {
+		err = server.ListenAndServeTLS("", "") // certificate is loaded in TLS config
+	} else {
+		err = server.ListenAndServe()
+	}
+	gwlog.Panic(errors.Wrap(err, "serve WebSocket failed"))
+}
+
+// wsListenAddr returns the address of WebSocket listener, which is on the same host as listen_addr
+func wsListenAddr(cfg *config.GateConfig) string {
+	host, _, err := net.SplitHostPort(cfg.ListenAddr)
+	if err != nil {
+		gwlog.Panic(errors.Wrapf(err, "invalid listen_addr: %s", cfg.ListenAddr))
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.ListenWSPort))
+}
+
+// kcpListenAddr returns the address of KCP listener, which is listen_addr, or listen_kcp_port on the same host if configured
+func kcpListenAddr(cfg *config.GateConfig) string {
+	if cfg.ListenKCPPort == 0 {
+		return cfg.ListenAddr
+	}
+	host, _, err := net.SplitHostPort(cfg.ListenAddr)
+	if err != nil {
+		gwlog.Panic(errors.Wrapf(err, "invalid listen_addr: %s", cfg.ListenAddr))
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.ListenKCPPort))
+}
+
+// kcpNoDelayParams returns the nodelay, interval, resend and nc parameters of KCP sessions
+func kcpNoDelayParams(cfg *config.GateConfig) (nodelay, interval, resend, nc int) {
+	if cfg.KCPNoDelay {
+		nodelay = 1
+	}
+	if cfg.KCPNoCongestion {
+		nc = 1
+	}
+	return nodelay, cfg.KCPInterval, cfg.KCPResend, nc
+}
+
 func (gs *GateService) handleWebSocketConn(wsConn *websocket.Conn) {
 	gwlog.Debugf("WebSocket Connection: %s", wsConn.RemoteAddr())
 	//var conn netutil.Connection = NewWebSocketConn(wsConn)
@@ -179,7 +420,7 @@ func (gs *GateService) handleClientConnection(conn net.Conn, isWebSocket bool) {
 		return
 	}
 
-	cfg := config.GetGate(args.gateid)
+	cfg := conf.GetGate(args.gateid)
 
 	if cfg.EncryptConnection && !isWebSocket {
 		tlsConn := tls.Server(conn, gs.tlsConfig)
@@ -187,6 +428,22 @@ func (gs *GateService) handleClientConnection(conn net.Conn, isWebSocket bool) {
 	}
 
 	cp := newClientProxy(conn, cfg)
+	if kcpSession, ok := conn.(*kcp.UDPSession); ok && gs.kcpConn != nil {
+		// datagrams are not sent to encrypted connections, which are not KCP sessions here
+		cp.setDatagramConn(gs.kcpConn, kcpSession.RemoteAddr())
+	}
+	if !gs.admissionLimiter.admit() {
+		// too many new connections, tell the client to reconnect later
+		rejectedConnections.Add(1)
+		cp.disconnectWithReconnectDirective(gs.reconnectBackoff)
+		return
+	}
+	if gs.draining.Load() {
+		// gate is draining, tell the client to reconnect to other gates
+		cp.disconnectWithReconnectDirective(gs.reconnectBackoff)
+		return
+	}
+
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debugf("%s.ServeTCPConnection: client %s connected", gs, cp)
 	}
@@ -210,16 +467,47 @@ func (gs *GateService) checkClientHeartbeats() {
 	}
 }
 
+// closeStuckClientProxies closes clients which do not read packets sent to them, so that pending packets are released
+func (gs *GateService) closeStuckClientProxies() {
+	for _, cp := range gs.clientProxies {
+		cp.CloseIfStuck(gs.stuckConnectionTimeout)
+		if cp.channel != nil {
+			cp.channel.CloseIfStuck(gs.stuckConnectionTimeout)
+		}
+	}
+}
+
 func (gs *GateService) onNewClientProxy(cp *ClientProxy) {
 	gs.clientProxies[cp.clientid] = cp
+	connectedClients.Inc()
 	bootEntityID := common.GenEntityID() // generate boot entity ID in the gate
 	cp.ownerEntityID = bootEntityID
 	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientConnected(cp.clientid, bootEntityID)
+	if gs.sessionTokenTTL > 0 {
+		cp.sessionExpireTime = time.Now().Add(gs.sessionTokenTTL)
+		gs.issueSessionToken(cp)
+	}
 }
 
 func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
+	if cp.channelOwner != nil {
+		gs.unbindClientChannel(cp)
+		return
+	}
+	if cp.channel != nil {
+		cp.channel.Close()
+	}
+
 	delete(gs.clientProxies, cp.clientid)
+	connectedClients.Dec()
+	gs.removeClientFilterProps(cp)
 
+	if !gs.detachClient(cp) {
+		gs.notifyClientDisconnected(cp)
+	}
+}
+
+func (gs *GateService) removeClientFilterProps(cp *ClientProxy) {
 	for key, val := range cp.filterProps {
 		ft := gs.filterTrees[key]
 		if ft != nil {
@@ -229,7 +517,9 @@ func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 			ft.Remove(cp, val)
 		}
 	}
+}
 
+func (gs *GateService) notifyClientDisconnected(cp *ClientProxy) {
 	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debugf("%s.onClientProxyClose: client %s disconnected", gs, cp)
@@ -239,15 +529,61 @@ func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 // HandleDispatcherClientPacket handles packets received by dispatcher client
 func (gs *GateService) handleClientProxyPacket(cp *ClientProxy, msgtype proto.MsgType, pkt *netutil.Packet) {
 	cp.heartbeatTime = time.Now()
+	if cp.protocolVersionRejected {
+		return // packets received before the client is closed are dropped
+	}
+	if cp.channelOwner != nil {
+		return // channels only send messages to clients, packets from them are dropped
+	}
+	if msgtype == proto.MT_BIND_CLIENT_CHANNEL_FROM_CLIENT {
+		gs.handleBindClientChannel(cp, pkt.ReadVarStr())
+		return
+	}
+	if !cp.protocolVersionNegotiated && msgtype != proto.MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT &&
+		msgtype != proto.MT_SET_CLIENT_CODEC_FROM_CLIENT && msgtype != proto.MT_HEARTBEAT_FROM_CLIENT {
+		// the client does not announce its version before other messages
+		if !gs.negotiateClientProtocolVersion(cp, proto.CLIENT_PROTOCOL_VERSION_BASE) {
+			return
+		}
+	}
+	if shim := proto.GetClientDecodeShim(msgtype, cp.protocolVersion); shim != nil {
+		shimmedPkt, err := shim(pkt)
+		if err != nil {
+			gwlog.Errorf("%s: decode message %d of client protocol version %d failed: %s", cp, msgtype, cp.protocolVersion, err)
+			return
+		}
+		defer shimmedPkt.Release()
+		pkt = shimmedPkt
+		msgtype = proto.MsgType(pkt.ReadUint16())
+	}
+
 	switch msgtype {
 	case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
 		gs.handleSyncPositionYawFromClient(pkt)
-	case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
+	case proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT:
+		gs.handleSyncPositionYawWithSeqFromClient(pkt)
+	case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+		proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT:
 		pkt.AppendClientID(cp.clientid) // append cp to the packet
 		eid := pkt.ReadEntityID()
+		span := tracing.StartSpan("gate: call from client", tracing.SpanKindServer, tracing.SpanContext{}) // trace contexts from clients are not trusted
+		span.SetAttr("goworld.entity_id", eid)
+		span.SetAttr("goworld.client_id", cp.clientid)
+		proto.InjectTraceContext(pkt, span.Context())
 		dispatchercluster.SelectByEntityID(eid).SendPacket(pkt)
+		span.End()
 	case proto.MT_HEARTBEAT_FROM_CLIENT:
 		// kcp connected from client, need to do nothing here
+	case proto.MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT:
+		if gs.sessionTokenTTL > 0 {
+			gs.handleSessionTokenResponse(cp, pkt.ReadVarStr())
+		}
+	case proto.MT_RESUME_SESSION_FROM_CLIENT:
+		gs.handleResumeSession(cp, pkt.ReadVarStr())
+	case proto.MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT:
+		gs.negotiateClientProtocolVersion(cp, pkt.ReadUint16())
+	case proto.MT_SET_CLIENT_CODEC_FROM_CLIENT:
+		gs.handleSetClientCodec(cp)
 	default:
 		gwlog.Panicf("unknown message type from client: %d", msgtype)
 	}
@@ -260,10 +596,12 @@ func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packe
 	}
 
 	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
-		_ = packet.ReadUint16() // gid
+		_ = packet.ReadUint16() // read useless gateid
 		clientid := packet.ReadClientID()
+		payload := packet.UnreadPayload()
 
 		clientproxy := gs.clientProxies[clientid]
+		detachedClientProxy := gs.getDetachedClientProxy(clientid)
 
 		// if msgtype is MT_CREATE_ENTITY_ON_CLIENT, update owner entity for the client proxy when isPlayer == true
 		if msgtype == proto.MT_CREATE_ENTITY_ON_CLIENT {
@@ -273,6 +611,8 @@ func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packe
 				if clientproxy != nil {
 					clientproxy.ownerEntityID = entityID
 					//gwlog.Warnf("%s: owner entity changed to %s", clientproxy, entityID)
+				} else if detachedClientProxy != nil {
+					detachedClientProxy.ownerEntityID = entityID
 				} else {
 					// client already disconnected, but the game service seems not knowing it, so tell the owner entity
 					dispatchercluster.SelectByEntityID(entityID).SendNotifyClientDisconnected(clientid, entityID)
@@ -286,16 +626,34 @@ func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packe
 				gs.handleSetClientFilterProp(clientproxy, packet)
 			} else if msgtype == proto.MT_CLEAR_CLIENTPROXY_FILTER_PROPS {
 				gs.handleClearClientFilterProps(clientproxy, packet)
+			} else if msgtype == proto.MT_SET_CLIENT_SYNC_VERSION {
+				clientproxy.syncGameID = packet.ReadUint16()
+				clientproxy.syncVersion = packet.ReadUint64()
+			} else if shim := proto.GetClientEncodeShim(msgtype, clientproxy.protocolVersion); shim != nil {
+				clientproxy.sendWithClientEncodeShim(shim, msgtype, packet, payload)
 			} else {
 				// message types that should be redirected to client proxy
+				clientproxy.recordEgressPacket(msgtype, payload, int64(packet.GetPayloadLen()))
 				clientproxy.SendPacket(packet)
 			}
+		} else if detachedClientProxy != nil {
+			// filter props are kept for the resumed session, other messages (including sync versions) are dropped
+			if msgtype == proto.MT_SET_CLIENTPROXY_FILTER_PROP {
+				key := packet.ReadVarStr()
+				detachedClientProxy.filterProps[key] = packet.ReadVarStr()
+			} else if msgtype == proto.MT_CLEAR_CLIENTPROXY_FILTER_PROPS {
+				detachedClientProxy.filterProps = map[string]string{}
+			}
 		}
 
 	} else if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS {
 		gs.handleSyncPositionYawOnClients(packet)
+	} else if msgtype == proto.MT_SYNC_INPUT_ACK_ON_CLIENTS {
+		gs.handleSyncInputAckOnClients(packet)
 	} else if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
 		gs.handleCallFilteredClientProxies(packet)
+	} else if msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY {
+		gs.handleCallEntityMethodOnClientWithDelivery(packet)
 	} else {
 		gwlog.Panicf("%s: unknown msg type: %d", gs, msgtype)
 	}
@@ -305,7 +663,10 @@ func (gs *GateService) handleSetClientFilterProp(clientproxy *ClientProxy, packe
 	gwlog.Debugf("%s.handleSetClientFilterProp: clientproxy=%s", gs, clientproxy)
 	key := packet.ReadVarStr()
 	val := packet.ReadVarStr()
+	gs.setClientFilterProp(clientproxy, key, val)
+}
 
+func (gs *GateService) setClientFilterProp(clientproxy *ClientProxy, key, val string) {
 	ft, ok := gs.filterTrees[key]
 	if !ok {
 		ft = newFilterTree()
@@ -344,14 +705,23 @@ func (gs *GateService) handleClearClientFilterProps(clientproxy *ClientProxy, pa
 }
 
 func (gs *GateService) handleSyncPositionYawOnClients(packet *netutil.Packet) {
+	gs.dispatchSyncInfosToClients(packet, proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.SYNC_INFO_SIZE_PER_ENTITY)
+}
+
+func (gs *GateService) handleSyncInputAckOnClients(packet *netutil.Packet) {
+	gs.dispatchSyncInfosToClients(packet, proto.MT_SYNC_INPUT_ACK_ON_CLIENTS, proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY)
+}
+
+func (gs *GateService) dispatchSyncInfosToClients(packet *netutil.Packet, msgtype proto.MsgType, infoSize int) {
 	_ = packet.ReadUint16() // read useless gateid
+	header := packet.ReadBytes(proto.SYNC_HEADER_SIZE)
 	payload := packet.UnreadPayload()
 	payloadLen := len(payload)
 	//gwlog.Infof("handleSyncPositionYawOnClients payloadLen=%v", payloadLen)
 	dispatch := map[common.ClientID][]byte{}
-	for i := 0; i < payloadLen; i += common.CLIENTID_LENGTH + common.ENTITYID_LENGTH + proto.SYNC_INFO_SIZE_PER_ENTITY {
+	for i := 0; i < payloadLen; i += common.CLIENTID_LENGTH + common.ENTITYID_LENGTH + infoSize {
 		clientid := common.ClientID(payload[i : i+common.CLIENTID_LENGTH])
-		data := payload[i+common.CLIENTID_LENGTH : i+common.CLIENTID_LENGTH+common.ENTITYID_LENGTH+proto.SYNC_INFO_SIZE_PER_ENTITY]
+		data := payload[i+common.CLIENTID_LENGTH : i+common.CLIENTID_LENGTH+common.ENTITYID_LENGTH+infoSize]
 		dispatch[clientid] = append(dispatch[clientid], data...)
 	}
 	//fmt.Fprintf(os.Stderr, "(%d,%d)", payloadLen, len(dispatch))
@@ -361,15 +731,46 @@ func (gs *GateService) handleSyncPositionYawOnClients(packet *netutil.Packet) {
 	for clientid, data := range dispatch {
 		clientproxy := gs.clientProxies[clientid]
 		if clientproxy != nil {
+			if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS && gs.syncPositionPrecision > 0 &&
+				clientproxy.protocolVersion >= proto.CLIENT_PROTOCOL_VERSION_QUANTIZED_SYNC && clientproxy.SendCodec() == nil &&
+				clientproxy.sendQuantizedSyncInfos(header, data, gs.syncPositionPrecision) {
+				continue
+			}
+
 			packet := netutil.NewPacket()
-			packet.AppendUint16(proto.MT_SYNC_POSITION_YAW_ON_CLIENTS)
+			packet.AppendUint16(uint16(msgtype))
+			packet.AppendBytes(header) // server tick & server time for clients to interpolate
 			packet.AppendBytes(data)
+			clientproxy.recordEgressSyncInfos(data, common.ENTITYID_LENGTH+infoSize, common.ENTITYID_LENGTH+infoSize)
 			clientproxy.SendPacket(packet)
 			packet.Release()
 		}
 	}
 }
 
+func (gs *GateService) handleCallEntityMethodOnClientWithDelivery(packet *netutil.Packet) {
+	gateid := packet.ReadUint16()
+	clientid := packet.ReadClientID()
+	delivery := proto.DeliveryClass(packet.ReadOneByte())
+
+	clientproxy := gs.clientProxies[clientid]
+	if clientproxy == nil {
+		return
+	}
+
+	// convert to MT_CALL_ENTITY_METHOD_ON_CLIENT, so that clients need not know delivery classes
+	payload := packet.UnreadPayload()
+	clientPacket := netutil.NewPacket()
+	clientPacket.AppendUint16(proto.MT_CALL_ENTITY_METHOD_ON_CLIENT)
+	clientPacket.AppendUint16(gateid)
+	clientPacket.AppendClientID(clientid)
+	clientPacket.AppendBytes(payload)
+	if clientproxy.sendPacketWithDelivery(clientPacket, delivery) {
+		clientproxy.recordEgressPacket(proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, payload, int64(clientPacket.GetPayloadLen()))
+	}
+	clientPacket.Release()
+}
+
 func (gs *GateService) handleCallFilteredClientProxies(packet *netutil.Packet) {
 	op := proto.FilterClientsOpType(packet.ReadOneByte())
 	key := packet.ReadVarStr()
@@ -380,15 +781,19 @@ func (gs *GateService) handleCallFilteredClientProxies(packet *netutil.Packet) {
 		for _, cp := range gs.clientProxies {
 			cp.SendPacket(packet)
 		}
+		recordEgress(egressClassRPC, "", int64(packet.GetPayloadLen())*int64(len(gs.clientProxies)), int64(len(gs.clientProxies)))
 		return
 	}
 
 	ft := gs.filterTrees[key]
 	if ft != nil {
+		var numClients int64
 		ft.Visit(op, val, func(cp *ClientProxy) {
 			//// visit all clientids and
 			cp.SendPacket(packet)
+			numClients += 1
 		})
+		recordEgress(egressClassRPC, "", int64(packet.GetPayloadLen())*numClients, numClients)
 	} else {
 		gwlog.Errorf("clients are not filtered by key %s", key)
 	}
@@ -404,6 +809,20 @@ func (gs *GateService) handleSyncPositionYawFromClient(packet *netutil.Packet) {
 	pkt.AppendBytes(data)
 }
 
+func (gs *GateService) handleSyncPositionYawWithSeqFromClient(packet *netutil.Packet) {
+	eid := packet.ReadEntityID()
+	data := packet.ReadBytes(proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY)
+	dispid := dispatchercluster.EntityIDToDispatcherID(eid)
+	pkt := gs.pendingInputSyncPackets[dispid-1]
+	if pkt == nil {
+		pkt = netutil.NewPacket()
+		pkt.AppendUint16(proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT)
+		gs.pendingInputSyncPackets[dispid-1] = pkt
+	}
+	pkt.AppendEntityID(eid)
+	pkt.AppendBytes(data)
+}
+
 func (gs *GateService) tryFlushPendingSyncPackets() {
 	now := time.Now()
 	if now.Before(gs.nextFlushSyncTime) {
@@ -422,6 +841,13 @@ func (gs *GateService) tryFlushPendingSyncPackets() {
 		pkt.AppendUint16(proto.MT_SYNC_POSITION_YAW_FROM_CLIENT)
 		gs.pendingSyncPackets[dispidx] = pkt
 	}
+
+	for dispidx, pkt := range gs.pendingInputSyncPackets {
+		if pkt != nil {
+			dispatchercluster.Select(dispidx).SendPacketRelease(pkt)
+			gs.pendingInputSyncPackets[dispidx] = nil
+		}
+	}
 }
 
 func (gs *GateService) mainRoutine() {
@@ -432,6 +858,7 @@ func (gs *GateService) mainRoutine() {
 			gs.handleClientProxyPacket(item.cp, item.msg.MsgType, item.msg.Packet)
 			op.Finish(time.Millisecond * 100)
 			item.msg.Packet.Release()
+			item.cp.floodGuard.done()
 		case item := <-gs.dispatcherClientPacketQueue:
 			op := opmon.StartOperation("GateServiceHandlePacket")
 			gs.handleDispatcherClientPacket(item.MsgType, item.Packet)
@@ -440,6 +867,22 @@ func (gs *GateService) mainRoutine() {
 			break
 		case <-gs.ticker:
 			gs.tryFlushPendingSyncPackets()
+			if gs.sessionTokenTTL > 0 {
+				if now := time.Now(); now.After(gs.nextCheckSessionTime) {
+					gs.nextCheckSessionTime = now.Add(consts.GATE_CHECK_SESSION_TOKENS_INTERVAL)
+					gs.checkSessionTokens()
+					gs.checkDetachedClients()
+				}
+			}
+			if gs.stuckConnectionTimeout > 0 {
+				if now := time.Now(); now.After(gs.nextCheckStuckTime) {
+					gs.nextCheckStuckTime = now.Add(consts.GATE_CHECK_STUCK_CONNECTIONS_INTERVAL)
+					gs.closeStuckClientProxies()
+				}
+			}
+			if gs.draining.Load() {
+				gs.checkDrain(time.Now())
+			}
 			break
 		}
 
@@ -451,7 +894,7 @@ func (gs *GateService) terminate() {
 	gs.terminating.Store(true)
 
 	for _, cp := range gs.clientProxies { // close all connected clients when terminating
-		cp.Close()
+		cp.disconnectWithReconnectDirective(gs.reconnectBackoff)
 	}
 
 	gs.terminated.Signal()
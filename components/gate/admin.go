@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type adminClientInfo struct {
+	ClientID        common.ClientID `json:"id"`
+	OwnerEntityID   common.EntityID `json:"owner"`
+	RemoteAddr      string          `json:"remote_addr"`
+	ProtocolVersion uint16          `json:"protocol_version"`
+	Codec           string          `json:"codec"`
+	Verdict         string          `json:"verdict,omitempty"` // action of the last verdict of the connection scorer
+	Score           float64         `json:"score,omitempty"`
+	Reason          string          `json:"reason,omitempty"`
+}
+
+// setupAdminHandlers registers admin handlers of the gate
+//
+//	GET /admin/clients  lists clients connected to the gate, with verdicts of the connection scorer
+func (gs *GateService) setupAdminHandlers() {
+	binutil.RegisterAdminHandler(http.MethodGet, "clients", func(params url.Values) (interface{}, error) {
+		clients := make([]adminClientInfo, 0, len(gs.clientProxies))
+		for _, cp := range gs.clientProxies {
+			info := adminClientInfo{
+				ClientID:        cp.clientid,
+				OwnerEntityID:   cp.ownerEntityID,
+				RemoteAddr:      cp.RemoteAddr().String(),
+				ProtocolVersion: cp.protocolVersion,
+				Codec:           cp.codec,
+			}
+			if cp.verdict != nil {
+				info.Verdict, info.Score, info.Reason = cp.verdict.Action, cp.verdict.Score, cp.verdict.Reason
+			}
+			clients = append(clients, info)
+		}
+		sort.Slice(clients, func(i, j int) bool {
+			return clients[i].ClientID < clients[j].ClientID
+		})
+		return map[string]interface{}{"total": len(clients), "clients": clients}, nil
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// certReloader provides the certificate of TLS handshakes, and reloads it if the key or certificate file is modified,
+// so that certificates can be rotated without restarting gates
+type certReloader struct {
+	sync.Mutex
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration // 0 if certificate is not reloaded
+	cert          *tls.Certificate
+	modTime       time.Time // latest modification time of key & certificate files when loaded
+	nextCheckTime time.Time
+}
+
+func newCertReloader(certFile string, keyFile string, checkInterval time.Duration) (*certReloader, error) {
+	cr := &certReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: checkInterval,
+	}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate, used as tls.Config.GetCertificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.Lock()
+	defer cr.Unlock()
+
+	if cr.checkInterval > 0 {
+		if now := time.Now(); now.After(cr.nextCheckTime) {
+			cr.nextCheckTime = now.Add(cr.checkInterval)
+			cr.reloadIfModified()
+		}
+	}
+	return cr.cert, nil
+}
+
+func (cr *certReloader) reloadIfModified() {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		gwlog.Warnf("check TLS certificate failed: %s", err)
+		return
+	}
+	if !modTime.After(cr.modTime) {
+		return
+	}
+
+	if err := cr.load(); err != nil {
+		// the files might be partially written, keep using the old certificate and retry later
+		gwlog.Warnf("reload TLS certificate failed: %s", err)
+		return
+	}
+	gwlog.Infof("TLS certificate reloaded: cert=%s, key=%s", cr.certFile, cr.keyFile)
+}
+
+func (cr *certReloader) load() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "load RSA key & certificate failed")
+	}
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTime, errors.WithStack(err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	clientChannelsBound      = expvar.NewInt("GateClientChannelsBound")
+	clientChannelsBindFailed = expvar.NewInt("GateClientChannelsBindFailed")
+)
+
+// handleBindClientChannel binds the connection as the channel of DELIVERY_UNORDERED_RELIABLE messages of the client of
+// the session token
+//
+// The channel should be bound after the codec is selected on the connection of the client, since messages on the
+// channel are encoded by the codec of the client after the ack.
+func (gs *GateService) handleBindClientChannel(cp *ClientProxy, token string) {
+	if gs.sessionTokenTTL <= 0 {
+		clientChannelsBindFailed.Add(1)
+		cp.SendBindClientChannelAck(false)
+		return
+	}
+
+	clientid, _, expireTime, ok := proto.VerifySessionToken(gs.sessionSecret, token)
+	owner := gs.clientProxies[clientid]
+	if !ok || owner == nil || owner == cp || time.Now().After(expireTime) {
+		clientChannelsBindFailed.Add(1)
+		gwlog.Warnf("%s: bind client channel failed", cp)
+		cp.SendBindClientChannelAck(false)
+		return
+	}
+
+	// the connection is not a client any more, so its boot entity is not needed
+	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
+	gs.removeClientFilterProps(cp)
+	delete(gs.clientProxies, cp.clientid)
+	connectedClients.Dec()
+
+	gs.bindClientChannel(owner, cp)
+	gwlog.Infof("%s: channel %s is bound", owner, cp)
+}
+
+// bindClientChannel binds the connection as the channel of the owner, the previous channel of the owner is closed
+func (gs *GateService) bindClientChannel(owner *ClientProxy, channel *ClientProxy) {
+	if owner.channel != nil {
+		owner.channel.Close()
+	}
+
+	owner.channel = channel
+	channel.channelOwner = owner
+	channel.noResume = true
+	channel.SendBindClientChannelAck(true)
+	channel.SetSendCodec(owner.SendCodec())
+	clientChannelsBound.Add(1)
+}
+
+// unbindClientChannel is called when the channel is closed
+func (gs *GateService) unbindClientChannel(channel *ClientProxy) {
+	if owner := channel.channelOwner; owner.channel == channel {
+		owner.channel = nil
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// selectCodec selects the codec of the client when MT_SET_CLIENT_CODEC_FROM_CLIENT is received by the serve routine
+//
+// Packets received after are decoded by the codec, and packets sent after the gate service replies the codec are
+// encoded by the codec. It returns false if the codec is already selected.
+func (cp *ClientProxy) selectCodec(pkt *netutil.Packet) bool {
+	if cp.codec != "" {
+		gwlog.Warnf("%s: codec is already selected: %s", cp, cp.codec)
+		return false
+	}
+
+	cp.codec = proto.SelectClientCodec(cp.preferredCodec, pkt.ReadStringList())
+	cp.SetRecvCodec(proto.GetClientCodec(cp.codec))
+	return true
+}
+
+func (gs *GateService) handleSetClientCodec(cp *ClientProxy) {
+	cp.SendSetClientCodec(cp.codec) // the reply is not encoded by the codec yet
+	cp.SetSendCodec(proto.GetClientCodec(cp.codec))
+	gwlog.Debugf("%s: codec is set to %s", cp, cp.codec)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"expvar"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	rejectedClientProtocolVersions = expvar.NewInt("GateRejectedClientProtocolVersions")
+)
+
+// clientProtocolVersionWindow returns the window of client protocol versions configured, the max version is the latest
+// version supported by the gate if not configured
+func clientProtocolVersionWindow(cfg *config.GateConfig) (minVersion, maxVersion uint16) {
+	maxVersion = proto.CLIENT_PROTOCOL_VERSION
+	if cfg.MaxClientProtocolVersion > 0 && cfg.MaxClientProtocolVersion < proto.CLIENT_PROTOCOL_VERSION {
+		maxVersion = uint16(cfg.MaxClientProtocolVersion)
+	}
+	return uint16(cfg.MinClientProtocolVersion), maxVersion
+}
+
+// negotiateClientProtocolVersion selects the protocol version of the client announcing clientVersion, the client is
+// rejected and disconnected if the version is not supported
+func (gs *GateService) negotiateClientProtocolVersion(cp *ClientProxy, clientVersion uint16) bool {
+	cp.protocolVersionNegotiated = true
+	minVersion, maxVersion := gs.minClientProtocolVersion, gs.maxClientProtocolVersion
+	version, ok := proto.NegotiateClientProtocolVersion(clientVersion, minVersion, maxVersion)
+	if !ok {
+		rejectedClientProtocolVersions.Add(1)
+		gwlog.Warnf("%s: client protocol version %d is rejected, supported versions: %d ~ %d", cp, clientVersion, minVersion, maxVersion)
+		cp.protocolVersionRejected = true
+		cp.noResume = true
+		cp.SendClientProtocolVersionRejected(clientVersion, minVersion, maxVersion, gs.clientUpdateURL)
+		cp.Flush("negotiateClientProtocolVersion")
+		cp.Close()
+		return false
+	}
+
+	cp.protocolVersion = version
+	if clientVersion >= proto.CLIENT_PROTOCOL_VERSION_NEGOTIATION {
+		cp.SendSetClientProtocolVersion(version)
+	}
+	gwlog.Debugf("%s: client protocol version %d is selected, announced by client: %d", cp, version, clientVersion)
+	return true
+}
+
+// sendWithClientEncodeShim sends the packet redirected to the client by the shim of the client protocol version
+//
+// payload is the unread payload after gate ID and client ID
+func (cp *ClientProxy) sendWithClientEncodeShim(shim proto.ClientEncodeShim, msgtype proto.MsgType, packet *netutil.Packet, payload []byte) {
+	sentBytes := cp.SentBytes()
+	if err := shim(cp.GoWorldConnection, packet.Payload()[2:]); err != nil { // skip the message type
+		gwlog.Errorf("%s: send message %d of client protocol version %d failed: %s", cp, msgtype, cp.protocolVersion, err)
+		return
+	}
+	cp.recordEgressPacket(msgtype, payload, int64(cp.SentBytes()-sentBytes))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	// scoredClients counts verdicts of the connection scorer by action
+	scoredClients = expvar.NewMap("GateScoredClients")
+)
+
+// connScoring records signals of the client connection and scores it periodically in the read loop of the client proxy
+type connScoring struct {
+	recorder      *netutil.SignalRecorder
+	nextScoreTime time.Time
+	action        string // the most severe action of verdicts so far
+}
+
+func newConnScoring(cp *ClientProxy, now time.Time) *connScoring {
+	return &connScoring{
+		recorder:      netutil.NewSignalRecorder(cp.clientid, cp.RemoteAddr().String(), now),
+		nextScoreTime: now.Add(gateService.connectionScoreInterval),
+	}
+}
+
+func isClientInput(msgtype proto.MsgType) bool {
+	switch msgtype {
+	case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT, proto.MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT,
+		proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+		proto.MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT:
+		return true
+	}
+	return false
+}
+
+// record records the received packet and scores the connection if it is time, returns if the client should be kicked
+func (s *connScoring) record(cp *ClientProxy, msgtype proto.MsgType, size int) (kick bool) {
+	now := time.Now()
+	s.recorder.Record(size, msgtype == proto.MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT, isClientInput(msgtype), now)
+	if now.Before(s.nextScoreTime) {
+		return false
+	}
+	s.nextScoreTime = now.Add(gateService.connectionScoreInterval)
+
+	verdict := gateService.connectionScorer(s.recorder.Signals(now))
+	switch verdict.Action {
+	case netutil.ConnectionAllow:
+		return false
+	case netutil.ConnectionFlag:
+		if s.action != "" {
+			return false // already flagged or rate limited
+		}
+		gwlog.Warnf("%s is flagged by the connection scorer: score=%v, %s", cp, verdict.Score, verdict.Reason)
+	case netutil.ConnectionRateLimit:
+		if !cp.floodGuard.limit(verdict.RateLimit) {
+			return false
+		}
+		gwlog.Warnf("%s is rate limited to %d packets per second by the connection scorer: score=%v, %s", cp, verdict.RateLimit, verdict.Score, verdict.Reason)
+	case netutil.ConnectionKick:
+		gwlog.Warnf("%s is kicked by the connection scorer: score=%v, %s", cp, verdict.Score, verdict.Reason)
+		kick = true
+	default:
+		gwlog.Errorf("%s: unknown action of the connection scorer: %s", cp, verdict.Action)
+		return false
+	}
+
+	scoredClients.Add(verdict.Action, 1)
+	s.action = verdict.Action
+	post.Post(func() {
+		cp.verdict = &verdict
+	})
+	return kick
+}
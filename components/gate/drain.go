@@ -0,0 +1,49 @@
+package main
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Graceful drain
+//
+// A draining gate tells new clients to reconnect later (to other gates behind the load balancer), keeps serving
+// connected clients, and terminates gracefully when all clients are disconnected or drain_timeout is reached.
+
+// drain starts draining the gate, it can be called in any goroutine
+func drain() {
+	post.Post(func() {
+		gateService.startDrain()
+	})
+}
+
+func (gs *GateService) startDrain() {
+	if gs.draining.Load() || gs.terminating.Load() {
+		return
+	}
+
+	deployCfg := conf.GetDeployment()
+	gs.draining.Store(true)
+	gs.drainDeadline = time.Now().Add(time.Second * time.Duration(deployCfg.DrainTimeout))
+	gwlog.Infof("%s: start draining with %d clients connected, timeout = %ds", gs, len(gs.clientProxies), deployCfg.DrainTimeout)
+}
+
+func (gs *GateService) checkDrain(now time.Time) {
+	if gs.drainFinished {
+		return
+	}
+
+	if len(gs.clientProxies) > 0 {
+		if now.Before(gs.drainDeadline) {
+			return
+		}
+		gwlog.Warnf("%s: drain timeout, %d clients are still connected", gs, len(gs.clientProxies))
+	} else {
+		gwlog.Infof("%s: drained, terminating ...", gs)
+	}
+	gs.drainFinished = true
+	signalChan <- syscall.SIGTERM
+}
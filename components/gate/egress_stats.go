@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"expvar"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// message classes for egress accounting
+const (
+	egressClassEntity   = "entity"   // entity creations and destructions on clients
+	egressClassAttr     = "attr"     // attribute changes
+	egressClassPosition = "position" // position & yaw sync
+	egressClassRPC      = "rpc"      // RPC calls on clients
+	egressClassOther    = "other"
+)
+
+const (
+	// egressUnknownEntityType is used when the entity type of messages can not be determined, e.g. calls to filtered clients
+	egressUnknownEntityType = "-"
+)
+
+var (
+	// egressBytes and egressMessages count payload sent to clients by "<message class>.<entity type>"
+	egressBytes    = expvar.NewMap("GateEgressBytes")
+	egressMessages = expvar.NewMap("GateEgressMessages")
+)
+
+func egressMessageClass(msgtype proto.MsgType) string {
+	switch msgtype {
+	case proto.MT_CREATE_ENTITY_ON_CLIENT, proto.MT_DESTROY_ENTITY_ON_CLIENT:
+		return egressClassEntity
+	case proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT, proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT, proto.MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT,
+		proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT, proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT, proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT,
+		proto.MT_NOTIFY_ATTR_PATCH_ON_CLIENT:
+		return egressClassAttr
+	case proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, proto.MT_SYNC_INPUT_ACK_ON_CLIENTS:
+		return egressClassPosition
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, proto.MT_CALL_FILTERED_CLIENTS:
+		return egressClassRPC
+	default:
+		return egressClassOther
+	}
+}
+
+func recordEgress(class string, typeName string, bytes int64, messages int64) {
+	if typeName == "" {
+		typeName = egressUnknownEntityType
+	}
+	key := class + "." + typeName
+	egressBytes.Add(key, bytes)
+	egressMessages.Add(key, messages)
+}
+
+// recordEgressPacket records a packet redirected to the client proxy
+//
+// payload is the unread payload after gate ID and client ID
+func (cp *ClientProxy) recordEgressPacket(msgtype proto.MsgType, payload []byte, bytes int64) {
+	recordEgress(egressMessageClass(msgtype), cp.egressEntityType(msgtype, payload), bytes, 1)
+}
+
+// egressEntityType returns the entity type of the packet redirected to the client proxy
+//
+// Entity types are learned from entity creations on the client, since other messages only contain entity IDs
+func (cp *ClientProxy) egressEntityType(msgtype proto.MsgType, payload []byte) string {
+	switch msgtype {
+	case proto.MT_CREATE_ENTITY_ON_CLIENT:
+		// isPlayer, entity ID, type name ...
+		if len(payload) < 1+common.ENTITYID_LENGTH {
+			return ""
+		}
+		entityID := common.EntityID(payload[1 : 1+common.ENTITYID_LENGTH])
+		typeName, _ := readEgressVarStr(payload[1+common.ENTITYID_LENGTH:])
+		cp.entityTypes[entityID] = typeName
+		return typeName
+	case proto.MT_DESTROY_ENTITY_ON_CLIENT:
+		// type name, entity ID
+		typeName, n := readEgressVarStr(payload)
+		if len(payload) >= n+common.ENTITYID_LENGTH {
+			delete(cp.entityTypes, common.EntityID(payload[n:n+common.ENTITYID_LENGTH]))
+		}
+		return typeName
+	default:
+		// entity ID, ...
+		if len(payload) < common.ENTITYID_LENGTH {
+			return ""
+		}
+		return cp.entityTypes[common.EntityID(payload[:common.ENTITYID_LENGTH])]
+	}
+}
+
+// readEgressVarStr reads a varsize string written by Packet.AppendVarStr and returns the string and bytes consumed
+func readEgressVarStr(b []byte) (string, int) {
+	if len(b) < 4 {
+		return "", len(b)
+	}
+	size := int(binary.LittleEndian.Uint32(b)) // packets are little endian
+	if len(b) < 4+size {
+		return "", len(b)
+	}
+	return string(b[4 : 4+size]), 4 + size
+}
+
+// recordEgressSyncInfos records position & yaw sync infos sent to the client proxy
+//
+// syncInfoSize is the size of sync info of each entity in data, and sentSize is the size sent to the client, including the entity ID
+func (cp *ClientProxy) recordEgressSyncInfos(data []byte, syncInfoSize int, sentSize int) {
+	counts := map[string]int64{}
+	for i := 0; i+syncInfoSize <= len(data); i += syncInfoSize {
+		counts[cp.entityTypes[common.EntityID(data[i:i+common.ENTITYID_LENGTH])]] += 1
+	}
+
+	for typeName, count := range counts {
+		recordEgress(egressClassPosition, typeName, count*int64(sentSize), count)
+	}
+}
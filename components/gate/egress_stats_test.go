@@ -0,0 +1,110 @@
+package main
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func TestEgressMessageClass(t *testing.T) {
+	for _, c := range []struct {
+		msgtype proto.MsgType
+		class   string
+	}{
+		{proto.MT_CREATE_ENTITY_ON_CLIENT, egressClassEntity},
+		{proto.MT_DESTROY_ENTITY_ON_CLIENT, egressClassEntity},
+		{proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT, egressClassAttr},
+		{proto.MT_NOTIFY_ATTR_PATCH_ON_CLIENT, egressClassAttr},
+		{proto.MT_SYNC_POSITION_YAW_ON_CLIENTS, egressClassPosition},
+		{proto.MT_SYNC_INPUT_ACK_ON_CLIENTS, egressClassPosition},
+		{proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, egressClassRPC},
+		{proto.MT_CALL_FILTERED_CLIENTS, egressClassRPC},
+		{proto.MT_SET_CLIENTPROXY_FILTER_PROP, egressClassOther},
+	} {
+		if class := egressMessageClass(c.msgtype); class != c.class {
+			t.Errorf("class of message type %d should be %s, not %s", c.msgtype, c.class, class)
+		}
+	}
+}
+
+func egressCount(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// recordEgressForTest records the packet of the message type sent to the client proxy, and returns the bytes recorded
+func recordEgressForTest(cp *ClientProxy, msgtype proto.MsgType, build func(packet *netutil.Packet)) int64 {
+	packet := netutil.NewPacket()
+	defer packet.Release()
+	build(packet)
+	bytes := int64(len(packet.Payload()))
+	cp.recordEgressPacket(msgtype, packet.Payload(), bytes)
+	return bytes
+}
+
+func TestRecordEgressPacket(t *testing.T) {
+	cp := newTestClientProxy()
+	entityID := common.GenEntityID()
+	typeName := "TestEgressAvatar"
+	entityKey, attrKey, rpcKey := egressClassEntity+"."+typeName, egressClassAttr+"."+typeName, egressClassRPC+"."+typeName
+
+	createBytes := recordEgressForTest(cp, proto.MT_CREATE_ENTITY_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendBool(true)
+		packet.AppendEntityID(entityID)
+		packet.AppendVarStr(typeName)
+	})
+	if n := egressCount(egressBytes, entityKey); n != createBytes {
+		t.Fatalf("bytes of entity creation should be recorded for %s: %d", typeName, n)
+	}
+
+	// later messages of the entity are attributed to the entity type learned from the creation
+	attrBytes := recordEgressForTest(cp, proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendEntityID(entityID)
+		packet.AppendVarStr("hp")
+	})
+	rpcBytes := recordEgressForTest(cp, proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendEntityID(entityID)
+		packet.AppendVarStr("OnAttack")
+	})
+	rpcBytes += recordEgressForTest(cp, proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendEntityID(entityID)
+		packet.AppendVarStr("OnHit")
+	})
+	if n := egressCount(egressBytes, attrKey); n != attrBytes {
+		t.Fatalf("bytes of attr changes should be recorded for %s: %d", typeName, n)
+	}
+	if n, m := egressCount(egressBytes, rpcKey), egressCount(egressMessages, rpcKey); n != rpcBytes || m != 2 {
+		t.Fatalf("RPCs should be recorded for %s: %d bytes, %d messages", typeName, n, m)
+	}
+
+	recordEgressForTest(cp, proto.MT_DESTROY_ENTITY_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendVarStr(typeName)
+		packet.AppendEntityID(entityID)
+	})
+	if m := egressCount(egressMessages, entityKey); m != 2 {
+		t.Fatalf("entity destruction should be recorded for %s: %d messages", typeName, m)
+	}
+	if _, ok := cp.entityTypes[entityID]; ok {
+		t.Fatalf("entity type should be forgotten after the entity is destroyed")
+	}
+
+	// messages of unknown entities are attributed to the unknown entity type
+	before := egressCount(egressMessages, egressClassRPC+"."+egressUnknownEntityType)
+	recordEgressForTest(cp, proto.MT_CALL_ENTITY_METHOD_ON_CLIENT, func(packet *netutil.Packet) {
+		packet.AppendEntityID(entityID)
+		packet.AppendVarStr("OnAttack")
+	})
+	if m := egressCount(egressMessages, egressClassRPC+"."+egressUnknownEntityType); m != before+1 {
+		t.Fatalf("RPC of unknown entity should be recorded for unknown entity type")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"golang.org/x/net/websocket"
+)
+
+// Fallback transport
+//
+// Players behind corporate firewalls can usually reach HTTPS ports only. The fallback listener serves WebSocket over
+// TLS on fallback_listen_addr (e.g. 0.0.0.0:443), which looks like ordinary HTTPS traffic to firewalls and proxies, and
+// the server list advertising all transports of gates at /servers, so clients can fall back to it if other transports
+// are blocked. HTTP/2 is negotiated for the server list, and WebSocket upgrades use HTTP/1.1.
+
+// serveFallback serves WebSocket connections over TLS and the server list on the fallback listener
+func (gs *GateService) serveFallback(addr string, path string) {
+	mux := http.NewServeMux()
+	mux.Handle(path, websocket.Handler(gs.handleWebSocketConn))
+	mux.HandleFunc("/servers", serveServerList)
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: gs.tlsConfig,
+	}
+
+	gwlog.Infof("Listening on fallback: %s%s ...", addr, path)
+	err := server.ListenAndServeTLS("", "") // certificate is loaded in TLS config
+	gwlog.Panic(errors.Wrap(err, "serve fallback failed"))
+}
+
+// serveServerList responds the server list of all gates in JSON
+func serveServerList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // browser clients may fetch the server list from other origins
+	if err := json.NewEncoder(w).Encode(conf.GetServerList()); err != nil {
+		gwlog.Warnf("write server list to %s failed: %s", r.RemoteAddr, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// flood policies of clients which exceed packet limits
+const (
+	floodPolicyDrop     = "drop"     // drop packets over limits
+	floodPolicyThrottle = "throttle" // stop reading from the client until packets are within limits
+	floodPolicyKick     = "kick"     // disconnect the client
+)
+
+// reasons of flooded packets
+const (
+	floodReasonPackets = "packets" // max_packets_per_second exceeded
+	floodReasonBytes   = "bytes"   // max_bytes_per_second exceeded
+	floodReasonPending = "pending" // max_pending_packets exceeded
+)
+
+const (
+	// floodThrottleCheckInterval is the interval to check pending packets of throttled clients
+	floodThrottleCheckInterval = time.Millisecond * 10
+)
+
+var (
+	// floodedPackets counts packets over limits by "<reason>.<policy>"
+	floodedPackets = expvar.NewMap("GateFloodedPackets")
+	kickedClients  = expvar.NewInt("GateFloodKickedClients")
+)
+
+// tokenBucket limits rate of something, allowing burst of one second
+type tokenBucket struct {
+	rate   float64 // tokens per second, not limited if <= 0
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int, now time.Time) tokenBucket {
+	return tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// wait returns how long to wait before n tokens are available
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= n || b.tokens >= b.rate { // packets larger than one second of tokens can be taken with full bucket
+		return 0
+	}
+	if n > b.rate {
+		n = b.rate
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate > 0 {
+		b.tokens -= n
+	}
+}
+
+// floodGuard enforces packet limits of a client in the read loop of the client proxy
+type floodGuard struct {
+	policy     string
+	packets    tokenBucket
+	bytes      tokenBucket
+	maxPending int64
+	pending    int64 // packets of the client queued to the gate service, accessed atomically
+}
+
+func newFloodGuard(cfg *config.GateConfig) *floodGuard {
+	now := time.Now()
+	return &floodGuard{
+		policy:     cfg.FloodPolicy,
+		packets:    newTokenBucket(cfg.MaxPacketsPerSecond, now),
+		bytes:      newTokenBucket(cfg.MaxBytesPerSecond, now),
+		maxPending: int64(cfg.MaxPendingPackets),
+	}
+}
+
+// admit checks limits for a received packet of the size, returns if the packet should be queued to the gate service,
+// and if the client should be kicked. Throttled clients are blocked until the packet is within limits.
+func (g *floodGuard) admit(size int) (ok bool, kick bool) {
+	reason, wait := g.check(float64(size), time.Now())
+	if reason != "" {
+		floodedPackets.Add(reason+"."+g.policy, 1)
+		switch g.policy {
+		case floodPolicyKick:
+			kickedClients.Add(1)
+			return false, true
+		case floodPolicyThrottle:
+			for reason != "" {
+				time.Sleep(wait)
+				reason, wait = g.check(float64(size), time.Now())
+			}
+		default:
+			return false, false
+		}
+	}
+
+	g.packets.take(1)
+	g.bytes.take(float64(size))
+	atomic.AddInt64(&g.pending, 1)
+	return true, false
+}
+
+// check returns the reason if the packet exceeds limits, and how long to wait before checking again
+func (g *floodGuard) check(size float64, now time.Time) (string, time.Duration) {
+	if g.maxPending > 0 && atomic.LoadInt64(&g.pending) >= g.maxPending {
+		return floodReasonPending, floodThrottleCheckInterval
+	}
+	if wait := g.packets.wait(1, now); wait > 0 {
+		return floodReasonPackets, wait
+	}
+	if wait := g.bytes.wait(size, now); wait > 0 {
+		return floodReasonBytes, wait
+	}
+	return "", 0
+}
+
+// done is called when a queued packet of the client is handled by the gate service
+func (g *floodGuard) done() {
+	atomic.AddInt64(&g.pending, -1)
+}
+
+// limit throttles packets of the client to the rate set by the verdict of the connection scorer, limits of bytes and
+// pending packets are also enforced by throttling, returns false if packets are already limited to the rate
+func (g *floodGuard) limit(packetsPerSecond int) bool {
+	if packetsPerSecond <= 0 || (g.packets.rate > 0 && float64(packetsPerSecond) >= g.packets.rate) {
+		return false
+	}
+	g.packets = newTokenBucket(packetsPerSecond, time.Now())
+	g.policy = floodPolicyThrottle
+	return true
+}
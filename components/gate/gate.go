@@ -8,18 +8,15 @@ import (
 
 	"os"
 
+	"net/http"
 	_ "net/http/pprof"
 
-	"runtime"
-
 	"os/signal"
 
 	"syscall"
 
 	"fmt"
 
-	"path"
-
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -29,6 +26,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 var (
@@ -37,8 +35,10 @@ var (
 		configFile      string
 		logLevel        string
 		runInDaemonMode bool
+		compatCorpus    bool
 		//listenAddr      string
 	}
+	conf        = config.Default() // config of the gate, passed to engine packages
 	gateService *GateService
 	signalChan  = make(chan os.Signal, 1)
 )
@@ -47,8 +47,10 @@ func parseArgs() {
 	var gateIdArg int
 	flag.IntVar(&gateIdArg, "gid", 0, "set gateid")
 	flag.StringVar(&args.configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{Config: conf}, "set", "override config key: -set section.key=value, can be repeated")
 	flag.StringVar(&args.logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&args.runInDaemonMode, "d", false, "run in daemon mode")
+	flag.BoolVar(&args.compatCorpus, "compat-corpus", false, "dump protocol compat corpus and exit")
 	//flag.StringVar(&args.listenAddr, "listen-addr", "", "set listen address for gate, overriding listen_addr in config file")
 	flag.Parse()
 	args.gateid = uint16(gateIdArg)
@@ -57,6 +59,9 @@ func parseArgs() {
 func main() {
 	rand.Seed(time.Now().UnixNano())
 	parseArgs()
+	if args.compatCorpus {
+		binutil.DumpCompatCorpusAndExit()
+	}
 
 	if args.runInDaemonMode {
 		daemoncontext := binutil.Daemonize()
@@ -64,38 +69,57 @@ func main() {
 	}
 
 	if args.configFile != "" {
-		config.SetConfigFile(args.configFile)
+		conf.SetConfigFile(args.configFile)
 	}
+	conf.SetLocalNode(config.NodeGate, args.gateid)
 
 	if args.gateid <= 0 {
 		gwlog.Errorf("gateid %d is not valid, should be positive", args.gateid)
 		os.Exit(1)
 	}
 
-	gateConfig := config.GetGate(args.gateid)
+	gateConfig := conf.GetGate(args.gateid)
 	verifyGateConfig(gateConfig)
-	if gateConfig.GoMaxProcs > 0 {
-		gwlog.Infof("SET GOMAXPROCS = %d", gateConfig.GoMaxProcs)
-		runtime.GOMAXPROCS(gateConfig.GoMaxProcs)
-	}
 	logLevel := args.logLevel
 	if logLevel == "" {
 		logLevel = gateConfig.LogLevel
 	}
-	binutil.SetupGWLog(fmt.Sprintf("gate%d", args.gateid), logLevel, gateConfig.LogFile, gateConfig.LogStderr)
+	binutil.SetupGWLog(fmt.Sprintf("gate%d", args.gateid), logLevel, gateConfig.LogFile, gateConfig.LogStderr, gateConfig.LogFormat, gwlog.Rotation{
+		MaxSize:    int64(gateConfig.LogRotateSize) << 20,
+		Interval:   gateConfig.LogRotateInterval,
+		MaxBackups: gateConfig.LogMaxBackups,
+	})
+	binutil.SetupCPU(conf, fmt.Sprintf("gate%d", args.gateid), gateConfig.GoMaxProcs, gateConfig.CPUAffinity, gateConfig.CPUAffinityAuto)
 
 	gateService = newGateService()
+	conf.OnChange(fmt.Sprintf("gate%d", args.gateid), func(old, new interface{}) {
+		post.Post(func() {
+			gateService.onConfigChanged(old.(*config.GateConfig), new.(*config.GateConfig))
+		})
+	})
+	conf.Watch()
+	tracingConfig := conf.GetTracing()
+	tracing.Setup(fmt.Sprintf("gate%d", args.gateid), tracingConfig.Endpoint, tracingConfig.SampleRate)
+	binutil.SetupMetrics(fmt.Sprintf("gate%d", args.gateid), gateConfig.Labels, conf.GetTelemetry())
+	binutil.SetupAdminAPI(conf.GetDebug())
+	gateService.setupAdminHandlers()
+	binutil.SetupDrainHandler(drain)
+	if gateConfig.EncryptConnection || gateConfig.FallbackListenAddr != "" {
+		gateService.setupTLSConfig(gateConfig) // the fallback is always secured by TLS
+	}
+	http.HandleFunc("/servers", serveServerList)
 	if gateConfig.EncryptConnection {
-		cfgdir := config.GetConfigDir()
-		rsaCert := path.Join(cfgdir, gateConfig.RSACertificate)
-		rsaKey := path.Join(cfgdir, gateConfig.RSAKey)
-		binutil.SetupHTTPServerTLS(gateConfig.HTTPAddr, gateService.handleWebSocketConn, rsaCert, rsaKey)
+		binutil.SetupHTTPServerTLSConfig(gateConfig.HTTPAddr, gateService.handleWebSocketConn, gateService.tlsConfig)
 	} else {
 		binutil.SetupHTTPServer(gateConfig.HTTPAddr, gateService.handleWebSocketConn)
 	}
 
-	dispatchercluster.Initialize(args.gateid, dispatcherclient.GateDispatcherClientType, false, false, &gateDispatcherClientDelegate{})
+	conf.RegisterLocalNode(gateConfig.ListenAddr)
+	dispatchercluster.Initialize(conf, args.gateid, dispatcherclient.GateDispatcherClientType, false, false, &gateDispatcherClientDelegate{})
 	//dispatcherclient.Initialize(&gateDispatcherClientDelegate{}, true)
+	binutil.SetupService(fmt.Sprintf("goworld_gate%d", args.gateid), func() {
+		signalChan <- syscall.SIGTERM
+	})
 	setupSignals()
 	gateService.run() // run gate service in another goroutine
 }
@@ -105,8 +129,8 @@ func verifyGateConfig(gateConfig *config.GateConfig) {
 
 func setupSignals() {
 	gwlog.Infof("Setup signals ...")
-	signal.Ignore(syscall.Signal(10), syscall.Signal(12), syscall.SIGPIPE, syscall.SIGHUP)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Ignore(syscall.Signal(10), syscall.SIGPIPE, syscall.SIGHUP)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, binutil.DrainSignal)
 
 	go func() {
 		for {
@@ -114,6 +138,7 @@ func setupSignals() {
 			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
 				// terminating gate ...
 				gwlog.Infof("Terminating gate service ...")
+				binutil.NotifyStopping()
 				post.Post(func() {
 					gateService.terminate()
 				})
@@ -121,6 +146,10 @@ func setupSignals() {
 				gateService.terminated.Wait()
 				gwlog.Infof("Gate %d terminated gracefully.", args.gateid)
 				os.Exit(0)
+			} else if sig == binutil.DrainSignal {
+				// SIGUSR2 => stop accepting clients and terminate when all clients are gone
+				gwlog.Infof("Draining gate service ...")
+				drain()
 			} else {
 				gwlog.Errorf("unexpected signal: %s", sig)
 			}
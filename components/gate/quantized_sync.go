@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// sendQuantizedSyncInfos sends sync infos to the client as MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS
+//
+// It returns false if positions in data are too far apart to quantize, so that they should be sent as they are
+func (cp *ClientProxy) sendQuantizedSyncInfos(header []byte, data []byte, precision float32) bool {
+	quantized, ok := proto.QuantizeSyncInfos(nil, data, precision)
+	if !ok {
+		return false
+	}
+
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS)
+	packet.AppendBytes(header)
+	packet.AppendBytes(quantized)
+	cp.recordEgressSyncInfos(data, common.ENTITYID_LENGTH+proto.SYNC_INFO_SIZE_PER_ENTITY, common.ENTITYID_LENGTH+proto.QUANTIZED_SYNC_INFO_SIZE_PER_ENTITY)
+	cp.SendPacket(packet)
+	packet.Release()
+	return true
+}
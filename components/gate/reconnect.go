@@ -0,0 +1,66 @@
+package main
+
+import (
+	"expvar"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	rejectedConnections = expvar.NewInt("GateRejectedConnections")
+)
+
+// admissionLimiter paces new client connections with a token bucket, so that reconnect storms are flattened
+type admissionLimiter struct {
+	sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func newAdmissionLimiter(rate int) *admissionLimiter {
+	return &admissionLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// admit returns if a new connection can be accepted now, always true if rate is not limited
+func (l *admissionLimiter) admit() bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate { // allow burst of one second
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens -= 1
+	return true
+}
+
+// reconnectDelay returns a random delay within backoff window for clients to reconnect, so clients do not reconnect at the same time
+func reconnectDelay(backoff time.Duration) time.Duration {
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+// disconnectWithReconnectDirective tells the client when to reconnect, and then closes the client
+func (cp *ClientProxy) disconnectWithReconnectDirective(backoff time.Duration) {
+	cp.SendReconnectDirective(reconnectDelay(backoff))
+	cp.Flush("disconnectWithReconnectDirective")
+	cp.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// admitted returns how many of n connections are admitted by the limiter
+func admitted(l *admissionLimiter, n int) (count int) {
+	for i := 0; i < n; i++ {
+		if l.admit() {
+			count += 1
+		}
+	}
+	return
+}
+
+func TestAdmissionLimiterBurst(t *testing.T) {
+	l := newAdmissionLimiter(10)
+	if n := admitted(l, 20); n != 10 {
+		t.Fatalf("burst of one second should be admitted: %d", n)
+	}
+
+	// tokens are capped at one second even if the limiter has been idle for long
+	l.last = time.Now().Add(-time.Minute)
+	if n := admitted(l, 20); n != 10 {
+		t.Fatalf("burst should be capped at rate: %d", n)
+	}
+}
+
+func TestAdmissionLimiterRefill(t *testing.T) {
+	l := newAdmissionLimiter(10)
+	admitted(l, 10)
+	if l.admit() {
+		t.Fatalf("connection should be rejected if tokens are used up")
+	}
+
+	l.last = l.last.Add(-time.Millisecond * 500)
+	if n := admitted(l, 10); n != 5 {
+		t.Fatalf("tokens should be refilled at rate: %d", n)
+	}
+}
+
+func TestAdmissionLimiterUnlimited(t *testing.T) {
+	l := newAdmissionLimiter(0)
+	if n := admitted(l, 1000); n != 1000 {
+		t.Fatalf("all connections should be admitted if rate is 0: %d", n)
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	if d := reconnectDelay(0); d != 0 {
+		t.Fatalf("delay should be 0 without backoff: %s", d)
+	}
+
+	backoff := time.Second
+	var min, max time.Duration = backoff, 0
+	for i := 0; i < 1000; i++ {
+		d := reconnectDelay(backoff)
+		if d < 0 || d >= backoff {
+			t.Fatalf("delay %s is out of backoff window %s", d, backoff)
+		}
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	if max-min < backoff/2 {
+		t.Fatalf("delays should be spread over the backoff window: %s ~ %s", min, max)
+	}
+}
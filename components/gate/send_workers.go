@@ -0,0 +1,155 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+var (
+	sendWorkerBatchDuration = metrics.NewHistogram("goworld_gate_send_worker_batch_seconds", "Durations of send workers flushing all clients assigned to them", metrics.DefBuckets)
+	sendWorkerFlushes       = metrics.NewCounterVec("goworld_gate_send_worker_flushes_total", "Flushes of send workers, path is inline, offloaded or busy (inline since offload workers are busy)", "path")
+	sendWorkerInlineFlushes = sendWorkerFlushes.With("inline")
+	sendWorkerOffloaded     = sendWorkerFlushes.With("offloaded")
+	sendWorkerBusyFlushes   = sendWorkerFlushes.With("busy")
+)
+
+// clientSendWorkers flushes packets sent to clients by a fixed number of workers, instead of one flush routine per client
+//
+// Each client is assigned to a worker by its client ID, so packets sent to a client are always written by the same
+// worker. Workers flush all clients assigned to them in one batch at every flush interval.
+type clientSendWorkers struct {
+	shards []*clientSendShard
+}
+
+type clientSendShard struct {
+	sync.Mutex
+	clients []*ClientProxy
+	offload *clientSendOffload
+}
+
+// clientSendOffload flushes large writes for send workers by a bounded number of offload workers
+//
+// Compression and encryption of client connections run in flushes, so large writes are handed over to offload workers
+// to keep a client from delaying others of the same send worker. A client is flushed by one goroutine at a time to keep
+// packets in order: send workers skip clients being flushed by offload workers, and flush the remaining packets at the
+// next interval. Small writes, and writes when all offload workers are busy, are still flushed inline.
+type clientSendOffload struct {
+	minBytes int
+	queue    chan *ClientProxy
+}
+
+func newClientSendWorkers(n int, flushInterval time.Duration, offloadWorkers int, offloadMinBytes int) *clientSendWorkers {
+	sw := &clientSendWorkers{
+		shards: make([]*clientSendShard, n),
+	}
+	var offload *clientSendOffload
+	if offloadWorkers > 0 {
+		offload = &clientSendOffload{
+			minBytes: offloadMinBytes,
+			queue:    make(chan *ClientProxy, offloadWorkers),
+		}
+		for i := 0; i < offloadWorkers; i++ {
+			go offload.run()
+		}
+	}
+	for i := range sw.shards {
+		shard := &clientSendShard{offload: offload}
+		sw.shards[i] = shard
+		go shard.run(flushInterval)
+	}
+	return sw
+}
+
+func (sw *clientSendWorkers) shardOf(cp *ClientProxy) *clientSendShard {
+	h := fnv.New32a()
+	h.Write([]byte(cp.clientid))
+	return sw.shards[h.Sum32()%uint32(len(sw.shards))]
+}
+
+// add assigns the client to its worker, it should be called by the serve routine of the client
+func (sw *clientSendWorkers) add(cp *ClientProxy) {
+	shard := sw.shardOf(cp)
+	shard.Lock()
+	cp.sendShardIndex = len(shard.clients)
+	shard.clients = append(shard.clients, cp)
+	shard.Unlock()
+}
+
+// remove removes the client from its worker, it should be called by the serve routine of the client when it quits
+func (sw *clientSendWorkers) remove(cp *ClientProxy) {
+	shard := sw.shardOf(cp)
+	shard.Lock()
+	last := len(shard.clients) - 1
+	moved := shard.clients[last]
+	shard.clients[cp.sendShardIndex] = moved
+	moved.sendShardIndex = cp.sendShardIndex
+	shard.clients[last] = nil
+	shard.clients = shard.clients[:last]
+	shard.Unlock()
+}
+
+func (shard *clientSendShard) run(flushInterval time.Duration) {
+	var batch []*ClientProxy
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		shard.Lock()
+		batch = append(batch[:0], shard.clients...)
+		shard.Unlock()
+
+		startTime := time.Now()
+		for i, cp := range batch {
+			if !cp.IsClosed() {
+				shard.flush(cp)
+			}
+			batch[i] = nil
+		}
+		sendWorkerBatchDuration.Observe(time.Since(startTime).Seconds())
+	}
+}
+
+// flush flushes the client inline, or hands it over to offload workers if the write is large
+func (shard *clientSendShard) flush(cp *ClientProxy) {
+	if cp.sendOffloading.Load() {
+		return // being flushed by an offload worker
+	}
+
+	offload := shard.offload
+	if offload == nil {
+		cp.Flush("SendWorker") // write errors are handled by the serve routine of the client
+		return
+	}
+
+	pendingBytes := cp.PendingBytes()
+	if pendingBytes == 0 {
+		return
+	}
+	if pendingBytes < offload.minBytes {
+		sendWorkerInlineFlushes.Inc()
+		cp.Flush("SendWorker")
+		return
+	}
+
+	cp.sendOffloading.Store(true)
+	select {
+	case offload.queue <- cp:
+		sendWorkerOffloaded.Inc()
+	default:
+		cp.sendOffloading.Store(false)
+		sendWorkerBusyFlushes.Inc()
+		cp.Flush("SendWorker")
+	}
+}
+
+func (offload *clientSendOffload) run() {
+	for cp := range offload.queue {
+		if !cp.IsClosed() {
+			cp.Flush("SendOffload")
+		}
+		cp.sendOffloading.Store(false)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	sessionsDetached      = expvar.NewInt("GateSessionsDetached")
+	sessionsResumed       = expvar.NewInt("GateSessionsResumed")
+	sessionsResumeFailed  = expvar.NewInt("GateSessionsResumeFailed")
+	sessionsResumeExpired = expvar.NewInt("GateSessionsResumeExpired")
+)
+
+// detachedClient is a disconnected client which can be resumed by a new connection with its session token
+//
+// The owner entity keeps its client during detachment, and messages to the client are dropped by gate. The last sync
+// version forwarded to the client is sent to the owner entity when the session is resumed, so that only changes
+// during detachment are synced to the client.
+type detachedClient struct {
+	cp         *ClientProxy // the closed client proxy
+	expireTime time.Time
+}
+
+// detachClient keeps the session of the closed client for resuming, returns false if the session can not be resumed
+func (gs *GateService) detachClient(cp *ClientProxy) bool {
+	if gs.sessionResumeTimeout <= 0 || cp.noResume || gs.terminating.Load() {
+		return false
+	}
+
+	gs.detachedClients[cp.clientid] = &detachedClient{
+		cp:         cp,
+		expireTime: time.Now().Add(gs.sessionResumeTimeout),
+	}
+	sessionsDetached.Add(1)
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s.detachClient: client %s detached", gs, cp)
+	}
+	return true
+}
+
+// handleResumeSession lets the new client proxy take over the detached client of the session token
+func (gs *GateService) handleResumeSession(cp *ClientProxy, token string) {
+	if gs.sessionResumeTimeout <= 0 {
+		sessionsResumeFailed.Add(1)
+		cp.SendResumeSessionAck(false)
+		return
+	}
+
+	clientid, _, expireTime, ok := proto.VerifySessionToken(gs.sessionSecret, token)
+	dc := gs.detachedClients[clientid]
+	now := time.Now()
+	if !ok || dc == nil || now.After(expireTime) {
+		sessionsResumeFailed.Add(1)
+		gwlog.Warnf("%s: resume session failed, continue as a new client", cp)
+		cp.SendResumeSessionAck(false)
+		return
+	}
+	delete(gs.detachedClients, clientid)
+
+	// the boot entity of the new connection is not needed any more
+	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
+	gs.removeClientFilterProps(cp)
+	delete(gs.clientProxies, cp.clientid)
+
+	cp.clientid = clientid
+	cp.ownerEntityID = dc.cp.ownerEntityID
+	cp.filterProps = map[string]string{}
+	for key, val := range dc.cp.filterProps {
+		gs.setClientFilterProp(cp, key, val)
+	}
+	gs.clientProxies[clientid] = cp
+	cp.syncGameID = dc.cp.syncGameID
+	cp.syncVersion = dc.cp.syncVersion
+
+	cp.sessionExpireTime = gs.capSessionExpireTime(now, expireTime)
+	gs.issueSessionToken(cp)
+	cp.SendResumeSessionAck(true)
+	if cp.syncVersion != 0 {
+		dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientResumedWithSyncVersion(clientid, cp.ownerEntityID, cp.syncGameID, cp.syncVersion)
+	} else {
+		dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientResumed(clientid, cp.ownerEntityID)
+	}
+	sessionsResumed.Add(1)
+	gwlog.Infof("%s: session resumed", cp)
+}
+
+// checkDetachedClients notifies owner entities of detached clients which are not resumed in time
+func (gs *GateService) checkDetachedClients() {
+	now := time.Now()
+	for clientid, dc := range gs.detachedClients {
+		if now.After(dc.expireTime) {
+			delete(gs.detachedClients, clientid)
+			sessionsResumeExpired.Add(1)
+			gs.notifyClientDisconnected(dc.cp)
+		}
+	}
+}
+
+// getDetachedClientProxy returns the closed client proxy of the detached client, or nil
+func (gs *GateService) getDetachedClientProxy(clientid common.ClientID) *ClientProxy {
+	if dc := gs.detachedClients[clientid]; dc != nil {
+		return dc.cp
+	}
+	return nil
+}
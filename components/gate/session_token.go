@@ -0,0 +1,65 @@
+package main
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	sessionTokensRotated = expvar.NewInt("GateSessionTokensRotated")
+	sessionTokensExpired = expvar.NewInt("GateSessionTokensExpired")
+	sessionTokensInvalid = expvar.NewInt("GateSessionTokensInvalid")
+)
+
+// issueSessionToken sends a challenge to the client, which has to be signed into a new session token by the auth service
+//
+// The client keeps connected while the challenge is pending, but it has to send the new token back before the current
+// token expires. The auth service shares session_secret with gates and only signs challenges for clients with valid
+// credentials, so the gate never hands out tokens by itself.
+func (gs *GateService) issueSessionToken(cp *ClientProxy) {
+	cp.pendingSessionChallenge = proto.MakeSessionTokenChallenge(cp.clientid)
+	cp.SendSessionTokenChallenge(cp.pendingSessionChallenge, time.Until(cp.sessionExpireTime))
+}
+
+func (gs *GateService) handleSessionTokenResponse(cp *ClientProxy, token string) {
+	clientid, challenge, expireTime, ok := proto.VerifySessionToken(gs.sessionSecret, token)
+	now := time.Now()
+	if !ok || clientid != cp.clientid || cp.pendingSessionChallenge == "" || challenge != cp.pendingSessionChallenge || !expireTime.After(now) {
+		sessionTokensInvalid.Add(1)
+		gwlog.Warnf("%s: invalid session token response, closing ...", cp)
+		cp.noResume = true
+		cp.Close()
+		return
+	}
+
+	cp.pendingSessionChallenge = ""
+	cp.sessionExpireTime = gs.capSessionExpireTime(now, expireTime)
+	cp.nextSessionRotateTime = now.Add(cp.sessionExpireTime.Sub(now) / 2)
+	sessionTokensRotated.Add(1)
+}
+
+// capSessionExpireTime limits expire time of session tokens signed by the auth service to session_token_ttl
+func (gs *GateService) capSessionExpireTime(now time.Time, expireTime time.Time) time.Time {
+	if maxExpireTime := now.Add(gs.sessionTokenTTL); expireTime.After(maxExpireTime) {
+		return maxExpireTime
+	}
+	return expireTime
+}
+
+// checkSessionTokens closes clients with expired session token, and rotates session tokens which are half expired
+func (gs *GateService) checkSessionTokens() {
+	now := time.Now()
+	for _, cp := range gs.clientProxies {
+		if now.After(cp.sessionExpireTime) {
+			sessionTokensExpired.Add(1)
+			gwlog.Warnf("%s: session token expired, closing ...", cp)
+			cp.noResume = true
+			cp.Close()
+		} else if cp.pendingSessionChallenge == "" && now.After(cp.nextSessionRotateTime) {
+			gs.issueSessionToken(cp)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package async
 
 import (
+	"strconv"
 	"sync"
 
+	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
@@ -90,6 +92,38 @@ func AppendAsyncJob(group string, routine AsyncRoutine, callback AsyncCallback)
 	ajw.appendJob(routine, callback)
 }
 
+var (
+	workerPoolSize = 1
+)
+
+// PooledAsyncRoutine is an AsyncRoutine which is executed by the worker of specified index in the worker pool
+type PooledAsyncRoutine func(worker int) (res interface{}, err error)
+
+// SetWorkerPoolSize sets the number of workers of each worker pool, should be called before appending pooled jobs
+func SetWorkerPoolSize(size int) {
+	if size <= 0 {
+		gwlog.Panicf("async: invalid worker pool size: %d", size)
+	}
+	workerPoolSize = size
+}
+
+// GetWorkerPoolSize returns the number of workers of each worker pool
+func GetWorkerPoolSize() int {
+	return workerPoolSize
+}
+
+// AppendPooledAsyncJob appends an async job to the worker pool of the group
+//
+// Jobs of the same key are executed by the same worker in order, while jobs of different keys can be executed
+// concurrently by different workers. The routine is passed the index of the worker in range [0, GetWorkerPoolSize()).
+func AppendPooledAsyncJob(group string, key string, routine PooledAsyncRoutine, callback AsyncCallback) {
+	worker := int(common.HashString(key) % uint32(workerPoolSize))
+	ajw := getAsyncJobWorker(group + "#" + strconv.Itoa(worker))
+	ajw.appendJob(func() (res interface{}, err error) {
+		return routine(worker)
+	}, callback)
+}
+
 // WaitClear wait for all async job workers to finish (should only be called in the game goroutine)
 func WaitClear() bool {
 	var cleared bool
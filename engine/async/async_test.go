@@ -22,6 +22,36 @@ func TestNewAsyncJob(t *testing.T) {
 	wait.Wait()
 }
 
+func TestPooledAsyncJob(t *testing.T) {
+	SetWorkerPoolSize(4)
+	defer SetWorkerPoolSize(1)
+
+	var wait sync.WaitGroup
+	var results []int
+	workers := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		i := i
+		wait.Add(1)
+		AppendPooledAsyncJob("pooled", "key", func(worker int) (res interface{}, err error) {
+			workers[worker] = true // jobs of the same key are always executed by the same worker
+			return i, nil
+		}, func(res interface{}, err error) {
+			results = append(results, res.(int))
+			wait.Done()
+		})
+	}
+	wait.Wait()
+
+	if len(workers) != 1 {
+		t.Fatalf("jobs of the same key are executed by %d workers", len(workers))
+	}
+	for i, res := range results {
+		if res != i {
+			t.Fatalf("jobs of the same key are executed out of order: %v", results)
+		}
+	}
+}
+
 func init() {
 	go func() {
 		for {
@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/xiaonanln/go-aoi"
+)
+
+const (
+	aoiSpaceSize = 1000
+	aoiDistance  = 100
+	aoiMoveStep  = 5
+)
+
+func init() {
+	for _, n := range []int{100, 1000, 5000} {
+		n := n
+		register(fmt.Sprintf("AOI/XZList/Move/%d", n), func(b *testing.B) {
+			benchmarkAOIMove(b, aoi.NewXZListAOIManager(aoiDistance), n)
+		})
+		register(fmt.Sprintf("AOI/Tower/Move/%d", n), func(b *testing.B) {
+			benchmarkAOIMove(b, aoi.NewTowerAOIManager(0, aoiSpaceSize, 0, aoiSpaceSize, aoiDistance), n)
+		})
+	}
+}
+
+type aoiCounter struct {
+	enters, leaves int
+}
+
+func (c *aoiCounter) OnEnterAOI(other *aoi.AOI) {
+	c.enters += 1
+}
+
+func (c *aoiCounter) OnLeaveAOI(other *aoi.AOI) {
+	c.leaves += 1
+}
+
+type aoiEntity struct {
+	aoi  aoi.AOI
+	x, z aoi.Coord
+}
+
+// benchmarkAOIMove measures the cost of moving one entity in a space with n entities
+func benchmarkAOIMove(b *testing.B, aoiMgr aoi.AOIManager, n int) {
+	rnd := rand.New(rand.NewSource(1)) // fixed seed for reproducible results
+	counter := &aoiCounter{}
+	entities := make([]aoiEntity, n)
+	for i := range entities {
+		e := &entities[i]
+		aoi.InitAOI(&e.aoi, aoiDistance, e, counter)
+		e.x, e.z = aoi.Coord(rnd.Float32()*aoiSpaceSize), aoi.Coord(rnd.Float32()*aoiSpaceSize)
+		aoiMgr.Enter(&e.aoi, e.x, e.z)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := &entities[i%n]
+		e.x = clampCoord(e.x+aoi.Coord((rnd.Float32()*2-1)*aoiMoveStep), 0, aoiSpaceSize-1)
+		e.z = clampCoord(e.z+aoi.Coord((rnd.Float32()*2-1)*aoiMoveStep), 0, aoiSpaceSize-1)
+		aoiMgr.Moved(&e.aoi, e.x, e.z)
+	}
+}
+
+func clampCoord(v, min, max aoi.Coord) aoi.Coord {
+	if v < min {
+		return min
+	} else if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func init() {
+	register("AttrSync/PackAllAttrs", benchmarkPackAllAttrs)
+	register("AttrSync/NotifyAttrChange", benchmarkNotifyAttrChange)
+}
+
+// makeBenchAttrs makes attributes similar to a typical player entity
+func makeBenchAttrs() *entity.MapAttr {
+	attrs := entity.NewMapAttr()
+	attrs.SetStr("name", "benchmark-player")
+	attrs.SetInt("level", 60)
+	attrs.SetInt("exp", 123456789)
+	attrs.SetFloat("hp", 1000.5)
+	attrs.SetBool("online", true)
+
+	bag := entity.NewListAttr()
+	for i := 0; i < 50; i++ {
+		item := entity.NewMapAttr()
+		item.SetStr("id", fmt.Sprintf("item%d", i))
+		item.SetInt("count", int64(i))
+		bag.AppendMapAttr(item)
+	}
+	attrs.SetListAttr("bag", bag)
+
+	skills := entity.NewMapAttr()
+	for i := 0; i < 20; i++ {
+		skills.SetInt(fmt.Sprintf("skill%d", i), int64(i))
+	}
+	attrs.SetMapAttr("skills", skills)
+	return attrs
+}
+
+// benchmarkPackAllAttrs measures encoding all attributes, which happens when entities are created on clients
+func benchmarkPackAllAttrs(b *testing.B) {
+	attrs := makeBenchAttrs()
+	buf := make([]byte, 0, 4096)
+	data, err := netutil.MSG_PACKER.PackMsg(attrs.ToMap(), buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := netutil.MSG_PACKER.PackMsg(attrs.ToMap(), buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type discardConn struct {
+	net.Conn
+}
+
+func (dc discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (dc discardConn) Flush() error {
+	return nil
+}
+
+// benchmarkNotifyAttrChange measures encoding attribute change notifications to clients
+func benchmarkNotifyAttrChange(b *testing.B) {
+	gwc := proto.NewGoWorldConnection(discardConn{})
+	clientid := common.GenClientID()
+	entityid := common.GenEntityID()
+	path := []interface{}{"bag", 10}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := gwc.SendNotifyMapAttrChangeOnClient(1, clientid, entityid, path, "count", i); err != nil {
+			b.Fatal(err)
+		}
+		if i%100 == 99 {
+			gwc.Flush("bench")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package bench contains reproducible benchmarks of the engine hot paths.
+//
+// Benchmarks are registered in this package and can be run by `go run ./cmd/gwbench`, which outputs results in JSON
+// format and can compare results with a baseline report to detect performance regressions.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Benchmark is a registered benchmark
+type Benchmark struct {
+	Name string
+	F    func(b *testing.B)
+}
+
+// Result is the result of a benchmark
+type Result struct {
+	Name        string  `json:"name"`
+	N           int     `json:"n"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"bytes_per_op"`
+	MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+}
+
+// Report is the result of a benchmark run
+type Report struct {
+	GoVersion string   `json:"go_version"`
+	GOOS      string   `json:"goos"`
+	GOARCH    string   `json:"goarch"`
+	NumCPU    int      `json:"num_cpu"`
+	Time      string   `json:"time"`
+	Results   []Result `json:"results"`
+}
+
+var (
+	benchmarks []Benchmark
+)
+
+func register(name string, f func(b *testing.B)) {
+	benchmarks = append(benchmarks, Benchmark{name, f})
+}
+
+// Benchmarks returns all registered benchmarks sorted by name
+func Benchmarks() []Benchmark {
+	res := append([]Benchmark(nil), benchmarks...)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Run runs all benchmarks matching the filter (or all benchmarks if filter is nil)
+//
+// onResult is called after each benchmark is finished if it is not nil
+func Run(filter *regexp.Regexp, onResult func(res Result)) *Report {
+	report := &Report{
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		NumCPU:    runtime.NumCPU(),
+		Time:      time.Now().Format(time.RFC3339),
+	}
+
+	for _, bm := range Benchmarks() {
+		if filter != nil && !filter.MatchString(bm.Name) {
+			continue
+		}
+
+		br := testing.Benchmark(bm.F)
+		res := Result{
+			Name:        bm.Name,
+			N:           br.N,
+			NsPerOp:     br.NsPerOp(),
+			AllocsPerOp: br.AllocsPerOp(),
+			BytesPerOp:  br.AllocedBytesPerOp(),
+		}
+		if br.Bytes > 0 && br.T > 0 {
+			res.MBPerSec = float64(br.Bytes) * float64(br.N) / 1e6 / br.T.Seconds()
+		}
+
+		report.Results = append(report.Results, res)
+		if onResult != nil {
+			onResult(res)
+		}
+	}
+	return report
+}
+
+// WriteReport writes the report in JSON format
+func WriteReport(w io.Writer, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadReport reads a report in JSON format
+func ReadReport(r io.Reader) (*Report, error) {
+	var report Report
+	if err := json.NewDecoder(r).Decode(&report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Compare compares the report with the baseline report and returns all regressions found
+//
+// A benchmark is regressed if its ns/op or allocs/op grows by more than threshold (0.1 means 10%)
+func Compare(baseline, report *Report, threshold float64) (regressions []string) {
+	baselineResults := map[string]Result{}
+	for _, res := range baseline.Results {
+		baselineResults[res.Name] = res
+	}
+
+	for _, res := range report.Results {
+		base, ok := baselineResults[res.Name]
+		if !ok {
+			continue
+		}
+
+		if isRegressed(base.NsPerOp, res.NsPerOp, threshold) {
+			regressions = append(regressions, fmt.Sprintf("%s: %d => %d ns/op", res.Name, base.NsPerOp, res.NsPerOp))
+		}
+		if isRegressed(base.AllocsPerOp, res.AllocsPerOp, threshold) {
+			regressions = append(regressions, fmt.Sprintf("%s: %d => %d allocs/op", res.Name, base.AllocsPerOp, res.AllocsPerOp))
+		}
+	}
+	return
+}
+
+func isRegressed(base, val int64, threshold float64) bool {
+	if base == 0 {
+		return val > 0
+	}
+	return float64(val-base)/float64(base) > threshold
+}
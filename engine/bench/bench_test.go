@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBenchmarkNamesUnique(t *testing.T) {
+	names := map[string]bool{}
+	for _, bm := range Benchmarks() {
+		if names[bm.Name] {
+			t.Errorf("duplicate benchmark: %s", bm.Name)
+		}
+		names[bm.Name] = true
+	}
+}
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{Results: []Result{
+		{Name: "A", NsPerOp: 100, AllocsPerOp: 1},
+		{Name: "B", NsPerOp: 100, AllocsPerOp: 0},
+		{Name: "C", NsPerOp: 100, AllocsPerOp: 0},
+	}}
+	report := &Report{Results: []Result{
+		{Name: "A", NsPerOp: 110, AllocsPerOp: 1}, // within threshold
+		{Name: "B", NsPerOp: 150, AllocsPerOp: 1}, // regressed
+		{Name: "D", NsPerOp: 1000},                // new benchmark
+	}}
+
+	regressions := Compare(baseline, report, 0.2)
+	if len(regressions) != 2 {
+		t.Fatalf("should find 2 regressions, but found: %v", regressions)
+	}
+}
+
+func TestReadWriteReport(t *testing.T) {
+	report := &Report{GoVersion: "go", Results: []Result{{Name: "A", N: 10, NsPerOp: 100}}}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	report2, err := ReadReport(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report2.Results) != 1 || report2.Results[0] != report.Results[0] {
+		t.Fatalf("report mismatch: %+v", report2)
+	}
+}
@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/netconnutil"
+)
+
+func init() {
+	register("Dispatcher/CallEntityMethod", benchmarkDispatcherCallEntityMethod)
+}
+
+// benchmarkDispatcherCallEntityMethod measures throughput of entity RPC packets through a loopback TCP connection,
+// using the same buffered connections as dispatcher and dispatcher clients
+func benchmarkDispatcherCallEntityMethod(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan int)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		gwc := proto.NewGoWorldConnection(netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE))
+		defer gwc.Close()
+
+		count := 0
+		for {
+			var msgtype proto.MsgType
+			pkt, err := gwc.Recv(&msgtype)
+			if err != nil {
+				break
+			}
+			pkt.Release()
+			count += 1
+		}
+		received <- count
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	gwc := proto.NewGoWorldConnection(netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE))
+	entityid := common.GenEntityID()
+	args := []interface{}{1, "abc", 1.5}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := gwc.SendCallEntityMethod(entityid, "Method", args); err != nil {
+			b.Fatal(err)
+		}
+		if i%100 == 99 {
+			gwc.Flush("bench")
+		}
+	}
+	gwc.Flush("bench")
+	gwc.Close()
+
+	if count := <-received; count != b.N {
+		b.Fatalf("sent %d packets, but received %d", b.N, count)
+	}
+}
@@ -0,0 +1,47 @@
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	entitystoragefilesystem "github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+)
+
+const storageBatchSize = 100
+
+func init() {
+	register("Storage/Filesystem/BatchWrite", benchmarkFilesystemBatchWrite)
+}
+
+// benchmarkFilesystemBatchWrite measures writing a batch of entities to filesystem entity storage
+func benchmarkFilesystemBatchWrite(b *testing.B) {
+	dir, err := ioutil.TempDir("", "goworld_bench_storage")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer es.Close()
+
+	entityIDs := make([]common.EntityID, storageBatchSize)
+	for i := range entityIDs {
+		entityIDs[i] = common.GenEntityID()
+	}
+	data := makeBenchAttrs().ToMap()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, eid := range entityIDs {
+			if err := es.Write("Avatar", eid, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
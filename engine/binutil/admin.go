@@ -0,0 +1,238 @@
+package binutil
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Admin HTTP API
+//
+// Admin endpoints are served under /admin/ by the HTTP server of each process and respond JSON. Requests are
+// authenticated by tokens of admin users in the header "Authorization: Bearer <token>", or by client certificates if the
+// HTTP server verifies client certificates (mTLS). Users are configured by admin_user_<name> = <role>[:<token>] of
+// [debug], and admin_token is the token of the gm role. Each endpoint requires a role: readonly for GET requests and
+// operator for others by default, and each role has all permissions of the roles before it (readonly, operator, gm).
+// Every request is recorded in the audit log. The admin API is disabled if no user is configured.
+//
+// Admin handlers are called in the main routine of the process, so they can access entities and clients safely.
+
+const (
+	_ADMIN_HANDLER_TIMEOUT = time.Second * 10
+)
+
+// AdminRole is the role of admin users, which decides the endpoints the users can access
+type AdminRole int
+
+const (
+	// AdminReadOnly can read state of the cluster
+	AdminReadOnly AdminRole = iota + 1
+	// AdminOperator can operate the cluster
+	AdminOperator
+	// AdminGM can change game state of players
+	AdminGM
+)
+
+// AdminHandler handles the admin request with query parameters and returns the result to be encoded in JSON
+type AdminHandler func(params url.Values) (interface{}, error)
+
+type adminEndpoint struct {
+	role    AdminRole
+	handler AdminHandler
+}
+
+type adminUser struct {
+	name string
+	role AdminRole
+}
+
+var (
+	adminTokens    = map[string]*adminUser{}    // token -> user
+	adminCertUsers = map[string]*adminUser{}    // common name of client certificates -> user
+	adminHandlers  = map[string]adminEndpoint{} // "METHOD /admin/path" -> endpoint
+
+	adminRoles = map[string]AdminRole{
+		config.AdminRoleReadOnly: AdminReadOnly,
+		config.AdminRoleOperator: AdminOperator,
+		config.AdminRoleGM:       AdminGM,
+	}
+)
+
+func (role AdminRole) String() string {
+	for name, r := range adminRoles {
+		if r == role {
+			return name
+		}
+	}
+	return "none"
+}
+
+// SetupAdminAPI enables the admin API for admin users in the debug config, and registers admin handlers common to all
+// processes
+//
+//	GET  /admin/loglevel           returns the current log level
+//	POST /admin/loglevel?level=... sets log levels, e.g. "info,dispatcherclient=debug"
+func SetupAdminAPI(debugConfig *config.DebugConfig) {
+	if debugConfig.AdminToken != "" {
+		adminTokens[debugConfig.AdminToken] = &adminUser{name: "admin", role: AdminGM}
+	}
+	for name, u := range debugConfig.AdminUsers {
+		user := &adminUser{name: name, role: adminRoles[u.Role]}
+		if u.Token != "" {
+			adminTokens[u.Token] = user
+		} else {
+			adminCertUsers[name] = user
+		}
+	}
+	openAdminAuditLog(debugConfig.AdminAuditLog)
+	http.HandleFunc("/admin/", serveAdmin)
+
+	RegisterAdminHandler(http.MethodGet, "loglevel", func(params url.Values) (interface{}, error) {
+		return map[string]string{"level": gwlog.GetLevel().String()}, nil
+	})
+	RegisterAdminHandler(http.MethodPost, "loglevel", func(params url.Values) (interface{}, error) {
+		level := params.Get("level")
+		if level == "" {
+			return nil, errors.Errorf("level is not specified")
+		}
+		gwlog.Infof("admin: set log level to %s", level)
+		gwlog.SetLevels(level)
+		return map[string]string{"level": gwlog.GetLevel().String()}, nil
+	})
+}
+
+// RegisterAdminHandler registers the admin handler for requests of the method to /admin/<path>, which requires the
+// readonly role for GET requests, and the operator role for others
+//
+// Admin handlers should be registered before the HTTP server is started.
+func RegisterAdminHandler(method string, path string, handler AdminHandler) {
+	role := AdminOperator
+	if method == http.MethodGet {
+		role = AdminReadOnly
+	}
+	RegisterAdminHandlerRole(method, path, role, handler)
+}
+
+// RegisterAdminHandlerRole registers the admin handler which requires the role for requests of the method to /admin/<path>
+func RegisterAdminHandlerRole(method string, path string, role AdminRole, handler AdminHandler) {
+	adminHandlers[method+" /admin/"+path] = adminEndpoint{role: role, handler: handler}
+}
+
+// RequireAdmin wraps the HTTP handler served outside of /admin/, so that requests are authenticated, authorized and
+// audited like admin requests
+func RequireAdmin(role AdminRole, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authorizeAdmin(w, r, role)
+		if !ok {
+			return
+		}
+		r.ParseForm()
+		auditAdminRequest(r, user, "ok", nil)
+		handler(w, r)
+	}
+}
+
+// authenticateAdmin returns the user of the request, or nil if the request is not authenticated
+func authenticateAdmin(r *http.Request) *adminUser {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		var found *adminUser
+		for t, user := range adminTokens { // compare all tokens in constant time
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				found = user
+			}
+		}
+		return found
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return adminCertUsers[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+	}
+	return nil
+}
+
+// authorizeAdmin authenticates the request and checks the role of the user, errors are written to the response
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, role AdminRole) (*adminUser, bool) {
+	if len(adminTokens) == 0 && len(adminCertUsers) == 0 {
+		writeAdminError(w, http.StatusForbidden, errors.Errorf("admin API is disabled"))
+		return nil, false
+	}
+	user := authenticateAdmin(r)
+	if user == nil {
+		auditAdminRequest(r, nil, "unauthenticated", nil)
+		writeAdminError(w, http.StatusUnauthorized, errors.Errorf("invalid admin token or client certificate"))
+		return nil, false
+	}
+	if user.role < role {
+		auditAdminRequest(r, user, "denied", nil)
+		writeAdminError(w, http.StatusForbidden, errors.Errorf("%s requires the %s role", r.URL.Path, role))
+		return nil, false
+	}
+	return user, true
+}
+
+func serveAdmin(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := adminHandlers[r.Method+" "+r.URL.Path]
+	if !ok {
+		// check authentication first, so that endpoints can not be probed by anonymous requests
+		if _, ok := authorizeAdmin(w, r, AdminReadOnly); ok {
+			writeAdminError(w, http.StatusNotFound, errors.Errorf("unknown admin API: %s %s", r.Method, r.URL.Path))
+		}
+		return
+	}
+	user, ok := authorizeAdmin(w, r, endpoint.role)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		auditAdminRequest(r, user, "error", err)
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var res interface{}
+	var err error
+	done := make(chan struct{})
+	post.Post(func() {
+		defer close(done)
+		defer func() {
+			if perr := recover(); perr != nil {
+				err = errors.Errorf("admin handler paniced: %v", perr)
+			}
+		}()
+		res, err = endpoint.handler(r.Form)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(_ADMIN_HANDLER_TIMEOUT):
+		auditAdminRequest(r, user, "timeout", nil)
+		writeAdminError(w, http.StatusServiceUnavailable, errors.Errorf("admin handler timeout"))
+		return
+	}
+
+	if err != nil {
+		auditAdminRequest(r, user, "error", err)
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	auditAdminRequest(r, user, "ok", nil)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		gwlog.Errorf("admin: encode result of %s failed: %s", r.URL.Path, err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
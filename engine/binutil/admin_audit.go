@@ -0,0 +1,102 @@
+package binutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// adminAuditEntry is a line of the audit log in JSON
+type adminAuditEntry struct {
+	Time   string     `json:"time"`
+	Source string     `json:"source"` // process handling the request, e.g. game1
+	User   string     `json:"user,omitempty"`
+	Role   string     `json:"role,omitempty"`
+	Remote string     `json:"remote"`
+	Method string     `json:"method"`
+	Path   string     `json:"path"`
+	Params url.Values `json:"params,omitempty"`
+	Result string     `json:"result"` // ok, error, timeout, denied or unauthenticated
+	Error  string     `json:"error,omitempty"`
+}
+
+var (
+	adminAuditLock sync.Mutex
+	adminAuditFile *os.File
+)
+
+// openAdminAuditLog opens the audit log file for appending, audit entries are written to the process log if the file is empty
+func openAdminAuditLog(file string) {
+	if file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		gwlog.Errorf("admin: open audit log %s failed, audit entries are written to the process log: %s", file, err)
+		return
+	}
+	adminAuditFile = f
+}
+
+// auditAdminRequest appends the admin request and its result to the audit log
+//
+// Each entry is written by a single append, so entries written by processes sharing the audit log are not interleaved.
+func auditAdminRequest(r *http.Request, user *adminUser, result string, err error) {
+	entry := adminAuditEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Source: gwlog.GetSource(),
+		Remote: r.RemoteAddr,
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Params: redactAdminParams(r.Form),
+		Result: result,
+	}
+	if user != nil {
+		entry.User, entry.Role = user.name, user.role.String()
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	line, _ := json.Marshal(entry)
+	adminAuditLock.Lock()
+	defer adminAuditLock.Unlock()
+	if adminAuditFile == nil {
+		gwlog.Infof("admin audit: %s", line)
+		return
+	}
+	if _, err := adminAuditFile.Write(append(line, '\n')); err != nil {
+		gwlog.Errorf("admin: write audit log failed: %s, entry: %s", err, line)
+	}
+}
+
+// redactAdminParams hides values of secret parameters, e.g. passwords and tokens
+func redactAdminParams(params url.Values) url.Values {
+	var redacted url.Values
+	for key, values := range params {
+		lower := strings.ToLower(key)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "token") || strings.Contains(lower, "secret") {
+			if redacted == nil {
+				redacted = url.Values{}
+				for k, v := range params {
+					redacted[k] = v
+				}
+			}
+			redacted[key] = make([]string, len(values))
+			for i := range values {
+				redacted[key][i] = "***"
+			}
+		}
+	}
+	if redacted != nil {
+		return redacted
+	}
+	return params
+}
@@ -1,33 +1,58 @@
 package binutil
 
 import (
+	"crypto/tls"
 	"net/http"
 	"syscall"
+	"time"
 
+	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics" // register /metrics handler
 	"golang.org/x/net/websocket"
 )
 
 const (
 	// FreezeSignal syscall used to freeze server
 	FreezeSignal = syscall.SIGHUP
+	// DrainSignal syscall used to drain server before rolling restart (SIGUSR2)
+	DrainSignal = syscall.Signal(12)
 )
 
+// SetupMetrics starts metrics exporters of the service (e.g. game1) configured in [telemetry], labels of the process
+// are exported with metrics
+func SetupMetrics(serviceName string, labels map[string]string, telemetryConfig *config.TelemetryConfig) {
+	metrics.Setup(serviceName, metrics.Options{
+		Exporters:    telemetryConfig.Exporters,
+		PushInterval: time.Second * time.Duration(telemetryConfig.PushInterval),
+		StatsDAddr:   telemetryConfig.StatsDAddr,
+		StatsDPrefix: telemetryConfig.StatsDPrefix,
+		OTLPEndpoint: telemetryConfig.OTLPEndpoint,
+		Labels:       labels,
+	})
+}
+
 // SetupHTTPServer starts the HTTP server for go tool pprof and websockets
 func SetupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn)) {
-	setupHTTPServer(listenAddr, wsHandler, "", "")
+	setupHTTPServer(listenAddr, wsHandler, "", "", nil)
 }
 
 // SetupHTTPServerTLS starts the HTTPs server for go tool pprof and websockets
 func SetupHTTPServerTLS(listenAddr string, wsHandler func(ws *websocket.Conn), certFile string, keyFile string) {
-	setupHTTPServer(listenAddr, wsHandler, certFile, keyFile)
+	setupHTTPServer(listenAddr, wsHandler, certFile, keyFile, nil)
+}
+
+// SetupHTTPServerTLSConfig starts the HTTPs server for go tool pprof and websockets, certificates are provided by the TLS config
+func SetupHTTPServerTLSConfig(listenAddr string, wsHandler func(ws *websocket.Conn), tlsConfig *tls.Config) {
+	setupHTTPServer(listenAddr, wsHandler, "", "", tlsConfig)
 }
 
-func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), certFile string, keyFile string) {
+func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), certFile string, keyFile string, tlsConfig *tls.Config) {
 	gwlog.Infof("http server listening on %s", listenAddr)
 	gwlog.Infof("pprof http://%s/debug/pprof/ ... available commands: ", listenAddr)
 	gwlog.Infof("    go tool pprof http://%s/debug/pprof/heap", listenAddr)
 	gwlog.Infof("    go tool pprof http://%s/debug/pprof/profile", listenAddr)
+	gwlog.Infof("metrics http://%s/metrics", listenAddr)
 	if keyFile != "" || certFile != "" {
 		gwlog.Infof("TLS is enabled on http: key=%s, cert=%s", keyFile, certFile)
 	}
@@ -38,7 +63,10 @@ func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), cert
 	}
 
 	go func() {
-		if keyFile == "" && certFile == "" {
+		if tlsConfig != nil {
+			server := &http.Server{Addr: listenAddr, TLSConfig: tlsConfig}
+			server.ListenAndServeTLS("", "")
+		} else if keyFile == "" && certFile == "" {
 			http.ListenAndServe(listenAddr, nil)
 		} else {
 			http.ListenAndServeTLS(listenAddr, certFile, keyFile, nil)
@@ -47,10 +75,14 @@ func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), cert
 }
 
 // SetupGWLog setup the GoWord log system
-func SetupGWLog(component string, logLevel string, logFile string, logStderr bool) {
+//
+// logLevel can override levels of modules, e.g. "info,dispatcherclient=debug"
+func SetupGWLog(component string, logLevel string, logFile string, logStderr bool, logFormat string, rotation gwlog.Rotation) {
 	gwlog.SetSource(component)
 	gwlog.Infof("Set log level to %s", logLevel)
-	gwlog.SetLevel(gwlog.ParseLevel(logLevel))
+	gwlog.SetLevels(logLevel)
+	gwlog.SetFormat(logFormat)
+	gwlog.SetRotation(rotation)
 
 	var outputs []string
 	if logStderr {
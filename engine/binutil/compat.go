@@ -0,0 +1,16 @@
+package binutil
+
+import (
+	"os"
+
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// DumpCompatCorpusAndExit dumps the protocol compat corpus to stdout and exits, used by `goworld compat-check`
+func DumpCompatCorpusAndExit() {
+	if err := proto.DumpCompatCorpus(os.Stdout); err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
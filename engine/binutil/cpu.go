@@ -0,0 +1,55 @@
+package binutil
+
+import (
+	"math"
+	"os"
+	"runtime"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// SetupCPU pins the process to CPUs by cpu_affinity, sets GOMAXPROCS to gomaxprocs or the number of CPUs the process
+// can run on (including the CPU limit of the container), and warns about games and gates of the config which may run on
+// the same CPUs
+func SetupCPU(conf *config.Config, serviceName string, goMaxProcs int, affinity []int, auto bool) {
+	if auto {
+		available, err := getCPUAffinity()
+		if err != nil {
+			gwlog.Warnf("%s: get CPU affinity failed: %s", serviceName, err)
+		} else {
+			affinity = conf.AutoCPUAffinity(serviceName, available)
+		}
+	}
+
+	available := runtime.NumCPU()
+	if len(affinity) > 0 {
+		if err := setCPUAffinity(affinity); err != nil {
+			gwlog.Warnf("%s: pin to CPUs %s failed: %s", serviceName, config.FormatCPUList(affinity), err)
+		} else {
+			gwlog.Infof("%s: pinned to CPUs %s", serviceName, config.FormatCPUList(affinity))
+			available = len(affinity)
+		}
+	}
+	if limit := cgroupCPULimit(); limit > 0 && int(math.Ceil(limit)) < available {
+		gwlog.Infof("%s: CPU limit of cgroup is %.2f", serviceName, limit)
+		available = int(math.Ceil(limit))
+	}
+
+	if goMaxProcs > 0 {
+		gwlog.Infof("SET GOMAXPROCS = %d", goMaxProcs)
+		runtime.GOMAXPROCS(goMaxProcs)
+		if goMaxProcs > available {
+			gwlog.Warnf("%s: gomaxprocs %d is more than %d CPUs available, which causes CPU throttling in containers", serviceName, goMaxProcs, available)
+		}
+	} else if os.Getenv("GOMAXPROCS") == "" && runtime.GOMAXPROCS(0) != available {
+		gwlog.Infof("SET GOMAXPROCS = %d (CPUs available)", available)
+		runtime.GOMAXPROCS(available)
+	}
+
+	for _, overlap := range conf.CheckCPUOverlaps() {
+		if overlap.Game == serviceName || overlap.Gate == serviceName {
+			gwlog.Warnf("%s", overlap)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package binutil
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// getCPUAffinity returns CPUs the process can run on
+func getCPUAffinity() ([]int, error) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return nil, err
+	}
+
+	var cpus []int
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// setCPUAffinity pins all threads of the process to CPUs, threads created later inherit the affinity
+func setCPUAffinity(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	tasks, err := ioutil.ReadDir("/proc/self/task")
+	if err != nil {
+		return unix.SchedSetaffinity(0, &set)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH { // ESRCH: the thread exited
+			return err
+		}
+	}
+	return nil
+}
+
+// cgroupCPULimit returns the CPU limit (quota / period) of cgroup v2 or v1, 0 if not limited
+func cgroupCPULimit() float64 {
+	if data, err := ioutil.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		fields := strings.Fields(string(data)) // "max 100000" or "200000 100000"
+		if len(fields) == 2 && fields[0] != "max" {
+			return cpuQuota(fields[0], fields[1])
+		}
+		return 0
+	}
+
+	quota, err1 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	period, err2 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period))) // quota is -1 if not limited
+}
+
+func cpuQuota(quota string, period string) float64 {
+	q, err1 := strconv.ParseFloat(quota, 64)
+	p, err2 := strconv.ParseFloat(period, 64)
+	if err1 != nil || err2 != nil || q <= 0 || p <= 0 {
+		return 0
+	}
+	return q / p
+}
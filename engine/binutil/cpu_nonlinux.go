@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package binutil
+
+import "github.com/pkg/errors"
+
+func getCPUAffinity() ([]int, error) {
+	return nil, errors.Errorf("CPU affinity is only supported on Linux")
+}
+
+func setCPUAffinity(cpus []int) error {
+	return errors.Errorf("CPU affinity is only supported on Linux")
+}
+
+func cgroupCPULimit() float64 {
+	// cgroups are only available on Linux
+	return 0
+}
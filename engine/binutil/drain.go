@@ -0,0 +1,26 @@
+package binutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SetupDrainHandler registers the HTTP handler which starts draining the server
+//
+//	POST /drain        starts draining, drain is called in the HTTP server goroutine
+//	POST /admin/drain  starts draining by the admin API
+func SetupDrainHandler(drain func()) {
+	RegisterAdminHandler(http.MethodPost, "drain", func(params url.Values) (interface{}, error) {
+		drain()
+		return map[string]bool{"ok": true}, nil
+	})
+	http.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		drain()
+		fmt.Fprintln(w, "draining")
+	})
+}
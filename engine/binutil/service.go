@@ -0,0 +1,41 @@
+package binutil
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Service manager integration
+//
+// Processes report readiness and liveness to systemd (Type=notify with WatchdogSec=) through NOTIFY_SOCKET, and run
+// under the Windows service control manager if they are started as Windows services. Both do nothing if processes are
+// not started by service managers, e.g. started by the goworld command.
+
+// NotifyReady tells the service manager that the process is ready, and starts watchdog keep-alives if enabled
+//
+// Keep-alives are sent by the main goroutine through post.Post, so a stuck main loop is detected by the service manager.
+func NotifyReady() {
+	sdNotify("READY=1")
+	reportServiceRunning()
+
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	gwlog.Infof("systemd watchdog is enabled, interval = %s", interval)
+	go func() {
+		for range time.Tick(interval / 2) {
+			post.Post(func() {
+				sdNotify("WATCHDOG=1")
+			})
+		}
+	}()
+}
+
+// NotifyStopping tells the service manager that the process is stopping gracefully
+func NotifyStopping() {
+	sdNotify("STOPPING=1")
+}
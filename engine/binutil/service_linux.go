@@ -0,0 +1,45 @@
+package binutil
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// sdNotify sends the state to systemd if the process is started by systemd with NOTIFY_SOCKET
+func sdNotify(state string) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return
+	}
+	if socketAddr[0] == '@' { // abstract socket
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		gwlog.Errorf("sdNotify: connect %s failed: %s", socketAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		gwlog.Errorf("sdNotify: send %s failed: %s", state, err)
+	}
+}
+
+// watchdogInterval returns the watchdog interval set by systemd, or 0 if watchdog is not enabled for this process
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package binutil
+
+import "time"
+
+func sdNotify(state string) {
+	// systemd is only available on Linux
+}
+
+func watchdogInterval() time.Duration {
+	return 0
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package binutil
+
+// SetupService runs the process under the Windows service control manager if it is started as a Windows service
+//
+// It does nothing on other platforms: service managers like systemd stop processes by SIGTERM.
+func SetupService(name string, stop func()) {
+}
+
+func reportServiceRunning() {
+}
@@ -0,0 +1,63 @@
+//go:build windows
+// +build windows
+
+package binutil
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"golang.org/x/sys/windows/svc"
+)
+
+var serviceRunning = make(chan struct{})
+
+// SetupService runs the process under the Windows service control manager if it is started as a Windows service
+//
+// stop is called when the service is requested to stop, and should terminate the process gracefully.
+func SetupService(name string, stop func()) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		gwlog.Fatalf("check interactive session failed: %s", err)
+	}
+	if interactive {
+		return
+	}
+
+	gwlog.Infof("run as Windows service %s", name)
+	go func() {
+		if err := svc.Run(name, &serviceHandler{stop: stop}); err != nil {
+			gwlog.Fatalf("run Windows service %s failed: %s", name, err)
+		}
+	}()
+}
+
+func reportServiceRunning() {
+	select {
+	case <-serviceRunning:
+	default:
+		close(serviceRunning)
+	}
+}
+
+type serviceHandler struct {
+	stop func()
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	running := serviceRunning
+	for {
+		select {
+		case <-running:
+			status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+			running = nil
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.stop() // the process exits after terminated gracefully
+			}
+		}
+	}
+}
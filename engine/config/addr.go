@@ -0,0 +1,58 @@
+package config
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Addresses in config are host:port, where the host can be a hostname, an IPv4 literal or a bracketed IPv6 literal,
+// e.g. 127.0.0.1:13000, [::1]:13000 or dispatcher1.local:13000. Listeners on [::] accept both IPv4 and IPv6 connections
+// if the OS supports dual-stack sockets. Port-only addresses (e.g. 25001) are on localhost.
+
+// readAddr reads the address of the key, port-only addresses are normalized to localhost:port
+func (c *Config) readAddr(sec *ini.Section, key *ini.Key, defaultAddr string) string {
+	addr := key.MustString(defaultAddr)
+	if addr == "" {
+		return ""
+	}
+	if _, err := strconv.ParseUint(addr, 10, 16); err == nil {
+		addr = net.JoinHostPort("localhost", addr)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		c.configFatalf("section %s: invalid %s %#v, should be host:port (IPv6 literals should be bracketed, e.g. [::1]:13000): %v", sec.Name(), key.Name(), addr, err)
+	}
+	return addr
+}
+
+// readHost reads the host of the key, which can be a hostname or an IP literal, IPv6 literals can be bracketed
+func (c *Config) readHost(sec *ini.Section, key *ini.Key, defaultHost string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(key.MustString(defaultHost), "["), "]")
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		c.configFatalf("section %s: invalid %s %#v, should be a hostname or an IP address without port", sec.Name(), key.Name(), host)
+	}
+	return host
+}
+
+// DialAddr returns the address to dial the listen address on the same host, wildcard hosts are replaced by loopback
+// addresses of the same IP family
+func DialAddr(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	} else if host == "::" {
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isWildcardAddr returns if the host of the address is a wildcard, which can not be dialed
+func isWildcardAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isWildcardHost(host)
+}
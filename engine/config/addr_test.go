@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestDialAddr(t *testing.T) {
+	for listenAddr, dialAddr := range map[string]string{
+		"0.0.0.0:14000":      "127.0.0.1:14000",
+		":14000":             "127.0.0.1:14000",
+		"[::]:14000":         "[::1]:14000",
+		"[fe80::1]:14000":    "[fe80::1]:14000",
+		"gate1.local:14000":  "gate1.local:14000",
+		"192.168.1.10:14000": "192.168.1.10:14000",
+	} {
+		if addr := DialAddr(listenAddr); addr != dialAddr {
+			t.Errorf("dial address of %s should be %s, but got %s", listenAddr, dialAddr, addr)
+		}
+	}
+}
+
+func TestIPv6Addrs(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.HTTPAddr != "localhost:25001" {
+		t.Errorf("port-only http_addr should be on localhost: %s", gc.HTTPAddr)
+	}
+
+	c.SetOverride("dispatcher1", "listen_addr", "[::]:13001")
+	c.SetOverride("dispatcher1", "advertise_addr", "")
+	c.SetOverride("dispatcher1", "http_addr", "[::1]:23001")
+	c.SetOverride("gate1", "listen_addr", "[::]:14001")
+	if dc := c.GetDispatcher(1); dc.ListenAddr != "[::]:13001" || dc.AdvertiseAddr != "[::1]:13001" || dc.HTTPAddr != "[::1]:23001" {
+		t.Errorf("wrong IPv6 dispatcher addresses: %+v", dc)
+	}
+	if gc := c.GetGate(1); gc.ListenAddr != "[::]:14001" {
+		t.Errorf("wrong IPv6 gate address: %s", gc.ListenAddr)
+	}
+
+	c.SetOverride("dispatcher1", "advertise_ip", "[2001:db8::1]")
+	if dc := c.GetDispatcher(1); dc.AdvertiseIP != "2001:db8::1" || dc.AdvertiseAddr != "[2001:db8::1]:13001" {
+		t.Errorf("advertise_ip should be dialed with the port of listen_addr: %+v", dc)
+	}
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"sync"
+)
+
+// Config is a handle of GoWorld config read from a config file
+//
+// Each Config has its own config file, overrides, local node and change handlers, so configs of multiple clusters can
+// be used in one process. Components create or receive a Config and pass it to engine packages which read config.
+// Package level functions such as Get and GetGame are deprecated wrappers of the default Config, which is replaced by
+// components with their Config by SetDefault, so that game code still using them reads the same config.
+type Config struct {
+	lock               sync.Mutex
+	filePath           string
+	config             *GoWorldConfig
+	overrides          []configOverride
+	localNodeKind      string
+	localNodeID        uint16
+	validateErrors     *[]error // config errors are collected instead of quitting if not nil
+	reloading          bool     // config errors panic instead of quitting when reloading by watching
+	changeHandlers     map[string][]ChangeHandler
+	changeHandlersLock sync.Mutex
+	watchOnce          sync.Once
+}
+
+// New creates a Config of the config file, which is read when used
+func New(configFile string) *Config {
+	return &Config{
+		filePath:       configFile,
+		changeHandlers: map[string][]ChangeHandler{},
+	}
+}
+
+var (
+	defaultConfig     = New(_DEFAULT_CONFIG_FILE)
+	defaultConfigLock sync.RWMutex
+)
+
+// Default returns the default Config
+func Default() *Config {
+	defaultConfigLock.RLock()
+	defer defaultConfigLock.RUnlock()
+	return defaultConfig
+}
+
+// SetDefault replaces the default Config used by package level functions
+func SetDefault(c *Config) {
+	defaultConfigLock.Lock()
+	defaultConfig = c
+	defaultConfigLock.Unlock()
+}
+
+// SetConfigFile sets the config file path of the default Config
+//
+// Deprecated: use Config.SetConfigFile of the Config passed by the component instead.
+func SetConfigFile(f string) {
+	Default().SetConfigFile(f)
+}
+
+// GetConfigDir returns the directory of the config file of the default Config
+//
+// Deprecated: use Config.GetConfigDir of the Config passed by the component instead.
+func GetConfigDir() string {
+	return Default().GetConfigDir()
+}
+
+// GetConfigFilePath returns the config file path of the default Config
+//
+// Deprecated: use Config.GetConfigFilePath of the Config passed by the component instead.
+func GetConfigFilePath() string {
+	return Default().GetConfigFilePath()
+}
+
+// Get returns the total GoWorld config of the default Config
+//
+// Deprecated: use Config.Get of the Config passed by the component instead.
+func Get() *GoWorldConfig {
+	return Default().Get()
+}
+
+// Reload forces goworld server to reload the whole config
+//
+// Deprecated: use Config.Reload of the Config passed by the component instead.
+func Reload() *GoWorldConfig {
+	return Default().Reload()
+}
+
+// GetDeployment returns the deployment config
+//
+// Deprecated: use Config.GetDeployment of the Config passed by the component instead.
+func GetDeployment() *DeploymentConfig {
+	return Default().GetDeployment()
+}
+
+// GetGame gets the game config of specified game ID
+//
+// Deprecated: use Config.GetGame of the Config passed by the component instead.
+func GetGame(gameid uint16) *GameConfig {
+	return Default().GetGame(gameid)
+}
+
+// GetGate gets the gate config of specified gate ID
+//
+// Deprecated: use Config.GetGate of the Config passed by the component instead.
+func GetGate(gateid uint16) *GateConfig {
+	return Default().GetGate(gateid)
+}
+
+// GetServerList returns the server list of all gates
+//
+// Deprecated: use Config.GetServerList of the Config passed by the component instead.
+func GetServerList() []GateServer {
+	return Default().GetServerList()
+}
+
+// GetGamesByLabels returns IDs of games with all labels of the selector in order
+//
+// Deprecated: use Config.GetGamesByLabels of the Config passed by the component instead.
+func GetGamesByLabels(selector map[string]string) []uint16 {
+	return Default().GetGamesByLabels(selector)
+}
+
+// CheckCPUOverlaps returns games and gates on the same host which may run on the same CPUs
+//
+// Deprecated: use Config.CheckCPUOverlaps of the Config passed by the component instead.
+func CheckCPUOverlaps() []CPUOverlap {
+	return Default().CheckCPUOverlaps()
+}
+
+// AutoCPUAffinity returns the share of available CPUs of the process with auto affinity
+//
+// Deprecated: use Config.AutoCPUAffinity of the Config passed by the component instead.
+func AutoCPUAffinity(processName string, available []int) []int {
+	return Default().AutoCPUAffinity(processName, available)
+}
+
+// GetDispatcherIDs returns all dispatcher IDs
+//
+// Deprecated: use Config.GetDispatcherIDs of the Config passed by the component instead.
+func GetDispatcherIDs() []uint16 {
+	return Default().GetDispatcherIDs()
+}
+
+// GetDispatcher returns the dispatcher config
+//
+// Deprecated: use Config.GetDispatcher of the Config passed by the component instead.
+func GetDispatcher(dispid uint16) *DispatcherConfig {
+	return Default().GetDispatcher(dispid)
+}
+
+// GetStorage returns the storage config
+//
+// Deprecated: use Config.GetStorage of the Config passed by the component instead.
+func GetStorage() *StorageConfig {
+	return Default().GetStorage()
+}
+
+// GetStorageByName returns the storage config in [storage_<name>] section, or nil if not found
+//
+// Deprecated: use Config.GetStorageByName of the Config passed by the component instead.
+func GetStorageByName(name string) *StorageConfig {
+	return Default().GetStorageByName(name)
+}
+
+// GetKVDB returns the KVDB config
+//
+// Deprecated: use Config.GetKVDB of the Config passed by the component instead.
+func GetKVDB() *KVDBConfig {
+	return Default().GetKVDB()
+}
+
+// Debug returns if debug is enabled
+//
+// Deprecated: use Config.Debug of the Config passed by the component instead.
+func Debug() bool {
+	return Default().Debug()
+}
+
+// GetAdminToken returns the token of the admin HTTP API
+//
+// Deprecated: use Config.GetAdminToken of the Config passed by the component instead.
+func GetAdminToken() string {
+	return Default().GetAdminToken()
+}
+
+// GetDebug returns the debug config, including users of the admin HTTP API
+//
+// Deprecated: use Config.GetDebug of the Config passed by the component instead.
+func GetDebug() *DebugConfig {
+	return Default().GetDebug()
+}
+
+// GetTracing returns the tracing config
+//
+// Deprecated: use Config.GetTracing of the Config passed by the component instead.
+func GetTracing() *TracingConfig {
+	return Default().GetTracing()
+}
+
+// GetTelemetry returns the config of metrics exporters
+//
+// Deprecated: use Config.GetTelemetry of the Config passed by the component instead.
+func GetTelemetry() *TelemetryConfig {
+	return Default().GetTelemetry()
+}
+
+// SetOverride overrides the config key in section of the default Config
+//
+// Deprecated: use Config.SetOverride of the Config passed by the component instead.
+func SetOverride(section, key, value string) {
+	Default().SetOverride(section, key, value)
+}
+
+// ParseOverride parses override in the form of section.key=value and sets the override of the default Config
+//
+// Deprecated: use Config.ParseOverride of the Config passed by the component instead.
+func ParseOverride(s string) error {
+	return Default().ParseOverride(s)
+}
+
+// SetLocalNode sets the kind and ID of this process in the default Config
+//
+// Deprecated: use Config.SetLocalNode of the Config passed by the component instead.
+func SetLocalNode(kind string, id uint16) {
+	Default().SetLocalNode(kind, id)
+}
+
+// RegisterLocalNode registers this process as a live node of the cluster of the default Config
+//
+// Deprecated: use Config.RegisterLocalNode of the Config passed by the component instead.
+func RegisterLocalNode(addr string) {
+	Default().RegisterLocalNode(addr)
+}
+
+// OnChange registers the handler which is called when config of the section of the default Config changes
+//
+// Deprecated: use Config.OnChange of the Config passed by the component instead.
+func OnChange(section string, handler ChangeHandler) {
+	Default().OnChange(section, handler)
+}
+
+// Watch starts watching the config file of the default Config
+//
+// Deprecated: use Config.Watch of the Config passed by the component instead.
+func Watch() {
+	Default().Watch()
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigLoader loads the config file into ini sections
+//
+// Config files of all formats are converted to ini sections, so they share the same defaulting and validation.
+type ConfigLoader func(configFile string) (*ini.File, error)
+
+var (
+	configLoaders = map[string]ConfigLoader{
+		".ini":  loadINIConfig,
+		".yaml": loadYAMLConfig,
+		".yml":  loadYAMLConfig,
+		".json": loadJSONConfig,
+	}
+)
+
+// RegisterConfigLoader registers the config loader for config files with the extension (e.g. ".toml")
+func RegisterConfigLoader(ext string, loader ConfigLoader) {
+	configLoaders[strings.ToLower(ext)] = loader
+}
+
+// loadConfigFile loads the config file by the loader of its extension, config files of unknown extensions are loaded as ini
+func loadConfigFile(configFile string) (*ini.File, error) {
+	loader := configLoaders[strings.ToLower(path.Ext(configFile))]
+	if loader == nil {
+		loader = loadINIConfig
+	}
+	return loader(configFile)
+}
+
+func loadINIConfig(configFile string) (*ini.File, error) {
+	return ini.Load(configFile)
+}
+
+// loadYAMLConfig loads YAML config file which maps section names to maps of keys and values, e.g.
+//
+//	game_common:
+//	  boot_entity: Account
+//	  save_interval: 600
+func loadYAMLConfig(configFile string) (*ini.File, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections yaml.MapSlice
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", configFile)
+	}
+
+	iniFile := ini.Empty()
+	for _, section := range sections {
+		secName := fmt.Sprint(section.Key)
+		sec, err := iniFile.NewSection(secName)
+		if err != nil {
+			return nil, err
+		}
+
+		if section.Value == nil { // empty section
+			continue
+		}
+
+		keys, ok := section.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, errors.Errorf("section %s should be a map, but is %T", secName, section.Value)
+		}
+		for _, item := range keys {
+			if err := setConfigKey(sec, fmt.Sprint(item.Key), item.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return iniFile, nil
+}
+
+// loadJSONConfig loads JSON config file which maps section names to objects of keys and values
+func loadJSONConfig(configFile string) (*ini.File, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections map[string]map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&sections); err != nil {
+		return nil, errors.Wrapf(err, "parse %s failed", configFile)
+	}
+
+	secNames := make([]string, 0, len(sections))
+	for secName := range sections {
+		secNames = append(secNames, secName)
+	}
+	sort.Strings(secNames)
+
+	iniFile := ini.Empty()
+	for _, secName := range secNames {
+		sec, err := iniFile.NewSection(secName)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := sections[secName]
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := setConfigKey(sec, name, keys[name]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return iniFile, nil
+}
+
+// setConfigKey sets the key in section to the scalar value, lists are set as numbered keys (e.g. start_nodes_1, start_nodes_2)
+func setConfigKey(sec *ini.Section, name string, val interface{}) error {
+	if list, ok := val.([]interface{}); ok {
+		for i, item := range list {
+			if err := setConfigKey(sec, name+"_"+strconv.Itoa(i+1), item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var s string
+	switch v := val.(type) {
+	case nil:
+		s = ""
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		s = v.String()
+	default:
+		return errors.Errorf("section %s key %s should be a scalar, but is %T", sec.Name(), name, val)
+	}
+
+	_, err := sec.NewKey(name, s)
+	return err
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func TestYAMLAndJSONConfig(t *testing.T) {
+	t.Parallel()
+	iniFile, err := ini.Load(sampleConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "goworld_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// convert the sample ini config to YAML and JSON
+	var yamlText strings.Builder
+	jsonSections := map[string]map[string]string{}
+	for _, sec := range iniFile.Sections() {
+		if sec.Name() == ini.DefaultSection {
+			continue
+		}
+		fmt.Fprintf(&yamlText, "%s:\n", sec.Name())
+		jsonSections[sec.Name()] = map[string]string{}
+		for _, key := range sec.Keys() {
+			fmt.Fprintf(&yamlText, "  %s: %q\n", key.Name(), key.Value())
+			jsonSections[sec.Name()][key.Name()] = key.Value()
+		}
+	}
+	jsonText, _ := json.Marshal(jsonSections)
+
+	yamlFile := filepath.Join(dir, "goworld.yaml")
+	jsonFile := filepath.Join(dir, "goworld.json")
+	if err := ioutil.WriteFile(yamlFile, []byte(yamlText.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jsonFile, jsonText, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	iniConfig := New(sampleConfigFile).Get()
+	for _, configFile := range []string{yamlFile, jsonFile} {
+		if cfg := New(configFile).Get(); !reflect.DeepEqual(cfg, iniConfig) {
+			t.Errorf("config loaded from %s is different from ini config:\n%s\n%s", configFile, DumpPretty(cfg), DumpPretty(iniConfig))
+		}
+	}
+}
+
+func TestYAMLConfigValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "goworld.yml")
+	yamlText := "deployment:\n  desired_games: 2\nstorage:\n  type: redis_cluster\n  start_nodes: [127.0.0.1:7000, 127.0.0.1:7001]\nempty:\n"
+	if err := ioutil.WriteFile(yamlFile, []byte(yamlText), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	iniFile, err := loadConfigFile(yamlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := iniFile.Section("deployment").Key("desired_games").MustInt(0); v != 2 {
+		t.Errorf("desired_games = %d, should be 2", v)
+	}
+	if v := iniFile.Section("storage").Key("start_nodes_2").String(); v != "127.0.0.1:7001" {
+		t.Errorf("start_nodes_2 = %s", v)
+	}
+
+	if err := ioutil.WriteFile(yamlFile, []byte("storage:\n  type:\n    nested: map\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(yamlFile); err == nil {
+		t.Errorf("nested map should not be allowed")
+	}
+}
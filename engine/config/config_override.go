@@ -0,0 +1,126 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// ENV_OVERRIDE_PREFIX is the prefix of environment variables which override config keys, e.g. GOWORLD_STORAGE_URL overrides storage.url
+	ENV_OVERRIDE_PREFIX = "GOWORLD_"
+)
+
+var (
+	knownSections       = []string{"deployment", "storage", "kvdb", "debug", "tracing", "dispatcher_common", "game_common", "gate_common"}
+	numberedSectionRegx = regexp.MustCompile(`^((?:DISPATCHER|GAME|GATE)\d+)_(.+)$`)
+)
+
+type configOverride struct {
+	section string
+	key     string
+	value   string
+}
+
+// SetOverride overrides the config key in section, which takes precedence over the config file and environment variables
+func (c *Config) SetOverride(section, key, value string) {
+	c.lock.Lock()
+	c.overrides = append(c.overrides, configOverride{strings.ToLower(section), strings.ToLower(key), value})
+	c.config = nil // config should be read again
+	c.lock.Unlock()
+}
+
+// ParseOverride parses override in the form of section.key=value and sets the override
+func (c *Config) ParseOverride(s string) error {
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return errors.Errorf("invalid config override %q, should be section.key=value", s)
+	}
+	sectionKey := s[:eq]
+	dot := strings.IndexByte(sectionKey, '.')
+	if dot <= 0 || dot == len(sectionKey)-1 {
+		return errors.Errorf("invalid config override %q, should be section.key=value", s)
+	}
+	c.SetOverride(sectionKey[:dot], sectionKey[dot+1:], s[eq+1:])
+	return nil
+}
+
+// OverrideFlag is the flag.Value for overriding config keys from command line, e.g. -set gate1.port=15011
+//
+// Usage: flag.Var(config.OverrideFlag{Config: c}, "set", "override config key: section.key=value")
+type OverrideFlag struct {
+	Config *Config // the default Config if nil
+}
+
+func (OverrideFlag) String() string {
+	return ""
+}
+
+// Set sets the config override
+func (f OverrideFlag) Set(s string) error {
+	c := f.Config
+	if c == nil {
+		c = Default()
+	}
+	return c.ParseOverride(s)
+}
+
+// applyOverrides applies overrides from environment variables and then overrides set by SetOverride to config sections
+func (c *Config) applyOverrides(iniFile *ini.File) {
+	for _, kv := range os.Environ() {
+		eq := strings.IndexByte(kv, '=')
+		if eq < 0 || !strings.HasPrefix(kv[:eq], ENV_OVERRIDE_PREFIX) {
+			continue
+		}
+
+		name := kv[len(ENV_OVERRIDE_PREFIX):eq]
+		section, key := parseEnvOverrideName(iniFile, name)
+		if section == "" {
+			gwlog.Warnf("config override %s%s is ignored: unknown section", ENV_OVERRIDE_PREFIX, name)
+			continue
+		}
+		c.setOverrideKey(iniFile, section, key, kv[eq+1:], ENV_OVERRIDE_PREFIX+name)
+	}
+
+	for _, o := range c.overrides {
+		c.setOverrideKey(iniFile, o.section, o.key, o.value, "-set "+o.section+"."+o.key)
+	}
+}
+
+// parseEnvOverrideName splits environment variable name (without prefix) into section and key, e.g. GAME_COMMON_LOG_LEVEL => game_common, log_level
+func parseEnvOverrideName(iniFile *ini.File, name string) (section string, key string) {
+	if m := numberedSectionRegx.FindStringSubmatch(name); m != nil {
+		return strings.ToLower(m[1]), strings.ToLower(m[2])
+	}
+
+	// sections might contain underscore, so match the longest section name
+	sections := append([]string{}, knownSections...)
+	sections = append(sections, iniFile.SectionStrings()...)
+	for _, sec := range sections {
+		prefix := strings.ToUpper(sec) + "_"
+		if len(name) > len(prefix) && strings.HasPrefix(name, prefix) && len(sec) > len(section) {
+			section, key = strings.ToLower(sec), strings.ToLower(name[len(prefix):])
+		}
+	}
+	return
+}
+
+func (c *Config) setOverrideKey(iniFile *ini.File, section, key, value string, source string) {
+	sec, err := iniFile.GetSection(section)
+	if err != nil {
+		sec, err = iniFile.NewSection(section)
+		c.checkConfigError(err, "")
+	}
+	if sec.HasKey(key) {
+		sec.Key(key).SetValue(value)
+	} else {
+		_, err = sec.NewKey(key, value)
+		c.checkConfigError(err, "")
+	}
+	// values are not logged since they might be credentials
+	gwlog.Infof("Config %s.%s is overridden by %s", section, key, source)
+}
@@ -0,0 +1,686 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOverrides(t *testing.T) {
+	c := New(sampleConfigFile)
+
+	os.Setenv("GOWORLD_GAME_COMMON_LOG_LEVEL", "warn")
+	os.Setenv("GOWORLD_GATE1_GOMAXPROCS", "3")
+	os.Setenv("GOWORLD_STORAGE_DB", "env_db")
+	defer os.Unsetenv("GOWORLD_GAME_COMMON_LOG_LEVEL")
+	defer os.Unsetenv("GOWORLD_GATE1_GOMAXPROCS")
+	defer os.Unsetenv("GOWORLD_STORAGE_DB")
+
+	if err := c.ParseOverride("storage.db=flag_db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("dispatcher_common.listen_addr"); err == nil {
+		t.Errorf("override without value should be invalid")
+	}
+	if err := c.ParseOverride("listen_addr=:0"); err == nil {
+		t.Errorf("override without section should be invalid")
+	}
+
+	if v := c.GetGame(1).LogLevel; v != "warn" {
+		t.Errorf("game1 log level = %s, should be warn", v)
+	}
+	if v := c.GetGate(1).GoMaxProcs; v != 3 {
+		t.Errorf("gate1 GOMAXPROCS = %d, should be 3", v)
+	}
+	if v := c.GetStorage().DB; v != "flag_db" {
+		t.Errorf("storage db = %s, should be flag_db", v)
+	}
+}
+
+func TestPostgresConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	for _, override := range []string{
+		"storage.type=postgres",
+		"storage.url=postgres://127.0.0.1/goworld",
+		"storage.max_open_conns=20",
+		"storage.conn_max_lifetime_ms=60000",
+		"kvdb.type=postgres",
+		"kvdb.url=postgres://127.0.0.1/goworld",
+		"kvdb.driver=pgx",
+		"kvdb.max_idle_conns=5",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sc := c.GetStorage(); sc.Driver != "postgres" || sc.MaxOpenConns != 20 || sc.ConnMaxLifetime != time.Minute {
+		t.Errorf("wrong postgres storage config: %+v", sc)
+	}
+	if kc := c.GetKVDB(); kc.Driver != "pgx" || kc.MaxIdleConns != 5 {
+		t.Errorf("wrong postgres KVDB config: %+v", kc)
+	}
+}
+
+func TestWriteBehindConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if sc := c.GetStorage(); sc.WriteBehindInterval != 0 || sc.WriteBehindMaxPending != _DEFAULT_WRITE_BEHIND_MAX_PENDING {
+		t.Errorf("write-behind should be disabled by default: %+v", sc)
+	}
+
+	for _, override := range []string{
+		"storage.write_behind_interval_ms=500",
+		"storage.write_behind_max_pending=0",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sc := c.GetStorage(); sc.WriteBehindInterval != time.Millisecond*500 || sc.WriteBehindMaxPending != 0 {
+		t.Errorf("wrong write-behind config: %+v", sc)
+	}
+}
+
+func TestAsyncWorkerPoolSizeConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.AsyncWorkerPoolSize != _DEFAULT_ASYNC_WORKER_POOL_SIZE {
+		t.Errorf("async worker pool size should be %d by default: %+v", _DEFAULT_ASYNC_WORKER_POOL_SIZE, gc)
+	}
+
+	if err := c.ParseOverride("game1.async_worker_pool_size=8"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); gc.AsyncWorkerPoolSize != 8 {
+		t.Errorf("wrong async worker pool size: %d", gc.AsyncWorkerPoolSize)
+	}
+	if gc := c.GetGame(2); gc.AsyncWorkerPoolSize != _DEFAULT_ASYNC_WORKER_POOL_SIZE {
+		t.Errorf("async worker pool size of game2 should not be changed: %d", gc.AsyncWorkerPoolSize)
+	}
+}
+
+func TestAOIImplementationConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.AOIImplementation != "xzlist" || gc.AOITowerCellSize != 0 {
+		t.Errorf("AOI implementation should be xzlist by default: %+v", gc)
+	}
+
+	if err := c.ParseOverride("game1.aoi_implementation=Tower"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("game1.aoi_tower_cell_size=50"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); gc.AOIImplementation != "tower" || gc.AOITowerCellSize != 50 {
+		t.Errorf("wrong AOI implementation: %s, cell size %v", gc.AOIImplementation, gc.AOITowerCellSize)
+	}
+}
+
+func TestAttrPatchConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.AttrPatchSync || gc.AttrPatchPrecision != 0 {
+		t.Errorf("attribute patch sync should be disabled by default: %+v", gc)
+	}
+
+	if err := c.ParseOverride("game1.attr_patch_sync=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("game1.attr_patch_float_precision=0.01"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); !gc.AttrPatchSync || gc.AttrPatchPrecision != 0.01 {
+		t.Errorf("wrong attribute patch config: %v, precision %v", gc.AttrPatchSync, gc.AttrPatchPrecision)
+	}
+}
+
+func TestResumeDiffSyncConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.ResumeDiffSync {
+		t.Errorf("resume diff sync should be disabled by default")
+	}
+
+	if err := c.ParseOverride("game_common.resume_diff_sync=1"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); !gc.ResumeDiffSync {
+		t.Errorf("resume diff sync should be enabled")
+	}
+}
+
+func TestCronTimezoneConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.CronTimezone != "" {
+		t.Errorf("cron timezone should be empty by default: %s", gc.CronTimezone)
+	}
+
+	if err := c.ParseOverride("game_common.cron_timezone=UTC"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); gc.CronTimezone != "UTC" {
+		t.Errorf("wrong cron timezone: %s", gc.CronTimezone)
+	}
+}
+
+func TestCreationQueueConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if dc := c.GetDispatcher(1); dc.OverloadCPUPercent != 0 || dc.CreationQueueMaxLen != _DEFAULT_CREATION_QUEUE_MAX_LEN || dc.CreationQueueTimeout != _DEFAULT_CREATION_QUEUE_TIMEOUT {
+		t.Errorf("wrong default creation queue config: %+v", dc)
+	}
+
+	if err := c.ParseOverride("dispatcher_common.overload_cpu_percent=80"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("dispatcher_common.creation_queue_max_len=100"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("dispatcher_common.creation_queue_timeout_ms=3000"); err != nil {
+		t.Fatal(err)
+	}
+	if dc := c.GetDispatcher(1); dc.OverloadCPUPercent != 80 || dc.CreationQueueMaxLen != 100 || dc.CreationQueueTimeout != time.Second*3 {
+		t.Errorf("wrong creation queue config: %+v", dc)
+	}
+}
+
+func TestAutoMigrateConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if dc := c.GetDeployment(); dc.AutoMigrate || dc.AutoMigrateCPUPercent != _DEFAULT_AUTO_MIGRATE_CPU_PERCENT || dc.AutoMigrateBatchSize != _DEFAULT_AUTO_MIGRATE_BATCH_SIZE {
+		t.Errorf("wrong default auto migrate config: %+v", dc)
+	}
+
+	if err := c.ParseOverride("deployment.auto_migrate=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("deployment.auto_migrate_cpu_percent=50"); err != nil {
+		t.Fatal(err)
+	}
+	if dc := c.GetDeployment(); !dc.AutoMigrate || dc.AutoMigrateCPUPercent != 50 || dc.AutoMigrateCPUDiff != _DEFAULT_AUTO_MIGRATE_CPU_DIFF {
+		t.Errorf("wrong auto migrate config: %+v", dc)
+	}
+}
+
+func TestDrainConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if dc := c.GetDeployment(); dc.DrainTimeout != _DEFAULT_DRAIN_TIMEOUT || dc.DrainBatchSize != _DEFAULT_DRAIN_BATCH_SIZE {
+		t.Errorf("wrong default drain config: %+v", dc)
+	}
+
+	if err := c.ParseOverride("deployment.drain_timeout=30"); err != nil {
+		t.Fatal(err)
+	}
+	if dc := c.GetDeployment(); dc.DrainTimeout != 30 || dc.DrainBatchSize != _DEFAULT_DRAIN_BATCH_SIZE {
+		t.Errorf("wrong drain config: %+v", dc)
+	}
+}
+
+func TestGateWriteTimeoutConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.WriteTimeout != 0 || gc.StuckConnectionTimeout != 0 {
+		t.Errorf("wrong default write timeout config: %+v", gc)
+	}
+
+	if err := c.ParseOverride("gate_common.write_timeout=10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("gate1.stuck_connection_timeout=30"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGate(1); gc.WriteTimeout != 10 || gc.StuckConnectionTimeout != 30 {
+		t.Errorf("wrong write timeout config: %+v", gc)
+	}
+}
+
+func TestGateClientCodecConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.ClientCodec != "msgpack" {
+		t.Errorf("wrong default client codec: %s", gc.ClientCodec)
+	}
+
+	if err := c.ParseOverride("gate_common.client_codec=protobuf"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGate(1); gc.ClientCodec != "protobuf" {
+		t.Errorf("wrong client codec: %s", gc.ClientCodec)
+	}
+}
+
+func TestTCPOptionsConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); !gc.TCPNoDelay || gc.TCPSendBuffer != 1024*1024 || gc.TCPRecvBuffer != 1024*1024 || gc.TCPKeepAlive != 0 {
+		t.Errorf("wrong default gate TCP options: %+v", gc)
+	}
+	for _, override := range []string{
+		"gate_common.tcp_nodelay=false",
+		"gate_common.so_sndbuf=65536",
+		"gate_common.so_rcvbuf=0",
+		"gate_common.tcp_keepalive_interval=30",
+		"dispatcher_common.so_sndbuf=4194304",
+		"dispatcher_common.tcp_keepalive_interval=-1",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gc := c.GetGate(1); gc.TCPNoDelay || gc.TCPSendBuffer != 65536 || gc.TCPRecvBuffer != 0 || gc.TCPKeepAlive != 30 {
+		t.Errorf("wrong gate TCP options: %+v", gc)
+	}
+	if dc := c.GetDispatcher(1); !dc.TCPNoDelay || dc.TCPSendBuffer != 4194304 || dc.TCPRecvBuffer != 1024*1024 || dc.TCPKeepAlive != -1 {
+		t.Errorf("wrong dispatcher TCP options: %+v", dc)
+	}
+}
+
+func TestDispatcherWorkersConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if dc := c.GetDispatcher(1); dc.Workers != 0 {
+		t.Errorf("wrong default dispatcher workers: %d", dc.Workers)
+	}
+	if err := c.ParseOverride("dispatcher_common.workers=8"); err != nil {
+		t.Fatal(err)
+	}
+	if dc := c.GetDispatcher(1); dc.Workers != 8 {
+		t.Errorf("wrong dispatcher workers: %d", dc.Workers)
+	}
+}
+
+func TestGateListenAcceptorsConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.ListenAcceptors != 1 {
+		t.Errorf("wrong default listen acceptors: %d", gc.ListenAcceptors)
+	}
+	if err := c.ParseOverride("gate_common.listen_acceptors=0"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGate(1); gc.ListenAcceptors != 0 {
+		t.Errorf("wrong listen acceptors: %d", gc.ListenAcceptors)
+	}
+}
+
+func TestGateSendWorkersConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.SendWorkers != 0 {
+		t.Errorf("wrong default send workers: %d", gc.SendWorkers)
+	}
+	if err := c.ParseOverride("gate_common.send_workers=4"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGate(1); gc.SendWorkers != 4 {
+		t.Errorf("wrong send workers: %d", gc.SendWorkers)
+	}
+
+	if gc := c.GetGate(1); gc.SendOffloadWorkers != 0 || gc.SendOffloadMinBytes != 4096 {
+		t.Errorf("wrong default send offload: %d workers, %d bytes", gc.SendOffloadWorkers, gc.SendOffloadMinBytes)
+	}
+	c.SetOverride("gate_common", "send_offload_workers", "2")
+	c.SetOverride("gate_common", "send_offload_min_bytes", "1024")
+	if gc := c.GetGate(1); gc.SendOffloadWorkers != 2 || gc.SendOffloadMinBytes != 1024 {
+		t.Errorf("wrong send offload: %d workers, %d bytes", gc.SendOffloadWorkers, gc.SendOffloadMinBytes)
+	}
+}
+
+func TestClientProtocolVersionConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.MinClientProtocolVersion != 0 || gc.MaxClientProtocolVersion != 0 || gc.ClientUpdateURL != "" {
+		t.Errorf("wrong default client protocol versions: %d ~ %d, %s", gc.MinClientProtocolVersion, gc.MaxClientProtocolVersion, gc.ClientUpdateURL)
+	}
+	for _, override := range []string{
+		"gate_common.min_client_protocol_version=1",
+		"gate_common.max_client_protocol_version=2",
+		"gate_common.client_update_url=https://example.com/update",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gc := c.GetGate(1); gc.MinClientProtocolVersion != 1 || gc.MaxClientProtocolVersion != 2 || gc.ClientUpdateURL != "https://example.com/update" {
+		t.Errorf("wrong client protocol versions: %d ~ %d, %s", gc.MinClientProtocolVersion, gc.MaxClientProtocolVersion, gc.ClientUpdateURL)
+	}
+}
+
+func TestRegisteredBackendConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	RegisterStorageType("test_backend")
+	RegisterKVDBType("test_backend")
+	for _, override := range []string{
+		"storage.type=test_backend",
+		"storage.option_cluster_file=fdb.cluster",
+		"kvdb.type=test_backend",
+		"kvdb.option_timeout=3",
+		"gate1.transport_test=127.0.0.1:14002",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sc := c.GetStorage(); sc.Type != "test_backend" || sc.Options["cluster_file"] != "fdb.cluster" {
+		t.Errorf("wrong storage config: %+v", sc)
+	}
+	if kc := c.GetKVDB(); kc.Type != "test_backend" || kc.Options["timeout"] != "3" {
+		t.Errorf("wrong kvdb config: %+v", kc)
+	}
+	if gc := c.GetGate(1); gc.Transports["test"] != "127.0.0.1:14002" {
+		t.Errorf("wrong gate transports: %v", gc.Transports)
+	}
+	if gc := c.GetGate(2); len(gc.Transports) != 0 {
+		t.Errorf("transports of gate2 should not be changed: %v", gc.Transports)
+	}
+}
+
+func TestNamedStorageConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	for _, override := range []string{
+		"storage_mongodb.url=mongodb://127.0.0.1:27017/",
+		"storage_target.type=redis",
+		"storage_target.url=redis://127.0.0.1:6379",
+		"storage_target.db=1",
+	} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sc := c.GetStorageByName("mongodb"); sc == nil || sc.Type != "mongodb" || sc.Url != "mongodb://127.0.0.1:27017/" {
+		t.Errorf("wrong named storage config: %+v", sc)
+	}
+	if sc := c.GetStorageByName("target"); sc == nil || sc.Type != "redis" || sc.DB != "1" {
+		t.Errorf("wrong named storage config: %+v", sc)
+	}
+	if c.GetStorageByName("missing") != nil {
+		t.Errorf("storage should not be found")
+	}
+}
+
+func TestAdminTokenConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if token := c.GetAdminToken(); token != "" {
+		t.Errorf("admin token should be empty by default: %s", token)
+	}
+	if err := c.ParseOverride("debug.admin_token=secret"); err != nil {
+		t.Fatal(err)
+	}
+	if token := c.GetAdminToken(); token != "secret" {
+		t.Errorf("wrong admin token: %s", token)
+	}
+
+	if err := c.ParseOverride("debug.admin_user_alice=readonly:alice-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("debug.admin_user_ops=operator"); err != nil {
+		t.Fatal(err)
+	}
+	dc := c.GetDebug()
+	if alice := dc.AdminUsers["alice"]; alice.Role != AdminRoleReadOnly || alice.Token != "alice-token" {
+		t.Errorf("wrong admin user alice: %+v", alice)
+	}
+	if ops := dc.AdminUsers["ops"]; ops.Role != AdminRoleOperator || ops.Token != "" {
+		t.Errorf("wrong admin user ops: %+v", ops)
+	}
+	if dc.AdminAuditLog != "admin_audit.log" {
+		t.Errorf("wrong admin audit log: %s", dc.AdminAuditLog)
+	}
+}
+
+func TestTracingConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if cfg := c.GetTracing(); cfg.Endpoint != "" {
+		t.Errorf("tracing should be disabled by default: %+v", cfg)
+	}
+	if err := c.ParseOverride("tracing.endpoint=http://127.0.0.1:4318/v1/traces"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := c.GetTracing(); cfg.Endpoint != "http://127.0.0.1:4318/v1/traces" || cfg.SampleRate != 0.01 {
+		t.Errorf("wrong tracing config: %+v", cfg)
+	}
+	if err := c.ParseOverride("tracing.sample_rate=0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := c.GetTracing(); cfg.SampleRate != 0.5 {
+		t.Errorf("wrong tracing sample rate: %v", cfg.SampleRate)
+	}
+}
+
+func TestTelemetryConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if cfg := c.GetTelemetry(); len(cfg.Exporters) != 1 || cfg.Exporters[0] != "prometheus" || cfg.PushInterval != 10 ||
+		cfg.StatsDAddr != "127.0.0.1:8125" || cfg.StatsDPrefix != "goworld." {
+		t.Errorf("wrong default telemetry config: %+v", cfg)
+	}
+	if err := c.ParseOverride("telemetry.exporters=statsd, otlp"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Errorf("otlp exporter without otlp_endpoint should be rejected")
+	}
+	if err := c.ParseOverride("telemetry.otlp_endpoint=http://127.0.0.1:4318/v1/metrics"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("telemetry config should be valid: %v", errs)
+	}
+	if cfg := c.GetTelemetry(); len(cfg.Exporters) != 2 || cfg.Exporters[0] != "statsd" || cfg.Exporters[1] != "otlp" {
+		t.Errorf("wrong telemetry exporters: %+v", cfg.Exporters)
+	}
+	if err := c.ParseOverride("telemetry.push_interval=0"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Errorf("non-positive push_interval should be rejected")
+	}
+}
+
+func TestGateCompressConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGate(1); gc.CompressMinBytes != 512 || gc.CompressMaxEntropy != 7.5 || len(gc.CompressSkipMsgTypes) != 0 {
+		t.Errorf("wrong default compress config: %d bytes, entropy %v, skip %v", gc.CompressMinBytes, gc.CompressMaxEntropy, gc.CompressSkipMsgTypes)
+	}
+	if err := c.ParseOverride("gate_common.compress_skip_msgtypes=MT_SYNC_POSITION_YAW_ON_CLIENTS,MT_CALL_ENTITY_METHOD_ON_CLIENT"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ParseOverride("gate_common.compress_max_entropy=7"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGate(1); gc.CompressMaxEntropy != 7 || len(gc.CompressSkipMsgTypes) != 2 || gc.CompressSkipMsgTypes[1] != "MT_CALL_ENTITY_METHOD_ON_CLIENT" {
+		t.Errorf("wrong compress config: entropy %v, skip %v", gc.CompressMaxEntropy, gc.CompressSkipMsgTypes)
+	}
+}
+
+func TestNamespaceConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if cfg := c.GetStorage(); cfg.Namespace != "" || cfg.UsePreviousNamespace {
+		t.Errorf("data should not be partitioned by default: %+v", cfg)
+	}
+	for _, override := range []string{"storage.namespace=s2", "storage.previous_namespace=", "kvdb.namespace=s2", "kvdb.previous_namespace=s1"} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("namespace config should be valid: %v", errs)
+	}
+	if cfg := c.GetStorage(); cfg.Namespace != "s2" || cfg.PreviousNamespace != "" || !cfg.UsePreviousNamespace {
+		t.Errorf("wrong storage namespaces: %+v", cfg)
+	}
+	if cfg := c.GetKVDB(); cfg.Namespace != "s2" || cfg.PreviousNamespace != "s1" || !cfg.UsePreviousNamespace {
+		t.Errorf("wrong kvdb namespaces: %+v", cfg)
+	}
+	if err := c.ParseOverride("storage.namespace=s_2"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Errorf("namespace with underscore should be rejected")
+	}
+}
+
+func TestLabelsConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if labels := c.GetGame(1).Labels; len(labels) != 0 {
+		t.Errorf("games should not be labeled by default: %v", labels)
+	}
+	for _, override := range []string{"game_common.labels=region:eu, tier:premium", "game1.labels=tier:highmem"} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("labels config should be valid: %v", errs)
+	}
+	if labels := c.GetGame(1).Labels; len(labels) != 2 || labels["region"] != "eu" || labels["tier"] != "highmem" {
+		t.Errorf("labels of game1 should override labels of game_common: %v", labels)
+	}
+	if labels := c.GetGame(2).Labels; labels["tier"] != "premium" {
+		t.Errorf("labels of game_common should be inherited: %v", labels)
+	}
+	if gameids := c.GetGamesByLabels(map[string]string{"tier": "highmem"}); len(gameids) != 1 || gameids[0] != 1 {
+		t.Errorf("wrong games by labels: %v", gameids)
+	}
+	if gameids := c.GetGamesByLabels(map[string]string{"region": "eu"}); len(gameids) != 2 {
+		t.Errorf("wrong games by labels: %v", gameids)
+	}
+
+	if err := c.ParseOverride("game2.labels=tier"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Errorf("label without value should be rejected")
+	}
+	if _, err := ParseLabels("bad-name:x"); err == nil {
+		t.Errorf("invalid label name should be rejected")
+	}
+}
+
+func TestCPUAffinityConfig(t *testing.T) {
+	t.Parallel()
+	if cpus, err := ParseCPUList("4-5, 0-2,1"); err != nil || FormatCPUList(cpus) != "0-2,4-5" {
+		t.Errorf("wrong CPU list: %v %v", cpus, err)
+	}
+	for _, s := range []string{"3-1", "a", "-1"} {
+		if _, err := ParseCPUList(s); err == nil {
+			t.Errorf("invalid CPU list %q should be rejected", s)
+		}
+	}
+
+	c := New(sampleConfigFile)
+	if overlaps := c.CheckCPUOverlaps(); len(overlaps) != 0 {
+		t.Errorf("processes not pinned should not overlap: %v", overlaps)
+	}
+	for _, override := range []string{"deployment.desired_games=2", "game1.cpu_affinity=0-3", "game2.cpu_affinity=auto", "gate_common.cpu_affinity=2,3,4"} {
+		if err := c.ParseOverride(override); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := c.Validate(); len(errs) != 0 {
+		t.Errorf("cpu_affinity config should be valid: %v", errs)
+	}
+	if gc := c.GetGame(1); FormatCPUList(gc.CPUAffinity) != "0-3" || gc.CPUAffinityAuto {
+		t.Errorf("wrong CPU affinity of game1: %v", gc.CPUAffinity)
+	}
+	overlaps := c.CheckCPUOverlaps()
+	if len(overlaps) != 1 || overlaps[0].Game != "game1" || overlaps[0].Gate != "gate1" || FormatCPUList(overlaps[0].CPUs) != "2-3" {
+		t.Errorf("wrong CPU overlaps: %v", overlaps)
+	}
+
+	if err := c.ParseOverride("gate_common.cpu_affinity=auto"); err != nil {
+		t.Fatal(err)
+	}
+	if overlaps := c.CheckCPUOverlaps(); len(overlaps) != 0 {
+		t.Errorf("processes with auto affinity should not overlap: %v", overlaps)
+	}
+	available := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if cpus := c.AutoCPUAffinity("game2", available); FormatCPUList(cpus) != "0-3" {
+		t.Errorf("wrong auto CPU affinity of game2: %v", cpus)
+	}
+	if cpus := c.AutoCPUAffinity("gate1", available); FormatCPUList(cpus) != "4-7" {
+		t.Errorf("wrong auto CPU affinity of gate1: %v", cpus)
+	}
+	if cpus := c.AutoCPUAffinity("game1", available); cpus != nil {
+		t.Errorf("game1 is not pinned automatically: %v", cpus)
+	}
+
+	if err := c.ParseOverride("game1.cpu_affinity=1-x"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := c.Validate(); len(errs) == 0 {
+		t.Errorf("invalid cpu_affinity should be rejected")
+	}
+}
+
+func TestDesyncDetectConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if gc := c.GetGame(1); gc.DesyncDetectThreshold != 0 {
+		t.Errorf("desync detection should be disabled by default")
+	}
+
+	if err := c.ParseOverride("game1.desync_detect_threshold=0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if gc := c.GetGame(1); gc.DesyncDetectThreshold != 0.5 {
+		t.Errorf("desync detect threshold should be 0.5: %v", gc.DesyncDetectThreshold)
+	}
+}
+
+func TestClockSkewConfig(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	if dc := c.GetDeployment(); dc.ClockSkewWarnMS != _DEFAULT_CLOCK_SKEW_WARN_MS || dc.ClockSkewMaxMS != 0 {
+		t.Errorf("wrong default clock skew config: %+v", dc)
+	}
+
+	if err := c.ParseOverride("deployment.clock_skew_max_ms=2000"); err != nil {
+		t.Fatal(err)
+	}
+	if dc := c.GetDeployment(); dc.ClockSkewMaxMS != 2000 || dc.ClockSkewWarnMS != _DEFAULT_CLOCK_SKEW_WARN_MS {
+		t.Errorf("clock skew max should be 2000ms: %+v", dc)
+	}
+}
@@ -5,12 +5,16 @@ import (
 
 	"encoding/json"
 
+	"time"
+
 	"github.com/bmizerany/assert"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 )
 
+const sampleConfigFile = "../../goworld.ini.sample"
+
 func init() {
-	SetConfigFile("../../goworld.ini.sample")
+	SetConfigFile(sampleConfigFile)
 }
 
 func TestLoad(t *testing.T) {
@@ -75,6 +79,20 @@ func TestGetGate(t *testing.T) {
 	GetGate(1)
 }
 
+func TestGateKCPConfig(t *testing.T) {
+	gc := GetGate(1)
+	if gc.ListenKCPPort != 0 || !gc.KCPNoDelay || gc.KCPInterval != 10 || gc.KCPResend != 2 || !gc.KCPNoCongestion {
+		t.Errorf("wrong KCP config: %+v", gc)
+	}
+}
+
+func TestDispatcherStandbyConfig(t *testing.T) {
+	dc := GetDispatcher(1)
+	if dc.StandbyAddr != "" || dc.StandbyListenAddr != "" || dc.FailoverTimeout != time.Second*3 {
+		t.Errorf("wrong standby config: %+v", dc)
+	}
+}
+
 func TestSetConfigFile(t *testing.T) {
 	SetConfigFile("../../goworld.ini")
 }
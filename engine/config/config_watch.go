@@ -0,0 +1,180 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_DEFAULT_WATCH_INTERVAL = time.Second * 5
+)
+
+// ChangeHandler is called with the old and new config of the section when the config file changes
+//
+// old and new are pointers to the config of section, e.g. *GameConfig for game1 and game_common
+type ChangeHandler func(old, new interface{})
+
+// OnChange registers the handler which is called when config of the section changes
+//
+// Sections of games, gates and dispatchers (e.g. game1) get the effective config, which is the common config if the section is not
+// in the config file. Handlers are called in the watching goroutine, so they should post changes to the main routine if necessary.
+func (c *Config) OnChange(section string, handler ChangeHandler) {
+	c.changeHandlersLock.Lock()
+	section = strings.ToLower(section)
+	c.changeHandlers[section] = append(c.changeHandlers[section], handler)
+	c.changeHandlersLock.Unlock()
+}
+
+// Watch starts watching the config file, the config is reloaded and change handlers are called when the config file is modified
+func (c *Config) Watch() {
+	c.watchOnce.Do(func() {
+		go c.watchRoutine(_DEFAULT_WATCH_INTERVAL)
+	})
+}
+
+func (c *Config) watchRoutine(interval time.Duration) {
+	lastVersion := c.configVersion()
+	for {
+		time.Sleep(interval)
+
+		version := c.configVersion()
+		if version == lastVersion || version == "" {
+			continue
+		}
+		lastVersion = version
+
+		gwlog.Infof("Config %s is modified, reloading ...", c.GetConfigFilePath())
+		c.reloadAndNotify()
+	}
+}
+
+// configVersion returns the modify time of config file or version of config source, or empty string if it fails
+func (c *Config) configVersion() string {
+	configFile := c.GetConfigFilePath()
+	if src := c.getSource(configFile); src != nil {
+		version, err := src.Version()
+		if err != nil {
+			gwlog.Errorf("Get config version of %s failed: %v", configFile, err)
+			return ""
+		}
+		return version
+	}
+
+	st, err := os.Stat(configFile)
+	if err != nil {
+		return ""
+	}
+	return st.ModTime().String()
+}
+
+// reloadAndNotify reloads the config file and calls change handlers of sections which are changed
+//
+// The old config is kept if the new config file is invalid
+func (c *Config) reloadAndNotify() {
+	oldConfig := c.Get()
+
+	c.lock.Lock()
+	newConfig, err := c.tryReadGoWorldConfig()
+	if err == nil {
+		c.config = newConfig
+	}
+	c.lock.Unlock()
+
+	if err != nil {
+		gwlog.Errorf("Reload config failed, keep using the old config: %v", err)
+		return
+	}
+
+	c.changeHandlersLock.Lock()
+	handlers := make(map[string][]ChangeHandler, len(c.changeHandlers))
+	for section, hs := range c.changeHandlers {
+		handlers[section] = hs
+	}
+	c.changeHandlersLock.Unlock()
+
+	for section, hs := range handlers {
+		oldVal, newVal := sectionConfig(oldConfig, section), sectionConfig(newConfig, section)
+		if reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+
+		gwlog.Infof("Config [%s] is changed", section)
+		for _, handler := range hs {
+			callChangeHandler(section, handler, oldVal, newVal)
+		}
+	}
+}
+
+func (c *Config) tryReadGoWorldConfig() (config *GoWorldConfig, err error) {
+	c.reloading = true
+	defer func() {
+		c.reloading = false
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.Errorf("%v", r)
+			}
+		}
+	}()
+
+	return c.readGoWorldConfig(), nil
+}
+
+func callChangeHandler(section string, handler ChangeHandler, oldVal, newVal interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			gwlog.TraceError("config change handler of [%s] paniced: %v", section, err)
+		}
+	}()
+	handler(oldVal, newVal)
+}
+
+// sectionConfig returns the effective config of the section
+func sectionConfig(config *GoWorldConfig, section string) interface{} {
+	switch section {
+	case "deployment":
+		return &config.Deployment
+	case "storage":
+		return &config.Storage
+	case "kvdb":
+		return &config.KVDB
+	case "debug":
+		return &config.Debug
+	case "tracing":
+		return &config.Tracing
+	case "game_common":
+		return &config.GameCommon
+	case "gate_common":
+		return &config.GateCommon
+	case "dispatcher_common":
+		return &config.DispatcherCommon
+	}
+
+	if strings.HasPrefix(section, "dispatcher") {
+		if id, err := strconv.Atoi(section[10:]); err == nil {
+			return config._Dispatchers[uint16(id)]
+		}
+	} else if strings.HasPrefix(section, "game") {
+		if id, err := strconv.Atoi(section[4:]); err == nil {
+			if cfg := config._Games[uint16(id)]; cfg != nil {
+				return cfg
+			}
+			return &config.GameCommon
+		}
+	} else if strings.HasPrefix(section, "gate") {
+		if id, err := strconv.Atoi(section[4:]); err == nil {
+			if cfg := config._Gates[uint16(id)]; cfg != nil {
+				return cfg
+			}
+			return &config.GateCommon
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func TestReloadAndNotify(t *testing.T) {
+	t.Parallel()
+	iniFile, err := ini.Load(sampleConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "goworld_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "goworld.ini")
+	if err := iniFile.SaveTo(configFile); err != nil {
+		t.Fatal(err)
+	}
+	c := New(configFile)
+
+	var changes []string
+	c.OnChange("game1", func(old, new interface{}) {
+		changes = append(changes, old.(*GameConfig).LogLevel+"=>"+new.(*GameConfig).LogLevel)
+	})
+
+	oldLogLevel := c.GetGame(1).LogLevel
+	iniFile.Section("game_common").Key("log_level").SetValue("error")
+	if err := iniFile.SaveTo(configFile); err != nil {
+		t.Fatal(err)
+	}
+	c.reloadAndNotify()
+	if len(changes) != 1 || changes[0] != oldLogLevel+"=>error" {
+		t.Fatalf("game1 config change is not notified correctly: %v", changes)
+	}
+
+	// invalid config should not be applied
+	iniFile.Section("deployment").Key("desired_games").SetValue("0")
+	if err := iniFile.SaveTo(configFile); err != nil {
+		t.Fatal(err)
+	}
+	c.reloadAndNotify()
+	if len(changes) != 1 || c.GetGame(1).LogLevel != "error" || c.GetDeployment().DesiredGames <= 0 {
+		t.Errorf("invalid config should not be applied: %v", changes)
+	}
+}
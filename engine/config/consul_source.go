@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_CONSUL_SESSION_TTL = time.Second * 15
+	_CONSUL_TIMEOUT     = time.Second * 5
+)
+
+// consulSource loads config from Consul KV store and registers nodes with Consul sessions
+//
+// URL consul://127.0.0.1:8500/goworld uses the following keys:
+//   - goworld/config/<section>/<key>: config values
+//   - goworld/nodes/<kind>/<id>: addresses of live nodes, which are deleted when the node is down
+type consulSource struct {
+	addr   string
+	prefix string
+	client *http.Client
+
+	sync.Mutex
+	session string
+	nodes   map[string]string // node key => address, registered by this process
+}
+
+type consulKVPair struct {
+	Key   string
+	Value []byte // base64 encoded in JSON
+}
+
+func newConsulSource(u *url.URL) (Source, error) {
+	prefix := strings.Trim(u.Path, "/")
+	if prefix == "" {
+		prefix = "goworld"
+	}
+	return &consulSource{
+		addr:   u.Host,
+		prefix: prefix,
+		client: &http.Client{Timeout: _CONSUL_TIMEOUT},
+		nodes:  map[string]string{},
+	}, nil
+}
+
+func (s *consulSource) String() string {
+	return fmt.Sprintf("consul://%s/%s", s.addr, s.prefix)
+}
+
+// Load loads config from keys under <prefix>/config/
+func (s *consulSource) Load() (*ini.File, error) {
+	configPrefix := s.prefix + "/config/"
+	pairs, err := s.list(configPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	iniFile := ini.Empty()
+	for _, pair := range pairs {
+		sectionKey := strings.TrimPrefix(pair.Key, configPrefix)
+		slash := strings.IndexByte(sectionKey, '/')
+		if slash <= 0 || slash == len(sectionKey)-1 {
+			continue // not a config key
+		}
+		iniFile.Section(sectionKey[:slash]).Key(sectionKey[slash+1:]).SetValue(string(pair.Value))
+	}
+	return iniFile, nil
+}
+
+// Version returns the modify index of keys under prefix
+func (s *consulSource) Version() (string, error) {
+	resp, err := s.request("GET", "/v1/kv/"+s.prefix+"/?keys", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Header.Get("X-Consul-Index"), nil
+}
+
+// Register registers the node with the session of this process
+func (s *consulSource) Register(kind string, id uint16, addr string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.session == "" {
+		if err := s.createSession(); err != nil {
+			return err
+		}
+		go s.keepAliveRoutine()
+	}
+
+	key := fmt.Sprintf("%s/nodes/%s/%d", s.prefix, kind, id)
+	if err := s.acquire(key, addr); err != nil {
+		return err
+	}
+	s.nodes[key] = addr
+	return nil
+}
+
+// Nodes returns live nodes under <prefix>/nodes/<kind>/
+func (s *consulSource) Nodes(kind string) (map[uint16]string, error) {
+	nodesPrefix := s.prefix + "/nodes/" + kind + "/"
+	pairs, err := s.list(nodesPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := map[uint16]string{}
+	for _, pair := range pairs {
+		id, err := strconv.Atoi(strings.TrimPrefix(pair.Key, nodesPrefix))
+		if err != nil || id <= 0 {
+			continue
+		}
+		nodes[uint16(id)] = string(pair.Value)
+	}
+	return nodes, nil
+}
+
+func (s *consulSource) keepAliveRoutine() {
+	for {
+		time.Sleep(_CONSUL_SESSION_TTL / 3)
+
+		s.Lock()
+		err := s.renewSession()
+		if err != nil {
+			gwlog.Errorf("%s: renew session failed: %v, registering again ...", s, err)
+			err = s.reregister()
+		}
+		s.Unlock()
+
+		if err != nil {
+			gwlog.Errorf("%s: register nodes failed: %v", s, err)
+		}
+	}
+}
+
+// reregister registers all nodes again with a new session, after the session is invalidated (e.g. Consul agent restarts)
+func (s *consulSource) reregister() error {
+	if err := s.createSession(); err != nil {
+		return err
+	}
+	for key, addr := range s.nodes {
+		if err := s.acquire(key, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *consulSource) createSession() error {
+	body, _ := json.Marshal(map[string]string{
+		"Name":      "goworld",
+		"TTL":       _CONSUL_SESSION_TTL.String(),
+		"Behavior":  "delete", // delete registered keys when the session is invalidated
+		"LockDelay": "0s",
+	})
+	var ret struct {
+		ID string
+	}
+	if err := s.do("PUT", "/v1/session/create", body, &ret); err != nil {
+		return errors.Wrap(err, "create session failed")
+	}
+	s.session = ret.ID
+	return nil
+}
+
+func (s *consulSource) renewSession() error {
+	return s.do("PUT", "/v1/session/renew/"+s.session, nil, nil)
+}
+
+func (s *consulSource) acquire(key string, value string) error {
+	var ok bool
+	if err := s.do("PUT", "/v1/kv/"+key+"?acquire="+s.session, []byte(value), &ok); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Errorf("%s is registered by another process", key)
+	}
+	return nil
+}
+
+func (s *consulSource) list(prefix string) ([]consulKVPair, error) {
+	resp, err := s.request("GET", "/v1/kv/"+prefix+"?recurse", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s: list %s failed: %s", s, prefix, resp.Status)
+	}
+
+	var pairs []consulKVPair
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+func (s *consulSource) do(method string, path string, body []byte, ret interface{}) error {
+	resp, err := s.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("%s: %s %s failed: %s %s", s, method, path, resp.Status, msg)
+	}
+	if ret == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(ret)
+}
+
+func (s *consulSource) request(method string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, "http://"+s.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req)
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// CPU affinity
+//
+// Games and gates can be pinned to CPUs by cpu_affinity = 0-3,8 in their sections, or by cpu_affinity = auto, which
+// divides CPUs available on the host evenly among games and gates with auto affinity on the same host (by hosts of
+// http_addr). GOMAXPROCS is the number of CPUs the process can run on (including the CPU limit of the container) if
+// gomaxprocs is not set.
+
+const (
+	_CPU_AFFINITY_AUTO = "auto"
+)
+
+// CPUOverlap is a game and a gate on the same host which may run on the same CPUs
+type CPUOverlap struct {
+	Game string
+	Gate string
+	CPUs []int // CPUs pinned by both processes, empty if either process is not pinned
+}
+
+func (o CPUOverlap) String() string {
+	if len(o.CPUs) == 0 {
+		return fmt.Sprintf("%s and %s on the same host may run on the same CPUs, one of them is not pinned by cpu_affinity", o.Game, o.Gate)
+	}
+	return fmt.Sprintf("%s and %s on the same host are pinned to the same CPUs: %s", o.Game, o.Gate, FormatCPUList(o.CPUs))
+}
+
+// ParseCPUList parses the list of CPUs in the form of 0-3,8 (as in taskset and cpusets of Linux)
+func ParseCPUList(s string) ([]int, error) {
+	set := map[int]bool{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last := item, item
+		if dash := strings.IndexByte(item, '-'); dash >= 0 {
+			first, last = item[:dash], item[dash+1:]
+		}
+		begin, err1 := strconv.Atoi(strings.TrimSpace(first))
+		end, err2 := strconv.Atoi(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil || begin < 0 || begin > end {
+			return nil, errors.Errorf("invalid CPU list %q", s)
+		}
+		for cpu := begin; cpu <= end; cpu++ {
+			set[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(set))
+	for cpu := range set {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList formats CPUs in order in the form of 0-3,8
+func FormatCPUList(cpus []int) string {
+	var items []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			items = append(items, strconv.Itoa(cpus[i]))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
+
+func (c *Config) readCPUAffinity(sec *ini.Section, key *ini.Key) (cpus []int, auto bool) {
+	s := strings.TrimSpace(key.String())
+	if strings.ToLower(s) == _CPU_AFFINITY_AUTO {
+		return nil, true
+	}
+	cpus, err := ParseCPUList(s)
+	if err != nil {
+		c.configFatalf("section %s: %s", sec.Name(), err)
+	}
+	return cpus, false
+}
+
+// cpuProcess is a game or gate with its CPU settings
+type cpuProcess struct {
+	name     string
+	isGate   bool
+	host     string // host of http_addr
+	affinity []int
+	auto     bool
+}
+
+func (p *cpuProcess) pinned() bool {
+	return len(p.affinity) > 0 || p.auto
+}
+
+// cpuProcesses returns games and gates in order of names
+func cpuProcesses(config *GoWorldConfig) []*cpuProcess {
+	var procs []*cpuProcess
+	for gameid := 1; gameid <= config.Deployment.DesiredGames; gameid++ {
+		gc := config._Games[uint16(gameid)]
+		if gc == nil {
+			gc = &config.GameCommon
+		}
+		host, _ := splitListenAddr(gc.HTTPAddr)
+		procs = append(procs, &cpuProcess{fmt.Sprintf("game%d", gameid), false, host, gc.CPUAffinity, gc.CPUAffinityAuto})
+	}
+	for gateid := 1; gateid <= config.Deployment.DesiredGates; gateid++ {
+		gc := config._Gates[uint16(gateid)]
+		if gc == nil {
+			gc = &config.GateCommon
+		}
+		host, _ := splitListenAddr(gc.HTTPAddr)
+		procs = append(procs, &cpuProcess{fmt.Sprintf("gate%d", gateid), true, host, gc.CPUAffinity, gc.CPUAffinityAuto})
+	}
+	return procs
+}
+
+func sameHost(host1, host2 string) bool {
+	return host1 == host2 || isWildcardHost(host1) || isWildcardHost(host2)
+}
+
+// CheckCPUOverlaps returns games and gates on the same host which may run on the same CPUs, if at least one of them is
+// pinned by cpu_affinity, processes with auto affinity do not overlap each other
+func (c *Config) CheckCPUOverlaps() []CPUOverlap {
+	procs := cpuProcesses(c.Get())
+	var overlaps []CPUOverlap
+	for _, game := range procs {
+		if game.isGate {
+			continue
+		}
+		for _, gate := range procs {
+			if !gate.isGate || !sameHost(game.host, gate.host) || !(game.pinned() || gate.pinned()) || (game.auto && gate.auto) {
+				continue
+			}
+			if len(game.affinity) > 0 && len(gate.affinity) > 0 {
+				if common := intersectCPUs(game.affinity, gate.affinity); len(common) > 0 {
+					overlaps = append(overlaps, CPUOverlap{game.name, gate.name, common})
+				}
+			} else if !game.pinned() || !gate.pinned() {
+				overlaps = append(overlaps, CPUOverlap{game.name, gate.name, nil})
+			}
+		}
+	}
+	return overlaps
+}
+
+// AutoCPUAffinity returns the share of available CPUs of the process (e.g. game1) with auto affinity, CPUs are divided
+// evenly among games and gates with auto affinity on the same host, and shared if there are more processes than CPUs
+func (c *Config) AutoCPUAffinity(processName string, available []int) []int {
+	procs := cpuProcesses(c.Get())
+	var self *cpuProcess
+	for _, p := range procs {
+		if p.name == processName {
+			self = p
+		}
+	}
+	if self == nil || !self.auto || len(available) == 0 {
+		return nil
+	}
+
+	index, count := 0, 0
+	for _, p := range procs {
+		if !p.auto || !sameHost(self.host, p.host) {
+			continue
+		}
+		if p == self {
+			index = count
+		}
+		count++
+	}
+	if count > len(available) {
+		return []int{available[index%len(available)]}
+	}
+	begin, end := len(available)*index/count, len(available)*(index+1)/count
+	return available[begin:end]
+}
+
+func intersectCPUs(cpus1, cpus2 []int) []int {
+	set := map[int]bool{}
+	for _, cpu := range cpus1 {
+		set[cpu] = true
+	}
+	var common []int
+	for _, cpu := range cpus2 {
+		if set[cpu] {
+			common = append(common, cpu)
+		}
+	}
+	return common
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+// Labels of processes
+//
+// Games and gates are labeled by labels = region:eu, tier:premium in their sections or common sections, labels of
+// common sections are overridden by labels with the same names in game or gate sections. Labels are exported with
+// metrics, advertised in the server list, and used as placement constraints, e.g. creating spaces only on games labeled
+// tier:highmem.
+
+var (
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`) // valid label names of metrics
+)
+
+// ParseLabels parses labels in the form of name:value, name2:value2
+func ParseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		colon := strings.IndexByte(item, ':')
+		if colon < 0 {
+			return nil, errors.Errorf("invalid label %q, should be name:value", item)
+		}
+		name, value := strings.TrimSpace(item[:colon]), strings.TrimSpace(item[colon+1:])
+		if !labelNameRegexp.MatchString(name) {
+			return nil, errors.Errorf("invalid label name %q", name)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// MatchLabels returns if labels have all labels of the selector
+func MatchLabels(labels map[string]string, selector map[string]string) bool {
+	for name, value := range selector {
+		if v, ok := labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetGamesByLabels returns IDs of games with all labels of the selector in order
+func (c *Config) GetGamesByLabels(selector map[string]string) []uint16 {
+	cfg := c.Get()
+	var gameids []uint16
+	for gameid, gc := range cfg._Games {
+		if MatchLabels(gc.Labels, selector) {
+			gameids = append(gameids, gameid)
+		}
+	}
+	sort.Slice(gameids, func(i, j int) bool {
+		return gameids[i] < gameids[j]
+	})
+	return gameids
+}
+
+// readLabels returns labels of the key merged into a copy of labels, which may be shared with the common section
+func (c *Config) readLabels(sec *ini.Section, key *ini.Key, labels map[string]string) map[string]string {
+	parsed, err := ParseLabels(key.String())
+	if err != nil {
+		c.configFatalf("section %s: %s", sec.Name(), err)
+		return labels
+	}
+
+	merged := make(map[string]string, len(labels)+len(parsed))
+	for name, value := range labels {
+		merged[name] = value
+	}
+	for name, value := range parsed {
+		merged[name] = value
+	}
+	return merged
+}
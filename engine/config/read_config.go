@@ -9,12 +9,14 @@ import (
 
 	"encoding/json"
 
-	"sync"
+	"math"
 
 	"sort"
 
 	"time"
 
+	"net"
+
 	"path"
 
 	"github.com/go-ini/ini"
@@ -24,16 +26,27 @@ import (
 )
 
 const (
-	_DEFAULT_CONFIG_FILE   = "goworld.ini"
-	_DEFAULT_SAVE_ITNERVAL = time.Minute * 5
-	_DEFAULT_LOG_LEVEL     = "debug"
-	_DEFAULT_STORAGE_DB    = "goworld"
-)
-
-var (
-	configFilePath = _DEFAULT_CONFIG_FILE
-	goWorldConfig  *GoWorldConfig
-	configLock     sync.Mutex
+	_DEFAULT_CONFIG_FILE      = "goworld.ini"
+	_DEFAULT_SAVE_ITNERVAL    = time.Minute * 5
+	_DEFAULT_LOG_LEVEL        = "debug"
+	_DEFAULT_STORAGE_DB       = "goworld"
+	_DEFAULT_TRASH_RETENTION  = time.Hour * 24 * 7
+	_DEFAULT_BACKUP_RETENTION = time.Hour * 24 * 7
+	_DEFAULT_FAILOVER_TIMEOUT = time.Second * 3
+
+	_DEFAULT_WRITE_BEHIND_MAX_PENDING = 10000
+	_DEFAULT_ASYNC_WORKER_POOL_SIZE   = 4
+	_DEFAULT_CREATION_QUEUE_MAX_LEN   = 10000
+	_DEFAULT_CREATION_QUEUE_TIMEOUT   = time.Second * 10
+	_DEFAULT_AUTO_MIGRATE_CPU_PERCENT = 70
+	_DEFAULT_AUTO_MIGRATE_CPU_DIFF    = 20
+	_DEFAULT_AUTO_MIGRATE_BACKLOG     = 1000
+	_DEFAULT_AUTO_MIGRATE_BATCH_SIZE  = 100
+	_DEFAULT_AUTO_MIGRATE_INTERVAL_MS = 10000
+	_DEFAULT_DRAIN_TIMEOUT            = 300
+	_DEFAULT_DRAIN_BATCH_SIZE         = 100
+	_DEFAULT_CLOCK_SKEW_WARN_MS       = 500
+	_DEFAULT_TRACING_SAMPLE_RATE      = 0.01
 )
 
 // DeploymentConfig defines fields of deployment config
@@ -41,6 +54,19 @@ type DeploymentConfig struct {
 	DesiredDispatchers int `ini:"desired_dispatchers"`
 	DesiredGames       int `ini:"desired_games"`
 	DesiredGates       int `ini:"desired_gates"`
+	// automatic entity migration from hot games to cold games
+	AutoMigrate           bool    `ini:"auto_migrate"`
+	AutoMigrateCPUPercent float64 `ini:"auto_migrate_cpu_percent"` // game is hot if CPU percent reaches this
+	AutoMigrateCPUDiff    float64 `ini:"auto_migrate_cpu_diff"`    // CPU percent of cold game must be lower than hot game by this
+	AutoMigrateBacklog    int     `ini:"auto_migrate_backlog"`     // game is hot if packet backlog reaches this
+	AutoMigrateBatchSize  int     `ini:"auto_migrate_batch_size"`  // max entities migrated from a hot game at a time
+	AutoMigrateIntervalMS int     `ini:"auto_migrate_interval_ms"`
+	// graceful drain of games and gates
+	DrainTimeout   int `ini:"drain_timeout"`    // seconds a draining game or gate waits before exiting anyway
+	DrainBatchSize int `ini:"drain_batch_size"` // max entities migrated by a draining game per second
+	// clock skew of games and gates from dispatchers
+	ClockSkewWarnMS int `ini:"clock_skew_warn_ms"` // warn if clocks differ by more than this
+	ClockSkewMaxMS  int `ini:"clock_skew_max_ms"`  // games and gates refuse to start if clocks differ by more than this, 0 means never
 }
 
 // GameConfig defines fields of game config
@@ -51,35 +77,125 @@ type GameConfig struct {
 	LogStderr              bool
 	HTTPAddr               string
 	LogLevel               string
+	LogFormat              string
+	LogRotateSize          int // MB
+	LogRotateInterval      time.Duration
+	LogMaxBackups          int
 	GoMaxProcs             int
 	PositionSyncIntervalMS int
 	BanBootEntity          bool
+	SensitiveAttrKey       string
+	AsyncWorkerPoolSize    int
+	AOIImplementation      string  // default AOI implementation of spaces: xzlist or tower
+	AOITowerCellSize       float64 // cell size of tower AOI, 0 means the AOI distance of the space
+	AttrPatchSync          bool    // coalesce attribute changes to clients into one patch per entity per sync tick
+	AttrPatchPrecision     float64 // floats in attribute patches are quantized to multiples of the precision, 0 means no quantization
+	ResumeDiffSync         bool    // sync only changes during disconnection to clients resuming their sessions
+	DesyncDetectThreshold  float64 // debug: replay client inputs and report divergences larger than this, 0 means disabled
+	CronTimezone           string  // time zone of cron job schedules, e.g. Asia/Shanghai, empty for the local time zone
+
+	Labels          map[string]string // labels of the game, e.g. region:eu, used as placement constraints
+	CPUAffinity     []int             // CPUs the game is pinned to, empty if not pinned
+	CPUAffinityAuto bool              // pin the game to its share of CPUs of the host
 }
 
 // GateConfig defines fields of gate config
 type GateConfig struct {
 	ListenAddr             string
+	ListenAcceptors        int // accept loops of listen_addr bound with SO_REUSEPORT if > 1, 0 for the number of CPUs
 	LogFile                string
 	LogStderr              bool
 	HTTPAddr               string
 	LogLevel               string
+	LogFormat              string
+	LogRotateSize          int // MB
+	LogRotateInterval      time.Duration
+	LogMaxBackups          int
 	GoMaxProcs             int
 	CompressConnection     bool
+	CompressMinBytes       int      // data shorter than this is not compressed
+	CompressMaxEntropy     float64  // data with higher entropy (bits per byte) is not compressed, 0 for no limit
+	CompressSkipMsgTypes   []string // messages of these types are not compressed
 	EncryptConnection      bool
 	RSAKey                 string
 	RSACertificate         string
+	TLSClientCA            string
+	TLSMinVersion          string
+	TLSCipherSuites        []string
+	TLSCertReloadInterval  int
 	HeartbeatCheckInterval int
 	PositionSyncIntervalMS int
+	SyncPositionPrecision  float64 // precision of quantized positions synced to clients, 0 for not quantized
+	AcceptRate             int
+	ReconnectBackoffMS     int
+	SessionTokenTTL        int
+	SessionSecret          string
+	SessionResumeTimeout   int
+	ListenWSPort           int
+	ListenWSPath           string
+	PublicHost             string // host dialed by clients, advertised in the server list
+	FallbackListenAddr     string // HTTPS listener of WebSocket over TLS for clients behind restrictive firewalls, e.g. 0.0.0.0:443
+	FallbackURL            string // URL of the fallback advertised in the server list
+	ListenKCPPort          int
+	KCPNoDelay             bool
+	KCPInterval            int
+	KCPResend              int
+	KCPNoCongestion        bool
+	KCPSendWindow          int
+	KCPRecvWindow          int
+	MaxPacketsPerSecond    int
+	MaxBytesPerSecond      int
+	MaxPendingPackets      int
+	FloodPolicy            string
+	WriteTimeout           int    // seconds
+	StuckConnectionTimeout int    // seconds
+	SendWorkers            int    // workers flushing packets sent to clients, 0 for one flush routine per client
+	SendOffloadWorkers     int    // workers flushing large writes for send workers, 0 for flushing inline
+	SendOffloadMinBytes    int    // writes smaller than this are flushed inline by send workers
+	ClientCodec            string // codec selected for clients supporting it: msgpack or protobuf
+	TCPNoDelay             bool
+	TCPSendBuffer          int // SO_SNDBUF of client connections, 0 for the OS default
+	TCPRecvBuffer          int // SO_RCVBUF of client connections, 0 for the OS default
+	TCPKeepAlive           int // seconds, 0 for the default, negative for disabled
+	// clients of protocol versions lower than MinClientProtocolVersion are rejected, and clients of versions higher than
+	// MaxClientProtocolVersion use MaxClientProtocolVersion (0 for the latest version supported by the gate)
+	MinClientProtocolVersion int
+	MaxClientProtocolVersion int
+	ClientUpdateURL          string            // sent to rejected clients
+	Transports               map[string]string // custom client transports registered by netutil.RegisterTransport: name -> listen address
+	Labels                   map[string]string // labels of the gate, e.g. region:eu, advertised in the server list
+	CPUAffinity              []int             // CPUs the gate is pinned to, empty if not pinned
+	CPUAffinityAuto          bool              // pin the gate to its share of CPUs of the host
+	ConnectionScorer         string            // connection scorer registered by netutil.RegisterConnectionScorer, empty for no scoring
+	ConnectionScoreInterval  int               // interval of scoring each connection in seconds
 }
 
 // DispatcherConfig defines fields of dispatcher config
 type DispatcherConfig struct {
-	ListenAddr    string
-	AdvertiseAddr string
-	HTTPAddr      string
-	LogFile       string
-	LogStderr     bool
-	LogLevel      string
+	ListenAddr        string
+	AdvertiseAddr     string
+	AdvertiseIP       string // host dialed by games and gates with the port of listen_addr, if advertise_addr is not set for the dispatcher
+	HTTPAddr          string
+	LogFile           string
+	LogStderr         bool
+	LogLevel          string
+	LogFormat         string
+	LogRotateSize     int // MB
+	LogRotateInterval time.Duration
+	LogMaxBackups     int
+	StandbyAddr       string        // advertise address of the hot-standby dispatcher, empty if no standby
+	StandbyListenAddr string        // listen address of the hot-standby dispatcher
+	FailoverTimeout   time.Duration // standby takes over if the active dispatcher is unreachable for this long
+	// queued creations are delayed while CPU percent of all games >= OverloadCPUPercent (0 for never)
+	OverloadCPUPercent   float64
+	CreationQueueMaxLen  int
+	CreationQueueTimeout time.Duration // default timeout of queued creations
+	// TCP options of connections between the dispatcher and games/gates, applied on both sides
+	TCPNoDelay    bool
+	TCPSendBuffer int // SO_SNDBUF, 0 for the OS default
+	TCPRecvBuffer int // SO_RCVBUF, 0 for the OS default
+	TCPKeepAlive  int // seconds, 0 for the default, negative for disabled
+	Workers       int // workers routing messages by entity IDs, 0 for routing all messages in the main routine
 }
 
 // GoWorldConfig defines the total GoWorld config file structure
@@ -92,109 +208,182 @@ type GoWorldConfig struct {
 	_Games           map[uint16]*GameConfig
 	_Gates           map[uint16]*GateConfig
 	Storage          StorageConfig
+	_Storages        map[string]*StorageConfig // named storages in [storage_<name>] sections, e.g. targets of migration
 	KVDB             KVDBConfig
 	Debug            DebugConfig
+	Tracing          TracingConfig
+	Telemetry        TelemetryConfig
 }
 
 // StorageConfig defines fields of storage config
 type StorageConfig struct {
-	Type       string // Type of storage (filesystem, mongodb, redis, mysql)
-	Directory  string // Directory of filesystem storage (filesystem)
-	Url        string // Connection URL (mongodb, redis, mysql)
-	DB         string // Database name (mongodb, redis)
-	Driver     string // SQL Driver name (mysql)
-	StartNodes common.StringSet
+	Type            string // Type of storage (filesystem, mongodb, redis, sql, postgres)
+	Directory       string // Directory of filesystem storage (filesystem)
+	Url             string // Connection URL (mongodb, redis, mysql, postgres)
+	DB              string // Database name (mongodb, redis)
+	Driver          string // SQL Driver name (mysql, postgres)
+	StartNodes      common.StringSet
+	MaxOpenConns    int           // Max open connections of the pool (postgres)
+	MaxIdleConns    int           // Max idle connections of the pool (postgres)
+	ConnMaxLifetime time.Duration // Max lifetime of pooled connections (postgres)
+	TrashRetention  time.Duration // How long deleted entities are kept in trash before purged
+	BackupSchedule  string        // Schedule of periodic backups in crontab format, backup is disabled if empty
+	BackupDir       string        // Directory of backup files
+	BackupRetention time.Duration // How long backup files are kept before pruned
+
+	WriteBehindInterval   time.Duration // Interval of flushing buffered entity saves, write-behind is disabled if 0
+	WriteBehindMaxPending int           // Warns and flushes without waiting if there are more buffered saves, 0 means no limit
+
+	Namespace            string // Data namespace (e.g. season ID) of entities, entities are not partitioned if empty
+	PreviousNamespace    string // Namespace read by storage.LoadPrevious during the transition to a new namespace
+	UsePreviousNamespace bool   // previous_namespace is set, the previous namespace may be empty (not partitioned)
+
+	Options map[string]string // Options of storage backends registered by storage.Register, set by option_<name> keys
 }
 
 // KVDBConfig defines fields of KVDB config
 type KVDBConfig struct {
-	Type       string
-	Url        string // MongoDB
-	DB         string // MongoDB
-	Collection string // MongoDB
-	Driver     string // SQL Driver: e.x. mysql
-	StartNodes common.StringSet
+	Type            string
+	Url             string // MongoDB
+	DB              string // MongoDB
+	Collection      string // MongoDB
+	Driver          string // SQL Driver: e.x. mysql
+	StartNodes      common.StringSet
+	MaxOpenConns    int               // postgres
+	MaxIdleConns    int               // postgres
+	ConnMaxLifetime time.Duration     // postgres
+	Options         map[string]string // backends registered by kvdb.Register, set by option_<name> keys
+
+	Namespace            string // Data namespace (e.g. season ID) of keys, keys are not partitioned if empty
+	PreviousNamespace    string // Namespace read by kvdb.GetPrevious during the transition to a new namespace
+	UsePreviousNamespace bool   // previous_namespace is set, the previous namespace may be empty (not partitioned)
 }
 
 type DebugConfig struct {
-	Debug bool
+	Debug         bool
+	AdminToken    string               // token of the gm role for the admin HTTP API
+	AdminUsers    map[string]AdminUser // users of the admin HTTP API by name, the admin API is disabled if there is no user or token
+	AdminAuditLog string               // file of the append-only audit log of admin requests, empty for logging to the process log
+}
+
+// Roles of admin users, each role has all permissions of the roles before it
+const (
+	AdminRoleReadOnly = "readonly" // reads state of the cluster, e.g. entities and clients
+	AdminRoleOperator = "operator" // operates the cluster, e.g. changing log levels, draining and snapshots
+	AdminRoleGM       = "gm"       // changes game state of players, e.g. granting items
+)
+
+// AdminUser is a user of the admin HTTP API, configured by admin_user_<name> = <role>[:<token>]
+//
+// Users without tokens are authenticated by client certificates with the common name of the user, if the HTTP server
+// verifies client certificates (mTLS).
+type AdminUser struct {
+	Role  string
+	Token string
+}
+
+// TelemetryConfig defines fields of metrics exporters config
+type TelemetryConfig struct {
+	Exporters    []string // prometheus, expvar, statsd, otlp or custom exporters registered by metrics.RegisterExporter
+	PushInterval int      // seconds between pushes of push exporters
+	StatsDAddr   string
+	StatsDPrefix string
+	OTLPEndpoint string // OTLP/HTTP endpoint of metrics, e.g. http://127.0.0.1:4318/v1/metrics
+}
+
+// TracingConfig defines fields of tracing config
+type TracingConfig struct {
+	Endpoint   string  // OTLP/HTTP endpoint of traces, e.g. http://127.0.0.1:4318/v1/traces, tracing is disabled if empty
+	SampleRate float64 // Ratio of RPCs from clients to be traced
 }
 
 // SetConfigFile sets the config file path (goworld.ini by default)
-func SetConfigFile(f string) {
-	configLock.Lock()
-	if configFilePath == f {
-		configLock.Unlock()
+//
+// Config file format is detected by extension: .yaml/.yml and .json files are supported besides ini files.
+// Config can also be loaded from sources by URL, e.g. consul://127.0.0.1:8500/goworld.
+// Config keys can be overridden by environment variables (e.g. GOWORLD_STORAGE_URL) and SetOverride.
+func (c *Config) SetConfigFile(f string) {
+	c.lock.Lock()
+	if c.filePath == f {
+		c.lock.Unlock()
 		return
 	}
 
-	configFilePath = f
-	configLock.Unlock()
+	c.filePath = f
+	c.lock.Unlock()
 
-	Reload()
+	c.Reload()
 }
 
-// GetConfigDir returns the directory of goworld.ini
-func GetConfigDir() string {
-	dir, _ := path.Split(configFilePath)
+// GetConfigDir returns the directory of goworld.ini, or the working directory if config is loaded from a source
+func (c *Config) GetConfigDir() string {
+	configFile := c.GetConfigFilePath()
+	if c.getSource(configFile) != nil {
+		return ""
+	}
+
+	dir, _ := path.Split(configFile)
 	return dir
 }
 
 // GetConfigFilePath returns the config file path
-func GetConfigFilePath() string {
-	return configFilePath
+func (c *Config) GetConfigFilePath() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.filePath
 }
 
 // Get returns the total GoWorld config
-func Get() *GoWorldConfig {
-	configLock.Lock()
-	defer configLock.Unlock() // protect concurrent access from Games & Gate
-	if goWorldConfig == nil {
-		goWorldConfig = readGoWorldConfig()
-		gwlog.Infof(">>> config <<< debug = %v", goWorldConfig.Debug.Debug)
-		gwlog.Infof(">>> config <<< desired dispatcher count = %d", goWorldConfig.Deployment.DesiredDispatchers)
-		gwlog.Infof(">>> config <<< desired game count = %d", goWorldConfig.Deployment.DesiredGames)
-		gwlog.Infof(">>> config <<< desired gate count = %d", goWorldConfig.Deployment.DesiredGates)
-		gwlog.Infof(">>> config <<< storage type = %s", goWorldConfig.Storage.Type)
-		gwlog.Infof(">>> config <<< KVDB type = %s", goWorldConfig.KVDB.Type)
+func (c *Config) Get() *GoWorldConfig {
+	c.lock.Lock()
+	defer c.lock.Unlock() // protect concurrent access from Games & Gate
+	if c.config == nil {
+		c.config = c.readGoWorldConfig()
+		gwlog.Infof(">>> config <<< debug = %v", c.config.Debug.Debug)
+		gwlog.Infof(">>> config <<< desired dispatcher count = %d", c.config.Deployment.DesiredDispatchers)
+		gwlog.Infof(">>> config <<< desired game count = %d", c.config.Deployment.DesiredGames)
+		gwlog.Infof(">>> config <<< desired gate count = %d", c.config.Deployment.DesiredGates)
+		gwlog.Infof(">>> config <<< storage type = %s", c.config.Storage.Type)
+		gwlog.Infof(">>> config <<< KVDB type = %s", c.config.KVDB.Type)
 	}
-	return goWorldConfig
+	return c.config
 }
 
-// Reload forces goworld server to reload the whole config
-func Reload() *GoWorldConfig {
-	configLock.Lock()
-	goWorldConfig = nil
-	configLock.Unlock()
+// Reload forces the config to be read again
+func (c *Config) Reload() *GoWorldConfig {
+	c.lock.Lock()
+	c.config = nil
+	c.lock.Unlock()
 
-	return Get()
+	return c.Get()
 }
 
-func GetDeployment() *DeploymentConfig {
-	return &Get().Deployment
+// GetDeployment returns the deployment config
+func (c *Config) GetDeployment() *DeploymentConfig {
+	return &c.Get().Deployment
 }
 
 // GetGame gets the game config of specified game ID
-func GetGame(gameid uint16) *GameConfig {
-	cfg := Get()._Games[gameid]
+func (c *Config) GetGame(gameid uint16) *GameConfig {
+	cfg := c.Get()._Games[gameid]
 	if cfg == nil {
-		cfg = &Get().GameCommon
+		cfg = &c.Get().GameCommon
 	}
 	return cfg
 }
 
 // GetGate gets the gate config of specified gate ID
-func GetGate(gateid uint16) *GateConfig {
-	cfg := Get()._Gates[gateid]
+func (c *Config) GetGate(gateid uint16) *GateConfig {
+	cfg := c.Get()._Gates[gateid]
 	if cfg == nil {
-		cfg = &Get().GateCommon
+		cfg = &c.Get().GateCommon
 	}
 	return cfg
 }
 
 // GetDispatcherIDs returns all dispatcher IDs
-func GetDispatcherIDs() []uint16 {
-	cfg := Get()
+func (c *Config) GetDispatcherIDs() []uint16 {
+	cfg := c.Get()
 	dispIDs := make([]int, 0, len(cfg._Dispatchers))
 	for id := range cfg._Dispatchers {
 		dispIDs = append(dispIDs, int(id))
@@ -209,18 +398,48 @@ func GetDispatcherIDs() []uint16 {
 }
 
 // GetDispatcher returns the dispatcher config
-func GetDispatcher(dispid uint16) *DispatcherConfig {
-	return Get()._Dispatchers[dispid]
+func (c *Config) GetDispatcher(dispid uint16) *DispatcherConfig {
+	return c.Get()._Dispatchers[dispid]
 }
 
 // GetStorage returns the storage config
-func GetStorage() *StorageConfig {
-	return &Get().Storage
+func (c *Config) GetStorage() *StorageConfig {
+	return &c.Get().Storage
+}
+
+// GetStorageByName returns the storage config in [storage_<name>] section, or nil if not found
+func (c *Config) GetStorageByName(name string) *StorageConfig {
+	return c.Get()._Storages[strings.ToLower(name)]
 }
 
 // GetKVDB returns the KVDB config
-func GetKVDB() *KVDBConfig {
-	return &Get().KVDB
+func (c *Config) GetKVDB() *KVDBConfig {
+	return &c.Get().KVDB
+}
+
+// Debug returns if debug is enabled
+func (c *Config) Debug() bool {
+	return c.Get().Debug.Debug
+}
+
+// GetAdminToken returns the token of the admin HTTP API
+func (c *Config) GetAdminToken() string {
+	return c.Get().Debug.AdminToken
+}
+
+// GetDebug returns the debug config, including users of the admin HTTP API
+func (c *Config) GetDebug() *DebugConfig {
+	return &c.Get().Debug
+}
+
+// GetTracing returns the tracing config
+func (c *Config) GetTracing() *TracingConfig {
+	return &c.Get().Tracing
+}
+
+// GetTelemetry returns the config of metrics exporters
+func (c *Config) GetTelemetry() *TelemetryConfig {
+	return &c.Get().Telemetry
 }
 
 // DumpPretty format config to string in pretty format
@@ -232,28 +451,44 @@ func DumpPretty(cfg interface{}) string {
 	return string(s)
 }
 
-func Debug() bool {
-	return Get().Debug.Debug
-}
-
-func readGoWorldConfig() *GoWorldConfig {
+func (c *Config) readGoWorldConfig() *GoWorldConfig {
 	config := GoWorldConfig{
 		_Dispatchers: map[uint16]*DispatcherConfig{},
 		_Games:       map[uint16]*GameConfig{},
 		_Gates:       map[uint16]*GateConfig{},
+		_Storages:    map[string]*StorageConfig{},
+		Telemetry: TelemetryConfig{ // metrics are exported at /metrics if [telemetry] is not configured
+			Exporters:    []string{"prometheus"},
+			PushInterval: 10,
+			StatsDAddr:   "127.0.0.1:8125",
+			StatsDPrefix: "goworld.",
+		},
+	}
+	gwlog.Infof("Using config file: %s", c.filePath)
+	var iniFile *ini.File
+	var err error
+	if src := c.getSource(c.filePath); src != nil {
+		iniFile, err = src.Load()
+		if registry, ok := src.(Registry); ok && err == nil {
+			c.applyDiscovery(iniFile, registry)
+		}
+	} else {
+		iniFile, err = loadConfigFile(c.filePath)
+	}
+	if err != nil {
+		c.checkConfigError(err, "")
+		return nil // only reached when errors are collected by Validate
 	}
-	gwlog.Infof("Using config file: %s", configFilePath)
-	iniFile, err := ini.Load(configFilePath)
-	checkConfigError(err, "")
+	c.applyOverrides(iniFile)
 	gameCommonSec := iniFile.Section("game_common")
-	readGameCommonConfig(gameCommonSec, &config.GameCommon)
+	c.readGameCommonConfig(gameCommonSec, &config.GameCommon)
 	gateCommonSec := iniFile.Section("gate_common")
-	readGateCommonConfig(gateCommonSec, &config.GateCommon)
+	c.readGateCommonConfig(gateCommonSec, &config.GateCommon)
 	dispatcherCommonSec := iniFile.Section("dispatcher_common")
-	readDispatcherCommonConfig(dispatcherCommonSec, &config.DispatcherCommon)
+	c.readDispatcherCommonConfig(dispatcherCommonSec, &config.DispatcherCommon)
 	deploymentSec := iniFile.Section("deployment")
 	if deploymentSec == nil {
-		gwlog.Fatalf("[deployment] section not found in config file")
+		c.configFatalf("[deployment] section not found in config file")
 	}
 	readDeploymentConfig(deploymentSec, &config.Deployment)
 	for _, sec := range iniFile.Sections() {
@@ -271,33 +506,44 @@ func readGoWorldConfig() *GoWorldConfig {
 		} else if len(secName) > 10 && secName[:10] == "dispatcher" {
 			// dispatcher config
 			id, err := strconv.Atoi(secName[10:])
-			checkConfigError(err, fmt.Sprintf("invalid dispatcher name: %s", secName))
+			c.checkConfigError(err, fmt.Sprintf("invalid dispatcher name: %s", secName))
 			if id > config.Deployment.DesiredDispatchers {
 				gwlog.Warnf("Section [%s] is ignored because [deployment].desired_dispatchers = %d", secName, config.Deployment.DesiredDispatchers)
 				continue
 			}
 
-			config._Dispatchers[uint16(id)] = readDispatcherConfig(sec, &config.DispatcherCommon)
+			config._Dispatchers[uint16(id)] = c.readDispatcherConfig(sec, &config.DispatcherCommon)
 		} else if len(secName) > 4 && secName[:4] == "game" {
 			// game config
 			id, err := strconv.Atoi(secName[4:])
-			checkConfigError(err, fmt.Sprintf("invalid game name: %s", secName))
-			config._Games[uint16(id)] = readGameConfig(sec, &config.GameCommon)
+			c.checkConfigError(err, fmt.Sprintf("invalid game name: %s", secName))
+			config._Games[uint16(id)] = c.readGameConfig(sec, &config.GameCommon)
 		} else if len(secName) > 4 && secName[:4] == "gate" {
 			id, err := strconv.Atoi(secName[4:])
-			checkConfigError(err, fmt.Sprintf("invalid gate name: %s", secName))
-			config._Gates[uint16(id)] = readGateConfig(sec, &config.GateCommon)
+			c.checkConfigError(err, fmt.Sprintf("invalid gate name: %s", secName))
+			config._Gates[uint16(id)] = c.readGateConfig(sec, &config.GateCommon)
 		} else if secName == "storage" {
 			// storage config
-			readStorageConfig(sec, &config.Storage)
+			c.readStorageConfig(sec, &config.Storage, "filesystem")
+		} else if len(secName) > 8 && secName[:8] == "storage_" {
+			// named storage config, the storage type is the name by default
+			name := secName[8:]
+			config._Storages[name] = &StorageConfig{}
+			c.readStorageConfig(sec, config._Storages[name], name)
 		} else if secName == "kvdb" {
 			// kvdb config
-			readKVDBConfig(sec, &config.KVDB)
+			c.readKVDBConfig(sec, &config.KVDB)
 		} else if secName == "debug" {
 			// debug config
-			readDebugConfig(sec, &config.Debug)
+			c.readDebugConfig(sec, &config.Debug)
+		} else if secName == "tracing" {
+			// tracing config
+			c.readTracingConfig(sec, &config.Tracing)
+		} else if secName == "telemetry" {
+			// metrics exporters config
+			c.readTelemetryConfig(sec, &config.Telemetry)
 		} else {
-			gwlog.Fatalf("unknown section: %s", secName)
+			c.configFatalf("unknown section: %s", secName)
 		}
 
 	}
@@ -309,38 +555,61 @@ func readGoWorldConfig() *GoWorldConfig {
 		}
 	}
 
-	validateConfig(&config)
+	c.validateConfig(&config)
 	return &config
 }
 
 func readDeploymentConfig(sec *ini.Section, config *DeploymentConfig) {
+	config.AutoMigrateCPUPercent = _DEFAULT_AUTO_MIGRATE_CPU_PERCENT
+	config.AutoMigrateCPUDiff = _DEFAULT_AUTO_MIGRATE_CPU_DIFF
+	config.AutoMigrateBacklog = _DEFAULT_AUTO_MIGRATE_BACKLOG
+	config.AutoMigrateBatchSize = _DEFAULT_AUTO_MIGRATE_BATCH_SIZE
+	config.AutoMigrateIntervalMS = _DEFAULT_AUTO_MIGRATE_INTERVAL_MS
+	config.DrainTimeout = _DEFAULT_DRAIN_TIMEOUT
+	config.DrainBatchSize = _DEFAULT_DRAIN_BATCH_SIZE
+	config.ClockSkewWarnMS = _DEFAULT_CLOCK_SKEW_WARN_MS
 	sec.MapTo(config)
 }
 
-func readGameCommonConfig(section *ini.Section, scc *GameConfig) {
+func (c *Config) readGameCommonConfig(section *ini.Section, scc *GameConfig) {
 	scc.BootEntity = "Boot"
 	scc.LogFile = "game.log"
 	scc.LogStderr = true
+	scc.LogFormat = "console"
 	scc.LogLevel = _DEFAULT_LOG_LEVEL
 	scc.SaveInterval = _DEFAULT_SAVE_ITNERVAL
 	scc.HTTPAddr = "127.0.0.1:25000"
 	scc.GoMaxProcs = 0
 	scc.PositionSyncIntervalMS = 100 // sync positions per 100ms by default
+	scc.AsyncWorkerPoolSize = _DEFAULT_ASYNC_WORKER_POOL_SIZE
+	scc.AOIImplementation = "xzlist"
 
-	_readGameConfig(section, scc)
+	c._readGameConfig(section, scc)
 }
 
-func readGameConfig(sec *ini.Section, gameCommonConfig *GameConfig) *GameConfig {
+func (c *Config) readGameConfig(sec *ini.Section, gameCommonConfig *GameConfig) *GameConfig {
 	var sc GameConfig = *gameCommonConfig // copy from game_common
-	_readGameConfig(sec, &sc)
+	c._readGameConfig(sec, &sc)
 	// validate game config
 	if sc.BootEntity == "" {
-		panic("boot_entity is not set in game config")
+		c.configFatalf("boot_entity is not set in game config %s", sec.Name())
+	}
+	if sc.AsyncWorkerPoolSize <= 0 {
+		c.configFatalf("async_worker_pool_size should be positive in game config %s", sec.Name())
+	}
+	if sc.AOITowerCellSize < 0 {
+		c.configFatalf("aoi_tower_cell_size should not be negative in game config %s", sec.Name())
+	}
+	if sc.AttrPatchPrecision < 0 {
+		c.configFatalf("attr_patch_float_precision should not be negative in game config %s", sec.Name())
+	}
+	if _, err := time.LoadLocation(sc.CronTimezone); err != nil {
+		c.configFatalf("invalid cron_timezone in game config %s: %s", sec.Name(), err)
 	}
 	return &sc
 }
 
-func _readGameConfig(sec *ini.Section, sc *GameConfig) {
+func (c *Config) _readGameConfig(sec *ini.Section, sc *GameConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "boot_entity" {
@@ -351,8 +620,16 @@ func _readGameConfig(sec *ini.Section, sc *GameConfig) {
 			sc.LogFile = key.MustString(sc.LogFile)
 		} else if name == "log_stderr" {
 			sc.LogStderr = key.MustBool(sc.LogStderr)
+		} else if name == "log_format" {
+			sc.LogFormat = c.readLogFormat(sec, key.MustString(sc.LogFormat))
+		} else if name == "log_rotate_size_mb" {
+			sc.LogRotateSize = key.MustInt(sc.LogRotateSize)
+		} else if name == "log_rotate_interval_hours" {
+			sc.LogRotateInterval = time.Hour * time.Duration(key.MustInt(int(sc.LogRotateInterval/time.Hour)))
+		} else if name == "log_max_backups" {
+			sc.LogMaxBackups = key.MustInt(sc.LogMaxBackups)
 		} else if name == "http_addr" {
-			sc.HTTPAddr = key.MustString(sc.HTTPAddr)
+			sc.HTTPAddr = c.readAddr(sec, key, sc.HTTPAddr)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
 		} else if name == "gomaxprocs" {
@@ -361,126 +638,475 @@ func _readGameConfig(sec *ini.Section, sc *GameConfig) {
 			sc.PositionSyncIntervalMS = key.MustInt(sc.PositionSyncIntervalMS)
 		} else if name == "ban_boot_entity" {
 			sc.BanBootEntity = key.MustBool(sc.BanBootEntity)
+		} else if name == "sensitive_attr_key" {
+			sc.SensitiveAttrKey = key.MustString(sc.SensitiveAttrKey)
+		} else if name == "async_worker_pool_size" {
+			sc.AsyncWorkerPoolSize = key.MustInt(sc.AsyncWorkerPoolSize)
+		} else if name == "aoi_implementation" {
+			sc.AOIImplementation = c.readAOIImplementation(sec, strings.ToLower(key.MustString(sc.AOIImplementation)))
+		} else if name == "aoi_tower_cell_size" {
+			sc.AOITowerCellSize = key.MustFloat64(sc.AOITowerCellSize)
+		} else if name == "attr_patch_sync" {
+			sc.AttrPatchSync = key.MustBool(sc.AttrPatchSync)
+		} else if name == "attr_patch_float_precision" {
+			sc.AttrPatchPrecision = key.MustFloat64(sc.AttrPatchPrecision)
+		} else if name == "resume_diff_sync" {
+			sc.ResumeDiffSync = key.MustBool(sc.ResumeDiffSync)
+		} else if name == "desync_detect_threshold" {
+			sc.DesyncDetectThreshold = key.MustFloat64(sc.DesyncDetectThreshold)
+		} else if name == "cron_timezone" {
+			sc.CronTimezone = key.MustString(sc.CronTimezone)
+		} else if name == "labels" {
+			sc.Labels = c.readLabels(sec, key, sc.Labels)
+		} else if name == "cpu_affinity" {
+			sc.CPUAffinity, sc.CPUAffinityAuto = c.readCPUAffinity(sec, key)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
 
-func readGateCommonConfig(section *ini.Section, gcc *GateConfig) {
+func (c *Config) readAOIImplementation(sec *ini.Section, impl string) string {
+	if impl != "xzlist" && impl != "tower" {
+		c.configFatalf("section %s: aoi_implementation should be xzlist or tower, but is %s", sec.Name(), impl)
+	}
+	return impl
+}
+
+func (c *Config) readLogFormat(sec *ini.Section, format string) string {
+	if format != "console" && format != "json" {
+		c.configFatalf("section %s: log_format should be console or json, but is %s", sec.Name(), format)
+	}
+	return format
+}
+
+func (c *Config) readGateCommonConfig(section *ini.Section, gcc *GateConfig) {
 	gcc.LogFile = "gate.log"
 	gcc.LogStderr = true
+	gcc.LogFormat = "console"
 	gcc.LogLevel = _DEFAULT_LOG_LEVEL
 	gcc.ListenAddr = "0.0.0.0:14000"
+	gcc.ListenAcceptors = 1
 	gcc.HTTPAddr = "127.0.0.1:24000"
 	gcc.GoMaxProcs = 0
 	gcc.RSAKey = "rsa.key"
 	gcc.RSACertificate = "rsa.crt"
+	gcc.TLSClientCA = ""
+	gcc.TLSMinVersion = "1.2"
+	gcc.CompressMinBytes = 512
+	gcc.CompressMaxEntropy = 7.5
+	gcc.TLSCipherSuites = nil
+	gcc.Transports = map[string]string{}
+	gcc.TLSCertReloadInterval = 10
 	gcc.HeartbeatCheckInterval = 0
 	gcc.PositionSyncIntervalMS = 100
-
-	_readGateConfig(section, gcc)
+	gcc.SyncPositionPrecision = 0
+	gcc.AcceptRate = 0
+	gcc.ReconnectBackoffMS = 10000
+	gcc.SessionTokenTTL = 0
+	gcc.SessionSecret = ""
+	gcc.SessionResumeTimeout = 0
+	gcc.ListenWSPort = 0
+	gcc.ListenWSPath = "/ws"
+	gcc.PublicHost = "" // defaults to the host of listen_addr
+	gcc.FallbackListenAddr = ""
+	gcc.FallbackURL = "" // defaults to wss://public_host:port/listen_ws_path of fallback_listen_addr
+	gcc.ListenKCPPort = 0
+	gcc.KCPNoDelay = true
+	gcc.KCPInterval = 10
+	gcc.KCPResend = 2
+	gcc.KCPNoCongestion = true
+	gcc.KCPSendWindow = 0
+	gcc.KCPRecvWindow = 0
+	gcc.MaxPacketsPerSecond = 0
+	gcc.MaxBytesPerSecond = 0
+	gcc.MaxPendingPackets = 0
+	gcc.FloodPolicy = "drop"
+	gcc.ConnectionScoreInterval = 10
+	gcc.WriteTimeout = 0
+	gcc.ClientCodec = "msgpack"
+	gcc.StuckConnectionTimeout = 0
+	gcc.SendWorkers = 0
+	gcc.SendOffloadWorkers = 0
+	gcc.SendOffloadMinBytes = 4096
+	gcc.TCPNoDelay = true
+	gcc.TCPSendBuffer = 1024 * 1024
+	gcc.TCPRecvBuffer = 1024 * 1024
+	gcc.TCPKeepAlive = 0
+	gcc.MinClientProtocolVersion = 0
+	gcc.MaxClientProtocolVersion = 0
+	gcc.ClientUpdateURL = ""
+
+	c._readGateConfig(section, gcc)
 }
 
-func readGateConfig(sec *ini.Section, gateCommonConfig *GateConfig) *GateConfig {
+func (c *Config) readGateConfig(sec *ini.Section, gateCommonConfig *GateConfig) *GateConfig {
 	var sc GateConfig = *gateCommonConfig // copy from game_common
-	_readGateConfig(sec, &sc)
+	sc.Transports = map[string]string{}
+	for name, addr := range gateCommonConfig.Transports {
+		sc.Transports[name] = addr
+	}
+	c._readGateConfig(sec, &sc)
 	// validate game config here
 	if sc.EncryptConnection && sc.RSAKey == "" {
-		gwlog.Fatalf("Gate %s: encrypt_connection is enabled, but rsa_key is not set", sec.Name())
+		c.configFatalf("Gate %s: encrypt_connection is enabled, but rsa_key is not set", sec.Name())
 	}
 	if sc.EncryptConnection && sc.RSACertificate == "" {
-		gwlog.Fatalf("Gate %s: encrypt_connection is enabled, but rsa_certificate is not set", sec.Name())
+		c.configFatalf("Gate %s: encrypt_connection is enabled, but rsa_certificate is not set", sec.Name())
+	}
+	if sc.CompressMinBytes < 0 || sc.CompressMaxEntropy < 0 || sc.CompressMaxEntropy > 8 {
+		c.configFatalf("Gate %s: compress_min_bytes should not be negative and compress_max_entropy should be in range [0, 8], but are %d and %v", sec.Name(), sc.CompressMinBytes, sc.CompressMaxEntropy)
+	}
+	if _, err := ParseTLSVersion(sc.TLSMinVersion); err != nil {
+		c.configFatalf("Gate %s: invalid tls_min_version: %s", sec.Name(), err)
+	}
+	if _, err := ParseTLSCipherSuites(sc.TLSCipherSuites); err != nil {
+		c.configFatalf("Gate %s: invalid tls_cipher_suites: %s", sec.Name(), err)
+	}
+	if sc.FallbackListenAddr != "" && (sc.RSAKey == "" || sc.RSACertificate == "") {
+		c.configFatalf("Gate %s: fallback_listen_addr is set, but rsa_key or rsa_certificate is not set", sec.Name())
+	}
+	if sc.FallbackURL != "" && !strings.HasPrefix(sc.FallbackURL, "wss://") {
+		c.configFatalf("Gate %s: fallback_url must start with wss://, but is %s", sec.Name(), sc.FallbackURL)
+	}
+	if (sc.ListenWSPort > 0 || sc.FallbackListenAddr != "") && !strings.HasPrefix(sc.ListenWSPath, "/") {
+		c.configFatalf("Gate %s: listen_ws_path must start with /, but is %s", sec.Name(), sc.ListenWSPath)
+	}
+	if sc.SessionResumeTimeout > 0 && sc.SessionTokenTTL <= 0 {
+		c.configFatalf("Gate %s: session_resume_timeout is set, but session_token_ttl is not set", sec.Name())
+	}
+	if sc.SessionTokenTTL > 0 && sc.SessionSecret == "" {
+		c.configFatalf("Gate %s: session_token_ttl is set, but session_secret is not set", sec.Name())
+	}
+	if sc.FloodPolicy != "drop" && sc.FloodPolicy != "throttle" && sc.FloodPolicy != "kick" {
+		c.configFatalf("Gate %s: flood_policy must be drop, throttle or kick, but is %s", sec.Name(), sc.FloodPolicy)
+	}
+	if sc.ConnectionScorer != "" && sc.ConnectionScoreInterval <= 0 {
+		c.configFatalf("Gate %s: connection_score_interval must be positive, but is %d", sec.Name(), sc.ConnectionScoreInterval)
+	}
+	if sc.KCPInterval <= 0 {
+		c.configFatalf("Gate %s: kcp_interval must be positive, but is %d", sec.Name(), sc.KCPInterval)
+	}
+	if sc.WriteTimeout < 0 || sc.StuckConnectionTimeout < 0 {
+		c.configFatalf("Gate %s: write_timeout and stuck_connection_timeout must not be negative", sec.Name())
+	}
+	if sc.ClientCodec != "msgpack" && sc.ClientCodec != "protobuf" {
+		c.configFatalf("Gate %s: client_codec must be msgpack or protobuf, but is %s", sec.Name(), sc.ClientCodec)
+	}
+	if sc.SyncPositionPrecision < 0 {
+		c.configFatalf("Gate %s: sync_position_precision must not be negative, but is %v", sec.Name(), sc.SyncPositionPrecision)
+	}
+	if sc.SendWorkers < 0 {
+		c.configFatalf("Gate %s: send_workers must not be negative, but is %d", sec.Name(), sc.SendWorkers)
+	}
+	if sc.SendOffloadWorkers < 0 || sc.SendOffloadMinBytes < 0 {
+		c.configFatalf("Gate %s: send_offload_workers and send_offload_min_bytes must not be negative, but are %d and %d", sec.Name(), sc.SendOffloadWorkers, sc.SendOffloadMinBytes)
+	}
+	if sc.SendOffloadWorkers > 0 && sc.SendWorkers == 0 {
+		c.configFatalf("Gate %s: send_offload_workers requires send_workers", sec.Name())
+	}
+	if sc.ListenAcceptors < 0 {
+		c.configFatalf("Gate %s: listen_acceptors must not be negative, but is %d", sec.Name(), sc.ListenAcceptors)
+	}
+	if sc.TCPSendBuffer < 0 || sc.TCPRecvBuffer < 0 {
+		c.configFatalf("Gate %s: so_sndbuf and so_rcvbuf must not be negative", sec.Name())
+	}
+	if sc.MinClientProtocolVersion < 0 || sc.MinClientProtocolVersion > math.MaxUint16 || sc.MaxClientProtocolVersion < 0 || sc.MaxClientProtocolVersion > math.MaxUint16 {
+		c.configFatalf("Gate %s: min_client_protocol_version and max_client_protocol_version must be within 0 ~ %d", sec.Name(), math.MaxUint16)
+	}
+	if sc.MaxClientProtocolVersion > 0 && sc.MinClientProtocolVersion > sc.MaxClientProtocolVersion {
+		c.configFatalf("Gate %s: min_client_protocol_version %d is greater than max_client_protocol_version %d", sec.Name(), sc.MinClientProtocolVersion, sc.MaxClientProtocolVersion)
 	}
 	return &sc
 }
 
-func _readGateConfig(sec *ini.Section, sc *GateConfig) {
+func (c *Config) _readGateConfig(sec *ini.Section, sc *GateConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "listen_addr" {
-			sc.ListenAddr = key.MustString(sc.ListenAddr)
+			sc.ListenAddr = c.readAddr(sec, key, sc.ListenAddr)
+		} else if name == "listen_acceptors" {
+			sc.ListenAcceptors = key.MustInt(sc.ListenAcceptors)
 		} else if name == "log_file" {
 			sc.LogFile = key.MustString(sc.LogFile)
 		} else if name == "log_stderr" {
 			sc.LogStderr = key.MustBool(sc.LogStderr)
+		} else if name == "log_format" {
+			sc.LogFormat = c.readLogFormat(sec, key.MustString(sc.LogFormat))
+		} else if name == "log_rotate_size_mb" {
+			sc.LogRotateSize = key.MustInt(sc.LogRotateSize)
+		} else if name == "log_rotate_interval_hours" {
+			sc.LogRotateInterval = time.Hour * time.Duration(key.MustInt(int(sc.LogRotateInterval/time.Hour)))
+		} else if name == "log_max_backups" {
+			sc.LogMaxBackups = key.MustInt(sc.LogMaxBackups)
 		} else if name == "http_addr" {
-			sc.HTTPAddr = key.MustString(sc.HTTPAddr)
+			sc.HTTPAddr = c.readAddr(sec, key, sc.HTTPAddr)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
 		} else if name == "gomaxprocs" {
 			sc.GoMaxProcs = key.MustInt(sc.GoMaxProcs)
 		} else if name == "compress_connection" {
 			sc.CompressConnection = key.MustBool(sc.CompressConnection)
+		} else if name == "compress_min_bytes" {
+			sc.CompressMinBytes = key.MustInt(sc.CompressMinBytes)
+		} else if name == "compress_max_entropy" {
+			sc.CompressMaxEntropy = key.MustFloat64(sc.CompressMaxEntropy)
+		} else if name == "compress_skip_msgtypes" {
+			sc.CompressSkipMsgTypes = key.Strings(",")
 		} else if name == "encrypt_connection" {
 			sc.EncryptConnection = key.MustBool(sc.EncryptConnection)
 		} else if name == "rsa_key" {
 			sc.RSAKey = key.MustString(sc.RSAKey)
 		} else if name == "rsa_certificate" {
 			sc.RSACertificate = key.MustString(sc.RSACertificate)
+		} else if name == "tls_client_ca" {
+			sc.TLSClientCA = key.MustString(sc.TLSClientCA)
+		} else if name == "tls_min_version" {
+			sc.TLSMinVersion = key.MustString(sc.TLSMinVersion)
+		} else if name == "tls_cipher_suites" {
+			sc.TLSCipherSuites = key.Strings(",")
+		} else if name == "tls_cert_reload_interval" {
+			sc.TLSCertReloadInterval = key.MustInt(sc.TLSCertReloadInterval)
 		} else if name == "heartbeat_check_interval" {
 			sc.HeartbeatCheckInterval = key.MustInt(sc.HeartbeatCheckInterval)
 		} else if name == "position_sync_interval_ms" {
 			sc.PositionSyncIntervalMS = key.MustInt(sc.PositionSyncIntervalMS)
+		} else if name == "sync_position_precision" {
+			sc.SyncPositionPrecision = key.MustFloat64(sc.SyncPositionPrecision)
+		} else if name == "accept_rate" {
+			sc.AcceptRate = key.MustInt(sc.AcceptRate)
+		} else if name == "reconnect_backoff_ms" {
+			sc.ReconnectBackoffMS = key.MustInt(sc.ReconnectBackoffMS)
+		} else if name == "session_token_ttl" {
+			sc.SessionTokenTTL = key.MustInt(sc.SessionTokenTTL)
+		} else if name == "session_secret" {
+			sc.SessionSecret = key.MustString(sc.SessionSecret)
+		} else if name == "session_resume_timeout" {
+			sc.SessionResumeTimeout = key.MustInt(sc.SessionResumeTimeout)
+		} else if name == "listen_ws_port" {
+			sc.ListenWSPort = key.MustInt(sc.ListenWSPort)
+		} else if name == "listen_ws_path" {
+			sc.ListenWSPath = key.MustString(sc.ListenWSPath)
+		} else if name == "public_host" {
+			sc.PublicHost = c.readHost(sec, key, sc.PublicHost)
+		} else if name == "fallback_listen_addr" {
+			sc.FallbackListenAddr = c.readAddr(sec, key, sc.FallbackListenAddr)
+		} else if name == "fallback_url" {
+			sc.FallbackURL = key.MustString(sc.FallbackURL)
+		} else if name == "labels" {
+			sc.Labels = c.readLabels(sec, key, sc.Labels)
+		} else if name == "cpu_affinity" {
+			sc.CPUAffinity, sc.CPUAffinityAuto = c.readCPUAffinity(sec, key)
+		} else if strings.HasPrefix(name, "transport_") {
+			sc.Transports[strings.TrimPrefix(name, "transport_")] = key.MustString("")
+		} else if name == "listen_kcp_port" {
+			sc.ListenKCPPort = key.MustInt(sc.ListenKCPPort)
+		} else if name == "kcp_nodelay" {
+			sc.KCPNoDelay = key.MustBool(sc.KCPNoDelay)
+		} else if name == "kcp_interval" {
+			sc.KCPInterval = key.MustInt(sc.KCPInterval)
+		} else if name == "kcp_resend" {
+			sc.KCPResend = key.MustInt(sc.KCPResend)
+		} else if name == "kcp_no_congestion" {
+			sc.KCPNoCongestion = key.MustBool(sc.KCPNoCongestion)
+		} else if name == "kcp_snd_wnd" {
+			sc.KCPSendWindow = key.MustInt(sc.KCPSendWindow)
+		} else if name == "kcp_rcv_wnd" {
+			sc.KCPRecvWindow = key.MustInt(sc.KCPRecvWindow)
+		} else if name == "max_packets_per_second" {
+			sc.MaxPacketsPerSecond = key.MustInt(sc.MaxPacketsPerSecond)
+		} else if name == "max_bytes_per_second" {
+			sc.MaxBytesPerSecond = key.MustInt(sc.MaxBytesPerSecond)
+		} else if name == "max_pending_packets" {
+			sc.MaxPendingPackets = key.MustInt(sc.MaxPendingPackets)
+		} else if name == "flood_policy" {
+			sc.FloodPolicy = key.MustString(sc.FloodPolicy)
+		} else if name == "connection_scorer" {
+			sc.ConnectionScorer = key.MustString(sc.ConnectionScorer)
+		} else if name == "connection_score_interval" {
+			sc.ConnectionScoreInterval = key.MustInt(sc.ConnectionScoreInterval)
+		} else if name == "write_timeout" {
+			sc.WriteTimeout = key.MustInt(sc.WriteTimeout)
+		} else if name == "stuck_connection_timeout" {
+			sc.StuckConnectionTimeout = key.MustInt(sc.StuckConnectionTimeout)
+		} else if name == "send_workers" {
+			sc.SendWorkers = key.MustInt(sc.SendWorkers)
+		} else if name == "send_offload_workers" {
+			sc.SendOffloadWorkers = key.MustInt(sc.SendOffloadWorkers)
+		} else if name == "send_offload_min_bytes" {
+			sc.SendOffloadMinBytes = key.MustInt(sc.SendOffloadMinBytes)
+		} else if name == "client_codec" {
+			sc.ClientCodec = key.MustString(sc.ClientCodec)
+		} else if name == "tcp_nodelay" {
+			sc.TCPNoDelay = key.MustBool(sc.TCPNoDelay)
+		} else if name == "so_sndbuf" {
+			sc.TCPSendBuffer = key.MustInt(sc.TCPSendBuffer)
+		} else if name == "so_rcvbuf" {
+			sc.TCPRecvBuffer = key.MustInt(sc.TCPRecvBuffer)
+		} else if name == "tcp_keepalive_interval" {
+			sc.TCPKeepAlive = key.MustInt(sc.TCPKeepAlive)
+		} else if name == "min_client_protocol_version" {
+			sc.MinClientProtocolVersion = key.MustInt(sc.MinClientProtocolVersion)
+		} else if name == "max_client_protocol_version" {
+			sc.MaxClientProtocolVersion = key.MustInt(sc.MaxClientProtocolVersion)
+		} else if name == "client_update_url" {
+			sc.ClientUpdateURL = key.MustString(sc.ClientUpdateURL)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
 
-func readDispatcherCommonConfig(section *ini.Section, dc *DispatcherConfig) {
+func (c *Config) readDispatcherCommonConfig(section *ini.Section, dc *DispatcherConfig) {
 	dc.ListenAddr = "127.0.0.1:13000"
-	dc.AdvertiseAddr = "127.0.0.1:13000"
+	dc.AdvertiseAddr = "" // defaults to listen_addr
 	dc.HTTPAddr = "127.0.0.1:23000"
 	dc.LogFile = "dispatcher.log"
 	dc.LogStderr = true
+	dc.LogFormat = "console"
 	dc.LogLevel = _DEFAULT_LOG_LEVEL
-
-	_readDispatcherConfig(section, dc)
+	dc.FailoverTimeout = _DEFAULT_FAILOVER_TIMEOUT
+	dc.CreationQueueMaxLen = _DEFAULT_CREATION_QUEUE_MAX_LEN
+	dc.CreationQueueTimeout = _DEFAULT_CREATION_QUEUE_TIMEOUT
+	dc.TCPNoDelay = true
+	dc.TCPSendBuffer = 1024 * 1024
+	dc.TCPRecvBuffer = 1024 * 1024
+	dc.TCPKeepAlive = 0
+	dc.Workers = 0
+
+	c._readDispatcherConfig(section, dc)
 }
 
-func readDispatcherConfig(sec *ini.Section, dispatcherCommonConfig *DispatcherConfig) *DispatcherConfig {
+func (c *Config) readDispatcherConfig(sec *ini.Section, dispatcherCommonConfig *DispatcherConfig) *DispatcherConfig {
 	dc := *dispatcherCommonConfig // copy from game_common
-	_readDispatcherConfig(sec, &dc)
+	// standby is specific to each dispatcher
+	dc.StandbyAddr, dc.StandbyListenAddr = "", ""
+	c._readDispatcherConfig(sec, &dc)
 	// validate dispatcher config
+	if dc.AdvertiseIP != "" && (!sec.HasKey("advertise_addr") || sec.Key("advertise_addr").String() == "") {
+		_, port, _ := net.SplitHostPort(dc.ListenAddr)
+		dc.AdvertiseAddr = net.JoinHostPort(dc.AdvertiseIP, port)
+	} else if dc.AdvertiseAddr == "" {
+		dc.AdvertiseAddr = DialAddr(dc.ListenAddr)
+	}
+	if dc.StandbyListenAddr == "" {
+		dc.StandbyListenAddr = dc.StandbyAddr
+	}
+	if isWildcardAddr(dc.AdvertiseAddr) || isWildcardAddr(dc.StandbyAddr) {
+		c.configFatalf("section %s: advertise_addr and standby_addr are dialed by games and gates, and should not be wildcard addresses", sec.Name())
+	}
+	if dc.StandbyAddr != "" && dc.StandbyAddr == dc.AdvertiseAddr {
+		c.configFatalf("section %s: standby_addr should differ from advertise_addr", sec.Name())
+	}
+	if dc.FailoverTimeout <= 0 {
+		c.configFatalf("section %s: standby_failover_timeout_ms should be positive", sec.Name())
+	}
+	if dc.OverloadCPUPercent < 0 {
+		c.configFatalf("section %s: overload_cpu_percent should not be negative", sec.Name())
+	}
+	if dc.CreationQueueMaxLen <= 0 || dc.CreationQueueTimeout <= 0 {
+		c.configFatalf("section %s: creation_queue_max_len and creation_queue_timeout_ms should be positive", sec.Name())
+	}
+	if dc.TCPSendBuffer < 0 || dc.TCPRecvBuffer < 0 {
+		c.configFatalf("section %s: so_sndbuf and so_rcvbuf should not be negative", sec.Name())
+	}
+	if dc.Workers < 0 {
+		c.configFatalf("section %s: workers should not be negative", sec.Name())
+	}
 	return &dc
 }
 
-func _readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
+func (c *Config) _readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "advertise_addr" {
-			config.AdvertiseAddr = key.MustString(config.AdvertiseAddr)
+			config.AdvertiseAddr = c.readAddr(sec, key, "") // empty for listen_addr
+		} else if name == "advertise_ip" {
+			config.AdvertiseIP = c.readHost(sec, key, config.AdvertiseIP)
 		} else if name == "listen_addr" {
-			config.ListenAddr = key.MustString(config.ListenAddr)
+			config.ListenAddr = c.readAddr(sec, key, config.ListenAddr)
 		} else if name == "log_file" {
 			config.LogFile = key.MustString(config.LogFile)
 		} else if name == "log_stderr" {
 			config.LogStderr = key.MustBool(config.LogStderr)
+		} else if name == "log_format" {
+			config.LogFormat = c.readLogFormat(sec, key.MustString(config.LogFormat))
+		} else if name == "log_rotate_size_mb" {
+			config.LogRotateSize = key.MustInt(config.LogRotateSize)
+		} else if name == "log_rotate_interval_hours" {
+			config.LogRotateInterval = time.Hour * time.Duration(key.MustInt(int(config.LogRotateInterval/time.Hour)))
+		} else if name == "log_max_backups" {
+			config.LogMaxBackups = key.MustInt(config.LogMaxBackups)
 		} else if name == "http_addr" {
-			config.HTTPAddr = key.MustString(config.HTTPAddr)
+			config.HTTPAddr = c.readAddr(sec, key, config.HTTPAddr)
 		} else if name == "log_level" {
 			config.LogLevel = key.MustString(config.LogLevel)
+		} else if name == "standby_addr" {
+			config.StandbyAddr = c.readAddr(sec, key, config.StandbyAddr)
+		} else if name == "standby_listen_addr" {
+			config.StandbyListenAddr = c.readAddr(sec, key, config.StandbyListenAddr)
+		} else if name == "standby_failover_timeout_ms" {
+			config.FailoverTimeout = time.Millisecond * time.Duration(key.MustInt(int(config.FailoverTimeout/time.Millisecond)))
+		} else if name == "overload_cpu_percent" {
+			config.OverloadCPUPercent = key.MustFloat64(config.OverloadCPUPercent)
+		} else if name == "creation_queue_max_len" {
+			config.CreationQueueMaxLen = key.MustInt(config.CreationQueueMaxLen)
+		} else if name == "creation_queue_timeout_ms" {
+			config.CreationQueueTimeout = time.Millisecond * time.Duration(key.MustInt(int(config.CreationQueueTimeout/time.Millisecond)))
+		} else if name == "tcp_nodelay" {
+			config.TCPNoDelay = key.MustBool(config.TCPNoDelay)
+		} else if name == "so_sndbuf" {
+			config.TCPSendBuffer = key.MustInt(config.TCPSendBuffer)
+		} else if name == "so_rcvbuf" {
+			config.TCPRecvBuffer = key.MustInt(config.TCPRecvBuffer)
+		} else if name == "tcp_keepalive_interval" {
+			config.TCPKeepAlive = key.MustInt(config.TCPKeepAlive)
+		} else if name == "workers" {
+			config.Workers = key.MustInt(config.Workers)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 	return
 }
 
-func readStorageConfig(sec *ini.Section, config *StorageConfig) {
+func (c *Config) readStorageConfig(sec *ini.Section, config *StorageConfig, defaultType string) {
 	// setup default values
-	config.Type = "filesystem"
+	config.Type = defaultType
 	config.Directory = "_entity_storage"
 	config.DB = _DEFAULT_STORAGE_DB
 	config.Url = ""
 	config.Driver = ""
 	config.StartNodes = common.StringSet{}
+	config.TrashRetention = _DEFAULT_TRASH_RETENTION
+	config.BackupSchedule = ""
+	config.BackupDir = "_backup"
+	config.BackupRetention = _DEFAULT_BACKUP_RETENTION
+	config.WriteBehindInterval = 0
+	config.WriteBehindMaxPending = _DEFAULT_WRITE_BEHIND_MAX_PENDING
+	config.Options = map[string]string{}
 
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "type" {
 			config.Type = key.MustString(config.Type)
+		} else if name == "trash_retention_days" {
+			config.TrashRetention = time.Hour * 24 * time.Duration(key.MustInt(int(_DEFAULT_TRASH_RETENTION/time.Hour/24)))
+		} else if name == "backup_schedule" {
+			config.BackupSchedule = key.MustString(config.BackupSchedule)
+		} else if name == "backup_dir" {
+			config.BackupDir = key.MustString(config.BackupDir)
+		} else if name == "backup_retention_days" {
+			config.BackupRetention = time.Hour * 24 * time.Duration(key.MustInt(int(_DEFAULT_BACKUP_RETENTION/time.Hour/24)))
+		} else if name == "write_behind_interval_ms" {
+			config.WriteBehindInterval = time.Millisecond * time.Duration(key.MustInt(int(config.WriteBehindInterval/time.Millisecond)))
+		} else if name == "write_behind_max_pending" {
+			config.WriteBehindMaxPending = key.MustInt(config.WriteBehindMaxPending)
+		} else if name == "namespace" {
+			config.Namespace = key.MustString(config.Namespace)
+		} else if name == "previous_namespace" {
+			config.PreviousNamespace = key.String()
+			config.UsePreviousNamespace = true
 		} else if name == "directory" {
 			config.Directory = key.MustString(config.Directory)
 		} else if name == "url" {
@@ -489,10 +1115,18 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 			config.DB = key.MustString(config.DB)
 		} else if name == "driver" {
 			config.Driver = key.MustString(config.Driver)
+		} else if name == "max_open_conns" {
+			config.MaxOpenConns = key.MustInt(config.MaxOpenConns)
+		} else if name == "max_idle_conns" {
+			config.MaxIdleConns = key.MustInt(config.MaxIdleConns)
+		} else if name == "conn_max_lifetime_ms" {
+			config.ConnMaxLifetime = time.Millisecond * time.Duration(key.MustInt(int(config.ConnMaxLifetime/time.Millisecond)))
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if strings.HasPrefix(name, "option_") {
+			config.Options[strings.TrimPrefix(name, "option_")] = key.String()
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 
@@ -500,13 +1134,18 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 		if config.DB == "" {
 			config.DB = "0"
 		}
+	} else if config.Type == "postgres" {
+		if config.Driver == "" {
+			config.Driver = "postgres"
+		}
 	}
 
-	validateStorageConfig(config)
+	c.validateStorageConfig(config)
 }
 
-func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
+func (c *Config) readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 	config.StartNodes = common.StringSet{}
+	config.Options = map[string]string{}
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "type" {
@@ -515,14 +1154,27 @@ func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 			config.Url = key.MustString(config.Url)
 		} else if name == "db" {
 			config.DB = key.MustString(config.DB)
+		} else if name == "namespace" {
+			config.Namespace = key.MustString(config.Namespace)
+		} else if name == "previous_namespace" {
+			config.PreviousNamespace = key.String()
+			config.UsePreviousNamespace = true
 		} else if name == "collection" {
 			config.Collection = key.MustString(config.Collection)
 		} else if name == "driver" {
 			config.Driver = key.MustString(config.Driver)
+		} else if name == "max_open_conns" {
+			config.MaxOpenConns = key.MustInt(config.MaxOpenConns)
+		} else if name == "max_idle_conns" {
+			config.MaxIdleConns = key.MustInt(config.MaxIdleConns)
+		} else if name == "conn_max_lifetime_ms" {
+			config.ConnMaxLifetime = time.Millisecond * time.Duration(key.MustInt(int(config.ConnMaxLifetime/time.Millisecond)))
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if strings.HasPrefix(name, "option_") {
+			config.Options[strings.TrimPrefix(name, "option_")] = key.String()
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 
@@ -530,132 +1182,266 @@ func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 		if config.DB == "" {
 			config.DB = "0"
 		}
+	} else if config.Type == "postgres" {
+		if config.Driver == "" {
+			config.Driver = "postgres"
+		}
 	}
 
-	validateKVDBConfig(config)
+	c.validateKVDBConfig(config)
 }
 
-func validateKVDBConfig(config *KVDBConfig) {
+func (c *Config) validateKVDBConfig(config *KVDBConfig) {
+	c.validateNamespaces(config.Namespace, config.PreviousNamespace)
 	if config.Type == "" {
 		// KVDB not enabled, it's OK
 	} else if config.Type == "mongodb" {
 		// must set DB and Collection for mongodb
 		if config.Url == "" || config.DB == "" || config.Collection == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			c.configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
 	} else if config.Type == "redis" {
 		if config.Url == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			c.configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
 		_, err := strconv.Atoi(config.DB) // make sure db is integer for redis
 		if err != nil {
-			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
+			c.configFatalf("redis db must be integer: %s", config.DB)
 		}
 	} else if config.Type == "redis_cluster" {
 		if len(config.StartNodes) == 0 {
-			gwlog.Fatalf("must have at least 1 start_nodes for [kvdb].redis_cluster")
+			c.configFatalf("must have at least 1 start_nodes for [kvdb].redis_cluster")
 		}
 		for s := range config.StartNodes {
 			if s == "" {
-				gwlog.Fatalf("start_nodes must not be empty")
+				c.configFatalf("start_nodes must not be empty")
 			}
 		}
 	} else if config.Type == "sql" {
 		if config.Driver == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n %s", config.Type, DumpPretty(config))
+			c.configFatalf("invalid %s KVDB config:\n %s", config.Type, DumpPretty(config))
+		}
+		if config.Url == "" {
+			c.configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
+	} else if config.Type == "postgres" {
 		if config.Url == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			c.configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
+	} else if isRegisteredKVDBType(config.Type) {
+		// validated by the registered backend when opened
 	} else {
-		gwlog.Fatalf("unknown storage type: %s", config.Type)
+		c.configFatalf("unknown storage type: %s", config.Type)
 	}
 }
 
-func readDebugConfig(sec *ini.Section, config *DebugConfig) {
+func (c *Config) readDebugConfig(sec *ini.Section, config *DebugConfig) {
 	config.Debug = false
+	config.AdminUsers = map[string]AdminUser{}
+	config.AdminAuditLog = "admin_audit.log"
 
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "debug" {
 			config.Debug = key.MustBool(config.Debug)
+		} else if name == "admin_token" {
+			config.AdminToken = key.MustString(config.AdminToken)
+		} else if strings.HasPrefix(name, "admin_user_") {
+			user := strings.TrimPrefix(name, "admin_user_")
+			role, token := key.MustString(""), ""
+			if i := strings.IndexByte(role, ':'); i >= 0 {
+				role, token = role[:i], role[i+1:]
+			}
+			if role != AdminRoleReadOnly && role != AdminRoleOperator && role != AdminRoleGM {
+				c.configFatalf("section %s: role of admin user %s must be readonly, operator or gm, but is %s", sec.Name(), user, role)
+			}
+			config.AdminUsers[user] = AdminUser{Role: role, Token: token}
+		} else if name == "admin_audit_log" {
+			config.AdminAuditLog = key.MustString(config.AdminAuditLog)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	tokens := map[string]string{config.AdminToken: "admin_token"}
+	for user, u := range config.AdminUsers {
+		if u.Token == "" {
+			continue
 		}
+		if other, ok := tokens[u.Token]; ok {
+			c.configFatalf("section %s: admin user %s has the same token as %s", sec.Name(), user, other)
+		}
+		tokens[u.Token] = "admin user " + user
 	}
 }
 
-func checkConfigError(err error, msg string) {
+func (c *Config) readTracingConfig(sec *ini.Section, config *TracingConfig) {
+	config.SampleRate = _DEFAULT_TRACING_SAMPLE_RATE
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "endpoint" {
+			config.Endpoint = key.MustString(config.Endpoint)
+		} else if name == "sample_rate" {
+			config.SampleRate = key.MustFloat64(config.SampleRate)
+		} else {
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		c.configFatalf("sample_rate of [tracing] should be between 0 and 1: %v", config.SampleRate)
+	}
+}
+
+func (c *Config) readTelemetryConfig(sec *ini.Section, config *TelemetryConfig) {
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "exporters" {
+			config.Exporters = key.Strings(",")
+		} else if name == "push_interval" {
+			config.PushInterval = key.MustInt(config.PushInterval)
+		} else if name == "statsd_addr" {
+			config.StatsDAddr = c.readAddr(sec, key, config.StatsDAddr)
+		} else if name == "statsd_prefix" {
+			config.StatsDPrefix = key.MustString(config.StatsDPrefix)
+		} else if name == "otlp_endpoint" {
+			config.OTLPEndpoint = key.MustString(config.OTLPEndpoint)
+		} else {
+			c.configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if config.PushInterval <= 0 {
+		c.configFatalf("push_interval of [telemetry] should be positive: %d", config.PushInterval)
+	}
+	for _, exporter := range config.Exporters {
+		if exporter == "otlp" && config.OTLPEndpoint == "" {
+			c.configFatalf("otlp exporter of [telemetry] is enabled, but otlp_endpoint is not set")
+		}
+	}
+}
+
+// configFatalf quits the process on config errors, except when the config is being reloaded by watching or validated by Validate
+func (c *Config) configFatalf(format string, args ...interface{}) {
+	if c.validateErrors != nil {
+		*c.validateErrors = append(*c.validateErrors, errors.Errorf(format, args...))
+		return
+	}
+	if c.reloading {
+		panic(errors.Errorf(format, args...))
+	}
+	gwlog.Fatalf(format, args...)
+}
+
+func (c *Config) checkConfigError(err error, msg string) {
 	if err != nil {
 		if msg == "" {
 			msg = err.Error()
 		}
-		gwlog.Fatalf("read config error: %s", msg)
+		c.configFatalf("read config error: %s", msg)
 	}
 }
 
-func validateStorageConfig(config *StorageConfig) {
+func (c *Config) validateStorageConfig(config *StorageConfig) {
+	c.validateNamespaces(config.Namespace, config.PreviousNamespace)
+	if config.WriteBehindMaxPending < 0 {
+		c.configFatalf("write_behind_max_pending should not be negative: %d", config.WriteBehindMaxPending)
+	}
+
 	if config.Type == "filesystem" {
 		// directory must be set
 		if config.Directory == "" {
-			gwlog.Fatalf("directory is not set in %s storage config", config.Type)
+			c.configFatalf("directory is not set in %s storage config", config.Type)
 		}
 	} else if config.Type == "mongodb" {
 		if config.Url == "" {
-			gwlog.Fatalf("url is not set in %s storage config", config.Type)
+			c.configFatalf("url is not set in %s storage config", config.Type)
 		}
 		if config.DB == "" {
-			gwlog.Fatalf("db is not set in %s storage config", config.Type)
+			c.configFatalf("db is not set in %s storage config", config.Type)
 		}
 	} else if config.Type == "redis" {
 		if config.Url == "" {
-			gwlog.Fatalf("redis host is not set")
+			c.configFatalf("redis host is not set")
 		}
 		if _, err := strconv.Atoi(config.DB); err != nil {
-			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
+			c.configFatalf("redis db must be integer: %s", config.DB)
 		}
 	} else if config.Type == "redis_cluster" {
 		if len(config.StartNodes) == 0 {
-			gwlog.Fatalf("must have at least 1 start_nodes for [storage].redis_cluster")
+			c.configFatalf("must have at least 1 start_nodes for [storage].redis_cluster")
 		}
 		for s := range config.StartNodes {
 			if s == "" {
-				gwlog.Fatalf("start_nodes must not be empty")
+				c.configFatalf("start_nodes must not be empty")
 			}
 		}
 	} else if config.Type == "sql" {
 		if config.Driver == "" {
-			gwlog.Fatalf("sql driver is not set")
+			c.configFatalf("sql driver is not set")
+		}
+		if config.Url == "" {
+			c.configFatalf("db url is not set")
 		}
+	} else if config.Type == "postgres" {
 		if config.Url == "" {
-			gwlog.Fatalf("db url is not set")
+			c.configFatalf("url is not set in %s storage config", config.Type)
 		}
+	} else if isRegisteredStorageType(config.Type) {
+		// validated by the registered backend when opened
 	} else {
-		gwlog.Fatalf("unknown storage type: %s", config.Type)
+		c.configFatalf("unknown storage type: %s", config.Type)
 	}
 }
 
-func validateConfig(config *GoWorldConfig) {
+func (c *Config) validateConfig(config *GoWorldConfig) {
 	deploymentConfig := &config.Deployment
 	if deploymentConfig.DesiredGates <= 0 {
-		gwlog.Fatalf("[deployment].desired_gates is %d, which must be positive", deploymentConfig.DesiredGates)
+		c.configFatalf("[deployment].desired_gates is %d, which must be positive", deploymentConfig.DesiredGates)
 	}
 
 	if deploymentConfig.DesiredGames <= 0 {
-		gwlog.Fatalf("[deployment].desired_games is %d, which must be positive", deploymentConfig.DesiredGames)
+		c.configFatalf("[deployment].desired_games is %d, which must be positive", deploymentConfig.DesiredGames)
+	}
+
+	if deploymentConfig.AutoMigrate {
+		if deploymentConfig.AutoMigrateCPUPercent <= 0 || deploymentConfig.AutoMigrateCPUDiff < 0 {
+			c.configFatalf("[deployment].auto_migrate_cpu_percent is %v and auto_migrate_cpu_diff is %v, which must be positive", deploymentConfig.AutoMigrateCPUPercent, deploymentConfig.AutoMigrateCPUDiff)
+		}
+		if deploymentConfig.AutoMigrateBatchSize <= 0 || deploymentConfig.AutoMigrateIntervalMS <= 0 {
+			c.configFatalf("[deployment].auto_migrate_batch_size is %d and auto_migrate_interval_ms is %d, which must be positive", deploymentConfig.AutoMigrateBatchSize, deploymentConfig.AutoMigrateIntervalMS)
+		}
+	}
+
+	if deploymentConfig.DrainTimeout <= 0 || deploymentConfig.DrainBatchSize <= 0 {
+		c.configFatalf("[deployment].drain_timeout is %d and drain_batch_size is %d, which must be positive", deploymentConfig.DrainTimeout, deploymentConfig.DrainBatchSize)
 	}
 
 	dispatchersNum := deploymentConfig.DesiredDispatchers
 	if dispatchersNum != len(config._Dispatchers) {
-		gwlog.Panicf("[deployment].desired_dispatchers is %d, but find %d dispatcher section in config file", dispatchersNum, len(config._Dispatchers))
+		c.configFatalf("[deployment].desired_dispatchers is %d, but find %d dispatcher section in config file", dispatchersNum, len(config._Dispatchers))
 	}
 	if dispatchersNum <= 0 {
-		gwlog.Fatalf("dispatcher not found in config file, must has at least 1 dispatcher")
+		c.configFatalf("dispatcher not found in config file, must has at least 1 dispatcher")
 	}
 
 	for dispatcherid := 1; dispatcherid <= dispatchersNum; dispatcherid++ {
 		if _, ok := config._Dispatchers[uint16(dispatcherid)]; !ok {
-			gwlog.Fatalf("found %d dispatchers in config file, but dispatcher%d is not found. dispatcherid must be 1~%d", dispatchersNum, dispatcherid, dispatchersNum)
+			c.configFatalf("found %d dispatchers in config file, but dispatcher%d is not found. dispatcherid must be 1~%d", dispatchersNum, dispatcherid, dispatchersNum)
+		}
+	}
+}
+
+// validateNamespaces makes sure data namespaces only contain letters and digits, so that they can be used as prefixes
+// of entity types and keys
+func (c *Config) validateNamespaces(namespaces ...string) {
+	for _, ns := range namespaces {
+		for _, r := range ns {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+				c.configFatalf("namespace should only contain letters and digits: %s", ns)
+				break
+			}
 		}
 	}
 }
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"sync"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+var (
+	registeredTypesLock    sync.RWMutex
+	registeredStorageTypes = common.StringSet{} // storage types registered by storage.Register
+	registeredKVDBTypes    = common.StringSet{} // KVDB types registered by kvdb.Register
+)
+
+// RegisterStorageType makes the storage type valid in config, it is called by storage.Register
+func RegisterStorageType(typeName string) {
+	registeredTypesLock.Lock()
+	registeredStorageTypes.Add(typeName)
+	registeredTypesLock.Unlock()
+}
+
+// RegisterKVDBType makes the KVDB type valid in config, it is called by kvdb.Register
+func RegisterKVDBType(typeName string) {
+	registeredTypesLock.Lock()
+	registeredKVDBTypes.Add(typeName)
+	registeredTypesLock.Unlock()
+}
+
+func isRegisteredStorageType(typeName string) bool {
+	registeredTypesLock.RLock()
+	defer registeredTypesLock.RUnlock()
+	return registeredStorageTypes.Contains(typeName)
+}
+
+func isRegisteredKVDBType(typeName string) bool {
+	registeredTypesLock.RLock()
+	defer registeredTypesLock.RUnlock()
+	return registeredKVDBTypes.Contains(typeName)
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// GateServer is the entry of a gate in the server list, which contains the addresses of transports dialed by clients
+//
+// Clients should try transports in the order of TCP, KCP, WebSocket and Fallback, so that clients behind firewalls
+// blocking arbitrary ports can still connect to the fallback, which is WebSocket over TLS on an HTTPS port (e.g. 443).
+type GateServer struct {
+	GateID    uint16            `json:"gateid"`
+	TCP       string            `json:"tcp"`
+	KCP       string            `json:"kcp,omitempty"`
+	WebSocket string            `json:"ws,omitempty"`
+	Fallback  string            `json:"fallback,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"` // labels of the gate, e.g. region:eu for choosing nearby gates
+}
+
+// GetServerList returns the server list of all gates
+func (c *Config) GetServerList() []GateServer {
+	cfg := c.Get()
+	gateids := make([]int, 0, len(cfg._Gates))
+	for gateid := range cfg._Gates {
+		gateids = append(gateids, int(gateid))
+	}
+	sort.Ints(gateids)
+
+	servers := make([]GateServer, 0, len(gateids))
+	for _, gateid := range gateids {
+		servers = append(servers, gateServer(uint16(gateid), cfg._Gates[uint16(gateid)]))
+	}
+	return servers
+}
+
+func gateServer(gateid uint16, gc *GateConfig) GateServer {
+	host, port, _ := net.SplitHostPort(DialAddr(gc.ListenAddr))
+	if gc.PublicHost != "" {
+		host = gc.PublicHost
+	}
+
+	server := GateServer{
+		GateID: gateid,
+		TCP:    net.JoinHostPort(host, port),
+		Labels: gc.Labels,
+	}
+	if gc.ListenKCPPort == 0 {
+		server.KCP = server.TCP
+	} else if gc.ListenKCPPort > 0 {
+		server.KCP = net.JoinHostPort(host, strconv.Itoa(gc.ListenKCPPort))
+	}
+	if gc.ListenWSPort > 0 {
+		scheme := "ws"
+		if gc.EncryptConnection {
+			scheme = "wss"
+		}
+		server.WebSocket = fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(gc.ListenWSPort)), gc.ListenWSPath)
+	}
+	if gc.FallbackURL != "" {
+		server.Fallback = gc.FallbackURL
+	} else if gc.FallbackListenAddr != "" {
+		_, fallbackPort, _ := net.SplitHostPort(gc.FallbackListenAddr)
+		fallbackHost := host
+		if fallbackPort != "443" {
+			fallbackHost = net.JoinHostPort(host, fallbackPort)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			fallbackHost = "[" + host + "]"
+		}
+		server.Fallback = fmt.Sprintf("wss://%s%s", fallbackHost, gc.ListenWSPath)
+	}
+	return server
+}
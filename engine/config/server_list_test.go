@@ -0,0 +1,40 @@
+package config
+
+import "testing"
+
+func TestGetServerList(t *testing.T) {
+	t.Parallel()
+	c := New(sampleConfigFile)
+
+	servers := c.GetServerList()
+	if len(servers) != 2 || servers[0].GateID != 1 || servers[1].GateID != 2 {
+		t.Fatalf("wrong server list: %+v", servers)
+	}
+	if s := servers[0]; s.TCP != "127.0.0.1:14001" || s.KCP != s.TCP || s.WebSocket != "" || s.Fallback != "" {
+		t.Errorf("wrong default server: %+v", s)
+	}
+
+	c.SetOverride("gate1", "public_host", "gate1.example.com")
+	c.SetOverride("gate1", "listen_ws_port", "14101")
+	c.SetOverride("gate1", "fallback_listen_addr", "0.0.0.0:443")
+	c.SetOverride("gate2", "public_host", "[2001:db8::2]")
+	c.SetOverride("gate2", "fallback_listen_addr", "[::]:8443")
+	c.SetOverride("gate2", "listen_kcp_port", "-1")
+	servers = c.GetServerList()
+	if s := servers[0]; s.TCP != "gate1.example.com:14001" || s.WebSocket != "ws://gate1.example.com:14101/ws" || s.Fallback != "wss://gate1.example.com/ws" {
+		t.Errorf("wrong server with fallback: %+v", s)
+	}
+	if s := servers[1]; s.TCP != "[2001:db8::2]:14002" || s.KCP != "" || s.Fallback != "wss://[2001:db8::2]:8443/ws" {
+		t.Errorf("wrong IPv6 server with fallback: %+v", s)
+	}
+
+	c.SetOverride("gate1", "labels", "region:eu")
+	if s := c.GetServerList()[0]; s.Labels["region"] != "eu" {
+		t.Errorf("labels should be advertised: %+v", s)
+	}
+
+	c.SetOverride("gate1", "fallback_url", "wss://play.example.com/gate1")
+	if s := c.GetServerList()[0]; s.Fallback != "wss://play.example.com/gate1" {
+		t.Errorf("fallback_url should be advertised: %+v", s)
+	}
+}
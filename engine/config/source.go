@@ -0,0 +1,136 @@
+package config
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Source is a central store which the config is loaded from, instead of the local config file
+//
+// Sources are specified by URL as the config file, e.g. consul://127.0.0.1:8500/goworld
+type Source interface {
+	// Load loads the config into ini sections
+	Load() (*ini.File, error)
+	// Version returns the version of the config, which changes when the config is modified
+	Version() (string, error)
+}
+
+// Registry is implemented by sources which also keep the live membership of cluster nodes
+type Registry interface {
+	// Register registers the node and keeps it alive until the process quits
+	Register(kind string, id uint16, addr string) error
+	// Nodes returns addresses of all live nodes of the kind
+	Nodes(kind string) (map[uint16]string, error)
+}
+
+// SourceFactory creates the source from URL
+type SourceFactory func(u *url.URL) (Source, error)
+
+const (
+	// NodeDispatcher is the node kind of dispatchers
+	NodeDispatcher = "dispatcher"
+	// NodeGame is the node kind of games
+	NodeGame = "game"
+	// NodeGate is the node kind of gates
+	NodeGate = "gate"
+)
+
+var (
+	sourceFactories = map[string]SourceFactory{
+		"consul": newConsulSource,
+	}
+	sources     = map[string]Source{} // sources are shared by configs of the same URL
+	sourcesLock sync.Mutex
+)
+
+// RegisterSource registers the source factory for URL scheme (e.g. "etcd")
+func RegisterSource(scheme string, factory SourceFactory) {
+	sourceFactories[scheme] = factory
+}
+
+// getSource returns the source of the config file path, or nil if the config file path is a local file
+func (c *Config) getSource(configFile string) Source {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	if src, ok := sources[configFile]; ok {
+		return src
+	}
+
+	u, err := url.Parse(configFile)
+	if err != nil || len(u.Scheme) <= 1 { // windows paths like C:\goworld.ini are parsed as URLs too
+		return nil
+	}
+	factory := sourceFactories[u.Scheme]
+	if factory == nil {
+		return nil
+	}
+
+	src, err := factory(u)
+	c.checkConfigError(err, "")
+	sources[configFile] = src
+	return src
+}
+
+// SetLocalNode sets the kind and ID of this process, which should be called before reading config
+//
+// The local dispatcher is always a member of the cluster, even before it is registered.
+func (c *Config) SetLocalNode(kind string, id uint16) {
+	c.lock.Lock()
+	c.localNodeKind, c.localNodeID = kind, id
+	c.config = nil // config should be read again
+	c.lock.Unlock()
+}
+
+// RegisterLocalNode registers this process as a live node of the cluster, if the config source is a registry
+func (c *Config) RegisterLocalNode(addr string) {
+	c.lock.Lock()
+	configFile, kind, id := c.filePath, c.localNodeKind, c.localNodeID
+	c.lock.Unlock()
+	src := c.getSource(configFile)
+
+	registry, ok := src.(Registry)
+	if !ok {
+		return
+	}
+
+	if err := registry.Register(kind, id, addr); err != nil {
+		gwlog.Panic(errors.Wrapf(err, "register %s%d failed", kind, id))
+	}
+	gwlog.Infof("Registered %s%d at %s", kind, id, addr)
+}
+
+// applyDiscovery sets dispatchers according to live dispatchers in the registry
+//
+// Live dispatchers override the advertise addresses in config, and [deployment].desired_dispatchers is set according to
+// live dispatchers, so GetDispatcherIDs returns the live cluster membership.
+func (c *Config) applyDiscovery(iniFile *ini.File, registry Registry) {
+	nodes, err := registry.Nodes(NodeDispatcher)
+	c.checkConfigError(err, "")
+
+	dispids := make([]int, 0, len(nodes)+1)
+	for dispid, addr := range nodes {
+		dispids = append(dispids, int(dispid))
+		sec := iniFile.Section(NodeDispatcher + strconv.Itoa(int(dispid)))
+		sec.Key("advertise_addr").SetValue(addr)
+	}
+	if c.localNodeKind == NodeDispatcher && nodes[c.localNodeID] == "" {
+		dispids = append(dispids, int(c.localNodeID))
+	}
+	if len(dispids) == 0 {
+		// no dispatcher is live yet (e.g. when dispatchers are starting), use dispatchers in config
+		return
+	}
+
+	sort.Ints(dispids)
+	// dispatcher IDs should be 1 ~ N, since entities are distributed to dispatchers by ID
+	if dispids[len(dispids)-1] != len(dispids) {
+		gwlog.Warnf("Live dispatchers are not continuous: %v", dispids)
+	}
+	iniFile.Section("deployment").Key("desired_dispatchers").SetValue(strconv.Itoa(dispids[len(dispids)-1]))
+}
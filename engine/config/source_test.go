@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+// fakeConsul emulates the KV and session API of Consul which are used by consulSource
+type fakeConsul struct {
+	sync.Mutex
+	kv    map[string][]byte
+	owner map[string]string // key => session
+	index int
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.Lock()
+	defer c.Unlock()
+
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		c.index++
+		json.NewEncoder(w).Encode(map[string]string{"ID": "session"})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		json.NewEncoder(w).Encode([]interface{}{})
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		query := r.URL.Query()
+		w.Header().Set("X-Consul-Index", strconv.Itoa(c.index))
+		if r.Method == "PUT" {
+			session := query.Get("acquire")
+			if owner := c.owner[key]; owner != "" && owner != session {
+				json.NewEncoder(w).Encode(false)
+				return
+			}
+			value, _ := ioutil.ReadAll(r.Body)
+			c.kv[key] = value
+			c.owner[key] = session
+			c.index++
+			json.NewEncoder(w).Encode(true)
+			return
+		}
+
+		var pairs []consulKVPair
+		for k, v := range c.kv {
+			if strings.HasPrefix(k, key) {
+				pairs = append(pairs, consulKVPair{Key: k, Value: v})
+			}
+		}
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+		json.NewEncoder(w).Encode(pairs)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestConsulSource(t *testing.T) {
+	t.Parallel()
+	iniFile, err := ini.Load(sampleConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consul := &fakeConsul{kv: map[string][]byte{}, owner: map[string]string{}}
+	for _, sec := range iniFile.Sections() {
+		for _, key := range sec.Keys() {
+			consul.kv["goworld/config/"+sec.Name()+"/"+key.Name()] = []byte(key.Value())
+		}
+	}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+
+	c := New("consul://" + strings.TrimPrefix(server.URL, "http://") + "/goworld")
+	if c.GetConfigDir() != "" {
+		t.Errorf("config dir should be empty for config source")
+	}
+	if c.GetDeployment().DesiredGames != iniFile.Section("deployment").Key("desired_games").MustInt(0) {
+		t.Errorf("desired games is not loaded from consul: %d", c.GetDeployment().DesiredGames)
+	}
+	version := c.configVersion()
+	if version == "" {
+		t.Fatalf("config version should not be empty")
+	}
+
+	// no dispatcher is live, use dispatchers in config
+	if len(c.GetDispatcherIDs()) != iniFile.Section("deployment").Key("desired_dispatchers").MustInt(0) {
+		t.Errorf("dispatchers in config should be used: %v", c.GetDispatcherIDs())
+	}
+
+	// local dispatcher is always live
+	c.SetLocalNode(NodeDispatcher, 3)
+	if ids := c.GetDispatcherIDs(); len(ids) != 3 {
+		t.Errorf("local dispatcher should be live: %v", ids)
+	}
+	c.RegisterLocalNode("10.0.0.1:13000")
+	if c.configVersion() == version {
+		t.Errorf("config version should be changed by registration")
+	}
+
+	c.SetLocalNode(NodeGame, 1)
+	if ids := c.GetDispatcherIDs(); len(ids) != 3 {
+		t.Errorf("dispatchers should be discovered: %v", ids)
+	}
+	if addr := c.GetDispatcher(3).AdvertiseAddr; addr != "10.0.0.1:13000" {
+		t.Errorf("advertise address should be discovered: %s", addr)
+	}
+
+	src := c.getSource(c.GetConfigFilePath()).(*consulSource)
+	if err := (&consulSource{addr: src.addr, prefix: src.prefix, client: src.client, nodes: map[string]string{}, session: "other"}).acquire("goworld/nodes/dispatcher/3", "x"); err == nil {
+		t.Errorf("node registered by another session should not be acquired")
+	}
+}
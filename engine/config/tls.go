@@ -0,0 +1,45 @@
+package config
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns the TLS version of name (e.g. 1.2)
+func ParseTLSVersion(name string) (uint16, error) {
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, errors.Errorf("unknown TLS version: %s", name)
+	}
+	return version, nil
+}
+
+// ParseTLSCipherSuites returns IDs of cipher suites by names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256), or nil for default cipher suites if names is empty
+func ParseTLSCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	suites := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, errors.Errorf("unknown or insecure TLS cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	if v, err := ParseTLSVersion("1.2"); err != nil || v != tls.VersionTLS12 {
+		t.Errorf("parse TLS version failed: %v, %v", v, err)
+	}
+	if _, err := ParseTLSVersion("2.0"); err == nil {
+		t.Errorf("unknown TLS version should fail")
+	}
+}
+
+func TestParseTLSCipherSuites(t *testing.T) {
+	if ids, err := ParseTLSCipherSuites(nil); ids != nil || err != nil {
+		t.Errorf("empty cipher suites should be default: %v, %v", ids, err)
+	}
+	ids, err := ParseTLSCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(ids) != 1 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("parse cipher suites failed: %v, %v", ids, err)
+	}
+	if _, err := ParseTLSCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Errorf("insecure cipher suite should fail")
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Validate reads the config file and returns all problems found, without quitting the process
+//
+// Problems include unknown sections and keys, missing dispatchers, bad storage and KVDB settings, and address conflicts
+// between dispatchers, games and gates.
+func Validate(configFile string) []error {
+	return New(configFile).Validate()
+}
+
+// Validate reads the config file and returns all problems found, without quitting the process or changing the config
+func (c *Config) Validate() (errs []error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.validateErrors = &errs
+	defer func() {
+		c.validateErrors = nil
+		if r := recover(); r != nil {
+			errs = append(errs, errors.Errorf("%v", r))
+		}
+	}()
+
+	config := c.readGoWorldConfig()
+	if config == nil {
+		return
+	}
+	errs = append(errs, checkAddrConflicts(config)...)
+	return
+}
+
+// checkAddrConflicts returns errors of addresses which are listened by multiple dispatchers, games or gates
+func checkAddrConflicts(config *GoWorldConfig) []error {
+	type listenAddr struct {
+		owner string
+		addr  string
+	}
+	var addrs []listenAddr
+	for dispid := 1; dispid <= config.Deployment.DesiredDispatchers; dispid++ {
+		if dc := config._Dispatchers[uint16(dispid)]; dc != nil {
+			name := fmt.Sprintf("dispatcher%d", dispid)
+			addrs = append(addrs, listenAddr{name + ".listen_addr", dc.ListenAddr}, listenAddr{name + ".http_addr", dc.HTTPAddr})
+		}
+	}
+	for gameid := 1; gameid <= config.Deployment.DesiredGames; gameid++ {
+		gc := config._Games[uint16(gameid)]
+		if gc == nil {
+			gc = &config.GameCommon
+		}
+		addrs = append(addrs, listenAddr{fmt.Sprintf("game%d.http_addr", gameid), gc.HTTPAddr})
+	}
+	for gateid := 1; gateid <= config.Deployment.DesiredGates; gateid++ {
+		gc := config._Gates[uint16(gateid)]
+		if gc == nil {
+			gc = &config.GateCommon
+		}
+		name := fmt.Sprintf("gate%d", gateid)
+		addrs = append(addrs, listenAddr{name + ".listen_addr", gc.ListenAddr}, listenAddr{name + ".http_addr", gc.HTTPAddr})
+		if gc.ListenWSPort > 0 {
+			host, _ := splitListenAddr(gc.ListenAddr)
+			addrs = append(addrs, listenAddr{name + ".listen_ws_port", net.JoinHostPort(host, strconv.Itoa(gc.ListenWSPort))})
+		}
+		if gc.FallbackListenAddr != "" {
+			addrs = append(addrs, listenAddr{name + ".fallback_listen_addr", gc.FallbackListenAddr})
+		}
+	}
+
+	var errs []error
+	for i := range addrs {
+		for j := i + 1; j < len(addrs); j++ {
+			if addrConflicts(addrs[i].addr, addrs[j].addr) {
+				errs = append(errs, errors.Errorf("%s and %s conflict: %s, %s", addrs[i].owner, addrs[j].owner, addrs[i].addr, addrs[j].addr))
+			}
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+// addrConflicts returns if two listen addresses use the same port on the same host, addresses can be port only (e.g. 25001)
+func addrConflicts(addr1, addr2 string) bool {
+	host1, port1 := splitListenAddr(addr1)
+	host2, port2 := splitListenAddr(addr2)
+	if port1 == "" || port1 == "0" || port1 != port2 {
+		return false
+	}
+	return host1 == host2 || isWildcardHost(host1) || isWildcardHost(host2)
+}
+
+func splitListenAddr(addr string) (host, port string) {
+	if !strings.Contains(addr, ":") {
+		return "", addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", ""
+	}
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	return host, port
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+func TestValidate(t *testing.T) {
+	for _, file := range []string{"../../goworld.ini", "../../goworld.ini.sample", "../../goworld_travis.ini"} {
+		if errs := Validate(file); len(errs) != 0 {
+			t.Errorf("%s should be valid: %v", file, errs)
+		}
+	}
+
+	if errs := Validate("not_exist.ini"); len(errs) != 1 {
+		t.Errorf("missing config file should be reported: %v", errs)
+	}
+}
+
+func TestValidateCollectsAllErrors(t *testing.T) {
+	iniFile, err := ini.Load("../../goworld.ini.sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iniFile.Section("game_common").Key("unknown_key").SetValue("1")
+	iniFile.Section("unknown_section").Key("key").SetValue("1")
+	iniFile.Section("storage").Key("type").SetValue("unknown")
+	iniFile.Section("deployment").Key("desired_gates").SetValue("2")
+	iniFile.Section("gate2").Key("http_addr").SetValue("127.0.0.1:24001")
+	iniFile.Section("gate_common").Key("listen_ws_port").SetValue("15000") // all gates listen on the same port
+
+	dir, err := ioutil.TempDir("", "goworld_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "goworld.ini")
+	if err := iniFile.SaveTo(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := Validate(configFile)
+	if len(errs) != 5 {
+		t.Fatalf("should find 5 problems, but found: %v", errs)
+	}
+	if GetConfigFilePath() == configFile {
+		t.Errorf("config file path should not be changed by Validate")
+	}
+}
+
+func TestAddrConflicts(t *testing.T) {
+	cases := []struct {
+		addr1, addr2 string
+		conflict     bool
+	}{
+		{"127.0.0.1:14000", "127.0.0.1:14000", true},
+		{"0.0.0.0:14000", "127.0.0.1:14000", true},
+		{"25001", "127.0.0.1:25001", true},
+		{"10.0.0.1:14000", "10.0.0.2:14000", false},
+		{"127.0.0.1:14000", "127.0.0.1:14001", false},
+		{":0", ":0", false},
+	}
+	for _, c := range cases {
+		if addrConflicts(c.addr1, c.addr2) != c.conflict {
+			t.Errorf("addrConflicts(%s, %s) should be %v", c.addr1, c.addr2, c.conflict)
+		}
+	}
+}
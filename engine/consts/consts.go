@@ -38,12 +38,22 @@ const (
 	DISPATCHER_CLIENT_PROXY_WRITE_FLUSH_INTERVAL = 5 * time.Millisecond
 	// DISPATCHER_CLIENT_FLUSH_INTERVAL is the flush interval for dispatcher clients (game -> dispatcher)
 	DISPATCHER_CLIENT_FLUSH_INTERVAL = 5 * time.Millisecond
+	// CLOCK_SYNC_INTERVAL is the interval for games and gates to exchange clocks with dispatchers
+	CLOCK_SYNC_INTERVAL = time.Second * 10
+	// CLOCK_SYNC_TIMEOUT is the max time for games and gates to wait for clocks of dispatchers when starting
+	CLOCK_SYNC_TIMEOUT = time.Second * 5
 
 	// For Game Service
 	// GAME_SERVICE_PACKET_QUEUE_SIZE is the max packet queue length for game service
 	GAME_SERVICE_PACKET_QUEUE_SIZE = 10000 // packet queue size
 	// GAME_SERVICE_TICK_INTERVAL is the tick interval to tick timers in game service
 	GAME_SERVICE_TICK_INTERVAL = time.Millisecond * 5 // server tick interval => affect timer resolution
+	// SPACE_STATS_EXPORT_INTERVAL is the interval to export space statistics
+	SPACE_STATS_EXPORT_INTERVAL = time.Second * 10
+	// ENTITY_RECONCILE_INTERVAL is the interval for games to reconcile entities with dispatchers
+	ENTITY_RECONCILE_INTERVAL = time.Minute
+	// SIMULATION_LOD_CHECK_INTERVAL is the interval to demote or promote entities by simulation LOD
+	SIMULATION_LOD_CHECK_INTERVAL = time.Second
 
 	// DISPATCHER_CLIENT_WRITE_BUFFER_SIZE is the writer buffer size for gates/games' connections to dispatcher
 	DISPATCHER_CLIENT_WRITE_BUFFER_SIZE = 1024 * 1024
@@ -55,6 +65,10 @@ const (
 	GATE_SERVICE_PACKET_QUEUE_SIZE = 10000
 	// GATE_SERVICE_TICK_INTERVAL is the tick interval to tick timers in gate service
 	GATE_SERVICE_TICK_INTERVAL = time.Millisecond * 5 // server tick interval => affect timer resolution
+	// GATE_CHECK_SESSION_TOKENS_INTERVAL is the interval for gate to check expiry and rotation of session tokens
+	GATE_CHECK_SESSION_TOKENS_INTERVAL = time.Second
+	// GATE_CHECK_STUCK_CONNECTIONS_INTERVAL is the interval for gate to check clients not reading packets sent to them
+	GATE_CHECK_STUCK_CONNECTIONS_INTERVAL = time.Second
 	// CLIENT_PROXY_WRITE_BUFFER_SIZE is the write buffer size for gates' client proxies
 	CLIENT_PROXY_WRITE_BUFFER_SIZE = 1024 * 1024
 	// CLIENT_PROXY_READ_BUFFER_SIZE is the read buffer size for gates' client proxies
@@ -64,6 +78,9 @@ const (
 	// CLIENT_PROXY_SET_TCP_NO_DELAY = true sets client proxies to TcpNoDelay
 	CLIENT_PROXY_SET_TCP_NO_DELAY     = true
 	CLIENT_PROXY_WRITE_FLUSH_INTERVAL = time.Millisecond * 5
+	// CLIENT_PROXY_CONGESTED_PENDING_PACKETS is the number of pending packets for client proxies to be considered as congested,
+	// unreliable messages are dropped when client proxies are congested
+	CLIENT_PROXY_CONGESTED_PENDING_PACKETS = 256
 
 	//SAVE_INTERVAL      = time.Minute * 5 // Save interval of entities
 
@@ -0,0 +1,183 @@
+package crontab
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+)
+
+// Cron jobs
+//
+// Cron jobs are added with the same name and schedule on all games. When a job is due, every game claims the run to the
+// dispatcher of the job, which grants the run to the first game claiming it, so each run is executed by only one game
+// in the cluster. The last run time of each job is recorded in KVDB (if configured), so that runs missed when the
+// cluster is down can be executed when games start according to the MissedRunPolicy of the job.
+
+const (
+	cronJobKVDBKeyPrefix      = "_cronjob/"
+	_CRON_JOB_MAX_MISSED_RUNS = 100 // at most so many missed runs are executed by MissedRunAll
+)
+
+// MissedRunPolicy decides how runs missed when the cluster is down are handled when games start
+type MissedRunPolicy int
+
+const (
+	// MissedRunSkip skips missed runs
+	MissedRunSkip MissedRunPolicy = iota
+	// MissedRunOnce executes the latest missed run once
+	MissedRunOnce
+	// MissedRunAll executes all missed runs in order
+	MissedRunAll
+)
+
+// CronJobHandler is called with the scheduled time of the run
+type CronJobHandler func(runTime time.Time)
+
+type cronJob struct {
+	name     string
+	schedule *Schedule
+	policy   MissedRunPolicy
+	handler  CronJobHandler
+	timer    *timer.Timer
+}
+
+var (
+	cronJobs        = map[string]*cronJob{}
+	cronJobLocation = time.Local
+	cronJobsStarted bool
+)
+
+// SetCronJobLocation sets the time zone of schedules of cron jobs, called by engine
+func SetCronJobLocation(loc *time.Location) {
+	cronJobLocation = loc
+}
+
+// AddCronJob adds a cron job which is executed by one game of the cluster at times matching the cron expression
+//
+// The job should be added on all games, typically before the game runs. See ParseCronExpr for the format of expr.
+func AddCronJob(expr string, name string, policy MissedRunPolicy, handler CronJobHandler) {
+	schedule, err := ParseCronExpr(expr)
+	if err != nil {
+		gwlog.Panicf("AddCronJob %s: %s", name, err)
+	}
+	if cronJobs[name] != nil {
+		gwlog.Panicf("AddCronJob %s: cron job already exists", name)
+	}
+	if policy != MissedRunSkip && !kvdb.IsConfigured() {
+		gwlog.Warnf("AddCronJob %s: missed runs can not be detected because KVDB is not configured", name)
+	}
+
+	job := &cronJob{
+		name:     name,
+		schedule: schedule,
+		policy:   policy,
+		handler:  handler,
+	}
+	cronJobs[name] = job
+	if cronJobsStarted {
+		job.start()
+	}
+}
+
+// RemoveCronJob removes the cron job from the game
+func RemoveCronJob(name string) {
+	job := cronJobs[name]
+	if job == nil {
+		return
+	}
+	delete(cronJobs, name)
+	if job.timer != nil {
+		job.timer.Cancel()
+	}
+}
+
+// StartCronJobs starts scheduling cron jobs when the deployment is ready, called by engine
+func StartCronJobs() {
+	if cronJobsStarted {
+		return
+	}
+	cronJobsStarted = true
+	for _, job := range cronJobs {
+		job.start()
+	}
+}
+
+// OnCronJobGranted is called by engine when the dispatcher grants the run of the cron job to this game
+func OnCronJobGranted(name string, runTime int64) {
+	job := cronJobs[name]
+	if job == nil {
+		gwlog.Warnf("cron job %s at %d is granted, but the job is removed", name, runTime)
+		return
+	}
+
+	gwlog.Infof("cron job %s: run at %s", name, time.Unix(runTime, 0).In(cronJobLocation))
+	gwutils.RunPanicless(func() {
+		job.handler(time.Unix(runTime, 0).In(cronJobLocation))
+	})
+	if kvdb.IsConfigured() {
+		kvdb.Put(cronJobKVDBKeyPrefix+name, strconv.FormatInt(runTime, 10), func(err error) {
+			if err != nil {
+				gwlog.Errorf("cron job %s: save last run time failed: %s", name, err)
+			}
+		})
+	}
+}
+
+func (job *cronJob) start() {
+	if job.policy == MissedRunSkip || !kvdb.IsConfigured() {
+		job.scheduleNext(time.Now())
+		return
+	}
+
+	now := time.Now()
+	kvdb.Get(cronJobKVDBKeyPrefix+job.name, func(val string, err error) {
+		if cronJobs[job.name] != job {
+			return // removed
+		}
+		if err != nil {
+			gwlog.Errorf("cron job %s: load last run time failed: %s", job.name, err)
+		} else if val != "" {
+			lastRun, _ := strconv.ParseInt(val, 10, 64)
+			for _, runTime := range job.missedRuns(time.Unix(lastRun, 0), now) {
+				dispatchercluster.SendClaimCronJob(job.name, runTime.Unix())
+			}
+		} // else the job never runs
+		job.scheduleNext(now)
+	})
+}
+
+// missedRuns returns runs after the last run and not after now to be executed according to the policy
+func (job *cronJob) missedRuns(lastRun time.Time, now time.Time) (runs []time.Time) {
+	for t := job.schedule.Next(lastRun.In(cronJobLocation)); !t.IsZero() && !t.After(now); t = job.schedule.Next(t) {
+		runs = append(runs, t)
+	}
+	if len(runs) == 0 {
+		return nil
+	}
+
+	gwlog.Infof("cron job %s: %d runs missed since %s", job.name, len(runs), lastRun)
+	if job.policy == MissedRunOnce {
+		return runs[len(runs)-1:]
+	} else if len(runs) > _CRON_JOB_MAX_MISSED_RUNS {
+		return runs[len(runs)-_CRON_JOB_MAX_MISSED_RUNS:]
+	}
+	return runs
+}
+
+func (job *cronJob) scheduleNext(after time.Time) {
+	runTime := job.schedule.Next(after.In(cronJobLocation))
+	if runTime.IsZero() {
+		gwlog.Warnf("cron job %s: no more runs after %s", job.name, after)
+		return
+	}
+
+	job.timer = timer.AddCallback(time.Until(runTime), func() {
+		dispatchercluster.SendClaimCronJob(job.name, runTime.Unix())
+		job.scheduleNext(runTime)
+	})
+}
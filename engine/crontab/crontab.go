@@ -1,8 +1,12 @@
 package crontab
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
@@ -107,23 +111,70 @@ func Register(minute, hour, day, month, dayofweek int, cb func()) Handle {
 	return h
 }
 
+// ParseSchedule parses a schedule in format of "minute hour day month dayofweek" to arguments of Register
+//
+// Each field can be a number, "*" for any time, or "*/N" for every N (same as -N in Register)
+// e.x. "0 4 * * *" means 04:00 every day, "*/10 * * * *" means every 10 minutes
+func ParseSchedule(schedule string) (minute, hour, day, month, dayofweek int, err error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		err = errors.Errorf("invalid schedule %#v: should have 5 fields", schedule)
+		return
+	}
+
+	vals := make([]int, 5)
+	for i, field := range fields {
+		if field == "*" {
+			vals[i] = -1
+		} else if strings.HasPrefix(field, "*/") {
+			var n int
+			n, err = strconv.Atoi(field[2:])
+			if err != nil || n <= 0 {
+				err = errors.Errorf("invalid schedule %#v: invalid field %s", schedule, field)
+				return
+			}
+			vals[i] = -n
+		} else {
+			vals[i], err = strconv.Atoi(field)
+			if err != nil || vals[i] < 0 {
+				err = errors.Errorf("invalid schedule %#v: invalid field %s", schedule, field)
+				return
+			}
+		}
+	}
+
+	if err = checkTime(vals[0], vals[1], vals[2], vals[3], vals[4]); err != nil {
+		err = errors.Wrapf(err, "invalid schedule %#v", schedule)
+		return
+	}
+	minute, hour, day, month, dayofweek = vals[0], vals[1], vals[2], vals[3], vals[4]
+	return
+}
+
 func validateTime(minute, hour, day, month, dayofweek int) {
-	if minute > 59 || minute < -60 {
-		gwlog.Panicf("invalid minute = %d", minute)
+	if err := checkTime(minute, hour, day, month, dayofweek); err != nil {
+		gwlog.Panicf("%s", err)
 	}
+}
 
+// checkTime checks the ranges of arguments of Register
+func checkTime(minute, hour, day, month, dayofweek int) error {
+	if minute > 59 || minute < -60 {
+		return errors.Errorf("invalid minute = %d", minute)
+	}
 	if hour > 23 || hour < -24 {
-		gwlog.Panicf("invalid hour = %d", hour)
+		return errors.Errorf("invalid hour = %d", hour)
 	}
 	if day > 31 || day < -31 || day == 0 {
-		gwlog.Panicf("invalid day = %d", day)
+		return errors.Errorf("invalid day = %d", day)
 	}
 	if month > 12 || month < -12 || month == 0 {
-		gwlog.Panicf("invalid month = %d", month)
+		return errors.Errorf("invalid month = %d", month)
 	}
 	if dayofweek > 7 || dayofweek < -1 {
-		gwlog.Panicf("invalid dayofweek = %d", dayofweek)
+		return errors.Errorf("invalid dayofweek = %d", dayofweek)
 	}
+	return nil
 }
 
 // Unregister a registered crontab handle
@@ -1,6 +1,9 @@
 package crontab
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 var (
 	quit int64
@@ -35,6 +38,82 @@ func TestUnregister(t *testing.T) {
 	check()
 }
 
+func TestParseSchedule(t *testing.T) {
+	minute, hour, day, month, dayofweek, err := ParseSchedule("0 4 * */2 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if minute != 0 || hour != 4 || day != -1 || month != -2 || dayofweek != 1 {
+		t.Fatalf("wrong schedule: %d %d %d %d %d", minute, hour, day, month, dayofweek)
+	}
+
+	for _, schedule := range []string{"", "0 4 * *", "x 4 * * *", "*/0 * * * *", "-1 * * * *"} {
+		if _, _, _, _, _, err := ParseSchedule(schedule); err == nil {
+			t.Errorf("schedule %#v should be invalid", schedule)
+		}
+	}
+
+	// fields out of range are rejected instead of panicking in Register
+	for _, schedule := range []string{"99 * * * *", "* 24 * * *", "* * 0 * *", "* * 32 * *", "* * 0 13 *", "* * * 13 *", "* * * * 8", "* * * * */2", "*/61 * * * *"} {
+		if _, _, _, _, _, err := ParseSchedule(schedule); err == nil {
+			t.Errorf("schedule %#v should be out of range", schedule)
+		}
+	}
+}
+
+func TestParseCronExpr(t *testing.T) {
+	for _, expr := range []string{"", "0 4 * *", "x 4 * * *", "*/0 * * * *", "60 * * * *", "0 0 0 * *", "5-1 * * * *", "0 0 * 13 *"} {
+		if _, err := ParseCronExpr(expr); err == nil {
+			t.Errorf("cron expression %#v should be invalid", expr)
+		}
+	}
+
+	loc := time.UTC
+	now := time.Date(2020, 1, 31, 4, 30, 15, 0, loc) // Friday
+	for _, c := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"0 4 * * *", time.Date(2020, 2, 1, 4, 0, 0, 0, loc)},
+		{"*/20 * * * *", time.Date(2020, 1, 31, 4, 40, 0, 0, loc)},
+		{"30 12 * * 1-5", time.Date(2020, 1, 31, 12, 30, 0, 0, loc)},
+		{"0 0 * * 7", time.Date(2020, 2, 2, 0, 0, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, loc)},
+		{"0 0 1 * 1", time.Date(2020, 2, 1, 0, 0, 0, 0, loc)}, // day or dayofweek
+		{"15,45 3/12 * * *", time.Date(2020, 1, 31, 15, 15, 0, 0, loc)},
+		{"@weekly", time.Date(2020, 2, 2, 0, 0, 0, 0, loc)},
+		{"@monthly", time.Date(2020, 2, 1, 0, 0, 0, 0, loc)},
+		{"0 0 30 2 *", time.Time{}},
+	} {
+		s, err := ParseCronExpr(c.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := s.Next(now); !next.Equal(c.next) {
+			t.Errorf("next time of %#v should be %s, but is %s", c.expr, c.next, next)
+		}
+	}
+}
+
+func TestCronJobMissedRuns(t *testing.T) {
+	s, err := ParseCronExpr("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastRun := time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local)
+	now := lastRun.Add(time.Hour*3 + time.Minute)
+
+	if runs := (&cronJob{schedule: s, policy: MissedRunAll}).missedRuns(lastRun, now); len(runs) != 3 || !runs[2].Equal(lastRun.Add(time.Hour*3)) {
+		t.Errorf("wrong missed runs: %v", runs)
+	}
+	if runs := (&cronJob{schedule: s, policy: MissedRunOnce}).missedRuns(lastRun, now); len(runs) != 1 || !runs[0].Equal(lastRun.Add(time.Hour*3)) {
+		t.Errorf("wrong missed runs: %v", runs)
+	}
+	if runs := (&cronJob{schedule: s, policy: MissedRunAll}).missedRuns(lastRun, lastRun.Add(time.Minute)); len(runs) != 0 {
+		t.Errorf("wrong missed runs: %v", runs)
+	}
+}
+
 //
 //func timerLoop() {
 //	for quit == 0 {
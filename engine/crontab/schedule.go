@@ -0,0 +1,156 @@
+package crontab
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_SCHEDULE_SEARCH_YEARS = 5 // Next gives up if no time matches in the years, e.g. "0 0 30 2 *"
+)
+
+var (
+	scheduleDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, day, month, dayofweek uint64 // bit set of matched values
+	dayRestricted, dayofweekRestricted  bool
+}
+
+type scheduleField struct {
+	min, max int
+}
+
+var (
+	minuteField    = scheduleField{0, 59}
+	hourField      = scheduleField{0, 23}
+	dayField       = scheduleField{1, 31}
+	monthField     = scheduleField{1, 12}
+	dayofweekField = scheduleField{0, 7} // both 0 and 7 are Sunday
+)
+
+// ParseCronExpr parses a cron expression in format of "minute hour day month dayofweek"
+//
+// Each field can be "*", a number, a range "A-B", a step "*/N" or "A-B/N", or a list of them separated by commas,
+// e.x. "0 4 * * *" means 04:00 every day, "30 12 * * 1-5" means 12:30 on weekdays. Descriptors @yearly, @monthly,
+// @weekly, @daily and @hourly are also supported. As in cron, if both day and dayofweek are restricted, the time matches
+// if either of them matches.
+func ParseCronExpr(expr string) (*Schedule, error) {
+	if descriptor, ok := scheduleDescriptors[strings.TrimSpace(expr)]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid cron expression %#v: should have 5 fields", expr)
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %#v", expr)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %#v", expr)
+	}
+	if s.day, err = dayField.parse(fields[2]); err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %#v", expr)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %#v", expr)
+	}
+	if s.dayofweek, err = dayofweekField.parse(fields[4]); err != nil {
+		return nil, errors.Wrapf(err, "invalid cron expression %#v", expr)
+	}
+	if s.dayofweek&(1<<7) != 0 {
+		s.dayofweek |= 1 << 0
+	}
+	s.dayRestricted = fields[2] != "*"
+	s.dayofweekRestricted = fields[4] != "*"
+	return s, nil
+}
+
+func (f scheduleField) parse(field string) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		rangeExpr, step, hasStep := item, 1, false
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			rangeExpr, hasStep = item[:i], true
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %s", item)
+			}
+		}
+
+		begin, end := f.min, f.max
+		if rangeExpr != "*" {
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			if begin, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value in %s", item)
+			}
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value in %s", item)
+				}
+			} else if !hasStep {
+				end = begin // a single value
+			} // else "N/S" means from N to max
+		}
+		if begin < f.min || end > f.max || begin > end {
+			return 0, errors.Errorf("%s out of range [%d, %d]", item, f.min, f.max)
+		}
+
+		for v := begin; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dayMatched := s.day&(1<<uint(t.Day())) != 0
+	dayofweekMatched := s.dayofweek&(1<<uint(t.Weekday())) != 0
+	if s.dayRestricted && s.dayofweekRestricted {
+		return dayMatched || dayofweekMatched
+	}
+	return dayMatched && dayofweekMatched
+}
+
+// Next returns the first time after t matching the schedule in the location of t, or zero time if not found
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	yearLimit := t.Year() + _SCHEDULE_SEARCH_YEARS
+
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
 
@@ -41,6 +42,14 @@ func newDispatcherClient(dctype DispatcherClientType, conn net.Conn, isReconnect
 	return dc
 }
 
+// newDispatcherClientWithConn creates the dispatcher client on the connection as is, packets are written only when flushed
+func newDispatcherClientWithConn(dctype DispatcherClientType, conn netutil.Connection) *DispatcherClient {
+	return &DispatcherClient{
+		GoWorldConnection: proto.NewGoWorldConnection(conn),
+		dctype:            dctype,
+	}
+}
+
 // Close the dispatcher client
 func (dc *DispatcherClient) Close() error {
 	return dc.GoWorldConnection.Close()
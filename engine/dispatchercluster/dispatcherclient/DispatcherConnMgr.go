@@ -17,6 +17,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
@@ -26,26 +27,46 @@ const (
 )
 
 type DispatcherConnMgr struct {
+	conf                                        *config.Config
 	gid                                         uint16 // gateid or gameid
 	dctype                                      DispatcherClientType
 	dispid                                      uint16
 	_dispatcherClient                           *DispatcherClient
 	isReconnect, isRestoreGame, isBanBootEntity bool // more properties for Game
 	delegate                                    IDispatcherClientDelegate
+	useStandby                                  bool // connect to the hot-standby dispatcher instead
+
+	clockSynced     chan struct{} // closed when the first clock of the dispatcher is received
+	clockSyncedOnce bool
+	onReconnected   func(dc *DispatcherClient)
 }
 
 var (
 	errDispatcherNotConnected = errors.New("dispatcher not connected")
+	dispatcherReconnects      = metrics.NewCounter("goworld_dispatcher_reconnects_total", "Reconnections to dispatchers")
 )
 
-func NewDispatcherConnMgr(gid uint16, dctype DispatcherClientType, dispid uint16, isRestoreGame, isBanBootEntity bool, delegate IDispatcherClientDelegate) *DispatcherConnMgr {
+func NewDispatcherConnMgr(conf *config.Config, gid uint16, dctype DispatcherClientType, dispid uint16, isRestoreGame, isBanBootEntity bool, delegate IDispatcherClientDelegate) *DispatcherConnMgr {
 	return &DispatcherConnMgr{
+		conf:            conf,
 		gid:             gid,
 		dctype:          dctype,
 		dispid:          dispid,
 		isRestoreGame:   isRestoreGame,
 		isBanBootEntity: isBanBootEntity,
 		delegate:        delegate,
+		clockSynced:     make(chan struct{}),
+	}
+}
+
+// NewDispatcherConnMgrWithConn creates the manager of a dispatcher client on the connection, which is never connected or
+// reconnected to the dispatcher, e.g. in-memory connections of test harnesses
+func NewDispatcherConnMgrWithConn(gid uint16, dctype DispatcherClientType, dispid uint16, conn netutil.Connection) *DispatcherConnMgr {
+	return &DispatcherConnMgr{
+		gid:               gid,
+		dctype:            dctype,
+		dispid:            dispid,
+		_dispatcherClient: newDispatcherClientWithConn(dctype, conn),
 	}
 }
 
@@ -63,6 +84,12 @@ func (dcm *DispatcherConnMgr) String() string {
 	return fmt.Sprintf("DispatcherConnMgr<%d>", dcm.dispid)
 }
 
+// SetReconnectedCallback sets the callback called with the new dispatcher client each time after reconnected, before
+// Connect is called
+func (dcm *DispatcherConnMgr) SetReconnectedCallback(cb func(dc *DispatcherClient)) {
+	dcm.onReconnected = cb
+}
+
 func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
 	//gwlog.Debugf("assureConnected: _dispatcherClient", _dispatcherClient)
 	var err error
@@ -71,6 +98,7 @@ func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
 		dc, err = dcm.connectDispatchClient()
 		if err != nil {
 			gwlog.Errorf("Connect to dispatcher%d failed: %s", dcm.dispid, err.Error())
+			dcm.switchStandby()
 			time.Sleep(_LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR)
 			continue
 		}
@@ -80,6 +108,13 @@ func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
 		} else {
 			dc.SendSetGateID(dcm.gid)
 		}
+		dc.SendClockSync(time.Now().UnixNano())
+		if dcm.isReconnect {
+			dispatcherReconnects.Inc()
+			if dcm.onReconnected != nil {
+				dcm.onReconnected(dc)
+			}
+		}
 		dcm.isReconnect = true
 
 		gwlog.Infof("dispatcher_client: connected to dispatcher: %s", dc)
@@ -88,18 +123,42 @@ func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
 }
 
 func (dcm *DispatcherConnMgr) connectDispatchClient() (*DispatcherClient, error) {
-	dispatcherConfig := config.GetDispatcher(dcm.dispid)
-	conn, err := netutil.ConnectTCP(dispatcherConfig.AdvertiseAddr)
+	dispatcherConfig := dcm.conf.GetDispatcher(dcm.dispid)
+	addr := dispatcherConfig.AdvertiseAddr
+	if dcm.useStandby && dispatcherConfig.StandbyAddr != "" {
+		addr = dispatcherConfig.StandbyAddr
+	}
+	conn, err := netutil.ConnectTCP(addr)
 	if err != nil {
 		return nil, err
 	}
-	tcpConn := conn.(*net.TCPConn)
-	tcpConn.SetReadBuffer(consts.DISPATCHER_CLIENT_READ_BUFFER_SIZE)
-	tcpConn.SetWriteBuffer(consts.DISPATCHER_CLIENT_WRITE_BUFFER_SIZE)
+	opts := netutil.TCPOptions{
+		NoDelay:    dispatcherConfig.TCPNoDelay,
+		SendBuffer: dispatcherConfig.TCPSendBuffer,
+		RecvBuffer: dispatcherConfig.TCPRecvBuffer,
+		KeepAlive:  time.Second * time.Duration(dispatcherConfig.TCPKeepAlive),
+	}
+	if err := opts.Apply(conn.(*net.TCPConn)); err != nil {
+		gwlog.Warnf("dispatcher_client: set TCP options of %s failed: %s", addr, err)
+	}
 	dc := newDispatcherClient(dcm.dctype, conn, dcm.isReconnect, dcm.isRestoreGame)
 	return dc, nil
 }
 
+// switchStandby switches between the dispatcher and its hot-standby, since only one of them is active
+func (dcm *DispatcherConnMgr) switchStandby() {
+	if dcm.conf.GetDispatcher(dcm.dispid).StandbyAddr == "" {
+		return
+	}
+
+	dcm.useStandby = !dcm.useStandby
+	if dcm.useStandby {
+		gwlog.Warnf("%s: switch to standby dispatcher", dcm)
+	} else {
+		gwlog.Warnf("%s: switch to primary dispatcher", dcm)
+	}
+}
+
 // IDispatcherClientDelegate defines functions that should be implemented by dispatcher clients
 type IDispatcherClientDelegate interface {
 	HandleDispatcherClientPacket(msgtype proto.MsgType, packet *netutil.Packet)
@@ -111,6 +170,8 @@ type IDispatcherClientDelegate interface {
 func (dcm *DispatcherConnMgr) Connect() {
 	dcm.assureConnected()
 	go gwutils.RepeatUntilPanicless(dcm.serveDispatcherClient) // start the recv routine
+	go gwutils.RepeatUntilPanicless(dcm.clockSyncRoutine)
+	dcm.waitClockSynced()
 }
 
 // GetDispatcherClientForSend returns the current dispatcher client for sending messages
@@ -142,6 +203,11 @@ func (dcm *DispatcherConnMgr) serveDispatcherClient() {
 		if consts.DEBUG_PACKETS {
 			gwlog.Debugf("%s.RecvPacket: msgtype=%v, payload=%v", dc, msgtype, pkt.Payload())
 		}
+		if msgtype == proto.MT_CLOCK_SYNC_ACK { // handled in the recv routine for accurate round trip time
+			dcm.handleClockSyncAck(pkt)
+			pkt.Release()
+			continue
+		}
 		dcm.delegate.HandleDispatcherClientPacket(msgtype, pkt)
 	}
 }
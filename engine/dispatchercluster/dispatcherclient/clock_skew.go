@@ -0,0 +1,85 @@
+package dispatcherclient
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Clock skew detection
+//
+// Games and gates send their clocks to dispatchers when connected and every CLOCK_SYNC_INTERVAL, and dispatchers send
+// their clocks back. The skew from a dispatcher is the clock of the dispatcher minus the midpoint of sending and
+// receiving, with error up to half of the round trip time. Skews are exported as metrics and warned if larger than
+// clock_skew_warn_ms of the deployment. Since skews silently break cooldowns, quotas and ordering of timestamps across
+// processes, games and gates refuse to start if they surely differ from any dispatcher by more than clock_skew_max_ms.
+
+var (
+	clockSkews        = metrics.NewGaugeVec("goworld_clock_skew_seconds", "Clock skew from dispatchers, positive if the local clock is behind", "dispatcher")
+	clockSkewWarnings = metrics.NewCounter("goworld_clock_skew_warnings_total", "Clock skews from dispatchers larger than clock_skew_warn_ms")
+)
+
+// estimateClockSkew returns the clock skew from the dispatcher and the round trip time
+func estimateClockSkew(sendTime, dispatcherTime, recvTime int64) (skew time.Duration, rtt time.Duration) {
+	rtt = time.Duration(recvTime - sendTime)
+	skew = time.Duration(dispatcherTime - sendTime - (recvTime-sendTime)/2)
+	return
+}
+
+// exceedsClockSkew returns if the skew is surely larger than the limit, considering the error of the estimation
+func exceedsClockSkew(skew, rtt, limit time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+	return limit > 0 && skew-rtt/2 > limit
+}
+
+// clockSyncRoutine sends the clock to the dispatcher periodically
+func (dcm *DispatcherConnMgr) clockSyncRoutine() {
+	for {
+		time.Sleep(consts.CLOCK_SYNC_INTERVAL)
+		dc := dcm.getDispatcherClient()
+		if dc != nil && !dc.IsClosed() {
+			dc.SendClockSync(time.Now().UnixNano())
+		}
+	}
+}
+
+// waitClockSynced waits for the first clock of the dispatcher, so that the process refuses to start if clocks differ
+func (dcm *DispatcherConnMgr) waitClockSynced() {
+	if dcm.conf.GetDeployment().ClockSkewMaxMS <= 0 {
+		return
+	}
+
+	select {
+	case <-dcm.clockSynced:
+	case <-time.After(consts.CLOCK_SYNC_TIMEOUT):
+		gwlog.Warnf("%s: clock of dispatcher%d is not received in %s, clock skew is not checked before starting", dcm, dcm.dispid, consts.CLOCK_SYNC_TIMEOUT)
+	}
+}
+
+func (dcm *DispatcherConnMgr) handleClockSyncAck(packet *netutil.Packet) {
+	sendTime := int64(packet.ReadUint64())
+	dispatcherTime := int64(packet.ReadUint64())
+	skew, rtt := estimateClockSkew(sendTime, dispatcherTime, time.Now().UnixNano())
+	clockSkews.With(strconv.Itoa(int(dcm.dispid))).Set(skew.Seconds())
+
+	deployment := dcm.conf.GetDeployment()
+	isStarting := dcm.clockSynced != nil && !dcm.clockSyncedOnce
+	if isStarting && exceedsClockSkew(skew, rtt, time.Duration(deployment.ClockSkewMaxMS)*time.Millisecond) {
+		gwlog.Fatalf("%s: clock skew from dispatcher%d is %s (round trip %s), larger than clock_skew_max_ms=%d, refuse to start", dcm, dcm.dispid, skew, rtt, deployment.ClockSkewMaxMS)
+	}
+	if exceedsClockSkew(skew, rtt, time.Duration(deployment.ClockSkewWarnMS)*time.Millisecond) {
+		clockSkewWarnings.Inc()
+		gwlog.Warnf("%s: clock skew from dispatcher%d is %s (round trip %s), larger than clock_skew_warn_ms=%d", dcm, dcm.dispid, skew, rtt, deployment.ClockSkewWarnMS)
+	}
+
+	if isStarting {
+		dcm.clockSyncedOnce = true
+		close(dcm.clockSynced)
+	}
+}
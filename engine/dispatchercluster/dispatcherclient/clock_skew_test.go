@@ -0,0 +1,25 @@
+package dispatcherclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEstimateClockSkew(t *testing.T) {
+	sendTime := int64(time.Second * 100)
+	// the dispatcher is 2s ahead, and the round trip takes 100ms
+	skew, rtt := estimateClockSkew(sendTime, sendTime+int64(time.Second*2+time.Millisecond*50), sendTime+int64(time.Millisecond*100))
+	if skew != time.Second*2 || rtt != time.Millisecond*100 {
+		t.Fatalf("wrong clock skew %s, round trip %s", skew, rtt)
+	}
+
+	if !exceedsClockSkew(skew, rtt, time.Second) || !exceedsClockSkew(-skew, rtt, time.Second) {
+		t.Errorf("skew %s should exceed 1s", skew)
+	}
+	if exceedsClockSkew(skew, rtt, time.Second*2-time.Millisecond*10) {
+		t.Errorf("skew %s is not surely larger than the limit with round trip %s", skew, rtt)
+	}
+	if exceedsClockSkew(skew, rtt, 0) {
+		t.Errorf("skew should never exceed limit 0")
+	}
+}
@@ -13,33 +13,97 @@ var (
 	dispatcherConns []*dispatcherclient.DispatcherConnMgr
 	dispatcherNum   int
 	gid             uint16
+	rpcOutboxes     []*rpcOutbox // entity RPCs sent to each dispatcher but not acked yet
 )
 
-func Initialize(_gid uint16, dctype dispatcherclient.DispatcherClientType, isRestoreGame, isBanBootEntity bool, delegate dispatcherclient.IDispatcherClientDelegate) {
+// Initialize connects to dispatchers of the config
+func Initialize(conf *config.Config, _gid uint16, dctype dispatcherclient.DispatcherClientType, isRestoreGame, isBanBootEntity bool, delegate dispatcherclient.IDispatcherClientDelegate) {
 	gid = _gid
 	if gid == 0 {
 		gwlog.Fatalf("gid is 0")
 	}
 
-	dispIds := config.GetDispatcherIDs()
+	dispIds := conf.GetDispatcherIDs()
 	dispatcherNum = len(dispIds)
 	if dispatcherNum == 0 {
 		gwlog.Fatalf("dispatcher number is 0")
 	}
 
 	dispatcherConns = make([]*dispatcherclient.DispatcherConnMgr, dispatcherNum)
+	rpcOutboxes = make([]*rpcOutbox, dispatcherNum)
 	for _, dispid := range dispIds {
-		dispatcherConns[dispid-1] = dispatcherclient.NewDispatcherConnMgr(gid, dctype, dispid, isRestoreGame, isBanBootEntity, delegate)
+		dcm := dispatcherclient.NewDispatcherConnMgr(conf, gid, dctype, dispid, isRestoreGame, isBanBootEntity, delegate)
+		outbox := newRPCOutbox(dispid)
+		dcm.SetReconnectedCallback(func(dc *dispatcherclient.DispatcherClient) {
+			outbox.resend(func(packet *netutil.Packet) {
+				dc.SendPacket(packet)
+			})
+		})
+		dispatcherConns[dispid-1] = dcm
+		rpcOutboxes[dispid-1] = outbox
 	}
 	for _, dispConn := range dispatcherConns {
 		dispConn.Connect()
 	}
 }
 
+// InitializeWithConns initializes dispatcher clients on the connections instead of connecting to dispatchers in config,
+// e.g. in-memory connections of test harnesses. Packets are written to the connections only when flushed by Flush.
+func InitializeWithConns(_gid uint16, conns []netutil.Connection) {
+	gid = _gid
+	dispatcherNum = len(conns)
+	dispatcherConns = make([]*dispatcherclient.DispatcherConnMgr, dispatcherNum)
+	rpcOutboxes = make([]*rpcOutbox, dispatcherNum)
+	for i, conn := range conns {
+		dispatcherConns[i] = dispatcherclient.NewDispatcherConnMgrWithConn(gid, dispatcherclient.GameDispatcherClientType, uint16(i+1), conn)
+		rpcOutboxes[i] = newRPCOutbox(uint16(i + 1))
+	}
+}
+
+// Flush flushes packets sent to all dispatchers
+func Flush(reason string) {
+	for _, dcm := range dispatcherConns {
+		dcm.GetDispatcherClientForSend().Flush(reason)
+	}
+}
+
 func SendNotifyDestroyEntity(id common.EntityID) error {
 	return SelectByEntityID(id).SendNotifyDestroyEntity(id)
 }
 
+// SendCallEntityMethod sends entity RPC with sequence number, which is sent again after reconnection until acked by
+// the receiver, so that RPCs lost with the connection are not lost and replays can be dropped by receivers
+func SendCallEntityMethod(id common.EntityID, method string, args []interface{}) error {
+	idx := hashEntityID(id) % dispatcherNum
+	pkt := rpcOutboxes[idx].makePacket(id, method, args)
+	err := dispatcherConns[idx].GetDispatcherClientForSend().SendPacket(pkt)
+	pkt.Release()
+	return err
+}
+
+// SendAckEntityRPCs acks entity RPCs applied from the source to the sending game
+func SendAckEntityRPCs(src proto.RPCSource, seqs []uint64) error {
+	return dispatcherConns[src.DispatcherID-1].GetDispatcherClientForSend().SendAckEntityRPCs(src, seqs)
+}
+
+// AckEntityRPCs drops entity RPCs sent to the dispatcher which are acked by receivers
+func AckEntityRPCs(dispid uint16, seqs []uint64) {
+	rpcOutboxes[dispid-1].ack(seqs)
+}
+
+// SendCallEntityMethodWithResult calls the entity method, results are sent back to this game with the call ID
+func SendCallEntityMethodWithResult(id common.EntityID, method string, args []interface{}, callID uint32) error {
+	return SelectByEntityID(id).SendCallEntityMethodWithResult(id, method, args, gid, callID)
+}
+
+// SendCallEntityMethodResult sends results of the entity method called with results to the calling game
+func SendCallEntityMethodResult(id common.EntityID, gameid uint16, callID uint32, code proto.RPCErrorCode, message string, results [][]byte) error {
+	pkt := proto.MakeCallEntityMethodResultPacket(gameid, callID, code, message, results)
+	err := SelectByEntityID(id).SendPacket(pkt)
+	pkt.Release()
+	return err
+}
+
 func SendMigrateRequest(entityID common.EntityID, spaceID common.EntityID, spaceGameID uint16) error {
 	return SelectByEntityID(entityID).SendMigrateRequest(entityID, spaceID, spaceGameID)
 }
@@ -81,6 +145,15 @@ func SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName
 	return SelectByEntityID(entityid).SendCreateEntitySomewhere(gameid, entityid, typeName, data)
 }
 
+func SendCreateEntityAnywhereQueued(entityid common.EntityID, typeName string, priority int32, timeoutMS uint32, data map[string]interface{}) error {
+	return SelectByEntityID(entityid).SendCreateEntityAnywhereQueued(entityid, typeName, priority, timeoutMS, data)
+}
+
+// SendSyncServiceSnapshot sends the snapshot of the service entity to the standby game through the dispatcher of the entity
+func SendSyncServiceSnapshot(standbyGameID uint16, gameid uint16, eid common.EntityID, serviceID string, data map[string]interface{}) error {
+	return SelectByEntityID(eid).SendSyncServiceSnapshot(standbyGameID, gameid, eid, serviceID, data)
+}
+
 func SendGameLBCInfo(lbcinfo proto.GameLBCInfo) {
 	packet := proto.AllocGameLBCInfoPacket(lbcinfo)
 	broadcast(packet)
@@ -94,10 +167,27 @@ func SendStartFreezeGame() {
 	return
 }
 
+// SendSetGameDraining tells all dispatchers that the game is draining
+func SendSetGameDraining() {
+	pkt := proto.MakeSetGameDrainingPacket()
+	broadcast(pkt)
+	pkt.Release()
+}
+
 func SendKvregRegister(srvid string, info string, force bool) {
 	SelectBySrvID(srvid).SendKvregRegister(srvid, info, force)
 }
 
+// SendPublishChannel sends the message to the dispatcher of the channel, so that messages of the channel are ordered
+func SendPublishChannel(channel string, toClients bool, method string, args []interface{}) {
+	SelectByChannel(channel).SendPublishChannel(channel, toClients, method, args)
+}
+
+// SendClaimCronJob claims the run of the cron job to the dispatcher of the job, which grants the first claim of each run
+func SendClaimCronJob(name string, runTime int64) {
+	SelectBySrvID(name).SendClaimCronJob(name, runTime)
+}
+
 func SendCallNilSpaces(exceptGameID uint16, method string, args []interface{}) {
 	// construct one packet for multiple sending
 	packet := proto.AllocCallNilSpacesPacket(exceptGameID, method, args)
@@ -105,6 +195,18 @@ func SendCallNilSpaces(exceptGameID uint16, method string, args []interface{}) {
 	packet.Release()
 }
 
+// SendReconcileEntities sends entity IDs to dispatchers for reconciliation, each dispatcher receives the entities it routes
+func SendReconcileEntities(eids []common.EntityID) {
+	dispEids := make([][]common.EntityID, dispatcherNum)
+	for _, eid := range eids {
+		idx := hashEntityID(eid) % dispatcherNum
+		dispEids[idx] = append(dispEids[idx], eid)
+	}
+	for idx, eids := range dispEids {
+		dispatcherConns[idx].GetDispatcherClientForSend().SendReconcileEntities(eids)
+	}
+}
+
 func EntityIDToDispatcherID(entityid common.EntityID) uint16 {
 	return uint16((hashEntityID(entityid) % dispatcherNum) + 1)
 }
@@ -132,6 +234,11 @@ func SelectBySrvID(srvid string) *dispatcherclient.DispatcherClient {
 	return dispatcherConns[idx].GetDispatcherClientForSend()
 }
 
+func SelectByChannel(channel string) *dispatcherclient.DispatcherClient {
+	idx := hashString(channel) % dispatcherNum
+	return dispatcherConns[idx].GetDispatcherClientForSend()
+}
+
 func Select(dispidx int) *dispatcherclient.DispatcherClient {
 	return dispatcherConns[dispidx].GetDispatcherClientForSend()
 }
@@ -0,0 +1,119 @@
+package dispatchercluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	_MAX_UNACKED_RPCS = 100000          // unacked RPCs kept for each dispatcher, older ones are never sent again
+	_RPC_ACK_TIMEOUT  = time.Minute * 5 // RPCs not acked in this time (e.g. the target entity is not found) are never sent again
+)
+
+var (
+	unackedRPCsExpired = metrics.NewCounter("goworld_unacked_rpcs_expired_total", "Entity RPCs not acked in time, which are not sent again after reconnection")
+	unackedRPCsResent  = metrics.NewCounter("goworld_unacked_rpcs_resent_total", "Entity RPCs sent again after reconnection to dispatchers")
+)
+
+// rpcOutbox keeps entity RPCs sent to one dispatcher until they are acked by receivers, and sends them again after the
+// game reconnects to the dispatcher, since they may be lost with the connection
+type rpcOutbox struct {
+	sync.Mutex
+	dispid  uint16
+	epoch   uint32
+	seq     uint64
+	unacked map[uint64]*unackedRPC
+	order   []uint64 // sequence numbers in sending order, acked ones are removed lazily
+}
+
+type unackedRPC struct {
+	packet   *netutil.Packet
+	sendTime time.Time
+}
+
+func newRPCOutbox(dispid uint16) *rpcOutbox {
+	return &rpcOutbox{
+		dispid: dispid,
+		// sequence numbers start from current time, so that they keep increasing after game restarts
+		seq:     uint64(time.Now().UnixNano()),
+		unacked: map[uint64]*unackedRPC{},
+	}
+}
+
+// makePacket numbers the RPC and keeps its packet until acked
+func (ob *rpcOutbox) makePacket(id common.EntityID, method string, args []interface{}) *netutil.Packet {
+	ob.Lock()
+	defer ob.Unlock()
+
+	now := time.Now()
+	ob.expire(now)
+	ackFloor := ob.ackFloor() // including this RPC
+	ob.seq += 1
+	src := proto.RPCSource{GameID: gid, DispatcherID: ob.dispid, Epoch: ob.epoch}
+	packet := proto.MakeCallEntityMethodWithSeqPacket(id, method, args, src, ob.seq, ackFloor)
+	packet.AddRefCount(1) // released when acked
+	ob.unacked[ob.seq] = &unackedRPC{packet, now}
+	ob.order = append(ob.order, ob.seq)
+	return packet
+}
+
+// ackFloor returns the lowest unacked sequence number, RPCs below which are never sent again
+func (ob *rpcOutbox) ackFloor() uint64 {
+	for len(ob.order) > 0 && ob.unacked[ob.order[0]] == nil {
+		ob.order = ob.order[1:]
+	}
+	if len(ob.order) == 0 {
+		return ob.seq + 1
+	}
+	return ob.order[0]
+}
+
+func (ob *rpcOutbox) expire(now time.Time) {
+	for ob.ackFloor() <= ob.seq {
+		seq := ob.order[0]
+		rpc := ob.unacked[seq]
+		if len(ob.unacked) < _MAX_UNACKED_RPCS && now.Sub(rpc.sendTime) < _RPC_ACK_TIMEOUT {
+			break
+		}
+		delete(ob.unacked, seq)
+		rpc.packet.Release()
+		unackedRPCsExpired.Inc()
+	}
+}
+
+func (ob *rpcOutbox) ack(seqs []uint64) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	for _, seq := range seqs {
+		if rpc := ob.unacked[seq]; rpc != nil {
+			delete(ob.unacked, seq)
+			rpc.packet.Release()
+		}
+	}
+}
+
+// resend sends unacked RPCs in order again after reconnection, RPCs sent later are in the new epoch
+func (ob *rpcOutbox) resend(send func(packet *netutil.Packet)) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	ob.epoch += 1
+	n := 0
+	for _, seq := range ob.order {
+		if rpc := ob.unacked[seq]; rpc != nil {
+			send(rpc.packet)
+			n += 1
+		}
+	}
+	if n > 0 {
+		unackedRPCsResent.Add(float64(n))
+		gwlog.Infof("dispatchercluster: %d unacked entity RPCs are sent again to dispatcher%d", n, ob.dispid)
+	}
+}
@@ -0,0 +1,79 @@
+package dispatchercluster
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func readRPCSeq(sent *netutil.Packet) (src proto.RPCSource, seq uint64, ackFloor uint64) {
+	packet := netutil.NewPacket() // sent packets are kept by the outbox, so they are read by copies
+	defer packet.Release()
+	packet.AppendBytes(sent.Payload())
+	_ = packet.ReadUint16() // msgtype
+	_ = packet.ReadEntityID()
+	_ = packet.ReadVarStr()
+	_ = packet.ReadArgs()
+	src = proto.RPCSource{GameID: packet.ReadUint16(), DispatcherID: packet.ReadUint16(), Epoch: packet.ReadUint32()}
+	seq = packet.ReadUint64()
+	ackFloor = packet.ReadUint64()
+	return
+}
+
+func TestRPCOutbox(t *testing.T) {
+	ob := newRPCOutbox(1)
+	eid := common.GenEntityID()
+	var seqs []uint64
+	for i := 0; i < 3; i++ {
+		pkt := ob.makePacket(eid, "Method", []interface{}{i})
+		_, seq, ackFloor := readRPCSeq(pkt)
+		pkt.Release()
+		if ackFloor != seqs0(seqs, seq) {
+			t.Fatalf("ack floor should be the first unacked seq, but is %d", ackFloor)
+		}
+		seqs = append(seqs, seq)
+	}
+
+	// the first call is held, later ones are acked
+	ob.ack(seqs[1:])
+	pkt := ob.makePacket(eid, "Method", nil)
+	src, seq, ackFloor := readRPCSeq(pkt)
+	pkt.Release()
+	if ackFloor != seqs[0] || src.Epoch != 0 {
+		t.Fatalf("ack floor should stay at the held call, but is %d (epoch %d)", ackFloor, src.Epoch)
+	}
+
+	var resent []uint64
+	ob.resend(func(packet *netutil.Packet) {
+		src, seq, _ := readRPCSeq(packet)
+		if src.Epoch != 0 {
+			t.Fatalf("replays should keep the old epoch")
+		}
+		resent = append(resent, seq)
+	})
+	if len(resent) != 2 || resent[0] != seqs[0] || resent[1] != seq {
+		t.Fatalf("unacked calls should be sent again in order, but %v are sent", resent)
+	}
+
+	pkt = ob.makePacket(eid, "Method", nil)
+	src, last, _ := readRPCSeq(pkt)
+	pkt.Release()
+	if src.Epoch != 1 {
+		t.Fatalf("calls after reconnection should be in the new epoch")
+	}
+
+	ob.ack([]uint64{seqs[0], seq, last})
+	if floor := ob.ackFloor(); floor != last+1 || len(ob.unacked) != 0 {
+		t.Fatalf("all calls should be acked, but ack floor is %d", floor)
+	}
+}
+
+// seqs0 returns the first seq of seqs, or seq if seqs is empty
+func seqs0(seqs []uint64, seq uint64) uint64 {
+	if len(seqs) == 0 {
+		return seq
+	}
+	return seqs[0]
+}
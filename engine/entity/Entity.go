@@ -10,7 +10,6 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-aoi"
-	timer "github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
@@ -20,6 +19,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -36,7 +36,7 @@ type entityTimerInfo struct {
 	Method         string
 	Args           []interface{}
 	Repeat         bool
-	rawTimer       *timer.Timer
+	rawTimer       *timerwheel.Timer
 }
 
 // Entity is the basic execution unit in GoWorld server. Entities can be used to
@@ -53,14 +53,32 @@ type Entity struct {
 	InterestedIn         EntitySet
 	InterestedBy         EntitySet
 	aoi                  aoi.AOI
+	aoiNeighbors         EntitySet // entities found in AOI by the AOI manager of the space
+	aoiDistance          Coord     // AOI distance set by SetAOIDistance, 0 means the AOI distance of the space
 	yaw                  Yaw
-	rawTimers            map[*timer.Timer]struct{}
+	rawTimers            map[*timerwheel.Timer]struct{}
 	timers               map[EntityTimerID]*entityTimerInfo
 	lastTimerId          EntityTimerID
+	persistentTimers     map[PersistentTimerID]*persistentTimerInfo
 	client               *GameClient
 	syncingFromClient    bool
 	Attrs                *MapAttr
+	attrPatch            []attrPatchOp // pending attribute changes to clients
+	posSync              *positionSync // position sync interval & thresholds, nil for syncing every sync interval
 	syncInfoFlag         syncInfoFlag
+	ownerEpoch           uint64              // increased each time the entity is loaded or migrated
+	fenced               bool                // a newer copy of the entity is found, this copy is stale
+	persistentDirty      bool                // persistent attributes are changed since last save
+	lastInputSeq         uint32              // last input sequence number processed from the own client
+	saveRing             *saveRing           // the save ring where the entity is saved periodically
+	saveIndex            int                 // index of the entity in the save ring
+	channels             map[string]struct{} // channels subscribed by the entity
+	timeline             *timeline           // recent events of the entity, nil if not recording
+	syncBase             uint64              // sync version when the entity is created or restored on this game
+	attrSyncVersions     map[string]uint64   // sync versions of the last changes of client attributes
+	simLOD               *simulationLOD      // low simulation tier state, nil if fully simulated
+	desync               *desyncDetector     // inputs recorded for desync detection, nil if not detecting
+	rpcDedup             *proto.RPCDedup     // numbered RPCs applied by the entity, nil if none is received
 	enteringSpaceRequest struct {
 		SpaceID              common.EntityID
 		EnterPos             Vector3
@@ -83,9 +101,15 @@ type entityMigrateData struct {
 	Yaw               Yaw                    `msgpack:"Yaw"`
 	SpaceID           common.EntityID        `msgpack:"SP"`
 	TimerData         []byte                 `msgpack:"TD,omitempty"`
+	PersistentTimers  []byte                 `msgpack:"PT,omitempty"`
 	FilterProps       map[string]string      `msgpack:"FP"`
 	SyncingFromClient bool                   `msgpack""SFC`
 	SyncInfoFlag      syncInfoFlag           `msgpack:"SIF"`
+	OwnerEpoch        uint64                 `msgpack:"OE"`
+	InputSeq          uint32                 `msgpack:"IS,omitempty"`
+	Channels          []string               `msgpack:"CH,omitempty"`
+	Timeline          []TimelineEvent        `msgpack:"TL,omitempty"`
+	RPCDedup          []proto.RPCWindowState `msgpack:"RD,omitempty"`
 }
 
 type syncInfoFlag int
@@ -93,6 +117,7 @@ type syncInfoFlag int
 const (
 	sifSyncOwnClient syncInfoFlag = 1 << iota
 	sifSyncNeighborClients
+	sifAckInputSeq
 )
 
 // IEntity declares functions that is defined in Entity
@@ -115,6 +140,10 @@ type IEntity interface {
 	// Client Notifications
 	OnClientConnected()    // Called when Client is connected to entity (become player)
 	OnClientDisconnected() // Called when Client disconnected
+	OnClientResumed()      // Called when Client reconnects and resumes its session
+	// Simulation LOD
+	OnSimulationDemoted()  // Called when entity is demoted to the low simulation tier
+	OnSimulationPromoted() // Called when entity is promoted to full simulation
 
 	DescribeEntityType(desc *EntityTypeDesc) // Define entity attributes in this function
 }
@@ -134,6 +163,7 @@ func (e *Entity) Destroy() {
 }
 
 func (e *Entity) destroyEntity(isMigrate bool) {
+	e.flushAttrPatch()
 	e.Space.leave(e)
 
 	if !isMigrate {
@@ -144,10 +174,16 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 
 	e.clearRawTimers()
 	e.rawTimers = nil // prohibit further use
+	e.unscheduleSave()
+	e.unsubscribeAllChannels()
 
 	if !isMigrate {
 		e.SetClient(nil) // always set Client to nil before destroy
 		e.Save()
+		if e.IsPersistent() {
+			// the entity might be loaded by another game soon, so do not keep the last save buffered
+			storage.FlushPendingSave(e.TypeName, e.ID)
+		}
 	} else {
 		e.assignClient(nil)
 	}
@@ -162,6 +198,8 @@ func (e *Entity) IsDestroyed() bool {
 }
 
 // Save the entity
+//
+// The write is skipped if persistent attributes are not changed since the last save.
 func (e *Entity) Save() {
 	if !e.IsPersistent() {
 		return
@@ -171,9 +209,38 @@ func (e *Entity) Save() {
 		gwlog.Debugf("SAVING %s ...", e)
 	}
 
+	if e.fenced {
+		return
+	}
+	if !e.persistentDirty {
+		entitySaves.With(e.TypeName, "skipped").Inc()
+		return
+	}
+
+	entitySaves.With(e.TypeName, "written").Inc()
+	e.persistentDirty = false
 	data := e.getPersistentData()
 
-	storage.Save(e.TypeName, e.ID, data, nil)
+	storage.SaveFenced(e.TypeName, e.ID, data, e.ownerEpoch, func(err error) {
+		if errors.Cause(err) == storage.ErrStaleOwnerEpoch {
+			e.fence(err)
+		}
+	})
+}
+
+// fence stops the stale copy of entity from saving and handling RPCs, since a newer copy of the entity owns the data now
+func (e *Entity) fence(err error) {
+	if e.fenced {
+		return
+	}
+
+	e.fenced = true
+	gwlog.Errorf("%s is fenced: %s", e, err)
+}
+
+// IsFenced returns if the entity is a stale copy which is fenced by a newer owner
+func (e *Entity) IsFenced() bool {
+	return e.fenced
 }
 
 // IsSpaceEntity returns if the entity is actually a space
@@ -198,7 +265,7 @@ func (e *Entity) init(typeName string, entityid common.EntityID, entityInstance
 
 	e.typeDesc = registeredEntityTypes[typeName]
 
-	e.rawTimers = map[*timer.Timer]struct{}{}
+	e.rawTimers = map[*timerwheel.Timer]struct{}{}
 	e.timers = map[EntityTimerID]*entityTimerInfo{}
 
 	attrs := NewMapAttr()
@@ -207,39 +274,43 @@ func (e *Entity) init(typeName string, entityid common.EntityID, entityInstance
 
 	e.InterestedIn = EntitySet{}
 	e.InterestedBy = EntitySet{}
+	e.aoiNeighbors = EntitySet{}
+	e.persistentDirty = true // always save once, so that the owner epoch is claimed
+	e.syncBase = nextSyncVersion()
 	aoi.InitAOI(&e.aoi, aoi.Coord(e.typeDesc.aoiDistance), e, e)
 
 	e.I.OnInit()
 }
 
-func (e *Entity) setupSaveTimer() {
-	e.addRawTimer(saveInterval, e.Save)
-}
-
-// SetSaveInterval sets the save interval for entity system
-func SetSaveInterval(duration time.Duration) {
-	saveInterval = duration
-	gwlog.Infof("Save interval set to %s", saveInterval)
-}
-
 // Space Operations related to aoi
 
 func (e *Entity) OnEnterAOI(otherAoi *aoi.AOI) {
-	e.interest(otherAoi.Data.(*Entity))
+	other := otherAoi.Data.(*Entity)
+	e.aoiNeighbors.Add(other)
+	e.updateInterest(other)
 }
 
 func (e *Entity) OnLeaveAOI(otherAoi *aoi.AOI) {
-	e.uninterest(otherAoi.Data.(*Entity))
+	other := otherAoi.Data.(*Entity)
+	e.aoiNeighbors.Del(other)
+	e.updateInterest(other)
 }
 
 // Interests and Uninterest among entities
 func (e *Entity) interest(other *Entity) {
+	other.flushAttrPatch() // pending changes are included in the created entity
+	if e.Space != nil {
+		e.Space.stats.current.aoiEnters += 1
+	}
 	e.InterestedIn.Add(other)
 	other.InterestedBy.Add(e)
 	e.client.sendCreateEntity(other, false)
 }
 
 func (e *Entity) uninterest(other *Entity) {
+	if e.Space != nil {
+		e.Space.stats.current.aoiLeaves += 1
+	}
 	e.InterestedIn.Del(other)
 	other.InterestedBy.Del(e)
 	e.client.sendDestroyEntity(other)
@@ -317,12 +388,21 @@ func (e *Entity) CancelTimer(tid EntityTimerID) {
 		return // timer already fired or cancelled
 	}
 	delete(e.timers, tid)
-	e.cancelRawTimer(timerInfo.rawTimer)
+	if timerInfo.rawTimer != nil {
+		e.cancelRawTimer(timerInfo.rawTimer)
+	}
+	if e.simLOD != nil {
+		delete(e.simLOD.pending, tid)
+	}
 }
 
 func (e *Entity) triggerTimer(tid EntityTimerID, isRepeat bool) {
 	timerInfo := e.timers[tid] // should never be nil
 	if !timerInfo.Repeat {
+		if e.coalesceTimer(tid) {
+			timerInfo.rawTimer = nil // fired, called at the next low tier tick
+			return
+		}
 		delete(e.timers, tid)
 	} else {
 		if !isRepeat {
@@ -333,6 +413,9 @@ func (e *Entity) triggerTimer(tid EntityTimerID, isRepeat bool) {
 
 		now := time.Now()
 		timerInfo.FireTime = now.Add(timerInfo.RepeatInterval)
+		if e.coalesceTimer(tid) {
+			return
+		}
 	}
 
 	e.onCallFromLocal(timerInfo.Method, timerInfo.Args)
@@ -389,9 +472,9 @@ func (e *Entity) restoreTimers(data []byte) error {
 	return nil
 }
 
-func (e *Entity) addRawCallback(d time.Duration, cb timer.CallbackFunc) *timer.Timer {
-	var t *timer.Timer
-	t = timer.AddCallback(d, func() {
+func (e *Entity) addRawCallback(d time.Duration, cb timerwheel.CallbackFunc) *timerwheel.Timer {
+	var t *timerwheel.Timer
+	t = timerwheel.AddCallback(d, func() {
 		delete(e.rawTimers, t)
 		cb()
 	})
@@ -399,13 +482,13 @@ func (e *Entity) addRawCallback(d time.Duration, cb timer.CallbackFunc) *timer.T
 	return t
 }
 
-func (e *Entity) addRawTimer(d time.Duration, cb timer.CallbackFunc) *timer.Timer {
-	t := timer.AddTimer(d, cb)
+func (e *Entity) addRawTimer(d time.Duration, cb timerwheel.CallbackFunc) *timerwheel.Timer {
+	t := timerwheel.AddTimer(d, cb)
 	e.rawTimers[t] = struct{}{}
 	return t
 }
 
-func (e *Entity) cancelRawTimer(t *timer.Timer) {
+func (e *Entity) cancelRawTimer(t *timerwheel.Timer) {
 	delete(e.rawTimers, t)
 	t.Cancel()
 }
@@ -414,7 +497,7 @@ func (e *Entity) clearRawTimers() {
 	for t := range e.rawTimers {
 		t.Cancel()
 	}
-	e.rawTimers = map[*timer.Timer]struct{}{}
+	e.rawTimers = map[*timerwheel.Timer]struct{}{}
 }
 
 // Post a function which will be executed immediately but not in the current stack frames
@@ -440,13 +523,23 @@ func (e *Entity) SetClientSyncing(syncing bool) {
 }
 
 func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
+	defer e.traceMethod(methodName)()
+	defer e.enterMethod()()
+	startTime := time.Now()
 	defer func() {
+		e.addLogicTime(time.Since(startTime))
+		e.observeRPCDuration(methodName, time.Since(startTime))
 		err := recover() // recover from any error during RPC call
 		if err != nil {
 			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			e.recordTimeline(TimelineRPC, "%s paniced: %v", methodName, err)
 		}
 	}()
 
+	if e.fenced {
+		gwlog.Panicf("%s.onCallFromLocal: Method %s is not called since entity is fenced", e, methodName)
+	}
+
 	rpcDesc := e.typeDesc.rpcDescs[methodName]
 	if rpcDesc == nil {
 		// rpc not found
@@ -477,17 +570,29 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 		in[i+1] = reflect.Zero(argType)
 	}
 
+	e.recordRPCTimeline(methodName, in, "")
 	rpcDesc.Func.Call(in)
 }
 
 func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid common.ClientID) {
+	defer e.traceMethod(methodName)()
+	defer e.enterMethod()()
+	startTime := time.Now()
 	defer func() {
+		e.addLogicTime(time.Since(startTime))
+		e.observeRPCDuration(methodName, time.Since(startTime))
 		err := recover() // recover from any error during RPC call
 		if err != nil {
 			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			e.recordTimeline(TimelineRPC, "%s paniced: %v", methodName, err)
 		}
 	}()
 
+	if e.fenced {
+		gwlog.Errorf("%s.onCallFromRemote: Method %s is not called since entity is fenced", e, methodName)
+		return
+	}
+
 	rpcDesc := e.typeDesc.rpcDescs[methodName]
 	if rpcDesc == nil {
 		// rpc not found
@@ -536,6 +641,7 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 		in[i+1] = reflect.Zero(argType)
 	}
 
+	e.recordRPCTimeline(methodName, in, clientid)
 	rpcDesc.Func.Call(in)
 }
 
@@ -607,9 +713,10 @@ func (e *Entity) IsPersistent() bool {
 
 // getPersistentData gets the persistent data
 //
-// Returns persistent attributes by default
+// Returns a new map of persistent attributes, values of which are copy-on-write snapshots shared by saves, so they
+// should never be modified.
 func (e *Entity) getPersistentData() map[string]interface{} {
-	return e.Attrs.ToMapWithFilter(e.typeDesc.persistentAttrs.Contains)
+	return e.Attrs.toSnapshotWithFilter(e.typeDesc.persistentAttrs.Contains)
 }
 
 // loadPersistentData loads persistent data
@@ -619,12 +726,14 @@ func (e *Entity) loadPersistentData(data map[string]interface{}) {
 	e.Attrs.AssignMap(data)
 }
 
+// getClientData gets the pooled data of client attributes, which should be released by releaseAttrMap
 func (e *Entity) getClientData() map[string]interface{} {
-	return e.Attrs.ToMapWithFilter(e.typeDesc.clientAttrs.Contains)
+	return e.Attrs.toMapWithFilterPooled(e.typeDesc.clientAttrs.Contains)
 }
 
+// getAllClientData gets the pooled data of all-client attributes, which should be released by releaseAttrMap
 func (e *Entity) getAllClientData() map[string]interface{} {
-	return e.Attrs.ToMapWithFilter(e.typeDesc.allClientAttrs.Contains)
+	return e.Attrs.toMapWithFilterPooled(e.typeDesc.allClientAttrs.Contains)
 }
 
 // GetMigrateData gets the migration data
@@ -635,9 +744,19 @@ func (e *Entity) GetMigrateData(spaceid common.EntityID) *entityMigrateData {
 		Pos:               e.Position,
 		Yaw:               e.yaw,
 		TimerData:         e.dumpTimers(),
+		PersistentTimers:  e.dumpPersistentTimers(),
 		SpaceID:           spaceid,
 		SyncingFromClient: e.syncingFromClient,
 		SyncInfoFlag:      e.syncInfoFlag,
+		OwnerEpoch:        e.ownerEpoch,
+		InputSeq:          e.lastInputSeq,
+		Channels:          e.SubscribedChannels(),
+	}
+	if e.timeline != nil {
+		md.Timeline = e.timeline.list(time.Time{})
+	}
+	if e.rpcDedup != nil {
+		md.RPCDedup = e.rpcDedup.Dump()
 	}
 
 	if e.client != nil {
@@ -680,12 +799,24 @@ func (e *Entity) SetClient(client *GameClient) {
 	if oldClient == client {
 		return
 	}
+	if client != nil && e.simLOD != nil {
+		e.promoteSimulation() // players are always fully simulated
+	}
+
+	// pending changes are sent to the old client, and included in entities created on the new client
+	e.flushAttrPatch()
+	if e.Space != nil {
+		e.Space.flushAttrPatch()
+	}
+	for neighbor := range e.InterestedIn {
+		neighbor.flushAttrPatch()
+	}
 
 	if oldClient != nil {
 		// send destroy entity to Client
 		dispatchercluster.SelectByEntityID(e.ID).SendClearClientFilterProp(oldClient.gateid, oldClient.clientid)
 
-		for neighbor := range e.InterestedBy {
+		for neighbor := range e.InterestedIn {
 			oldClient.sendDestroyEntity(neighbor)
 		}
 
@@ -697,19 +828,14 @@ func (e *Entity) SetClient(client *GameClient) {
 	}
 
 	e.assignClient(client) // remove old client, assign new client
+	if oldClient != nil {
+		e.recordTimeline(TimelineClient, "client %s on gate%d is removed", oldClient.clientid, oldClient.gateid)
+	}
 
 	if client != nil {
 		// send create entity to new client
 		dispatchercluster.SelectByEntityID(e.ID).SendClearClientFilterProp(client.gateid, client.clientid)
-		client.sendCreateEntity(e, true)
-
-		if !e.Space.IsNil() {
-			client.sendCreateEntity(&e.Space.Entity, false)
-		}
-
-		for neighbor := range e.InterestedBy {
-			client.sendCreateEntity(neighbor, false)
-		}
+		e.createEntitiesOnClient(client)
 	}
 
 	if oldClient != nil && client == nil {
@@ -723,24 +849,45 @@ func (e *Entity) SetClient(client *GameClient) {
 	}
 }
 
+// createEntitiesOnClient creates the entity, its space and neighbors on the client
+func (e *Entity) createEntitiesOnClient(client *GameClient) {
+	client.sendCreateEntity(e, true)
+
+	if !e.Space.IsNil() {
+		client.sendCreateEntity(&e.Space.Entity, false)
+	}
+
+	for neighbor := range e.InterestedIn {
+		client.sendCreateEntity(neighbor, false)
+	}
+}
+
 func (e *Entity) assignClient(client *GameClient) {
 	if e.client != nil {
 		e.client.ownerid = ""
 	}
+	if e.client == nil || client == nil || e.client.clientid != client.clientid {
+		e.lastInputSeq = 0 // input sequence numbers are counted by each client
+		e.desync = nil
+	}
 
 	e.client = client
 	if client != nil {
 		client.ownerid = e.ID
+		e.enableTimeline()
+		e.recordTimeline(TimelineClient, "client %s on gate%d is assigned", client.clientid, client.gateid)
 	}
 }
 
 // CallClient calls the Client entity
 func (e *Entity) CallClient(method string, args ...interface{}) {
+	e.flushAttrPatch()
 	e.client.call(e.ID, method, args)
 }
 
 // CallAllClients calls the entity method on all clients
 func (e *Entity) CallAllClients(method string, args ...interface{}) {
+	e.flushAttrPatch()
 	e.client.call(e.ID, method, args)
 
 	for neighbor := range e.InterestedBy {
@@ -748,6 +895,27 @@ func (e *Entity) CallAllClients(method string, args ...interface{}) {
 	}
 }
 
+// CallClientWithDelivery calls the client entity method with the specified delivery class
+//
+// Messages which are frequent and superseded by later ones (e.g. combat effects) can use DELIVERY_UNRELIABLE, so that
+// they are sent in datagrams to KCP clients, or dropped instead of queued when the client connection is congested.
+// Messages which need not wait for others (e.g. chat) can use DELIVERY_UNORDERED_RELIABLE, so that they are sent on the
+// channel connection if the client binds one.
+func (e *Entity) CallClientWithDelivery(delivery proto.DeliveryClass, method string, args ...interface{}) {
+	e.flushAttrPatch()
+	e.client.callWithDelivery(e.ID, delivery, method, args)
+}
+
+// CallAllClientsWithDelivery calls the entity method on all clients with the specified delivery class
+func (e *Entity) CallAllClientsWithDelivery(delivery proto.DeliveryClass, method string, args ...interface{}) {
+	e.flushAttrPatch()
+	e.client.callWithDelivery(e.ID, delivery, method, args)
+
+	for neighbor := range e.InterestedBy {
+		neighbor.client.callWithDelivery(e.ID, delivery, method, args)
+	}
+}
+
 // GiveClientTo gives Client to other entity
 func (e *Entity) GiveClientTo(other *Entity) {
 	if e.client == nil {
@@ -779,10 +947,19 @@ func (e *Entity) ForAllClients(f func(client *GameClient)) {
 
 func (e *Entity) notifyClientDisconnected() {
 	// called when Client disconnected
+	e.recordTimeline(TimelineClient, "client %s is disconnected", e.getClientID())
 	e.assignClient(nil)
 	e.I.OnClientDisconnected()
 }
 
+func (e *Entity) notifyClientResumed(syncVersion uint64) {
+	// messages to the client are dropped by gate during disconnection, so changes since the sync version are synced to
+	// the resumed client, or entities are created again on the client if the changes are unknown
+	e.recordTimeline(TimelineClient, "client %s is resumed", e.getClientID())
+	e.syncResumedClient(syncVersion)
+	e.I.OnClientResumed()
+}
+
 // OnClientConnected is called when Client is connected
 //
 // Can override this function in custom entity type
@@ -801,17 +978,37 @@ func (e *Entity) OnClientDisconnected() {
 	}
 }
 
+// OnClientResumed is called when Client reconnects and resumes its session, with changes during disconnection synced to the Client
+//
+// Can override this function in custom entity type
+func (e *Entity) OnClientResumed() {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s.OnClientResumed: %s", e, e.client)
+	}
+}
+
 func (e *Entity) getAttrFlag(attrName string) (flag attrFlag) {
 	if e.typeDesc.allClientAttrs.Contains(attrName) {
 		flag = afAllClient
 	} else if e.typeDesc.clientAttrs.Contains(attrName) {
 		flag = afClient
 	}
+	if e.typeDesc.persistentAttrs.Contains(attrName) {
+		flag |= afPersistent
+	}
 
 	return
 }
 
+// markPersistentDirty marks the entity to be saved if the changed attribute is persistent
+func (e *Entity) markPersistentDirty(flag attrFlag) {
+	if flag&afPersistent != 0 {
+		e.persistentDirty = true
+	}
+}
+
 func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interface{}) {
+	e.recordAttrTimeline(ma.getPathFromOwner(), key, "set", val)
 	var flag attrFlag
 	if ma == e.Attrs {
 		// this is the root attr
@@ -819,6 +1016,11 @@ func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interfa
 	} else {
 		flag = ma.flag
 	}
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, ma.getPathFromOwner(), key)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_MAP_CHANGE, Path: ma.getPathFromOwner(), Key: key, Val: val}) {
+		return
+	}
 
 	if flag&afAllClient != 0 {
 		path := ma.getPathFromOwner()
@@ -833,6 +1035,7 @@ func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interfa
 }
 
 func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
+	e.recordAttrTimeline(ma.getPathFromOwner(), key, "deleted", nil)
 	var flag attrFlag
 	if ma == e.Attrs {
 		// this is the root attr
@@ -840,6 +1043,11 @@ func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
 	} else {
 		flag = ma.flag
 	}
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, ma.getPathFromOwner(), key)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_MAP_DEL, Path: ma.getPathFromOwner(), Key: key}) {
+		return
+	}
 
 	if flag&afAllClient != 0 {
 		path := ma.getPathFromOwner()
@@ -854,11 +1062,17 @@ func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
 }
 
 func (e *Entity) sendMapAttrClearToClients(ma *MapAttr) {
+	e.recordAttrTimeline(ma.getPathFromOwner(), nil, "cleared", nil)
 	if ma == e.Attrs {
 		// this is the root attr
 		gwlog.Panicf("outmost e.Attrs can not be cleared")
 	}
 	flag := ma.flag
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, ma.getPathFromOwner(), nil)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_MAP_CLEAR, Path: ma.getPathFromOwner()}) {
+		return
+	}
 
 	if flag&afAllClient != 0 {
 		path := ma.getPathFromOwner()
@@ -873,7 +1087,13 @@ func (e *Entity) sendMapAttrClearToClients(ma *MapAttr) {
 }
 
 func (e *Entity) sendListAttrChangeToClients(la *ListAttr, index int, val interface{}) {
+	e.recordAttrTimeline(la.getPathFromOwner(), index, "set", val)
 	flag := la.flag
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, la.getPathFromOwner(), index)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_LIST_CHANGE, Path: la.getPathFromOwner(), Index: uint32(index), Val: val}) {
+		return
+	}
 
 	if flag&afAllClient != 0 {
 		// TODO: only pack 1 packet, do not marshal multiple times
@@ -889,7 +1109,13 @@ func (e *Entity) sendListAttrChangeToClients(la *ListAttr, index int, val interf
 }
 
 func (e *Entity) sendListAttrPopToClients(la *ListAttr) {
+	e.recordAttrTimeline(la.getPathFromOwner(), nil, "popped", nil)
 	flag := la.flag
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, la.getPathFromOwner(), nil)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_LIST_POP, Path: la.getPathFromOwner()}) {
+		return
+	}
 	if flag&afAllClient != 0 {
 		path := la.getPathFromOwner()
 		e.client.sendNotifyListAttrPop(e.ID, path)
@@ -903,7 +1129,13 @@ func (e *Entity) sendListAttrPopToClients(la *ListAttr) {
 }
 
 func (e *Entity) sendListAttrAppendToClients(la *ListAttr, val interface{}) {
+	e.recordAttrTimeline(la.getPathFromOwner(), nil, "appended", nil)
 	flag := la.flag
+	e.markPersistentDirty(flag)
+	e.stampAttrSync(flag, la.getPathFromOwner(), nil)
+	if e.patchAttr(flag, proto.AttrPatchOp{Op: proto.ATTR_PATCH_LIST_APPEND, Path: la.getPathFromOwner(), Val: val}) {
+		return
+	}
 	if flag&afAllClient != 0 {
 		path := la.getPathFromOwner()
 		e.client.sendNotifyListAttrAppend(e.ID, path, val)
@@ -1090,6 +1322,7 @@ func OnMigrateRequestAck(entityid common.EntityID, spaceid common.EntityID, spac
 }
 
 func (e *Entity) realMigrateTo(spaceid common.EntityID, pos Vector3, spaceGameID uint16) {
+	e.recordTimeline(TimelineMigrate, "migrate to space %s on game%d", spaceid, spaceGameID)
 	migrateData := e.GetMigrateData(spaceid)
 	data, err := netutil.MSG_PACKER.PackMsg(migrateData, nil)
 	if err != nil {
@@ -1171,9 +1404,9 @@ func (e *Entity) CallFilteredClients(key, op, val string, method string, args ..
 
 // IsUseAOI returns if entity type is using aoi
 //
-// Entities like Account, Service entities should not be using aoi
+// Entities like Account, Service entities should not be using aoi, and entities demoted by simulation LOD are not in aoi
 func (e *Entity) IsUseAOI() bool {
-	return e.typeDesc.useAOI
+	return e.typeDesc.useAOI && e.simLOD == nil
 }
 
 // GetPosition returns the entity position
@@ -1195,6 +1428,9 @@ func (e *Entity) setPositionYaw(pos Vector3, yaw Yaw, fromClient bool) {
 
 	space.move(e, pos)
 	e.yaw = yaw
+	if space.headless {
+		return
+	}
 
 	// mark the entity as needing sync
 	// Real sync packets will be sent before flushing dispatcher Client
@@ -1213,12 +1449,15 @@ func getEntitySyncInfosPacket(gateid uint16) *netutil.Packet {
 		pkt = netutil.NewPacket()
 		pkt.AppendUint16(proto.MT_SYNC_POSITION_YAW_ON_CLIENTS)
 		pkt.AppendUint16(gateid)
+		appendSyncHeader(pkt)
 		entitySyncInfosToGate[gateid] = pkt
 	}
 	return pkt
 }
 
 func CollectEntitySyncInfos() {
+	now := time.Now()
+	beginSyncTick(now)
 	for eid, e := range entityManager.entities {
 		syncInfoFlag := e.syncInfoFlag
 		if syncInfoFlag == 0 {
@@ -1226,7 +1465,11 @@ func CollectEntitySyncInfos() {
 		}
 
 		e.syncInfoFlag = 0
+		if e.posSync != nil {
+			syncInfoFlag = e.filterPositionSync(syncInfoFlag, now)
+		}
 		syncInfo := e.getSyncInfo()
+		var syncBytes uint64
 		if syncInfoFlag&sifSyncOwnClient != 0 && e.client != nil {
 			gateid := e.client.gateid
 			packet := getEntitySyncInfosPacket(gateid)
@@ -1236,6 +1479,10 @@ func CollectEntitySyncInfos() {
 			packet.AppendFloat32(syncInfo.Y)
 			packet.AppendFloat32(syncInfo.Z)
 			packet.AppendFloat32(syncInfo.Yaw)
+			syncBytes += _SYNC_INFO_SIZE_PER_CLIENT
+		}
+		if syncInfoFlag&sifAckInputSeq != 0 && e.client != nil {
+			syncBytes += e.appendInputAck(syncInfo)
 		}
 		if syncInfoFlag&sifSyncNeighborClients != 0 {
 			for neighbor := range e.InterestedBy {
@@ -1249,9 +1496,13 @@ func CollectEntitySyncInfos() {
 					packet.AppendFloat32(syncInfo.Y)
 					packet.AppendFloat32(syncInfo.Z)
 					packet.AppendFloat32(syncInfo.Yaw)
+					syncBytes += _SYNC_INFO_SIZE_PER_CLIENT
 				}
 			}
 		}
+		if e.Space != nil {
+			e.Space.stats.current.clientBytes += syncBytes
+		}
 	}
 
 	// send to dispatcher, one gate by one gate
@@ -1264,6 +1515,8 @@ func CollectEntitySyncInfos() {
 
 		entitySyncInfosToGate = map[uint16]*netutil.Packet{} // clear all packets
 	}
+	flushEntityInputAcks()
+	sendClientSyncVersions()
 }
 
 func (e *Entity) getSyncInfo() proto.EntitySyncInfo {
@@ -1283,6 +1536,9 @@ func (e *Entity) GetYaw() Yaw {
 // SetYaw sets entity Yaw
 func (e *Entity) SetYaw(yaw Yaw) {
 	e.yaw = yaw
+	if e.Space != nil && e.Space.headless {
+		return
+	}
 	e.syncInfoFlag |= (sifSyncNeighborClients | sifSyncOwnClient)
 	//e.ForAllClients(func(Client *GameClient) {
 	//	Client.updateYawOnClient(e.ID, e.Yaw)
@@ -4,6 +4,7 @@ import (
 	"reflect"
 
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/common"
@@ -11,6 +12,8 @@ import (
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/storage"
 	"github.com/xiaonanln/typeconv"
 )
@@ -26,11 +29,20 @@ type EntityTypeDesc struct {
 	IsPersistent    bool
 	useAOI          bool
 	aoiDistance     Coord
+	autoMigrate     bool
 	entityType      reflect.Type
 	rpcDescs        rpcDescMap
 	allClientAttrs  common.StringSet
 	clientAttrs     common.StringSet
 	persistentAttrs common.StringSet
+	sensitiveAttrs  common.StringSet
+	declaredAttrs   common.StringSet
+	saveInterval    time.Duration // 0 for the save interval of the game
+	timelineSize    int           // 0 if timelines are disabled
+	timelinePaths   []string
+	timelineRoots   common.StringSet
+	simLODDistance  Coord         // promote distance of simulation LOD, 0 if not used
+	simLODInterval  time.Duration // interval of calling timers in the low simulation tier
 	//compositiveMethodComponentIndices map[string][]int
 	//definedAttrs                      bool
 }
@@ -41,6 +53,7 @@ func init() {
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Client"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("AllClients"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Persistent"))
+	_VALID_ATTR_DEFS.Add(strings.ToLower("Sensitive"))
 }
 
 func (desc *EntityTypeDesc) SetPersistent(persistent bool) *EntityTypeDesc {
@@ -62,9 +75,21 @@ func (desc *EntityTypeDesc) SetUseAOI(useAOI bool, aoiDistance Coord) *EntityTyp
 	return desc
 }
 
+// SetAutoMigrate sets if entities of this type can be migrated to other games automatically for load balancing
+//
+// Only entities in nil space are migrated, to the nil space of the target game.
+func (desc *EntityTypeDesc) SetAutoMigrate(autoMigrate bool) *EntityTypeDesc {
+	if desc.isService && autoMigrate {
+		gwlog.Panicf("Service entity must NOT be auto-migratable: %s", desc.entityType.Name())
+	}
+
+	desc.autoMigrate = autoMigrate
+	return desc
+}
+
 func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeDesc {
 	gwlog.Infof("        Attr %s = %v", attr, defs)
-	isAllClient, isClient, isPersistent := false, false, false
+	isAllClient, isClient, isPersistent, isSensitive := false, false, false, false
 
 	for _, def := range defs {
 		def := strings.ToLower(def)
@@ -85,9 +110,17 @@ func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeD
 			if !desc.IsPersistent {
 				gwlog.Fatalf("Entity type %s is not persistent, should not define persistent attribute: %s", desc.entityType.Name(), attr)
 			}
+		} else if def == "sensitive" {
+			isSensitive = true
 		}
 	}
 
+	if isSensitive && isClient {
+		// sensitive attributes are kept encrypted, so they can not be synced to clients
+		gwlog.Panicf("attribute %s: Sensitive attribute can not be Client or AllClients", attr)
+	}
+
+	desc.declaredAttrs.Add(attr)
 	if isAllClient {
 		desc.allClientAttrs.Add(attr)
 	}
@@ -97,9 +130,16 @@ func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeD
 	if isPersistent {
 		desc.persistentAttrs.Add(attr)
 	}
+	if isSensitive {
+		desc.sensitiveAttrs.Add(attr)
+	}
 	return desc
 }
 
+var (
+	entityCount = metrics.NewGaugeVec("goworld_entities", "Number of entities on game", "type")
+)
+
 type _EntityManager struct {
 	entities       EntityMap
 	entitiesByType map[string]EntityMap
@@ -121,6 +161,7 @@ func (em *_EntityManager) put(entity *Entity) {
 	} else {
 		em.entitiesByType[etype] = EntityMap{eid: entity}
 	}
+	entityCount.With(etype).Inc()
 }
 
 func (em *_EntityManager) del(e *Entity) {
@@ -129,6 +170,7 @@ func (em *_EntityManager) del(e *Entity) {
 	if entities, ok := em.entitiesByType[e.TypeName]; ok {
 		entities.Del(eid)
 	}
+	entityCount.With(e.TypeName).Dec()
 }
 
 func (em *_EntityManager) get(id common.EntityID) *Entity {
@@ -175,6 +217,8 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 		clientAttrs:     common.StringSet{},
 		allClientAttrs:  common.StringSet{},
 		persistentAttrs: common.StringSet{},
+		sensitiveAttrs:  common.StringSet{},
+		declaredAttrs:   common.StringSet{},
 		//compositiveMethodComponentIndices: map[string][]int{},
 	}
 	registeredEntityTypes[typeName] = entityTypeDesc
@@ -192,6 +236,17 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 	return entityTypeDesc
 }
 
+// GetPersistentEntityTypes returns names of all persistent entity types
+func GetPersistentEntityTypes() []string {
+	var typeNames []string
+	for typeName, desc := range registeredEntityTypes {
+		if desc.IsPersistent {
+			typeNames = append(typeNames, typeName)
+		}
+	}
+	return typeNames
+}
+
 func GetEntityTypeDesc(typeName string) *EntityTypeDesc {
 	return registeredEntityTypes[typeName]
 }
@@ -251,14 +306,24 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 
 	entityManager.put(entity)
 	if data != nil {
+		// the loaded copy owns the epoch claimed by storage.LoadAndClaim, which supersedes all copies saved before
+		entity.ownerEpoch = storage.GetOwnerEpoch(data)
+		if entity.ownerEpoch == 0 {
+			entity.ownerEpoch = 1
+		}
+		delete(data, storage.OWNER_EPOCH_KEY)
 		entity.loadPersistentData(data)
 	} else {
+		entity.ownerEpoch = 1
 		entity.Save() // save immediately after creation
 	}
 
 	isPersistent := entity.IsPersistent()
-	if isPersistent { // startup the periodical timer for saving e
-		entity.setupSaveTimer()
+	if isPersistent { // save e periodically by the save ring
+		entity.scheduleSave()
+		if data != nil {
+			entity.loadPersistentTimers()
+		}
 	}
 
 	dispatchercluster.SendNotifyCreateEntity(entityID)
@@ -296,24 +361,45 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 
 	entityManager.put(entity)
 	entity.loadMigrateData(mdata.Attrs)
+	entity.ownerEpoch = mdata.OwnerEpoch
+	if len(mdata.RPCDedup) > 0 {
+		entity.rpcDedup = proto.RestoreRPCDedup(mdata.RPCDedup)
+	}
+	if !isRestore {
+		// the migrated copy supersedes the copy before migration
+		entity.ownerEpoch += 1
+	}
 
 	timerData := mdata.TimerData
 	if timerData != nil {
 		entity.restoreTimers(timerData)
 	}
+	if err := entity.restorePersistentTimers(mdata.PersistentTimers); err != nil {
+		gwlog.Errorf("%s: restore persistent timers failed: %s", entity, err)
+	}
 
 	isPersistent := entity.IsPersistent()
-	if isPersistent { // startup the periodical timer for saving e
-		entity.setupSaveTimer()
+	if isPersistent { // save e periodically by the save ring
+		entity.scheduleSave()
 	}
 
 	entity.syncInfoFlag = mdata.SyncInfoFlag
 	entity.syncingFromClient = mdata.SyncingFromClient
+	for _, channel := range mdata.Channels {
+		entity.SubscribeChannel(channel)
+	}
+	if len(mdata.Timeline) > 0 {
+		entity.enableTimeline()
+		for _, event := range mdata.Timeline {
+			entity.timeline.add(event)
+		}
+	}
 
 	if mdata.Client != nil {
 		client := MakeGameClient(mdata.Client.ClientID, mdata.Client.GateID)
 		// assign Client to the newly created
 		entity.assignClient(client) // assign Client quietly
+		entity.lastInputSeq = mdata.InputSeq
 	}
 
 	gwlog.Debugf("Entity %s created, Client=%s", entity, entity.client)
@@ -340,8 +426,14 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 
 func loadEntityLocally(typeName string, entityID common.EntityID, space *Space, pos Vector3) {
 	// load the data from storage
-	storage.Load(typeName, entityID, func(_data interface{}, err error) {
+	storage.LoadAndClaim(typeName, entityID, func(_data interface{}, err error) {
 		// callback runs in main routine
+		if errors.Cause(err) == storage.ErrStaleOwnerEpoch {
+			// another game is loading the entity at the same time, and it owns the entity
+			dispatchercluster.SendNotifyDestroyEntity(entityID)
+			gwlog.Errorf("load entity %s.%s cancelled: %s", typeName, entityID, err)
+			return
+		}
 		if err != nil {
 			dispatchercluster.SendNotifyDestroyEntity(entityID) // load entity failed, tell dispatcher
 			gwlog.Panicf("load entity %s.%s failed: %s", typeName, entityID, err)
@@ -363,7 +455,7 @@ func loadEntityLocally(typeName string, entityID common.EntityID, space *Space,
 		entityTypeDesc := registeredEntityTypes[typeName]
 		removeFields := []string{}
 		for k, _ := range data {
-			if !entityTypeDesc.persistentAttrs.Contains(k) {
+			if k != storage.OWNER_EPOCH_KEY && !entityTypeDesc.persistentAttrs.Contains(k) {
 				removeFields = append(removeFields, k)
 			}
 		}
@@ -395,6 +487,11 @@ func CreateEntitySomewhere(gameid uint16, typeName string) common.EntityID {
 	return createEntitySomewhere(gameid, typeName, nil)
 }
 
+// CreateEntitySomewhereWithID creates new entity with specified entity ID in any game
+func CreateEntitySomewhereWithID(gameid uint16, typeName string, entityid common.EntityID) {
+	dispatchercluster.SendCreateEntitySomewhere(gameid, entityid, typeName, nil)
+}
+
 // OnCreateEntitySomewhere is called when CreateEntitySomewhere chooses this game
 func OnCreateEntitySomewhere(entityid common.EntityID, typeName string, data map[string]interface{}) {
 	createEntity(typeName, nil, Vector3{}, entityid, data)
@@ -430,6 +527,26 @@ func OnClientDisconnected(ownerID common.EntityID, clientid common.ClientID) {
 	}
 }
 
+// OnClientResumed is called by engine when Client resumes its session after transient disconnection, with the last
+// sync version sent by the game and delivered to the client (0 if unknown)
+func OnClientResumed(ownerID common.EntityID, clientid common.ClientID, gameid uint16, syncVersion uint64) {
+	owner := entityManager.get(ownerID)
+	if owner != nil {
+		if owner.client != nil && owner.client.clientid == clientid {
+			if gameid != resumeSyncGameID {
+				syncVersion = 0 // versions of other games are not comparable
+			}
+			gwutils.RunPanicless(func() {
+				owner.notifyClientResumed(syncVersion)
+			})
+		} else {
+			gwlog.Warnf("client %s is resumed, but owner entity %s has client %s", clientid, owner, owner.client)
+		}
+	} else {
+		gwlog.Warnf("owner entity %s not found for resumed client %s, might already be destroyed", ownerID, clientid)
+	}
+}
+
 func Call(id common.EntityID, method string, args []interface{}) {
 	if consts.OPTIMIZE_LOCAL_ENTITY_CALL {
 		e := entityManager.get(id)
@@ -459,7 +576,7 @@ func OnCallNilSpaces(method string, args [][]byte) {
 }
 
 func callRemote(id common.EntityID, method string, args []interface{}) {
-	dispatchercluster.SelectByEntityID(id).SendCallEntityMethod(id, method, args)
+	dispatchercluster.SendCallEntityMethod(id, method, args)
 }
 
 var lastWarnedOnCallMethod = ""
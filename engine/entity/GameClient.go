@@ -8,15 +8,17 @@ import (
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
 )
 
 // GameClient represents the game Client of entity
 //
 // Each entity can have at most one GameClient, and GameClient can be given to other entities
 type GameClient struct {
-	clientid common.ClientID
-	gateid   uint16
-	ownerid  common.EntityID
+	clientid  common.ClientID
+	gateid    uint16
+	ownerid   common.EntityID
+	syncState *clientSyncState // entities known by the client, nil if not tracked
 }
 
 // MakeGameClient creates a GameClient object using Client ID and Game ID
@@ -48,66 +50,113 @@ func (client *GameClient) sendCreateEntity(entity *Entity, isPlayer bool) {
 
 	pos := entity.Position
 	yaw := entity.yaw
-	client.selectDispatcher().SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, isPlayer,
-		clientData, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+	client.trackCreated(entity)
+	client.send(func(dc *dispatcherclient.DispatcherClient) {
+		dc.SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, isPlayer,
+			clientData, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+	})
+	releaseAttrMap(clientData) // client data is packed, so it can be reused
 }
 
 func (client *GameClient) sendDestroyEntity(entity *Entity) {
 	if client != nil {
-		client.selectDispatcher().SendDestroyEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID)
+		client.trackDestroyed(entity)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendDestroyEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID)
+		})
 	}
 }
 
 func (client *GameClient) call(entityID common.EntityID, method string, args []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendCallEntityMethodOnClient(client.gateid, client.clientid, entityID, method, args)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendCallEntityMethodOnClient(client.gateid, client.clientid, entityID, method, args)
+		})
 	}
 }
 
+func (client *GameClient) callWithDelivery(entityID common.EntityID, delivery proto.DeliveryClass, method string, args []interface{}) {
+	if client == nil {
+		return
+	}
+
+	if delivery == proto.DELIVERY_ORDERED_RELIABLE {
+		client.call(entityID, method, args)
+		return
+	}
+
+	client.send(func(dc *dispatcherclient.DispatcherClient) {
+		dc.SendCallEntityMethodOnClientWithDelivery(client.gateid, client.clientid, delivery, entityID, method, args)
+	})
+}
+
 // sendNotifyMapAttrChange updates MapAttr change to Client entity
 func (client *GameClient) sendNotifyMapAttrChange(entityID common.EntityID, path []interface{}, key string, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrChangeOnClient(client.gateid, client.clientid, entityID, path, key, val)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrChangeOnClient(client.gateid, client.clientid, entityID, path, key, val)
+		})
 	}
 }
 
 // sendNotifyMapAttrDel updates MapAttr delete to Client entity
 func (client *GameClient) sendNotifyMapAttrDel(entityID common.EntityID, path []interface{}, key string) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrDelOnClient(client.gateid, client.clientid, entityID, path, key)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrDelOnClient(client.gateid, client.clientid, entityID, path, key)
+		})
 	}
 }
 
 func (client *GameClient) sendNotifyMapAttrClear(entityID common.EntityID, path []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrClearOnClient(client.gateid, client.clientid, entityID, path)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrClearOnClient(client.gateid, client.clientid, entityID, path)
+		})
 	}
 }
 
 // sendNotifyListAttrChange notifies Client of ListAttr item changing
 func (client *GameClient) sendNotifyListAttrChange(entityID common.EntityID, path []interface{}, index uint32, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrChangeOnClient(client.gateid, client.clientid, entityID, path, index, val)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrChangeOnClient(client.gateid, client.clientid, entityID, path, index, val)
+		})
 	}
 }
 
 // sendNotifyListAttrPop notify Client of ListAttr popping
 func (client *GameClient) sendNotifyListAttrPop(entityID common.EntityID, path []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrPopOnClient(client.gateid, client.clientid, entityID, path)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrPopOnClient(client.gateid, client.clientid, entityID, path)
+		})
 	}
 }
 
 // sendNotifyListAttrAppend notify entity of ListAttr appending
 func (client *GameClient) sendNotifyListAttrAppend(entityID common.EntityID, path []interface{}, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrAppendOnClient(client.gateid, client.clientid, entityID, path, val)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrAppendOnClient(client.gateid, client.clientid, entityID, path, val)
+		})
+	}
+}
+
+// sendAttrPatch sends the encoded attribute patch of the entity to the client
+func (client *GameClient) sendAttrPatch(entityID common.EntityID, patch []byte) {
+	if client != nil {
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyAttrPatchOnClient(client.gateid, client.clientid, entityID, patch)
+		})
 	}
 }
 
 func (client *GameClient) sendSetClientFilterProp(key, val string) {
 	if client != nil {
-		client.selectDispatcher().SendSetClientFilterProp(client.gateid, client.clientid, key, val)
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendSetClientFilterProp(client.gateid, client.clientid, key, val)
+		})
 	}
 }
 
@@ -119,3 +168,15 @@ func (client *GameClient) selectDispatcher() *dispatcherclient.DispatcherClient
 	}
 	return dispatchercluster.SelectByEntityID(client.ownerid)
 }
+
+// send sends message to the client and attributes sent bytes to the space of the client owner
+func (client *GameClient) send(f func(dc *dispatcherclient.DispatcherClient)) {
+	dc := client.selectDispatcher()
+	sentBytes := dc.SentBytes()
+	f(dc)
+	client.markSyncVersionDirty()
+
+	if owner := entityManager.get(client.ownerid); owner != nil && owner.Space != nil {
+		owner.Space.stats.current.clientBytes += dc.SentBytes() - sentBytes
+	}
+}
@@ -10,8 +10,9 @@ type ISpace interface {
 	OnSpaceCreated() // Called when space is created
 	OnSpaceDestroy() // Called just before space is destroyed
 	// Space Operations
-	OnEntityEnterSpace(entity *Entity) // Called when any entity enters space
-	OnEntityLeaveSpace(entity *Entity) // Called when any entity leaves space
+	OnEntityEnterSpace(entity *Entity)             // Called when any entity enters space
+	OnEntityLeaveSpace(entity *Entity)             // Called when any entity leaves space
+	GetTowerRange() (minX, minY, maxX, maxY Coord) // Called when tower AOI is enabled, override to change the range covered by towers
 	// Game releated callbacks on nil space only
 	OnGameReady()
 }
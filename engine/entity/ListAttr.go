@@ -15,6 +15,8 @@ type ListAttr struct {
 	path   []interface{}
 	flag   attrFlag
 	items  []interface{}
+
+	snapshot []interface{} // snapshot for saving, nil if changed since the last snapshot
 }
 
 func (a *ListAttr) String() string {
@@ -94,6 +96,7 @@ func (a *ListAttr) setOwner(owner *Entity, flag attrFlag) {
 // Set sets item value
 func (a *ListAttr) set(index int, val interface{}) {
 	a.items[index] = val
+	dropSnapshot(a)
 	switch sa := val.(type) {
 	case *MapAttr:
 		// val is MapAttr, set parent and owner accordingly
@@ -223,6 +226,7 @@ func (a *ListAttr) pop() interface{} {
 	size := len(a.items)
 	val := a.items[size-1]
 	a.items = a.items[:size-1]
+	dropSnapshot(a)
 
 	switch sa := val.(type) {
 	case *MapAttr:
@@ -264,6 +268,7 @@ func (a *ListAttr) PopMapAttr() *MapAttr {
 // append puts item to the end of list
 func (a *ListAttr) append(val interface{}) {
 	a.items = append(a.items, val)
+	dropSnapshot(a)
 	index := len(a.items) - 1
 
 	switch sa := val.(type) {
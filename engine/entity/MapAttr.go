@@ -16,6 +16,8 @@ type MapAttr struct {
 	path   []interface{}
 	flag   attrFlag
 	attrs  map[string]interface{}
+
+	snapshot map[string]interface{} // snapshot for saving, nil if changed since the last snapshot
 }
 
 // Size returns the size of MapAttr
@@ -83,6 +85,7 @@ func (a *MapAttr) ForEach(f func(key string, val interface{})) {
 func (a *MapAttr) set(key string, val interface{}) {
 	var flag attrFlag
 	a.attrs[key] = val
+	dropSnapshot(a)
 	switch sa := val.(type) {
 	case *MapAttr:
 		// val is MapAttr, set parent and owner accordingly
@@ -298,6 +301,7 @@ func (a *MapAttr) pop(key string) interface{} {
 	}
 
 	delete(a.attrs, key)
+	dropSnapshot(a)
 	switch sa := val.(type) {
 	case *MapAttr:
 		sa.removeFromParent()
@@ -382,6 +386,7 @@ func (a *MapAttr) Clear() {
 
 	var curattrs map[string]interface{}
 	curattrs, a.attrs = a.attrs, map[string]interface{}{}
+	dropSnapshot(a)
 	for _, v := range curattrs {
 		switch sa := v.(type) {
 		case *MapAttr:
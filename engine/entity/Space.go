@@ -2,18 +2,24 @@ package entity
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/xiaonanln/go-aoi"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
 )
 
 const (
 	_SPACE_ENTITY_TYPE    = "__space__"
 	_SPACE_KIND_ATTR_KEY  = "_K"
 	_SPACE_ENABLE_AOI_KEY = "_EnableAOI"
+	_SPACE_AOI_IMPL_KEY   = "_AOIImpl"
+	_SPACE_AOI_CELL_KEY   = "_AOICell"
+	_SPACE_HEADLESS_KEY   = "_Headless"
 )
 
 var (
@@ -29,8 +35,16 @@ type Space struct {
 	entities EntitySet
 	Kind     int
 	I        ISpace
+	headless bool
 
-	aoiMgr aoi.AOIManager
+	aoiMgr            aoi.AOIManager
+	aoiDistance       Coord
+	customAOIEntities EntitySet // entities with AOI distances set by SetAOIDistance
+	stats             spaceStatsCollector
+	subs              map[*Entity]map[string]*spaceSubscription // subscriptions of subscribers
+
+	destroyCheckTimer *timerwheel.Timer
+	emptySince        time.Time // when the space becomes empty, for destroy policy
 }
 
 func (space *Space) String() string {
@@ -53,6 +67,7 @@ func (space *Space) GetSpaceRange() (minX, minY, maxX, maxY Coord) {
 	return -1000, -1000, 1000, 1000
 }
 
+// GetTowerRange returns the range covered by towers of tower AOI, entities out of the range are in towers on the edges
 func (space *Space) GetTowerRange() (minX, minY, maxX, maxY Coord) {
 	return -1000, -1000, 1000, 1000
 }
@@ -61,6 +76,7 @@ func (space *Space) GetTowerRange() (minX, minY, maxX, maxY Coord) {
 func (space *Space) OnInit() {
 	space.entities = EntitySet{}
 	space.I = space.Entity.I.(ISpace)
+	space.stats.windowStart = time.Now()
 
 	space.I.OnSpaceInit()
 }
@@ -86,33 +102,37 @@ func (space *Space) OnCreated() {
 		gwlog.Debugf("%s.OnCreated", space)
 	}
 	space.I.OnSpaceCreated()
+	space.instantiateTemplate()
 }
 
+// EnableAOI enables AOI of the space using the default AOI implementation of the game
+//
+// It should be called in OnSpaceCreated before any entity enters.
 func (space *Space) EnableAOI(defaultAOIDistance Coord) {
-	if defaultAOIDistance <= 0 {
-		gwlog.Panicf("defaultAOIDistance < 0")
-	}
+	space.enableAOI(defaultAOIDistance, defaultAOIImplementation, defaultAOITowerCellSize)
+}
 
+// EnableHeadless makes the space headless for server side simulations (e.g. economy ticks, territory wars resolution)
+//
+// Entities in headless spaces are not synced to clients: the space is not created on clients, and positions & yaws are
+// not synced. Headless spaces can not enable AOI. It should be called in OnSpaceCreated before any entity enters.
+func (space *Space) EnableHeadless() {
 	if space.aoiMgr != nil {
-		gwlog.Panicf("%s.EnableAOI: AOI already enabled", space)
+		gwlog.Panicf("%s.EnableHeadless: AOI is enabled", space)
 	}
 
 	if len(space.entities) > 0 {
-		gwlog.Panicf("%s is already using AOI", space)
+		gwlog.Panicf("%s.EnableHeadless: space is not empty", space)
 	}
 
-	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
-	space.aoiMgr = aoi.NewXZListAOIManager(aoi.Coord(defaultAOIDistance))
-	//space.aoiMgr = aoi.NewTowerAOIManager(-500, 500, -500, 500, 10)
+	space.Attrs.SetBool(_SPACE_HEADLESS_KEY, true)
+	space.headless = true
 }
 
-//func (space *Space) UseTowerAOI(minX, maxX, minY, maxY Coord, towerRange Coord) {
-//	if space.aoiMgr != nil || len(space.entities) > 0 {
-//		gwlog.Panicf("%s is already using AOI", space)
-//	}
-//
-//	space.aoiMgr = aoi.NewTowerAOIManager(aoi.Coord(minX), aoi.Coord(maxX), aoi.Coord(minY), aoi.Coord(maxY), aoi.Coord(towerRange))
-//}
+// IsHeadless returns if the space is headless
+func (space *Space) IsHeadless() bool {
+	return space.headless
+}
 
 // OnRestored is called when space entity is restored
 func (space *Space) OnRestored() {
@@ -120,8 +140,14 @@ func (space *Space) OnRestored() {
 	//gwlog.Debugf("space %s restored: atts=%+v", space, space.Attrs)
 	aoidist := space.GetFloat(_SPACE_ENABLE_AOI_KEY)
 	if aoidist > 0 {
-		space.EnableAOI(Coord(aoidist))
+		impl := space.GetStr(_SPACE_AOI_IMPL_KEY)
+		if impl == "" { // spaces saved before AOI implementations are selectable
+			impl = AOIXZList
+		}
+		space.enableAOI(Coord(aoidist), impl, Coord(space.GetFloat(_SPACE_AOI_CELL_KEY)))
 	}
+	space.headless = space.GetBool(_SPACE_HEADLESS_KEY)
+	space.setupDestroyCheck()
 }
 
 func (space *Space) onSpaceCreated() {
@@ -150,7 +176,8 @@ func (space *Space) OnSpaceCreated() {
 
 // OnDestroy is called when Space entity is destroyed
 func (space *Space) OnDestroy() {
-	space.I.OnSpaceDestroy()
+	// entities are always destroyed even if OnSpaceDestroy panics
+	gwutils.RunPanicless(space.I.OnSpaceDestroy)
 	// destroy all entities
 	for e := range space.entities {
 		e.Destroy()
@@ -202,13 +229,18 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	space.entities.Add(entity)
 	entity.Position = pos
 
-	entity.syncInfoFlag |= sifSyncOwnClient | sifSyncNeighborClients
+	if !space.headless {
+		entity.syncInfoFlag |= sifSyncOwnClient | sifSyncNeighborClients
+	}
 
 	if !isRestore {
-		entity.client.sendCreateEntity(&space.Entity, false) // create Space entity before every other entities
+		entity.recordTimeline(TimelineSpace, "enter space %s (kind %d) at %s", space.ID, space.Kind, pos)
+		if !space.headless {
+			entity.client.sendCreateEntity(&space.Entity, false) // create Space entity before every other entities
+		}
 
 		if space.aoiMgr != nil && entity.IsUseAOI() {
-			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
+			space.enterAOI(entity)
 		}
 
 		gwutils.RunPanicless(func() {
@@ -218,7 +250,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	} else {
 		// restoring ...
 		if space.aoiMgr != nil && entity.IsUseAOI() {
-			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
+			space.enterAOI(entity)
 		}
 
 	}
@@ -238,12 +270,19 @@ func (space *Space) leave(entity *Entity) {
 	// remove from Space entities
 	space.entities.Del(entity)
 	entity.Space = nilSpace
+	entity.recordTimeline(TimelineSpace, "leave space %s (kind %d) at %s", space.ID, space.Kind, entity.Position)
+	space.unsubscribeAll(entity)
 
 	if space.aoiMgr != nil && entity.IsUseAOI() {
-		space.aoiMgr.Leave(&entity.aoi)
+		space.leaveAOI(entity)
+	}
+	if entity.simLOD != nil {
+		entity.promoteSimulation() // simulation LOD only applies in spaces
 	}
 
-	entity.client.sendDestroyEntity(&space.Entity)
+	if !space.headless {
+		entity.client.sendDestroyEntity(&space.Entity)
+	}
 	gwutils.RunPanicless(func() {
 		space.I.OnEntityLeaveSpace(entity)
 		entity.I.OnLeaveSpace(space)
@@ -251,12 +290,13 @@ func (space *Space) leave(entity *Entity) {
 }
 
 func (space *Space) move(entity *Entity, newPos Vector3) {
-	if space.aoiMgr == nil {
+	entity.Position = newPos
+	if space.aoiMgr == nil || !entity.IsUseAOI() {
 		return
 	}
 
-	entity.Position = newPos
 	space.aoiMgr.Moved(&entity.aoi, aoi.Coord(newPos.X), aoi.Coord(newPos.Z))
+	space.updateCustomAOI(entity)
 	gwlog.Debugf("%s: %s move to %v", space, entity, newPos)
 }
 
@@ -301,6 +341,37 @@ func (space *Space) ForEachEntity(f func(e *Entity)) {
 	}
 }
 
+// ForEachEntityInRadius visits all entities within radius of pos in space, distances are measured on the XZ plane as AOI
+func (space *Space) ForEachEntityInRadius(pos Vector3, radius Coord, f func(e *Entity)) {
+	radiusSqr := radius * radius
+	for e := range space.entities {
+		dx, dz := e.Position.X-pos.X, e.Position.Z-pos.Z
+		if dx*dx+dz*dz <= radiusSqr {
+			f(e)
+		}
+	}
+}
+
+// BroadcastInRadius calls the method on clients of all client-bound entities within radius of pos (e.g. for explosions and emotes)
+//
+// The method is called on the client's own entity of each client. Returns the count of clients called.
+func (space *Space) BroadcastInRadius(pos Vector3, radius Coord, method string, args ...interface{}) int {
+	return space.BroadcastInRadiusWithDelivery(pos, radius, proto.DELIVERY_ORDERED_RELIABLE, method, args...)
+}
+
+// BroadcastInRadiusWithDelivery is BroadcastInRadius with the specified delivery class
+func (space *Space) BroadcastInRadiusWithDelivery(pos Vector3, radius Coord, delivery proto.DeliveryClass, method string, args ...interface{}) int {
+	count := 0
+	space.ForEachEntityInRadius(pos, radius, func(e *Entity) {
+		if e.client == nil {
+			return
+		}
+		e.client.callWithDelivery(e.ID, delivery, method, args)
+		count += 1
+	})
+	return count
+}
+
 // GetEntity returns the entity in space with specified ID, nil otherwise
 func (space *Space) GetEntity(entityID common.EntityID) *Entity {
 	entity := GetEntity(entityID)
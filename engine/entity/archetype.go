@@ -0,0 +1,219 @@
+package entity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Archetypes
+//
+// Archetypes are immutable template data shared by entities and items, such as NPC stat blocks and item definitions.
+// Instead of copying template data into the attributes of every entity, an entity only keeps the name of its archetype
+// in the ARCHETYPE_ATTR_KEY attribute, and reads the shared data by GetArchetype. Items in attributes can keep the
+// names of their definitions and look them up by GetArchetype(name) as well. An archetype can be based on another
+// archetype, and inherits the data which it does not declare.
+
+const (
+	// ARCHETYPE_ATTR_KEY is the attribute keeping the archetype name of entities of types using archetypes
+	ARCHETYPE_ATTR_KEY = "_Archetype"
+)
+
+var (
+	registeredArchetypes = map[string]*Archetype{}
+)
+
+// Archetype is the immutable template data shared by entities and items
+//
+// Data of archetypes are shared, so maps and lists returned by archetypes should never be modified.
+type Archetype struct {
+	Name string                 `json:"-"`
+	Base string                 `json:"base"` // name of the archetype whose data are inherited
+	Data map[string]interface{} `json:"data"`
+
+	base *Archetype
+}
+
+// RegisterArchetype registers the archetype of the name, archetypes should be registered on all games before entities
+// are created
+func RegisterArchetype(name string, archetype *Archetype) {
+	if _, ok := registeredArchetypes[name]; ok {
+		gwlog.Fatalf("RegisterArchetype: archetype %s already registered", name)
+	}
+	if archetype.Data == nil {
+		archetype.Data = map[string]interface{}{}
+	}
+	archetype.Name = name
+	registeredArchetypes[name] = archetype
+}
+
+// LoadArchetypes registers archetypes from the JSON file, which is an object of archetype names to archetypes
+func LoadArchetypes(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "read archetypes failed")
+	}
+
+	var archetypes map[string]*Archetype
+	if err := json.Unmarshal(content, &archetypes); err != nil {
+		return errors.Wrapf(err, "parse archetypes failed: %s", file)
+	}
+	for name, archetype := range archetypes {
+		RegisterArchetype(name, archetype)
+	}
+	for name := range archetypes {
+		if err := checkArchetypeBase(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkArchetypeBase checks that bases of the archetype are registered without cycles
+func checkArchetypeBase(name string) error {
+	visited := map[string]bool{}
+	for name != "" {
+		if visited[name] {
+			return errors.Errorf("archetype %s is based on itself", name)
+		}
+		visited[name] = true
+		archetype := registeredArchetypes[name]
+		if archetype == nil {
+			return errors.Errorf("archetype %s is not registered", name)
+		}
+		name = archetype.Base
+	}
+	return nil
+}
+
+// GetArchetype returns the registered archetype of the name, or nil if the archetype is not registered
+func GetArchetype(name string) *Archetype {
+	return registeredArchetypes[name]
+}
+
+// ListArchetypes returns names of all registered archetypes in order
+func ListArchetypes() []string {
+	names := make([]string, 0, len(registeredArchetypes))
+	for name := range registeredArchetypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *Archetype) getBase() *Archetype {
+	if a.base == nil && a.Base != "" {
+		if err := checkArchetypeBase(a.Name); err != nil {
+			gwlog.Panicf("archetype %s: %s", a.Name, err)
+		}
+		a.base = registeredArchetypes[a.Base]
+	}
+	return a.base
+}
+
+// Get returns the value of the key declared by the archetype or its bases
+func (a *Archetype) Get(key string) (interface{}, bool) {
+	for ; a != nil; a = a.getBase() {
+		if val, ok := a.Data[key]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// Has checks if the key is declared by the archetype or its bases
+func (a *Archetype) Has(key string) bool {
+	_, ok := a.Get(key)
+	return ok
+}
+
+// GetInt returns the value of the key as int64, 0 if not declared
+func (a *Archetype) GetInt(key string) int64 {
+	val, _ := a.Get(key)
+	switch v := val.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case float32:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// GetFloat returns the value of the key as float64, 0 if not declared
+func (a *Archetype) GetFloat(key string) float64 {
+	val, _ := a.Get(key)
+	switch v := val.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case int:
+		return float64(v)
+	default:
+		return 0
+	}
+}
+
+// GetStr returns the value of the key as string, empty if not declared
+func (a *Archetype) GetStr(key string) string {
+	val, _ := a.Get(key)
+	s, _ := val.(string)
+	return s
+}
+
+// GetBool returns the value of the key as bool, false if not declared
+func (a *Archetype) GetBool(key string) bool {
+	val, _ := a.Get(key)
+	b, _ := val.(bool)
+	return b
+}
+
+// GetMap returns the value of the key as map, which is shared and should not be modified
+func (a *Archetype) GetMap(key string) map[string]interface{} {
+	val, _ := a.Get(key)
+	m, _ := val.(map[string]interface{})
+	return m
+}
+
+// GetList returns the value of the key as list, which is shared and should not be modified
+func (a *Archetype) GetList(key string) []interface{} {
+	val, _ := a.Get(key)
+	l, _ := val.([]interface{})
+	return l
+}
+
+// UseArchetype declares the ARCHETYPE_ATTR_KEY attribute of entities of this type with attribute definitions, e.g.
+// "AllClients" so that clients can render entities by their archetypes, or "Persistent" to save archetypes
+func (desc *EntityTypeDesc) UseArchetype(defs ...string) *EntityTypeDesc {
+	return desc.DefineAttr(ARCHETYPE_ATTR_KEY, defs...)
+}
+
+// SetArchetype sets the archetype of the entity, the archetype should be registered
+func (e *Entity) SetArchetype(name string) {
+	if !e.typeDesc.declaredAttrs.Contains(ARCHETYPE_ATTR_KEY) {
+		gwlog.Panicf("%s.SetArchetype: entity type does not use archetypes", e)
+	}
+	if GetArchetype(name) == nil {
+		gwlog.Panicf("%s.SetArchetype: archetype %s is not registered", e, name)
+	}
+	e.Attrs.SetStr(ARCHETYPE_ATTR_KEY, name)
+}
+
+// GetArchetype returns the archetype of the entity, or nil if the entity has no archetype
+func (e *Entity) GetArchetype() *Archetype {
+	name, ok := e.Attrs.attrs[ARCHETYPE_ATTR_KEY].(string)
+	if !ok {
+		return nil
+	}
+	return registeredArchetypes[name]
+}
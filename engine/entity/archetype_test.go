@@ -0,0 +1,64 @@
+package entity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type TestArchetypeEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestArchetypeEntity", &TestArchetypeEntity{}, false)
+}
+
+func (e *TestArchetypeEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.UseArchetype("AllClients")
+	desc.DefineAttr("hp", "AllClients")
+}
+
+func TestArchetypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archetype")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "archetypes.json")
+	ioutil.WriteFile(file, []byte(`{
+		"goblin_test": {"data": {"hp": 100, "speed": 1.5, "name": "Goblin", "drops": ["gold"]}},
+		"elite_goblin_test": {"base": "goblin_test", "data": {"hp": 300, "elite": true}}}`), 0644)
+	if err := LoadArchetypes(file); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		delete(registeredArchetypes, "goblin_test")
+		delete(registeredArchetypes, "elite_goblin_test")
+	}()
+
+	elite := GetArchetype("elite_goblin_test")
+	if elite.GetInt("hp") != 300 || elite.GetFloat("speed") != 1.5 || elite.GetStr("name") != "Goblin" || !elite.GetBool("elite") {
+		t.Errorf("wrong data of archetype: %+v", elite)
+	}
+	if len(elite.GetList("drops")) != 1 || elite.Has("armor") || GetArchetype("goblin_test").GetBool("elite") {
+		t.Errorf("wrong inherited data of archetype: %+v", elite)
+	}
+
+	e := CreateEntityLocally("TestArchetypeEntity", nil)
+	if e.GetArchetype() != nil {
+		t.Fatalf("entity should have no archetype")
+	}
+	e.SetArchetype("elite_goblin_test")
+	if e.GetArchetype() != elite || e.Attrs.Size() != 1 {
+		t.Errorf("entity should only keep the name of its archetype: %v", e.Attrs.ToMap())
+	}
+
+	RegisterArchetype("loop_test", &Archetype{Base: "loop_test"})
+	defer delete(registeredArchetypes, "loop_test")
+	if err := checkArchetypeBase("loop_test"); err == nil {
+		t.Errorf("archetype based on itself should be found")
+	}
+}
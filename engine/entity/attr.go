@@ -7,6 +7,7 @@ type attrFlag int
 const (
 	afClient attrFlag = 1 << iota
 	afAllClient
+	afPersistent
 )
 
 func getPathFromOwner(a interface{}, path []interface{}) []interface{} {
@@ -0,0 +1,158 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Attribute patch sync
+//
+// If attribute patch sync is enabled, attribute changes to clients are recorded by entities, coalesced and sent to each
+// client in one MT_NOTIFY_ATTR_PATCH_ON_CLIENT message when entity sync infos are collected. Later changes supersede
+// earlier changes of the same attribute and its children. Pending changes of an entity are also flushed before the
+// entity is created on other clients, calls its clients or changes its client, so clients always see changes in order.
+
+var (
+	attrPatchSync      bool
+	attrPatchPrecision float32
+	attrPatchEntities  = EntitySet{} // entities with pending attribute changes
+)
+
+type attrPatchOp struct {
+	proto.AttrPatchOp
+	allClients bool // the change should be sent to clients of neighbors as well
+}
+
+// SetAttrPatchSync enables or disables attribute patch sync
+//
+// floatPrecision is used to quantize floats in patches, 0 means no quantization.
+func SetAttrPatchSync(enabled bool, floatPrecision float32) {
+	if !enabled {
+		FlushAttrPatches()
+	}
+	attrPatchSync = enabled
+	attrPatchPrecision = floatPrecision
+}
+
+// FlushAttrPatches sends pending attribute changes of all entities to clients
+func FlushAttrPatches() {
+	for e := range attrPatchEntities {
+		e.flushAttrPatch()
+	}
+}
+
+// patchAttr records the attribute change if attribute patch sync is enabled, and returns false otherwise
+func (e *Entity) patchAttr(flag attrFlag, op proto.AttrPatchOp) bool {
+	if !attrPatchSync {
+		return false
+	}
+
+	allClients := flag&afAllClient != 0
+	if !allClients && flag&afClient == 0 {
+		return true
+	}
+	if e.client == nil && (!allClients || len(e.InterestedBy) == 0) {
+		return true // nobody to send to
+	}
+
+	switch op.Op {
+	case proto.ATTR_PATCH_MAP_CHANGE, proto.ATTR_PATCH_MAP_DEL:
+		e.removeAttrPatchOps(op.Path, op.Key)
+	case proto.ATTR_PATCH_LIST_CHANGE:
+		e.removeAttrPatchOps(op.Path, int(op.Index))
+	case proto.ATTR_PATCH_MAP_CLEAR:
+		e.removeAttrPatchOps(op.Path, nil)
+	}
+	e.attrPatch = append(e.attrPatch, attrPatchOp{op, allClients})
+	attrPatchEntities.Add(e)
+	return true
+}
+
+// removeAttrPatchOps removes pending changes superseded by a change of the key at the path, or by clearing the path if
+// key is nil
+func (e *Entity) removeAttrPatchOps(path []interface{}, key interface{}) {
+	ops := e.attrPatch[:0]
+	for _, op := range e.attrPatch {
+		if !isAttrPatchOpSuperseded(&op.AttrPatchOp, path, key) {
+			ops = append(ops, op)
+		}
+	}
+	for i := len(ops); i < len(e.attrPatch); i++ {
+		e.attrPatch[i] = attrPatchOp{}
+	}
+	e.attrPatch = ops
+}
+
+func isAttrPatchOpSuperseded(op *proto.AttrPatchOp, path []interface{}, key interface{}) bool {
+	// paths are from the attribute to the root, so the path of the changed attribute is [key, path...]
+	if key == nil {
+		return isAttrPathUnder(op.Path, path, 0)
+	}
+	if isAttrPathUnder(op.Path, path, 1) && op.Path[len(op.Path)-len(path)-1] == key {
+		return true // change of the changed attribute or its children
+	}
+	if !isAttrPathUnder(op.Path, path, 0) || len(op.Path) != len(path) {
+		return false
+	}
+	switch op.Op {
+	case proto.ATTR_PATCH_MAP_CHANGE, proto.ATTR_PATCH_MAP_DEL:
+		return op.Key == key
+	case proto.ATTR_PATCH_LIST_CHANGE:
+		return int(op.Index) == key
+	default:
+		return false
+	}
+}
+
+// isAttrPathUnder checks if subPath is at least depth levels under path
+func isAttrPathUnder(subPath []interface{}, path []interface{}, depth int) bool {
+	offset := len(subPath) - len(path)
+	if offset < depth {
+		return false
+	}
+	for i, item := range path {
+		if subPath[offset+i] != item {
+			return false
+		}
+	}
+	return true
+}
+
+// flushAttrPatch sends pending attribute changes of the entity to clients
+func (e *Entity) flushAttrPatch() {
+	if len(e.attrPatch) == 0 {
+		return
+	}
+
+	ops := e.attrPatch
+	e.attrPatch = nil
+	attrPatchEntities.Del(e)
+
+	patch := proto.AttrPatch{FloatPrecision: attrPatchPrecision, Ops: make([]proto.AttrPatchOp, 0, len(ops))}
+	ownClientOnly := false
+	for _, op := range ops {
+		patch.Ops = append(patch.Ops, op.AttrPatchOp)
+		ownClientOnly = ownClientOnly || !op.allClients
+	}
+	if e.client != nil {
+		e.client.sendAttrPatch(e.ID, patch.Encode(nil))
+	}
+
+	if len(e.InterestedBy) == 0 {
+		return
+	}
+	if ownClientOnly {
+		patch.Ops = patch.Ops[:0]
+		for _, op := range ops {
+			if op.allClients {
+				patch.Ops = append(patch.Ops, op.AttrPatchOp)
+			}
+		}
+		if len(patch.Ops) == 0 {
+			return
+		}
+	}
+	data := patch.Encode(nil)
+	for neighbor := range e.InterestedBy {
+		neighbor.client.sendAttrPatch(e.ID, data)
+	}
+}
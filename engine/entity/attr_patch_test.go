@@ -0,0 +1,66 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type TestAttrPatchEntity struct {
+	Entity
+}
+
+func (e *TestAttrPatchEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("hp", "AllClients")
+	desc.DefineAttr("bag", "Client")
+	desc.DefineAttr("items", "Client")
+	desc.DefineAttr("temp")
+}
+
+func TestAttrPatchCoalescing(t *testing.T) {
+	RegisterEntity("TestAttrPatchEntity", &TestAttrPatchEntity{}, false)
+	e := CreateEntityLocally("TestAttrPatchEntity", nil)
+	e.Attrs.SetMapAttr("bag", NewMapAttr())
+	e.Attrs.SetListAttr("items", NewListAttr())
+
+	SetAttrPatchSync(true, 0)
+	e.client = &GameClient{}
+	defer func() {
+		e.client = nil
+		e.attrPatch = nil
+		attrPatchEntities.Del(e)
+		SetAttrPatchSync(false, 0)
+	}()
+
+	e.Attrs.SetInt("hp", 1)
+	e.Attrs.SetInt("hp", 2)
+	e.Attrs.SetInt("temp", 1)
+	bag := e.GetMapAttr("bag")
+	bag.SetInt("gold", 1)
+	bag.SetMapAttr("weapon", NewMapAttr())
+	bag.GetMapAttr("weapon").SetInt("level", 1)
+	bag.SetMapAttr("weapon", NewMapAttr())
+	items := e.GetListAttr("items")
+	items.AppendMapAttr(NewMapAttr())
+	items.GetMapAttr(0).SetInt("count", 1)
+	items.SetMapAttr(0, NewMapAttr())
+	bag.SetInt("silver", 1)
+	bag.Clear()
+
+	var ops []string
+	for _, op := range e.attrPatch {
+		ops = append(ops, fmt.Sprintf("%d %v %s %d %v %v", op.Op, op.Path, op.Key, op.Index, op.Val, op.allClients))
+	}
+	expected := []string{
+		"0 [] hp 0 2 true",         // hp changed twice
+		"5 [items]  0 map[] false", // appended item is not superseded
+		"3 [items]  0 map[] false", // change of the item is superseded by setting the item
+		"2 [bag]  0 <nil> false",   // all changes of bag are superseded by clearing
+	}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatalf("wrong coalesced changes: %q, expected %q", ops, expected)
+	}
+	if !attrPatchEntities.Contains(e) {
+		t.Fatalf("entity should have pending changes")
+	}
+}
@@ -0,0 +1,117 @@
+package entity
+
+import "sync"
+
+// Pooled attribute trees
+//
+// Converting attributes to native maps and lists allocates a whole tree for each conversion, which is a major source
+// of GC pressure when entities are created on clients. Trees converted by toMapPooled and toListPooled are made of
+// pooled maps and lists, and should be released by releaseAttrMap and releaseAttrList once they are packed.
+//
+// Only trees which are packed synchronously can be released. Trees held after the conversion, such as attribute
+// changes batched by attr patches or persistent data saved by storage, should be converted by ToMap and ToList.
+
+const (
+	_MAX_POOLED_ATTR_MAP_SIZE  = 256 // larger maps are not pooled because maps never shrink
+	_MAX_POOLED_ATTR_LIST_SIZE = 256
+)
+
+var (
+	attrMapPool = sync.Pool{
+		New: func() interface{} {
+			return map[string]interface{}{}
+		},
+	}
+	attrListPool = sync.Pool{ // holders of pooled lists
+		New: func() interface{} {
+			return &[]interface{}{}
+		},
+	}
+	attrListHolderPool = sync.Pool{ // empty holders, so that releasing lists does not allocate holders
+		New: func() interface{} {
+			return &[]interface{}{}
+		},
+	}
+)
+
+// toMapPooled converts MapAttr to pooled native map, recursively
+func (a *MapAttr) toMapPooled() map[string]interface{} {
+	return a.toMapWithFilterPooled(nil)
+}
+
+// toMapWithFilterPooled converts filtered fields of MapAttr to pooled native map, recursively
+func (a *MapAttr) toMapWithFilterPooled(filter func(string) bool) map[string]interface{} {
+	doc := attrMapPool.Get().(map[string]interface{})
+	for k, v := range a.attrs {
+		if filter != nil && !filter(k) {
+			continue
+		}
+
+		switch a := v.(type) {
+		case *MapAttr:
+			doc[k] = a.toMapPooled()
+		case *ListAttr:
+			doc[k] = a.toListPooled()
+		default:
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// toListPooled converts ListAttr to pooled slice, recursively
+func (a *ListAttr) toListPooled() []interface{} {
+	lp := attrListPool.Get().(*[]interface{})
+	l := (*lp)[:0]
+	*lp = nil
+	attrListHolderPool.Put(lp)
+	if l == nil || cap(l) < len(a.items) {
+		l = make([]interface{}, 0, len(a.items)) // never nil, so that empty lists are packed as empty arrays
+	}
+
+	for _, v := range a.items {
+		switch a := v.(type) {
+		case *MapAttr:
+			l = append(l, a.toMapPooled())
+		case *ListAttr:
+			l = append(l, a.toListPooled())
+		default:
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// releaseAttrMap releases the map converted by toMapPooled or toMapWithFilterPooled, recursively
+func releaseAttrMap(doc map[string]interface{}) {
+	size := len(doc)
+	for k, v := range doc {
+		releaseAttrValue(v)
+		delete(doc, k)
+	}
+	if size <= _MAX_POOLED_ATTR_MAP_SIZE {
+		attrMapPool.Put(doc)
+	}
+}
+
+// releaseAttrList releases the list converted by toListPooled, recursively
+func releaseAttrList(l []interface{}) {
+	for i, v := range l {
+		releaseAttrValue(v)
+		l[i] = nil
+	}
+	if cap(l) <= _MAX_POOLED_ATTR_LIST_SIZE {
+		lp := attrListHolderPool.Get().(*[]interface{})
+		*lp = l[:0]
+		attrListPool.Put(lp)
+	}
+}
+
+func releaseAttrValue(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		releaseAttrMap(v)
+	case []interface{}:
+		releaseAttrList(v)
+	}
+}
@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func makeTestPoolAttrs() *MapAttr {
+	attrs := NewMapAttr()
+	attrs.SetStr("name", "player")
+	attrs.SetInt("level", 60)
+	attrs.SetFloat("hp", 1000.5)
+	attrs.SetListAttr("empty", NewListAttr())
+
+	bag := NewListAttr()
+	for i := 0; i < 50; i++ {
+		item := NewMapAttr()
+		item.SetStr("id", fmt.Sprintf("item%d", i))
+		item.SetInt("count", int64(i))
+		bag.AppendMapAttr(item)
+	}
+	attrs.SetListAttr("bag", bag)
+
+	skills := NewMapAttr()
+	for i := 0; i < 20; i++ {
+		skills.SetInt(fmt.Sprintf("skill%d", i), int64(i))
+	}
+	attrs.SetMapAttr("skills", skills)
+	return attrs
+}
+
+func TestToMapPooled(t *testing.T) {
+	attrs := makeTestPoolAttrs()
+	for i := 0; i < 3; i++ { // pooled maps and lists are reused from the second round
+		doc := attrs.toMapPooled()
+		if !reflect.DeepEqual(doc, attrs.ToMap()) {
+			t.Fatalf("pooled map is different: %v", doc)
+		}
+		if doc["empty"].([]interface{}) == nil {
+			t.Fatalf("empty list is converted to nil")
+		}
+		releaseAttrMap(doc)
+		if len(doc) != 0 {
+			t.Fatalf("released map is not cleared: %v", doc)
+		}
+	}
+
+	filtered := attrs.toMapWithFilterPooled(func(key string) bool { return key == "level" })
+	if !reflect.DeepEqual(filtered, map[string]interface{}{"level": int64(60)}) {
+		t.Errorf("wrong filtered map: %v", filtered)
+	}
+	releaseAttrMap(filtered)
+}
+
+func BenchmarkPackAttrs(b *testing.B) {
+	attrs := makeTestPoolAttrs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := netutil.MSG_PACKER.PackMsg(attrs.ToMap(), nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPackAttrsPooled(b *testing.B) {
+	attrs := makeTestPoolAttrs()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		doc := attrs.toMapPooled()
+		if _, err := netutil.MSG_PACKER.PackMsg(doc, nil); err != nil {
+			b.Fatal(err)
+		}
+		releaseAttrMap(doc)
+	}
+}
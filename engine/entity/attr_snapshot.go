@@ -0,0 +1,83 @@
+package entity
+
+// Copy-on-write snapshots of attributes
+//
+// Saving an entity converts its persistent attributes to native maps and lists, which are encoded by the storage
+// routine. Converting the whole attribute tree blocks the game routine for long when entities have large attributes,
+// such as members of guilds or items of auctions. Instead, each MapAttr and ListAttr keeps the snapshot converted by
+// the last save, which is shared by following saves until the attribute is changed. Changing an attribute drops
+// snapshots of the attribute and all its ancestors, so saves only convert changed attributes and their ancestors.
+//
+// Snapshots are never modified once converted, so the storage routine can encode them while the game routine keeps
+// changing attributes. If the snapshot of an attribute is dropped, snapshots of all its ancestors are dropped too.
+
+// toSnapshot returns the snapshot of MapAttr, which is converted if the attribute is changed since the last snapshot
+func (a *MapAttr) toSnapshot() map[string]interface{} {
+	if a.snapshot == nil {
+		a.snapshot = a.toSnapshotWithFilter(nil)
+	}
+	return a.snapshot
+}
+
+// toSnapshotWithFilter converts filtered fields of MapAttr to a new map, which shares snapshots of sub-attributes
+func (a *MapAttr) toSnapshotWithFilter(filter func(string) bool) map[string]interface{} {
+	doc := make(map[string]interface{}, len(a.attrs))
+	for k, v := range a.attrs {
+		if filter != nil && !filter(k) {
+			continue
+		}
+
+		switch a := v.(type) {
+		case *MapAttr:
+			doc[k] = a.toSnapshot()
+		case *ListAttr:
+			doc[k] = a.toSnapshot()
+		default:
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// toSnapshot returns the snapshot of ListAttr, which is converted if the attribute is changed since the last snapshot
+func (a *ListAttr) toSnapshot() []interface{} {
+	if a.snapshot != nil {
+		return a.snapshot
+	}
+
+	l := make([]interface{}, len(a.items))
+	for i, v := range a.items {
+		switch a := v.(type) {
+		case *MapAttr:
+			l[i] = a.toSnapshot()
+		case *ListAttr:
+			l[i] = a.toSnapshot()
+		default:
+			l[i] = v
+		}
+	}
+	a.snapshot = l
+	return l
+}
+
+// dropSnapshot drops snapshots of the attribute and its ancestors, called when the attribute is changed
+func dropSnapshot(attr interface{}) {
+	for {
+		switch a := attr.(type) {
+		case *MapAttr:
+			if a.snapshot == nil {
+				return // ancestors have no snapshot either
+			}
+			a.snapshot = nil
+			attr = a.parent
+		case *ListAttr:
+			if a.snapshot == nil {
+				return
+			}
+			a.snapshot = nil
+			attr = a.parent
+		default:
+			return
+		}
+	}
+}
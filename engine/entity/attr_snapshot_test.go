@@ -0,0 +1,50 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAttrSnapshot(t *testing.T) {
+	root := NewMapAttr()
+	members := NewMapAttr()
+	member := NewMapAttr()
+	member.SetInt("level", 1)
+	members.SetMapAttr("m1", member)
+	root.SetMapAttr("members", members)
+	items := NewListAttr()
+	items.AppendStr("sword")
+	root.SetListAttr("items", items)
+
+	snap1 := root.toSnapshotWithFilter(nil)
+	if !reflect.DeepEqual(snap1, root.ToMap()) {
+		t.Fatalf("wrong snapshot: %v", snap1)
+	}
+
+	member.SetInt("level", 2)
+	snap2 := root.toSnapshotWithFilter(nil)
+	if snap1["members"].(map[string]interface{})["m1"].(map[string]interface{})["level"] != int64(1) {
+		t.Fatalf("snapshot is modified: %v", snap1)
+	}
+	if snap2["members"].(map[string]interface{})["m1"].(map[string]interface{})["level"] != int64(2) {
+		t.Fatalf("snapshot is not updated: %v", snap2)
+	}
+	if reflect.ValueOf(snap1["items"]).Pointer() != reflect.ValueOf(snap2["items"]).Pointer() {
+		t.Fatalf("snapshot of unchanged attribute should be shared")
+	}
+
+	items.AppendStr("shield")
+	removed := members.PopMapAttr("m1")
+	snap3 := root.toSnapshotWithFilter(nil)
+	if len(snap3["items"].([]interface{})) != 2 || len(snap3["members"].(map[string]interface{})) != 0 {
+		t.Fatalf("snapshot is not updated: %v", snap3)
+	}
+	if len(snap2["items"].([]interface{})) != 1 || len(snap2["members"].(map[string]interface{})) != 1 {
+		t.Fatalf("snapshot is modified: %v", snap2)
+	}
+
+	removed.SetInt("level", 3)
+	if snap4 := root.toSnapshotWithFilter(nil); !reflect.DeepEqual(snap4, snap3) {
+		t.Fatalf("changes of removed attributes should not affect snapshots: %v", snap4)
+	}
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// AutoMigrateEntities migrates at most count auto-migratable entities in nil space to the nil space of the target game
+//
+// It is called when the dispatcher finds this game is hot and the target game is cold.
+func AutoMigrateEntities(targetGameID uint16, count int) {
+	targetSpaceID := GetNilSpaceID(targetGameID)
+	if nilSpace == nil || nilSpace.ID == targetSpaceID {
+		return
+	}
+
+	migrated := 0
+	for typeName, desc := range registeredEntityTypes {
+		if !desc.autoMigrate {
+			continue
+		}
+
+		for _, e := range entityManager.entitiesByType[typeName] {
+			if migrated >= count {
+				break
+			}
+			if e.destroyed || e.Space == nil || !e.Space.IsNil() || e.isEnteringSpace() {
+				continue
+			}
+
+			e.EnterSpace(targetSpaceID, e.Position)
+			migrated += 1
+		}
+	}
+
+	gwlog.Infof("AutoMigrateEntities: migrating %d entities to game%d", migrated, targetGameID)
+}
@@ -0,0 +1,119 @@
+package entity
+
+import (
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Pub/sub channels
+//
+// Entities subscribe channels (e.g. "world", "guild:1001") by SubscribeChannel. Messages published to a channel are sent
+// to the dispatcher selected by the channel, which broadcasts them to all games, and each game calls the method on its
+// subscribers of the channel, or on the clients of its subscribers. Messages published by a game to a channel are
+// delivered in order. Subscriptions are kept when entities migrate or games are restored.
+
+var (
+	channelSubscribers = map[string]EntitySet{}
+)
+
+// SubscribeChannel subscribes the channel, so that messages published to the channel are delivered to the entity
+func (e *Entity) SubscribeChannel(channel string) {
+	if e.destroyed {
+		gwlog.Panicf("%s.SubscribeChannel(%s): entity is destroyed", e, channel)
+	}
+
+	if e.channels == nil {
+		e.channels = map[string]struct{}{}
+	}
+	e.channels[channel] = struct{}{}
+
+	subscribers := channelSubscribers[channel]
+	if subscribers == nil {
+		subscribers = EntitySet{}
+		channelSubscribers[channel] = subscribers
+	}
+	subscribers.Add(e)
+}
+
+// UnsubscribeChannel unsubscribes the channel
+func (e *Entity) UnsubscribeChannel(channel string) {
+	if _, ok := e.channels[channel]; !ok {
+		return
+	}
+
+	delete(e.channels, channel)
+	subscribers := channelSubscribers[channel]
+	subscribers.Del(e)
+	if len(subscribers) == 0 {
+		delete(channelSubscribers, channel)
+	}
+}
+
+// IsSubscribedChannel returns if the entity subscribes the channel
+func (e *Entity) IsSubscribedChannel(channel string) bool {
+	_, ok := e.channels[channel]
+	return ok
+}
+
+// SubscribedChannels returns all channels subscribed by the entity in order
+func (e *Entity) SubscribedChannels() []string {
+	channels := make([]string, 0, len(e.channels))
+	for channel := range e.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// unsubscribeAllChannels removes the entity from subscribers of all channels when the entity is destroyed or migrated
+// out, subscribed channels are kept in migrate data
+func (e *Entity) unsubscribeAllChannels() {
+	for channel := range e.channels {
+		e.UnsubscribeChannel(channel)
+	}
+}
+
+// Publish calls the method on all subscribers of the channel in all games
+func Publish(channel string, method string, args []interface{}) {
+	dispatchercluster.SendPublishChannel(channel, false, method, args)
+}
+
+// PublishToClients calls the client method on clients of all subscribers of the channel in all games
+//
+// The method is called on the client entity of the subscriber, e.g. the player entity subscribing a chat channel.
+func PublishToClients(channel string, method string, args []interface{}) {
+	dispatchercluster.SendPublishChannel(channel, true, method, args)
+}
+
+// OnPublish is called by engine when the message published to the channel reaches in the game
+func OnPublish(channel string, toClients bool, method string, args [][]byte) {
+	subscribers := channelSubscribers[channel]
+	if len(subscribers) == 0 {
+		return
+	}
+
+	if !toClients {
+		for e := range subscribers {
+			if !e.destroyed {
+				e.onCallFromRemote(method, args, "")
+			}
+		}
+		return
+	}
+
+	clientArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err := netutil.MSG_PACKER.UnpackMsg(arg, &clientArgs[i]); err != nil {
+			gwlog.Errorf("OnPublish: unpack argument %d of %s to channel %s failed: %s", i, method, channel, err)
+			return
+		}
+	}
+	for e := range subscribers {
+		if e.client != nil {
+			e.CallClient(method, clientArgs...)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+type TestChannelEntity struct {
+	Entity
+	messages []string
+}
+
+func init() {
+	RegisterEntity("TestChannelEntity", &TestChannelEntity{}, false)
+}
+
+func (e *TestChannelEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestChannelEntity) OnChat(msg string) {
+	e.messages = append(e.messages, msg)
+}
+
+func TestChannelSubscribe(t *testing.T) {
+	e := CreateEntityLocally("TestChannelEntity", nil)
+	e.SubscribeChannel("world")
+	e.SubscribeChannel("guild:1")
+	e.SubscribeChannel("world")
+	if channels := e.SubscribedChannels(); len(channels) != 2 || channels[0] != "guild:1" || channels[1] != "world" {
+		t.Fatalf("wrong subscribed channels: %v", channels)
+	}
+	if !channelSubscribers["world"].Contains(e) {
+		t.Fatalf("entity is not subscriber of world")
+	}
+	if md := e.GetMigrateData(common.GenEntityID()); len(md.Channels) != 2 {
+		t.Fatalf("channels are not kept in migrate data: %v", md.Channels)
+	}
+
+	e.UnsubscribeChannel("guild:1")
+	if _, ok := channelSubscribers["guild:1"]; ok {
+		t.Fatalf("channel without subscribers should be removed")
+	}
+	if e.IsSubscribedChannel("guild:1") || !e.IsSubscribedChannel("world") {
+		t.Fatalf("wrong subscription of channels: %v", e.SubscribedChannels())
+	}
+
+	e.unsubscribeAllChannels()
+	if _, ok := channelSubscribers["world"]; ok {
+		t.Fatalf("destroyed entity should unsubscribe all channels")
+	}
+}
+
+func TestChannelOnPublish(t *testing.T) {
+	e1 := CreateEntityLocally("TestChannelEntity", nil)
+	e2 := CreateEntityLocally("TestChannelEntity", nil)
+	defer e1.unsubscribeAllChannels()
+	defer e2.unsubscribeAllChannels()
+	e1.SubscribeChannel("chat")
+
+	arg, err := netutil.MSG_PACKER.PackMsg("hello", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	OnPublish("chat", false, "OnChat", [][]byte{arg})
+	OnPublish("other", false, "OnChat", [][]byte{arg})
+
+	if msgs := e1.I.(*TestChannelEntity).messages; len(msgs) != 1 || msgs[0] != "hello" {
+		t.Fatalf("subscriber received wrong messages: %v", msgs)
+	}
+	if msgs := e2.I.(*TestChannelEntity).messages; len(msgs) != 0 {
+		t.Fatalf("non-subscriber received messages: %v", msgs)
+	}
+}
@@ -0,0 +1,201 @@
+package entity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Desync detection
+//
+// Desync detection is a debug mode for tuning client prediction and reconciliation. Inputs (RPCs with input sequence
+// numbers) from own clients of entity types implementing IInputReplayer are recorded. When the client syncs the
+// position predicted after an input, the server replays recorded inputs until the input against its authoritative
+// state, and the distance between the predicted position and the replayed position is the divergence of the client.
+// Divergences are exported as metrics, and statistics of each client are dumped by GET /admin/desync of the game.
+
+const (
+	_MAX_RECORDED_INPUTS = 64 // recorded inputs kept for each client, older inputs are dropped
+)
+
+var (
+	desyncThreshold  Coord // divergence larger than this is a desync, 0 if desync detection is disabled
+	desyncDistances  = metrics.NewHistogram("goworld_input_desync_distance", "Distances between positions predicted by clients and replayed by server", []float64{0.01, 0.1, 0.5, 1, 2, 5, 10})
+	desyncsTotal     = metrics.NewCounterVec("goworld_input_desyncs_total", "Predicted positions diverging from replayed positions more than the threshold", "type")
+	desyncSkipsTotal = metrics.NewCounterVec("goworld_input_desync_skips_total", "Predicted positions not checked since recorded inputs are incomplete", "type")
+)
+
+// IInputReplayer can be implemented by entity types whose movement is predicted by clients, for desync detection
+//
+// ReplayInput applies the input to the state by the authoritative rules of the server, without side effects on the entity.
+type IInputReplayer interface {
+	ReplayInput(state InputState, input *ClientInput) InputState
+}
+
+// InputState is the state of the entity replayed by inputs
+type InputState struct {
+	Position Vector3
+	Yaw      Yaw
+}
+
+// ClientInput is an input received from the own client
+type ClientInput struct {
+	Seq    uint32
+	Method string
+	Args   [][]byte
+	DT     time.Duration // time since the previous input of the client
+}
+
+// DecodeArg decodes the i-th argument of the input into v
+func (input *ClientInput) DecodeArg(i int, v interface{}) error {
+	return netutil.MSG_PACKER.UnpackMsg(input.Args[i], v)
+}
+
+// DesyncStats is the divergence statistics of a client
+type DesyncStats struct {
+	EntityID      common.EntityID `json:"entity"`
+	ClientID      common.ClientID `json:"client"`
+	TypeName      string          `json:"type"`
+	Samples       int             `json:"samples"`  // predicted positions checked
+	Desyncs       int             `json:"desyncs"`  // predicted positions diverging more than the threshold
+	Skipped       int             `json:"skipped"`  // predicted positions not checked since recorded inputs are incomplete
+	LastSeq       uint32          `json:"last_seq"` // input sequence number of the last check
+	LastDistance  Coord           `json:"last_distance"`
+	MaxDistance   Coord           `json:"max_distance"`
+	MeanDistance  Coord           `json:"mean_distance"`
+	totalDistance float64
+}
+
+// desyncDetector records inputs of the own client for replaying
+type desyncDetector struct {
+	inputs        []*ClientInput
+	base          InputState // authoritative state before the recorded inputs
+	lastInputTime time.Time
+	incomplete    bool // inputs are dropped since the last check
+	stats         DesyncStats
+}
+
+// SetDesyncDetect enables desync detection with the threshold, or disables it if the threshold is 0
+func SetDesyncDetect(threshold Coord) {
+	desyncThreshold = threshold
+}
+
+// recordInput records the input from the own client for replaying
+func (e *Entity) recordInput(seq uint32, method string, args [][]byte) {
+	if desyncThreshold <= 0 {
+		return
+	}
+	if _, ok := e.I.(IInputReplayer); !ok {
+		return
+	}
+
+	if e.desync == nil {
+		e.desync = &desyncDetector{}
+	}
+	d := e.desync
+	now := time.Now()
+	var dt time.Duration
+	if !d.lastInputTime.IsZero() {
+		dt = now.Sub(d.lastInputTime)
+	}
+	d.lastInputTime = now
+	if len(d.inputs) == 0 {
+		d.base = InputState{e.Position, e.yaw}
+	}
+	if len(d.inputs) == _MAX_RECORDED_INPUTS {
+		d.inputs = d.inputs[1:]
+		d.incomplete = true
+	}
+	argsCopy := make([][]byte, len(args)) // args are in the packet which is released after the call
+	for i, arg := range args {
+		argsCopy[i] = append([]byte(nil), arg...)
+	}
+	d.inputs = append(d.inputs, &ClientInput{Seq: seq, Method: method, Args: argsCopy, DT: dt})
+}
+
+// checkDesync replays recorded inputs until seq against the authoritative state, and compares the replayed position
+// with the position predicted by the client
+func (e *Entity) checkDesync(seq uint32, predicted Vector3) {
+	d := e.desync
+	if d == nil {
+		return
+	}
+
+	var inputs, newer []*ClientInput
+	for _, input := range d.inputs {
+		if isNewerInputSeq(input.Seq, seq) {
+			newer = append(newer, input) // inputs can arrive out of order
+		} else {
+			inputs = append(inputs, input)
+		}
+	}
+	if len(inputs) == 0 {
+		return // no input to replay
+	}
+	d.inputs = newer
+	if d.incomplete {
+		// the state before dropped inputs is unknown, recording again from the next input
+		d.incomplete = len(d.inputs) > 0
+		d.stats.Skipped += 1
+		desyncSkipsTotal.With(e.TypeName).Inc()
+		return
+	}
+	sort.SliceStable(inputs, func(i, j int) bool {
+		return isNewerInputSeq(inputs[j].Seq, inputs[i].Seq)
+	})
+
+	replayer := e.I.(IInputReplayer)
+	state := d.base
+	if err := gwutils.CatchPanic(func() {
+		for _, input := range inputs {
+			state = replayer.ReplayInput(state, input)
+		}
+	}); err != nil {
+		gwlog.Errorf("%s.ReplayInput paniced: %v", e, err)
+		d.incomplete = len(d.inputs) > 0
+		return
+	}
+	d.base = state
+
+	dist := state.Position.DistanceTo(predicted)
+	st := &d.stats
+	st.Samples += 1
+	st.LastSeq = seq
+	st.LastDistance = dist
+	st.totalDistance += float64(dist)
+	if dist > st.MaxDistance {
+		st.MaxDistance = dist
+	}
+	desyncDistances.Observe(float64(dist))
+	if dist > desyncThreshold {
+		st.Desyncs += 1
+		desyncsTotal.With(e.TypeName).Inc()
+		gwlog.Warnf("%s: client %s desynced at input %d: predicted %s, replayed %s", e, e.getClientID(), seq, predicted, state.Position)
+		e.recordTimeline(TimelineClient, "desynced at input %d: predicted %s, replayed %s", seq, predicted, state.Position)
+	}
+}
+
+// GetDesyncStats returns divergence statistics of clients of all entities on the game, in the order of entity IDs
+func GetDesyncStats() []DesyncStats {
+	stats := []DesyncStats{}
+	for _, e := range entityManager.entities {
+		if e.desync == nil || e.client == nil {
+			continue
+		}
+		st := e.desync.stats
+		st.EntityID, st.ClientID, st.TypeName = e.ID, e.client.clientid, e.TypeName
+		if st.Samples > 0 {
+			st.MeanDistance = Coord(st.totalDistance / float64(st.Samples))
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].EntityID < stats[j].EntityID
+	})
+	return stats
+}
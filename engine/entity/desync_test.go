@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+type TestDesyncEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestDesyncEntity", &TestDesyncEntity{}, false)
+}
+
+func (e *TestDesyncEntity) DescribeEntityType(desc *EntityTypeDesc) {
+}
+
+func (e *TestDesyncEntity) ReplayInput(state InputState, input *ClientInput) InputState {
+	var dx float32
+	if err := input.DecodeArg(0, &dx); err != nil {
+		panic(err)
+	}
+	state.Position.X += Coord(dx)
+	return state
+}
+
+func TestDesyncDetect(t *testing.T) {
+	SetDesyncDetect(0.5)
+	defer SetDesyncDetect(0)
+
+	e := CreateEntityLocally("TestDesyncEntity", nil)
+	move := func(seq uint32, dx float32) {
+		arg, _ := netutil.MSG_PACKER.PackMsg(dx, nil)
+		e.recordInput(seq, "Move_Client", [][]byte{arg})
+	}
+
+	move(1, 1)
+	move(3, 2) // arrives before input 2
+	move(2, 1)
+	e.Position.X = 10 // not changing the state before the inputs
+	e.checkDesync(2, Vector3{X: 2})
+	if st := e.desync.stats; st.Samples != 1 || st.Desyncs != 0 || st.LastDistance != 0 || len(e.desync.inputs) != 1 {
+		t.Fatalf("inputs until 2 should be replayed without desync: %+v", st)
+	}
+
+	e.checkDesync(3, Vector3{X: 5})
+	if st := e.desync.stats; st.Samples != 2 || st.Desyncs != 1 || st.LastDistance != 1 || st.MaxDistance != 1 {
+		t.Fatalf("input 3 should be desynced: %+v", st)
+	}
+	e.checkDesync(4, Vector3{X: 5}) // no input to replay
+	if e.desync.stats.Samples != 2 {
+		t.Fatalf("predicted position without inputs should not be checked")
+	}
+
+	for seq := uint32(5); seq < 5+_MAX_RECORDED_INPUTS+1; seq++ {
+		move(seq, 1)
+	}
+	e.checkDesync(5+_MAX_RECORDED_INPUTS, Vector3{})
+	if st := e.desync.stats; st.Samples != 2 || st.Skipped != 1 || e.desync.incomplete {
+		t.Fatalf("predicted position after dropped inputs should be skipped: %+v", st)
+	}
+
+	e.client = &GameClient{clientid: "TestDesyncClient"}
+	defer func() {
+		e.client = nil
+	}()
+	stats := GetDesyncStats()
+	if len(stats) != 1 || stats[0].ClientID != "TestDesyncClient" || stats[0].MeanDistance != 0.5 {
+		t.Errorf("wrong desync stats: %+v", stats)
+	}
+}
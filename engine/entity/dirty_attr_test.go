@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"testing"
+)
+
+type TestDirtyEntity struct {
+	Entity
+}
+
+func (e *TestDirtyEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("hp", "Persistent")
+	desc.DefineAttr("bag", "Client", "Persistent")
+	desc.DefineAttr("temp", "Client")
+}
+
+func TestPersistentDirty(t *testing.T) {
+	RegisterEntity("TestDirtyEntity", &TestDirtyEntity{}, false)
+	e := CreateEntityLocally("TestDirtyEntity", nil)
+	e.Attrs.SetMapAttr("bag", NewMapAttr())
+	e.Attrs.SetMapAttr("temp", NewMapAttr())
+
+	e.persistentDirty = false
+	e.Attrs.SetInt("temp2", 1)
+	e.GetMapAttr("temp").SetInt("x", 1)
+	if e.persistentDirty {
+		t.Fatalf("changing non-persistent attrs should not mark the entity dirty")
+	}
+
+	e.GetMapAttr("bag").SetInt("sword", 1)
+	if !e.persistentDirty {
+		t.Fatalf("changing nested persistent attrs should mark the entity dirty")
+	}
+
+	written, skipped := entitySaves.With("TestDirtyEntity", "written"), entitySaves.With("TestDirtyEntity", "skipped")
+	nwritten, nskipped := written.Value(), skipped.Value()
+	e.Save()
+	if e.persistentDirty {
+		t.Fatalf("entity should not be dirty after saved")
+	}
+	e.Save()
+	if written.Value() != nwritten+1 || skipped.Value() != nskipped+1 {
+		t.Fatalf("saving unchanged entity should be skipped: %v written, %v skipped", written.Value()-nwritten, skipped.Value()-nskipped)
+	}
+
+	e.Attrs.SetInt("hp", 100)
+	if !e.persistentDirty {
+		t.Fatalf("changing persistent attrs should mark the entity dirty")
+	}
+}
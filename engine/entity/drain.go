@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// DrainEntities migrates at most count entities to nil spaces of target games in turn, and returns the number of
+// entities which are not migrated out yet, including entities which are migrating
+//
+// It is called repeatedly by a draining game. Spaces and service entities are not migrated.
+func DrainEntities(targetGameIDs []uint16, count int) (remaining int) {
+	if nilSpace == nil || len(targetGameIDs) == 0 {
+		return
+	}
+
+	migrated := 0
+	for _, e := range entityManager.entities {
+		if e.destroyed || e.IsSpaceEntity() || e.typeDesc.isService {
+			continue
+		}
+
+		remaining += 1
+		if migrated >= count || e.isEnteringSpace() {
+			continue
+		}
+
+		targetGameID := targetGameIDs[migrated%len(targetGameIDs)]
+		e.EnterSpace(GetNilSpaceID(targetGameID), e.Position)
+		migrated += 1
+	}
+
+	if migrated > 0 {
+		gwlog.Infof("DrainEntities: migrating %d entities to games %v, %d entities remaining", migrated, targetGameIDs, remaining)
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package entity
+
+import (
+	"github.com/xiaonanln/go-aoi"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Per-entity AOI distance and interest filtering
+//
+// The AOI manager of the space finds entities around each entity. If any of two entities has the AOI distance set, the
+// entity sees the other entity within its own AOI distance, or within the AOI distance of the other entity if that is
+// larger, so bosses with large AOI distances are visible farther away. Entities with AOI distances larger than the
+// space are checked against all entities in the space when they move. At last, AOIFilter of the entity can exclude
+// the other entity.
+
+// IAOIFilter can be implemented by entity types to filter entities in AOI
+//
+// AOIFilter returns false to exclude other entity from AOI of the entity, e.g. stealthed players for some observers.
+// Call RefreshAOI on the entity or the other entity when the result changes.
+type IAOIFilter interface {
+	AOIFilter(other *Entity) bool
+}
+
+// SetAOIDistance sets the AOI distance of the entity, 0 means the AOI distance of the space
+//
+// Changes are applied to entities around immediately. The AOI distance is kept when the entity enters other spaces on
+// the same game.
+func (e *Entity) SetAOIDistance(distance Coord) {
+	if distance < 0 {
+		gwlog.Panicf("%s.SetAOIDistance: aoi distance < 0", e)
+	}
+
+	oldDistance := e.aoiDistance
+	e.aoiDistance = distance
+	space := e.Space
+	if space == nil || space.aoiMgr == nil || !e.IsUseAOI() {
+		return
+	}
+
+	if distance > 0 {
+		space.customAOIEntities.Add(e)
+	} else {
+		space.customAOIEntities.Del(e)
+	}
+	if oldDistance > space.aoiDistance || distance > space.aoiDistance {
+		space.refreshAOIWithAll(e)
+	} else {
+		e.RefreshAOI()
+	}
+}
+
+// GetAOIDistance returns the AOI distance of the entity
+func (e *Entity) GetAOIDistance() Coord {
+	if e.aoiDistance == 0 {
+		return e.baseAOIDistance()
+	}
+	return e.aoiDistance
+}
+
+// baseAOIDistance returns the AOI distance used by the AOI manager of the space
+func (e *Entity) baseAOIDistance() Coord {
+	if e.Space == nil || e.Space.aoiMgr == nil {
+		return 0
+	}
+	if _, ok := e.Space.aoiMgr.(*aoi.TowerAOIManager); ok {
+		return e.typeDesc.aoiDistance
+	}
+	return e.Space.aoiDistance
+}
+
+// RefreshAOI checks interests between the entity and entities around again, e.g. when results of AOIFilter change
+func (e *Entity) RefreshAOI() {
+	space := e.Space
+	if space == nil || space.aoiMgr == nil || !e.IsUseAOI() {
+		return
+	}
+
+	others := EntitySet{}
+	for _, set := range []EntitySet{e.aoiNeighbors, e.InterestedIn, e.InterestedBy} {
+		for other := range set {
+			others.Add(other)
+		}
+	}
+	for other := range space.customAOIEntities {
+		if other.aoiDistance > space.aoiDistance {
+			others.Add(other)
+		}
+	}
+	for other := range others {
+		e.updateInterest(other)
+		other.updateInterest(e)
+	}
+}
+
+// updateInterest makes the entity interested in the other entity or not
+func (e *Entity) updateInterest(other *Entity) {
+	if should := e.shouldInterest(other); should != e.InterestedIn.Contains(other) {
+		if should {
+			e.interest(other)
+		} else {
+			e.uninterest(other)
+		}
+	}
+}
+
+func (e *Entity) shouldInterest(other *Entity) bool {
+	space := e.Space
+	if other == e || other.destroyed || space == nil || other.Space != space || space.aoiMgr == nil || !other.IsUseAOI() {
+		return false
+	}
+
+	if e.aoiDistance == 0 && other.aoiDistance == 0 {
+		if !e.aoiNeighbors.Contains(other) {
+			return false
+		}
+	} else {
+		distance := e.GetAOIDistance()
+		if other.aoiDistance > distance {
+			distance = other.aoiDistance
+		}
+		if distance <= e.baseAOIDistance() && !e.aoiNeighbors.Contains(other) {
+			return false
+		}
+		dx, dz := e.Position.X-other.Position.X, e.Position.Z-other.Position.Z
+		if dx < -distance || dx > distance || dz < -distance || dz > distance {
+			return false
+		}
+	}
+
+	if filter, ok := e.I.(IAOIFilter); ok && !filter.AOIFilter(other) {
+		return false
+	}
+	return true
+}
+
+func (space *Space) enterAOI(entity *Entity) {
+	if entity.aoiDistance > 0 {
+		space.customAOIEntities.Add(entity)
+	}
+	space.aoiMgr.Enter(&entity.aoi, aoi.Coord(entity.Position.X), aoi.Coord(entity.Position.Z))
+	space.updateCustomAOI(entity)
+}
+
+func (space *Space) leaveAOI(entity *Entity) {
+	space.aoiMgr.Leave(&entity.aoi)
+	space.customAOIEntities.Del(entity)
+	// entities with large AOI distances are not found by the AOI manager
+	for other := range entity.InterestedIn {
+		entity.uninterest(other)
+	}
+	for other := range entity.InterestedBy {
+		other.uninterest(entity)
+	}
+}
+
+// updateCustomAOI checks interests between the entity and entities with AOI distances set, when the entity moves
+func (space *Space) updateCustomAOI(entity *Entity) {
+	if len(space.customAOIEntities) == 0 || !entity.IsUseAOI() {
+		return
+	}
+
+	if entity.aoiDistance > space.aoiDistance {
+		space.refreshAOIWithAll(entity)
+		return
+	}
+
+	isCustom := space.customAOIEntities.Contains(entity)
+	for other := range entity.aoiNeighbors {
+		if isCustom || space.customAOIEntities.Contains(other) {
+			entity.updateInterest(other)
+			other.updateInterest(entity)
+		}
+	}
+	for other := range space.customAOIEntities {
+		if other.aoiDistance > space.aoiDistance && other != entity {
+			entity.updateInterest(other)
+			other.updateInterest(entity)
+		}
+	}
+}
+
+func (space *Space) refreshAOIWithAll(entity *Entity) {
+	for other := range space.entities {
+		if other != entity && other.IsUseAOI() {
+			entity.updateInterest(other)
+			other.updateInterest(entity)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+var (
+	rpcDuration = metrics.NewHistogramVec("goworld_rpc_duration_seconds", "Durations of entity RPC calls", metrics.DefBuckets, "type", "method")
+	entitySaves = metrics.NewCounterVec("goworld_entity_saves_total", "Saves of persistent entities, result is written or skipped (unchanged since the last save)", "type", "result")
+)
+
+// observeRPCDuration records the duration of RPC call, calls to invalid RPCs are not recorded to limit label values
+func (e *Entity) observeRPCDuration(methodName string, d time.Duration) {
+	if e.typeDesc.rpcDescs[methodName] == nil {
+		return
+	}
+	rpcDuration.With(e.TypeName, methodName).Observe(d.Seconds())
+}
@@ -0,0 +1,98 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Input sequence numbers are stamped by clients on movement and action packets. The last processed input sequence number
+// is acknowledged to the own client together with the authoritative position and yaw of the entity, so that clients can
+// do prediction and reconciliation by replaying inputs which are not acknowledged yet.
+
+var entityInputAcksToGate = map[uint16]*netutil.Packet{}
+
+// OnSyncPositionYawWithSeqFromClient is called by engine to sync entity infos with input sequence number from Client
+func OnSyncPositionYawWithSeqFromClient(eid common.EntityID, seq uint32, x, y, z Coord, yaw Yaw) {
+	e := entityManager.get(eid)
+	if e == nil {
+		// entity not found, may destroyed before call
+		return
+	}
+
+	e.checkDesync(seq, Vector3{x, y, z})
+	e.syncPositionYawFromClient(x, y, z, yaw)
+	e.ackInputSeq(seq)
+}
+
+// OnCallFromClientWithSeq is called by engine when client calls entity method with input sequence number
+func OnCallFromClientWithSeq(id common.EntityID, method string, args [][]byte, clientID common.ClientID, seq uint32) {
+	e := entityManager.get(id)
+	if e == nil {
+		// entity not found, may destroyed before call
+		return
+	}
+
+	isFromOwnClient := clientID == e.getClientID()
+	if isFromOwnClient {
+		e.recordInput(seq, method, args) // recorded before the call changes the authoritative state
+	}
+	e.onCallFromRemote(method, args, clientID)
+	if isFromOwnClient { // only inputs from own client are acknowledged
+		e.ackInputSeq(seq)
+	}
+}
+
+// LastInputSeq returns the last input sequence number processed from the own client
+func (e *Entity) LastInputSeq() uint32 {
+	return e.lastInputSeq
+}
+
+func (e *Entity) ackInputSeq(seq uint32) {
+	if !isNewerInputSeq(seq, e.lastInputSeq) {
+		// inputs can be processed out of order (e.g. RPCs are not batched like position syncs), never acknowledge backwards
+		return
+	}
+
+	e.lastInputSeq = seq
+	e.syncInfoFlag |= sifAckInputSeq
+}
+
+// isNewerInputSeq returns if seq is newer than last, considering wrapping around of sequence numbers
+func isNewerInputSeq(seq, last uint32) bool {
+	return int32(seq-last) > 0
+}
+
+func (e *Entity) appendInputAck(syncInfo proto.EntitySyncInfo) uint64 {
+	gateid := e.client.gateid
+	pkt := entityInputAcksToGate[gateid]
+	if pkt == nil {
+		pkt = netutil.NewPacket()
+		pkt.AppendUint16(proto.MT_SYNC_INPUT_ACK_ON_CLIENTS)
+		pkt.AppendUint16(gateid)
+		appendSyncHeader(pkt)
+		entityInputAcksToGate[gateid] = pkt
+	}
+
+	pkt.AppendClientID(e.client.clientid)
+	pkt.AppendEntityID(e.ID)
+	pkt.AppendUint32(e.lastInputSeq)
+	pkt.AppendFloat32(syncInfo.X)
+	pkt.AppendFloat32(syncInfo.Y)
+	pkt.AppendFloat32(syncInfo.Z)
+	pkt.AppendFloat32(syncInfo.Yaw)
+	return common.CLIENTID_LENGTH + common.ENTITYID_LENGTH + proto.SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY
+}
+
+func flushEntityInputAcks() {
+	if len(entityInputAcksToGate) == 0 {
+		return
+	}
+
+	for gateid, pkt := range entityInputAcksToGate {
+		dispatchercluster.SelectByGateID(gateid).SendPacket(pkt)
+		pkt.Release()
+	}
+	entityInputAcksToGate = map[uint16]*netutil.Packet{}
+}
@@ -0,0 +1,23 @@
+package entity
+
+import "testing"
+
+func TestAckInputSeq(t *testing.T) {
+	e := &Entity{}
+	e.ackInputSeq(3)
+	if e.LastInputSeq() != 3 || e.syncInfoFlag&sifAckInputSeq == 0 {
+		t.Fatalf("input 3 should be acknowledged: last=%d, flag=%v", e.LastInputSeq(), e.syncInfoFlag)
+	}
+
+	e.syncInfoFlag = 0
+	e.ackInputSeq(2) // out of order input
+	if e.LastInputSeq() != 3 || e.syncInfoFlag != 0 {
+		t.Errorf("older input 2 should not be acknowledged: last=%d, flag=%v", e.LastInputSeq(), e.syncInfoFlag)
+	}
+
+	e.lastInputSeq = 0xFFFFFFFF
+	e.ackInputSeq(1) // wrapped around
+	if e.LastInputSeq() != 1 {
+		t.Errorf("wrapped input 1 should be acknowledged: last=%d", e.LastInputSeq())
+	}
+}
@@ -0,0 +1,134 @@
+package entity
+
+import (
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// EntityBrief is the brief of an entity for runtime inspection
+type EntityBrief struct {
+	ID       common.EntityID `json:"id"`
+	TypeName string          `json:"type"`
+	SpaceID  common.EntityID `json:"space,omitempty"`
+	Position Vector3         `json:"position"`
+}
+
+// EntityDetail is the detail of an entity for runtime inspection
+type EntityDetail struct {
+	EntityBrief
+	Yaw          Yaw                    `json:"yaw"`
+	Persistent   bool                   `json:"persistent"`
+	ClientID     common.ClientID        `json:"client,omitempty"`
+	GateID       uint16                 `json:"gate,omitempty"`
+	Attrs        map[string]interface{} `json:"attrs"`
+	InterestedIn []common.EntityID      `json:"interested_in"`
+	InterestedBy []common.EntityID      `json:"interested_by"`
+	Timers       int                    `json:"timers"`
+	Channels     []string               `json:"channels"`
+}
+
+// SpaceDetail is the detail of a space for runtime inspection
+type SpaceDetail struct {
+	ID          common.EntityID `json:"id"`
+	Kind        int             `json:"kind"`
+	AOIDistance Coord           `json:"aoi_distance"`
+	Entities    []EntityBrief   `json:"entities"`
+}
+
+func (e *Entity) brief() EntityBrief {
+	b := EntityBrief{
+		ID:       e.ID,
+		TypeName: e.TypeName,
+		Position: e.Position,
+	}
+	if e.Space != nil {
+		b.SpaceID = e.Space.ID
+	}
+	return b
+}
+
+// ListEntities returns briefs of at most limit entities of the type (all types if typeName is empty) ordered by ID, and
+// the total number of entities of the type
+func ListEntities(typeName string, limit int) ([]EntityBrief, int) {
+	var entities []*Entity
+	if typeName == "" {
+		entities = make([]*Entity, 0, len(entityManager.entities))
+		for _, e := range entityManager.entities {
+			entities = append(entities, e)
+		}
+	} else {
+		for _, e := range entityManager.entitiesByType[typeName] {
+			entities = append(entities, e)
+		}
+	}
+
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].ID < entities[j].ID
+	})
+	total := len(entities)
+	if len(entities) > limit {
+		entities = entities[:limit]
+	}
+	briefs := make([]EntityBrief, len(entities))
+	for i, e := range entities {
+		briefs[i] = e.brief()
+	}
+	return briefs, total
+}
+
+// InspectEntity returns the detail of the entity, or nil if the entity is not found on this game
+func InspectEntity(id common.EntityID) *EntityDetail {
+	e := entityManager.get(id)
+	if e == nil {
+		return nil
+	}
+
+	d := &EntityDetail{
+		EntityBrief:  e.brief(),
+		Yaw:          e.yaw,
+		Persistent:   e.IsPersistent(),
+		Attrs:        e.Attrs.ToMap(),
+		InterestedIn: sortedEntityIDs(e.InterestedIn),
+		InterestedBy: sortedEntityIDs(e.InterestedBy),
+		Timers:       len(e.timers),
+		Channels:     e.SubscribedChannels(),
+	}
+	if e.client != nil {
+		d.ClientID, d.GateID = e.client.clientid, e.client.gateid
+	}
+	return d
+}
+
+// InspectSpace returns the detail of the space, or nil if the space is not found on this game
+func InspectSpace(id common.EntityID) *SpaceDetail {
+	space := spaceManager.getSpace(id)
+	if space == nil {
+		return nil
+	}
+
+	d := &SpaceDetail{
+		ID:          space.ID,
+		Kind:        space.Kind,
+		AOIDistance: space.aoiDistance,
+		Entities:    make([]EntityBrief, 0, len(space.entities)),
+	}
+	for e := range space.entities {
+		d.Entities = append(d.Entities, e.brief())
+	}
+	sort.Slice(d.Entities, func(i, j int) bool {
+		return d.Entities[i].ID < d.Entities[j].ID
+	})
+	return d
+}
+
+func sortedEntityIDs(entities EntitySet) []common.EntityID {
+	eids := make([]common.EntityID, 0, len(entities))
+	for e := range entities {
+		eids = append(eids, e.ID)
+	}
+	sort.Slice(eids, func(i, j int) bool {
+		return eids[i] < eids[j]
+	})
+	return eids
+}
@@ -0,0 +1,51 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type TestInspectEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestInspectEntity", &TestInspectEntity{}, false)
+}
+
+func (e *TestInspectEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("name", "AllClients")
+}
+
+func TestInspectEntities(t *testing.T) {
+	e1 := CreateEntityLocally("TestInspectEntity", map[string]interface{}{"name": "e1"})
+	e2 := CreateEntityLocally("TestInspectEntity", nil)
+
+	briefs, total := ListEntities("TestInspectEntity", 1)
+	if total != 2 || len(briefs) != 1 || briefs[0].ID != minEntityID(e1, e2) || briefs[0].TypeName != "TestInspectEntity" {
+		t.Errorf("wrong entities listed: %+v, total %d", briefs, total)
+	}
+	if _, total := ListEntities("", 0); total < 2 {
+		t.Errorf("wrong total of all entities: %d", total)
+	}
+
+	d := InspectEntity(e1.ID)
+	if d == nil || d.ID != e1.ID || d.Attrs["name"] != "e1" {
+		t.Fatalf("wrong entity detail: %+v", d)
+	}
+	if _, err := json.Marshal(d); err != nil {
+		t.Errorf("entity detail can not be encoded: %s", err)
+	}
+	if InspectEntity("NotExistEntity00") != nil || InspectSpace(e1.ID) != nil {
+		t.Errorf("entities not found should not be inspected")
+	}
+}
+
+func minEntityID(e1, e2 *Entity) common.EntityID {
+	if e1.ID < e2.ID {
+		return e1.ID
+	}
+	return e2.ID
+}
@@ -0,0 +1,178 @@
+package entity
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+)
+
+// Persistent timers
+//
+// Persistent timers are recorded in KVDB, so that they are not lost when the game crashes or the entity is destroyed.
+// They are migrated and restored with the entity like other timers, and are loaded from KVDB when the entity is loaded
+// from storage, when timers expired in the meantime fire immediately. A timer is removed from KVDB after it fires, so it
+// might fire again if the game crashes right after it fires.
+
+const persistentTimerKeyPrefix = "_ptimer/"
+
+// PersistentTimerID is the type of persistent timer ID, which is unique among all entities
+type PersistentTimerID string
+
+type persistentTimerInfo struct {
+	FireTime time.Time
+	Method   string
+	Args     []interface{}
+	rawTimer *timerwheel.Timer
+}
+
+func persistentTimerKeyRange(entityID common.EntityID) (beginKey string, endKey string) {
+	return persistentTimerKeyPrefix + string(entityID) + "/", persistentTimerKeyPrefix + string(entityID) + "0" // '0' follows '/'
+}
+
+func persistentTimerKey(entityID common.EntityID, tid PersistentTimerID) string {
+	return persistentTimerKeyPrefix + string(entityID) + "/" + string(tid)
+}
+
+// AddPersistentTimer adds a callback which calls the method after the duration, even if the game restarts in between
+//
+// Only persistent entities can add persistent timers, and KVDB should be configured.
+func (e *Entity) AddPersistentTimer(d time.Duration, method string, args ...interface{}) PersistentTimerID {
+	if !e.IsPersistent() {
+		gwlog.Panicf("%s.AddPersistentTimer %s: entity is not persistent", e, method)
+	}
+	if !kvdb.IsConfigured() {
+		gwlog.Panicf("%s.AddPersistentTimer %s: KVDB is not configured", e, method)
+	}
+
+	tid := PersistentTimerID(common.GenEntityID())
+	info := &persistentTimerInfo{
+		FireTime: time.Now().Add(d),
+		Method:   method,
+		Args:     args,
+	}
+	data, err := timersPacker.PackMsg(info, nil)
+	if err != nil {
+		gwlog.Panicf("%s.AddPersistentTimer %s: pack arguments failed: %s", e, method, err)
+	}
+
+	key := persistentTimerKey(e.ID, tid)
+	kvdb.Put(key, string(data), func(err error) {
+		if err != nil {
+			gwlog.Errorf("%s: save persistent timer %s failed: %s", e, key, err)
+		}
+	})
+	e.armPersistentTimer(tid, info)
+	gwlog.Debugf("%s.AddPersistentTimer %s: %s", e, method, tid)
+	return tid
+}
+
+// CancelPersistentTimer cancels the persistent timer
+func (e *Entity) CancelPersistentTimer(tid PersistentTimerID) {
+	info := e.persistentTimers[tid]
+	if info == nil {
+		return // timer already fired or cancelled
+	}
+	delete(e.persistentTimers, tid)
+	e.cancelRawTimer(info.rawTimer)
+	e.delPersistentTimerRecord(tid)
+}
+
+func (e *Entity) armPersistentTimer(tid PersistentTimerID, info *persistentTimerInfo) {
+	if e.persistentTimers == nil {
+		e.persistentTimers = map[PersistentTimerID]*persistentTimerInfo{}
+	}
+	e.persistentTimers[tid] = info
+	info.rawTimer = e.addRawCallback(time.Until(info.FireTime), func() {
+		e.triggerPersistentTimer(tid)
+	})
+}
+
+func (e *Entity) triggerPersistentTimer(tid PersistentTimerID) {
+	info := e.persistentTimers[tid]
+	delete(e.persistentTimers, tid)
+	e.delPersistentTimerRecord(tid)
+	e.onCallFromLocal(info.Method, info.Args)
+}
+
+func (e *Entity) delPersistentTimerRecord(tid PersistentTimerID) {
+	key := persistentTimerKey(e.ID, tid)
+	kvdb.Del(key, func(err error) {
+		if err != nil {
+			gwlog.Errorf("%s: delete persistent timer %s failed: %s", e, key, err)
+		}
+	})
+}
+
+// dumpPersistentTimers packs persistent timers for migrating or freezing the entity
+func (e *Entity) dumpPersistentTimers() []byte {
+	if len(e.persistentTimers) == 0 {
+		return nil
+	}
+
+	timers := e.persistentTimers
+	e.persistentTimers = nil
+	data, err := timersPacker.PackMsg(timers, nil)
+	if err != nil {
+		gwlog.TraceError("%s dump persistent timers failed: %s", e, err)
+	}
+	return data
+}
+
+func (e *Entity) restorePersistentTimers(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	var timers map[PersistentTimerID]*persistentTimerInfo
+	if err := timersPacker.UnpackMsg(data, &timers); err != nil {
+		return err
+	}
+	for tid, info := range timers {
+		e.armPersistentTimer(tid, info)
+	}
+	return nil
+}
+
+// loadPersistentTimers loads persistent timers from KVDB when the entity is loaded from storage
+func (e *Entity) loadPersistentTimers() {
+	if !kvdb.IsConfigured() {
+		return
+	}
+
+	beginKey, endKey := persistentTimerKeyRange(e.ID)
+	kvdb.GetRange(beginKey, endKey, func(items []kvdbtypes.KVItem, err error) {
+		if err != nil {
+			gwlog.Errorf("%s: load persistent timers failed: %s", e, err)
+			return
+		}
+		if e.IsDestroyed() {
+			return // timers are loaded again when the entity is loaded next time
+		}
+
+		for _, item := range items {
+			tid, info, err := decodePersistentTimer(beginKey, item)
+			if err != nil {
+				gwlog.Errorf("%s: load persistent timer %s failed: %s", e, item.Key, err)
+				continue
+			}
+			if _, ok := e.persistentTimers[tid]; !ok {
+				e.armPersistentTimer(tid, info)
+			}
+		}
+		if len(items) > 0 {
+			gwlog.Infof("%s: %d persistent timers loaded", e, len(items))
+		}
+	})
+}
+
+func decodePersistentTimer(keyPrefix string, item kvdbtypes.KVItem) (PersistentTimerID, *persistentTimerInfo, error) {
+	var info persistentTimerInfo
+	if err := timersPacker.UnpackMsg([]byte(item.Val), &info); err != nil {
+		return "", nil, err
+	}
+	return PersistentTimerID(strings.TrimPrefix(item.Key, keyPrefix)), &info, nil
+}
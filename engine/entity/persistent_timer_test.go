@@ -0,0 +1,46 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+func TestPersistentTimerKeyRange(t *testing.T) {
+	eid := common.GenEntityID()
+	other := common.GenEntityID()
+	beginKey, endKey := persistentTimerKeyRange(eid)
+
+	key := persistentTimerKey(eid, PersistentTimerID(common.GenEntityID()))
+	if key < beginKey || key >= endKey {
+		t.Errorf("key %s out of range [%s, %s)", key, beginKey, endKey)
+	}
+	otherKey := persistentTimerKey(other, PersistentTimerID(common.GenEntityID()))
+	if otherKey >= beginKey && otherKey < endKey {
+		t.Errorf("key %s of other entity in range [%s, %s)", otherKey, beginKey, endKey)
+	}
+}
+
+func TestDecodePersistentTimer(t *testing.T) {
+	eid := common.GenEntityID()
+	tid := PersistentTimerID(common.GenEntityID())
+	fireTime := time.Now().Add(time.Hour).Round(time.Millisecond)
+	data, err := timersPacker.PackMsg(&persistentTimerInfo{FireTime: fireTime, Method: "OnExpire", Args: []interface{}{"a", 1}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	beginKey, _ := persistentTimerKeyRange(eid)
+	decodedID, info, err := decodePersistentTimer(beginKey, kvdbtypes.KVItem{Key: persistentTimerKey(eid, tid), Val: string(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedID != tid {
+		t.Errorf("timer ID is %s, expected %s", decodedID, tid)
+	}
+	if !info.FireTime.Equal(fireTime) || info.Method != "OnExpire" || len(info.Args) != 2 {
+		t.Errorf("wrong timer decoded: %+v", info)
+	}
+}
@@ -0,0 +1,95 @@
+package entity
+
+import (
+	"math"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Position sync control
+//
+// Positions & yaws of entities are synced to clients every position sync interval of the game if changed. Entities can
+// be synced less often by SetPositionSyncInterval, and skip small movements by SetPositionSyncThreshold, i.e. positions
+// & yaws are only synced if the entity moved farther than the distance threshold or rotated more than the yaw threshold
+// since the last synced position & yaw, so clients should extrapolate (dead reckoning) between syncs. Input
+// acknowledgements to the own client are not affected. These settings are not migrated, so they are usually set in OnInit.
+
+type positionSync struct {
+	interval     time.Duration
+	distance     Coord
+	yaw          Yaw
+	nextSyncTime time.Time
+	syncedPos    Vector3
+	syncedYaw    Yaw
+}
+
+// SetPositionSyncInterval sets the minimal interval of syncing position & yaw of the entity to clients
+//
+// 0 means syncing every position sync interval of the game.
+func (e *Entity) SetPositionSyncInterval(interval time.Duration) {
+	if interval < 0 {
+		gwlog.Panicf("%s.SetPositionSyncInterval: negative interval: %s", e, interval)
+	}
+
+	e.getPositionSync().interval = interval
+	e.checkPositionSync()
+}
+
+// SetPositionSyncThreshold sets the distance & yaw (in degrees) the entity should move or rotate before syncing position
+// & yaw to clients
+func (e *Entity) SetPositionSyncThreshold(distance Coord, yaw Yaw) {
+	if distance < 0 || yaw < 0 {
+		gwlog.Panicf("%s.SetPositionSyncThreshold: negative threshold: %v, %v", e, distance, yaw)
+	}
+
+	ps := e.getPositionSync()
+	ps.distance, ps.yaw = distance, yaw
+	e.checkPositionSync()
+}
+
+func (e *Entity) getPositionSync() *positionSync {
+	if e.posSync == nil {
+		e.posSync = &positionSync{syncedPos: e.Position, syncedYaw: e.yaw}
+	}
+	return e.posSync
+}
+
+// checkPositionSync resets position sync control of the entity if it is the same as default
+func (e *Entity) checkPositionSync() {
+	ps := e.posSync
+	if ps.interval == 0 && ps.distance == 0 && ps.yaw == 0 {
+		e.posSync = nil
+	}
+}
+
+// filterPositionSync returns sync info flags of the entity to sync now, position sync which is not due yet is kept for
+// later
+func (e *Entity) filterPositionSync(flag syncInfoFlag, now time.Time) syncInfoFlag {
+	posFlag := flag & (sifSyncOwnClient | sifSyncNeighborClients)
+	if posFlag == 0 {
+		return flag
+	}
+
+	ps := e.posSync
+	if now.Before(ps.nextSyncTime) {
+		e.syncInfoFlag |= posFlag // sync later
+		return flag &^ posFlag
+	}
+	if e.Position.DistanceTo(ps.syncedPos) <= ps.distance && yawDiff(e.yaw, ps.syncedYaw) <= ps.yaw {
+		return flag &^ posFlag
+	}
+
+	ps.syncedPos, ps.syncedYaw = e.Position, e.yaw
+	ps.nextSyncTime = now.Add(ps.interval)
+	return flag
+}
+
+// yawDiff returns the angle between two yaws in degrees, in range [0, 180]
+func yawDiff(yaw1, yaw2 Yaw) Yaw {
+	diff := math.Mod(math.Abs(float64(yaw1-yaw2)), 360)
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return Yaw(diff)
+}
@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPositionSyncThreshold(t *testing.T) {
+	e := &Entity{}
+	e.SetPositionSyncThreshold(1, 10)
+	now := time.Now()
+
+	e.Position = Vector3{0.5, 0, 0}
+	e.yaw = 355
+	if flag := e.filterPositionSync(sifSyncNeighborClients|sifAckInputSeq, now); flag != sifAckInputSeq {
+		t.Fatalf("small movement should not be synced: %v", flag)
+	}
+
+	e.Position = Vector3{1.5, 0, 0}
+	if flag := e.filterPositionSync(sifSyncNeighborClients, now); flag != sifSyncNeighborClients {
+		t.Fatalf("movement over threshold should be synced: %v", flag)
+	}
+
+	e.yaw = 10 // rotated 15 degrees across 0
+	if flag := e.filterPositionSync(sifSyncOwnClient, now); flag != sifSyncOwnClient {
+		t.Fatalf("rotation over threshold should be synced: %v", flag)
+	}
+
+	e.SetPositionSyncThreshold(0, 0)
+	if e.posSync != nil {
+		t.Fatalf("position sync control should be reset")
+	}
+}
+
+func TestPositionSyncInterval(t *testing.T) {
+	e := &Entity{}
+	e.SetPositionSyncInterval(time.Second)
+	now := time.Now()
+
+	e.Position = Vector3{1, 0, 0}
+	if flag := e.filterPositionSync(sifSyncNeighborClients, now); flag != sifSyncNeighborClients {
+		t.Fatalf("first movement should be synced: %v", flag)
+	}
+
+	e.Position = Vector3{2, 0, 0}
+	if flag := e.filterPositionSync(sifSyncNeighborClients, now.Add(time.Millisecond*500)); flag != 0 {
+		t.Fatalf("movement should not be synced before interval: %v", flag)
+	}
+	if e.syncInfoFlag != sifSyncNeighborClients {
+		t.Fatalf("movement should be synced later: %v", e.syncInfoFlag)
+	}
+	if flag := e.filterPositionSync(e.syncInfoFlag, now.Add(time.Second)); flag != sifSyncNeighborClients {
+		t.Fatalf("movement should be synced after interval: %v", flag)
+	}
+}
@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// QueuedCreateOptions are options of CreateEntityAnywhereQueued
+type QueuedCreateOptions struct {
+	Priority   int32                                         // creations of higher priority are placed earlier when games are overloaded
+	Timeout    time.Duration                                 // creation is rejected if not placed before timeout, 0 for creation_queue_timeout_ms of dispatcher
+	OnRejected func(entityID common.EntityID, reason string) // called if the creation is rejected by the dispatcher
+}
+
+type queuedCreationCallback struct {
+	onRejected func(entityID common.EntityID, reason string)
+	deadline   time.Time
+}
+
+const (
+	_QUEUED_CREATION_CALLBACK_EXTRA_TIMEOUT = time.Minute // rejections are expected before timeout, but keep callbacks longer
+)
+
+var (
+	queuedCreationCallbacks = map[common.EntityID]*queuedCreationCallback{}
+)
+
+// CreateEntityAnywhereQueued creates new entity in any game which is not overloaded
+//
+// The creation waits in the creation queue of the dispatcher if all games are overloaded, and OnRejected is called if
+// the creation times out or the queue is full.
+func CreateEntityAnywhereQueued(typeName string, opts QueuedCreateOptions) common.EntityID {
+	if opts.Timeout < 0 {
+		gwlog.Panicf("CreateEntityAnywhereQueued: invalid timeout: %s", opts.Timeout)
+	}
+
+	entityid := common.GenEntityID()
+	if opts.OnRejected != nil {
+		now := time.Now()
+		for eid, cb := range queuedCreationCallbacks {
+			if now.After(cb.deadline) {
+				delete(queuedCreationCallbacks, eid)
+			}
+		}
+
+		timeout := opts.Timeout
+		if timeout == 0 {
+			timeout = time.Minute
+		}
+		queuedCreationCallbacks[entityid] = &queuedCreationCallback{
+			onRejected: opts.OnRejected,
+			deadline:   now.Add(timeout + _QUEUED_CREATION_CALLBACK_EXTRA_TIMEOUT),
+		}
+	}
+
+	dispatchercluster.SendCreateEntityAnywhereQueued(entityid, typeName, opts.Priority, uint32(opts.Timeout/time.Millisecond), nil)
+	return entityid
+}
+
+// OnCreateEntityRejected is called when the dispatcher rejects the creation requested by CreateEntityAnywhereQueued
+func OnCreateEntityRejected(entityid common.EntityID, reason string) {
+	gwlog.Warnf("Creation of entity %s is rejected: %s", entityid, reason)
+	cb := queuedCreationCallbacks[entityid]
+	if cb == nil {
+		return
+	}
+
+	delete(queuedCreationCallbacks, entityid)
+	gwutils.RunPanicless(func() {
+		cb.onRejected(entityid, reason)
+	})
+}
@@ -0,0 +1,43 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+var errDestroyedByReconciliation = errors.New("destroyed as stale copy by entity reconciliation")
+
+// ReconcileEntities sends all entities on the game to dispatchers for reconciliation
+func ReconcileEntities() {
+	eids := make([]common.EntityID, 0, len(entityManager.entities))
+	for eid := range entityManager.entities {
+		eids = append(eids, eid)
+	}
+	dispatchercluster.SendReconcileEntities(eids)
+}
+
+// DestroyStaleEntities destroys stale copies of entities found by dispatchers in reconciliation
+//
+// Stale copies are destroyed quietly: they are not saved, and dispatchers and clients are not notified,
+// since the entity and its client are owned by another copy of the entity.
+func DestroyStaleEntities(eids []common.EntityID) {
+	for _, eid := range eids {
+		e := entityManager.get(eid)
+		if e == nil || e.destroyed || e.IsSpaceEntity() {
+			continue
+		}
+
+		gwlog.Warnf("%s is a stale copy, destroying ...", e)
+		e.fence(errDestroyedByReconciliation)
+		e.Space.leave(e)
+		gwutils.RunPanicless(e.I.OnDestroy)
+		e.clearRawTimers()
+		e.rawTimers = nil // prohibit further use
+		e.assignClient(nil)
+		e.destroyed = true
+		entityManager.del(e)
+	}
+}
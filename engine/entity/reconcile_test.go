@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type TestStaleEntity struct {
+	Entity
+	destroyed bool
+}
+
+func (e *TestStaleEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestStaleEntity) OnDestroy() {
+	e.destroyed = true
+}
+
+func init() {
+	RegisterEntity("TestStaleEntity", &TestStaleEntity{}, false)
+}
+
+func TestDestroyStaleEntities(t *testing.T) {
+	stale := CreateEntityLocally("TestStaleEntity", nil)
+	kept := CreateEntityLocally("TestStaleEntity", nil)
+	stale.Space, kept.Space = &Space{}, &Space{} // the nil space is not created in tests
+	DestroyStaleEntities([]common.EntityID{stale.ID, common.GenEntityID()})
+
+	if !stale.IsDestroyed() || !stale.I.(*TestStaleEntity).destroyed || entityManager.get(stale.ID) != nil {
+		t.Fatalf("stale copy should be destroyed")
+	}
+	if !stale.IsFenced() {
+		t.Fatalf("stale copy should be fenced, so that it does not overwrite data saved by the owner")
+	}
+	if kept.IsDestroyed() || entityManager.get(kept.ID) == nil {
+		t.Fatalf("other entities should not be destroyed")
+	}
+}
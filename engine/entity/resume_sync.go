@@ -0,0 +1,246 @@
+package entity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+// Resume diff sync
+//
+// If resume diff sync is enabled, changes sent to clients are stamped with increasing sync versions: each client
+// records the versions at which entities are created on it and recent entities destroyed on it, and each entity
+// records the versions of the last changes of its client attributes. After changes are flushed to clients in each sync
+// interval, the current sync version is sent to gates of clients which received changes, so the gate knows the last
+// version delivered to a client before it is disconnected. When the client resumes its session, the gate sends the
+// version back, and only entities created or destroyed, client attributes changed and positions during the
+// disconnection are synced to the client, instead of creating all entities on the client again.
+
+const (
+	_MAX_CLIENT_DESTROY_LOG = 256 // recent destroys kept for each client, older destroys require full re-sync
+)
+
+var (
+	resumeDiffSync     bool
+	resumeSyncGameID   uint16
+	syncVersion        = uint64(time.Now().UnixNano()) // versions are increasing across restarts of the game
+	syncVersionClients = map[*GameClient]struct{}{}    // clients which received changes since the last sync version sent
+	resumeSyncs        = metrics.NewCounterVec("goworld_resume_syncs_total", "Syncs of resumed clients, mode is diff or full", "mode")
+)
+
+// clientSyncState records entities known by the client for syncing the client after resuming
+type clientSyncState struct {
+	base         uint64                     // sync version when the client is tracked, earlier versions require full re-sync
+	created      map[common.EntityID]uint64 // entities created on the client with sync versions
+	destroyed    []clientDestroy            // recent entities destroyed on the client
+	destroyFloor uint64                     // destroys until this version are dropped from the log
+}
+
+type clientDestroy struct {
+	id       common.EntityID
+	typeName string
+	version  uint64
+}
+
+// resumeSyncPlan is what should be synced to the resumed client
+type resumeSyncPlan struct {
+	destroyed []clientDestroy // entities destroyed during disconnection
+	created   []*Entity       // entities created or restored during disconnection
+	changed   []attrSyncDiff  // entities with client attributes changed during disconnection
+	synced    []*Entity       // entities known by the client, whose positions are synced again
+}
+
+type attrSyncDiff struct {
+	entity *Entity
+	keys   []string // changed or deleted root attributes
+}
+
+// SetResumeDiffSync enables or disables syncing only changes during disconnection to resumed clients of the game
+func SetResumeDiffSync(enabled bool, gameid uint16) {
+	resumeDiffSync = enabled
+	resumeSyncGameID = gameid
+}
+
+func nextSyncVersion() uint64 {
+	syncVersion += 1
+	return syncVersion
+}
+
+// stampAttrSync records the sync version of the change of the client attribute at path (from owner, in reverse order)
+// and key
+func (e *Entity) stampAttrSync(flag attrFlag, path []interface{}, key interface{}) {
+	if !resumeDiffSync || flag&(afClient|afAllClient) == 0 {
+		return
+	}
+
+	root := key
+	if len(path) > 0 {
+		root = path[len(path)-1]
+	}
+	rootKey, ok := root.(string)
+	if !ok {
+		return
+	}
+	if e.attrSyncVersions == nil {
+		e.attrSyncVersions = map[string]uint64{}
+	}
+	e.attrSyncVersions[rootKey] = nextSyncVersion()
+}
+
+func (client *GameClient) getSyncState() *clientSyncState {
+	if client.syncState == nil {
+		client.syncState = &clientSyncState{
+			base:    nextSyncVersion(),
+			created: map[common.EntityID]uint64{},
+		}
+	}
+	return client.syncState
+}
+
+// trackCreated records the entity created on the client
+func (client *GameClient) trackCreated(entity *Entity) {
+	if !resumeDiffSync {
+		return
+	}
+	client.getSyncState().created[entity.ID] = nextSyncVersion()
+}
+
+// trackDestroyed records the entity destroyed on the client
+func (client *GameClient) trackDestroyed(entity *Entity) {
+	if !resumeDiffSync {
+		return
+	}
+	st := client.getSyncState()
+	delete(st.created, entity.ID)
+	if len(st.destroyed) == _MAX_CLIENT_DESTROY_LOG {
+		st.destroyFloor = st.destroyed[0].version
+		copy(st.destroyed, st.destroyed[1:])
+		st.destroyed = st.destroyed[:len(st.destroyed)-1]
+	}
+	st.destroyed = append(st.destroyed, clientDestroy{entity.ID, entity.TypeName, nextSyncVersion()})
+}
+
+// markSyncVersionDirty marks the client to receive the sync version after changes are flushed
+func (client *GameClient) markSyncVersionDirty() {
+	if resumeDiffSync {
+		syncVersionClients[client] = struct{}{}
+	}
+}
+
+// sendClientSyncVersions sends the current sync version to gates of clients which received changes
+func sendClientSyncVersions() {
+	if len(syncVersionClients) == 0 {
+		return
+	}
+
+	FlushAttrPatches() // pending changes are stamped with earlier versions
+	for client := range syncVersionClients {
+		if client.ownerid != "" {
+			client.selectDispatcher().SendSetClientSyncVersion(client.gateid, client.clientid, resumeSyncGameID, syncVersion)
+		}
+	}
+	syncVersionClients = map[*GameClient]struct{}{}
+}
+
+// syncResumedClient syncs changes since the acked sync version to the resumed client, or creates all entities on the
+// client again if changes since the version are unknown
+func (e *Entity) syncResumedClient(ackedVersion uint64) {
+	plan := e.diffClientSync(ackedVersion)
+	if plan == nil {
+		resumeSyncs.With("full").Inc()
+		e.createEntitiesOnClient(e.client)
+		return
+	}
+
+	resumeSyncs.With("diff").Inc()
+	client := e.client
+	for _, d := range plan.destroyed {
+		client.send(func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendDestroyEntityOnClient(client.gateid, client.clientid, d.typeName, d.id)
+		})
+	}
+	for _, entity := range plan.created {
+		client.sendCreateEntity(entity, entity == e)
+	}
+	for _, diff := range plan.changed {
+		keys := common.StringSet{}
+		for _, key := range diff.keys {
+			keys.Add(key)
+		}
+		data := diff.entity.Attrs.toMapWithFilterPooled(keys.Contains)
+		for _, key := range diff.keys {
+			if val, ok := data[key]; ok {
+				client.sendNotifyMapAttrChange(diff.entity.ID, nil, key, val)
+			} else {
+				client.sendNotifyMapAttrDel(diff.entity.ID, nil, key)
+			}
+		}
+		releaseAttrMap(data)
+	}
+	for _, entity := range plan.synced {
+		syncInfo := entity.getSyncInfo()
+		packet := getEntitySyncInfosPacket(client.gateid)
+		packet.AppendClientID(client.clientid)
+		packet.AppendEntityID(entity.ID)
+		packet.AppendFloat32(syncInfo.X)
+		packet.AppendFloat32(syncInfo.Y)
+		packet.AppendFloat32(syncInfo.Z)
+		packet.AppendFloat32(syncInfo.Yaw)
+	}
+}
+
+// diffClientSync returns what should be synced to the client of the entity since the acked sync version, or nil if
+// changes since the version are unknown
+func (e *Entity) diffClientSync(ackedVersion uint64) *resumeSyncPlan {
+	client := e.client
+	if !resumeDiffSync || client == nil || client.syncState == nil || ackedVersion == 0 {
+		return nil
+	}
+	st := client.syncState
+	if ackedVersion < st.base || ackedVersion < st.destroyFloor || ackedVersion > syncVersion {
+		return nil
+	}
+
+	plan := &resumeSyncPlan{}
+	for _, d := range st.destroyed {
+		if _, ok := st.created[d.id]; !ok && d.version > ackedVersion {
+			plan.destroyed = append(plan.destroyed, d)
+		}
+	}
+
+	diff := func(entity *Entity, isPlayer bool) {
+		createdVersion, ok := st.created[entity.ID]
+		if !ok || createdVersion > ackedVersion || entity.syncBase > ackedVersion {
+			plan.created = append(plan.created, entity)
+			return
+		}
+
+		plan.synced = append(plan.synced, entity)
+		clientAttrs := entity.typeDesc.allClientAttrs
+		if isPlayer {
+			clientAttrs = entity.typeDesc.clientAttrs
+		}
+		var keys []string
+		for key, version := range entity.attrSyncVersions {
+			if version > ackedVersion && clientAttrs.Contains(key) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			sort.Strings(keys)
+			plan.changed = append(plan.changed, attrSyncDiff{entity, keys})
+		}
+	}
+
+	diff(e, true)
+	if e.Space != nil && !e.Space.IsNil() {
+		diff(&e.Space.Entity, false)
+	}
+	for neighbor := range e.InterestedIn {
+		diff(neighbor, false)
+	}
+	return plan
+}
@@ -0,0 +1,84 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+type TestResumeSyncEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestResumeSyncEntity", &TestResumeSyncEntity{}, false)
+}
+
+func (e *TestResumeSyncEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("hp", "AllClients")
+	desc.DefineAttr("gold", "Client")
+	desc.DefineAttr("temp")
+}
+
+func TestResumeDiffSync(t *testing.T) {
+	SetResumeDiffSync(true, 1)
+	defer SetResumeDiffSync(false, 0)
+
+	owner := CreateEntityLocally("TestResumeSyncEntity", nil)
+	kept := CreateEntityLocally("TestResumeSyncEntity", nil)
+	left := CreateEntityLocally("TestResumeSyncEntity", nil)
+	entered := CreateEntityLocally("TestResumeSyncEntity", nil)
+	owner.Attrs.SetInt("hp", 1)
+
+	client := &GameClient{clientid: "TestResumeClient", ownerid: owner.ID}
+	for _, e := range []*Entity{owner, kept, left} {
+		client.trackCreated(e)
+	}
+	owner.InterestedIn.Add(kept)
+	owner.InterestedIn.Add(left)
+	acked := syncVersion // the client is disconnected after this version
+
+	owner.Attrs.SetInt("gold", 10)
+	owner.Attrs.SetInt("temp", 1) // not synced to clients
+	kept.Attrs.SetInt("hp", 5)
+	kept.Attrs.SetInt("gold", 5) // not synced to clients of other entities
+	owner.InterestedIn.Del(left)
+	client.trackDestroyed(left)
+	owner.InterestedIn.Add(entered)
+	client.trackCreated(entered)
+
+	owner.client = client
+	defer func() {
+		owner.client = nil
+	}()
+
+	plan := owner.diffClientSync(acked)
+	if plan == nil {
+		t.Fatalf("changes since the acked version should be known")
+	}
+	if len(plan.destroyed) != 1 || plan.destroyed[0].id != left.ID {
+		t.Errorf("wrong destroyed entities: %+v", plan.destroyed)
+	}
+	if !reflect.DeepEqual(plan.created, []*Entity{entered}) {
+		t.Errorf("wrong created entities: %v", plan.created)
+	}
+	if !reflect.DeepEqual(plan.synced, []*Entity{owner, kept}) {
+		t.Errorf("wrong synced entities: %v", plan.synced)
+	}
+	expected := []attrSyncDiff{{owner, []string{"gold"}}, {kept, []string{"hp"}}}
+	if !reflect.DeepEqual(plan.changed, expected) {
+		t.Errorf("wrong changed attributes: %+v", plan.changed)
+	}
+
+	if plan := owner.diffClientSync(client.syncState.base - 1); plan != nil {
+		t.Errorf("changes before the client is tracked should be unknown")
+	}
+	if plan := owner.diffClientSync(syncVersion + 1); plan != nil {
+		t.Errorf("changes since a future version should be unknown")
+	}
+	for i := 0; i < _MAX_CLIENT_DESTROY_LOG; i++ {
+		client.trackDestroyed(left)
+	}
+	if plan := owner.diffClientSync(acked); plan != nil {
+		t.Errorf("changes should be unknown after destroys are dropped from the log")
+	}
+}
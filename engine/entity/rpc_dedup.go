@@ -0,0 +1,43 @@
+package entity
+
+import (
+	"expvar"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	duplicateRPCsDropped = expvar.NewInt("DuplicateRPCsDropped")
+	rpcAcks              = proto.NewRPCAcks()
+)
+
+// OnCallWithSeq is called by engine when a numbered RPC reaches the game, replays of RPCs applied by the entity are dropped
+//
+// Applied RPCs are remembered by the entity and migrated with it, so that replays reaching the game which the entity
+// migrates to are also dropped.
+func OnCallWithSeq(id common.EntityID, method string, args [][]byte, src proto.RPCSource, seq uint64, ackFloor uint64) {
+	rpcAcks.Add(src, seq) // replays are acked again, since the ack might be lost
+
+	e := entityManager.get(id)
+	if e == nil {
+		OnCall(id, method, args, "")
+		return
+	}
+
+	if e.rpcDedup == nil {
+		e.rpcDedup = proto.NewRPCDedup()
+	}
+	if !e.rpcDedup.Accept(src, seq, ackFloor) {
+		duplicateRPCsDropped.Add(1)
+		gwlog.Warnf("%s: duplicate RPC %s from game %d (dispatcher %d, seq %d) is dropped", e, method, src.GameID, src.DispatcherID, seq)
+		return
+	}
+	e.onCallFromRemote(method, args, "")
+}
+
+// TakeRPCAcks returns sequence numbers of numbered RPCs received since the last call, to be acked to senders
+func TakeRPCAcks() map[proto.RPCSource][]uint64 {
+	return rpcAcks.Take()
+}
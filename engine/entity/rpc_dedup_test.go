@@ -0,0 +1,67 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type TestRPCDedupEntity struct {
+	Entity
+	calls int
+}
+
+func init() {
+	RegisterEntity("TestRPCDedupEntity", &TestRPCDedupEntity{}, false)
+}
+
+func (e *TestRPCDedupEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestRPCDedupEntity) Count() {
+	e.calls += 1
+}
+
+// migrateForTest migrates the entity out and in the game, and returns the migrated entity
+func migrateForTest(t *testing.T, e *Entity) *Entity {
+	data, err := netutil.MSG_PACKER.PackMsg(e.GetMigrateData(common.GenEntityID()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.destroyEntity(true)
+	OnRealMigrate(e.ID, data)
+	return entityManager.get(e.ID)
+}
+
+func TestRPCDedupMigrate(t *testing.T) {
+	e := CreateEntityLocally("TestRPCDedupEntity", nil)
+	e.Space = &Space{}
+	src := proto.RPCSource{GameID: 1, DispatcherID: 1, Epoch: 1}
+
+	OnCallWithSeq(e.ID, "Count", nil, src, 1, 0)
+	OnCallWithSeq(e.ID, "Count", nil, src, 1, 0)
+	if calls := e.I.(*TestRPCDedupEntity).calls; calls != 1 {
+		t.Fatalf("replayed RPC should be dropped: %d calls", calls)
+	}
+
+	e = migrateForTest(t, e)
+	if e == nil {
+		t.Fatalf("entity is not migrated")
+	}
+	e.Space = &Space{}
+	// the RPC is replayed to the game which the entity migrates to
+	OnCallWithSeq(e.ID, "Count", nil, src, 1, 0)
+	if calls := e.I.(*TestRPCDedupEntity).calls; calls != 0 {
+		t.Fatalf("RPC replayed after migration should be dropped: %d calls", calls)
+	}
+	OnCallWithSeq(e.ID, "Count", nil, src, 2, 0)
+	if calls := e.I.(*TestRPCDedupEntity).calls; calls != 1 {
+		t.Fatalf("new RPC after migration should be applied: %d calls", calls)
+	}
+
+	if acks := TakeRPCAcks()[src]; len(acks) != 4 {
+		t.Fatalf("all received RPCs should be acked: %v", acks)
+	}
+}
@@ -0,0 +1,220 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// RPC with results
+//
+// CallWithResult calls the entity method and delivers the return values of the method to the callback on the game
+// routine. If the type of the last return value is error, it is not a result: a non-nil error fails the call. Calls fail
+// with RPCError if the entity is not found, the method panics or no result is received within the timeout. Results are
+// always msgpacked, so they are of the same types whether the entity is local or not.
+
+// RPCCallback receives results of the method called by CallWithResult, or the error if the call fails
+type RPCCallback func(results []interface{}, err error)
+
+// RPCError is the error of failed calls by CallWithResult
+type RPCError struct {
+	Code    proto.RPCErrorCode
+	Message string
+}
+
+func (err *RPCError) Error() string {
+	return fmt.Sprintf("rpc %s: %s", err.Code, err.Message)
+}
+
+type pendingRPCCall struct {
+	caller   *Entity // the callback is dropped if the caller is destroyed, nil if not called by entity
+	callback RPCCallback
+	timer    *timer.Timer
+}
+
+var (
+	lastRPCCallID   uint32
+	pendingRPCCalls = map[uint32]*pendingRPCCall{}
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// CallWithResult calls the entity method, results or the error are delivered to the callback
+func CallWithResult(id common.EntityID, method string, args []interface{}, timeout time.Duration, callback RPCCallback) {
+	callWithResult(nil, id, method, args, timeout, callback)
+}
+
+// CallWithResult calls other entities with results, the callback is not called if the entity is destroyed before
+func (e *Entity) CallWithResult(id common.EntityID, method string, timeout time.Duration, callback RPCCallback, args ...interface{}) {
+	callWithResult(e, id, method, args, timeout, callback)
+}
+
+func callWithResult(caller *Entity, id common.EntityID, method string, args []interface{}, timeout time.Duration, callback RPCCallback) {
+	if timeout <= 0 {
+		gwlog.Panicf("CallWithResult %s.%s: timeout must be positive, but is %s", id, method, timeout)
+	}
+
+	lastRPCCallID++
+	callID := lastRPCCallID
+	call := &pendingRPCCall{caller: caller, callback: callback}
+	call.timer = timer.AddCallback(timeout, func() {
+		OnCallResult(callID, proto.RPC_ERROR_TIMEOUT, fmt.Sprintf("%s.%s has no result in %s", id, method, timeout), nil)
+	})
+	pendingRPCCalls[callID] = call
+
+	if consts.OPTIMIZE_LOCAL_ENTITY_CALL && entityManager.get(id) != nil {
+		packedArgs, err := packRPCValues(args)
+		post.Post(func() {
+			if err != nil {
+				OnCallResult(callID, proto.RPC_ERROR_INVALID_METHOD, err.Error(), nil)
+				return
+			}
+			results, code, message := callEntityWithResult(id, method, packedArgs)
+			OnCallResult(callID, code, message, results)
+		})
+	} else {
+		dispatchercluster.SendCallEntityMethodWithResult(id, method, args, callID)
+	}
+}
+
+// OnCallWithResult is called by engine when method call with result reaches in the game
+func OnCallWithResult(id common.EntityID, method string, args [][]byte, srcGameID uint16, callID uint32) {
+	results, code, message := callEntityWithResult(id, method, args)
+	dispatchercluster.SendCallEntityMethodResult(id, srcGameID, callID, code, message, results)
+}
+
+// OnCallResult is called by engine when result of the call by CallWithResult is received
+func OnCallResult(callID uint32, code proto.RPCErrorCode, message string, results [][]byte) {
+	call := pendingRPCCalls[callID]
+	if call == nil {
+		gwlog.Warnf("OnCallResult: call %d is not found, result might be received after timeout", callID)
+		return
+	}
+
+	delete(pendingRPCCalls, callID)
+	call.timer.Cancel()
+	if call.caller != nil && call.caller.IsDestroyed() {
+		return
+	}
+	if call.caller != nil {
+		defer call.caller.enterMethod()()
+	}
+
+	var values []interface{}
+	var err error
+	if code != proto.RPC_OK {
+		err = &RPCError{Code: code, Message: message}
+	} else if values, err = unpackRPCValues(results); err != nil {
+		err = &RPCError{Code: proto.RPC_ERROR_INVALID_METHOD, Message: err.Error()}
+	}
+	gwutils.RunPanicless(func() {
+		call.callback(values, err)
+	})
+}
+
+func callEntityWithResult(id common.EntityID, method string, args [][]byte) ([][]byte, proto.RPCErrorCode, string) {
+	e := entityManager.get(id)
+	if e == nil {
+		return nil, proto.RPC_ERROR_ENTITY_NOT_FOUND, fmt.Sprintf("entity %s is not found while calling %s", id, method)
+	}
+
+	results, code, message := e.onCallWithResult(method, args)
+	if code != proto.RPC_OK {
+		return nil, code, message
+	}
+
+	packedResults, err := packRPCValues(results)
+	if err != nil {
+		return nil, proto.RPC_ERROR_INVALID_METHOD, fmt.Sprintf("%s.%s returned results which can not be packed: %s", e, method, err)
+	}
+	return packedResults, proto.RPC_OK, ""
+}
+
+func (e *Entity) onCallWithResult(methodName string, args [][]byte) (results []interface{}, code proto.RPCErrorCode, message string) {
+	defer e.traceMethod(methodName)()
+	defer e.enterMethod()()
+	startTime := time.Now()
+	defer func() {
+		e.addLogicTime(time.Since(startTime))
+		e.observeRPCDuration(methodName, time.Since(startTime))
+		err := recover() // panics during RPC call are sent back to the caller
+		if err != nil {
+			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			results, code, message = nil, proto.RPC_ERROR_PANIC, fmt.Sprintf("%s.%s paniced: %v", e, methodName, err)
+		}
+	}()
+
+	if e.fenced {
+		return nil, proto.RPC_ERROR_ENTITY_NOT_FOUND, fmt.Sprintf("%s is fenced", e)
+	}
+
+	rpcDesc := e.typeDesc.rpcDescs[methodName]
+	if rpcDesc == nil || rpcDesc.Flags&rfServer == 0 {
+		return nil, proto.RPC_ERROR_INVALID_METHOD, fmt.Sprintf("%s.%s is not a valid RPC", e, methodName)
+	}
+	if rpcDesc.NumArgs < len(args) {
+		return nil, proto.RPC_ERROR_INVALID_METHOD, fmt.Sprintf("%s.%s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
+	}
+
+	methodType := rpcDesc.MethodType
+	in := make([]reflect.Value, rpcDesc.NumArgs+1)
+	in[0] = e.V // first argument is the bind instance (self)
+
+	for i, arg := range args {
+		argType := methodType.In(i + 1)
+		argValPtr := reflect.New(argType)
+		if err := netutil.MSG_PACKER.UnpackMsg(arg, argValPtr.Interface()); err != nil {
+			return nil, proto.RPC_ERROR_INVALID_METHOD, fmt.Sprintf("%s.%s: convert argument %d failed: %s", e, methodName, i+1, err)
+		}
+		in[i+1] = reflect.Indirect(argValPtr)
+	}
+
+	for i := len(args); i < rpcDesc.NumArgs; i++ { // use zero value for missing arguments
+		in[i+1] = reflect.Zero(methodType.In(i + 1))
+	}
+
+	out := rpcDesc.Func.Call(in)
+	if n := len(out); n > 0 && methodType.Out(n-1) == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return nil, proto.RPC_ERROR_RETURNED, err.Error()
+		}
+		out = out[:n-1]
+	}
+
+	results = make([]interface{}, len(out))
+	for i, v := range out {
+		results[i] = v.Interface()
+	}
+	return results, proto.RPC_OK, ""
+}
+
+func packRPCValues(values []interface{}) ([][]byte, error) {
+	packed := make([][]byte, len(values))
+	for i, v := range values {
+		data, err := netutil.MSG_PACKER.PackMsg(v, nil)
+		if err != nil {
+			return nil, err
+		}
+		packed[i] = data
+	}
+	return packed, nil
+}
+
+func unpackRPCValues(packed [][]byte) ([]interface{}, error) {
+	values := make([]interface{}, len(packed))
+	for i, data := range packed {
+		if err := netutil.MSG_PACKER.UnpackMsg(data, &values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/typeconv"
+)
+
+type TestRPCResultEntity struct {
+	Entity
+}
+
+func (e *TestRPCResultEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestRPCResultEntity) Add(a, b int) (int, string) {
+	return a + b, "sum"
+}
+
+func (e *TestRPCResultEntity) Divide(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("divided by zero")
+	}
+	return a / b, nil
+}
+
+func (e *TestRPCResultEntity) Panic(a, b int) {
+	panic("test panic")
+}
+
+func callWithResultForTest(t *testing.T, caller *Entity, callee *Entity, method string, args ...interface{}) (results []interface{}, err error) {
+	called := false
+	callWithResult(caller, callee.ID, method, args, time.Second, func(_results []interface{}, _err error) {
+		results, err, called = _results, _err, true
+	})
+	if called {
+		t.Fatalf("callback should not be called before the call returns")
+	}
+	post.Tick()
+	if !called {
+		t.Fatalf("callback of %s is not called", method)
+	}
+	return
+}
+
+func TestCallWithResult(t *testing.T) {
+	RegisterEntity("TestRPCResultEntity", &TestRPCResultEntity{}, false)
+	e := CreateEntityLocally("TestRPCResultEntity", nil)
+
+	results, err := callWithResultForTest(t, nil, e, "Add", 1, 2)
+	if err != nil || len(results) != 2 || typeconv.Int(results[0]) != 3 || results[1] != "sum" {
+		t.Errorf("wrong results of Add: %v, %v", results, err)
+	}
+
+	results, err = callWithResultForTest(t, nil, e, "Divide", 6, 3)
+	if err != nil || len(results) != 1 || typeconv.Int(results[0]) != 2 {
+		t.Errorf("wrong results of Divide: %v, %v", results, err)
+	}
+
+	for method, code := range map[string]proto.RPCErrorCode{
+		"Divide":   proto.RPC_ERROR_RETURNED,
+		"Panic":    proto.RPC_ERROR_PANIC,
+		"NotFound": proto.RPC_ERROR_INVALID_METHOD,
+	} {
+		_, err := callWithResultForTest(t, nil, e, method, 1, 0)
+		if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != code {
+			t.Errorf("%s should fail with %s, but got %v", method, code, err)
+		}
+	}
+
+	if len(pendingRPCCalls) != 0 {
+		t.Errorf("pending calls are not cleared: %v", pendingRPCCalls)
+	}
+
+	callWithResult(nil, e.ID, "Add", []interface{}{1, 2}, time.Millisecond, func(_ []interface{}, _err error) {
+		err = _err
+	})
+	for callID := range pendingRPCCalls {
+		OnCallResult(callID, proto.RPC_ERROR_TIMEOUT, "timeout", nil) // as if the timer fired
+	}
+	post.Tick() // results after timeout are dropped
+	if rpcErr, ok := err.(*RPCError); !ok || rpcErr.Code != proto.RPC_ERROR_TIMEOUT {
+		t.Errorf("call should time out, but got %v", err)
+	}
+}
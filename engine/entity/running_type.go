@@ -0,0 +1,20 @@
+package entity
+
+var (
+	runningEntityType string
+)
+
+// RunningEntityType returns the type of the entity whose method is running on the game routine, or "" if no entity
+// method is running, which is used to attribute calls to their callers
+func RunningEntityType() string {
+	return runningEntityType
+}
+
+// enterMethod sets the running entity type to the type of the entity, and returns the function to restore it
+func (e *Entity) enterMethod() func() {
+	prev := runningEntityType
+	runningEntityType = e.TypeName
+	return func() {
+		runningEntityType = prev
+	}
+}
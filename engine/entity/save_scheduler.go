@@ -0,0 +1,141 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Time-sliced saving
+//
+// Persistent entities are not saved by timers of their own, which fire together and save all entities at once when
+// many entities are created or loaded at the same time. Instead, entities are kept in a save ring for each save
+// interval, and TickSave saves the share of entities of each ring at every tick, so that each entity is saved once per
+// interval, and saving is spread evenly across the interval.
+
+type saveRing struct {
+	interval time.Duration // 0 for the save interval of the game
+	entities []*Entity     // removed entities are left nil and compacted when a round is finished
+	cursor   int           // next entity to be saved
+	due      float64       // entities to be saved but not saved yet
+	removed  int
+}
+
+var (
+	saveRings    = map[time.Duration]*saveRing{}
+	lastSaveTick time.Time
+)
+
+// SetSaveInterval sets the save interval of entities of this type, which overrides the save interval of the game
+//
+// Entities of this type use the save interval of the game if interval is 0.
+func (desc *EntityTypeDesc) SetSaveInterval(interval time.Duration) *EntityTypeDesc {
+	if interval < 0 {
+		gwlog.Panicf("save interval < 0")
+	}
+
+	desc.saveInterval = interval
+	return desc
+}
+
+// SetSaveInterval sets the save interval for entity system
+//
+// Entities in save rings are saved by the new save interval from the next tick.
+func SetSaveInterval(duration time.Duration) {
+	if duration == saveInterval {
+		return
+	}
+
+	saveInterval = duration
+	gwlog.Infof("Save interval set to %s", saveInterval)
+}
+
+// TickSave saves the share of persistent entities due at this tick, called by engine at every tick
+func TickSave() {
+	now := time.Now()
+	if lastSaveTick.IsZero() {
+		lastSaveTick = now
+		return
+	}
+
+	elapsed := now.Sub(lastSaveTick)
+	lastSaveTick = now
+	for _, ring := range saveRings {
+		interval := ring.interval
+		if interval == 0 {
+			interval = saveInterval
+		}
+		ring.tick(elapsed, interval)
+	}
+}
+
+// scheduleSave puts the entity to the save ring of its type, so that it is saved periodically
+func (e *Entity) scheduleSave() {
+	ring := saveRings[e.typeDesc.saveInterval]
+	if ring == nil {
+		ring = &saveRing{interval: e.typeDesc.saveInterval}
+		saveRings[ring.interval] = ring
+	}
+
+	e.saveRing, e.saveIndex = ring, len(ring.entities)
+	ring.entities = append(ring.entities, e)
+}
+
+// unscheduleSave removes the entity from the save ring
+func (e *Entity) unscheduleSave() {
+	if e.saveRing == nil {
+		return
+	}
+
+	e.saveRing.entities[e.saveIndex] = nil
+	e.saveRing.removed++
+	e.saveRing = nil
+}
+
+func (ring *saveRing) tick(elapsed time.Duration, interval time.Duration) {
+	if interval <= 0 || len(ring.entities) == 0 {
+		return
+	}
+
+	ring.due += float64(len(ring.entities)) * float64(elapsed) / float64(interval)
+	if ring.due > float64(len(ring.entities)) {
+		ring.due = float64(len(ring.entities)) // at most one round at a tick after the game is blocked for a long time
+	}
+
+	for ; ring.due >= 1; ring.due-- {
+		if ring.cursor >= len(ring.entities) {
+			ring.compact()
+			if len(ring.entities) == 0 {
+				ring.due = 0
+				return
+			}
+		}
+
+		e := ring.entities[ring.cursor]
+		ring.cursor++
+		if e != nil {
+			e.Save()
+		}
+	}
+}
+
+// compact removes nil entities and restarts the round
+func (ring *saveRing) compact() {
+	ring.cursor = 0
+	if ring.removed == 0 {
+		return
+	}
+
+	entities := ring.entities[:0]
+	for _, e := range ring.entities {
+		if e != nil {
+			e.saveIndex = len(entities)
+			entities = append(entities, e)
+		}
+	}
+	for i := len(entities); i < len(ring.entities); i++ {
+		ring.entities[i] = nil
+	}
+	ring.entities = entities
+	ring.removed = 0
+}
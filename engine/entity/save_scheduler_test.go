@@ -0,0 +1,72 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+type TestSaveEntity struct {
+	Entity
+}
+
+func (e *TestSaveEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true).SetSaveInterval(time.Minute * 7)
+	desc.DefineAttr("hp", "Persistent")
+}
+
+func init() {
+	RegisterEntity("TestSaveEntity", &TestSaveEntity{}, false)
+}
+
+func TestSaveRing(t *testing.T) {
+	entities := make([]*Entity, 100)
+	for i := range entities {
+		entities[i] = CreateEntityLocally("TestSaveEntity", nil)
+	}
+	ring := saveRings[time.Minute*7]
+	if ring == nil || len(ring.entities) < len(entities) {
+		t.Fatalf("entities are not put to the save ring")
+	}
+	ring.tick(time.Minute*7, time.Minute*7) // save all entities created before
+
+	countDirty := func() (n int) {
+		for _, e := range entities {
+			if e.persistentDirty {
+				n++
+			}
+		}
+		return
+	}
+	for _, e := range entities {
+		e.Attrs.SetInt("hp", 100)
+	}
+
+	// 1/10 of entities are saved every 1/10 of the interval
+	for i := 1; i <= 10; i++ {
+		ring.tick(time.Minute*7/10, time.Minute*7)
+		if dirty := countDirty(); dirty != 100-i*10 {
+			t.Fatalf("%d entities are dirty after %d ticks", dirty, i)
+		}
+	}
+
+	// removed entities are compacted when a round is finished
+	for _, e := range entities[:50] {
+		e.unscheduleSave()
+	}
+	for _, e := range entities[50:] {
+		e.Attrs.SetInt("hp", 200)
+	}
+	size := len(ring.entities)
+	ring.tick(time.Minute*7, time.Minute*7)
+	if len(ring.entities) != size-50 {
+		t.Fatalf("removed entities are not compacted: %d entities in the ring", len(ring.entities))
+	}
+	for i, e := range ring.entities {
+		if e.saveIndex != i {
+			t.Fatalf("wrong save index of %s: %d", e, e.saveIndex)
+		}
+	}
+	if dirty := countDirty(); dirty != 0 {
+		t.Fatalf("%d entities are dirty after a round", dirty)
+	}
+}
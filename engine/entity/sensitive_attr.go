@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// sensitiveAttrPrefix marks attribute values which are encrypted by the sensitive attr key
+	sensitiveAttrPrefix = "$enc$"
+)
+
+var (
+	sensitiveAttrAEAD cipher.AEAD
+)
+
+// SetSensitiveAttrKey sets the key for encrypting Sensitive attributes
+//
+// Sensitive attributes are kept encrypted in Attrs, so they are also encrypted in memory dumps, freeze files,
+// migrate data and entity storage. All games should use the same key, otherwise migrated or loaded entities can not
+// decrypt their sensitive attributes. Sensitive attributes are stored in plain text if key is empty.
+func SetSensitiveAttrKey(key string) {
+	if key == "" {
+		sensitiveAttrAEAD = nil
+		gwlog.Infof("Sensitive attr key is not set, sensitive attributes will be stored in plain text")
+		return
+	}
+
+	aesKey := sha256.Sum256([]byte(key)) // AES-256
+	block, err := aes.NewCipher(aesKey[:])
+	if err != nil {
+		gwlog.Panic(errors.Wrap(err, "create sensitive attr cipher failed"))
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		gwlog.Panic(errors.Wrap(err, "create sensitive attr cipher failed"))
+	}
+	sensitiveAttrAEAD = aead
+	gwlog.Infof("Sensitive attr key is set, sensitive attributes will be encrypted")
+}
+
+func encryptSensitiveAttr(val string) string {
+	if sensitiveAttrAEAD == nil {
+		return val
+	}
+
+	nonce := make([]byte, sensitiveAttrAEAD.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		gwlog.Panic(errors.Wrap(err, "generate nonce failed"))
+	}
+
+	sealed := sensitiveAttrAEAD.Seal(nonce, nonce, []byte(val), nil)
+	return sensitiveAttrPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func decryptSensitiveAttr(val string) (string, error) {
+	if !strings.HasPrefix(val, sensitiveAttrPrefix) {
+		// value is stored in plain text (before sensitive attr key is set)
+		return val, nil
+	}
+
+	if sensitiveAttrAEAD == nil {
+		return "", errors.Errorf("sensitive attr key is not set")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(val[len(sensitiveAttrPrefix):])
+	if err != nil {
+		return "", errors.Wrap(err, "decode sensitive attr failed")
+	}
+
+	nonceSize := sensitiveAttrAEAD.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.Errorf("sensitive attr is too short")
+	}
+
+	plain, err := sensitiveAttrAEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypt sensitive attr failed")
+	}
+	return string(plain), nil
+}
+
+// SetSensitiveStr sets an outtermost Sensitive attribute, the value is encrypted in Attrs
+func (e *Entity) SetSensitiveStr(key string, val string) {
+	if !e.typeDesc.sensitiveAttrs.Contains(key) {
+		gwlog.Panicf("%s.SetSensitiveStr: attribute %s is not Sensitive", e, key)
+	}
+
+	e.Attrs.SetStr(key, encryptSensitiveAttr(val))
+}
+
+// GetSensitiveStr gets an outtermost Sensitive attribute and decrypts it
+func (e *Entity) GetSensitiveStr(key string) string {
+	if !e.typeDesc.sensitiveAttrs.Contains(key) {
+		gwlog.Panicf("%s.GetSensitiveStr: attribute %s is not Sensitive", e, key)
+	}
+
+	val, err := decryptSensitiveAttr(e.Attrs.GetStr(key))
+	if err != nil {
+		gwlog.Panic(errors.Wrapf(err, "%s.GetSensitiveStr: %s", e, key))
+	}
+	return val
+}
@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type TestSensitiveEntity struct {
+	Entity
+}
+
+func (e *TestSensitiveEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("email", "Sensitive")
+}
+
+func TestSensitiveAttr(t *testing.T) {
+	RegisterEntity("TestSensitiveEntity", &TestSensitiveEntity{}, false)
+	e := CreateEntityLocally("TestSensitiveEntity", nil)
+
+	SetSensitiveAttrKey("")
+	e.SetSensitiveStr("email", "foo@bar.com")
+	if e.GetStr("email") != "foo@bar.com" {
+		t.Fatalf("sensitive attr should be plain text without key")
+	}
+
+	SetSensitiveAttrKey("test key")
+	defer SetSensitiveAttrKey("")
+	if e.GetSensitiveStr("email") != "foo@bar.com" {
+		t.Fatalf("plain text sensitive attr should still be readable")
+	}
+
+	e.SetSensitiveStr("email", "foo@bar.com")
+	raw := e.GetStr("email")
+	if !strings.HasPrefix(raw, sensitiveAttrPrefix) || strings.Contains(raw, "foo@bar.com") {
+		t.Fatalf("sensitive attr is not encrypted: %s", raw)
+	}
+	if e.GetSensitiveStr("email") != "foo@bar.com" {
+		t.Fatalf("decrypt sensitive attr failed")
+	}
+
+	md := e.GetMigrateData(common.GenEntityID())
+	if strings.Contains(md.Attrs["email"].(string), "foo@bar.com") {
+		t.Fatalf("migrate data should not contain plain text of sensitive attr")
+	}
+}
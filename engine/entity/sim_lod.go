@@ -0,0 +1,212 @@
+package entity
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+)
+
+// Simulation LOD
+//
+// Entities of types with simulation LOD set (NPCs, monsters, etc.) are demoted to the low simulation tier when no
+// player (entity with client) is near them in the same space, so that the CPU cost of the world does not grow
+// linearly with its population. Demoted entities leave AOI, and timers of demoted entities are coalesced: timers which
+// fire are called together at most once per low tier interval, so AI ticks are reduced accordingly. Entities are
+// promoted back to full simulation when players approach, and pending timers are called at once. Simulation LOD is
+// checked every SIMULATION_LOD_CHECK_INTERVAL, so the promote distance should cover the AOI distance plus the distance
+// players move in the interval.
+
+const (
+	_SIM_LOD_DEMOTE_FACTOR = 1.2 // entities are demoted when no player is within promote distance * factor
+)
+
+var (
+	maxSimLODDistance Coord // max promote distance of all entity types
+	simLODEntities    = metrics.NewGaugeVec("goworld_simulation_demoted_entities", "Entities in the low simulation tier", "type")
+)
+
+// simulationLOD is the state of the entity in the low simulation tier
+type simulationLOD struct {
+	pending   map[EntityTimerID]struct{} // timers fired while demoted
+	tickTimer *timerwheel.Timer
+}
+
+// SetSimulationLOD enables simulation LOD for entities of this type: entities are demoted when no player is within
+// the promote distance in the same space, and timers of demoted entities are called at most once per interval
+func (desc *EntityTypeDesc) SetSimulationLOD(promoteDistance Coord, interval time.Duration) *EntityTypeDesc {
+	if desc.isService {
+		gwlog.Panicf("Service entity must NOT use simulation LOD: %s", desc.entityType.Name())
+	}
+	if promoteDistance <= 0 || interval <= 0 {
+		gwlog.Panicf("invalid simulation LOD: promote distance %v, interval %s", promoteDistance, interval)
+	}
+
+	desc.simLODDistance = promoteDistance
+	desc.simLODInterval = interval
+	if promoteDistance > maxSimLODDistance {
+		maxSimLODDistance = promoteDistance
+	}
+	return desc
+}
+
+// IsSimulationDemoted returns if the entity is in the low simulation tier
+func (e *Entity) IsSimulationDemoted() bool {
+	return e.simLOD != nil
+}
+
+// OnSimulationDemoted is called when the entity is demoted to the low simulation tier
+//
+// Can override this function in custom entity type
+func (e *Entity) OnSimulationDemoted() {
+}
+
+// OnSimulationPromoted is called when the entity is promoted to full simulation
+//
+// Can override this function in custom entity type
+func (e *Entity) OnSimulationPromoted() {
+}
+
+func (e *Entity) demoteSimulation() {
+	if e.Space.aoiMgr != nil && e.IsUseAOI() {
+		e.Space.leaveAOI(e)
+	}
+	e.simLOD = &simulationLOD{
+		pending: map[EntityTimerID]struct{}{},
+	}
+	e.simLOD.tickTimer = e.addRawTimer(e.typeDesc.simLODInterval, e.tickDemotedSimulation)
+	gwutils.RunPanicless(e.I.OnSimulationDemoted)
+}
+
+func (e *Entity) promoteSimulation() {
+	lod := e.simLOD
+	e.simLOD = nil
+	e.cancelRawTimer(lod.tickTimer)
+	if e.Space.aoiMgr != nil && e.IsUseAOI() {
+		e.Space.enterAOI(e)
+	}
+	gwutils.RunPanicless(e.I.OnSimulationPromoted)
+	e.callCoalescedTimers(lod.pending)
+}
+
+func (e *Entity) tickDemotedSimulation() {
+	if len(e.simLOD.pending) == 0 {
+		return
+	}
+	pending := e.simLOD.pending
+	e.simLOD.pending = map[EntityTimerID]struct{}{}
+	e.callCoalescedTimers(pending)
+}
+
+// coalesceTimer defers the fired timer to the next low tier tick if the entity is demoted
+func (e *Entity) coalesceTimer(tid EntityTimerID) bool {
+	if e.simLOD == nil {
+		return false
+	}
+	e.simLOD.pending[tid] = struct{}{}
+	return true
+}
+
+// callCoalescedTimers calls pending timers in the order they are added
+func (e *Entity) callCoalescedTimers(pending map[EntityTimerID]struct{}) {
+	tids := make([]EntityTimerID, 0, len(pending))
+	for tid := range pending {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool {
+		return tids[i] < tids[j]
+	})
+
+	for _, tid := range tids {
+		if e.destroyed {
+			return
+		}
+		timerInfo := e.timers[tid]
+		if timerInfo == nil {
+			continue // cancelled by previous timers
+		}
+		if !timerInfo.Repeat {
+			delete(e.timers, tid)
+		}
+		e.onCallFromLocal(timerInfo.Method, timerInfo.Args)
+	}
+}
+
+// CheckSimulationLOD demotes or promotes entities in all spaces by distances to players
+func CheckSimulationLOD() {
+	if maxSimLODDistance == 0 {
+		return
+	}
+
+	demoted := map[string]int{}
+	for _, space := range spaceManager.spaces {
+		if !space.IsNil() && !space.IsDestroyed() {
+			space.checkSimulationLOD(demoted)
+		}
+	}
+	for typeName, desc := range registeredEntityTypes {
+		if desc.simLODDistance > 0 {
+			simLODEntities.With(typeName).Set(float64(demoted[typeName]))
+		}
+	}
+}
+
+type simLODCell struct {
+	x, z int
+}
+
+func (space *Space) checkSimulationLOD(demoted map[string]int) {
+	cellSize := float64(maxSimLODDistance) * _SIM_LOD_DEMOTE_FACTOR
+	cellOf := func(pos Vector3) simLODCell {
+		return simLODCell{int(math.Floor(float64(pos.X) / cellSize)), int(math.Floor(float64(pos.Z) / cellSize))}
+	}
+	players := map[simLODCell][]Vector3{}
+	for e := range space.entities {
+		if e.client != nil {
+			cell := cellOf(e.Position)
+			players[cell] = append(players[cell], e.Position)
+		}
+	}
+	// players within the distance, which is not larger than the cell size, are in neighbor cells
+	hasPlayerWithin := func(pos Vector3, dist Coord) bool {
+		cell := cellOf(pos)
+		for dx := -1; dx <= 1; dx++ {
+			for dz := -1; dz <= 1; dz++ {
+				for _, p := range players[simLODCell{cell.x + dx, cell.z + dz}] {
+					if p.DistanceTo(pos) <= dist {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	for e := range space.entities {
+		desc := e.typeDesc
+		if desc.simLODDistance == 0 || e.destroyed || e.Space != space {
+			continue
+		}
+		if e.client != nil {
+			if e.simLOD != nil {
+				e.promoteSimulation()
+			}
+			continue
+		}
+
+		if e.simLOD == nil {
+			if !hasPlayerWithin(e.Position, desc.simLODDistance*_SIM_LOD_DEMOTE_FACTOR) {
+				e.demoteSimulation()
+			}
+		} else if hasPlayerWithin(e.Position, desc.simLODDistance) {
+			e.promoteSimulation()
+		}
+		if e.simLOD != nil && !e.destroyed {
+			demoted[e.TypeName] += 1
+		}
+	}
+}
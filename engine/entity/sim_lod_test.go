@@ -0,0 +1,80 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+type TestSimLODEntity struct {
+	Entity
+	ticks int
+}
+
+func init() {
+	RegisterEntity("TestSimLODEntity", &TestSimLODEntity{}, false)
+}
+
+func (e *TestSimLODEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetUseAOI(true, 10)
+	desc.SetSimulationLOD(50, time.Second)
+}
+
+func (e *TestSimLODEntity) Tick() {
+	e.ticks += 1
+}
+
+func TestSimulationLOD(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.I = space
+	space.EnableAOI(10)
+
+	npc := CreateEntityLocally("TestSimLODEntity", nil)
+	defer npc.clearRawTimers()
+	npc.Space = space
+	space.entities.Add(npc)
+	space.enterAOI(npc)
+	watcher := newTestAOIEntity(space, 5, 0)
+	player := &Entity{Space: space, Position: Vector3{X: 30}, client: &GameClient{}, typeDesc: &EntityTypeDesc{}}
+	space.entities.Add(player)
+
+	space.checkSimulationLOD(map[string]int{})
+	if npc.IsSimulationDemoted() || !watcher.IsInterestedIn(npc) {
+		t.Fatalf("entity near players should be fully simulated")
+	}
+	player.Position.X = 55 // within the demote distance
+	space.checkSimulationLOD(map[string]int{})
+	if npc.IsSimulationDemoted() {
+		t.Fatalf("entity should not be demoted within the demote distance")
+	}
+
+	player.Position.X = 100
+	demoted := map[string]int{}
+	space.checkSimulationLOD(demoted)
+	if !npc.IsSimulationDemoted() || npc.IsUseAOI() || watcher.IsInterestedIn(npc) || demoted["TestSimLODEntity"] != 1 {
+		t.Fatalf("entity far from players should be demoted and leave AOI: %v", demoted)
+	}
+	space.move(npc, Vector3{X: 1})
+
+	ticker := npc.I.(*TestSimLODEntity)
+	tid := npc.AddTimer(time.Second, "Tick")
+	cb := npc.AddCallback(time.Second, "Tick")
+	npc.triggerTimer(tid, true)
+	npc.triggerTimer(tid, true)
+	npc.triggerTimer(cb, false)
+	if ticker.ticks != 0 || len(npc.simLOD.pending) != 2 {
+		t.Fatalf("timers of demoted entity should be coalesced: %d ticks, %d pending", ticker.ticks, len(npc.simLOD.pending))
+	}
+	npc.tickDemotedSimulation()
+	if ticker.ticks != 2 || npc.timers[cb] != nil || npc.timers[tid] == nil {
+		t.Fatalf("coalesced timers should be called once: %d ticks", ticker.ticks)
+	}
+
+	npc.triggerTimer(tid, true)
+	player.Position.X = 40
+	space.checkSimulationLOD(map[string]int{})
+	if npc.IsSimulationDemoted() || !watcher.IsInterestedIn(npc) || ticker.ticks != 3 {
+		t.Fatalf("entity should be promoted and call pending timers when players approach: %d ticks", ticker.ticks)
+	}
+	npc.CancelTimer(tid)
+}
@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Snapshot returns states of all entities on the game for snapshots of the cluster
+//
+// Snapshots are like freeze data, but entities keep running, and the nil space and clients are not included, since
+// snapshots are restored by games of new clusters.
+func Snapshot() (map[common.EntityID]*storagecommon.SnapshotEntity, error) {
+	entities := make(map[common.EntityID]*storagecommon.SnapshotEntity, len(entityManager.entities))
+	for eid, e := range entityManager.entities {
+		if e.IsSpaceEntity() && e.AsSpace().IsNil() {
+			continue
+		}
+
+		var spaceID common.EntityID
+		if e.Space != nil && !e.Space.IsNil() {
+			spaceID = e.Space.ID
+		}
+		md := e.GetMigrateData(spaceID)
+		md.Client = nil
+		data, err := netutil.MSG_PACKER.PackMsg(md, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "pack %s failed", e)
+		}
+		entities[eid] = &storagecommon.SnapshotEntity{TypeName: e.TypeName, SpaceID: spaceID, Data: data}
+	}
+	return entities, nil
+}
+
+// RestoreSnapshot restores entities from snapshots of the cluster, entities in the nil space are restored to the nil
+// space of the game, which should be created before
+func RestoreSnapshot(entities map[common.EntityID]*storagecommon.SnapshotEntity) error {
+	if nilSpace == nil {
+		return errors.Errorf("nil space is not created")
+	}
+
+	mdatas := make(map[common.EntityID]*entityMigrateData, len(entities))
+	for eid, se := range entities {
+		var md entityMigrateData
+		if err := netutil.MSG_PACKER.UnpackMsg(se.Data, &md); err != nil {
+			return errors.Wrapf(err, "unpack %s<%s> failed", se.TypeName, eid)
+		}
+		if _, ok := registeredEntityTypes[md.Type]; !ok {
+			return errors.Errorf("unknown entity type: %s", md.Type)
+		}
+		if md.SpaceID.IsNil() {
+			md.SpaceID = nilSpace.ID
+		}
+		mdatas[eid] = &md
+	}
+
+	// spaces are restored before entities in them
+	for _, restoreSpaces := range []bool{true, false} {
+		for eid, md := range mdatas {
+			if (md.Type == _SPACE_ENTITY_TYPE) != restoreSpaces {
+				continue
+			}
+			if md.SpaceID != nilSpace.ID && spaceManager.getSpace(md.SpaceID) == nil {
+				gwlog.Warnf("restore snapshot: space %s of %s<%s> is not found, restored to nil space", md.SpaceID, md.Type, eid)
+				md.SpaceID = nilSpace.ID
+			}
+			restoreEntity(eid, md, true)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"github.com/xiaonanln/go-aoi"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// AOI implementations of spaces
+const (
+	// AOIXZList keeps entities sorted on X and Z axes, which is accurate but costs O(n) per move in crowded spaces
+	AOIXZList = "xzlist"
+	// AOITower divides the space into towers (grid cells), entities see entities in towers within the AOI distance.
+	// It scales to thousands of entities in one space
+	AOITower = "tower"
+)
+
+var (
+	defaultAOIImplementation = AOIXZList
+	defaultAOITowerCellSize  Coord
+)
+
+// SetDefaultAOIImplementation sets the AOI implementation used by EnableAOI
+//
+// towerCellSize is the cell size of tower AOI, 0 means the AOI distance of the space.
+func SetDefaultAOIImplementation(impl string, towerCellSize Coord) {
+	checkAOIImplementation(impl)
+	defaultAOIImplementation = impl
+	defaultAOITowerCellSize = towerCellSize
+}
+
+func checkAOIImplementation(impl string) {
+	if impl != AOIXZList && impl != AOITower {
+		gwlog.Panicf("unknown AOI implementation: %s", impl)
+	}
+}
+
+// EnableTowerAOI enables tower AOI of the space with the cell size, regardless of the default AOI implementation
+//
+// cellSize 0 means the AOI distance. Range of towers is returned by GetTowerRange. It should be called in
+// OnSpaceCreated before any entity enters.
+func (space *Space) EnableTowerAOI(defaultAOIDistance Coord, cellSize Coord) {
+	space.enableAOI(defaultAOIDistance, AOITower, cellSize)
+}
+
+// GetAOIImplementation returns the AOI implementation of the space, or empty string if AOI is not enabled
+func (space *Space) GetAOIImplementation() string {
+	if space.aoiMgr == nil {
+		return ""
+	}
+	return space.GetStr(_SPACE_AOI_IMPL_KEY)
+}
+
+func (space *Space) enableAOI(defaultAOIDistance Coord, impl string, cellSize Coord) {
+	if defaultAOIDistance <= 0 {
+		gwlog.Panicf("defaultAOIDistance < 0")
+	}
+
+	if space.aoiMgr != nil {
+		gwlog.Panicf("%s.EnableAOI: AOI already enabled", space)
+	}
+
+	if space.headless {
+		gwlog.Panicf("%s.EnableAOI: space is headless", space)
+	}
+
+	if len(space.entities) > 0 {
+		gwlog.Panicf("%s is already using AOI", space)
+	}
+
+	checkAOIImplementation(impl)
+	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
+	space.Attrs.SetStr(_SPACE_AOI_IMPL_KEY, impl)
+	space.aoiDistance = defaultAOIDistance
+	space.customAOIEntities = EntitySet{}
+	if impl == AOITower {
+		if cellSize <= 0 {
+			cellSize = defaultAOIDistance
+		}
+		space.Attrs.SetFloat(_SPACE_AOI_CELL_KEY, float64(cellSize))
+		minX, minY, maxX, maxY := space.I.GetTowerRange()
+		space.aoiMgr = aoi.NewTowerAOIManager(aoi.Coord(minX), aoi.Coord(maxX), aoi.Coord(minY), aoi.Coord(maxY), aoi.Coord(cellSize))
+	} else {
+		space.aoiMgr = aoi.NewXZListAOIManager(aoi.Coord(defaultAOIDistance))
+	}
+}
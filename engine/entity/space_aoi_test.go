@@ -0,0 +1,149 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/go-aoi"
+)
+
+type testAOIEntity struct {
+	Entity
+	hidden EntitySet // entities excluded by AOIFilter
+}
+
+func (e *testAOIEntity) DescribeEntityType(desc *EntityTypeDesc) {}
+
+func (e *testAOIEntity) AOIFilter(other *Entity) bool {
+	return !e.hidden.Contains(other)
+}
+
+var testAOITypeDesc = &EntityTypeDesc{useAOI: true, aoiDistance: 10}
+
+func newTestAOIEntity(space *Space, x, z Coord) *Entity {
+	te := &testAOIEntity{hidden: EntitySet{}}
+	e := &te.Entity
+	e.Space, e.Position, e.typeDesc, e.I = space, Vector3{X: x, Z: z}, testAOITypeDesc, te
+	e.InterestedIn, e.InterestedBy, e.aoiNeighbors = EntitySet{}, EntitySet{}, EntitySet{}
+	aoi.InitAOI(&e.aoi, 10, e, e)
+	space.entities.Add(e)
+	space.enterAOI(e)
+	return e
+}
+
+func moveTestAOIEntity(e *Entity, x, z Coord) {
+	e.Position = Vector3{X: x, Z: z}
+	e.Space.move(e, e.Position)
+}
+
+func TestTowerAOI(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.I = space
+	space.EnableTowerAOI(10, 0)
+	if _, ok := space.aoiMgr.(*aoi.TowerAOIManager); !ok || space.GetAOIImplementation() != AOITower || space.GetFloat(_SPACE_AOI_CELL_KEY) != 10 {
+		t.Fatalf("tower AOI should be enabled with cell size 10: %T %v", space.aoiMgr, space.Attrs.ToMap())
+	}
+
+	e1 := newTestAOIEntity(space, 0, 0)
+	e2 := newTestAOIEntity(space, 5, 5)
+	e3 := newTestAOIEntity(space, 500, 500)
+	if !e1.IsInterestedIn(e2) || !e2.IsInterestedIn(e1) || e1.IsInterestedIn(e3) || e3.IsInterestedIn(e1) {
+		t.Fatalf("only near entities should be interested")
+	}
+
+	space.aoiMgr.Moved(&e3.aoi, 8, 8)
+	if !e1.IsInterestedIn(e3) || !e3.IsInterestedIn(e2) {
+		t.Fatalf("entity moved near should be interested")
+	}
+	space.aoiMgr.Moved(&e1.aoi, -500, -500)
+	if e1.IsInterestedIn(e2) || e2.IsInterestedIn(e1) || e3.IsInterestedIn(e1) {
+		t.Fatalf("entity moved away should not be interested")
+	}
+	space.aoiMgr.Leave(&e2.aoi)
+	if len(e2.InterestedIn) != 0 || len(e2.InterestedBy) != 0 {
+		t.Fatalf("entity left should not be interested")
+	}
+}
+
+func TestDefaultAOIImplementation(t *testing.T) {
+	SetDefaultAOIImplementation(AOITower, 20)
+	defer SetDefaultAOIImplementation(AOIXZList, 0)
+
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.I = space
+	space.EnableAOI(10)
+	if space.GetAOIImplementation() != AOITower || space.GetFloat(_SPACE_AOI_CELL_KEY) != 20 {
+		t.Fatalf("space should use the default AOI implementation: %v", space.Attrs.ToMap())
+	}
+}
+
+func TestAOIDistance(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.I = space
+	space.EnableAOI(10)
+
+	player := newTestAOIEntity(space, 0, 0)
+	boss := newTestAOIEntity(space, 50, 50)
+	if player.IsInterestedIn(boss) || boss.IsInterestedIn(player) {
+		t.Fatalf("boss should not be visible before AOI distance is set")
+	}
+
+	boss.SetAOIDistance(100)
+	if boss.GetAOIDistance() != 100 || player.GetAOIDistance() != 10 {
+		t.Fatalf("wrong AOI distances: %v %v", boss.GetAOIDistance(), player.GetAOIDistance())
+	}
+	if !player.IsInterestedIn(boss) || !boss.IsInterestedIn(player) {
+		t.Fatalf("boss should be visible within its AOI distance")
+	}
+	moveTestAOIEntity(player, -60, 0)
+	if player.IsInterestedIn(boss) || boss.IsInterestedIn(player) {
+		t.Fatalf("boss should not be visible out of its AOI distance")
+	}
+	moveTestAOIEntity(boss, 30, 0)
+	if !player.IsInterestedIn(boss) || !boss.IsInterestedIn(player) {
+		t.Fatalf("boss moved near should be visible")
+	}
+
+	boss.SetAOIDistance(0)
+	if player.IsInterestedIn(boss) || boss.IsInterestedIn(player) {
+		t.Fatalf("boss should not be visible after AOI distance is reset")
+	}
+
+	moveTestAOIEntity(boss, -55, 0)
+	player.SetAOIDistance(3)
+	if player.IsInterestedIn(boss) || !boss.IsInterestedIn(player) {
+		t.Fatalf("player should not see entities out of its smaller AOI distance")
+	}
+	moveTestAOIEntity(player, -57, 0)
+	if !player.IsInterestedIn(boss) {
+		t.Fatalf("player should see entities within its smaller AOI distance")
+	}
+
+	space.leaveAOI(boss)
+	if len(boss.InterestedIn) != 0 || len(boss.InterestedBy) != 0 || space.customAOIEntities.Contains(boss) {
+		t.Fatalf("entity left should not be interested")
+	}
+}
+
+func TestAOIFilter(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.I = space
+	space.EnableAOI(10)
+
+	observer := newTestAOIEntity(space, 100, 100)
+	stealth := newTestAOIEntity(space, 0, 0)
+	observer.I.(*testAOIEntity).hidden.Add(stealth)
+	moveTestAOIEntity(observer, 5, 5)
+	if observer.IsInterestedIn(stealth) || !stealth.IsInterestedIn(observer) {
+		t.Fatalf("filtered entity should not be visible")
+	}
+
+	observer.I.(*testAOIEntity).hidden.Del(stealth)
+	stealth.RefreshAOI()
+	if !observer.IsInterestedIn(stealth) {
+		t.Fatalf("entity should be visible after the filter changes")
+	}
+}
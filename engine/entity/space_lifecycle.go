@@ -0,0 +1,100 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_SPACE_DESTROY_POLICY_KEY     = "_DestroyPolicy"
+	_SPACE_DESTROY_CHECK_INTERVAL = time.Second
+)
+
+// SpaceDestroyPolicy defines when the space is destroyed automatically, so that spaces are not leaked
+type SpaceDestroyPolicy struct {
+	EmptyTimeout time.Duration // destroy the space after no entity is in the space for the duration, 0 for disabled
+	TTL          time.Duration // destroy the space after the duration since the policy is set, 0 for disabled
+	OnMatchEnd   bool          // destroy the space when EndMatch is called
+}
+
+// SetDestroyPolicy sets the destroy policy of the space, which replaces the old policy and is kept when the space migrates
+//
+// OnSpaceDestroy is always called before entities in the space are destroyed (and saved), so results can be persisted there.
+func (space *Space) SetDestroyPolicy(policy SpaceDestroyPolicy) {
+	if space.IsNil() {
+		gwlog.Panicf("%s.SetDestroyPolicy: nil space can not be destroyed", space)
+	}
+
+	attr := NewMapAttr()
+	attr.SetInt("emptyTimeout", int64(policy.EmptyTimeout/time.Millisecond))
+	if policy.TTL > 0 {
+		attr.SetInt("deadline", toUnixMillis(time.Now().Add(policy.TTL)))
+	} else {
+		attr.SetInt("deadline", 0)
+	}
+	attr.SetBool("onMatchEnd", policy.OnMatchEnd)
+	space.Attrs.SetMapAttr(_SPACE_DESTROY_POLICY_KEY, attr)
+	space.setupDestroyCheck()
+}
+
+// EndMatch notifies that the match in the space is ended, the space is destroyed if the destroy policy is OnMatchEnd
+func (space *Space) EndMatch() {
+	if space.Attrs.HasKey(_SPACE_DESTROY_POLICY_KEY) && space.GetMapAttr(_SPACE_DESTROY_POLICY_KEY).GetBool("onMatchEnd") {
+		space.destroyByPolicy("match end")
+	}
+}
+
+// setupDestroyCheck starts checking the destroy policy periodically, called when the policy is set or the space is restored
+func (space *Space) setupDestroyCheck() {
+	if space.destroyCheckTimer != nil {
+		space.cancelRawTimer(space.destroyCheckTimer)
+		space.destroyCheckTimer = nil
+	}
+	space.emptySince = time.Time{}
+
+	if !space.Attrs.HasKey(_SPACE_DESTROY_POLICY_KEY) {
+		return
+	}
+	policy := space.GetMapAttr(_SPACE_DESTROY_POLICY_KEY)
+	if policy.GetInt("emptyTimeout") > 0 || policy.GetInt("deadline") > 0 {
+		space.destroyCheckTimer = space.addRawTimer(_SPACE_DESTROY_CHECK_INTERVAL, func() {
+			if reason := space.checkDestroyPolicy(time.Now()); reason != "" {
+				space.destroyByPolicy(reason)
+			}
+		})
+	}
+}
+
+// checkDestroyPolicy returns the reason if the space should be destroyed now
+func (space *Space) checkDestroyPolicy(now time.Time) string {
+	policy := space.GetMapAttr(_SPACE_DESTROY_POLICY_KEY)
+	if deadline := policy.GetInt("deadline"); deadline > 0 && toUnixMillis(now) >= deadline {
+		return "TTL expired"
+	}
+
+	emptyTimeout := time.Duration(policy.GetInt("emptyTimeout")) * time.Millisecond
+	if emptyTimeout <= 0 {
+		return ""
+	}
+	if len(space.entities) > 0 {
+		space.emptySince = time.Time{}
+		return ""
+	}
+	if space.emptySince.IsZero() {
+		space.emptySince = now
+	}
+	if now.Sub(space.emptySince) >= emptyTimeout {
+		return "empty for " + emptyTimeout.String()
+	}
+	return ""
+}
+
+func (space *Space) destroyByPolicy(reason string) {
+	gwlog.Infof("%s is destroyed by destroy policy: %s", space, reason)
+	space.Destroy()
+}
+
+func toUnixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
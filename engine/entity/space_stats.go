@@ -0,0 +1,111 @@
+package entity
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// SpaceStats is the statistics of a space
+type SpaceStats struct {
+	Kind            int
+	EntityCounts    map[string]int // entity counts by type
+	AvgTickDuration time.Duration  // average time spent in entity logic (RPCs and timers) of the space per game tick
+	AOIEnterRate    float64        // AOI enters per second
+	AOILeaveRate    float64        // AOI leaves per second
+	ClientBandwidth float64        // bytes per second sent to clients in the space
+}
+
+// spaceStatsCounter counts space statistics in a time window
+type spaceStatsCounter struct {
+	logicTime   time.Duration
+	aoiEnters   int64
+	aoiLeaves   int64
+	clientBytes uint64
+}
+
+type spaceStatsCollector struct {
+	current      spaceStatsCounter
+	last         spaceStatsCounter
+	lastDuration time.Duration
+	windowStart  time.Time
+}
+
+const (
+	// _SYNC_INFO_SIZE_PER_CLIENT is the size of position sync info sent to each client
+	_SYNC_INFO_SIZE_PER_CLIENT = common.CLIENTID_LENGTH + common.ENTITYID_LENGTH + proto.SYNC_INFO_SIZE_PER_ENTITY
+)
+
+var (
+	exportedSpaceStats atomic.Value
+)
+
+func init() {
+	expvar.Publish("SpaceStats", expvar.Func(func() interface{} {
+		return exportedSpaceStats.Load()
+	}))
+}
+
+// rotate starts a new time window
+func (sc *spaceStatsCollector) rotate(now time.Time) {
+	sc.last = sc.current
+	sc.lastDuration = now.Sub(sc.windowStart)
+	sc.current = spaceStatsCounter{}
+	sc.windowStart = now
+}
+
+// Stats returns the statistics of the space
+//
+// Rates are calculated in the last export interval, or since the space is created if not exported yet
+func (space *Space) Stats() SpaceStats {
+	counter, duration := space.stats.last, space.stats.lastDuration
+	if duration <= 0 {
+		counter, duration = space.stats.current, time.Since(space.stats.windowStart)
+	}
+
+	stats := SpaceStats{
+		Kind:         space.Kind,
+		EntityCounts: map[string]int{},
+	}
+	for e := range space.entities {
+		stats.EntityCounts[e.TypeName] += 1
+	}
+
+	if duration > 0 {
+		seconds := duration.Seconds()
+		stats.AvgTickDuration = time.Duration(float64(counter.logicTime) * float64(consts.GAME_SERVICE_TICK_INTERVAL) / float64(duration))
+		stats.AOIEnterRate = float64(counter.aoiEnters) / seconds
+		stats.AOILeaveRate = float64(counter.aoiLeaves) / seconds
+		stats.ClientBandwidth = float64(counter.clientBytes) / seconds
+	}
+	return stats
+}
+
+// ExportSpaceStats starts new statistics windows for all spaces and exports statistics of the last windows to expvar
+func ExportSpaceStats() {
+	now := time.Now()
+	allStats := make(map[string]SpaceStats, len(spaceManager.spaces))
+	for id, space := range spaceManager.spaces {
+		space.stats.rotate(now)
+		allStats[string(id)] = space.Stats()
+	}
+	exportedSpaceStats.Store(allStats)
+}
+
+// statsSpace returns the space which entity statistics are attributed to
+func (e *Entity) statsSpace() *Space {
+	if e.IsSpaceEntity() {
+		return e.AsSpace()
+	}
+	return e.Space
+}
+
+func (e *Entity) addLogicTime(d time.Duration) {
+	if space := e.statsSpace(); space != nil {
+		space.stats.current.logicTime += d
+	}
+}
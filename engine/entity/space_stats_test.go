@@ -0,0 +1,39 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+func TestSpaceStats(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.entities.Add(&Entity{TypeName: "Avatar"})
+	space.entities.Add(&Entity{TypeName: "Avatar"})
+	space.entities.Add(&Entity{TypeName: "Monster"})
+
+	now := time.Now()
+	space.stats.windowStart = now.Add(-time.Second * 2)
+	space.stats.current = spaceStatsCounter{
+		logicTime:   time.Millisecond * 800,
+		aoiEnters:   10,
+		aoiLeaves:   4,
+		clientBytes: 2000,
+	}
+	space.stats.rotate(now)
+	space.stats.current.aoiEnters = 1000 // should not affect stats until next rotate
+
+	stats := space.Stats()
+	if stats.Kind != 1 || stats.EntityCounts["Avatar"] != 2 || stats.EntityCounts["Monster"] != 1 {
+		t.Fatalf("wrong entity counts: %+v", stats)
+	}
+	if stats.AOIEnterRate != 5 || stats.AOILeaveRate != 2 || stats.ClientBandwidth != 1000 {
+		t.Fatalf("wrong rates: %+v", stats)
+	}
+	// 400ms logic time per second
+	expected := time.Duration(0.4 * float64(consts.GAME_SERVICE_TICK_INTERVAL))
+	if diff := stats.AvgTickDuration - expected; diff < -time.Microsecond || diff > time.Microsecond {
+		t.Fatalf("wrong avg tick duration: %v, expected %v", stats.AvgTickDuration, expected)
+	}
+}
@@ -0,0 +1,109 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+)
+
+const (
+	// SUBSCRIPTION_SYNC_METHOD is the client method called on the subscriber with the subscription name and selected entities
+	SUBSCRIPTION_SYNC_METHOD = "OnSubscriptionSync"
+)
+
+// SubscriptionFilter selects entities of a space subscription
+type SubscriptionFilter func(e *Entity) bool
+
+// spaceSubscription is a filtered view of a space subscribed by the client of subscriber
+type spaceSubscription struct {
+	space      *Space
+	subscriber *Entity
+	name       string
+	filter     SubscriptionFilter
+	timer      *timerwheel.Timer
+}
+
+// FilterByType returns the subscription filter which selects entities of the types
+func FilterByType(typeNames ...string) SubscriptionFilter {
+	return func(e *Entity) bool {
+		for _, typeName := range typeNames {
+			if e.TypeName == typeName {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Subscribe subscribes the client of subscriber to entities of the space selected by filter, independent of AOI (e.g. for map UI)
+//
+// Every interval, the client method OnSubscriptionSync(name, entities) is called on the subscriber, where entities is a list of
+// [entityID, typeName, x, y, z, yaw] of selected entities. Syncs are unreliable, since they are superseded by later ones.
+// Subscriptions are cancelled when the subscriber leaves the space, and subscribing with the same name replaces the old one.
+func (space *Space) Subscribe(subscriber *Entity, name string, interval time.Duration, filter SubscriptionFilter) {
+	if space.IsNil() || subscriber.Space != space {
+		gwlog.Panicf("%s.Subscribe(%s, %s): subscriber is not in this space", space, subscriber, name)
+	}
+	if interval <= 0 {
+		gwlog.Panicf("%s.Subscribe(%s, %s): invalid interval %s", space, subscriber, name, interval)
+	}
+
+	space.Unsubscribe(subscriber, name)
+	sub := &spaceSubscription{
+		space:      space,
+		subscriber: subscriber,
+		name:       name,
+		filter:     filter,
+	}
+	sub.timer = subscriber.addRawTimer(interval, sub.sync)
+
+	if space.subs == nil {
+		space.subs = map[*Entity]map[string]*spaceSubscription{}
+	}
+	if space.subs[subscriber] == nil {
+		space.subs[subscriber] = map[string]*spaceSubscription{}
+	}
+	space.subs[subscriber][name] = sub
+}
+
+// Unsubscribe cancels the subscription of the subscriber
+func (space *Space) Unsubscribe(subscriber *Entity, name string) {
+	sub := space.subs[subscriber][name]
+	if sub == nil {
+		return
+	}
+
+	subscriber.cancelRawTimer(sub.timer)
+	delete(space.subs[subscriber], name)
+	if len(space.subs[subscriber]) == 0 {
+		delete(space.subs, subscriber)
+	}
+}
+
+// unsubscribeAll cancels all subscriptions of the subscriber
+func (space *Space) unsubscribeAll(subscriber *Entity) {
+	for name := range space.subs[subscriber] {
+		space.Unsubscribe(subscriber, name)
+	}
+}
+
+func (sub *spaceSubscription) sync() {
+	if sub.subscriber.client == nil {
+		return
+	}
+	sub.subscriber.client.callWithDelivery(sub.subscriber.ID, proto.DELIVERY_UNRELIABLE, SUBSCRIPTION_SYNC_METHOD, []interface{}{sub.name, sub.collect()})
+}
+
+// collect returns [entityID, typeName, x, y, z, yaw] of entities selected by the filter
+func (sub *spaceSubscription) collect() []interface{} {
+	entities := []interface{}{}
+	for e := range sub.space.entities {
+		if !sub.filter(e) {
+			continue
+		}
+		entities = append(entities, []interface{}{string(e.ID), e.TypeName, float32(e.Position.X), float32(e.Position.Y), float32(e.Position.Z), float32(e.yaw)})
+	}
+	return entities
+}
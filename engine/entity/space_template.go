@@ -0,0 +1,210 @@
+package entity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_SPACE_TEMPLATE_KEY        = "_Template"
+	_SPACE_TEMPLATE_PARAMS_KEY = "_TemplateParams"
+	// TEMPLATE_PARAM_PREFIX is the prefix of string values in templates which are replaced by parameters (e.g. "$level")
+	TEMPLATE_PARAM_PREFIX = "$"
+)
+
+var (
+	registeredSpaceTemplates = map[string]*SpaceTemplate{}
+)
+
+// SpaceTemplate declares the kind, static data and initial entities of spaces created from the template
+//
+// String values of data and entity attributes which begin with $ are replaced by parameters when spaces are created,
+// so that one template can be used by instances of different levels, difficulties, etc.
+type SpaceTemplate struct {
+	Kind     int                    `json:"kind"`
+	Data     map[string]interface{} `json:"data"`     // static data of spaces, see Space.GetTemplateData
+	Spawners []SpawnerTemplate      `json:"spawners"` // entities spawned at random positions
+	Entities []EntityTemplate       `json:"entities"` // entities created at fixed positions
+}
+
+// SpawnerTemplate spawns Count entities of the type at random positions within Radius of Center on the XZ plane
+type SpawnerTemplate struct {
+	TypeName string                 `json:"type"`
+	Count    int                    `json:"count"`
+	Center   Vector3                `json:"center"`
+	Radius   Coord                  `json:"radius"`
+	Attrs    map[string]interface{} `json:"attrs"`
+
+	Archetype string `json:"archetype"` // archetype of spawned entities, see RegisterArchetype
+}
+
+// EntityTemplate declares an entity created in spaces
+type EntityTemplate struct {
+	TypeName string                 `json:"type"`
+	Position Vector3                `json:"position"`
+	Yaw      Yaw                    `json:"yaw"`
+	Attrs    map[string]interface{} `json:"attrs"`
+
+	Archetype string `json:"archetype"` // archetype of the entity, see RegisterArchetype
+}
+
+// RegisterSpaceTemplate registers the space template, templates should be registered on all games
+func RegisterSpaceTemplate(name string, template *SpaceTemplate) {
+	if _, ok := registeredSpaceTemplates[name]; ok {
+		gwlog.Fatalf("RegisterSpaceTemplate: space template %s already registered", name)
+	}
+	if template.Kind == 0 {
+		gwlog.Fatalf("RegisterSpaceTemplate: space template %s can not be of kind 0", name)
+	}
+	registeredSpaceTemplates[name] = template
+}
+
+// LoadSpaceTemplates registers space templates from the JSON file, which is an object of template names to templates
+func LoadSpaceTemplates(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "read space templates failed")
+	}
+
+	var templates map[string]*SpaceTemplate
+	if err := json.Unmarshal(content, &templates); err != nil {
+		return errors.Wrapf(err, "parse space templates failed: %s", file)
+	}
+	for name, template := range templates {
+		RegisterSpaceTemplate(name, template)
+	}
+	return nil
+}
+
+func getSpaceTemplate(name string) *SpaceTemplate {
+	template := registeredSpaceTemplates[name]
+	if template == nil {
+		gwlog.Panicf("unknown space template: %s", name)
+	}
+	return template
+}
+
+func spaceTemplateData(name string, params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		_SPACE_KIND_ATTR_KEY:       getSpaceTemplate(name).Kind,
+		_SPACE_TEMPLATE_KEY:        name,
+		_SPACE_TEMPLATE_PARAMS_KEY: params,
+	}
+}
+
+// CreateSpaceFromTemplateLocally creates a space from the template in the local game
+func CreateSpaceFromTemplateLocally(name string, params map[string]interface{}) *Space {
+	e := createEntity(_SPACE_ENTITY_TYPE, nil, Vector3{}, "", spaceTemplateData(name, params))
+	return e.AsSpace()
+}
+
+// CreateSpaceFromTemplateSomewhere creates a space from the template in any game server
+func CreateSpaceFromTemplateSomewhere(gameid uint16, name string, params map[string]interface{}) common.EntityID {
+	return createEntitySomewhere(gameid, _SPACE_ENTITY_TYPE, spaceTemplateData(name, params))
+}
+
+// GetTemplateName returns the name of the template which the space is created from, or empty string
+func (space *Space) GetTemplateName() string {
+	return space.GetStr(_SPACE_TEMPLATE_KEY)
+}
+
+// GetTemplateParams returns parameters of the template which the space is created from
+func (space *Space) GetTemplateParams() map[string]interface{} {
+	if !space.Attrs.HasKey(_SPACE_TEMPLATE_PARAMS_KEY) {
+		return map[string]interface{}{}
+	}
+	return space.GetMapAttr(_SPACE_TEMPLATE_PARAMS_KEY).ToMap()
+}
+
+// GetTemplateData returns the static data of the space template, with parameters replaced
+func (space *Space) GetTemplateData() map[string]interface{} {
+	name := space.GetTemplateName()
+	if name == "" {
+		return nil
+	}
+	return applyTemplateParams(getSpaceTemplate(name).Data, space.GetTemplateParams()).(map[string]interface{})
+}
+
+// instantiateTemplate creates initial entities of the space template
+func (space *Space) instantiateTemplate() {
+	name := space.GetTemplateName()
+	if name == "" {
+		return
+	}
+
+	template := getSpaceTemplate(name)
+	params := space.GetTemplateParams()
+	for _, et := range template.Entities {
+		e := space.createTemplateEntity(et.TypeName, et.Archetype, et.Position, et.Attrs, params)
+		if et.Yaw != 0 {
+			e.SetYaw(et.Yaw)
+		}
+	}
+	for _, st := range template.Spawners {
+		for i := 0; i < st.Count; i++ {
+			space.createTemplateEntity(st.TypeName, st.Archetype, randomPosInRadius(st.Center, st.Radius), st.Attrs, params)
+		}
+	}
+}
+
+func (space *Space) createTemplateEntity(typeName string, archetype string, pos Vector3, attrs map[string]interface{}, params map[string]interface{}) *Entity {
+	var data map[string]interface{}
+	if len(attrs) > 0 {
+		data = applyTemplateParams(attrs, params).(map[string]interface{})
+	}
+	if archetype != "" {
+		if GetArchetype(archetype) == nil {
+			gwlog.Panicf("space template %s: archetype %s is not registered", space.GetTemplateName(), archetype)
+		}
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		data[ARCHETYPE_ATTR_KEY] = archetype // only the name is kept by the entity, data of the archetype is shared
+	}
+	return createEntity(typeName, space, pos, "", data)
+}
+
+// applyTemplateParams returns a copy of the template value with parameter references replaced
+func applyTemplateParams(v interface{}, params map[string]interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		if !strings.HasPrefix(val, TEMPLATE_PARAM_PREFIX) {
+			return val
+		}
+		param, ok := params[val[len(TEMPLATE_PARAM_PREFIX):]]
+		if !ok {
+			gwlog.Panicf("space template parameter %s is missing", val)
+		}
+		return param
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, elem := range val {
+			m[k] = applyTemplateParams(elem, params)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for i, elem := range val {
+			l[i] = applyTemplateParams(elem, params)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func randomPosInRadius(center Vector3, radius Coord) Vector3 {
+	r := float64(radius) * math.Sqrt(rand.Float64())
+	theta := rand.Float64() * 2 * math.Pi
+	return Vector3{X: center.X + Coord(r*math.Cos(theta)), Y: center.Y, Z: center.Z + Coord(r*math.Sin(theta))}
+}
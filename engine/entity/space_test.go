@@ -0,0 +1,142 @@
+package entity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+)
+
+func TestForEachEntityInRadius(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	near := &Entity{Position: Vector3{X: 3, Y: 100, Z: 4}}
+	far := &Entity{Position: Vector3{X: 4, Y: 0, Z: 4}}
+	space.entities.Add(near)
+	space.entities.Add(far)
+
+	var visited []*Entity
+	space.ForEachEntityInRadius(Vector3{}, 5, func(e *Entity) {
+		visited = append(visited, e)
+	})
+	if len(visited) != 1 || visited[0] != near {
+		t.Fatalf("only entities within radius on XZ plane should be visited: %v", visited)
+	}
+
+	if n := space.BroadcastInRadius(Vector3{}, 100, "OnExplode"); n != 0 {
+		t.Errorf("entities without clients should not be called: %d", n)
+	}
+}
+
+func TestSpaceSubscription(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	subscriber := &Entity{ID: "Subscriber000001", TypeName: "Avatar", Space: space, rawTimers: map[*timerwheel.Timer]struct{}{}}
+	boss := &Entity{ID: "Boss000000000001", TypeName: "Boss", Position: Vector3{X: 1, Y: 2, Z: 3}}
+	space.entities.Add(subscriber)
+	space.entities.Add(boss)
+	space.entities.Add(&Entity{TypeName: "Monster"})
+
+	space.Subscribe(subscriber, "bosses", time.Second, FilterByType("Boss"))
+	sub := space.subs[subscriber]["bosses"]
+	if sub == nil || len(subscriber.rawTimers) != 1 {
+		t.Fatalf("subscription should be added with timer")
+	}
+	entities := sub.collect()
+	if len(entities) != 1 || entities[0].([]interface{})[0] != string(boss.ID) || entities[0].([]interface{})[3] != float32(2) {
+		t.Errorf("wrong entities of subscription: %v", entities)
+	}
+
+	space.Subscribe(subscriber, "bosses", time.Second, FilterByType("Monster"))
+	if len(space.subs[subscriber]) != 1 || len(subscriber.rawTimers) != 1 {
+		t.Errorf("subscription with the same name should be replaced")
+	}
+
+	space.unsubscribeAll(subscriber)
+	if len(space.subs) != 0 || len(subscriber.rawTimers) != 0 {
+		t.Errorf("subscriptions should be cancelled")
+	}
+}
+
+func TestHeadlessSpace(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.EnableHeadless()
+	if !space.IsHeadless() || !space.GetBool(_SPACE_HEADLESS_KEY) {
+		t.Fatalf("space should be headless")
+	}
+
+	e := &Entity{Space: space}
+	space.entities.Add(e)
+	e.SetPosition(Vector3{X: 1, Y: 2, Z: 3})
+	e.SetYaw(90)
+	if e.Position.X != 1 || e.yaw != 90 {
+		t.Errorf("position & yaw should be updated in headless space: %v, %v", e.Position, e.yaw)
+	}
+	if e.syncInfoFlag != 0 {
+		t.Errorf("entities in headless space should not be synced: %v", e.syncInfoFlag)
+	}
+}
+
+func TestSpaceTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "space_template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "templates.json")
+	ioutil.WriteFile(file, []byte(`{"dungeon_test": {"kind": 3, "data": {"level": "$level", "drops": ["$drop", "gold"]},
+		"spawners": [{"type": "Monster", "count": 5, "radius": 10, "attrs": {"level": "$level"}}]}}`), 0644)
+	if err := LoadSpaceTemplates(file); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(registeredSpaceTemplates, "dungeon_test")
+
+	template := getSpaceTemplate("dungeon_test")
+	if template.Kind != 3 || len(template.Spawners) != 1 || template.Spawners[0].Count != 5 {
+		t.Fatalf("wrong template: %+v", template)
+	}
+	data := applyTemplateParams(template.Data, map[string]interface{}{"level": 10, "drop": "sword"}).(map[string]interface{})
+	if data["level"] != 10 || data["drops"].([]interface{})[0] != "sword" || template.Data["level"] != "$level" {
+		t.Errorf("wrong template data: %v", data)
+	}
+
+	pos := randomPosInRadius(Vector3{X: 100, Y: 1}, 10)
+	if pos.Y != 1 || pos.DistanceTo(Vector3{X: 100, Y: 1}) > 10 {
+		t.Errorf("wrong spawn position: %v", pos)
+	}
+}
+
+func TestSpaceDestroyPolicy(t *testing.T) {
+	space := &Space{Kind: 1, entities: EntitySet{}}
+	space.Attrs = NewMapAttr()
+	space.rawTimers = map[*timerwheel.Timer]struct{}{}
+	space.SetDestroyPolicy(SpaceDestroyPolicy{EmptyTimeout: time.Minute, TTL: time.Hour})
+	if space.destroyCheckTimer == nil || len(space.rawTimers) != 1 {
+		t.Fatalf("destroy policy should be checked by timer")
+	}
+
+	now := time.Now()
+	space.entities.Add(&Entity{})
+	if reason := space.checkDestroyPolicy(now.Add(time.Hour - time.Second)); reason != "" {
+		t.Errorf("space should not be destroyed: %s", reason)
+	}
+	if reason := space.checkDestroyPolicy(now.Add(time.Hour + time.Second)); reason != "TTL expired" {
+		t.Errorf("space should be destroyed by TTL: %s", reason)
+	}
+
+	space.entities = EntitySet{}
+	if reason := space.checkDestroyPolicy(now); reason != "" {
+		t.Errorf("space should not be destroyed when it becomes empty: %s", reason)
+	}
+	if reason := space.checkDestroyPolicy(now.Add(time.Minute)); reason == "" {
+		t.Errorf("space should be destroyed after empty timeout")
+	}
+
+	space.SetDestroyPolicy(SpaceDestroyPolicy{OnMatchEnd: true})
+	if space.destroyCheckTimer != nil || len(space.rawTimers) != 0 {
+		t.Errorf("destroy check timer should be cancelled")
+	}
+}
@@ -0,0 +1,30 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Entity sync packets to clients are stamped with the server tick and the server time when sync infos are collected, so that
+// clients can buffer snapshots and render smooth movement by interpolating between them (see package interp).
+
+var (
+	syncTick       uint32
+	syncServerTime uint64 // in milliseconds
+)
+
+// SyncTick returns the server tick of the last collected entity sync infos
+func SyncTick() uint32 {
+	return syncTick
+}
+
+func beginSyncTick(now time.Time) {
+	syncTick += 1
+	syncServerTime = uint64(now.UnixNano() / int64(time.Millisecond))
+}
+
+func appendSyncHeader(pkt *netutil.Packet) {
+	pkt.AppendUint32(syncTick)
+	pkt.AppendUint64(syncServerTime)
+}
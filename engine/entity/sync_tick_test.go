@@ -0,0 +1,24 @@
+package entity
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func TestSyncHeader(t *testing.T) {
+	tick := SyncTick()
+	now := time.Unix(1500000000, int64(123*time.Millisecond))
+	beginSyncTick(now)
+	if SyncTick() != tick+1 {
+		t.Fatalf("sync tick should be increased: %d => %d", tick, SyncTick())
+	}
+
+	pkt := netutil.NewPacket()
+	defer pkt.Release()
+	appendSyncHeader(pkt)
+	if pkt.ReadUint32() != tick+1 || pkt.ReadUint64() != 1500000000123 {
+		t.Errorf("wrong sync header")
+	}
+}
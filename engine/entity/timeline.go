@@ -0,0 +1,196 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Event timeline
+//
+// Entities of types which enable timelines by SetTimeline record recent significant events in a ring buffer once they
+// are bound to clients: RPCs handled, changes of flagged attributes, space transitions, client connections and
+// migrations. Timelines are kept across migrations and dumped by GET /admin/timeline of the game, so that support can
+// find out what happened to a player recently without searching logs.
+
+const (
+	// TimelineRPC is the event of RPC handled by the entity
+	TimelineRPC = "rpc"
+	// TimelineAttr is the event of flagged attribute changed
+	TimelineAttr = "attr"
+	// TimelineSpace is the event of entering or leaving a space
+	TimelineSpace = "space"
+	// TimelineClient is the event of client connected, disconnected, resumed or desynced
+	TimelineClient = "client"
+	// TimelineMigrate is the event of migrating to another game
+	TimelineMigrate = "migrate"
+
+	_TIMELINE_MAX_DETAIL_LEN = 256
+)
+
+// TimelineEvent is a significant event recorded in the timeline of the entity
+type TimelineEvent struct {
+	Time   time.Time `msgpack:"T" json:"time"`
+	Kind   string    `msgpack:"K" json:"kind"`
+	Detail string    `msgpack:"D" json:"detail"`
+}
+
+// timeline is the ring buffer of recent events of the entity
+type timeline struct {
+	events []TimelineEvent
+	next   int // index of the next event to be written
+	full   bool
+}
+
+func newTimeline(size int) *timeline {
+	return &timeline{events: make([]TimelineEvent, size)}
+}
+
+func (tl *timeline) add(event TimelineEvent) {
+	tl.events[tl.next] = event
+	tl.next += 1
+	if tl.next == len(tl.events) {
+		tl.next = 0
+		tl.full = true
+	}
+}
+
+// list returns events after the time in order of time
+func (tl *timeline) list(after time.Time) []TimelineEvent {
+	events := make([]TimelineEvent, 0, len(tl.events))
+	if tl.full {
+		events = append(events, tl.events[tl.next:]...)
+	}
+	events = append(events, tl.events[:tl.next]...)
+
+	i := 0
+	for i < len(events) && !events[i].Time.After(after) {
+		i += 1
+	}
+	return events[i:]
+}
+
+// SetTimeline enables timelines of entities of this type with at most size recent events, changes of attributes in
+// attrPaths are also recorded, e.g. "level" or "bag.gold" (including changes of all attributes under the path)
+func (desc *EntityTypeDesc) SetTimeline(size int, attrPaths ...string) *EntityTypeDesc {
+	if size <= 0 {
+		gwlog.Panicf("timeline size should be positive: %d", size)
+	}
+
+	desc.timelineSize = size
+	desc.timelineRoots = common.StringSet{}
+	desc.timelinePaths = attrPaths
+	for _, path := range attrPaths {
+		desc.timelineRoots.Add(strings.SplitN(path, ".", 2)[0])
+	}
+	return desc
+}
+
+// enableTimeline starts recording events of the entity if timelines of its type are enabled
+func (e *Entity) enableTimeline() {
+	if e.timeline == nil && e.typeDesc.timelineSize > 0 {
+		e.timeline = newTimeline(e.typeDesc.timelineSize)
+	}
+}
+
+// RecordTimelineEvent records the custom event in the timeline of the entity, e.g. purchases or quest completions
+//
+// Events are not recorded if the timeline is not enabled for the entity.
+func (e *Entity) RecordTimelineEvent(kind string, detail string) {
+	if e.timeline == nil {
+		return
+	}
+	if len(detail) > _TIMELINE_MAX_DETAIL_LEN {
+		detail = detail[:_TIMELINE_MAX_DETAIL_LEN] + "..."
+	}
+	e.timeline.add(TimelineEvent{Time: time.Now(), Kind: kind, Detail: detail})
+}
+
+func (e *Entity) recordTimeline(kind string, format string, args ...interface{}) {
+	if e.timeline == nil {
+		return
+	}
+	e.RecordTimelineEvent(kind, fmt.Sprintf(format, args...))
+}
+
+// recordRPCTimeline records the RPC call with arguments in the timeline
+func (e *Entity) recordRPCTimeline(methodName string, in []reflect.Value, clientid common.ClientID) {
+	if e.timeline == nil {
+		return
+	}
+
+	args := make([]string, len(in)-1)
+	for i, arg := range in[1:] {
+		args[i] = fmt.Sprintf("%v", arg.Interface())
+	}
+	from := "server"
+	if clientid != "" {
+		if clientid == e.getClientID() {
+			from = "own client"
+		} else {
+			from = "client " + string(clientid)
+		}
+	}
+	e.recordTimeline(TimelineRPC, "%s(%s) from %s", methodName, strings.Join(args, ", "), from)
+}
+
+// recordAttrTimeline records the change of the attribute at path (from owner, in reverse order) and key if the
+// attribute is flagged by SetTimeline
+func (e *Entity) recordAttrTimeline(path []interface{}, key interface{}, op string, val interface{}) {
+	if e.timeline == nil || len(e.typeDesc.timelinePaths) == 0 {
+		return
+	}
+
+	root := key
+	if len(path) > 0 {
+		root = path[len(path)-1]
+	}
+	if rootKey, ok := root.(string); !ok || !e.typeDesc.timelineRoots.Contains(rootKey) {
+		return
+	}
+
+	keys := make([]string, 0, len(path)+1)
+	for i := len(path) - 1; i >= 0; i-- {
+		keys = append(keys, fmt.Sprint(path[i]))
+	}
+	if key != nil {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	fullPath := strings.Join(keys, ".")
+
+	for _, p := range e.typeDesc.timelinePaths {
+		if fullPath == p || strings.HasPrefix(fullPath, p+".") || strings.HasPrefix(p, fullPath+".") {
+			if e.typeDesc.sensitiveAttrs.Contains(keys[0]) {
+				val = "<sensitive>"
+			}
+			if op == "set" {
+				e.recordTimeline(TimelineAttr, "%s = %v", fullPath, val)
+			} else {
+				e.recordTimeline(TimelineAttr, "%s %s", fullPath, op)
+			}
+			return
+		}
+	}
+}
+
+// GetTimeline returns events of the entity in the last duration (all recent events if duration is 0), the entity is
+// not found if ok is false
+func GetTimeline(id common.EntityID, duration time.Duration) (events []TimelineEvent, ok bool) {
+	e := entityManager.get(id)
+	if e == nil {
+		return nil, false
+	}
+	if e.timeline == nil {
+		return []TimelineEvent{}, true
+	}
+
+	var after time.Time
+	if duration > 0 {
+		after = time.Now().Add(-duration)
+	}
+	return e.timeline.list(after), true
+}
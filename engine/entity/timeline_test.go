@@ -0,0 +1,91 @@
+package entity
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+type TestTimelineEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestTimelineEntity", &TestTimelineEntity{}, false)
+}
+
+func (e *TestTimelineEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetTimeline(4, "level", "bag.gold")
+}
+
+func (e *TestTimelineEntity) Heal(amount int) {
+}
+
+func TestTimelineRing(t *testing.T) {
+	tl := newTimeline(3)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		tl.add(TimelineEvent{Time: now.Add(time.Duration(i) * time.Second), Kind: "test", Detail: string('a' + rune(i))})
+	}
+	events := tl.list(time.Time{})
+	if len(events) != 3 || events[0].Detail != "c" || events[2].Detail != "e" {
+		t.Fatalf("wrong events: %+v", events)
+	}
+	if events := tl.list(now.Add(time.Second * 3)); len(events) != 1 || events[0].Detail != "e" {
+		t.Fatalf("wrong events after time: %+v", events)
+	}
+}
+
+func TestTimelineEvents(t *testing.T) {
+	e := CreateEntityLocally("TestTimelineEntity", nil)
+	if events, ok := GetTimeline(e.ID, 0); !ok || len(events) != 0 {
+		t.Fatalf("timeline should not be recorded before bound to client: %+v", events)
+	}
+
+	e.enableTimeline()
+	e.Attrs.SetInt("exp", 100) // not flagged
+	e.Attrs.SetInt("level", 2)
+	bag := NewMapAttr()
+	bag.SetInt("gold", 10)
+	bag.SetInt("silver", 5)
+	e.Attrs.SetMapAttr("bag", bag)
+	bag.SetInt("silver", 6) // not flagged
+	bag.SetInt("gold", 20)
+
+	arg, err := netutil.MSG_PACKER.PackMsg(30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.onCallFromRemote("Heal", [][]byte{arg}, "")
+
+	events, _ := GetTimeline(e.ID, time.Hour)
+	var details []string
+	for _, event := range events {
+		details = append(details, event.Kind+": "+event.Detail)
+	}
+	expected := []string{
+		"attr: level = 2",
+		"attr: bag = map[gold:10 silver:5]",
+		"attr: bag.gold = 20",
+		"rpc: Heal(30) from server",
+	}
+	if strings.Join(details, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("wrong events:\n%s", strings.Join(details, "\n"))
+	}
+
+	md := e.GetMigrateData(common.GenEntityID())
+	data, err := netutil.MSG_PACKER.PackMsg(md, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored entityMigrateData
+	if err := netutil.MSG_PACKER.UnpackMsg(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Timeline) != 4 || !restored.Timeline[3].Time.Equal(events[3].Time) {
+		t.Fatalf("timeline is not kept in migrate data: %+v", restored.Timeline)
+	}
+}
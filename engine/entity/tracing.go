@@ -0,0 +1,24 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+func noTrace() {}
+
+// traceMethod starts the span of the entity method if the caller is traced, and makes it the current span, so that RPCs
+// made by the method are traced in the same trace; the returned function should be called when the method returns
+func (e *Entity) traceMethod(methodName string) func() {
+	parent := tracing.Current()
+	if !parent.IsValid() {
+		return noTrace
+	}
+
+	span := tracing.StartSpan(e.TypeName+"."+methodName, tracing.SpanKindInternal, parent)
+	span.SetAttr("goworld.entity_id", e.ID)
+	tracing.SetCurrent(span.Context())
+	return func() {
+		tracing.SetCurrent(parent)
+		span.End()
+	}
+}
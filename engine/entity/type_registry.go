@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"sort"
+)
+
+// EntityTypeInfo describes a registered entity type for introspection
+type EntityTypeInfo struct {
+	Name          string       `json:"name"`
+	GoType        string       `json:"go_type"`
+	Service       bool         `json:"service"`
+	Persistent    bool         `json:"persistent"`
+	UseAOI        bool         `json:"use_aoi"`
+	AOIDistance   Coord        `json:"aoi_distance,omitempty"`
+	AutoMigrate   bool         `json:"auto_migrate"`
+	Timeline      int          `json:"timeline,omitempty"`
+	Attrs         []AttrInfo   `json:"attrs"`
+	ClientMethods []MethodInfo `json:"client_methods"` // methods which can be called by clients
+}
+
+// AttrInfo describes an attribute declared by DefineAttr
+type AttrInfo struct {
+	Name       string `json:"name"`
+	Client     bool   `json:"client"`
+	AllClients bool   `json:"all_clients"`
+	Persistent bool   `json:"persistent"`
+	Sensitive  bool   `json:"sensitive"`
+}
+
+// MethodInfo describes an RPC method of the entity type
+type MethodInfo struct {
+	Name        string   `json:"name"`
+	OtherClient bool     `json:"other_client"` // can be called by clients of other entities (_AllClients)
+	Args        []string `json:"args"`
+}
+
+// ListEntityTypes returns infos of all registered entity types ordered by name
+func ListEntityTypes() []*EntityTypeInfo {
+	typeNames := make([]string, 0, len(registeredEntityTypes))
+	for typeName := range registeredEntityTypes {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	infos := make([]*EntityTypeInfo, len(typeNames))
+	for i, typeName := range typeNames {
+		infos[i] = registeredEntityTypes[typeName].info(typeName)
+	}
+	return infos
+}
+
+func (desc *EntityTypeDesc) info(typeName string) *EntityTypeInfo {
+	info := &EntityTypeInfo{
+		Name:          typeName,
+		GoType:        desc.entityType.String(),
+		Service:       desc.isService,
+		Persistent:    desc.IsPersistent,
+		UseAOI:        desc.useAOI,
+		AOIDistance:   desc.aoiDistance,
+		AutoMigrate:   desc.autoMigrate,
+		Timeline:      desc.timelineSize,
+		Attrs:         []AttrInfo{},
+		ClientMethods: []MethodInfo{},
+	}
+
+	for attr := range desc.declaredAttrs {
+		info.Attrs = append(info.Attrs, AttrInfo{
+			Name:       attr,
+			Client:     desc.clientAttrs.Contains(attr),
+			AllClients: desc.allClientAttrs.Contains(attr),
+			Persistent: desc.persistentAttrs.Contains(attr),
+			Sensitive:  desc.sensitiveAttrs.Contains(attr),
+		})
+	}
+	sort.Slice(info.Attrs, func(i, j int) bool {
+		return info.Attrs[i].Name < info.Attrs[j].Name
+	})
+
+	for method, rd := range desc.rpcDescs {
+		if rd.Flags&(rfOwnClient|rfOtherClient) == 0 {
+			continue
+		}
+		mi := MethodInfo{Name: method, OtherClient: rd.Flags&rfOtherClient != 0, Args: []string{}}
+		for _, argType := range rd.argTypes() {
+			mi.Args = append(mi.Args, argType.String())
+		}
+		info.ClientMethods = append(info.ClientMethods, mi)
+	}
+	sort.Slice(info.ClientMethods, func(i, j int) bool {
+		return info.ClientMethods[i].Name < info.ClientMethods[j].Name
+	})
+	return info
+}
@@ -0,0 +1,59 @@
+package entity
+
+import (
+	"testing"
+)
+
+type TestRegistryEntity struct {
+	Entity
+}
+
+func init() {
+	RegisterEntity("TestRegistryEntity", &TestRegistryEntity{}, false)
+}
+
+func (e *TestRegistryEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetUseAOI(true, 50)
+	desc.DefineAttr("name", "AllClients")
+	desc.DefineAttr("secret", "Sensitive")
+	desc.DefineAttr("counter")
+}
+
+func (e *TestRegistryEntity) Attack_Client(targetID string, skill int) {
+}
+
+func (e *TestRegistryEntity) Wave_AllClients() {
+}
+
+func (e *TestRegistryEntity) ServerOnly() {
+}
+
+func TestListEntityTypes(t *testing.T) {
+	var info *EntityTypeInfo
+	for _, ti := range ListEntityTypes() {
+		if ti.Name == "TestRegistryEntity" {
+			info = ti
+		}
+	}
+	if info == nil {
+		t.Fatalf("TestRegistryEntity is not listed")
+	}
+	if info.GoType != "entity.TestRegistryEntity" || !info.UseAOI || info.AOIDistance != 50 || info.Service {
+		t.Errorf("wrong entity type info: %+v", info)
+	}
+
+	if len(info.Attrs) != 3 || info.Attrs[0].Name != "counter" || info.Attrs[0].Client ||
+		!info.Attrs[1].AllClients || !info.Attrs[1].Client || !info.Attrs[2].Sensitive {
+		t.Errorf("wrong attrs: %+v", info.Attrs)
+	}
+
+	if len(info.ClientMethods) != 2 {
+		t.Fatalf("wrong client methods: %+v", info.ClientMethods)
+	}
+	if m := info.ClientMethods[0]; m.Name != "Attack" || m.OtherClient || len(m.Args) != 2 || m.Args[0] != "string" || m.Args[1] != "int" {
+		t.Errorf("wrong client method: %+v", m)
+	}
+	if m := info.ClientMethods[1]; m.Name != "Wave" || !m.OtherClient || len(m.Args) != 0 {
+		t.Errorf("wrong client method: %+v", m)
+	}
+}
@@ -0,0 +1,78 @@
+package entity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// OnCallFromClientWithTypedArgs is called by engine when method call with typed arguments from client reaches in the game
+func OnCallFromClientWithTypedArgs(id common.EntityID, method string, typedArgs []byte, clientID common.ClientID) {
+	e := entityManager.get(id)
+	if e == nil {
+		// entity not found, may destroyed before call
+		return
+	}
+
+	rpcDesc := e.typeDesc.rpcDescs[method]
+	if rpcDesc == nil {
+		gwlog.Errorf("%s.OnCallFromClientWithTypedArgs: Method %s is not a valid RPC", e, method)
+		return
+	}
+
+	args, err := proto.DecodeTypedArgs(typedArgs, rpcDesc.argTypes())
+	if err != nil {
+		gwlog.Errorf("%s.OnCallFromClientWithTypedArgs: Method %s: %s", e, method, err)
+		return
+	}
+	e.onCallFromRemote(method, args, clientID)
+}
+
+func (desc *rpcDesc) argTypes() []reflect.Type {
+	argTypes := make([]reflect.Type, desc.NumArgs)
+	for i := range argTypes {
+		argTypes[i] = desc.MethodType.In(i + 1) // skip the receiver
+	}
+	return argTypes
+}
+
+// GenerateRPCArgsProto writes protobuf messages of typed arguments of RPCs which can be called by clients
+//
+// The message of method Method of entity type EntityType is named EntityType_Method_Args, and argument i is field argi.
+func GenerateRPCArgsProto(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "// Code generated by goworld game -rpc-args-proto. DO NOT EDIT.\n\n")
+	fmt.Fprintf(bw, "syntax = \"proto3\";\n\npackage goworld;\n\nimport \"goworld_client.proto\";\n")
+
+	typeNames := make([]string, 0, len(registeredEntityTypes))
+	for typeName := range registeredEntityTypes {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		rpcDescs := registeredEntityTypes[typeName].rpcDescs
+		methods := make([]string, 0, len(rpcDescs))
+		for method, desc := range rpcDescs {
+			if desc.Flags&(rfOwnClient|rfOtherClient) != 0 {
+				methods = append(methods, method)
+			}
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			fmt.Fprintf(bw, "\nmessage %s_%s_Args {\n", typeName, method)
+			for i, argType := range rpcDescs[method].argTypes() {
+				fmt.Fprintf(bw, "  %s arg%d = %d; // %s\n", proto.TypedArgProtoType(argType), i+1, i+1, argType)
+			}
+			fmt.Fprintf(bw, "}\n")
+		}
+	}
+	return bw.Flush()
+}
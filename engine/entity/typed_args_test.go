@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type TestTypedArgsEntity struct {
+	Entity
+}
+
+func (e *TestTypedArgsEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestTypedArgsEntity) Move_Client(x, y float32, name string, items []int) {
+}
+
+func (e *TestTypedArgsEntity) Chat_AllClients(data []byte, count uint) {
+}
+
+func (e *TestTypedArgsEntity) ServerOnly(id int64) {
+}
+
+func TestGenerateRPCArgsProto(t *testing.T) {
+	RegisterEntity("TestTypedArgsEntity", &TestTypedArgsEntity{}, false)
+
+	var buf bytes.Buffer
+	if err := GenerateRPCArgsProto(&buf); err != nil {
+		t.Fatal(err)
+	}
+	protoFile := buf.String()
+	for _, s := range []string{
+		"message TestTypedArgsEntity_Chat_Args {\n  bytes arg1 = 1; // []uint8\n  uint64 arg2 = 2; // uint\n}\n",
+		"message TestTypedArgsEntity_Move_Args {\n  float arg1 = 1; // float32\n  float arg2 = 2; // float32\n  string arg3 = 3; // string\n  Value arg4 = 4; // []int\n}\n",
+	} {
+		if !strings.Contains(protoFile, s) {
+			t.Errorf("message is not generated: %s", s)
+		}
+	}
+	if strings.Contains(protoFile, "ServerOnly") {
+		t.Errorf("server methods should not be generated")
+	}
+	if strings.Index(protoFile, "TestTypedArgsEntity_Chat_Args") > strings.Index(protoFile, "TestTypedArgsEntity_Move_Args") {
+		t.Errorf("messages should be sorted")
+	}
+}
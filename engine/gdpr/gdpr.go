@@ -0,0 +1,370 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/typeconv"
+)
+
+// EntityRef refers to an entity in entity storage
+type EntityRef struct {
+	TypeName string
+	EntityID common.EntityID
+}
+
+// OwnedRecords is the records owned by an entity
+type OwnedRecords struct {
+	Entities []EntityRef // entities owned by the entity, they are also walked recursively
+	KVDBKeys []string    // KVDB keys owned by the entity
+}
+
+// OwnershipMapping returns records owned by the entity using the entity's persistent data
+type OwnershipMapping func(entityID common.EntityID, data map[string]interface{}) OwnedRecords
+
+// AuditLog is an audit log containing records tied to entities, e.g. purchase logs
+//
+// Methods are called in an async job with all entities owned by the account, so they can block on IO
+type AuditLog interface {
+	// ExportRecords returns records of the entities
+	ExportRecords(entities []EntityRef) ([]interface{}, error)
+	// PurgeRecords deletes records of the entities
+	PurgeRecords(entities []EntityRef) error
+}
+
+// ErrAccountNotFound is returned if the account does not exist in entity storage
+var ErrAccountNotFound = errors.New("account not found")
+
+const _GDPR_ASYNC_JOB_GROUP = "_gdpr"
+
+// ExportCallback is the callback type of ExportAccountData
+type ExportCallback func(archive []byte, err error)
+
+// DeleteCallback is the callback type of DeleteAccountData
+type DeleteCallback func(err error)
+
+var (
+	accountTypeName   = "Account"
+	ownershipMappings = map[string]OwnershipMapping{}
+	auditLogs         = map[string]AuditLog{}
+)
+
+// SetAccountType sets the entity type of accounts (Account by default)
+func SetAccountType(typeName string) {
+	accountTypeName = typeName
+}
+
+// RegisterOwnershipMapping registers the ownership mapping of the entity type
+//
+// Entities of types without ownership mappings own nothing except for themselves
+func RegisterOwnershipMapping(typeName string, mapping OwnershipMapping) {
+	if _, ok := ownershipMappings[typeName]; ok {
+		gwlog.Panicf("ownership mapping of %s is already registered", typeName)
+	}
+	ownershipMappings[typeName] = mapping
+}
+
+// RegisterAuditLog registers an audit log, records of accounts in it are exported and deleted along with account data
+func RegisterAuditLog(name string, auditLog AuditLog) {
+	if _, ok := auditLogs[name]; ok {
+		gwlog.Panicf("audit log %s is already registered", name)
+	}
+	auditLogs[name] = auditLog
+}
+
+// accountRecords contains all records of an account found by walking ownership mappings
+type accountRecords struct {
+	entityData  map[EntityRef]map[string]interface{}
+	entityOrder []EntityRef
+	kvdbKeys    common.StringSet
+}
+
+type walkCallback func(records *accountRecords, err error)
+
+// walkAccountRecords loads the account and all records owned by the account recursively
+func walkAccountRecords(accountID common.EntityID, callback walkCallback) {
+	records := &accountRecords{
+		entityData: map[EntityRef]map[string]interface{}{},
+		kvdbKeys:   common.StringSet{},
+	}
+
+	pending := 0
+	var walkErr error
+	finished := false
+
+	var walk func(ref EntityRef)
+	walk = func(ref EntityRef) {
+		if _, ok := records.entityData[ref]; ok {
+			return
+		}
+
+		records.entityData[ref] = nil // mark visited
+		records.entityOrder = append(records.entityOrder, ref)
+		pending += 1
+		storage.Load(ref.TypeName, ref.EntityID, func(data interface{}, err error) {
+			pending -= 1
+			if err != nil && walkErr == nil {
+				walkErr = errors.Wrapf(err, "load %s %s failed", ref.TypeName, ref.EntityID)
+			}
+
+			if err == nil && data != nil {
+				entityData := typeconv.MapStringAnything(data)
+				records.entityData[ref] = entityData
+				if mapping, ok := ownershipMappings[ref.TypeName]; ok {
+					owned := mapping(ref.EntityID, entityData)
+					for _, key := range owned.KVDBKeys {
+						records.kvdbKeys.Add(key)
+					}
+					for _, ownedRef := range owned.Entities {
+						walk(ownedRef)
+					}
+				}
+			}
+
+			if pending == 0 && !finished {
+				finished = true
+				if walkErr == nil && records.entityData[EntityRef{accountTypeName, accountID}] == nil {
+					walkErr = errors.Wrapf(ErrAccountNotFound, "%s %s", accountTypeName, accountID)
+				}
+				callback(records, walkErr)
+			}
+		})
+	}
+
+	walk(EntityRef{accountTypeName, accountID})
+}
+
+// ExportAccountData exports all records owned by the account as a zip archive
+//
+// Entity data are exported as JSON files in entities/, KVDB items are exported in kvdb.json,
+// and records in audit logs are exported as JSON files in audit/.
+// ErrAccountNotFound is returned if the account does not exist
+func ExportAccountData(accountID common.EntityID, callback ExportCallback) {
+	walkAccountRecords(accountID, func(records *accountRecords, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		kvItems := map[string]string{}
+		var auditRecords map[string][]interface{}
+		pending := len(records.kvdbKeys) + 1
+		var getErr error
+		onGot := func(err error) {
+			pending -= 1
+			if err != nil && getErr == nil {
+				getErr = err
+			}
+			if pending == 0 {
+				if getErr != nil {
+					callback(nil, getErr)
+					return
+				}
+				archive, err := makeExportArchive(accountID, records, kvItems, auditRecords)
+				callback(archive, err)
+			}
+		}
+
+		for key := range records.kvdbKeys {
+			key := key
+			kvdb.Get(key, func(val string, err error) {
+				if err != nil {
+					err = errors.Wrapf(err, "get KVDB key %s failed", key)
+				} else if val != "" {
+					kvItems[key] = val
+				}
+				onGot(err)
+			})
+		}
+
+		exportAuditRecords(records.entityOrder, func(res map[string][]interface{}, err error) {
+			auditRecords = res
+			onGot(err)
+		})
+	})
+}
+
+// exportAuditRecords exports records of the entities in all audit logs
+func exportAuditRecords(entities []EntityRef, callback func(auditRecords map[string][]interface{}, err error)) {
+	logs := make(map[string]AuditLog, len(auditLogs))
+	for name, auditLog := range auditLogs {
+		logs[name] = auditLog
+	}
+
+	async.AppendAsyncJob(_GDPR_ASYNC_JOB_GROUP, func() (interface{}, error) {
+		auditRecords := map[string][]interface{}{}
+		for name, auditLog := range logs {
+			records, err := auditLog.ExportRecords(entities)
+			if err != nil {
+				return nil, errors.Wrapf(err, "export records in audit log %s failed", name)
+			}
+			if len(records) > 0 {
+				auditRecords[name] = records
+			}
+		}
+		return auditRecords, nil
+	}, func(res interface{}, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		callback(res.(map[string][]interface{}), nil)
+	})
+}
+
+// purgeAuditRecords deletes records of the entities in all audit logs
+func purgeAuditRecords(entities []EntityRef, callback DeleteCallback) {
+	logs := make(map[string]AuditLog, len(auditLogs))
+	for name, auditLog := range auditLogs {
+		logs[name] = auditLog
+	}
+
+	async.AppendAsyncJob(_GDPR_ASYNC_JOB_GROUP, func() (interface{}, error) {
+		for name, auditLog := range logs {
+			if err := auditLog.PurgeRecords(entities); err != nil {
+				return nil, errors.Wrapf(err, "purge records in audit log %s failed", name)
+			}
+		}
+		return nil, nil
+	}, func(_ interface{}, err error) {
+		callback(err)
+	})
+}
+
+func makeExportArchive(accountID common.EntityID, records *accountRecords, kvItems map[string]string, auditRecords map[string][]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, ref := range records.entityOrder {
+		data := records.entityData[ref]
+		if data == nil {
+			continue
+		}
+
+		if err := writeArchiveJSON(zw, fmt.Sprintf("entities/%s/%s.json", ref.TypeName, ref.EntityID), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeArchiveJSON(zw, "kvdb.json", kvItems); err != nil {
+		return nil, err
+	}
+
+	for name, records := range auditRecords {
+		if err := writeArchiveJSON(zw, fmt.Sprintf("audit/%s.json", name), records); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "close archive failed")
+	}
+
+	gwlog.Infof("gdpr: exported account %s: %d entities, %d KVDB items, %d audit logs, archive size %d", accountID, len(records.entityOrder), len(kvItems), len(auditRecords), buf.Len())
+	return buf.Bytes(), nil
+}
+
+func writeArchiveJSON(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return errors.Wrapf(err, "create %s in archive failed", name)
+	}
+
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s failed", name)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// DeleteAccountData deletes all records owned by the account, including records in audit logs, and verifies that they are purged
+//
+// ErrAccountNotFound is returned if the account does not exist.
+// Loaded entities should be destroyed before calling DeleteAccountData, otherwise they will be saved again
+func DeleteAccountData(accountID common.EntityID, callback DeleteCallback) {
+	walkAccountRecords(accountID, func(records *accountRecords, err error) {
+		if err != nil {
+			callback(err)
+			return
+		}
+
+		pending := len(records.entityOrder) + len(records.kvdbKeys) + 1
+		var delErr error
+		onDeleted := func(err error) {
+			pending -= 1
+			if err != nil && delErr == nil {
+				delErr = err
+			}
+			if pending == 0 {
+				if delErr != nil {
+					callback(delErr)
+				} else {
+					verifyAccountDataDeleted(accountID, records, callback)
+				}
+			}
+		}
+
+		for _, ref := range records.entityOrder {
+			storage.Purge(ref.TypeName, ref.EntityID, onDeleted)
+		}
+		for key := range records.kvdbKeys {
+			kvdb.Del(key, onDeleted)
+		}
+		purgeAuditRecords(records.entityOrder, onDeleted)
+	})
+}
+
+func verifyAccountDataDeleted(accountID common.EntityID, records *accountRecords, callback DeleteCallback) {
+	pending := len(records.entityOrder) + len(records.kvdbKeys) + 1
+	var verifyErr error
+	onVerified := func(err error) {
+		pending -= 1
+		if err != nil && verifyErr == nil {
+			verifyErr = err
+		}
+		if pending == 0 {
+			if verifyErr == nil {
+				gwlog.Infof("gdpr: deleted account %s: %d entities, %d KVDB keys", accountID, len(records.entityOrder), len(records.kvdbKeys))
+			}
+			callback(verifyErr)
+		}
+	}
+
+	for _, ref := range records.entityOrder {
+		ref := ref
+		storage.Exists(ref.TypeName, ref.EntityID, func(exists bool, err error) {
+			if err == nil && exists {
+				err = errors.Errorf("%s %s still exists after deleted", ref.TypeName, ref.EntityID)
+			}
+			onVerified(err)
+		})
+	}
+	for key := range records.kvdbKeys {
+		key := key
+		kvdb.Get(key, func(val string, err error) {
+			if err == nil && val != "" {
+				err = errors.Errorf("KVDB key %s still exists after deleted", key)
+			}
+			onVerified(err)
+		})
+	}
+	exportAuditRecords(records.entityOrder, func(auditRecords map[string][]interface{}, err error) {
+		if err == nil {
+			for name := range auditRecords {
+				err = errors.Errorf("records in audit log %s still exist after deleted", name)
+				break
+			}
+		}
+		onVerified(err)
+	})
+}
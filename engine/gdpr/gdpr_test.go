@@ -0,0 +1,219 @@
+package gdpr
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+// testAuditLog is an audit log of records keyed by entity IDs
+type testAuditLog struct {
+	sync.Mutex
+	records map[common.EntityID][]interface{}
+}
+
+func (l *testAuditLog) ExportRecords(entities []EntityRef) ([]interface{}, error) {
+	l.Lock()
+	defer l.Unlock()
+	var records []interface{}
+	for _, ref := range entities {
+		records = append(records, l.records[ref.EntityID]...)
+	}
+	return records, nil
+}
+
+func (l *testAuditLog) PurgeRecords(entities []EntityRef) error {
+	l.Lock()
+	defer l.Unlock()
+	for _, ref := range entities {
+		delete(l.records, ref.EntityID)
+	}
+	return nil
+}
+
+var (
+	auditLog   = &testAuditLog{records: map[common.EntityID][]interface{}{}}
+	storageDir string
+)
+
+func init() {
+	var err error
+	storageDir, err = ioutil.TempDir("", "goworld_gdpr")
+	if err != nil {
+		panic(err)
+	}
+
+	engine := kvdbtest.NewMemoryEngine()
+	kvdb.Register("gdprtest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return engine, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "gdprtest")
+	conf.SetOverride("storage", "type", "filesystem")
+	conf.SetOverride("storage", "directory", storageDir)
+	kvdb.Initialize(conf)
+	storage.Initialize(conf)
+
+	RegisterOwnershipMapping("Account", func(entityID common.EntityID, data map[string]interface{}) OwnedRecords {
+		avatarID := common.EntityID(data["avatar"].(string))
+		return OwnedRecords{Entities: []EntityRef{{"Avatar", avatarID}}, KVDBKeys: []string{"mail$" + string(avatarID)}}
+	})
+	RegisterAuditLog("purchase", auditLog)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.RemoveAll(storageDir)
+	os.Exit(code)
+}
+
+// wait ticks the game routine until the callback is called
+func wait(t *testing.T, done func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("callback is not called in time")
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// createAccount saves an account owning an avatar, a KVDB item and an audit record
+func createAccount(t *testing.T) (accountID common.EntityID, avatarID common.EntityID) {
+	accountID, avatarID = common.GenEntityID(), common.GenEntityID()
+	pending := 3
+	onDone := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending -= 1
+	}
+	storage.Save("Account", accountID, map[string]interface{}{"avatar": string(avatarID)}, func() { onDone(nil) })
+	storage.Save("Avatar", avatarID, map[string]interface{}{"name": "foo"}, func() { onDone(nil) })
+	kvdb.Put("mail$"+string(avatarID), "hello", onDone)
+	wait(t, func() bool { return pending == 0 })
+
+	auditLog.Lock()
+	auditLog.records[avatarID] = []interface{}{map[string]interface{}{"item": "sword"}}
+	auditLog.Unlock()
+	return
+}
+
+func exportAccount(t *testing.T, accountID common.EntityID) (names common.StringSet, err error) {
+	var archive []byte
+	done := false
+	ExportAccountData(accountID, func(a []byte, e error) {
+		archive, err, done = a, e, true
+	})
+	wait(t, func() bool { return done })
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = common.StringSet{}
+	for _, f := range zr.File {
+		names.Add(f.Name)
+	}
+	return names, nil
+}
+
+func deleteAccount(t *testing.T, accountID common.EntityID) (err error) {
+	done := false
+	DeleteAccountData(accountID, func(e error) {
+		err, done = e, true
+	})
+	wait(t, func() bool { return done })
+	return
+}
+
+func TestExportAccountData(t *testing.T) {
+	accountID, avatarID := createAccount(t)
+	names, err := exportAccount(t, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"entities/Account/" + string(accountID) + ".json", "entities/Avatar/" + string(avatarID) + ".json", "kvdb.json", "audit/purchase.json"} {
+		if !names.Contains(name) {
+			t.Errorf("%s not found in archive: %v", name, names.ToList())
+		}
+	}
+}
+
+func TestDeleteAccountData(t *testing.T) {
+	accountID, avatarID := createAccount(t)
+	if err := deleteAccount(t, accountID); err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog.Lock()
+	_, ok := auditLog.records[avatarID]
+	auditLog.Unlock()
+	if ok {
+		t.Errorf("audit records should be purged")
+	}
+	if _, err := exportAccount(t, accountID); errors.Cause(err) != ErrAccountNotFound {
+		t.Errorf("deleted account should not be found: %v", err)
+	}
+}
+
+func TestUnknownAccount(t *testing.T) {
+	accountID := common.GenEntityID()
+	if _, err := exportAccount(t, accountID); errors.Cause(err) != ErrAccountNotFound {
+		t.Errorf("export of unknown account should fail with ErrAccountNotFound: %v", err)
+	}
+	if err := deleteAccount(t, accountID); errors.Cause(err) != ErrAccountNotFound {
+		t.Errorf("delete of unknown account should fail with ErrAccountNotFound: %v", err)
+	}
+}
+
+func TestMakeExportArchive(t *testing.T) {
+	accountID := common.GenEntityID()
+	avatarID := common.GenEntityID()
+	records := &accountRecords{
+		entityData: map[EntityRef]map[string]interface{}{
+			{"Account", accountID}: {"avatar": string(avatarID)},
+			{"Avatar", avatarID}:   {"name": "foo"},
+		},
+		entityOrder: []EntityRef{{"Account", accountID}, {"Avatar", avatarID}},
+		kvdbKeys:    common.StringSet{},
+	}
+	records.kvdbKeys.Add("mail$" + string(avatarID))
+
+	archive, err := makeExportArchive(accountID, records, map[string]string{"mail$" + string(avatarID): "hello"}, map[string][]interface{}{"purchase": {"sword"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := common.StringSet{}
+	for _, f := range zr.File {
+		names.Add(f.Name)
+	}
+	for _, name := range []string{"entities/Account/" + string(accountID) + ".json", "entities/Avatar/" + string(avatarID) + ".json", "kvdb.json", "audit/purchase.json"} {
+		if !names.Contains(name) {
+			t.Errorf("%s not found in archive: %v", name, names.ToList())
+		}
+	}
+}
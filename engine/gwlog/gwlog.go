@@ -1,7 +1,11 @@
 package gwlog
 
 import (
+	"os"
+	"runtime"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 
 	"strings"
 
@@ -39,6 +43,11 @@ var (
 	sugar        *zap.SugaredLogger
 	source       string
 	currentLevel Level
+	rotation     Rotation
+	outputFiles  []*rotatingFile
+
+	moduleLevels  atomic.Value // map[string]Level, levels of modules which override the current level
+	callerModules sync.Map     // caller PC => module name
 )
 
 func init() {
@@ -60,6 +69,7 @@ func init() {
 		panic(err)
 	}
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	moduleLevels.Store(map[string]Level{})
 	rebuildLoggerFromCfg()
 }
 
@@ -69,12 +79,114 @@ func SetSource(source_ string) {
 	rebuildLoggerFromCfg()
 }
 
+// GetSource returns the component name set by SetSource
+func GetSource() string {
+	return source
+}
+
 // SetLevel sets the log level
 func SetLevel(lv Level) {
 	currentLevel = lv
+	updateZapLevel()
+}
+
+// SetModuleLevel sets the log level of the module, which overrides the current log level
+//
+// Module of a log is the last element of the package path of the caller, e.g. dispatcherclient.
+func SetModuleLevel(module string, lv Level) {
+	levels := map[string]Level{}
+	for m, l := range moduleLevels.Load().(map[string]Level) {
+		levels[m] = l
+	}
+	levels[module] = lv
+	moduleLevels.Store(levels)
+	updateZapLevel()
+}
+
+// SetLevels sets the log level and levels of modules from the spec, e.g. "info,dispatcherclient=debug"
+//
+// Module levels which are not in the spec are cleared.
+func SetLevels(spec string) {
+	lv := currentLevel
+	levels := map[string]Level{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if eq := strings.IndexByte(item, '='); eq >= 0 {
+			levels[strings.TrimSpace(item[:eq])] = ParseLevel(strings.TrimSpace(item[eq+1:]))
+		} else {
+			lv = ParseLevel(item)
+		}
+	}
+	currentLevel = lv
+	moduleLevels.Store(levels)
+	updateZapLevel()
+}
+
+// updateZapLevel sets the level of zap logger to the lowest level of the current level and module levels
+func updateZapLevel() {
+	lv := currentLevel
+	for _, l := range moduleLevels.Load().(map[string]Level) {
+		if l < lv {
+			lv = l
+		}
+	}
 	cfg.Level.SetLevel(lv)
 }
 
+// enabled checks if logs of the level are enabled for the module of the caller
+func enabled(lv Level) bool {
+	levels := moduleLevels.Load().(map[string]Level)
+	if len(levels) == 0 {
+		return currentLevel.Enabled(lv)
+	}
+
+	if l, ok := levels[callerModule(3)]; ok {
+		return l.Enabled(lv)
+	}
+	return currentLevel.Enabled(lv)
+}
+
+// callerModule returns the last element of the package path of the caller
+func callerModule(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return ""
+	}
+	if module, ok := callerModules.Load(pc); ok {
+		return module.(string)
+	}
+
+	module := ""
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		module = fn.Name()                                     // e.g. github.com/xiaonanln/goworld/engine/gwlog.Infof
+		module = module[strings.LastIndexByte(module, '/')+1:] // e.g. gwlog.Infof
+		if dot := strings.IndexByte(module, '.'); dot >= 0 {
+			module = module[:dot]
+		}
+	}
+	callerModules.Store(pc, module)
+	return module
+}
+
+// SetFormat sets the log format: console or json
+func SetFormat(format string) {
+	if format != "console" && format != "json" {
+		Errorf("SetFormat: unknown log format: %s", format)
+		return
+	}
+	cfg.Encoding = format
+	rebuildLoggerFromCfg()
+}
+
+// SetRotation sets the rotation of log files
+func SetRotation(r Rotation) {
+	rotation = r
+	rebuildLoggerFromCfg()
+}
+
 // GetLevel get the current log level
 func GetLevel() Level {
 	return currentLevel
@@ -82,8 +194,11 @@ func GetLevel() Level {
 
 // TraceError prints the stack and error
 func TraceError(format string, args ...interface{}) {
-	Error(string(debug.Stack()))
-	Errorf(format, args...)
+	if !enabled(ErrorLevel) {
+		return
+	}
+	sugar.With(zap.Time("ts", time.Now())).Error(string(debug.Stack()))
+	sugar.With(zap.Time("ts", time.Now())).Errorf(format, args...)
 }
 
 // SetOutput sets the output writer
@@ -94,6 +209,7 @@ func SetOutput(outputs []string) {
 
 // ParseLevel converts string to Levels
 func ParseLevel(s string) Level {
+	s = strings.TrimSpace(s)
 	if strings.ToLower(s) == "debug" {
 		return DebugLevel
 	} else if strings.ToLower(s) == "info" {
@@ -112,35 +228,68 @@ func ParseLevel(s string) Level {
 }
 
 func rebuildLoggerFromCfg() {
-	if newLogger, err := cfg.Build(); err == nil {
-		if logger != nil {
-			logger.Sync()
-		}
-		logger = newLogger
-		//logger = logger.With(zap.Time("ts", time.Now()))
-		if source != "" {
-			logger = logger.With(zap.String("source", source))
+	var writers []zapcore.WriteSyncer
+	var files []*rotatingFile
+	for _, path := range cfg.OutputPaths {
+		if path == "stderr" {
+			writers = append(writers, zapcore.Lock(os.Stderr))
+		} else if path == "stdout" {
+			writers = append(writers, zapcore.Lock(os.Stdout))
+		} else {
+			f, err := openRotatingFile(path, rotation)
+			if err != nil {
+				panic(err)
+			}
+			writers = append(writers, f)
+			files = append(files, f)
 		}
-		setSugar(logger.Sugar())
+	}
+
+	var encoder zapcore.Encoder
+	if cfg.Encoding == "json" {
+		encoder = zapcore.NewJSONEncoder(cfg.EncoderConfig)
 	} else {
-		panic(err)
+		encoder = zapcore.NewConsoleEncoder(cfg.EncoderConfig)
+	}
+
+	newLogger := zap.New(zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(writers...), cfg.Level), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+	if logger != nil {
+		logger.Sync()
+	}
+	logger = newLogger
+	if source != "" {
+		logger = logger.With(zap.String("source", source))
+	}
+	setSugar(logger.Sugar())
+
+	for _, f := range outputFiles {
+		f.Close()
 	}
+	outputFiles = files
 }
 
 func Debugf(format string, args ...interface{}) {
-	sugar.With(zap.Time("ts", time.Now())).Debugf(format, args...)
+	if enabled(DebugLevel) {
+		sugar.With(zap.Time("ts", time.Now())).Debugf(format, args...)
+	}
 }
 
 func Infof(format string, args ...interface{}) {
-	sugar.With(zap.Time("ts", time.Now())).Infof(format, args...)
+	if enabled(InfoLevel) {
+		sugar.With(zap.Time("ts", time.Now())).Infof(format, args...)
+	}
 }
 
 func Warnf(format string, args ...interface{}) {
-	sugar.With(zap.Time("ts", time.Now())).Warnf(format, args...)
+	if enabled(WarnLevel) {
+		sugar.With(zap.Time("ts", time.Now())).Warnf(format, args...)
+	}
 }
 
 func Errorf(format string, args ...interface{}) {
-	sugar.With(zap.Time("ts", time.Now())).Errorf(format, args...)
+	if enabled(ErrorLevel) {
+		sugar.With(zap.Time("ts", time.Now())).Errorf(format, args...)
+	}
 }
 
 func Panicf(format string, args ...interface{}) {
@@ -153,7 +302,9 @@ func Fatalf(format string, args ...interface{}) {
 }
 
 func Error(args ...interface{}) {
-	sugar.With(zap.Time("ts", time.Now())).Error(args...)
+	if enabled(ErrorLevel) {
+		sugar.With(zap.Time("ts", time.Now())).Error(args...)
+	}
 }
 
 func Panic(args ...interface{}) {
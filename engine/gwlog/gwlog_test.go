@@ -46,3 +46,25 @@ func TestGWLog(t *testing.T) {
 		//Fatalf("this is a fatal %d", 5)
 	}()
 }
+
+func TestSetLevels(t *testing.T) {
+	defer SetLevels("debug")
+
+	SetLevels("error, gwlog=info")
+	if GetLevel() != ErrorLevel {
+		t.Errorf("level is %s", GetLevel())
+	}
+	if !checkEnabled(InfoLevel) || checkEnabled(DebugLevel) {
+		t.Errorf("module level of gwlog is not applied")
+	}
+
+	SetLevels("warn")
+	if checkEnabled(InfoLevel) || !checkEnabled(WarnLevel) {
+		t.Errorf("module level of gwlog is not cleared")
+	}
+}
+
+// checkEnabled calls enabled like log functions, so that the caller module is gwlog
+func checkEnabled(lv Level) bool {
+	return enabled(lv)
+}
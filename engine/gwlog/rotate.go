@@ -0,0 +1,148 @@
+package gwlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	_ROTATED_TIME_FORMAT = "20060102-150405"
+)
+
+// Rotation configures rotation of log files
+//
+// Rotated files are renamed to <file>.<time>, e.g. game.log.20180101-000000.
+type Rotation struct {
+	MaxSize    int64         // rotate if file size exceeds MaxSize bytes, 0 means no limit
+	Interval   time.Duration // rotate at multiples of Interval (in UTC), 0 means no time-based rotation
+	MaxBackups int           // number of rotated files to keep, 0 means keep all
+}
+
+// rotatingFile is a log file which rotates according to Rotation
+type rotatingFile struct {
+	sync.Mutex
+	path         string
+	rotation     Rotation
+	file         *os.File
+	size         int64
+	nextRotation time.Time
+}
+
+func openRotatingFile(path string, rotation Rotation) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, rotation: rotation}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	if f.rotation.Interval > 0 {
+		f.nextRotation = time.Now().Truncate(f.rotation.Interval).Add(f.rotation.Interval)
+	}
+	return nil
+}
+
+// Write writes p to the file, and rotates the file before writing if necessary
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.size > 0 && ((f.rotation.MaxSize > 0 && f.size+int64(len(p)) > f.rotation.MaxSize) ||
+		(f.rotation.Interval > 0 && !time.Now().Before(f.nextRotation))) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "gwlog: rotate %s failed: %s\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync commits the file to disk
+func (f *rotatingFile) Sync() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file
+func (f *rotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	rotatedPath := f.path + "." + time.Now().Format(_ROTATED_TIME_FORMAT)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotatedPath); os.IsNotExist(err) {
+			break
+		}
+		rotatedPath = fmt.Sprintf("%s.%s-%d", f.path, time.Now().Format(_ROTATED_TIME_FORMAT), i)
+	}
+
+	renameErr := os.Rename(f.path, rotatedPath)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	f.removeOldBackups()
+	return nil
+}
+
+// removeOldBackups removes the oldest rotated files if there are more than MaxBackups
+func (f *rotatingFile) removeOldBackups() {
+	if f.rotation.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(f.path + ".[0-9]*")
+	if err != nil || len(matches) <= f.rotation.MaxBackups {
+		return
+	}
+
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-f.rotation.MaxBackups] {
+		os.Remove(path)
+	}
+}
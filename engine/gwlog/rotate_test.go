@@ -0,0 +1,37 @@
+package gwlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.log")
+	f, err := openRotatingFile(path, Rotation{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 2 {
+		t.Errorf("expect 2 backups, but got %v", backups)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 10 {
+		t.Errorf("log file is not rotated: %v, %v", info, err)
+	}
+}
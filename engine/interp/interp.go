@@ -0,0 +1,149 @@
+// Package interp implements the client side interpolation buffer for timestamped entity sync packets
+//
+// Entity sync packets (MT_SYNC_POSITION_YAW_ON_CLIENTS and MT_SYNC_INPUT_ACK_ON_CLIENTS) begin with the server tick (uint32)
+// and the server time in milliseconds (uint64) when sync infos are collected. Client SDKs should render entities as follows:
+//
+//  1. Estimate the offset between server time and local time from the timestamps of received packets (see Clock).
+//  2. Push position & yaw of each entity into the buffer of the entity, ordered by server tick. Snapshots with older or
+//     duplicated ticks are dropped.
+//  3. Render entities at (estimated server time - interpolation delay). The delay should be at least twice the position sync
+//     interval of game (e.g. 200ms for 10Hz server updates), so that there are two snapshots to interpolate between most of the time.
+//  4. Positions are interpolated linearly, and yaws (in degrees) are interpolated along the shorter direction. If the render time
+//     is after the newest snapshot, the newest snapshot is held instead of extrapolating.
+//
+// The own entity of the client should be predicted by inputs and reconciled by input acknowledgements, not interpolated.
+package interp
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DEFAULT_CAPACITY is the default count of snapshots kept in a buffer
+	DEFAULT_CAPACITY = 32
+	// clockSmoothing is the weight of new samples when estimating server time offset
+	clockSmoothing = 0.1
+)
+
+// Snapshot is the position & yaw of an entity at the server tick
+type Snapshot struct {
+	Tick       uint32
+	ServerTime int64 // in milliseconds
+	X, Y, Z    float32
+	Yaw        float32
+}
+
+// Buffer keeps recent snapshots of an entity, ordered by server tick
+type Buffer struct {
+	snapshots []Snapshot
+	capacity  int
+}
+
+// NewBuffer creates a buffer which keeps at most capacity snapshots
+func NewBuffer(capacity int) *Buffer {
+	if capacity < 2 {
+		capacity = DEFAULT_CAPACITY
+	}
+	return &Buffer{
+		snapshots: make([]Snapshot, 0, capacity),
+		capacity:  capacity,
+	}
+}
+
+// Len returns the count of snapshots in the buffer
+func (b *Buffer) Len() int {
+	return len(b.snapshots)
+}
+
+// Push adds the snapshot to the buffer, returns false if the snapshot is dropped because it is not newer than the latest one
+func (b *Buffer) Push(s Snapshot) bool {
+	if n := len(b.snapshots); n > 0 && !isNewerTick(s.Tick, b.snapshots[n-1].Tick) {
+		return false
+	}
+
+	if len(b.snapshots) == b.capacity {
+		copy(b.snapshots, b.snapshots[1:])
+		b.snapshots = b.snapshots[:len(b.snapshots)-1]
+	}
+	b.snapshots = append(b.snapshots, s)
+	return true
+}
+
+// Sample returns the interpolated snapshot at server time renderTime (in milliseconds)
+func (b *Buffer) Sample(renderTime int64) (Snapshot, bool) {
+	n := len(b.snapshots)
+	if n == 0 {
+		return Snapshot{}, false
+	}
+	if renderTime <= b.snapshots[0].ServerTime {
+		return b.snapshots[0], true
+	}
+	if renderTime >= b.snapshots[n-1].ServerTime {
+		return b.snapshots[n-1], true
+	}
+
+	i := 1
+	for b.snapshots[i].ServerTime < renderTime {
+		i += 1
+	}
+	from, to := b.snapshots[i-1], b.snapshots[i]
+	t := float32(renderTime-from.ServerTime) / float32(to.ServerTime-from.ServerTime)
+	return Snapshot{
+		Tick:       from.Tick,
+		ServerTime: renderTime,
+		X:          lerp(from.X, to.X, t),
+		Y:          lerp(from.Y, to.Y, t),
+		Z:          lerp(from.Z, to.Z, t),
+		Yaw:        lerpYaw(from.Yaw, to.Yaw, t),
+	}, true
+}
+
+// Clock estimates server time from timestamps of sync packets
+type Clock struct {
+	offset      float64 // server time - local time, in milliseconds
+	initialized bool
+}
+
+// Observe updates the offset by the server time of a packet which is received at local time now
+func (c *Clock) Observe(serverTime int64, now time.Time) {
+	offset := float64(serverTime - toMillis(now))
+	if !c.initialized {
+		c.offset = offset
+		c.initialized = true
+	} else {
+		c.offset += (offset - c.offset) * clockSmoothing
+	}
+}
+
+// RenderTime returns the server time (in milliseconds) to render entities at local time now
+func (c *Clock) RenderTime(now time.Time, delay time.Duration) int64 {
+	return toMillis(now) + int64(c.offset) - int64(delay/time.Millisecond)
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// isNewerTick returns if tick is newer than last, considering wrapping around of ticks
+func isNewerTick(tick, last uint32) bool {
+	return int32(tick-last) > 0
+}
+
+func lerp(from, to, t float32) float32 {
+	return from + (to-from)*t
+}
+
+func lerpYaw(from, to, t float32) float32 {
+	delta := math.Mod(float64(to-from), 360)
+	if delta > 180 {
+		delta -= 360
+	} else if delta < -180 {
+		delta += 360
+	}
+	yaw := math.Mod(float64(from)+delta*float64(t), 360)
+	if yaw < 0 {
+		yaw += 360
+	}
+	return float32(yaw)
+}
@@ -0,0 +1,61 @@
+package interp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBufferSample(t *testing.T) {
+	b := NewBuffer(3)
+	if _, ok := b.Sample(0); ok {
+		t.Fatalf("empty buffer should not be sampled")
+	}
+
+	b.Push(Snapshot{Tick: 1, ServerTime: 1000, X: 0, Yaw: 350})
+	b.Push(Snapshot{Tick: 2, ServerTime: 1100, X: 10, Yaw: 10})
+	if b.Push(Snapshot{Tick: 2, ServerTime: 1100, X: 100}) {
+		t.Errorf("duplicated tick should be dropped")
+	}
+
+	s, _ := b.Sample(1050)
+	if s.X != 5 || s.Yaw != 0 {
+		t.Errorf("wrong interpolation: %+v", s)
+	}
+	if s, _ := b.Sample(900); s.Tick != 1 {
+		t.Errorf("oldest snapshot should be used before buffered snapshots: %+v", s)
+	}
+	if s, _ := b.Sample(1200); s.Tick != 2 || s.X != 10 {
+		t.Errorf("newest snapshot should be held after buffered snapshots: %+v", s)
+	}
+
+	b.Push(Snapshot{Tick: 3, ServerTime: 1200})
+	b.Push(Snapshot{Tick: 4, ServerTime: 1300})
+	if b.Len() != 3 {
+		t.Errorf("buffer should be limited by capacity: %d", b.Len())
+	}
+	if s, _ := b.Sample(0); s.Tick != 2 {
+		t.Errorf("oldest snapshot should be dropped: %+v", s)
+	}
+}
+
+func TestTickWrapping(t *testing.T) {
+	b := NewBuffer(0)
+	b.Push(Snapshot{Tick: 0xffffffff})
+	if !b.Push(Snapshot{Tick: 0}) {
+		t.Errorf("wrapped tick should be newer")
+	}
+}
+
+func TestClock(t *testing.T) {
+	var c Clock
+	now := time.Unix(100, 0)
+	c.Observe(50000, now)
+	if rt := c.RenderTime(now.Add(time.Second), 200*time.Millisecond); rt != 50800 {
+		t.Errorf("wrong render time: %d", rt)
+	}
+
+	c.Observe(50000+1000, now) // offset increased by 1000ms, smoothed
+	if rt := c.RenderTime(now, 0); rt != 50100 {
+		t.Errorf("wrong smoothed render time: %d", rt)
+	}
+}
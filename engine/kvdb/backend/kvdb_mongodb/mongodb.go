@@ -4,6 +4,8 @@ import (
 	"gopkg.in/mgo.v2"
 
 	"io"
+	"strconv"
+	"time"
 
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
@@ -13,8 +15,25 @@ import (
 const (
 	_DEFAULT_DB_NAME = "goworld"
 	_VAL_KEY         = "_"
+	_EXPIRE_AT_KEY   = "expire_at"
 )
 
+// kvDoc is the document of key-value items, expired documents are removed by the TTL index of expire_at
+type kvDoc struct {
+	Key      string     `bson:"_id"`
+	Val      string     `bson:"_"`
+	ExpireAt *time.Time `bson:"expire_at,omitempty"`
+}
+
+func (doc *kvDoc) expired() bool {
+	return doc.ExpireAt != nil && !time.Now().Before(*doc.ExpireAt)
+}
+
+// notExpired matches documents which have no TTL or are not expired yet
+func notExpired() bson.M {
+	return bson.M{"$or": []bson.M{{_EXPIRE_AT_KEY: nil}, {_EXPIRE_AT_KEY: bson.M{"$gt": time.Now()}}}}
+}
+
 type mongoKVDB struct {
 	s *mgo.Session
 	c *mgo.Collection
@@ -35,6 +54,10 @@ func OpenMongoKVDB(url string, dbname string, collectionName string) (kvdbtypes.
 	}
 	db := session.DB(dbname)
 	c := db.C(collectionName)
+	if err := c.EnsureIndex(mgo.Index{Key: []string{_EXPIRE_AT_KEY}, ExpireAfter: time.Second}); err != nil {
+		session.Close()
+		return nil, err
+	}
 	return &mongoKVDB{
 		s: session,
 		c: c,
@@ -48,9 +71,17 @@ func (kvdb *mongoKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (kvdb *mongoKVDB) Del(key string) error {
+	err := kvdb.c.RemoveId(key)
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
 func (kvdb *mongoKVDB) Get(key string) (val string, err error) {
 	q := kvdb.c.FindId(key)
-	var doc map[string]string
+	var doc kvDoc
 	err = q.One(&doc)
 	if err != nil {
 		if err == mgo.ErrNotFound {
@@ -58,21 +89,100 @@ func (kvdb *mongoKVDB) Get(key string) (val string, err error) {
 		}
 		return
 	}
-	val = doc[_VAL_KEY]
+	if !doc.expired() { // expired documents might not be removed yet
+		val = doc.Val
+	}
 	return
 }
 
+func (kvdb *mongoKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	return kvdb.compareAndSwap(key, oldVal, newVal, false)
+}
+
+// compareAndSwap swaps the value by updating the document only if it has the old value
+func (kvdb *mongoKVDB) compareAndSwap(key string, oldVal string, newVal string, keepTTL bool) (bool, error) {
+	update := bson.M{"$set": bson.M{_VAL_KEY: newVal}}
+	if !keepTTL {
+		update["$unset"] = bson.M{_EXPIRE_AT_KEY: 1}
+	}
+
+	if oldVal != "" {
+		err := kvdb.c.Update(bson.M{"$and": []bson.M{{"_id": key, _VAL_KEY: oldVal}, notExpired()}}, update)
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err := kvdb.c.Insert(bson.M{"_id": key, _VAL_KEY: newVal})
+	if !mgo.IsDup(err) {
+		return err == nil, err
+	}
+
+	// the document exists, swap if the value is "" or expired
+	update["$unset"] = bson.M{_EXPIRE_AT_KEY: 1}
+	err = kvdb.c.Update(bson.M{"_id": key, "$or": []bson.M{{_VAL_KEY: ""}, {_EXPIRE_AT_KEY: bson.M{"$lte": time.Now()}}}}, update)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr is emulated by compare-and-swap in loop, because values are stored as strings
+func (kvdb *mongoKVDB) Incr(key string, delta int64) (int64, error) {
+	for {
+		oldVal, err := kvdb.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var val int64
+		if oldVal != "" {
+			if val, err = strconv.ParseInt(oldVal, 10, 64); err != nil {
+				return 0, err
+			}
+		}
+		val += delta
+
+		swapped, err := kvdb.compareAndSwap(key, oldVal, strconv.FormatInt(val, 10), true)
+		if err != nil || swapped {
+			return val, err
+		}
+	}
+}
+
+func (kvdb *mongoKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	_, err := kvdb.c.UpsertId(key, bson.M{
+		_VAL_KEY:       val,
+		_EXPIRE_AT_KEY: time.Now().Add(ttl),
+	})
+	return err
+}
+
+func (kvdb *mongoKVDB) Expire(key string, ttl time.Duration) error {
+	err := kvdb.c.Update(bson.M{"$and": []bson.M{{"_id": key}, notExpired()}}, bson.M{"$set": bson.M{_EXPIRE_AT_KEY: time.Now().Add(ttl)}})
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
 type mongoKVIterator struct {
 	it *mgo.Iter
 }
 
 func (it *mongoKVIterator) Next() (kvdbtypes.KVItem, error) {
-	var doc map[string]string
-	ok := it.it.Next(&doc)
-	if ok {
+	for {
+		var doc kvDoc
+		if !it.it.Next(&doc) {
+			break
+		}
+		if doc.expired() {
+			continue
+		}
 		return kvdbtypes.KVItem{
-			Key: doc["_id"],
-			Val: doc["_"],
+			Key: doc.Key,
+			Val: doc.Val,
 		}, nil
 	}
 
@@ -9,19 +9,27 @@ import (
 
 	"strconv"
 
-	_ "github.com/go-sql-driver/mysql"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
 )
 
 const (
 	_MAX_KEY_LENGTH = 256
+	_PURGE_INTERVAL = time.Minute
+	_MAX_TX_RETRIES = 3
+
+	_ER_DUP_ENTRY     = 1062
+	_ER_LOCK_DEADLOCK = 1213
 )
 
 type mysqlKVDB struct {
 	driverName     string
 	dataSourceName string
 	db             *sql.DB
+	lastPurge      time.Time
 }
 
 // OpenMySQLKVDB opens SQL driver for KVDB backend
@@ -42,6 +50,17 @@ func OpenMySQLKVDB(dataSourceName string) (kvdbtypes.KVDBEngine, error) {
 		return nil, err
 	}
 
+	// expire times are stored in unix milliseconds, and expired items are purged periodically when TTLs are set
+	var hasExpireAt int
+	if err = db.QueryRow("SELECT COUNT(*) FROM `information_schema`.`columns` WHERE `table_schema` = DATABASE() AND `table_name` = '__kv__' AND `column_name` = 'expire_at'").Scan(&hasExpireAt); err != nil {
+		return nil, err
+	}
+	if hasExpireAt == 0 {
+		if _, err = db.Exec("ALTER TABLE `__kv__` ADD COLUMN `expire_at` BIGINT NULL"); err != nil {
+			return nil, err
+		}
+	}
+
 	return &mysqlKVDB{
 		driverName:     "mysql",
 		dataSourceName: dataSourceName,
@@ -53,8 +72,12 @@ func (sqlkvdb *mysqlKVDB) String() string {
 	return fmt.Sprintf("%s<%s>", sqlkvdb.driverName, sqlkvdb.dataSourceName)
 }
 
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
 func (sqlkvdb *mysqlKVDB) Get(key string) (val string, err error) {
-	row := sqlkvdb.db.QueryRow("SELECT `val` FROM `__kv__` WHERE `key` = ?", key)
+	row := sqlkvdb.db.QueryRow("SELECT `val` FROM `__kv__` WHERE `key` = ? AND (`expire_at` IS NULL OR `expire_at` > ?)", key, nowMillis())
 	err = row.Scan(&val)
 	if err == sql.ErrNoRows {
 		err = nil // not found, use default val ""
@@ -64,7 +87,116 @@ func (sqlkvdb *mysqlKVDB) Get(key string) (val string, err error) {
 }
 
 func (sqlkvdb *mysqlKVDB) Put(key string, val string) (err error) {
-	_, err = sqlkvdb.db.Exec("INSERT INTO `__kv__`(`key`, `val`) VALUES(?, ?) ON DUPLICATE KEY UPDATE `val`=?", key, val, val)
+	_, err = sqlkvdb.db.Exec("INSERT INTO `__kv__`(`key`, `val`) VALUES(?, ?) ON DUPLICATE KEY UPDATE `val`=?, `expire_at`=NULL", key, val, val)
+	return
+}
+
+func (sqlkvdb *mysqlKVDB) CompareAndSwap(key string, oldVal string, newVal string) (swapped bool, err error) {
+	err = sqlkvdb.modify(key, func(val string, expireAt sql.NullInt64) (string, sql.NullInt64, bool, error) {
+		return newVal, sql.NullInt64{}, val == oldVal, nil
+	})
+	if err == errNotModified {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (sqlkvdb *mysqlKVDB) Incr(key string, delta int64) (newVal int64, err error) {
+	err = sqlkvdb.modify(key, func(val string, expireAt sql.NullInt64) (string, sql.NullInt64, bool, error) {
+		newVal = 0
+		if val != "" {
+			var err error
+			if newVal, err = strconv.ParseInt(val, 10, 64); err != nil {
+				return "", expireAt, false, err
+			}
+		}
+		newVal += delta
+		return strconv.FormatInt(newVal, 10), expireAt, true, nil
+	})
+	return
+}
+
+var errNotModified = fmt.Errorf("not modified")
+
+// modify reads the value and expire time of key with lock, and writes back the value returned by f in a transaction
+//
+// Expired items are passed to f as not exist. Transactions are retried if new keys are inserted concurrently.
+func (sqlkvdb *mysqlKVDB) modify(key string, f func(val string, expireAt sql.NullInt64) (newVal string, newExpireAt sql.NullInt64, ok bool, err error)) (err error) {
+	for retry := 0; ; retry++ {
+		err = sqlkvdb.modifyOnce(key, f)
+		if mysqlErr, ok := err.(*mysql.MySQLError); ok && retry < _MAX_TX_RETRIES && (mysqlErr.Number == _ER_DUP_ENTRY || mysqlErr.Number == _ER_LOCK_DEADLOCK) {
+			continue
+		}
+		return
+	}
+}
+
+func (sqlkvdb *mysqlKVDB) modifyOnce(key string, f func(val string, expireAt sql.NullInt64) (string, sql.NullInt64, bool, error)) error {
+	tx, err := sqlkvdb.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // no-op if committed
+
+	var val string
+	var expireAt sql.NullInt64
+	exists := true
+	err = tx.QueryRow("SELECT `val`, `expire_at` FROM `__kv__` WHERE `key` = ? FOR UPDATE", key).Scan(&val, &expireAt)
+	if err == sql.ErrNoRows {
+		exists = false
+	} else if err != nil {
+		return err
+	}
+	if expireAt.Valid && expireAt.Int64 <= nowMillis() {
+		val, expireAt = "", sql.NullInt64{}
+	}
+
+	newVal, newExpireAt, ok, err := f(val, expireAt)
+	if err != nil {
+		return err
+	} else if !ok {
+		return errNotModified
+	}
+
+	if exists {
+		_, err = tx.Exec("UPDATE `__kv__` SET `val` = ?, `expire_at` = ? WHERE `key` = ?", newVal, newExpireAt, key)
+	} else {
+		_, err = tx.Exec("INSERT INTO `__kv__`(`key`, `val`, `expire_at`) VALUES(?, ?, ?)", key, newVal, newExpireAt)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (sqlkvdb *mysqlKVDB) PutWithTTL(key string, val string, ttl time.Duration) (err error) {
+	sqlkvdb.purgeExpired()
+	expireAt := nowMillis() + int64(ttl/time.Millisecond)
+	_, err = sqlkvdb.db.Exec("INSERT INTO `__kv__`(`key`, `val`, `expire_at`) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE `val`=?, `expire_at`=?", key, val, expireAt, val, expireAt)
+	return
+}
+
+func (sqlkvdb *mysqlKVDB) Expire(key string, ttl time.Duration) (err error) {
+	sqlkvdb.purgeExpired()
+	now := nowMillis()
+	_, err = sqlkvdb.db.Exec("UPDATE `__kv__` SET `expire_at` = ? WHERE `key` = ? AND (`expire_at` IS NULL OR `expire_at` > ?)", now+int64(ttl/time.Millisecond), key, now)
+	return
+}
+
+// purgeExpired deletes expired items if they are not purged for a while
+func (sqlkvdb *mysqlKVDB) purgeExpired() {
+	if time.Since(sqlkvdb.lastPurge) < _PURGE_INTERVAL {
+		return
+	}
+
+	sqlkvdb.lastPurge = time.Now()
+	if _, err := sqlkvdb.db.Exec("DELETE FROM `__kv__` WHERE `expire_at` <= ?", nowMillis()); err != nil {
+		gwlog.Errorf("%s: purge expired items failed: %s", sqlkvdb.String(), err)
+	}
+}
+
+func (sqlkvdb *mysqlKVDB) Del(key string) (err error) {
+	_, err = sqlkvdb.db.Exec("DELETE FROM `__kv__` WHERE `key` = ?", key)
 	return
 }
 
@@ -83,7 +215,7 @@ func (it *sqlKVDBIterator) Next() (kvdbtypes.KVItem, error) {
 }
 
 func (sqlkvdb *mysqlKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
-	rows, err := sqlkvdb.db.Query("SELECT `key`, `val` FROM `__kv__` WHERE `key` >= ? AND `key` < ?", beginKey, endKey)
+	rows, err := sqlkvdb.db.Query("SELECT `key`, `val` FROM `__kv__` WHERE `key` >= ? AND `key` < ? AND (`expire_at` IS NULL OR `expire_at` > ?)", beginKey, endKey, nowMillis())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,224 @@
+package kvdbpostgres
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+const (
+	_PURGE_INTERVAL = time.Minute
+)
+
+type postgresKVDB struct {
+	driverName    string
+	db            *sql.DB
+	getStmt       *sql.Stmt
+	putStmt       *sql.Stmt
+	delStmt       *sql.Stmt
+	findStmt      *sql.Stmt
+	casStmt       *sql.Stmt
+	casInsertStmt *sql.Stmt
+	incrStmt      *sql.Stmt
+	putTTLStmt    *sql.Stmt
+	expireStmt    *sql.Stmt
+	lastPurge     time.Time
+}
+
+// OpenPostgresKVDB opens PostgreSQL for KVDB backend
+//
+// The database/sql driver must be registered by importing it in the game, e.g. github.com/lib/pq for driver postgres.
+// Keys use the C collation, so that key ranges are ordered by bytes as other KVDB backends. Expire times are stored in
+// unix milliseconds, and expired items are purged periodically when TTLs are set.
+func OpenPostgresKVDB(driverName string, url string, maxOpenConns int, maxIdleConns int, connMaxLifetime time.Duration) (kvdbtypes.KVDBEngine, error) {
+	db, err := sql.Open(driverName, url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open postgres failed, is the %s driver imported?", driverName)
+	}
+
+	// pool settings which are not positive are left as database/sql defaults
+	if maxOpenConns > 0 {
+		db.SetMaxOpenConns(maxOpenConns)
+	}
+	if maxIdleConns > 0 {
+		db.SetMaxIdleConns(maxIdleConns)
+	}
+	if connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(connMaxLifetime)
+	}
+	kvdb := &postgresKVDB{
+		driverName: driverName,
+		db:         db,
+	}
+	if err = kvdb.init(); err != nil {
+		kvdb.Close()
+		return nil, err
+	}
+	return kvdb, nil
+}
+
+func (kvdb *postgresKVDB) init() (err error) {
+	if err = kvdb.db.Ping(); err != nil {
+		return
+	}
+
+	if _, err = kvdb.db.Exec(`CREATE TABLE IF NOT EXISTS "__kv__"("key" TEXT COLLATE "C" NOT NULL PRIMARY KEY, "val" TEXT NOT NULL)`); err != nil {
+		return
+	}
+	if _, err = kvdb.db.Exec(`ALTER TABLE "__kv__" ADD COLUMN IF NOT EXISTS "expire_at" BIGINT`); err != nil {
+		return
+	}
+
+	// expired items are the same as not exist, so "expire_at" is checked against the current time in $N
+	const notExpired = `("__kv__"."expire_at" IS NULL OR "__kv__"."expire_at" > %s)`
+	const expired = `"__kv__"."expire_at" <= %s`
+	for _, prepare := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&kvdb.getStmt, `SELECT "val" FROM "__kv__" WHERE "key" = $1 AND ` + fmt.Sprintf(notExpired, "$2")},
+		{&kvdb.putStmt, `INSERT INTO "__kv__"("key", "val") VALUES($1, $2) ON CONFLICT ("key") DO UPDATE SET "val" = EXCLUDED."val", "expire_at" = NULL`},
+		{&kvdb.delStmt, `DELETE FROM "__kv__" WHERE "key" = $1`},
+		{&kvdb.findStmt, `SELECT "key", "val" FROM "__kv__" WHERE "key" >= $1 AND "key" < $2 AND ` + fmt.Sprintf(notExpired, "$3") + ` ORDER BY "key"`},
+		{&kvdb.casStmt, `UPDATE "__kv__" SET "val" = $3, "expire_at" = NULL WHERE "key" = $1 AND "val" = $2 AND ` + fmt.Sprintf(notExpired, "$4")},
+		{&kvdb.casInsertStmt, `INSERT INTO "__kv__"("key", "val") VALUES($1, $2) ON CONFLICT ("key") DO UPDATE SET "val" = EXCLUDED."val", "expire_at" = NULL
+			WHERE "__kv__"."val" = '' OR ` + fmt.Sprintf(expired, "$3")},
+		{&kvdb.incrStmt, `INSERT INTO "__kv__"("key", "val") VALUES($1, $2::BIGINT::TEXT) ON CONFLICT ("key") DO UPDATE SET
+			"val" = CASE WHEN "__kv__"."val" = '' OR ` + fmt.Sprintf(expired, "$3") + ` THEN EXCLUDED."val" ELSE ("__kv__"."val"::BIGINT + $2::BIGINT)::TEXT END,
+			"expire_at" = CASE WHEN ` + fmt.Sprintf(expired, "$3") + ` THEN NULL ELSE "__kv__"."expire_at" END
+			RETURNING "val"`},
+		{&kvdb.putTTLStmt, `INSERT INTO "__kv__"("key", "val", "expire_at") VALUES($1, $2, $3) ON CONFLICT ("key") DO UPDATE SET "val" = EXCLUDED."val", "expire_at" = EXCLUDED."expire_at"`},
+		{&kvdb.expireStmt, `UPDATE "__kv__" SET "expire_at" = $2 WHERE "key" = $1 AND ` + fmt.Sprintf(notExpired, "$3")},
+	} {
+		if *prepare.stmt, err = kvdb.db.Prepare(prepare.query); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func (kvdb *postgresKVDB) String() string {
+	return fmt.Sprintf("postgres<%s>", kvdb.driverName)
+}
+
+func (kvdb *postgresKVDB) Get(key string) (val string, err error) {
+	err = kvdb.getStmt.QueryRow(key, nowMillis()).Scan(&val)
+	if err == sql.ErrNoRows {
+		err = nil // not found, use default val ""
+	}
+	return
+}
+
+func (kvdb *postgresKVDB) Put(key string, val string) (err error) {
+	_, err = kvdb.putStmt.Exec(key, val)
+	return
+}
+
+func (kvdb *postgresKVDB) Del(key string) (err error) {
+	_, err = kvdb.delStmt.Exec(key)
+	return
+}
+
+func (kvdb *postgresKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	var res sql.Result
+	var err error
+	if oldVal == "" {
+		res, err = kvdb.casInsertStmt.Exec(key, newVal, nowMillis())
+	} else {
+		res, err = kvdb.casStmt.Exec(key, oldVal, newVal, nowMillis())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (kvdb *postgresKVDB) Incr(key string, delta int64) (int64, error) {
+	var val string
+	if err := kvdb.incrStmt.QueryRow(key, delta, nowMillis()).Scan(&val); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func (kvdb *postgresKVDB) PutWithTTL(key string, val string, ttl time.Duration) (err error) {
+	kvdb.purgeExpired()
+	_, err = kvdb.putTTLStmt.Exec(key, val, nowMillis()+int64(ttl/time.Millisecond))
+	return
+}
+
+func (kvdb *postgresKVDB) Expire(key string, ttl time.Duration) (err error) {
+	kvdb.purgeExpired()
+	now := nowMillis()
+	_, err = kvdb.expireStmt.Exec(key, now+int64(ttl/time.Millisecond), now)
+	return
+}
+
+// purgeExpired deletes expired items if they are not purged for a while
+func (kvdb *postgresKVDB) purgeExpired() {
+	if time.Since(kvdb.lastPurge) < _PURGE_INTERVAL {
+		return
+	}
+
+	kvdb.lastPurge = time.Now()
+	if _, err := kvdb.db.Exec(`DELETE FROM "__kv__" WHERE "expire_at" <= $1`, nowMillis()); err != nil {
+		gwlog.Errorf("%s: purge expired items failed: %s", kvdb.String(), err)
+	}
+}
+
+type postgresKVDBIterator struct {
+	rows *sql.Rows
+}
+
+func (it *postgresKVDBIterator) Next() (kvdbtypes.KVItem, error) {
+	if it.rows.Next() {
+		var item kvdbtypes.KVItem
+		err := it.rows.Scan(&item.Key, &item.Val)
+		return item, err
+	}
+
+	it.rows.Close()
+	if err := it.rows.Err(); err != nil {
+		return kvdbtypes.KVItem{}, err
+	}
+	return kvdbtypes.KVItem{}, io.EOF
+}
+
+func (kvdb *postgresKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
+	rows, err := kvdb.findStmt.Query(beginKey, endKey, nowMillis())
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresKVDBIterator{
+		rows: rows,
+	}, nil
+}
+
+func (kvdb *postgresKVDB) Close() {
+	for _, stmt := range []*sql.Stmt{kvdb.getStmt, kvdb.putStmt, kvdb.delStmt, kvdb.findStmt, kvdb.casStmt, kvdb.casInsertStmt,
+		kvdb.incrStmt, kvdb.putTTLStmt, kvdb.expireStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	if err := kvdb.db.Close(); err != nil {
+		gwlog.Errorf("%s: close error: %s", kvdb.String(), err)
+	}
+}
+
+func (kvdb *postgresKVDB) IsConnectionError(err error) bool {
+	return true
+}
@@ -2,6 +2,7 @@ package kvdbredis
 
 import (
 	"io"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/pkg/errors"
@@ -12,6 +13,21 @@ const (
 	keyPrefix = "_KV_"
 )
 
+var (
+	// compareAndSwapScript swaps the value if it is the old value, missing keys are the same as ""
+	compareAndSwapScript = redis.NewScript(1, `
+local val = redis.call('GET', KEYS[1])
+if val == false then
+	val = ''
+end
+if val ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+return 1
+`)
+)
+
 type redisKVDB struct {
 	c redis.Conn
 }
@@ -88,6 +104,30 @@ func (db *redisKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (db *redisKVDB) Del(key string) error {
+	_, err := db.c.Do("DEL", keyPrefix+key)
+	return err
+}
+
+func (db *redisKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	swapped, err := redis.Int(compareAndSwapScript.Do(db.c, keyPrefix+key, oldVal, newVal))
+	return swapped == 1, err
+}
+
+func (db *redisKVDB) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(db.c.Do("INCRBY", keyPrefix+key, delta))
+}
+
+func (db *redisKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	_, err := db.c.Do("SET", keyPrefix+key, val, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (db *redisKVDB) Expire(key string, ttl time.Duration) error {
+	_, err := db.c.Do("PEXPIRE", keyPrefix+key, int64(ttl/time.Millisecond))
+	return err
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
@@ -109,7 +149,7 @@ func (it *redisKVDBIterator) Next() (kvdbtypes.KVItem, error) {
 }
 
 func (db *redisKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
-	return nil, errors.Errorf("operation not supported on redis")
+	return nil, errors.Wrap(kvdbtypes.ErrNotSupported, "redis")
 }
 
 func (db *redisKVDB) Close() {
@@ -93,6 +93,43 @@ func (db *redisKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (db *redisKVDB) Del(key string) error {
+	_, err := db.c.Do("DEL", keyPrefix+key)
+	return err
+}
+
+// CompareAndSwap is atomic only if oldVal is "", because scripts can not be routed by the cluster client: other swaps
+// are emulated by GET and SET, which can race with writes from other processes
+func (db *redisKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	if oldVal == "" {
+		r, err := db.c.Do("SET", keyPrefix+key, newVal, "NX")
+		if err != nil || r != nil {
+			return r != nil, err
+		}
+		// the key exists, but it might be ""
+	}
+
+	val, err := db.Get(key)
+	if err != nil || val != oldVal {
+		return false, err
+	}
+	return true, db.Put(key, newVal)
+}
+
+func (db *redisKVDB) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(db.c.Do("INCRBY", keyPrefix+key, delta))
+}
+
+func (db *redisKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	_, err := db.c.Do("SET", keyPrefix+key, val, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (db *redisKVDB) Expire(key string, ttl time.Duration) error {
+	_, err := db.c.Do("PEXPIRE", keyPrefix+key, int64(ttl/time.Millisecond))
+	return err
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
@@ -114,7 +151,7 @@ func (it *redisKVDBIterator) Next() (kvdbtypes.KVItem, error) {
 }
 
 func (db *redisKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
-	return nil, errors.Errorf("operation not supported on redis")
+	return nil, errors.Wrap(kvdbtypes.ErrNotSupported, "redis")
 }
 
 func (db *redisKVDB) Close() {
@@ -5,24 +5,21 @@ import (
 
 	"io"
 
-	"strconv"
-
 	"github.com/xiaonanln/goworld/engine/async"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
-	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
-	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmysql"
-	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
-	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbrediscluster"
 	"github.com/xiaonanln/goworld/engine/kvdb/types"
 )
 
 const (
-	_KVDB_ASYNC_JOB_GROUP = "_kvdb"
+	_KVDB_ASYNC_JOB_GROUP        = "_kvdb"
+	_KVDB_POOLED_ASYNC_JOB_GROUP = "_kvdb_pooled"
 )
 
 var (
-	kvdbEngine kvdbtypes.KVDBEngine
+	conf          *config.Config // config of the game, set by Initialize
+	kvdbEngine    kvdbtypes.KVDBEngine
+	pooledEngines []kvdbtypes.KVDBEngine // engines of pooled workers, each worker uses its own connection
 )
 
 // KVDBGetCallback is type of KVDB Get callback
@@ -31,17 +28,31 @@ type KVDBGetCallback func(val string, err error)
 // KVDBPutCallback is type of KVDB Get callback
 type KVDBPutCallback func(err error)
 
+// KVDBDelCallback is type of KVDB Del callback
+type KVDBDelCallback func(err error)
+
 // KVDBGetRangeCallback is type of KVDB GetRange callback
 type KVDBGetRangeCallback func(items []kvdbtypes.KVItem, err error)
 
 // KVDBGetOrPutCallback is type of KVDB GetOrPut callback
 type KVDBGetOrPutCallback func(oldVal string, err error)
 
-// Initialize the KVDB
+// KVDBCompareAndSwapCallback is type of KVDB CompareAndSwap callback
+type KVDBCompareAndSwapCallback func(swapped bool, err error)
+
+// KVDBIncrCallback is type of KVDB Incr callback
+type KVDBIncrCallback func(val int64, err error)
+
+// KVDBExpireCallback is type of KVDB PutWithTTL and Expire callback
+type KVDBExpireCallback func(err error)
+
+// Initialize the KVDB with the config of the game
 //
 // Called by game server engine
-func Initialize() {
-	kvdbCfg := config.GetKVDB()
+func Initialize(c *config.Config) {
+	conf = c
+	pooledEngines = make([]kvdbtypes.KVDBEngine, async.GetWorkerPoolSize())
+	kvdbCfg := conf.GetKVDB()
 	if kvdbCfg.Type == "" {
 		return
 	}
@@ -50,35 +61,35 @@ func Initialize() {
 	assureKVDBEngineReady()
 }
 
+// IsConfigured returns if KVDB is configured
+func IsConfigured() bool {
+	return conf != nil && conf.GetKVDB().Type != ""
+}
+
 func assureKVDBEngineReady() (err error) {
 	if kvdbEngine != nil { // connection is valid
 		return
 	}
 
-	kvdbCfg := config.GetKVDB()
+	kvdbEngine, err = openKVDBEngine()
+	return
+}
 
-	if kvdbCfg.Type == "mongodb" {
-		kvdbEngine, err = kvdbmongo.OpenMongoKVDB(kvdbCfg.Url, kvdbCfg.DB, kvdbCfg.Collection)
-	} else if kvdbCfg.Type == "redis" {
-		var dbindex int = -1
-		if kvdbCfg.DB != "" {
-			dbindex, err = strconv.Atoi(kvdbCfg.DB)
-			if err != nil {
-				return err
-			}
-		}
-		kvdbEngine, err = kvdbredis.OpenRedisKVDB(kvdbCfg.Url, dbindex)
-	} else if kvdbCfg.Type == "redis_cluster" {
-		kvdbEngine, err = kvdbrediscluster.OpenRedisKVDB(kvdbCfg.StartNodes.ToList())
-	} else if kvdbCfg.Type == "sql" {
-		if kvdbCfg.Driver == "mysql" {
-			kvdbEngine, err = kvdbmysql.OpenMySQLKVDB(kvdbCfg.Url)
-		} else {
-			gwlog.Fatalf("KVDB mysql driver %s is unknown", kvdbCfg.Driver)
-		}
-	} else {
+func openKVDBEngine() (engine kvdbtypes.KVDBEngine, err error) {
+	return openKVDBEngineOfConfig(conf.GetKVDB())
+}
+
+// openKVDBEngineOfConfig opens the KVDB engine of the config, keys are in the namespace of the config if set
+func openKVDBEngineOfConfig(kvdbCfg *config.KVDBConfig) (engine kvdbtypes.KVDBEngine, err error) {
+	factory := getFactory(kvdbCfg.Type)
+	if factory == nil {
 		gwlog.Fatalf("KVDB type %s is not implemented", kvdbCfg.Type)
 	}
+
+	engine, err = factory(kvdbCfg)
+	if err == nil && kvdbCfg.Namespace != "" {
+		engine = kvdbtypes.NewNamespaceEngine(engine, kvdbCfg.Namespace)
+	}
 	return
 }
 
@@ -139,7 +150,24 @@ func Put(key string, val string, callback KVDBPutCallback) {
 	}), ac)
 }
 
+// Del deletes key from KVDB, returns in callback
+func Del(key string, callback KVDBDelCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.Del(key)
+		return
+	}), ac)
+}
+
 // GetOrPut gets value of key from KVDB, if val not exists or is "", put key-value to KVDB.
+//
+// GetOrPut is atomic, so only one of concurrent callers puts the value and others get the value put.
 func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
@@ -154,13 +182,90 @@ func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
 
 	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
 		oldVal, err := kvdbEngine.Get(key)
-		if err == nil {
-			if oldVal == "" {
-				err = kvdbEngine.Put(key, val)
+		if err != nil || oldVal != "" {
+			return oldVal, err
+		}
+
+		swapped, err := kvdbEngine.CompareAndSwap(key, "", val)
+		if err != nil || swapped {
+			return "", err
+		}
+		// put by others after Get
+		return kvdbEngine.Get(key)
+	}), ac)
+}
+
+// CompareAndSwap sets key to newVal if the value of key is oldVal ("" for not exists), returns in callback
+//
+// TTL of the key is cleared if swapped.
+func CompareAndSwap(key string, oldVal string, newVal string, callback KVDBCompareAndSwapCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			if err == nil {
+				callback(res.(bool), nil)
+			} else {
+				callback(false, err)
 			}
 		}
+	}
 
-		return oldVal, err
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		res, err = kvdbEngine.CompareAndSwap(key, oldVal, newVal)
+		return
+	}), ac)
+}
+
+// Incr adds delta (decrements if negative) to the integer value of key, returns the new value in callback
+//
+// The value of key is considered as 0 if not exists. TTL of the key is kept.
+func Incr(key string, delta int64, callback KVDBIncrCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			if err == nil {
+				callback(res.(int64), nil)
+			} else {
+				callback(0, err)
+			}
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		res, err = kvdbEngine.Incr(key, delta)
+		return
+	}), ac)
+}
+
+// PutWithTTL puts key-value item which expires after ttl to KVDB, returns in callback
+func PutWithTTL(key string, val string, ttl time.Duration, callback KVDBExpireCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.PutWithTTL(key, val, ttl)
+		return
+	}), ac)
+}
+
+// Expire sets key to expire after ttl, returns in callback
+//
+// Nothing is done if the key does not exist.
+func Expire(key string, ttl time.Duration, callback KVDBExpireCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.Expire(key, ttl)
+		return
 	}), ac)
 }
 
@@ -178,28 +283,101 @@ func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
 	}
 
 	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
-		it, err := kvdbEngine.Find(beginKey, endKey)
+		return findItems(kvdbEngine, beginKey, endKey)
+	}), ac)
+}
+
+func findItems(engine kvdbtypes.KVDBEngine, beginKey string, endKey string) ([]kvdbtypes.KVItem, error) {
+	it, err := engine.Find(beginKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []kvdbtypes.KVItem
+	for {
+		item, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+
 		if err != nil {
 			return nil, err
 		}
 
-		var items []kvdbtypes.KVItem
-		for {
-			item, err := it.Next()
-			if err == io.EOF {
-				break
-			}
+		items = append(items, item)
+	}
+	return items, nil
+}
 
+// GetAsync gets value of key from KVDB in the worker pool, returns in callback on the game goroutine
+//
+// Operations of the same key are executed in order by the same worker, while operations of different keys are executed
+// concurrently, so slow operations do not delay others. Async operations are not ordered with Get, Put, etc.
+func GetAsync(key string, callback KVDBGetCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
 			if err != nil {
-				return nil, err
+				callback("", err)
+			} else {
+				callback(res.(string), nil)
 			}
+		}
+	}
+	async.AppendPooledAsyncJob(_KVDB_POOLED_ASYNC_JOB_GROUP, key, pooledKVDBRoutine(func(engine kvdbtypes.KVDBEngine) (res interface{}, err error) {
+		return engine.Get(key)
+	}), ac)
+}
 
-			items = append(items, item)
+// PutAsync puts key-value item to KVDB in the worker pool, returns in callback on the game goroutine
+func PutAsync(key string, val string, callback KVDBPutCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
 		}
-		return items, nil
+	}
+	async.AppendPooledAsyncJob(_KVDB_POOLED_ASYNC_JOB_GROUP, key, pooledKVDBRoutine(func(engine kvdbtypes.KVDBEngine) (res interface{}, err error) {
+		return nil, engine.Put(key, val)
 	}), ac)
 }
 
+// DelAsync deletes key from KVDB in the worker pool, returns in callback on the game goroutine
+func DelAsync(key string, callback KVDBDelCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+	async.AppendPooledAsyncJob(_KVDB_POOLED_ASYNC_JOB_GROUP, key, pooledKVDBRoutine(func(engine kvdbtypes.KVDBEngine) (res interface{}, err error) {
+		return nil, engine.Del(key)
+	}), ac)
+}
+
+// pooledKVDBRoutine runs r with the engine of the worker, the engine is reopened if the connection is broken
+func pooledKVDBRoutine(r func(engine kvdbtypes.KVDBEngine) (res interface{}, err error)) async.PooledAsyncRoutine {
+	return func(worker int) (res interface{}, err error) {
+		for pooledEngines[worker] == nil {
+			engine, err := openKVDBEngine()
+			if err == nil {
+				pooledEngines[worker] = engine
+			} else {
+				gwlog.Errorf("KVDB engine of worker %d is not ready: %s", worker, err)
+				time.Sleep(time.Second)
+			}
+		}
+
+		engine := pooledEngines[worker]
+		res, err = r(engine)
+		if err != nil && engine.IsConnectionError(err) {
+			engine.Close()
+			pooledEngines[worker] = nil
+		}
+		return
+	}
+}
+
 // NextLargerKey finds the next key that is larger than the specified key,
 // but smaller than any other keys that is larger than the specified key
 func NextLargerKey(key string) string {
@@ -10,9 +10,12 @@ import (
 
 	"os"
 
+	"time"
+
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmysql"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
 )
 
@@ -54,6 +57,75 @@ func testKVDBBackendSet(t *testing.T, kvdb KVDBEngine) {
 
 }
 
+func TestMongoBackendAtomic(t *testing.T) {
+	testKVDBBackendAtomic(t, openTestMongoKVDB(t))
+}
+
+func TestRedisBackendAtomic(t *testing.T) {
+	testKVDBBackendAtomic(t, openTestRedisKVDB(t))
+}
+
+func TestMySQLBackendAtomic(t *testing.T) {
+	testKVDBBackendAtomic(t, openTestMySQLKVDB(t))
+}
+
+func testKVDBBackendAtomic(t *testing.T, kvdb KVDBEngine) {
+	key := "__atomic__" + strconv.Itoa(rand.Intn(1000000))
+	defer kvdb.Del(key)
+
+	if swapped, err := kvdb.CompareAndSwap(key, "", "1"); err != nil || !swapped {
+		t.Fatalf("swap not exists key failed: %v", err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "", "2"); err != nil || swapped {
+		t.Fatalf("swap with wrong old value should fail: %v", err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "1", "2"); err != nil || !swapped {
+		t.Fatalf("swap failed: %v", err)
+	}
+
+	if val, err := kvdb.Incr(key, 10); err != nil || val != 12 {
+		t.Fatalf("incr returns %d, %v, expected 12", val, err)
+	}
+	if val, err := kvdb.Incr(key, -3); err != nil || val != 9 {
+		t.Fatalf("decr returns %d, %v, expected 9", val, err)
+	}
+
+	if err := kvdb.PutWithTTL(key, "ttl", time.Millisecond*100); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "ttl" {
+		t.Fatalf("get returns %s, %v, expected ttl", val, err)
+	}
+	if err := kvdb.Expire(key, time.Millisecond*100); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("key should be expired, but get returns %s, %v", val, err)
+	}
+	if val, err := kvdb.Incr(key, 1); err != nil || val != 1 {
+		t.Fatalf("incr expired key returns %d, %v, expected 1", val, err)
+	}
+}
+
+func TestMongoBackendConformance(t *testing.T) {
+	kvdbtest.RunConformance(t, func() (KVDBEngine, error) {
+		return kvdbmongo.OpenMongoKVDB("mongodb://127.0.0.1:27017/goworld", "goworld", "__kv__")
+	})
+}
+
+func TestRedisBackendConformance(t *testing.T) {
+	kvdbtest.RunConformance(t, func() (KVDBEngine, error) {
+		return kvdbredis.OpenRedisKVDB("redis://127.0.0.1:6379", 0)
+	})
+}
+
+func TestMySQLBackendConformance(t *testing.T) {
+	kvdbtest.RunConformance(t, func() (KVDBEngine, error) {
+		return openTestMySQLKVDB(t), nil
+	})
+}
+
 func TestMongoBackendFind(t *testing.T) {
 	testBackendFind(t, openTestMongoKVDB(t))
 }
@@ -8,8 +8,7 @@ import (
 )
 
 func init() {
-	config.SetConfigFile("../../goworld.ini")
-	Initialize()
+	Initialize(config.New("../../goworld.ini"))
 }
 
 func TestBasic(t *testing.T) {
@@ -0,0 +1,253 @@
+// Package kvdbtest provides the conformance test suite of KVDB engines
+//
+// Engines in this repository and in other modules are validated identically by calling RunConformance in tests:
+//
+//	func TestConformance(t *testing.T) {
+//		kvdbtest.RunConformance(t, func() (kvdbtypes.KVDBEngine, error) {
+//			return OpenMyKVDB(...)
+//		})
+//	}
+package kvdbtest
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+const (
+	_CONCURRENT_WORKERS = 4
+	_CONCURRENT_INCRS   = 50
+	_LARGE_VALUE_SIZE   = 1024 * 1024
+)
+
+// Factory opens a new connection of the KVDB engine
+//
+// Factory is called multiple times by concurrency tests, and engines opened are closed by the test suite.
+type Factory func() (kvdbtypes.KVDBEngine, error)
+
+// RunConformance runs the conformance test suite on the KVDB engine
+//
+// Keys are prefixed by a random string and deleted after tests, so the suite can run on KVDBs in use. Find is skipped if
+// the engine returns kvdbtypes.ErrNotSupported.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("PutGet", func(t *testing.T) { runWithEngine(t, factory, testPutGet) })
+	t.Run("Find", func(t *testing.T) { runWithEngine(t, factory, testFind) })
+	t.Run("Atomic", func(t *testing.T) { runWithEngine(t, factory, testAtomic) })
+	t.Run("TTL", func(t *testing.T) { runWithEngine(t, factory, testTTL) })
+	t.Run("LargeValue", func(t *testing.T) { runWithEngine(t, factory, testLargeValue) })
+	t.Run("Unicode", func(t *testing.T) { runWithEngine(t, factory, testUnicode) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+func runWithEngine(t *testing.T, factory Factory, test func(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string)) {
+	kvdb := open(t, factory)
+	defer kvdb.Close()
+	test(t, kvdb, randomPrefix())
+}
+
+func open(t *testing.T, factory Factory) kvdbtypes.KVDBEngine {
+	kvdb, err := factory()
+	if err != nil {
+		t.Fatalf("open KVDB failed: %s", err)
+	}
+	return kvdb
+}
+
+// randomPrefix returns a key prefix which is not used by other tests
+func randomPrefix() string {
+	return fmt.Sprintf("__conformance_%08d_", rand.Intn(100000000))
+}
+
+func testPutGet(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	key := prefix + "key"
+	defer kvdb.Del(key)
+
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("Get returns %q, %v for key not put", val, err)
+	}
+	putAndVerify(t, kvdb, key, "val1")
+	putAndVerify(t, kvdb, key, "val2")
+
+	if err := kvdb.Del(key); err != nil {
+		t.Fatalf("Del failed: %s", err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("Get returns %q, %v for key deleted", val, err)
+	}
+	if err := kvdb.Del(key); err != nil {
+		t.Fatalf("Del key which does not exist failed: %s", err)
+	}
+}
+
+func testFind(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%s%02d", prefix, rand.Intn(100))
+		defer kvdb.Del(key)
+		if err := kvdb.Put(key, key); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		keys = append(keys, key)
+	}
+
+	beginKey, endKey := prefix+"20", prefix+"80"
+	expected := map[string]bool{}
+	for _, key := range keys {
+		if key >= beginKey && key < endKey {
+			expected[key] = true
+		}
+	}
+
+	it, err := kvdb.Find(beginKey, endKey)
+	if errors.Cause(err) == kvdbtypes.ErrNotSupported {
+		t.Skipf("Find is not supported: %s", err)
+	} else if err != nil {
+		t.Fatalf("Find failed: %s", err)
+	}
+
+	var found []string
+	for {
+		item, err := it.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("iterate failed: %s", err)
+		}
+
+		if item.Val != item.Key {
+			t.Errorf("Find returns %q for key %q", item.Val, item.Key)
+		}
+		if strings.HasPrefix(item.Key, prefix) {
+			found = append(found, item.Key)
+		}
+	}
+
+	if !sort.StringsAreSorted(found) {
+		t.Errorf("keys found should be increasing: %v", found)
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("Find(%q, %q) returns %v, expected %d keys", beginKey, endKey, found, len(expected))
+	}
+	for _, key := range found {
+		if !expected[key] {
+			t.Fatalf("Find(%q, %q) returns unexpected key %q", beginKey, endKey, key)
+		}
+	}
+}
+
+func testAtomic(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	key := prefix + "atomic"
+	defer kvdb.Del(key)
+
+	if swapped, err := kvdb.CompareAndSwap(key, "", "1"); err != nil || !swapped {
+		t.Fatalf("swap key which does not exist failed: %v", err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "", "2"); err != nil || swapped {
+		t.Fatalf("swap with wrong old value should fail: %v", err)
+	}
+	if swapped, err := kvdb.CompareAndSwap(key, "1", "2"); err != nil || !swapped {
+		t.Fatalf("swap failed: %v", err)
+	}
+	if val, err := kvdb.Incr(key, 10); err != nil || val != 12 {
+		t.Fatalf("Incr returns %d, %v, expected 12", val, err)
+	}
+	if val, err := kvdb.Incr(key, -3); err != nil || val != 9 {
+		t.Fatalf("Incr returns %d, %v, expected 9", val, err)
+	}
+	if val, err := kvdb.Incr(prefix+"incr", 1); err != nil || val != 1 {
+		t.Fatalf("Incr key which does not exist returns %d, %v, expected 1", val, err)
+	}
+	kvdb.Del(prefix + "incr")
+}
+
+func testTTL(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	key := prefix + "ttl"
+	defer kvdb.Del(key)
+
+	if err := kvdb.PutWithTTL(key, "ttl", time.Millisecond*200); err != nil {
+		t.Fatalf("PutWithTTL failed: %s", err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "ttl" {
+		t.Fatalf("Get returns %q, %v, expected ttl", val, err)
+	}
+	time.Sleep(time.Millisecond * 400)
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("key should be expired, but Get returns %q, %v", val, err)
+	}
+
+	putAndVerify(t, kvdb, key, "expire")
+	if err := kvdb.Expire(key, time.Millisecond*200); err != nil {
+		t.Fatalf("Expire failed: %s", err)
+	}
+	time.Sleep(time.Millisecond * 400)
+	if val, err := kvdb.Get(key); err != nil || val != "" {
+		t.Fatalf("key should be expired, but Get returns %q, %v", val, err)
+	}
+	if err := kvdb.Expire(prefix+"not_exists", time.Second); err != nil {
+		t.Fatalf("Expire key which does not exist failed: %s", err)
+	}
+}
+
+func testLargeValue(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	key := prefix + "large"
+	defer kvdb.Del(key)
+	putAndVerify(t, kvdb, key, strings.Repeat("0123456789abcdef", _LARGE_VALUE_SIZE/16))
+}
+
+func testUnicode(t *testing.T, kvdb kvdbtypes.KVDBEngine, prefix string) {
+	for _, key := range []string{prefix + "名字", prefix + "имя", prefix + "🎮"} {
+		putAndVerify(t, kvdb, key, "玩家一 игрок 🐉")
+		kvdb.Del(key)
+	}
+}
+
+// testConcurrency increments the same key concurrently through multiple connections
+func testConcurrency(t *testing.T, factory Factory) {
+	key := randomPrefix() + "counter"
+	var wait sync.WaitGroup
+	errs := make(chan error, _CONCURRENT_WORKERS*_CONCURRENT_INCRS)
+	for w := 0; w < _CONCURRENT_WORKERS; w++ {
+		kvdb := open(t, factory)
+		defer kvdb.Close()
+
+		wait.Add(1)
+		go func(kvdb kvdbtypes.KVDBEngine) {
+			defer wait.Done()
+			for i := 0; i < _CONCURRENT_INCRS; i++ {
+				if _, err := kvdb.Incr(key, 1); err != nil {
+					errs <- err
+				}
+			}
+		}(kvdb)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	kvdb := open(t, factory)
+	defer kvdb.Close()
+	defer kvdb.Del(key)
+	if val, err := kvdb.Get(key); err != nil || val != fmt.Sprint(_CONCURRENT_WORKERS*_CONCURRENT_INCRS) {
+		t.Fatalf("counter incremented concurrently is %q, %v, expected %d", val, err, _CONCURRENT_WORKERS*_CONCURRENT_INCRS)
+	}
+}
+
+func putAndVerify(t *testing.T, kvdb kvdbtypes.KVDBEngine, key string, val string) {
+	if err := kvdb.Put(key, val); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if verifyVal, err := kvdb.Get(key); err != nil || verifyVal != val {
+		t.Fatalf("Get %q returns %d bytes, %v, expected %d bytes", key, len(verifyVal), err, len(val))
+	}
+}
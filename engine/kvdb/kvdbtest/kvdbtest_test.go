@@ -0,0 +1,44 @@
+package kvdbtest
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+func TestRunConformance(t *testing.T) {
+	db := newMemoryKVDB()
+	RunConformance(t, func() (kvdbtypes.KVDBEngine, error) {
+		return db, nil
+	})
+	if len(db.items) != 0 {
+		t.Errorf("keys are not deleted after tests: %d keys left", len(db.items))
+	}
+}
+
+func TestNamespaceConformance(t *testing.T) {
+	db := newMemoryKVDB()
+	RunConformance(t, func() (kvdbtypes.KVDBEngine, error) {
+		return kvdbtypes.NewNamespaceEngine(db, "s2"), nil
+	})
+
+	db.Put("s1/a", "1")
+	db.Put("s2/a", "2")
+	db.Put("s2/b", "3")
+	db.Put("s20/c", "4")
+	s2 := kvdbtypes.NewNamespaceEngine(db, "s2")
+	if val, _ := s2.Get("a"); val != "2" {
+		t.Errorf("wrong value in namespace: %s", val)
+	}
+	it, err := s2.Find("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for item, err := it.Next(); err == nil; item, err = it.Next() {
+		keys = append(keys, item.Key)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("wrong keys found in namespace: %v", keys)
+	}
+}
@@ -0,0 +1,131 @@
+package kvdbtest
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// NewMemoryEngine returns an in-memory KVDB engine, for validating the test suite and testing modules using KVDB
+func NewMemoryEngine() kvdbtypes.KVDBEngine {
+	return newMemoryKVDB()
+}
+
+func newMemoryKVDB() *memoryKVDB {
+	return &memoryKVDB{items: map[string]memoryItem{}}
+}
+
+type memoryItem struct {
+	val      string
+	expireAt time.Time
+}
+
+// memoryKVDB is the minimal in-memory KVDB engine
+type memoryKVDB struct {
+	sync.Mutex
+	items map[string]memoryItem
+}
+
+func (db *memoryKVDB) get(key string) (memoryItem, bool) {
+	item, ok := db.items[key]
+	if ok && !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		delete(db.items, key)
+		return memoryItem{}, false
+	}
+	return item, ok
+}
+
+func (db *memoryKVDB) Get(key string) (string, error) {
+	db.Lock()
+	defer db.Unlock()
+	item, _ := db.get(key)
+	return item.val, nil
+}
+
+func (db *memoryKVDB) Put(key string, val string) error {
+	db.Lock()
+	defer db.Unlock()
+	db.items[key] = memoryItem{val: val}
+	return nil
+}
+
+func (db *memoryKVDB) Del(key string) error {
+	db.Lock()
+	defer db.Unlock()
+	delete(db.items, key)
+	return nil
+}
+
+func (db *memoryKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
+	db.Lock()
+	defer db.Unlock()
+	it := &memoryIterator{}
+	for key := range db.items {
+		if item, ok := db.get(key); ok && key >= beginKey && key < endKey {
+			it.items = append(it.items, kvdbtypes.KVItem{Key: key, Val: item.val})
+		}
+	}
+	sort.Slice(it.items, func(i, j int) bool { return it.items[i].Key < it.items[j].Key })
+	return it, nil
+}
+
+func (db *memoryKVDB) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	if item, _ := db.get(key); item.val != oldVal {
+		return false, nil
+	}
+	db.items[key] = memoryItem{val: newVal}
+	return true, nil
+}
+
+func (db *memoryKVDB) Incr(key string, delta int64) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	item, _ := db.get(key)
+	val, _ := strconv.ParseInt(item.val, 10, 64)
+	val += delta
+	item.val = strconv.FormatInt(val, 10)
+	db.items[key] = item
+	return val, nil
+}
+
+func (db *memoryKVDB) PutWithTTL(key string, val string, ttl time.Duration) error {
+	db.Lock()
+	defer db.Unlock()
+	db.items[key] = memoryItem{val: val, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (db *memoryKVDB) Expire(key string, ttl time.Duration) error {
+	db.Lock()
+	defer db.Unlock()
+	if item, ok := db.get(key); ok {
+		item.expireAt = time.Now().Add(ttl)
+		db.items[key] = item
+	}
+	return nil
+}
+
+func (db *memoryKVDB) Close() {}
+
+func (db *memoryKVDB) IsConnectionError(err error) bool {
+	return false
+}
+
+type memoryIterator struct {
+	items []kvdbtypes.KVItem
+}
+
+func (it *memoryIterator) Next() (kvdbtypes.KVItem, error) {
+	if len(it.items) == 0 {
+		return kvdbtypes.KVItem{}, io.EOF
+	}
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
@@ -0,0 +1,80 @@
+package kvdb
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// Keys are partitioned by [kvdb].namespace (e.g. season ID). When the namespace is changed for a new season, keys of
+// the last season are kept in its namespace, and can be read by GetPrevious and GetRangePrevious if
+// [kvdb].previous_namespace is set. The previous namespace is read-only.
+
+const (
+	_KVDB_PREVIOUS_ASYNC_JOB_GROUP = "_kvdb_previous"
+)
+
+var (
+	previousEngine kvdbtypes.KVDBEngine // opened lazily by the async job group
+)
+
+// GetPrevious gets value of key from the previous namespace of KVDB, returns in callback
+func GetPrevious(key string, callback KVDBGetCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			if err != nil {
+				callback("", err)
+			} else {
+				callback(res.(string), nil)
+			}
+		}
+	}
+	async.AppendAsyncJob(_KVDB_PREVIOUS_ASYNC_JOB_GROUP, previousKVDBRoutine(func(engine kvdbtypes.KVDBEngine) (interface{}, error) {
+		return engine.Get(key)
+	}), ac)
+}
+
+// GetRangePrevious retrives key-value items of specified key range from the previous namespace of KVDB, returns in
+// callback
+func GetRangePrevious(beginKey string, endKey string, callback KVDBGetRangeCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			if err == nil {
+				callback(res.([]kvdbtypes.KVItem), nil)
+			} else {
+				callback(nil, err)
+			}
+		}
+	}
+	async.AppendAsyncJob(_KVDB_PREVIOUS_ASYNC_JOB_GROUP, previousKVDBRoutine(func(engine kvdbtypes.KVDBEngine) (interface{}, error) {
+		return findItems(engine, beginKey, endKey)
+	}), ac)
+}
+
+// previousKVDBRoutine runs r with the engine of the previous namespace, the engine is reopened if the connection is
+// broken
+func previousKVDBRoutine(r func(engine kvdbtypes.KVDBEngine) (interface{}, error)) async.AsyncRoutine {
+	return func() (res interface{}, err error) {
+		cfg := *conf.GetKVDB()
+		if !cfg.UsePreviousNamespace {
+			return nil, errors.Errorf("[kvdb].previous_namespace is not set")
+		}
+
+		if previousEngine == nil {
+			cfg.Namespace = cfg.PreviousNamespace
+			if previousEngine, err = openKVDBEngineOfConfig(&cfg); err != nil {
+				previousEngine = nil
+				return nil, err
+			}
+		}
+
+		res, err = r(previousEngine)
+		if err != nil && previousEngine.IsConnectionError(err) {
+			previousEngine.Close()
+			previousEngine = nil
+		}
+		return
+	}
+}
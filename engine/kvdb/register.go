@@ -0,0 +1,76 @@
+package kvdb
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmysql"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbpostgres"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbrediscluster"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// Factory opens the KVDB engine with the KVDB config
+//
+// Engines can read custom options from cfg.Options, which are set by option_<name> keys in the kvdb section.
+type Factory func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+func init() {
+	Register("mongodb", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return kvdbmongo.OpenMongoKVDB(cfg.Url, cfg.DB, cfg.Collection)
+	})
+	Register("redis", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		var dbindex int = -1
+		if cfg.DB != "" {
+			var err error
+			if dbindex, err = strconv.Atoi(cfg.DB); err != nil {
+				return nil, err
+			}
+		}
+		return kvdbredis.OpenRedisKVDB(cfg.Url, dbindex)
+	})
+	Register("redis_cluster", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return kvdbrediscluster.OpenRedisKVDB(cfg.StartNodes.ToList())
+	})
+	Register("postgres", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return kvdbpostgres.OpenPostgresKVDB(cfg.Driver, cfg.Url, cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxLifetime)
+	})
+	Register("sql", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		if cfg.Driver != "mysql" {
+			gwlog.Fatalf("KVDB mysql driver %s is unknown", cfg.Driver)
+		}
+		return kvdbmysql.OpenMySQLKVDB(cfg.Url)
+	})
+}
+
+// Register registers the KVDB engine of the KVDB type, so that it can be used by type=<typeName> in config
+//
+// Engines in other modules should be registered in init functions, before config is loaded.
+func Register(typeName string, factory Factory) {
+	if typeName == "" || factory == nil {
+		gwlog.Panicf("kvdb.Register: invalid KVDB type %q", typeName)
+	}
+
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, ok := factories[typeName]; ok {
+		gwlog.Panicf("kvdb.Register: KVDB type %s is already registered", typeName)
+	}
+	factories[typeName] = factory
+	config.RegisterKVDBType(typeName)
+}
+
+func getFactory(typeName string) Factory {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	return factories[typeName]
+}
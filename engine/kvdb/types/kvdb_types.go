@@ -1,11 +1,33 @@
 package kvdbtypes
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotSupported is returned (maybe wrapped) by engines which do not support the operation, e.g. Find on redis
+var ErrNotSupported = errors.New("operation not supported")
+
 // KVDBEngine defines the interface of a KVDB engine implementation
+//
+// Values of "" are the same as keys not exist. CompareAndSwap, Incr, PutWithTTL and Expire are atomic across processes
+// unless documented otherwise by the engine. Engines are registered by kvdb.Register to be used in config.
 type KVDBEngine interface {
 	Get(key string) (val string, err error)
 	Put(key string, val string) (err error)
+	Del(key string) (err error)
 	Find(beginKey string, endKey string) (Iterator, error)
+	// CompareAndSwap sets key to newVal if the value of key is oldVal, and clears the TTL of key
+	CompareAndSwap(key string, oldVal string, newVal string) (swapped bool, err error)
+	// Incr adds delta to the integer value of key (0 if not exists), returns the new value, and keeps the TTL of key
+	Incr(key string, delta int64) (val int64, err error)
+	// PutWithTTL puts the key-value which expires after ttl
+	PutWithTTL(key string, val string, ttl time.Duration) (err error)
+	// Expire sets the key to expire after ttl, it does nothing if the key does not exist
+	Expire(key string, ttl time.Duration) (err error)
 	Close()
+	// IsConnectionError returns if the error is caused by broken connection, so that the engine should be reopened
 	IsConnectionError(err error) bool
 }
 
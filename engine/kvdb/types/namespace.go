@@ -0,0 +1,72 @@
+package kvdbtypes
+
+import (
+	"strings"
+	"time"
+)
+
+// namespaceEngine partitions the KVDB by the data namespace (e.g. season ID), key K is stored as <namespace>/K
+type namespaceEngine struct {
+	KVDBEngine
+	prefix string
+}
+
+// NewNamespaceEngine returns the KVDB engine which reads and writes keys in the namespace of engine
+func NewNamespaceEngine(engine KVDBEngine, namespace string) KVDBEngine {
+	return &namespaceEngine{KVDBEngine: engine, prefix: namespace + "/"}
+}
+
+func (ne *namespaceEngine) Get(key string) (string, error) {
+	return ne.KVDBEngine.Get(ne.prefix + key)
+}
+
+func (ne *namespaceEngine) Put(key string, val string) error {
+	return ne.KVDBEngine.Put(ne.prefix+key, val)
+}
+
+func (ne *namespaceEngine) Del(key string) error {
+	return ne.KVDBEngine.Del(ne.prefix + key)
+}
+
+// Find finds keys in [beginKey, endKey) of the namespace, all keys after beginKey if endKey is ""
+func (ne *namespaceEngine) Find(beginKey string, endKey string) (Iterator, error) {
+	nsEndKey := ne.prefix + endKey
+	if endKey == "" {
+		nsEndKey = ne.prefix[:len(ne.prefix)-1] + "0" // '0' is next to '/'
+	}
+	it, err := ne.KVDBEngine.Find(ne.prefix+beginKey, nsEndKey)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceIterator{it, ne.prefix}, nil
+}
+
+func (ne *namespaceEngine) CompareAndSwap(key string, oldVal string, newVal string) (bool, error) {
+	return ne.KVDBEngine.CompareAndSwap(ne.prefix+key, oldVal, newVal)
+}
+
+func (ne *namespaceEngine) Incr(key string, delta int64) (int64, error) {
+	return ne.KVDBEngine.Incr(ne.prefix+key, delta)
+}
+
+func (ne *namespaceEngine) PutWithTTL(key string, val string, ttl time.Duration) error {
+	return ne.KVDBEngine.PutWithTTL(ne.prefix+key, val, ttl)
+}
+
+func (ne *namespaceEngine) Expire(key string, ttl time.Duration) error {
+	return ne.KVDBEngine.Expire(ne.prefix+key, ttl)
+}
+
+type namespaceIterator struct {
+	Iterator
+	prefix string
+}
+
+func (it *namespaceIterator) Next() (KVItem, error) {
+	item, err := it.Iterator.Next()
+	if err != nil {
+		return item, err
+	}
+	item.Key = strings.TrimPrefix(item.Key, it.prefix)
+	return item, nil
+}
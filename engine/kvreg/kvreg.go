@@ -20,6 +20,11 @@ func Register(key string, val string, force bool) {
 	dispatchercluster.SendKvregRegister(key, val, force)
 }
 
+// Get returns the registered value of key, or "" if not registered
+func Get(key string) string {
+	return kvmap[key]
+}
+
 func TraverseByPrefix(prefix string, cb func(key string, val string)) {
 	for key, val := range kvmap {
 		if strings.HasPrefix(key, prefix) {
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Exporters
+//
+// Metrics are exported by the exporters configured by exporters of [telemetry]. The prometheus exporter serves metrics
+// at /metrics for pulling, and the expvar exporter publishes them as the expvar goworld_metrics at /debug/vars. Other
+// exporters push metrics gathered periodically, e.g. the statsd exporter and the otlp exporter. Custom exporters
+// can be registered by RegisterExporter before Setup.
+
+const (
+	// ExporterPrometheus serves metrics in Prometheus text format at /metrics
+	ExporterPrometheus = "prometheus"
+	// ExporterExpvar publishes metrics as the expvar goworld_metrics
+	ExporterExpvar = "expvar"
+	// ExporterStatsD pushes metrics to StatsD over UDP, labels are sent as DogStatsD tags
+	ExporterStatsD = "statsd"
+	// ExporterOTLP pushes metrics to the OTLP/HTTP endpoint in JSON encoding
+	ExporterOTLP = "otlp"
+
+	_DEFAULT_PUSH_INTERVAL = time.Second * 10
+)
+
+// Options are options of exporters
+type Options struct {
+	Exporters    []string      // names of exporters, e.g. prometheus, statsd
+	PushInterval time.Duration // interval of pushing metrics to push exporters
+	StatsDAddr   string        // address of StatsD, e.g. 127.0.0.1:8125
+	StatsDPrefix string        // prefix of metric names sent to StatsD, e.g. goworld.
+	OTLPEndpoint string        // OTLP/HTTP endpoint of metrics, e.g. http://127.0.0.1:4318/v1/metrics
+
+	// Labels of the process (e.g. region:eu), which are sent with all metrics by push exporters, and exported as labels
+	// of goworld_process_labels for the prometheus exporter
+	Labels map[string]string
+}
+
+// Exporter pushes gathered metrics to a monitoring system
+type Exporter interface {
+	Export(families []*Family) error
+}
+
+// NewExporterFunc creates the exporter of the service (e.g. game1) by options
+type NewExporterFunc func(serviceName string, opts *Options) (Exporter, error)
+
+var (
+	prometheusEnabled xnsyncutil.AtomicBool
+	exporterFactories = map[string]NewExporterFunc{}
+	setupOnce         sync.Once
+
+	metricsExports = NewCounterVec("goworld_metrics_exports_total", "Metrics exports by push exporters, result is ok or failed", "exporter", "result")
+)
+
+func init() {
+	prometheusEnabled.Store(true) // until Setup is called
+	RegisterExporter(ExporterStatsD, newStatsDExporter)
+	RegisterExporter(ExporterOTLP, newOTLPExporter)
+}
+
+// RegisterExporter registers the push exporter, which is used if its name is in exporters of [telemetry]
+func RegisterExporter(name string, newExporter NewExporterFunc) {
+	exporterFactories[name] = newExporter
+}
+
+// Setup starts exporters of metrics of the service, the prometheus exporter is disabled if it is not in exporters
+func Setup(serviceName string, opts Options) {
+	setupOnce.Do(func() {
+		if err := setup(serviceName, &opts); err != nil {
+			gwlog.Fatalf("metrics: setup exporters failed: %s", err)
+		}
+	})
+}
+
+func setup(serviceName string, opts *Options) error {
+	if opts.PushInterval <= 0 {
+		opts.PushInterval = _DEFAULT_PUSH_INTERVAL
+	}
+
+	if len(opts.Labels) > 0 {
+		setProcessLabels(opts.Labels)
+	}
+
+	prometheusEnabled.Store(false)
+	for _, name := range opts.Exporters {
+		switch name {
+		case ExporterPrometheus:
+			prometheusEnabled.Store(true)
+		case ExporterExpvar:
+			expvar.Publish("goworld_metrics", expvar.Func(gatherValues))
+		default:
+			newExporter := exporterFactories[name]
+			if newExporter == nil {
+				return errors.Errorf("unknown exporter: %s", name)
+			}
+			exporter, err := newExporter(serviceName, opts)
+			if err != nil {
+				return errors.Wrapf(err, "create exporter %s failed", name)
+			}
+			go pushForever(name, exporter, opts.PushInterval)
+		}
+	}
+	gwlog.Infof("metrics: exporters = %s, push interval = %s", strings.Join(opts.Exporters, ","), opts.PushInterval)
+	return nil
+}
+
+// setProcessLabels exports labels of the process as the info metric, which can be joined with other metrics in queries
+func setProcessLabels(labels map[string]string) {
+	names := sortedLabelNames(labels)
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = labels[name]
+	}
+	NewGaugeVec("goworld_process_labels", "Labels of the process, the value is always 1", names...).With(values...).Set(1)
+}
+
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func pushForever(name string, exporter Exporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := exporter.Export(Gather()); err != nil {
+			gwlog.Warnf("metrics: export by %s failed: %s", name, err)
+			metricsExports.With(name, "failed").Inc()
+		} else {
+			metricsExports.With(name, "ok").Inc()
+		}
+	}
+}
+
+// gatherValues returns values of metrics for the expvar exporter, keyed by names with labels, e.g.
+// goworld_entities{type="Avatar"}, histograms are exported by counts and sums
+func gatherValues() interface{} {
+	values := map[string]float64{}
+	for _, f := range Gather() {
+		for _, sample := range f.Samples {
+			labels := formatLabels(f.LabelNames, sample.LabelValues)
+			if sample.Histogram != nil {
+				values[f.Name+"_count"+labels] = float64(sample.Histogram.Count)
+				values[f.Name+"_sum"+labels] = sample.Histogram.Sum
+			} else {
+				values[f.Name+labels] = sample.Value
+			}
+		}
+	}
+	return values
+}
+
+// formatLabels formats labels as {name1="value1",name2="value2"} in order of names
+func formatLabels(labelNames []string, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+
+	labels := make([]string, len(labelNames))
+	for i, name := range labelNames {
+		labels[i] = name + `="` + labelValues[i] + `"`
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
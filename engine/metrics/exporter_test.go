@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDExporter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	c := NewCounterVec("test_statsd_requests_total", "", "method")
+	c.With("Foo").Add(2)
+	NewGauge("test_statsd_gauge", "").Set(5)
+	exporter, err := newStatsDExporter("game1", &Options{StatsDAddr: conn.LocalAddr().String(), StatsDPrefix: "goworld."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func() string {
+		var lines []string
+		buf := make([]byte, _STATSD_MAX_PACKET_SIZE)
+		for {
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return strings.Join(lines, "\n")
+			}
+			if n > _STATSD_MAX_PACKET_SIZE {
+				t.Errorf("packet too large: %d", n)
+			}
+			lines = append(lines, string(buf[:n]))
+		}
+	}
+
+	if err := exporter.Export(Gather()); err != nil {
+		t.Fatal(err)
+	}
+	text := receive()
+	for _, line := range []string{
+		"goworld.test_statsd_requests_total:2|c|#service:game1,method:Foo",
+		"goworld.test_statsd_gauge:5|g|#service:game1",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("missing line %q", line)
+		}
+	}
+
+	c.With("Foo").Inc()
+	if err := exporter.Export(Gather()); err != nil {
+		t.Fatal(err)
+	}
+	text = receive()
+	if !strings.Contains(text, "goworld.test_statsd_requests_total:1|c|#service:game1,method:Foo") {
+		t.Errorf("counter should be sent as delta: %s", text)
+	}
+
+	if err := exporter.Export(Gather()); err != nil {
+		t.Fatal(err)
+	}
+	if text = receive(); strings.Contains(text, "test_statsd_requests_total") {
+		t.Errorf("unchanged counter should not be sent: %s", text)
+	}
+}
+
+func TestOTLPEncode(t *testing.T) {
+	h := NewHistogram("test_otlp_latency_seconds", "", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	NewCounter("test_otlp_total", "").Inc()
+
+	exporter, err := newOTLPExporter("gate1", &Options{OTLPEndpoint: "http://127.0.0.1:4318/v1/metrics", Labels: map[string]string{"region": "eu"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := exporter.(*otlpExporter).encode(Gather(), time.Now())
+	if _, err := json.Marshal(req); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := req.ResourceMetrics[0].Resource.Attributes; len(attrs) != 2 || attrs[0].Value.StringValue != "gate1" ||
+		attrs[1].Key != "region" || attrs[1].Value.StringValue != "eu" {
+		t.Errorf("wrong resource attributes: %+v", attrs)
+	}
+
+	var found int
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		switch m.Name {
+		case "test_otlp_latency_seconds":
+			found++
+			p := m.Histogram.DataPoints[0]
+			if p.Count != "3" || strings.Join(p.BucketCounts, ",") != "1,1,1" || len(p.ExplicitBounds) != 2 {
+				t.Errorf("wrong histogram data point: %+v", p)
+			}
+		case "test_otlp_total":
+			found++
+			if m.Sum == nil || !m.Sum.IsMonotonic || m.Sum.DataPoints[0].AsDouble != 1 {
+				t.Errorf("wrong counter: %+v", m)
+			}
+		}
+	}
+	if found != 2 {
+		t.Errorf("metrics not found")
+	}
+}
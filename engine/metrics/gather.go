@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"expvar"
+	"sort"
+	"sync/atomic"
+)
+
+// Family is a snapshot of samples of the metric with the same name, gathered for exporters
+type Family struct {
+	Name       string
+	Help       string
+	Type       string // counter, gauge, histogram or untyped
+	LabelNames []string
+	Samples    []Sample
+}
+
+// Sample is a snapshot of the metric with label values
+type Sample struct {
+	LabelValues []string
+	Value       float64            // value of counters and gauges
+	Histogram   *HistogramSnapshot // snapshot of histograms
+}
+
+// HistogramSnapshot is a snapshot of the histogram
+type HistogramSnapshot struct {
+	UpperBounds []float64 // upper bounds of buckets, without +Inf
+	Counts      []uint64  // cumulative counts of buckets, the last one is of +Inf which equals Count
+	Sum         float64
+	Count       uint64
+}
+
+func (f *Family) addSample(labelValues []string, val float64) {
+	f.Samples = append(f.Samples, Sample{LabelValues: labelValues, Value: val})
+}
+
+func (f *Family) addHistogramSample(labelValues []string, h *Histogram) {
+	snapshot := &HistogramSnapshot{
+		UpperBounds: h.buckets,
+		Counts:      make([]uint64, len(h.counts)),
+		Sum:         h.sum.get(),
+	}
+	for i := range h.counts {
+		snapshot.Count += atomic.LoadUint64(&h.counts[i])
+		snapshot.Counts[i] = snapshot.Count
+	}
+	f.Samples = append(f.Samples, Sample{LabelValues: labelValues, Histogram: snapshot})
+}
+
+// Gather returns snapshots of all registered metrics and exported expvars in order of names
+func Gather() []*Family {
+	registryLock.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = registry[name]
+	}
+	registryLock.RUnlock()
+
+	families := make([]*Family, 0, len(metrics))
+	for _, m := range metrics {
+		d := m.desc()
+		f := &Family{Name: d.name, Help: d.help, Type: d.typ, LabelNames: d.labelNames}
+		m.collect(f)
+		families = append(families, f)
+	}
+	return append(families, gatherExpvars()...)
+}
+
+// gatherExpvars returns integer and float expvars, and maps of them
+func gatherExpvars() (families []*Family) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		name := _EXPVAR_PREFIX + toSnakeCase(kv.Key)
+		if !metricNameRegexp.MatchString(name) {
+			return
+		}
+
+		registryLock.RLock()
+		_, registered := registry[name]
+		registryLock.RUnlock()
+		if registered {
+			return
+		}
+
+		f := &Family{Name: name, Type: "untyped"}
+		switch v := kv.Value.(type) {
+		case *expvar.Int:
+			f.addSample(nil, float64(v.Value()))
+		case *expvar.Float:
+			f.addSample(nil, v.Value())
+		case *expvar.Map:
+			f.LabelNames = []string{"key"}
+			v.Do(func(kv expvar.KeyValue) {
+				switch iv := kv.Value.(type) {
+				case *expvar.Int:
+					f.addSample([]string{kv.Key}, float64(iv.Value()))
+				case *expvar.Float:
+					f.addSample([]string{kv.Key}, iv.Value())
+				}
+			})
+		}
+		if len(f.Samples) > 0 {
+			families = append(families, f)
+		}
+	})
+	return
+}
@@ -0,0 +1,365 @@
+// Package metrics exports metrics in Prometheus text format at /metrics of the HTTP server of dispatchers, gates and
+// games
+//
+// Engine metrics are registered by engine packages, and game developers can register custom metrics in the same way:
+//
+//	var dungeonEntries = metrics.NewCounterVec("mygame_dungeon_entries_total", "Dungeon entries", "dungeon")
+//	dungeonEntries.With("dragon_lair").Inc()
+//
+// Integer and float expvars (and maps of them) are also exported, with names converted to snake case and prefixed by
+// goworld_, e.g. GateSessionsResumed is exported as goworld_gate_sessions_resumed.
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// DefBuckets are the default histogram buckets for latencies in seconds
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	registryLock sync.RWMutex
+	registry     = map[string]metric{}
+)
+
+func init() {
+	http.Handle("/metrics", Handler())
+	NewGaugeFunc("goworld_goroutines", "Number of goroutines", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+type metric interface {
+	desc() *metricDesc
+	collect(f *Family)
+}
+
+type metricDesc struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (d *metricDesc) desc() *metricDesc {
+	return d
+}
+
+func register(m metric) {
+	d := m.desc()
+	if !metricNameRegexp.MatchString(d.name) {
+		gwlog.Panicf("metrics: invalid metric name: %s", d.name)
+	}
+	for _, labelName := range d.labelNames {
+		if !labelNameRegexp.MatchString(labelName) {
+			gwlog.Panicf("metrics: invalid label name of %s: %s", d.name, labelName)
+		}
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[d.name]; ok {
+		gwlog.Panicf("metrics: duplicate metric: %s", d.name)
+	}
+	registry[d.name] = m
+}
+
+// Unregister removes the metric from registry
+func Unregister(name string) {
+	registryLock.Lock()
+	delete(registry, name)
+	registryLock.Unlock()
+}
+
+// atomicFloat is a float64 which can be updated atomically
+type atomicFloat uint64
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64((*uint64)(f))
+		if atomic.CompareAndSwapUint64((*uint64)(f), old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64((*uint64)(f), math.Float64bits(v))
+}
+
+func (f *atomicFloat) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64((*uint64)(f)))
+}
+
+// Counter is a metric which only increases
+type Counter struct {
+	val atomicFloat
+}
+
+// Inc increases the counter by 1
+func (c *Counter) Inc() {
+	c.val.add(1)
+}
+
+// Add increases the counter by v, which should not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		gwlog.Panicf("metrics: counter can not decrease: %v", v)
+	}
+	c.val.add(v)
+}
+
+// Value returns the current value of counter
+func (c *Counter) Value() float64 {
+	return c.val.get()
+}
+
+// Gauge is a metric which can go up and down
+type Gauge struct {
+	val atomicFloat
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.val.set(v)
+}
+
+// Add adds v to the gauge
+func (g *Gauge) Add(v float64) {
+	g.val.add(v)
+}
+
+// Inc increases the gauge by 1
+func (g *Gauge) Inc() {
+	g.val.add(1)
+}
+
+// Dec decreases the gauge by 1
+func (g *Gauge) Dec() {
+	g.val.add(-1)
+}
+
+// Value returns the current value of gauge
+func (g *Gauge) Value() float64 {
+	return g.val.get()
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	buckets []float64 // upper bounds, sorted
+	counts  []uint64  // count of observations in each bucket (not cumulative), the last one is +Inf
+	sum     atomicFloat
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		gwlog.Panicf("metrics: histogram buckets are not sorted: %v", buckets)
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds an observation to the histogram
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.add(v)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() (count uint64) {
+	for i := range h.counts {
+		count += atomic.LoadUint64(&h.counts[i])
+	}
+	return
+}
+
+type counterMetric struct {
+	metricDesc
+	*Counter
+}
+
+func (m *counterMetric) collect(f *Family) {
+	f.addSample(nil, m.Value())
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name string, help string) *Counter {
+	m := &counterMetric{metricDesc{name, help, "counter", nil}, &Counter{}}
+	register(m)
+	return m.Counter
+}
+
+type gaugeMetric struct {
+	metricDesc
+	*Gauge
+}
+
+func (m *gaugeMetric) collect(f *Family) {
+	f.addSample(nil, m.Value())
+}
+
+// NewGauge creates and registers a gauge
+func NewGauge(name string, help string) *Gauge {
+	m := &gaugeMetric{metricDesc{name, help, "gauge", nil}, &Gauge{}}
+	register(m)
+	return m.Gauge
+}
+
+type gaugeFuncMetric struct {
+	metricDesc
+	f func() float64
+}
+
+func (m *gaugeFuncMetric) collect(f *Family) {
+	f.addSample(nil, m.f())
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by f when metrics are collected
+//
+// f is called in the HTTP server goroutine or goroutines of exporters, so it must be safe for concurrent use.
+func NewGaugeFunc(name string, help string, f func() float64) {
+	register(&gaugeFuncMetric{metricDesc{name, help, "gauge", nil}, f})
+}
+
+type histogramMetric struct {
+	metricDesc
+	*Histogram
+}
+
+func (m *histogramMetric) collect(f *Family) {
+	f.addHistogramSample(nil, m.Histogram)
+}
+
+// NewHistogram creates and registers a histogram, buckets are upper bounds of buckets in increasing order
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	m := &histogramMetric{metricDesc{name, help, "histogram", nil}, newHistogram(buckets)}
+	register(m)
+	return m.Histogram
+}
+
+// vec keeps metrics of the same name with different label values
+type vec struct {
+	metricDesc
+	sync.RWMutex
+	children map[string]interface{}
+	newChild func() interface{}
+}
+
+func newVec(name, help, typ string, labelNames []string, newChild func() interface{}) *vec {
+	v := &vec{
+		metricDesc: metricDesc{name, help, typ, labelNames},
+		children:   map[string]interface{}{},
+		newChild:   newChild,
+	}
+	register(v)
+	return v
+}
+
+func (v *vec) with(labelValues []string) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		gwlog.Panicf("metrics: %s expects %d label values, but got %v", v.name, len(v.labelNames), labelValues)
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	v.RLock()
+	child := v.children[key]
+	v.RUnlock()
+	if child != nil {
+		return child
+	}
+
+	v.Lock()
+	if child = v.children[key]; child == nil {
+		child = v.newChild()
+		v.children[key] = child
+	}
+	v.Unlock()
+	return child
+}
+
+func (v *vec) collect(f *Family) {
+	v.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]interface{}, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.RUnlock()
+
+	for i, key := range keys {
+		labelValues := strings.Split(key, "\xff")
+		switch child := children[i].(type) {
+		case *Counter:
+			f.addSample(labelValues, child.Value())
+		case *Gauge:
+			f.addSample(labelValues, child.Value())
+		case *Histogram:
+			f.addHistogramSample(labelValues, child)
+		}
+	}
+}
+
+// CounterVec is a set of counters with the same name and different label values
+type CounterVec struct {
+	v *vec
+}
+
+// NewCounterVec creates and registers a counter vector
+func NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, "counter", labelNames, func() interface{} { return &Counter{} })}
+}
+
+// With returns the counter with label values, which is created if not exists
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.v.with(labelValues).(*Counter)
+}
+
+// GaugeVec is a set of gauges with the same name and different label values
+type GaugeVec struct {
+	v *vec
+}
+
+// NewGaugeVec creates and registers a gauge vector
+func NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, "gauge", labelNames, func() interface{} { return &Gauge{} })}
+}
+
+// With returns the gauge with label values, which is created if not exists
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return gv.v.with(labelValues).(*Gauge)
+}
+
+// HistogramVec is a set of histograms with the same name and buckets, and different label values
+type HistogramVec struct {
+	v *vec
+}
+
+// NewHistogramVec creates and registers a histogram vector
+func NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	newHistogram(buckets) // validate buckets
+	return &HistogramVec{newVec(name, help, "histogram", labelNames, func() interface{} { return newHistogram(buckets) })}
+}
+
+// With returns the histogram with label values, which is created if not exists
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.v.with(labelValues).(*Histogram)
+}
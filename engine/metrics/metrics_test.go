@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"bytes"
+	"expvar"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	c := NewCounter("test_counter_total", "A test counter")
+	c.Add(3)
+	g := NewGaugeVec("test_gauge", "A test gauge", "type")
+	g.With("Avatar").Inc()
+	g.With("Avatar").Inc()
+	g.With("Monster").Dec()
+	h := NewHistogramVec("test_latency_seconds", "", []float64{0.1, 1}, "method")
+	h.With("Foo").Observe(0.05)
+	h.With("Foo").Observe(0.5)
+	h.With("Foo").Observe(5)
+	expvar.NewInt("TestExpvarInt").Set(7)
+	m := expvar.NewMap("TestExpvarMap")
+	m.Add("a\"b", 2)
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	t.Logf("%s", text)
+
+	for _, line := range []string{
+		"# HELP test_counter_total A test counter",
+		"# TYPE test_counter_total counter",
+		"test_counter_total 3",
+		`test_gauge{type="Avatar"} 2`,
+		`test_gauge{type="Monster"} -1`,
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{method="Foo",le="0.1"} 1`,
+		`test_latency_seconds_bucket{method="Foo",le="1"} 2`,
+		`test_latency_seconds_bucket{method="Foo",le="+Inf"} 3`,
+		`test_latency_seconds_sum{method="Foo"} 5.55`,
+		`test_latency_seconds_count{method="Foo"} 3`,
+		"goworld_test_expvar_int 7",
+		`goworld_test_expvar_map{key="a\"b"} 2`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("line not found: %s", line)
+		}
+	}
+}
+
+func TestToSnakeCase(t *testing.T) {
+	for s, expected := range map[string]string{
+		"GateSessionsResumed": "gate_sessions_resumed",
+		"SpaceStats":          "space_stats",
+		"cmdline":             "cmdline",
+	} {
+		if actual := toSnakeCase(s); actual != expected {
+			t.Errorf("toSnakeCase(%s) = %s, expected %s", s, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_OTLP_REQUEST_TIMEOUT = time.Second * 10
+
+	_OTLP_TEMPORALITY_CUMULATIVE = 2
+)
+
+// otlpExporter pushes metrics to the OTLP/HTTP endpoint in JSON encoding with cumulative temporality
+type otlpExporter struct {
+	resource  otlpResource // service name and labels of the process
+	endpoint  string
+	client    *http.Client
+	startTime string
+}
+
+func newOTLPExporter(serviceName string, opts *Options) (Exporter, error) {
+	if opts.OTLPEndpoint == "" {
+		return nil, errors.Errorf("otlp_endpoint is not set")
+	}
+	resource := otlpResource{Attributes: []otlpAttr{{"service.name", otlpAttrValue{serviceName}}}}
+	for _, name := range sortedLabelNames(opts.Labels) {
+		resource.Attributes = append(resource.Attributes, otlpAttr{name, otlpAttrValue{opts.Labels[name]}})
+	}
+	return &otlpExporter{
+		resource:  resource,
+		endpoint:  opts.OTLPEndpoint,
+		client:    &http.Client{Timeout: _OTLP_REQUEST_TIMEOUT},
+		startTime: strconv.FormatInt(time.Now().UnixNano(), 10),
+	}, nil
+}
+
+func (e *otlpExporter) Export(families []*Family) error {
+	body, err := json.Marshal(e.encode(families, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                      `json:"aggregationTemporality"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsDouble          float64    `json:"asDouble"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	Count             string     `json:"count"`
+	Sum               float64    `json:"sum"`
+	BucketCounts      []string   `json:"bucketCounts"`
+	ExplicitBounds    []float64  `json:"explicitBounds"`
+}
+
+type otlpAttr struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (e *otlpExporter) encode(families []*Family, now time.Time) *otlpMetricsRequest {
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, f := range families {
+		m := otlpMetric{Name: f.Name, Description: f.Help}
+		var points []otlpNumberDataPoint
+		for _, sample := range f.Samples {
+			attrs := make([]otlpAttr, len(f.LabelNames))
+			for i, name := range f.LabelNames {
+				attrs[i] = otlpAttr{name, otlpAttrValue{sample.LabelValues[i]}}
+			}
+
+			if h := sample.Histogram; h != nil {
+				if m.Histogram == nil {
+					m.Histogram = &otlpHistogram{AggregationTemporality: _OTLP_TEMPORALITY_CUMULATIVE}
+				}
+				// bucket counts of OTLP are not cumulative
+				bucketCounts := make([]string, len(h.Counts))
+				var last uint64
+				for i, count := range h.Counts {
+					bucketCounts[i] = strconv.FormatUint(count-last, 10)
+					last = count
+				}
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, otlpHistogramDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: e.startTime,
+					TimeUnixNano:      timestamp,
+					Count:             strconv.FormatUint(h.Count, 10),
+					Sum:               h.Sum,
+					BucketCounts:      bucketCounts,
+					ExplicitBounds:    h.UpperBounds,
+				})
+				continue
+			}
+			points = append(points, otlpNumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: e.startTime,
+				TimeUnixNano:      timestamp,
+				AsDouble:          sample.Value,
+			})
+		}
+
+		if m.Histogram == nil {
+			if len(points) == 0 {
+				continue
+			}
+			if f.Type == "counter" {
+				m.Sum = &otlpSum{DataPoints: points, AggregationTemporality: _OTLP_TEMPORALITY_CUMULATIVE, IsMonotonic: true}
+			} else {
+				m.Gauge = &otlpGauge{DataPoints: points}
+			}
+		}
+		metrics = append(metrics, m)
+	}
+
+	return &otlpMetricsRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: e.resource,
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "goworld"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
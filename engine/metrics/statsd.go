@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_STATSD_MAX_PACKET_SIZE = 1432 // fits in the MTU of Ethernet
+)
+
+var (
+	statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", ":", "_")
+)
+
+// statsdExporter pushes metrics to StatsD over UDP
+//
+// Counters are sent as counts of increments since the last export, gauges and untyped metrics are sent as gauges, and
+// histograms are sent as counts of their counts and sums. Labels, the service name and labels of the process are sent
+// as DogStatsD tags, which are supported by Datadog agents, Telegraf and StatsD exporters of Prometheus.
+type statsdExporter struct {
+	conn        net.Conn
+	prefix      string
+	processTags string             // tags of the service name and labels of the process
+	lastValues  map[string]float64 // last values of counters by lines without values
+}
+
+func newStatsDExporter(serviceName string, opts *Options) (Exporter, error) {
+	if opts.StatsDAddr == "" {
+		return nil, errors.Errorf("statsd_addr is not set")
+	}
+	conn, err := net.Dial("udp", opts.StatsDAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdExporter{
+		conn:        conn,
+		prefix:      opts.StatsDPrefix,
+		processTags: statsdProcessTags(serviceName, opts.Labels),
+		lastValues:  map[string]float64{},
+	}, nil
+}
+
+func (e *statsdExporter) Export(families []*Family) error {
+	var packet bytes.Buffer
+	var err error
+	send := func(name string, tags string, val float64, typ string) {
+		line := e.prefix + name + ":" + formatFloat(val) + "|" + typ + "|#" + tags
+		if packet.Len() > 0 && packet.Len()+1+len(line) > _STATSD_MAX_PACKET_SIZE {
+			if _, werr := e.conn.Write(packet.Bytes()); werr != nil {
+				err = werr
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	for _, f := range families {
+		for _, sample := range f.Samples {
+			tags := e.tags(f.LabelNames, sample.LabelValues)
+			switch {
+			case sample.Histogram != nil:
+				if count := e.delta(f.Name+".count|"+tags, float64(sample.Histogram.Count)); count > 0 {
+					send(f.Name+".count", tags, count, "c")
+					send(f.Name+".sum", tags, e.delta(f.Name+".sum|"+tags, sample.Histogram.Sum), "c")
+				}
+			case f.Type == "counter":
+				if delta := e.delta(f.Name+"|"+tags, sample.Value); delta > 0 { // unchanged counters are not sent
+					send(f.Name, tags, delta, "c")
+				}
+			default:
+				send(f.Name, tags, sample.Value, "g")
+			}
+		}
+	}
+	if packet.Len() > 0 {
+		if _, werr := e.conn.Write(packet.Bytes()); werr != nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// delta returns the increment of the counter since the last export
+func (e *statsdExporter) delta(key string, val float64) float64 {
+	last := e.lastValues[key]
+	e.lastValues[key] = val
+	if val < last { // counter is reset
+		return val
+	}
+	return val - last
+}
+
+func (e *statsdExporter) tags(labelNames []string, labelValues []string) string {
+	tags := make([]string, 0, len(labelNames)+1)
+	tags = append(tags, e.processTags)
+	for i, name := range labelNames {
+		tags = append(tags, name+":"+statsdTagReplacer.Replace(labelValues[i]))
+	}
+	return strings.Join(tags, ",")
+}
+
+func statsdProcessTags(serviceName string, labels map[string]string) string {
+	tags := []string{"service:" + serviceName}
+	for _, name := range sortedLabelNames(labels) {
+		tags = append(tags, name+":"+statsdTagReplacer.Replace(labels[name]))
+	}
+	return strings.Join(tags, ",")
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	_EXPVAR_PREFIX = "goworld_"
+	_CONTENT_TYPE  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Handler returns the HTTP handler which serves metrics in Prometheus text format, unless the prometheus exporter is
+// disabled by Setup
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !prometheusEnabled.Load() {
+			http.Error(w, "prometheus exporter is disabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", _CONTENT_TYPE)
+		WriteText(w)
+	})
+}
+
+// WriteText writes all metrics to w in Prometheus text format
+func WriteText(w io.Writer) error {
+	tw := &textWriter{w: bufio.NewWriter(w)}
+	for _, f := range Gather() {
+		tw.writeHeader(f.Name, f.Help, f.Type)
+		for _, sample := range f.Samples {
+			if sample.Histogram != nil {
+				tw.writeHistogram(f.Name, f.LabelNames, sample.LabelValues, sample.Histogram)
+			} else {
+				tw.writeSample(f.Name, f.LabelNames, sample.LabelValues, sample.Value)
+			}
+		}
+	}
+	return tw.w.Flush()
+}
+
+type textWriter struct {
+	w *bufio.Writer
+}
+
+func (tw *textWriter) writeHeader(name, help, typ string) {
+	if help != "" {
+		tw.w.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	}
+	tw.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (tw *textWriter) writeSample(name string, labelNames []string, labelValues []string, val float64) {
+	tw.writeSampleWithExtraLabel(name, labelNames, labelValues, "", "", val)
+}
+
+func (tw *textWriter) writeSampleWithExtraLabel(name string, labelNames []string, labelValues []string, extraName string, extraValue string, val float64) {
+	tw.w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		tw.w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				tw.w.WriteByte(',')
+			}
+			tw.writeLabel(labelName, labelValues[i])
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				tw.w.WriteByte(',')
+			}
+			tw.writeLabel(extraName, extraValue)
+		}
+		tw.w.WriteByte('}')
+	}
+	tw.w.WriteByte(' ')
+	tw.w.WriteString(formatFloat(val))
+	tw.w.WriteByte('\n')
+}
+
+func (tw *textWriter) writeLabel(name string, value string) {
+	tw.w.WriteString(name)
+	tw.w.WriteString(`="`)
+	tw.w.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+	tw.w.WriteByte('"')
+}
+
+func (tw *textWriter) writeHistogram(name string, labelNames []string, labelValues []string, h *HistogramSnapshot) {
+	for i, upperBound := range h.UpperBounds {
+		tw.writeSampleWithExtraLabel(name+"_bucket", labelNames, labelValues, "le", formatFloat(upperBound), float64(h.Counts[i]))
+	}
+	tw.writeSampleWithExtraLabel(name+"_bucket", labelNames, labelValues, "le", "+Inf", float64(h.Count))
+	tw.writeSample(name+"_sum", labelNames, labelValues, h.Sum)
+	tw.writeSample(name+"_count", labelNames, labelValues, float64(h.Count))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// toSnakeCase converts CamelCase names to snake_case
+func toSnakeCase(s string) string {
+	var sb strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) && runes[i-1] != '_' {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,202 @@
+package netutil
+
+import (
+	"bufio"
+	"hash/crc32"
+	"math"
+	"net"
+
+	"github.com/golang/snappy"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwioutil"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/netconnutil"
+)
+
+// Snappy framing format, see https://github.com/google/snappy/blob/master/framing_format.txt
+const (
+	_SNAPPY_CHUNK_COMPRESSED   = 0x00
+	_SNAPPY_CHUNK_UNCOMPRESSED = 0x01
+	_SNAPPY_MAX_BLOCK_SIZE     = 65536
+	_SNAPPY_CHUNK_HEADER_SIZE  = 8 // chunk type, 3 bytes of chunk length and 4 bytes of checksum
+	_SNAPPY_STREAM_IDENTIFIER  = "\xff\x06\x00\x00sNaPpY"
+
+	_ENTROPY_SAMPLE_SIZE = 4096 // bytes sampled for estimating entropy of the chunk
+)
+
+var (
+	snappyCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+	compressInputBytes     = metrics.NewCounterVec("goworld_compress_input_bytes_total", "Bytes written to compressing connections, result is compressed, small, entropy, msgtype or incompressible", "result")
+	compressCompressed     = compressInputBytes.With("compressed")
+	compressSkippedSmall   = compressInputBytes.With("small")
+	compressSkippedEntropy = compressInputBytes.With("entropy")
+	compressSkippedMsgType = compressInputBytes.With("msgtype")
+	compressIncompressible = compressInputBytes.With("incompressible")
+	compressOutputBytes    = metrics.NewCounter("goworld_compress_output_bytes_total", "Bytes written by compressing connections after compression")
+)
+
+// CompressOptions decides which data is compressed by CompressConn
+type CompressOptions struct {
+	MinBytes     int             // data shorter than this is not compressed
+	MaxEntropy   float64         // data with higher entropy (bits per byte) is not compressed, since it is likely compressed or encrypted already
+	SkipMsgTypes map[uint16]bool // packets of these message types are not compressed
+}
+
+// CompressConn is a connection compressing data in the snappy framing format, which can be read by snappy.Reader
+//
+// Unlike snappy.Writer, data is compressed only if it is worthwhile. Small writes, data which looks random, and
+// packets of skipped message types are written as uncompressed chunks of the same format, so readers are not affected.
+// Written data is buffered until Flush, so CompressConn should not be wrapped by buffered connections, otherwise packets
+// written by PacketConnection can not be recognized.
+type CompressConn struct {
+	net.Conn
+	reader        *snappy.Reader
+	opts          CompressOptions
+	pending       []byte // data to compress, which is not written yet
+	out           []byte // chunks to write when flushed
+	encoded       []byte
+	wroteStreamID bool
+}
+
+// NewCompressConn creates a compressing connection
+func NewCompressConn(conn net.Conn, opts CompressOptions) *CompressConn {
+	return &CompressConn{
+		Conn:   conn,
+		reader: snappy.NewReader(bufio.NewReaderSize(conn, consts.BUFFERED_READ_BUFFSIZE)),
+		opts:   opts,
+	}
+}
+
+func (cc *CompressConn) Read(b []byte) (int, error) {
+	return cc.reader.Read(b)
+}
+
+// Write buffers the data to compress
+func (cc *CompressConn) Write(b []byte) (int, error) {
+	cc.pending = append(cc.pending, b...)
+	if len(cc.pending) >= _SNAPPY_MAX_BLOCK_SIZE {
+		cc.writePending()
+	}
+	return len(b), nil
+}
+
+// WritePacket buffers data of the packet, which is not compressed if the message type is skipped
+func (cc *CompressConn) WritePacket(data []byte) error {
+	if len(data) >= _PREPAYLOAD_SIZE+2 && cc.opts.SkipMsgTypes[packetEndian.Uint16(data[_PREPAYLOAD_SIZE:])] {
+		cc.writePending()
+		compressSkippedMsgType.Add(float64(len(data)))
+		cc.writeChunks(data, false)
+		return nil
+	}
+
+	_, err := cc.Write(data)
+	return err
+}
+
+// Flush writes all buffered data to the connection
+func (cc *CompressConn) Flush() error {
+	cc.writePending()
+	if len(cc.out) > 0 {
+		compressOutputBytes.Add(float64(len(cc.out)))
+		err := gwioutil.WriteAll(cc.Conn, cc.out)
+		cc.out = cc.out[:0]
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := cc.Conn.(netconnutil.Flushable); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (cc *CompressConn) writePending() {
+	if len(cc.pending) == 0 {
+		return
+	}
+
+	cc.writeChunks(cc.pending, true)
+	cc.pending = cc.pending[:0]
+}
+
+// writeChunks appends chunks of the data to the output, data is compressed if it is compressible and worthwhile
+func (cc *CompressConn) writeChunks(data []byte, compressible bool) {
+	if !cc.wroteStreamID {
+		cc.out = append(cc.out, _SNAPPY_STREAM_IDENTIFIER...)
+		cc.wroteStreamID = true
+	}
+
+	for len(data) > 0 {
+		block := data
+		if len(block) > _SNAPPY_MAX_BLOCK_SIZE {
+			block = block[:_SNAPPY_MAX_BLOCK_SIZE]
+		}
+		data = data[len(block):]
+
+		if !compressible {
+			cc.appendChunk(_SNAPPY_CHUNK_UNCOMPRESSED, block, block)
+			continue
+		}
+		if len(block) < cc.opts.MinBytes {
+			compressSkippedSmall.Add(float64(len(block)))
+			cc.appendChunk(_SNAPPY_CHUNK_UNCOMPRESSED, block, block)
+			continue
+		}
+		if cc.opts.MaxEntropy > 0 && estimateEntropy(block) > cc.opts.MaxEntropy {
+			compressSkippedEntropy.Add(float64(len(block)))
+			cc.appendChunk(_SNAPPY_CHUNK_UNCOMPRESSED, block, block)
+			continue
+		}
+
+		cc.encoded = snappy.Encode(cc.encoded[:cap(cc.encoded)], block)
+		if len(cc.encoded) >= len(block)-len(block)/8 { // same as snappy.Writer, compressed data should save 12.5% at least
+			compressIncompressible.Add(float64(len(block)))
+			cc.appendChunk(_SNAPPY_CHUNK_UNCOMPRESSED, block, block)
+			continue
+		}
+		compressCompressed.Add(float64(len(block)))
+		cc.appendChunk(_SNAPPY_CHUNK_COMPRESSED, block, cc.encoded)
+	}
+}
+
+func (cc *CompressConn) appendChunk(chunkType byte, block []byte, chunkData []byte) {
+	checksum := snappyChecksum(block)
+	chunkLen := len(chunkData) + 4
+	cc.out = append(cc.out,
+		chunkType, byte(chunkLen), byte(chunkLen>>8), byte(chunkLen>>16),
+		byte(checksum), byte(checksum>>8), byte(checksum>>16), byte(checksum>>24),
+	)
+	cc.out = append(cc.out, chunkData...)
+}
+
+// snappyChecksum returns the masked CRC-32C checksum of the data
+func snappyChecksum(b []byte) uint32 {
+	c := crc32.Update(0, snappyCRCTable, b)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// estimateEntropy estimates the Shannon entropy of the data in bits per byte by sampling
+func estimateEntropy(b []byte) float64 {
+	step := 1
+	if len(b) > _ENTROPY_SAMPLE_SIZE {
+		step = len(b) / _ENTROPY_SAMPLE_SIZE
+	}
+
+	var counts [256]int
+	n := 0
+	for i := 0; i < len(b); i += step {
+		counts[b[i]]++
+		n++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(n)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
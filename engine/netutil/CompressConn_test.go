@@ -0,0 +1,82 @@
+package netutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// bufferConn is a connection writing to the buffer
+type bufferConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (bc *bufferConn) Write(b []byte) (int, error) {
+	return bc.buf.Write(b)
+}
+
+func sendTestPacketOfType(pc *PacketConnection, msgtype uint16, payload []byte) {
+	packet := pc.NewPacket()
+	packet.AppendUint16(msgtype)
+	packet.AppendBytes(payload)
+	pc.SendPacket(packet)
+	packet.Release()
+}
+
+func TestCompressConn(t *testing.T) {
+	out := &bufferConn{}
+	cc := NewCompressConn(out, CompressOptions{MinBytes: 512, MaxEntropy: 7.5, SkipMsgTypes: map[uint16]bool{3: true}})
+	pc := NewPacketConnection(cc)
+
+	random := make([]byte, 8192)
+	rand.Read(random)
+	repeated := bytes.Repeat([]byte("goworld"), 1000)
+
+	compressed, small, entropy, msgtype := compressCompressed.Value(), compressSkippedSmall.Value(), compressSkippedEntropy.Value(), compressSkippedMsgType.Value()
+	sendTestPacketOfType(pc, 1, []byte("heartbeat"))
+	if err := pc.Flush("test"); err != nil {
+		t.Fatal(err)
+	}
+	sendTestPacketOfType(pc, 2, random)
+	if err := pc.Flush("test"); err != nil {
+		t.Fatal(err)
+	}
+	sendTestPacketOfType(pc, 3, repeated)
+	sendTestPacketOfType(pc, 1, repeated)
+	sendTestPacketOfType(pc, 1, repeated[:100])
+	if err := pc.Flush("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if compressSkippedSmall.Value() == small || compressSkippedEntropy.Value() == entropy || compressSkippedMsgType.Value() == msgtype || compressCompressed.Value() == compressed {
+		t.Fatalf("wrong compress results: %v compressed, %v small, %v entropy, %v msgtype", compressCompressed.Value()-compressed,
+			compressSkippedSmall.Value()-small, compressSkippedEntropy.Value()-entropy, compressSkippedMsgType.Value()-msgtype)
+	}
+	if out.buf.Len() >= len(random)+len(repeated)*2 {
+		t.Fatalf("repeated data should be compressed, but written %d bytes", out.buf.Len())
+	}
+
+	data, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(out.buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []byte
+	for _, p := range []struct {
+		msgtype uint16
+		payload []byte
+	}{{1, []byte("heartbeat")}, {2, random}, {3, repeated}, {1, repeated}, {1, repeated[:100]}} {
+		packet := NewPacket()
+		packet.AppendUint16(p.msgtype)
+		packet.AppendBytes(p.payload)
+		expected = append(expected, packet.data()...)
+		packet.Release()
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("data read by snappy reader is different: %d bytes, expected %d bytes", len(data), len(expected))
+	}
+}
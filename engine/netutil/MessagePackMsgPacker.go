@@ -2,24 +2,46 @@ package netutil
 
 import (
 	"bytes"
+	"sync"
 
 	"github.com/vmihailenco/msgpack"
 )
 
+const (
+	_MAX_POOLED_ENCODER_BUFFER_SIZE = 64 * 1024 // larger encoder buffers are not pooled to avoid holding too much memory
+)
+
 // MessagePackMsgPacker packs and unpacks message in MessagePack format
 type MessagePackMsgPacker struct{}
 
-// PackMsg packs message to bytes in MessagePack format
+// msgpackEncoder is an encoder bound to its buffer, pooled to be reused by PackMsg
+type msgpackEncoder struct {
+	buffer  bytes.Buffer
+	encoder *msgpack.Encoder
+}
+
+var msgpackEncoderPool = sync.Pool{
+	New: func() interface{} {
+		e := &msgpackEncoder{}
+		e.encoder = msgpack.NewEncoder(&e.buffer)
+		return e
+	},
+}
+
+// PackMsg packs message to bytes in MessagePack format, and appends them to buf
 func (mp MessagePackMsgPacker) PackMsg(msg interface{}, buf []byte) ([]byte, error) {
-	buffer := bytes.NewBuffer(buf)
+	e := msgpackEncoderPool.Get().(*msgpackEncoder)
+	defer func() {
+		if e.buffer.Cap() <= _MAX_POOLED_ENCODER_BUFFER_SIZE {
+			e.buffer.Reset()
+			msgpackEncoderPool.Put(e)
+		}
+	}()
 
-	encoder := msgpack.NewEncoder(buffer)
-	err := encoder.Encode(msg)
-	if err != nil {
+	if err := e.encoder.Encode(msg); err != nil {
 		return buf, err
 	}
-	buf = buffer.Bytes()
-	return buf, nil
+	return append(buf, e.buffer.Bytes()...), nil
 }
 
 // UnpackMsg unpacksbytes in MessagePack format to message
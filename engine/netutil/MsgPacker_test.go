@@ -103,3 +103,32 @@ func BenchmarkMessagePackMsgPacker_PackMsg_Array_OneByOne(b *testing.B) {
 		}
 	}
 }
+
+func TestMessagePackMsgPacker_PackMsgAppend(t *testing.T) {
+	packer := MessagePackMsgPacker{}
+	data, err := packer.PackMsg("abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := packer.PackMsg("abc", []byte("prefix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "prefix"+string(data) {
+		t.Errorf("PackMsg should append to buf: %q", buf)
+	}
+}
+
+func BenchmarkPacket_AppendData(b *testing.B) {
+	msg := map[string]interface{}{
+		"name":  "abc",
+		"level": 60,
+		"list":  []interface{}{1, 2, 3, "abc", "def"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet := NewPacket()
+		packet.AppendData(msg)
+		packet.Release()
+	}
+}
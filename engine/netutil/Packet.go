@@ -12,11 +12,14 @@ import (
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 const (
 	_MIN_PAYLOAD_CAP = 128
 	_CAP_GROW_SHIFT  = uint(2)
+
+	_MAX_POOLED_DATA_BUFFER_SIZE = 64 * 1024 // larger data buffers are not pooled to avoid holding too much memory
 )
 
 var (
@@ -45,6 +48,12 @@ var (
 			return p
 		},
 	}
+	dataBufferPool = sync.Pool{ // buffers for packing data in AppendData
+		New: func() interface{} {
+			buf := make([]byte, 0, _MIN_PAYLOAD_CAP)
+			return &buf
+		},
+	}
 )
 
 func init() {
@@ -86,6 +95,7 @@ type Packet struct {
 	refcount     int64
 	bytes        []byte
 	initialBytes [_PREPAYLOAD_SIZE + _MIN_PAYLOAD_CAP]byte
+	traceContext tracing.SpanContext // span context received with the packet
 }
 
 func allocPacket() *Packet {
@@ -191,6 +201,7 @@ func (p *Packet) Release() {
 
 		p.readCursor = 0
 		p.SetPayloadLen(0)
+		p.traceContext = tracing.SpanContext{}
 		packetPool.Put(p)
 
 		if consts.DEBUG_PACKET_ALLOC {
@@ -201,6 +212,16 @@ func (p *Packet) Release() {
 	}
 }
 
+// TraceContext returns the span context received with the packet, which is not valid if the packet is not traced
+func (p *Packet) TraceContext() tracing.SpanContext {
+	return p.traceContext
+}
+
+// SetTraceContext sets the span context received with the packet
+func (p *Packet) SetTraceContext(sc tracing.SpanContext) {
+	p.traceContext = sc
+}
+
 // ClearPayload clears packet payload
 func (p *Packet) ClearPayload() {
 	p.readCursor = 0
@@ -418,12 +439,17 @@ func (p *Packet) ReadMapStringString() map[string]string {
 
 // AppendData appends one data of any type to the end of payload
 func (p *Packet) AppendData(msg interface{}) {
-	dataBytes, err := MSG_PACKER.PackMsg(msg, nil)
+	buf := dataBufferPool.Get().(*[]byte)
+	dataBytes, err := MSG_PACKER.PackMsg(msg, (*buf)[:0])
 	if err != nil {
 		gwlog.Panic(err)
 	}
 
 	p.AppendVarBytes(dataBytes)
+	if cap(dataBytes) <= _MAX_POOLED_DATA_BUFFER_SIZE {
+		*buf = dataBytes
+		dataBufferPool.Put(buf)
+	}
 }
 
 // ReadData reads one data of any type from the beginning of unread payload
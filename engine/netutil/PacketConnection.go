@@ -16,6 +16,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/opmon"
 )
 
@@ -30,6 +31,13 @@ var (
 	// NETWORK_ENDIAN is the network Endian of connections
 	NETWORK_ENDIAN = binary.LittleEndian
 	errRecvAgain   = _ErrRecvAgain{}
+
+	packetsSent     = metrics.NewCounter("goworld_packets_sent_total", "Packets sent by packet connections")
+	bytesSent       = metrics.NewCounter("goworld_packet_bytes_sent_total", "Bytes of packets sent by packet connections")
+	packetsReceived = metrics.NewCounter("goworld_packets_received_total", "Packets received by packet connections")
+	bytesReceived   = metrics.NewCounter("goworld_packet_bytes_received_total", "Bytes of packets received by packet connections")
+	writeTimeouts   = metrics.NewCounter("goworld_packet_write_timeouts_total", "Writes failed for write timeouts of connections")
+	stuckClosed     = metrics.NewCounter("goworld_packet_stuck_connections_closed_total", "Packet connections closed for sent packets not written in time")
 )
 
 type _ErrRecvAgain struct{}
@@ -46,11 +54,18 @@ func (err _ErrRecvAgain) Timeout() bool {
 	return true
 }
 
+// packetWriter is implemented by connections handling each packet written, e.g. CompressConn
+type packetWriter interface {
+	WritePacket(data []byte) error
+}
+
 // PacketConnection is a connection that send and receive data packets upon a network stream connection
 type PacketConnection struct {
 	conn               Connection
 	pendingPackets     []*Packet
+	pendingBytes       int
 	pendingPacketsLock sync.Mutex
+	unflushedSince     time.Time // since when sent packets are not fully written, zero if all written
 
 	// buffers and infos for receiving a packet
 	payloadLenBuf         [_SIZE_FIELD_SIZE]byte
@@ -89,10 +104,30 @@ func (pc *PacketConnection) SendPacket(packet *Packet) error {
 	packet.AddRefCount(1)
 	pc.pendingPacketsLock.Lock()
 	pc.pendingPackets = append(pc.pendingPackets, packet)
+	pc.pendingBytes += _PREPAYLOAD_SIZE + int(packet.GetPayloadLen())
+	if pc.unflushedSince.IsZero() {
+		pc.unflushedSince = time.Now()
+	}
 	pc.pendingPacketsLock.Unlock()
 	return nil
 }
 
+// PendingPacketCount returns the number of packets waiting to be flushed
+func (pc *PacketConnection) PendingPacketCount() int {
+	pc.pendingPacketsLock.Lock()
+	n := len(pc.pendingPackets)
+	pc.pendingPacketsLock.Unlock()
+	return n
+}
+
+// PendingBytes returns the number of bytes of packets waiting to be flushed
+func (pc *PacketConnection) PendingBytes() int {
+	pc.pendingPacketsLock.Lock()
+	n := pc.pendingBytes
+	pc.pendingPacketsLock.Unlock()
+	return n
+}
+
 // Flush connection writes
 func (pc *PacketConnection) Flush(reason string) (err error) {
 	pc.pendingPacketsLock.Lock()
@@ -102,28 +137,27 @@ func (pc *PacketConnection) Flush(reason string) (err error) {
 	}
 	packets := make([]*Packet, 0, len(pc.pendingPackets))
 	packets, pc.pendingPackets = pc.pendingPackets, packets
+	pc.pendingBytes = 0
 	pc.pendingPacketsLock.Unlock()
 
 	// flush should only be called in one goroutine
 	op := opmon.StartOperation("FlushPackets-" + reason)
 	defer op.Finish(time.Millisecond * 300)
 
-	//var cw *flate.Writer
-
-	if len(packets) == 1 {
-		// only 1 packet to send, just send it directly, no need to use send buffer
-		packet := packets[0]
-
-		err = gwioutil.WriteAll(pc.conn, packet.data())
-		packet.Release()
-		if err == nil {
-			err = pc.conn.Flush()
-		}
-		return
+	packetsSent.Add(float64(len(packets)))
+	for _, packet := range packets {
+		bytesSent.Add(float64(_PREPAYLOAD_SIZE + packet.GetPayloadLen()))
 	}
 
+	pw, isPacketWriter := pc.conn.(packetWriter)
 	for _, packet := range packets {
-		gwioutil.WriteAll(pc.conn, packet.data())
+		if err == nil {
+			if isPacketWriter {
+				err = pw.WritePacket(packet.data())
+			} else {
+				err = gwioutil.WriteAll(pc.conn, packet.data())
+			}
+		}
 		packet.Release()
 	}
 
@@ -131,9 +165,52 @@ func (pc *PacketConnection) Flush(reason string) (err error) {
 	if err == nil {
 		err = pc.conn.Flush()
 	}
+	pc.onFlushed(err)
 	return
 }
 
+func (pc *PacketConnection) onFlushed(err error) {
+	if err == nil {
+		pc.pendingPacketsLock.Lock()
+		if len(pc.pendingPackets) == 0 {
+			pc.unflushedSince = time.Time{}
+		} else {
+			pc.unflushedSince = time.Now() // packets sent during flushing are not written yet
+		}
+		pc.pendingPacketsLock.Unlock()
+	} else if errors.Cause(err) == ErrWriteTimeout {
+		// the connection is not usable after partial writes, so close it
+		gwlog.Warnf("%s: write timeout, closing", pc)
+		pc.Close()
+	}
+}
+
+// UnflushedDuration returns how long sent packets have been waiting to be written, 0 if all packets are written
+func (pc *PacketConnection) UnflushedDuration() time.Duration {
+	pc.pendingPacketsLock.Lock()
+	since := pc.unflushedSince
+	pc.pendingPacketsLock.Unlock()
+	if since.IsZero() {
+		return 0
+	}
+	return time.Since(since)
+}
+
+// CloseIfStuck closes the connection if sent packets are not written within the timeout, returns if it is closed
+//
+// Packets are not written if the remote stops reading (e.g. a zero window TCP socket), so pending packets pile up.
+func (pc *PacketConnection) CloseIfStuck(timeout time.Duration) bool {
+	d := pc.UnflushedDuration()
+	if d <= timeout {
+		return false
+	}
+
+	gwlog.Warnf("%s: sent packets are not written for %s, closing", pc, d)
+	stuckClosed.Inc()
+	pc.Close()
+	return true
+}
+
 // SetRecvDeadline sets the receive deadline
 func (pc *PacketConnection) SetRecvDeadline(deadline time.Time) error {
 	return pc.conn.SetReadDeadline(deadline)
@@ -175,6 +252,8 @@ func (pc *PacketConnection) RecvPacket() (*Packet, error) {
 		packet := pc.recvingPacket
 		packet.SetPayloadLen(pc.recvTotalPayloadLen)
 		pc.resetRecvStates()
+		packetsReceived.Inc()
+		bytesReceived.Add(float64(_PREPAYLOAD_SIZE + packet.GetPayloadLen()))
 
 		return packet, nil
 	}
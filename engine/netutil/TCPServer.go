@@ -20,27 +20,49 @@ type TCPServerDelegate interface {
 
 // ServeTCPForever serves on specified address as TCP server, for ever ...
 func ServeTCPForever(listenAddr string, delegate TCPServerDelegate) {
+	serveTCPForever(listenAddr, delegate, listenTCP)
+}
+
+// ServeTCPReusePortForever serves on specified address as TCP server with multiple accept loops, for ever ...
+//
+// Each accept loop has its own listener bound with SO_REUSEPORT, so that connections are accepted concurrently.
+func ServeTCPReusePortForever(listenAddr string, acceptors int, delegate TCPServerDelegate) {
+	for i := 1; i < acceptors; i++ {
+		go serveTCPForever(listenAddr, delegate, ListenTCPReusePort)
+	}
+	serveTCPForever(listenAddr, delegate, ListenTCPReusePort)
+}
+
+func serveTCPForever(listenAddr string, delegate TCPServerDelegate, listen TransportListenFunc) {
 	for {
-		err := serveTCPForeverOnce(listenAddr, delegate)
+		err := serveTCPForeverOnce(listenAddr, delegate, listen)
 		gwlog.Errorf("server@%s failed with error: %v, will restart after %s", listenAddr, err, _RESTART_TCP_SERVER_INTERVAL)
 		time.Sleep(_RESTART_TCP_SERVER_INTERVAL)
 	}
 }
 
-func serveTCPForeverOnce(listenAddr string, delegate TCPServerDelegate) error {
+func serveTCPForeverOnce(listenAddr string, delegate TCPServerDelegate, listen TransportListenFunc) error {
 	defer func() {
 		if err := recover(); err != nil {
 			gwlog.TraceError("serveTCPImpl: paniced with error %s", err)
 		}
 	}()
 
-	return ServeTCP(listenAddr, delegate)
+	return serveTCP(listenAddr, delegate, listen)
 
 }
 
 // ServeTCP serves on specified address as TCP server
 func ServeTCP(listenAddr string, delegate TCPServerDelegate) error {
-	ln, err := net.Listen("tcp", listenAddr)
+	return serveTCP(listenAddr, delegate, listenTCP)
+}
+
+func listenTCP(listenAddr string) (net.Listener, error) {
+	return net.Listen("tcp", listenAddr)
+}
+
+func serveTCP(listenAddr string, delegate TCPServerDelegate, listen TransportListenFunc) error {
+	ln, err := listen(listenAddr)
 	gwlog.Infof("Listening on TCP: %s ...", listenAddr)
 
 	if err != nil {
@@ -0,0 +1,35 @@
+package netutil
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrWriteTimeout is returned by connections created by NewWriteTimeoutConn if a write does not finish in time
+//
+// It is neither temporary nor timeout error, so that writes are not retried on connections which stop reading.
+var ErrWriteTimeout = errors.New("write timeout")
+
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// NewWriteTimeoutConn creates a connection which fails writes by ErrWriteTimeout if they can not finish within timeout
+func NewWriteTimeoutConn(conn net.Conn, timeout time.Duration) net.Conn {
+	return writeTimeoutConn{Conn: conn, timeout: timeout}
+}
+
+func (wtc writeTimeoutConn) Write(b []byte) (n int, err error) {
+	if err = wtc.Conn.SetWriteDeadline(time.Now().Add(wtc.timeout)); err != nil {
+		return
+	}
+	n, err = wtc.Conn.Write(b)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		writeTimeouts.Inc()
+		err = ErrWriteTimeout
+	}
+	return
+}
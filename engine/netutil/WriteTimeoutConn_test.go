@@ -0,0 +1,81 @@
+package netutil
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func sendTestPacket(pc *PacketConnection) {
+	packet := pc.NewPacket()
+	packet.AppendUint16(1)
+	pc.SendPacket(packet)
+	packet.Release()
+}
+
+func TestWriteTimeoutConn(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	pc := NewPacketConnection(NetConn{NewWriteTimeoutConn(conn, time.Millisecond*50)})
+
+	sendTestPacket(pc) // the peer never reads
+	if err := pc.Flush("test"); errors.Cause(err) != ErrWriteTimeout {
+		t.Fatalf("flush should time out, but got %v", err)
+	}
+	if _, err := conn.Write([]byte{0}); err == nil {
+		t.Fatalf("connection should be closed after write timeout")
+	}
+}
+
+func TestCloseIfStuck(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	pc := NewPacketConnection(NetConn{conn})
+
+	if pc.UnflushedDuration() != 0 {
+		t.Fatalf("new connection should have no unflushed packets")
+	}
+	sendTestPacket(pc)
+	time.Sleep(time.Millisecond * 10)
+	if pc.CloseIfStuck(time.Hour) {
+		t.Fatalf("connection should not be stuck yet")
+	}
+
+	go io.Copy(ioutil.Discard, peer)
+	if err := pc.Flush("test"); err != nil {
+		t.Fatal(err)
+	}
+	if pc.UnflushedDuration() != 0 {
+		t.Fatalf("all packets should be written")
+	}
+
+	sendTestPacket(pc) // not flushed, as if the last flush were blocked
+	time.Sleep(time.Millisecond * 10)
+	if !pc.CloseIfStuck(time.Millisecond) {
+		t.Fatalf("connection should be closed for stuck")
+	}
+}
+
+func TestPendingBytes(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	pc := NewPacketConnection(NetConn{conn})
+
+	sendTestPacket(pc)
+	sendTestPacket(pc)
+	if n := pc.PendingBytes(); n != 2*(_PREPAYLOAD_SIZE+2) {
+		t.Fatalf("wrong pending bytes: %d", n)
+	}
+
+	go io.Copy(ioutil.Discard, peer)
+	if err := pc.Flush("test"); err != nil {
+		t.Fatal(err)
+	}
+	if n := pc.PendingBytes(); n != 0 {
+		t.Fatalf("pending bytes should be 0 after flushed, but is %d", n)
+	}
+}
@@ -0,0 +1,161 @@
+package netutil
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Connection signals
+//
+// Gates record behavioral signals of client connections (handshake timing, packet cadence and input rates) and pass
+// them to the connection scorer enabled by connection_scorer=<name> in gate config. Verdicts of the scorer can flag,
+// rate limit or kick the client, so that anti-cheat pipelines can use network-layer inputs to detect bots.
+
+// verdict actions of connection scorers
+const (
+	ConnectionAllow     = ""           // nothing to do
+	ConnectionFlag      = "flag"       // the client is flagged as suspicious, shown by GET /admin/clients of the gate
+	ConnectionRateLimit = "rate_limit" // packets of the client are throttled to RateLimit per second
+	ConnectionKick      = "kick"       // the client is disconnected
+)
+
+const (
+	_CADENCE_BUCKET_WIDTH = time.Millisecond * 10
+	_CADENCE_BUCKETS      = 200 // intervals longer than 2 seconds are in the last bucket
+)
+
+// ConnectionSignals are behavioral signals of a client connection
+type ConnectionSignals struct {
+	ClientID          common.ClientID
+	RemoteAddr        string
+	ConnectedTime     time.Time
+	Duration          time.Duration // connected duration
+	HandshakeDelay    time.Duration // from connected to the first packet, 0 if no packet is received
+	ProtocolAnnounced bool          // the first packet announces the client protocol version, as official clients do
+	Packets           int64
+	Bytes             int64
+	CadenceEntropy    float64 // entropy in bits of intervals between packets (in 10ms buckets), low entropy means machine-like timing
+	PeakPacketRate    int     // most packets received in one second
+	PeakInputRate     int     // most inputs (position syncs and RPC calls) received in one second
+}
+
+// ConnectionVerdict is the result of scoring a client connection
+type ConnectionVerdict struct {
+	Action    string // ConnectionAllow, ConnectionFlag, ConnectionRateLimit or ConnectionKick
+	Score     float64
+	Reason    string
+	RateLimit int // packets per second if rate limited
+}
+
+// ConnectionScorer scores the connection by its signals, it is called periodically in the reading goroutine of the
+// connection, so it should be safe for concurrent use and should not block
+type ConnectionScorer func(signals *ConnectionSignals) ConnectionVerdict
+
+var (
+	scorersLock sync.RWMutex
+	scorers     = map[string]ConnectionScorer{}
+)
+
+// RegisterConnectionScorer registers the connection scorer, which is enabled on gates by connection_scorer=<name> in
+// config, scorers in other modules should be registered in init functions
+func RegisterConnectionScorer(name string, scorer ConnectionScorer) {
+	if name == "" || scorer == nil {
+		gwlog.Panicf("RegisterConnectionScorer: invalid scorer %q", name)
+	}
+
+	scorersLock.Lock()
+	defer scorersLock.Unlock()
+	if _, ok := scorers[name]; ok {
+		gwlog.Panicf("RegisterConnectionScorer: scorer %s is already registered", name)
+	}
+	scorers[name] = scorer
+}
+
+// GetConnectionScorer returns the registered connection scorer, or nil if not registered
+func GetConnectionScorer(name string) ConnectionScorer {
+	scorersLock.RLock()
+	defer scorersLock.RUnlock()
+	return scorers[name]
+}
+
+// SignalRecorder records signals of a client connection, it is not safe for concurrent use
+type SignalRecorder struct {
+	signals        ConnectionSignals
+	lastPacketTime time.Time
+	cadence        [_CADENCE_BUCKETS]int
+	intervals      int
+	second         time.Time // start of the current one-second window
+	secondPackets  int
+	secondInputs   int
+}
+
+// NewSignalRecorder creates the signal recorder of the client connected at the time
+func NewSignalRecorder(clientid common.ClientID, remoteAddr string, connectedTime time.Time) *SignalRecorder {
+	return &SignalRecorder{
+		signals: ConnectionSignals{
+			ClientID:      clientid,
+			RemoteAddr:    remoteAddr,
+			ConnectedTime: connectedTime,
+		},
+		second: connectedTime,
+	}
+}
+
+// Record records the packet of the size received at the time, announce is true if the packet announces the client
+// protocol version and input is true if the packet is a position sync or an RPC call
+func (r *SignalRecorder) Record(size int, announce bool, input bool, now time.Time) {
+	s := &r.signals
+	if s.Packets == 0 {
+		s.HandshakeDelay = now.Sub(s.ConnectedTime)
+		s.ProtocolAnnounced = announce
+	} else {
+		bucket := int(now.Sub(r.lastPacketTime) / _CADENCE_BUCKET_WIDTH)
+		if bucket >= _CADENCE_BUCKETS {
+			bucket = _CADENCE_BUCKETS - 1
+		} else if bucket < 0 {
+			bucket = 0
+		}
+		r.cadence[bucket] += 1
+		r.intervals += 1
+	}
+	r.lastPacketTime = now
+	s.Packets += 1
+	s.Bytes += int64(size)
+
+	if now.Sub(r.second) >= time.Second {
+		r.second, r.secondPackets, r.secondInputs = now, 0, 0
+	}
+	r.secondPackets += 1
+	if input {
+		r.secondInputs += 1
+	}
+	if r.secondPackets > s.PeakPacketRate {
+		s.PeakPacketRate = r.secondPackets
+	}
+	if r.secondInputs > s.PeakInputRate {
+		s.PeakInputRate = r.secondInputs
+	}
+}
+
+// Signals returns signals recorded until the time
+func (r *SignalRecorder) Signals(now time.Time) *ConnectionSignals {
+	signals := r.signals
+	signals.Duration = now.Sub(signals.ConnectedTime)
+	signals.CadenceEntropy = r.cadenceEntropy()
+	return &signals
+}
+
+func (r *SignalRecorder) cadenceEntropy() float64 {
+	entropy := 0.0
+	for _, n := range r.cadence {
+		if n > 0 {
+			p := float64(n) / float64(r.intervals)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
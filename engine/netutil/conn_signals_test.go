@@ -0,0 +1,55 @@
+package netutil
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSignalRecorder(t *testing.T) {
+	start := time.Now()
+	r := NewSignalRecorder("client", "127.0.0.1:1234", start)
+	now := start.Add(time.Millisecond * 300)
+	for i := 0; i < 100; i++ {
+		r.Record(10, i == 0, i%2 == 0, now)
+		now = now.Add(time.Millisecond * 50)
+	}
+	signals := r.Signals(now)
+	if signals.HandshakeDelay != time.Millisecond*300 || !signals.ProtocolAnnounced {
+		t.Errorf("wrong handshake signals: %+v", signals)
+	}
+	if signals.Packets != 100 || signals.Bytes != 1000 || signals.Duration != now.Sub(start) {
+		t.Errorf("wrong packet signals: %+v", signals)
+	}
+	if signals.CadenceEntropy != 0 {
+		t.Errorf("regular cadence should have no entropy: %v", signals.CadenceEntropy)
+	}
+	if signals.PeakPacketRate != 20 || signals.PeakInputRate != 10 {
+		t.Errorf("wrong peak rates: %d %d", signals.PeakPacketRate, signals.PeakInputRate)
+	}
+
+	r = NewSignalRecorder("client", "127.0.0.1:1234", start)
+	now = start
+	for i := 0; i < 100; i++ {
+		r.Record(10, false, false, now)
+		now = now.Add(time.Millisecond * time.Duration(20+rand.Intn(200)))
+	}
+	if signals := r.Signals(now); signals.CadenceEntropy < 3 || signals.ProtocolAnnounced {
+		t.Errorf("irregular cadence should have high entropy: %+v", signals)
+	}
+}
+
+func TestRegisterConnectionScorer(t *testing.T) {
+	if GetConnectionScorer("test_scorer") != nil {
+		t.Fatalf("scorer should not be registered")
+	}
+	RegisterConnectionScorer("test_scorer", func(signals *ConnectionSignals) ConnectionVerdict {
+		if signals.PeakInputRate > 100 {
+			return ConnectionVerdict{Action: ConnectionKick, Reason: "impossible input rate"}
+		}
+		return ConnectionVerdict{}
+	})
+	if verdict := GetConnectionScorer("test_scorer")(&ConnectionSignals{PeakInputRate: 200}); verdict.Action != ConnectionKick {
+		t.Errorf("wrong verdict: %+v", verdict)
+	}
+}
@@ -3,6 +3,7 @@ package netutil
 import (
 	"io"
 	"net"
+	"time"
 
 	"unsafe"
 
@@ -38,6 +39,44 @@ func ConnectTCP(addr string) (net.Conn, error) {
 	return conn, err
 }
 
+// TCPOptions are options of TCP connections
+type TCPOptions struct {
+	NoDelay    bool
+	SendBuffer int           // SO_SNDBUF, 0 for the OS default
+	RecvBuffer int           // SO_RCVBUF, 0 for the OS default
+	KeepAlive  time.Duration // keepalive interval, 0 for the default, negative for disabled
+}
+
+// Apply sets the options to the TCP connection
+func (opts TCPOptions) Apply(conn *net.TCPConn) error {
+	if err := conn.SetNoDelay(opts.NoDelay); err != nil {
+		return errors.Wrap(err, "set nodelay failed")
+	}
+	if opts.SendBuffer > 0 {
+		if err := conn.SetWriteBuffer(opts.SendBuffer); err != nil {
+			return errors.Wrap(err, "set send buffer failed")
+		}
+	}
+	if opts.RecvBuffer > 0 {
+		if err := conn.SetReadBuffer(opts.RecvBuffer); err != nil {
+			return errors.Wrap(err, "set receive buffer failed")
+		}
+	}
+	if opts.KeepAlive < 0 {
+		if err := conn.SetKeepAlive(false); err != nil {
+			return errors.Wrap(err, "disable keepalive failed")
+		}
+	} else if opts.KeepAlive > 0 {
+		if err := conn.SetKeepAlive(true); err != nil {
+			return errors.Wrap(err, "enable keepalive failed")
+		}
+		if err := conn.SetKeepAlivePeriod(opts.KeepAlive); err != nil {
+			return errors.Wrap(err, "set keepalive period failed")
+		}
+	}
+	return nil
+}
+
 func PutFloat32(b []byte, f float32) {
 	NETWORK_ENDIAN.PutUint32(b, *(*uint32)(unsafe.Pointer(&f)))
 }
@@ -81,3 +81,73 @@ func TestPacketConnection(t *testing.T) {
 	}
 
 }
+
+func TestTCPOptions(t *testing.T) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", PORT))
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+	defer conn.Close()
+
+	for _, opts := range []TCPOptions{
+		{NoDelay: true, SendBuffer: 64 * 1024, RecvBuffer: 64 * 1024, KeepAlive: time.Second * 30},
+		{NoDelay: false, KeepAlive: -1},
+		{},
+	} {
+		if err := opts.Apply(conn.(*net.TCPConn)); err != nil {
+			t.Errorf("apply %+v failed: %s", opts, err)
+		}
+	}
+}
+
+type testAcceptCountServer struct {
+	accepted chan net.Conn
+}
+
+func (ts *testAcceptCountServer) ServeTCPConnection(conn net.Conn) {
+	ts.accepted <- conn
+}
+
+func TestListenTCPReusePort(t *testing.T) {
+	if !ReusePortSupported {
+		if _, err := ListenTCPReusePort("localhost:0"); err == nil {
+			t.Errorf("SO_REUSEPORT should not be supported")
+		}
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+
+	ln1, err := ListenTCPReusePort("localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln1.Close()
+	ln2, err := ListenTCPReusePort(ln1.Addr().String())
+	if err != nil {
+		t.Fatalf("listen on the same address with SO_REUSEPORT failed: %s", err)
+	}
+	ln2.Close()
+
+	if ln, err := net.Listen("tcp", ln1.Addr().String()); err == nil {
+		ln.Close()
+		t.Errorf("listen without SO_REUSEPORT should fail")
+	}
+
+	addr := ln1.Addr().String()
+	ln1.Close()
+	ts := &testAcceptCountServer{accepted: make(chan net.Conn, 10)}
+	go ServeTCPReusePortForever(addr, 2, ts)
+	time.Sleep(time.Millisecond * 200)
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("connect error: %s", err)
+		}
+		defer conn.Close()
+		select {
+		case c := <-ts.accepted:
+			c.Close()
+		case <-time.After(time.Second):
+			t.Fatalf("connection is not accepted")
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReusePortSupported is true if TCP listeners can be bound with SO_REUSEPORT
+const ReusePortSupported = true
+
+// ListenTCPReusePort listens on the TCP address with SO_REUSEPORT, so that multiple listeners can be bound to the same
+// address and connections are balanced among them by the kernel
+func ListenTCPReusePort(listenAddr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", listenAddr)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package netutil
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ReusePortSupported is true if TCP listeners can be bound with SO_REUSEPORT
+const ReusePortSupported = false
+
+// ListenTCPReusePort listens on the TCP address with SO_REUSEPORT, which is only supported on Linux
+func ListenTCPReusePort(listenAddr string) (net.Listener, error) {
+	return nil, errors.Errorf("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,40 @@
+package netutil
+
+import (
+	"net"
+	"sync"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// TransportListenFunc listens on the address for client connections of a custom transport
+type TransportListenFunc func(addr string) (net.Listener, error)
+
+var (
+	transportsLock sync.RWMutex
+	transports     = map[string]TransportListenFunc{}
+)
+
+// RegisterTransport registers the custom client transport, which is enabled on gates by transport_<name>=<addr> in config
+//
+// Transports in other modules should be registered in init functions. Connections accepted by the listener are served
+// the same way as TCP connections.
+func RegisterTransport(name string, listen TransportListenFunc) {
+	if name == "" || listen == nil {
+		gwlog.Panicf("RegisterTransport: invalid transport %q", name)
+	}
+
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	if _, ok := transports[name]; ok {
+		gwlog.Panicf("RegisterTransport: transport %s is already registered", name)
+	}
+	transports[name] = listen
+}
+
+// GetTransport returns the listen function of the registered transport, or nil if not registered
+func GetTransport(name string) TransportListenFunc {
+	transportsLock.RLock()
+	defer transportsLock.RUnlock()
+	return transports[name]
+}
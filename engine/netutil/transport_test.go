@@ -0,0 +1,26 @@
+package netutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRegisterTransport(t *testing.T) {
+	if GetTransport("test_transport") != nil {
+		t.Fatalf("transport should not be registered")
+	}
+
+	RegisterTransport("test_transport", func(addr string) (net.Listener, error) {
+		return net.Listen("tcp", addr)
+	})
+	listen := GetTransport("test_transport")
+	if listen == nil {
+		t.Fatalf("transport is not registered")
+	}
+
+	ln, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
 )
 
 var (
@@ -21,6 +22,8 @@ var (
 	}
 
 	monitor = newMonitor()
+
+	operationDuration = metrics.NewHistogramVec("goworld_operation_duration_seconds", "Durations of monitored operations, including storage operations", metrics.DefBuckets, "operation")
 )
 
 func init() {
@@ -112,6 +115,7 @@ func StartOperation(operationName string) *Operation {
 func (op *Operation) Finish(warnThreshold time.Duration) {
 	takeTime := time.Now().Sub(op.startTime)
 	monitor.record(op.name, takeTime)
+	operationDuration.With(op.name).Observe(takeTime.Seconds())
 	if takeTime >= warnThreshold {
 		gwlog.Warnf("opmon: operation %s takes %s > %s", op.name, takeTime, warnThreshold)
 	}
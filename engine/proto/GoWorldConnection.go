@@ -2,6 +2,7 @@ package proto
 
 import (
 	"net"
+	"sync/atomic"
 
 	"time"
 
@@ -10,13 +11,17 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 // GoWorldConnection is the network protocol implementation of GoWorld components (dispatcher, gate, game)
 type GoWorldConnection struct {
+	sentBytes    uint64 // accessed atomically, keep it 64-bit aligned
 	packetConn   *netutil.PacketConnection
 	closed       xnsyncutil.AtomicBool
 	autoFlushing bool
+	sendCodec    PacketCodec // codec of sent packets, nil if packets are sent natively
+	recvCodec    PacketCodec // codec of received packets, nil if packets are received natively
 }
 
 // NewGoWorldConnection creates a GoWorldConnection using network connection
@@ -86,6 +91,26 @@ func (gwc *GoWorldConnection) SendNotifyClientDisconnected(id common.ClientID, o
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendNotifyClientResumed sends MT_NOTIFY_CLIENT_RESUMED message
+func (gwc *GoWorldConnection) SendNotifyClientResumed(id common.ClientID, ownerEntityID common.EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_CLIENT_RESUMED)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendClientID(id)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyClientResumedWithSyncVersion sends MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION message
+func (gwc *GoWorldConnection) SendNotifyClientResumedWithSyncVersion(id common.ClientID, ownerEntityID common.EntityID, gameid uint16, syncVersion uint64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendClientID(id)
+	packet.AppendUint16(gameid)
+	packet.AppendUint64(syncVersion)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCreateEntitySomewhere sends MT_CREATE_ENTITY_SOMEWHERE message
 func (gwc *GoWorldConnection) SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName string, data map[string]interface{}) error {
 	packet := gwc.packetConn.NewPacket()
@@ -97,6 +122,18 @@ func (gwc *GoWorldConnection) SendCreateEntitySomewhere(gameid uint16, entityid
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendCreateEntityAnywhereQueued sends MT_CREATE_ENTITY_ANYWHERE_QUEUED message
+func (gwc *GoWorldConnection) SendCreateEntityAnywhereQueued(entityid common.EntityID, typeName string, priority int32, timeoutMS uint32, data map[string]interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CREATE_ENTITY_ANYWHERE_QUEUED)
+	packet.AppendEntityID(entityid)
+	packet.AppendVarStr(typeName)
+	packet.AppendUint32(uint32(priority))
+	packet.AppendUint32(timeoutMS)
+	packet.AppendData(data)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendLoadEntitySomewhere sends MT_LOAD_ENTITY_SOMEWHERE message
 func (gwc *GoWorldConnection) SendLoadEntitySomewhere(typeName string, entityID common.EntityID, gameid uint16) error {
 	packet := gwc.packetConn.NewPacket()
@@ -117,6 +154,43 @@ func (gwc *GoWorldConnection) SendKvregRegister(srvid string, info string, force
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendPublishChannel sends MT_PUBLISH_CHANNEL message
+func (gwc *GoWorldConnection) SendPublishChannel(channel string, toClients bool, method string, args []interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_PUBLISH_CHANNEL)
+	packet.AppendVarStr(channel)
+	packet.AppendBool(toClients)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendClaimCronJob sends MT_CLAIM_CRON_JOB message
+func (gwc *GoWorldConnection) SendClaimCronJob(name string, runTime int64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLAIM_CRON_JOB)
+	packet.AppendVarStr(name)
+	packet.AppendUint64(uint64(runTime))
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendClockSync sends MT_CLOCK_SYNC message with the clock of the sender in nanoseconds
+func (gwc *GoWorldConnection) SendClockSync(sendTime int64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLOCK_SYNC)
+	packet.AppendUint64(uint64(sendTime))
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendClockSyncAck sends MT_CLOCK_SYNC_ACK message with the clock of the dispatcher in nanoseconds
+func (gwc *GoWorldConnection) SendClockSyncAck(sendTime int64, dispatcherTime int64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLOCK_SYNC_ACK)
+	packet.AppendUint64(uint64(sendTime))
+	packet.AppendUint64(uint64(dispatcherTime))
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCallEntityMethod sends MT_CALL_ENTITY_METHOD message
 func (gwc *GoWorldConnection) SendCallEntityMethod(id common.EntityID, method string, args []interface{}) error {
 	packet := gwc.packetConn.NewPacket()
@@ -124,6 +198,50 @@ func (gwc *GoWorldConnection) SendCallEntityMethod(id common.EntityID, method st
 	packet.AppendEntityID(id)
 	packet.AppendVarStr(method)
 	packet.AppendArgs(args)
+	InjectTraceContext(packet, tracing.Current())
+	return gwc.SendPacketRelease(packet)
+}
+
+// MakeCallEntityMethodWithSeqPacket makes MT_CALL_ENTITY_METHOD_WITH_SEQ packet, which is kept by the sender for
+// sending again after reconnection until acked
+func MakeCallEntityMethodWithSeqPacket(id common.EntityID, method string, args []interface{}, src RPCSource, seq uint64, ackFloor uint64) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_SEQ)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	packet.AppendUint16(src.GameID)
+	packet.AppendUint16(src.DispatcherID)
+	packet.AppendUint32(src.Epoch)
+	packet.AppendUint64(seq)
+	packet.AppendUint64(ackFloor)
+	InjectTraceContext(packet, tracing.Current())
+	return packet
+}
+
+// SendAckEntityRPCs sends MT_ACK_ENTITY_RPCS message to ack RPCs applied from the source
+func (gwc *GoWorldConnection) SendAckEntityRPCs(src RPCSource, seqs []uint64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_ACK_ENTITY_RPCS)
+	packet.AppendUint16(src.GameID)
+	packet.AppendUint16(src.DispatcherID)
+	packet.AppendUint32(uint32(len(seqs)))
+	for _, seq := range seqs {
+		packet.AppendUint64(seq)
+	}
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendCallEntityMethodWithResult sends MT_CALL_ENTITY_METHOD_WITH_RESULT message
+func (gwc *GoWorldConnection) SendCallEntityMethodWithResult(id common.EntityID, method string, args []interface{}, srcGameID uint16, callID uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_RESULT)
+	packet.AppendEntityID(id)
+	packet.AppendUint16(srcGameID)
+	packet.AppendUint32(callID)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	InjectTraceContext(packet, tracing.Current())
 	return gwc.SendPacketRelease(packet)
 }
 
@@ -137,6 +255,17 @@ func (gwc *GoWorldConnection) SendCallEntityMethodFromClient(id common.EntityID,
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendCallEntityMethodWithSeqFromClient sends MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendCallEntityMethodWithSeqFromClient(id common.EntityID, seq uint32, method string, args []interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT)
+	packet.AppendEntityID(id)
+	packet.AppendUint32(seq)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCreateEntityOnClient sends MT_CREATE_ENTITY_ON_CLIENT message
 func (gwc *GoWorldConnection) SendCreateEntityOnClient(gameid uint16, clientid common.ClientID, typeName string, entityid common.EntityID,
 	isPlayer bool, clientData map[string]interface{}, x, y, z float32, yaw float32) error {
@@ -167,6 +296,19 @@ func (gwc *GoWorldConnection) SendSyncPositionYawFromClient(entityID common.Enti
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendSyncPositionYawWithSeqFromClient sends MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendSyncPositionYawWithSeqFromClient(entityID common.EntityID, seq uint32, x, y, z float32, yaw float32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT)
+	packet.AppendEntityID(entityID)
+	packet.AppendUint32(seq)
+	packet.AppendFloat32(x)
+	packet.AppendFloat32(y)
+	packet.AppendFloat32(z)
+	packet.AppendFloat32(yaw)
+	return gwc.SendPacketRelease(packet)
+}
+
 //func (gwc *GoWorldConnection) SendSetClientClientID(clientid common.ClientID) error {
 //	packet := gwc.packetConn.NewPacket()
 //	packet.AppendUint16(MT_SET_CLIENT_CLIENTID)
@@ -181,6 +323,117 @@ func (gwc *GoWorldConnection) SetHeartbeatFromClient() error {
 
 }
 
+// SendReconnectDirective sends MT_RECONNECT_DIRECTIVE message
+func (gwc *GoWorldConnection) SendReconnectDirective(delay time.Duration) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RECONNECT_DIRECTIVE)
+	packet.AppendUint32(uint32(delay / time.Millisecond))
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSessionTokenChallenge sends MT_SESSION_TOKEN_CHALLENGE message
+func (gwc *GoWorldConnection) SendSessionTokenChallenge(challenge string, expireIn time.Duration) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SESSION_TOKEN_CHALLENGE)
+	packet.AppendVarStr(challenge)
+	packet.AppendUint32(uint32(expireIn / time.Second))
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSessionTokenResponseFromClient sends MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendSessionTokenResponseFromClient(token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT)
+	packet.AppendVarStr(token)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendResumeSessionFromClient sends MT_RESUME_SESSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendResumeSessionFromClient(token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_SESSION_FROM_CLIENT)
+	packet.AppendVarStr(token)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendBindClientChannelFromClient sends MT_BIND_CLIENT_CHANNEL_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendBindClientChannelFromClient(token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_BIND_CLIENT_CHANNEL_FROM_CLIENT)
+	packet.AppendVarStr(token)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientProtocolVersionFromClient sends MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendSetClientProtocolVersionFromClient(version uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT)
+	packet.AppendUint16(version)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendCallEntityMethodWithTypedArgsFromClient sends MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendCallEntityMethodWithTypedArgsFromClient(id common.EntityID, method string, typedArgs []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(method)
+	packet.AppendVarBytes(typedArgs)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientCodecFromClient sends MT_SET_CLIENT_CODEC_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendSetClientCodecFromClient(codecs []string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_CODEC_FROM_CLIENT)
+	packet.AppendStringList(codecs)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientCodec sends MT_SET_CLIENT_CODEC message
+func (gwc *GoWorldConnection) SendSetClientCodec(codec string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_CODEC)
+	packet.AppendVarStr(codec)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientProtocolVersion sends MT_SET_CLIENT_PROTOCOL_VERSION message
+func (gwc *GoWorldConnection) SendSetClientProtocolVersion(version uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_PROTOCOL_VERSION)
+	packet.AppendUint16(version)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendClientProtocolVersionRejected sends MT_CLIENT_PROTOCOL_VERSION_REJECTED message with versions supported by the gate
+// and the URL for updating the client
+func (gwc *GoWorldConnection) SendClientProtocolVersionRejected(clientVersion, minVersion, maxVersion uint16, updateURL string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLIENT_PROTOCOL_VERSION_REJECTED)
+	packet.AppendUint16(clientVersion)
+	packet.AppendUint16(minVersion)
+	packet.AppendUint16(maxVersion)
+	packet.AppendVarStr(updateURL)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendResumeSessionAck sends MT_RESUME_SESSION_ACK message
+func (gwc *GoWorldConnection) SendResumeSessionAck(ok bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_SESSION_ACK)
+	packet.AppendBool(ok)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendBindClientChannelAck sends MT_BIND_CLIENT_CHANNEL_ACK message
+func (gwc *GoWorldConnection) SendBindClientChannelAck(ok bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_BIND_CLIENT_CHANNEL_ACK)
+	packet.AppendBool(ok)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendDestroyEntityOnClient sends MT_DESTROY_ENTITY_ON_CLIENT message
 func (gwc *GoWorldConnection) SendDestroyEntityOnClient(gateid uint16, clientid common.ClientID, typeName string, entityid common.EntityID) error {
 	packet := gwc.packetConn.NewPacket()
@@ -264,6 +517,17 @@ func (gwc *GoWorldConnection) SendNotifyListAttrAppendOnClient(gateid uint16, cl
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendNotifyAttrPatchOnClient sends MT_NOTIFY_ATTR_PATCH_ON_CLIENT message with the encoded AttrPatch
+func (gwc *GoWorldConnection) SendNotifyAttrPatchOnClient(gateid uint16, clientid common.ClientID, entityid common.EntityID, patch []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_ATTR_PATCH_ON_CLIENT)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendEntityID(entityid)
+	packet.AppendBytes(patch)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCallEntityMethodOnClient sends MT_CALL_ENTITY_METHOD_ON_CLIENT message
 func (gwc *GoWorldConnection) SendCallEntityMethodOnClient(gateid uint16, clientid common.ClientID, entityID common.EntityID, method string, args []interface{}) (err error) {
 	packet := gwc.packetConn.NewPacket()
@@ -276,6 +540,19 @@ func (gwc *GoWorldConnection) SendCallEntityMethodOnClient(gateid uint16, client
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendCallEntityMethodOnClientWithDelivery sends MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY message
+func (gwc *GoWorldConnection) SendCallEntityMethodOnClientWithDelivery(gateid uint16, clientid common.ClientID, delivery DeliveryClass, entityID common.EntityID, method string, args []interface{}) (err error) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendByte(byte(delivery))
+	packet.AppendEntityID(entityID)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendSetClientFilterProp sends MT_SET_CLIENTPROXY_FILTER_PROP message
 func (gwc *GoWorldConnection) SendSetClientFilterProp(gateid uint16, clientid common.ClientID, key, val string) (err error) {
 	packet := gwc.packetConn.NewPacket()
@@ -287,6 +564,17 @@ func (gwc *GoWorldConnection) SendSetClientFilterProp(gateid uint16, clientid co
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendSetClientSyncVersion sends MT_SET_CLIENT_SYNC_VERSION message
+func (gwc *GoWorldConnection) SendSetClientSyncVersion(gateid uint16, clientid common.ClientID, gameid uint16, syncVersion uint64) (err error) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_SYNC_VERSION)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendUint16(gameid)
+	packet.AppendUint64(syncVersion)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendClearClientFilterProp sends MT_CLEAR_CLIENTPROXY_FILTER_PROPS message
 func (gwc *GoWorldConnection) SendClearClientFilterProp(gateid uint16, clientid common.ClientID) (err error) {
 	packet := gwc.packetConn.NewPacket()
@@ -375,6 +663,15 @@ func AllocStartFreezeGamePacket() *netutil.Packet {
 	return packet
 }
 
+func AllocLoadEntitySomewherePacket(typeName string, entityID common.EntityID, gameid uint16) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_LOAD_ENTITY_SOMEWHERE)
+	packet.AppendUint16(gameid)
+	packet.AppendEntityID(entityID)
+	packet.AppendVarStr(typeName)
+	return packet
+}
+
 func MakeNotifyGameConnectedPacket(gameid uint16) *netutil.Packet {
 	pkt := netutil.NewPacket()
 	pkt.AppendUint16(MT_NOTIFY_GAME_CONNECTED)
@@ -389,6 +686,31 @@ func MakeNotifyGameDisconnectedPacket(gameid uint16) *netutil.Packet {
 	return pkt
 }
 
+// MakeCreateEntityRejectedPacket makes a MT_CREATE_ENTITY_REJECTED packet
+func MakeCreateEntityRejectedPacket(entityid common.EntityID, reason string) *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(MT_CREATE_ENTITY_REJECTED)
+	pkt.AppendEntityID(entityid)
+	pkt.AppendVarStr(reason)
+	return pkt
+}
+
+// MakeAutoMigrateEntitiesPacket makes a MT_AUTO_MIGRATE_ENTITIES packet
+func MakeAutoMigrateEntitiesPacket(targetGameID uint16, count int) *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(MT_AUTO_MIGRATE_ENTITIES)
+	pkt.AppendUint16(targetGameID)
+	pkt.AppendUint32(uint32(count))
+	return pkt
+}
+
+// MakeSetGameDrainingPacket makes a MT_SET_GAME_DRAINING packet
+func MakeSetGameDrainingPacket() *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(MT_SET_GAME_DRAINING)
+	return pkt
+}
+
 func MakeNotifyDeploymentReadyPacket() *netutil.Packet {
 	pkt := netutil.NewPacket()
 	pkt.AppendUint16(MT_NOTIFY_DEPLOYMENT_READY)
@@ -416,18 +738,130 @@ func (gwc *GoWorldConnection) SendSetGameIDAck(dispid uint16, isDeploymentReady
 	return gwc.SendPacketRelease(pkt)
 }
 
+// SendSetStandby sends MT_SET_STANDBY message
+func (gwc *GoWorldConnection) SendSetStandby(dispid uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_STANDBY)
+	packet.AppendUint16(dispid)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSyncRoutingToStandby sends MT_SYNC_ROUTING_TO_STANDBY message
+//
+// gameids are all known games, kvregs are changed kvreg registrations and
+// entities are changed entity locations where gameid 0 means the entity is gone.
+func (gwc *GoWorldConnection) SendSyncRoutingToStandby(gameids []uint16, kvregs map[string]string, entities map[common.EntityID]uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SYNC_ROUTING_TO_STANDBY)
+	packet.AppendUint16(uint16(len(gameids)))
+	for _, gameid := range gameids {
+		packet.AppendUint16(gameid)
+	}
+	packet.AppendMapStringString(kvregs)
+	packet.AppendUint32(uint32(len(entities)))
+	for eid, gameid := range entities {
+		packet.AppendEntityID(eid)
+		packet.AppendUint16(gameid)
+	}
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendReconcileEntities sends MT_RECONCILE_ENTITIES message
+func (gwc *GoWorldConnection) SendReconcileEntities(eids []common.EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RECONCILE_ENTITIES)
+	packet.AppendUint32(uint32(len(eids)))
+	for _, eid := range eids {
+		packet.AppendEntityID(eid)
+	}
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendDestroyStaleEntities sends MT_DESTROY_STALE_ENTITIES message
+func (gwc *GoWorldConnection) SendDestroyStaleEntities(eids []common.EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_DESTROY_STALE_ENTITIES)
+	packet.AppendUint32(uint32(len(eids)))
+	for _, eid := range eids {
+		packet.AppendEntityID(eid)
+	}
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSyncServiceSnapshot sends MT_SYNC_SERVICE_SNAPSHOT message
+func (gwc *GoWorldConnection) SendSyncServiceSnapshot(standbyGameID uint16, gameid uint16, eid common.EntityID, serviceID string, data map[string]interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SYNC_SERVICE_SNAPSHOT)
+	packet.AppendUint16(standbyGameID)
+	packet.AppendUint16(gameid)
+	packet.AppendEntityID(eid)
+	packet.AppendVarStr(serviceID)
+	packet.AppendData(data)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendPacket send a packet to remote
 func (gwc *GoWorldConnection) SendPacket(packet *netutil.Packet) error {
+	if gwc.sendCodec != nil {
+		encoded, err := gwc.sendCodec.EncodePacket(packet)
+		if err != nil {
+			gwlog.Errorf("%s: encode packet failed: %s", gwc, err)
+			return err
+		}
+		defer encoded.Release()
+		packet = encoded
+	}
+
+	atomic.AddUint64(&gwc.sentBytes, uint64(packet.GetPayloadLen()))
 	return gwc.packetConn.SendPacket(packet)
 }
 
 // SendPacketRelease send a packet to remote and then release the packet
 func (gwc *GoWorldConnection) SendPacketRelease(packet *netutil.Packet) error {
-	err := gwc.packetConn.SendPacket(packet)
+	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
 }
 
+// SetSendCodec sets the codec of packets sent after, it should be called by the goroutine sending packets
+func (gwc *GoWorldConnection) SetSendCodec(codec PacketCodec) {
+	gwc.sendCodec = codec
+}
+
+// SendCodec returns the codec of sent packets, or nil if packets are sent natively
+func (gwc *GoWorldConnection) SendCodec() PacketCodec {
+	return gwc.sendCodec
+}
+
+// SetRecvCodec sets the codec of packets received after, it should be called by the goroutine receiving packets
+func (gwc *GoWorldConnection) SetRecvCodec(codec PacketCodec) {
+	gwc.recvCodec = codec
+}
+
+// PendingPacketCount returns the number of packets waiting to be flushed
+func (gwc *GoWorldConnection) PendingPacketCount() int {
+	return gwc.packetConn.PendingPacketCount()
+}
+
+// PendingBytes returns the number of bytes of packets waiting to be flushed
+func (gwc *GoWorldConnection) PendingBytes() int {
+	return gwc.packetConn.PendingBytes()
+}
+
+// CloseIfStuck closes the connection if sent packets are not written within the timeout, returns if it is closed
+func (gwc *GoWorldConnection) CloseIfStuck(timeout time.Duration) bool {
+	if !gwc.packetConn.CloseIfStuck(timeout) {
+		return false
+	}
+	gwc.closed.Store(true)
+	return true
+}
+
+// SentBytes returns the total payload size of packets sent by the connection
+func (gwc *GoWorldConnection) SentBytes() uint64 {
+	return atomic.LoadUint64(&gwc.sentBytes)
+}
+
 // Flush connection writes
 func (gwc *GoWorldConnection) Flush(reason string) error {
 	return gwc.packetConn.Flush(reason)
@@ -458,6 +892,16 @@ func (gwc *GoWorldConnection) Recv(msgtype *MsgType) (*netutil.Packet, error) {
 		return nil, err
 	}
 
+	if gwc.recvCodec != nil {
+		decoded, err := gwc.recvCodec.DecodePacket(pkt)
+		pkt.Release()
+		if err != nil {
+			return nil, err
+		}
+		pkt = decoded
+	}
+
+	extractTraceContext(pkt)
 	*msgtype = MsgType(pkt.ReadUint16())
 	if consts.DEBUG_PACKETS {
 		gwlog.Infof("%s: Recv msgtype=%v, payload size=%d", gwc, *msgtype, pkt.GetPayloadLen())
@@ -0,0 +1,296 @@
+package proto
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Attribute patches
+//
+// Attribute changes of an entity in one sync tick are coalesced into one MT_NOTIFY_ATTR_PATCH_ON_CLIENT message. The
+// patch is encoded compactly: integers are varints, and floats can be quantized to multiples of the float precision
+// of the patch. Clients announce CLIENT_PROTOCOL_VERSION_ATTR_PATCH by MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT to
+// receive patches, gates expand patches to MT_NOTIFY_*_ATTR_* messages for older clients.
+
+// AttrPatchOpType is the type of operations in attribute patches
+type AttrPatchOpType byte
+
+const (
+	// ATTR_PATCH_MAP_CHANGE sets Key of the MapAttr at Path to Val
+	ATTR_PATCH_MAP_CHANGE AttrPatchOpType = iota
+	// ATTR_PATCH_MAP_DEL deletes Key of the MapAttr at Path
+	ATTR_PATCH_MAP_DEL
+	// ATTR_PATCH_MAP_CLEAR clears the MapAttr at Path
+	ATTR_PATCH_MAP_CLEAR
+	// ATTR_PATCH_LIST_CHANGE sets item Index of the ListAttr at Path to Val
+	ATTR_PATCH_LIST_CHANGE
+	// ATTR_PATCH_LIST_POP pops the last item of the ListAttr at Path
+	ATTR_PATCH_LIST_POP
+	// ATTR_PATCH_LIST_APPEND appends Val to the ListAttr at Path
+	ATTR_PATCH_LIST_APPEND
+)
+
+// value encodings in attribute patches
+const (
+	attrPatchValData           = iota // msgpack encoded data
+	attrPatchValInt                   // zigzag varint
+	attrPatchValStr                   // varint length followed by bytes
+	attrPatchValQuantizedFloat        // zigzag varint of the float divided by the float precision
+)
+
+// path item encodings in attribute patches
+const (
+	attrPatchPathKey   = iota // key of MapAttr
+	attrPatchPathIndex        // index of ListAttr
+)
+
+// AttrPatchOp is an operation in attribute patches
+type AttrPatchOp struct {
+	Op    AttrPatchOpType
+	Path  []interface{} // keys (string) and indexes (int64 when decoded) from the attribute to the root of entity attributes
+	Key   string
+	Index uint32
+	Val   interface{}
+}
+
+// AttrPatch is the attribute changes of an entity
+type AttrPatch struct {
+	FloatPrecision float32 // floats are quantized to multiples of FloatPrecision if it is positive
+	Ops            []AttrPatchOp
+}
+
+// Encode appends the encoded patch to buf
+func (patch *AttrPatch) Encode(buf []byte) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(patch.FloatPrecision))
+	buf = append(buf, b[:]...)
+	buf = appendUvarint(buf, uint64(len(patch.Ops)))
+	for i := range patch.Ops {
+		op := &patch.Ops[i]
+		buf = append(buf, byte(op.Op))
+		buf = patch.appendPath(buf, op.Path)
+		switch op.Op {
+		case ATTR_PATCH_MAP_CHANGE:
+			buf = appendVarStr(buf, op.Key)
+			buf = patch.appendVal(buf, op.Val)
+		case ATTR_PATCH_MAP_DEL:
+			buf = appendVarStr(buf, op.Key)
+		case ATTR_PATCH_LIST_CHANGE:
+			buf = appendUvarint(buf, uint64(op.Index))
+			buf = patch.appendVal(buf, op.Val)
+		case ATTR_PATCH_LIST_APPEND:
+			buf = patch.appendVal(buf, op.Val)
+		}
+	}
+	return buf
+}
+
+func (patch *AttrPatch) appendPath(buf []byte, path []interface{}) []byte {
+	buf = appendUvarint(buf, uint64(len(path)))
+	for _, item := range path {
+		if key, ok := item.(string); ok {
+			buf = append(buf, attrPatchPathKey)
+			buf = appendVarStr(buf, key)
+		} else {
+			index, _ := toInt64(item)
+			buf = append(buf, attrPatchPathIndex)
+			buf = appendUvarint(buf, uint64(index))
+		}
+	}
+	return buf
+}
+
+func (patch *AttrPatch) appendVal(buf []byte, val interface{}) []byte {
+	if v, ok := toInt64(val); ok {
+		buf = append(buf, attrPatchValInt)
+		return appendVarint(buf, v)
+	}
+
+	switch v := val.(type) {
+	case string:
+		buf = append(buf, attrPatchValStr)
+		return appendVarStr(buf, v)
+	case float32:
+		val = float64(v)
+	}
+
+	if v, ok := val.(float64); ok && patch.FloatPrecision > 0 {
+		if q := math.Round(v / float64(patch.FloatPrecision)); math.Abs(q) < 1<<53 {
+			buf = append(buf, attrPatchValQuantizedFloat)
+			return appendVarint(buf, int64(q))
+		}
+	}
+
+	data, err := netutil.MSG_PACKER.PackMsg(val, nil)
+	if err != nil {
+		gwlog.Panic(errors.Wrap(err, "pack attribute value failed"))
+	}
+	buf = append(buf, attrPatchValData)
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// DecodeAttrPatch decodes the attribute patch encoded by AttrPatch.Encode
+func DecodeAttrPatch(data []byte) (patch *AttrPatch, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			patch, err = nil, errors.Errorf("decode attribute patch failed: %v", r)
+		}
+	}()
+
+	d := attrPatchDecoder{data: data}
+	patch = &AttrPatch{FloatPrecision: math.Float32frombits(binary.LittleEndian.Uint32(d.read(4)))}
+	opCount := d.uvarint()
+	if opCount > uint64(len(data)) {
+		return nil, errors.Errorf("decode attribute patch failed: too many operations: %d", opCount)
+	}
+	patch.Ops = make([]AttrPatchOp, opCount)
+	for i := range patch.Ops {
+		op := &patch.Ops[i]
+		op.Op = AttrPatchOpType(d.read(1)[0])
+		op.Path = d.path()
+		switch op.Op {
+		case ATTR_PATCH_MAP_CHANGE:
+			op.Key = d.varStr()
+			op.Val = d.val(patch.FloatPrecision)
+		case ATTR_PATCH_MAP_DEL:
+			op.Key = d.varStr()
+		case ATTR_PATCH_LIST_CHANGE:
+			op.Index = uint32(d.uvarint())
+			op.Val = d.val(patch.FloatPrecision)
+		case ATTR_PATCH_LIST_APPEND:
+			op.Val = d.val(patch.FloatPrecision)
+		case ATTR_PATCH_MAP_CLEAR, ATTR_PATCH_LIST_POP:
+		default:
+			return nil, errors.Errorf("decode attribute patch failed: unknown operation %d", op.Op)
+		}
+	}
+	return patch, nil
+}
+
+// attrPatchDecoder reads attribute patches, and panics if data is malformed
+type attrPatchDecoder struct {
+	data []byte
+}
+
+func (d *attrPatchDecoder) read(n int) []byte {
+	if n < 0 || n > len(d.data) {
+		panic("unexpected end of data")
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *attrPatchDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		panic("invalid uvarint")
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *attrPatchDecoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		panic("invalid varint")
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *attrPatchDecoder) varStr() string {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		panic("unexpected end of data")
+	}
+	return string(d.read(int(n)))
+}
+
+func (d *attrPatchDecoder) path() []interface{} {
+	n := d.uvarint()
+	if n == 0 {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		panic("path too long")
+	}
+	path := make([]interface{}, n)
+	for i := range path {
+		switch d.read(1)[0] {
+		case attrPatchPathKey:
+			path[i] = d.varStr()
+		case attrPatchPathIndex:
+			path[i] = int64(d.uvarint())
+		default:
+			panic("unknown path item")
+		}
+	}
+	return path
+}
+
+func (d *attrPatchDecoder) val(floatPrecision float32) interface{} {
+	switch d.read(1)[0] {
+	case attrPatchValInt:
+		return d.varint()
+	case attrPatchValStr:
+		return d.varStr()
+	case attrPatchValQuantizedFloat:
+		return float64(d.varint()) * float64(floatPrecision)
+	case attrPatchValData:
+		n := d.uvarint()
+		if n > uint64(len(d.data)) {
+			panic("unexpected end of data")
+		}
+		var val interface{}
+		if err := netutil.MSG_PACKER.UnpackMsg(d.read(int(n)), &val); err != nil {
+			panic(err)
+		}
+		return val
+	default:
+		panic("unknown value encoding")
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendVarStr(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,42 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAttrPatchEncoding(t *testing.T) {
+	patch := &AttrPatch{FloatPrecision: 0.5, Ops: []AttrPatchOp{
+		{Op: ATTR_PATCH_MAP_CHANGE, Key: "hp", Val: int64(-100)},
+		{Op: ATTR_PATCH_MAP_CHANGE, Path: []interface{}{"bag"}, Key: "x", Val: 1.2},
+		{Op: ATTR_PATCH_MAP_DEL, Path: []interface{}{"bag"}, Key: "y"},
+		{Op: ATTR_PATCH_MAP_CLEAR, Path: []interface{}{"bag"}},
+		{Op: ATTR_PATCH_LIST_CHANGE, Path: []interface{}{2, "items"}, Index: 3, Val: "str"},
+		{Op: ATTR_PATCH_LIST_POP, Path: []interface{}{"items"}},
+		{Op: ATTR_PATCH_LIST_APPEND, Path: []interface{}{"items"}, Val: map[string]interface{}{"k": true}},
+	}}
+	data := patch.Encode(nil)
+
+	decoded, err := DecodeAttrPatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &AttrPatch{FloatPrecision: 0.5, Ops: []AttrPatchOp{
+		{Op: ATTR_PATCH_MAP_CHANGE, Key: "hp", Val: int64(-100)},
+		{Op: ATTR_PATCH_MAP_CHANGE, Path: []interface{}{"bag"}, Key: "x", Val: 1.0}, // quantized
+		{Op: ATTR_PATCH_MAP_DEL, Path: []interface{}{"bag"}, Key: "y"},
+		{Op: ATTR_PATCH_MAP_CLEAR, Path: []interface{}{"bag"}},
+		{Op: ATTR_PATCH_LIST_CHANGE, Path: []interface{}{int64(2), "items"}, Index: 3, Val: "str"},
+		{Op: ATTR_PATCH_LIST_POP, Path: []interface{}{"items"}},
+		{Op: ATTR_PATCH_LIST_APPEND, Path: []interface{}{"items"}, Val: map[string]interface{}{"k": true}},
+	}}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Fatalf("decoded patch is wrong: %+v", decoded)
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := DecodeAttrPatch(data[:i]); err == nil {
+			t.Fatalf("truncated patch of %d bytes should not be decoded", i)
+		}
+	}
+}
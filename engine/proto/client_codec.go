@@ -0,0 +1,452 @@
+package proto
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Client codecs
+//
+// Messages between clients and gates are encoded natively by default, with dynamic values packed by msgpack. A client
+// can send MT_SET_CLIENT_CODEC_FROM_CLIENT with codecs it supports, then the gate replies MT_SET_CLIENT_CODEC with the
+// codec selected by gate config client_codec, and messages after the reply are encoded by the selected codec in both
+// directions. The client should not send other messages until the reply is received. With the protobuf codec, each
+// packet is the message type (uint16) followed by the protobuf message of the type defined in goworld_client.proto.
+
+const (
+	// CLIENT_CODEC_MSGPACK is the native codec of client messages
+	CLIENT_CODEC_MSGPACK = "msgpack"
+	// CLIENT_CODEC_PROTOBUF encodes client messages as protobuf messages defined in goworld_client.proto
+	CLIENT_CODEC_PROTOBUF = "protobuf"
+)
+
+// PacketCodec converts packets between the native encoding and the encoding used by the remote
+type PacketCodec interface {
+	// EncodePacket encodes the native packet, the encoded packet should be released by the caller
+	EncodePacket(packet *netutil.Packet) (*netutil.Packet, error)
+	// DecodePacket decodes the packet to a native packet, the decoded packet should be released by the caller
+	DecodePacket(packet *netutil.Packet) (*netutil.Packet, error)
+}
+
+// ProtobufClientCodec is the PacketCodec of CLIENT_CODEC_PROTOBUF
+var ProtobufClientCodec PacketCodec = protobufClientCodec{}
+
+// SelectClientCodec returns the preferred codec if it is supported by the client, or CLIENT_CODEC_MSGPACK otherwise
+func SelectClientCodec(preferred string, supported []string) string {
+	for _, codec := range supported {
+		if codec == preferred {
+			return codec
+		}
+	}
+	return CLIENT_CODEC_MSGPACK
+}
+
+// GetClientCodec returns the PacketCodec of the client codec, or nil if messages are encoded natively
+func GetClientCodec(codec string) PacketCodec {
+	if codec == CLIENT_CODEC_PROTOBUF {
+		return ProtobufClientCodec
+	}
+	return nil
+}
+
+type protobufClientCodec struct{}
+
+func (protobufClientCodec) EncodePacket(packet *netutil.Packet) (encoded *netutil.Packet, err error) {
+	payload := packet.Payload()
+	if len(payload) < 2 {
+		return nil, errors.New("protobuf codec: empty packet")
+	}
+	msgtype := MsgType(binary.LittleEndian.Uint16(payload))
+	encode := pbEncoders[msgtype]
+	if encode == nil {
+		return nil, errors.Errorf("protobuf codec: message type %d can not be encoded", msgtype)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			encoded, err = nil, errors.Errorf("protobuf codec: encode message type %d failed: %v", msgtype, r)
+		}
+	}()
+
+	r := &nativeReader{attrPatchDecoder{data: payload[2:]}}
+	if msgtype >= MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		r.read(2 + common.CLIENTID_LENGTH) // gateid & clientid
+	}
+	data := encode(r, nil)
+
+	encoded = netutil.NewPacket()
+	encoded.AppendUint16(uint16(msgtype))
+	encoded.AppendBytes(data)
+	return encoded, nil
+}
+
+func (protobufClientCodec) DecodePacket(packet *netutil.Packet) (*netutil.Packet, error) {
+	payload := packet.Payload()
+	if len(payload) < 2 {
+		return nil, errors.New("protobuf codec: empty packet")
+	}
+	msgtype := MsgType(binary.LittleEndian.Uint16(payload))
+	decode := pbDecoders[msgtype]
+	if decode == nil {
+		return nil, errors.Errorf("protobuf codec: message type %d can not be decoded", msgtype)
+	}
+
+	decoded, err := decode(payload[2:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "protobuf codec: decode message type %d failed", msgtype)
+	}
+	return decoded, nil
+}
+
+// nativeReader reads native payloads of packets, and panics if data is malformed
+type nativeReader struct {
+	attrPatchDecoder
+}
+
+func (r *nativeReader) bool() bool {
+	return r.read(1)[0] != 0
+}
+
+func (r *nativeReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.read(2))
+}
+
+func (r *nativeReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *nativeReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.read(8))
+}
+
+func (r *nativeReader) float32() float32 {
+	return math.Float32frombits(r.uint32())
+}
+
+func (r *nativeReader) varBytes() []byte {
+	return r.read(int(r.uint32()))
+}
+
+func (r *nativeReader) varStr() string {
+	return string(r.varBytes())
+}
+
+func (r *nativeReader) entityID() string {
+	return string(r.read(common.ENTITYID_LENGTH))
+}
+
+func (r *nativeReader) value() interface{} {
+	var v interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(r.varBytes(), &v); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (r *nativeReader) args() []interface{} {
+	args := make([]interface{}, r.uint16())
+	for i := range args {
+		args[i] = r.value()
+	}
+	return args
+}
+
+// pbEncoders append protobuf fields of client-bound messages read from native payloads
+var pbEncoders = map[MsgType]func(r *nativeReader, buf []byte) []byte{
+	MT_CREATE_ENTITY_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendBool(buf, 1, r.bool())
+		buf = pbAppendString(buf, 2, r.entityID())
+		buf = pbAppendString(buf, 3, r.varStr())
+		for field := 4; field <= 7; field++ { // x, y, z, yaw
+			buf = pbAppendFloat(buf, field, r.float32())
+		}
+		return pbAppendValue(buf, 8, r.value())
+	},
+	MT_DESTROY_ENTITY_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.varStr())
+		return pbAppendString(buf, 2, r.entityID())
+	},
+	MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		buf = pbAppendValues(buf, 2, r.value())
+		buf = pbAppendString(buf, 3, r.varStr())
+		return pbAppendValue(buf, 4, r.value())
+	},
+	MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		buf = pbAppendValues(buf, 2, r.value())
+		return pbAppendString(buf, 3, r.varStr())
+	},
+	MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT: pbEncodeAttrPath,
+	MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		buf = pbAppendValues(buf, 2, r.value())
+		buf = pbAppendUint(buf, 3, uint64(r.uint32()))
+		return pbAppendValue(buf, 4, r.value())
+	},
+	MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT: pbEncodeAttrPath,
+	MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		buf = pbAppendValues(buf, 2, r.value())
+		return pbAppendValue(buf, 3, r.value())
+	},
+	MT_NOTIFY_ATTR_PATCH_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		patch, err := DecodeAttrPatch(r.data)
+		if err != nil {
+			panic(err)
+		}
+		for i := range patch.Ops {
+			buf = pbAppendBytes(buf, 2, pbEncodeAttrPatchOp(nil, &patch.Ops[i]))
+		}
+		return buf
+	},
+	MT_CALL_ENTITY_METHOD_ON_CLIENT: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.entityID())
+		buf = pbAppendString(buf, 2, r.varStr())
+		return pbAppendValues(buf, 3, r.args())
+	},
+	MT_CALL_FILTERED_CLIENTS: func(r *nativeReader, buf []byte) []byte {
+		r.read(1)  // op
+		r.varStr() // key
+		r.varStr() // val
+		buf = pbAppendString(buf, 1, r.varStr())
+		return pbAppendValues(buf, 2, r.args())
+	},
+	MT_SYNC_POSITION_YAW_ON_CLIENTS: func(r *nativeReader, buf []byte) []byte {
+		return pbEncodeSyncInfos(r, buf, false)
+	},
+	MT_SYNC_INPUT_ACK_ON_CLIENTS: func(r *nativeReader, buf []byte) []byte {
+		return pbEncodeSyncInfos(r, buf, true)
+	},
+	MT_RECONNECT_DIRECTIVE: func(r *nativeReader, buf []byte) []byte {
+		return pbAppendUint(buf, 1, uint64(r.uint32()))
+	},
+	MT_SESSION_TOKEN_CHALLENGE: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendString(buf, 1, r.varStr())
+		return pbAppendUint(buf, 2, uint64(r.uint32()))
+	},
+	MT_RESUME_SESSION_ACK: func(r *nativeReader, buf []byte) []byte {
+		return pbAppendBool(buf, 1, r.bool())
+	},
+	MT_SET_CLIENT_PROTOCOL_VERSION: func(r *nativeReader, buf []byte) []byte {
+		return pbAppendUint(buf, 1, uint64(r.uint16()))
+	},
+	MT_CLIENT_PROTOCOL_VERSION_REJECTED: func(r *nativeReader, buf []byte) []byte {
+		buf = pbAppendUint(buf, 1, uint64(r.uint16()))
+		buf = pbAppendUint(buf, 2, uint64(r.uint16()))
+		buf = pbAppendUint(buf, 3, uint64(r.uint16()))
+		return pbAppendString(buf, 4, r.varStr())
+	},
+}
+
+func pbEncodeAttrPath(r *nativeReader, buf []byte) []byte {
+	buf = pbAppendString(buf, 1, r.entityID())
+	return pbAppendValues(buf, 2, r.value())
+}
+
+func pbEncodeAttrPatchOp(buf []byte, op *AttrPatchOp) []byte {
+	buf = pbAppendUint(buf, 1, uint64(op.Op))
+	buf = pbAppendValues(buf, 2, op.Path)
+	switch op.Op {
+	case ATTR_PATCH_MAP_CHANGE:
+		buf = pbAppendString(buf, 3, op.Key)
+		buf = pbAppendValue(buf, 5, op.Val)
+	case ATTR_PATCH_MAP_DEL:
+		buf = pbAppendString(buf, 3, op.Key)
+	case ATTR_PATCH_LIST_CHANGE:
+		buf = pbAppendUint(buf, 4, uint64(op.Index))
+		buf = pbAppendValue(buf, 5, op.Val)
+	case ATTR_PATCH_LIST_APPEND:
+		buf = pbAppendValue(buf, 5, op.Val)
+	}
+	return buf
+}
+
+func pbEncodeSyncInfos(r *nativeReader, buf []byte, withSeq bool) []byte {
+	buf = pbAppendUint(buf, 1, uint64(r.uint32())) // server tick
+	buf = pbAppendUint(buf, 2, r.uint64())         // server time
+	for len(r.data) > 0 {
+		info := pbAppendString(nil, 1, r.entityID())
+		if withSeq {
+			info = pbAppendUint(info, 6, uint64(r.uint32()))
+		}
+		for field := 2; field <= 5; field++ { // x, y, z, yaw
+			info = pbAppendFloat(info, field, r.float32())
+		}
+		buf = pbAppendBytes(buf, 3, info)
+	}
+	return buf
+}
+
+// pbDecoders decode protobuf messages from clients to native packets
+var pbDecoders = map[MsgType]func(data []byte) (*netutil.Packet, error){
+	MT_CALL_ENTITY_METHOD_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		var eid, method string
+		var args []interface{}
+		var typedArgs []byte
+		err := pbDecode(data, func(f *pbField) (err error) {
+			switch f.num {
+			case 1:
+				eid = f.string()
+			case 2:
+				method = f.string()
+			case 3:
+				var arg interface{}
+				arg, err = pbDecodeValue(f.b, 0)
+				args = append(args, arg)
+			case 4:
+				typedArgs = f.b
+			}
+			return
+		})
+		entityID, err := pbCheckEntityID(eid, err)
+		if err != nil {
+			return nil, err
+		}
+
+		packet := netutil.NewPacket()
+		if typedArgs != nil {
+			packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT)
+			packet.AppendEntityID(entityID)
+			packet.AppendVarStr(method)
+			packet.AppendVarBytes(typedArgs)
+		} else {
+			packet.AppendUint16(MT_CALL_ENTITY_METHOD_FROM_CLIENT)
+			packet.AppendEntityID(entityID)
+			packet.AppendVarStr(method)
+			packet.AppendArgs(args)
+		}
+		return packet, nil
+	},
+	MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		var eid, method string
+		var seq uint32
+		var args []interface{}
+		err := pbDecode(data, func(f *pbField) (err error) {
+			switch f.num {
+			case 1:
+				eid = f.string()
+			case 2:
+				seq = uint32(f.u)
+			case 3:
+				method = f.string()
+			case 4:
+				var arg interface{}
+				arg, err = pbDecodeValue(f.b, 0)
+				args = append(args, arg)
+			}
+			return
+		})
+		entityID, err := pbCheckEntityID(eid, err)
+		if err != nil {
+			return nil, err
+		}
+
+		packet := netutil.NewPacket()
+		packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT)
+		packet.AppendEntityID(entityID)
+		packet.AppendUint32(seq)
+		packet.AppendVarStr(method)
+		packet.AppendArgs(args)
+		return packet, nil
+	},
+	MT_SYNC_POSITION_YAW_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		return pbDecodeSyncPositionYaw(data, MT_SYNC_POSITION_YAW_FROM_CLIENT, false)
+	},
+	MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		return pbDecodeSyncPositionYaw(data, MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT, true)
+	},
+	MT_HEARTBEAT_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		packet := netutil.NewPacket()
+		packet.AppendUint16(MT_HEARTBEAT_FROM_CLIENT)
+		return packet, nil
+	},
+	MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		return pbDecodeToken(data, MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT)
+	},
+	MT_RESUME_SESSION_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		return pbDecodeToken(data, MT_RESUME_SESSION_FROM_CLIENT)
+	},
+	MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT: func(data []byte) (*netutil.Packet, error) {
+		var version uint64
+		err := pbDecode(data, func(f *pbField) error {
+			if f.num == 1 {
+				version = f.u
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		packet := netutil.NewPacket()
+		packet.AppendUint16(MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT)
+		packet.AppendUint16(uint16(version))
+		return packet, nil
+	},
+}
+
+func pbCheckEntityID(eid string, err error) (common.EntityID, error) {
+	if err != nil {
+		return "", err
+	}
+	return pbEntityID(eid)
+}
+
+func pbDecodeSyncPositionYaw(data []byte, msgtype MsgType, withSeq bool) (*netutil.Packet, error) {
+	var eid string
+	var seq uint32
+	var pos [4]float32 // x, y, z, yaw
+	firstPosField := 2
+	if withSeq {
+		firstPosField = 3
+	}
+	err := pbDecode(data, func(f *pbField) error {
+		if f.num == 1 {
+			eid = f.string()
+		} else if withSeq && f.num == 2 {
+			seq = uint32(f.u)
+		} else if i := f.num - firstPosField; i >= 0 && i < len(pos) {
+			pos[i] = f.float()
+		}
+		return nil
+	})
+	entityID, err := pbCheckEntityID(eid, err)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := netutil.NewPacket()
+	packet.AppendUint16(uint16(msgtype))
+	packet.AppendEntityID(entityID)
+	if withSeq {
+		packet.AppendUint32(seq)
+	}
+	for _, v := range pos {
+		packet.AppendFloat32(v)
+	}
+	return packet, nil
+}
+
+func pbDecodeToken(data []byte, msgtype MsgType) (*netutil.Packet, error) {
+	var token string
+	err := pbDecode(data, func(f *pbField) error {
+		if f.num == 1 {
+			token = f.string()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packet := netutil.NewPacket()
+	packet.AppendUint16(uint16(msgtype))
+	packet.AppendVarStr(token)
+	return packet, nil
+}
@@ -0,0 +1,230 @@
+package proto
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// pbFieldsForTest decodes fields of the protobuf message by field number
+func pbFieldsForTest(t *testing.T, data []byte) map[int][]pbField {
+	fields := map[int][]pbField{}
+	if err := pbDecode(data, func(f *pbField) error {
+		fields[f.num] = append(fields[f.num], *f)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return fields
+}
+
+func pbValuesForTest(t *testing.T, fields []pbField) []interface{} {
+	values := make([]interface{}, len(fields))
+	for i := range fields {
+		v, err := pbDecodeValue(fields[i].b, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values[i] = v
+	}
+	return values
+}
+
+func TestPbValue(t *testing.T) {
+	type testStruct struct {
+		A int `msgpack:"a"`
+	}
+	values := []interface{}{nil, true, -1, uint64(math.MaxUint64), 1.5, "str", []byte("bytes"),
+		[]interface{}{1, "a", []interface{}{}}, map[string]interface{}{"k": map[interface{}]interface{}{1: "v"}}, testStruct{A: 1}}
+	expected := []interface{}{nil, true, int64(-1), uint64(math.MaxUint64), 1.5, "str", []byte("bytes"),
+		[]interface{}{int64(1), "a", []interface{}{}}, map[string]interface{}{"k": map[string]interface{}{"1": "v"}}, map[string]interface{}{"a": int64(1)}}
+
+	for i, v := range values {
+		decoded, err := pbDecodeValue(pbEncodeValue(nil, v), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, expected[i]) {
+			t.Errorf("value %#v is decoded as %#v", v, decoded)
+		}
+	}
+
+	var nested interface{} = "leaf"
+	for i := 0; i <= pbMaxValueDepth; i++ {
+		nested = []interface{}{nested}
+	}
+	if _, err := pbDecodeValue(pbEncodeValue(nil, nested), 0); err == nil {
+		t.Errorf("value nested too deep should not be decoded")
+	}
+}
+
+func TestProtobufClientCodecEncode(t *testing.T) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_ON_CLIENT)
+	packet.AppendUint16(1)
+	packet.AppendClientID(compatClientID)
+	packet.AppendEntityID(compatEntityID)
+	packet.AppendVarStr("Method")
+	packet.AppendArgs(compatArgs)
+	encoded, err := ProtobufClientCodec.EncodePacket(packet)
+	packet.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msgtype := encoded.ReadUint16(); msgtype != MT_CALL_ENTITY_METHOD_ON_CLIENT {
+		t.Fatalf("wrong message type: %d", msgtype)
+	}
+	fields := pbFieldsForTest(t, encoded.UnreadPayload())
+	encoded.Release()
+	if fields[1][0].string() != string(compatEntityID) || fields[2][0].string() != "Method" {
+		t.Errorf("wrong entity ID or method: %+v", fields)
+	}
+	expectedArgs := []interface{}{int64(1), "str", true, 1.5, []interface{}{int64(1), int64(2)}, map[string]interface{}{"k": "v"}}
+	if args := pbValuesForTest(t, fields[3]); !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("wrong args: %#v", args)
+	}
+
+	packet = netutil.NewPacket()
+	packet.AppendUint16(MT_SYNC_INPUT_ACK_ON_CLIENTS)
+	packet.AppendUint32(10)  // server tick
+	packet.AppendUint64(100) // server time
+	packet.AppendEntityID(compatEntityID)
+	packet.AppendUint32(5) // seq
+	for _, v := range []float32{1, 2, 3, 4} {
+		packet.AppendFloat32(v)
+	}
+	encoded, err = ProtobufClientCodec.EncodePacket(packet)
+	packet.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded.ReadUint16()
+	fields = pbFieldsForTest(t, encoded.UnreadPayload())
+	encoded.Release()
+	if fields[1][0].u != 10 || fields[2][0].u != 100 || len(fields[3]) != 1 {
+		t.Fatalf("wrong sync infos: %+v", fields)
+	}
+	info := pbFieldsForTest(t, fields[3][0].b)
+	if info[1][0].string() != string(compatEntityID) || info[6][0].u != 5 || info[2][0].float() != 1 || info[5][0].float() != 4 {
+		t.Errorf("wrong sync info: %+v", info)
+	}
+
+	packet = netutil.NewPacket()
+	packet.AppendUint16(MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS)
+	if _, err := ProtobufClientCodec.EncodePacket(packet); err == nil {
+		t.Errorf("quantized sync infos should not be encoded")
+	}
+	packet.Release()
+}
+
+func TestProtobufClientCodecDecode(t *testing.T) {
+	decode := func(msgtype MsgType, data []byte) (*netutil.Packet, error) {
+		packet := netutil.NewPacket()
+		defer packet.Release()
+		packet.AppendUint16(uint16(msgtype))
+		packet.AppendBytes(data)
+		return ProtobufClientCodec.DecodePacket(packet)
+	}
+
+	data := pbAppendString(nil, 1, string(compatEntityID))
+	data = pbAppendString(data, 2, "Method")
+	data = pbAppendValue(data, 3, 1)
+	data = pbAppendValue(data, 3, "str")
+	packet, err := decode(MT_CALL_ENTITY_METHOD_FROM_CLIENT, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgtype := packet.ReadUint16(); msgtype != MT_CALL_ENTITY_METHOD_FROM_CLIENT {
+		t.Fatalf("wrong message type: %d", msgtype)
+	}
+	if eid, method := packet.ReadEntityID(), packet.ReadVarStr(); eid != compatEntityID || method != "Method" {
+		t.Errorf("wrong entity ID or method: %s, %s", eid, method)
+	}
+	args, err := unpackRPCValuesForTest(packet.ReadArgs())
+	if err != nil || !reflect.DeepEqual(args, []interface{}{int64(1), "str"}) {
+		t.Errorf("wrong args: %#v, %v", args, err)
+	}
+	packet.Release()
+
+	packet, err = decode(MT_CALL_ENTITY_METHOD_FROM_CLIENT, pbAppendBytes(data, 4, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgtype := packet.ReadUint16(); msgtype != MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT {
+		t.Errorf("call with typed args should be decoded as %d, but is %d", MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT, msgtype)
+	}
+	packet.Release()
+
+	data = pbAppendString(nil, 1, string(compatEntityID))
+	data = pbAppendUint(data, 2, 7)
+	data = pbAppendFloat(data, 3, 1)
+	data = pbAppendFloat(data, 6, 4)
+	packet, err = decode(MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet.ReadUint16()
+	if eid, seq := packet.ReadEntityID(), packet.ReadUint32(); eid != compatEntityID || seq != 7 {
+		t.Errorf("wrong entity ID or seq: %s, %d", eid, seq)
+	}
+	if x, y, z, yaw := packet.ReadFloat32(), packet.ReadFloat32(), packet.ReadFloat32(), packet.ReadFloat32(); x != 1 || y != 0 || z != 0 || yaw != 4 {
+		t.Errorf("wrong position & yaw: %v, %v, %v, %v", x, y, z, yaw)
+	}
+	packet.Release()
+
+	if _, err := decode(MT_SYNC_POSITION_YAW_FROM_CLIENT, pbAppendString(nil, 1, "short")); err == nil {
+		t.Errorf("invalid entity ID should not be decoded")
+	}
+	if _, err := decode(MT_SYNC_POSITION_YAW_FROM_CLIENT, data[:len(data)-1]); err == nil {
+		t.Errorf("truncated message should not be decoded")
+	}
+	if _, err := decode(MT_SET_CLIENT_CODEC_FROM_CLIENT, nil); err == nil {
+		t.Errorf("message type not supported should not be decoded")
+	}
+}
+
+func TestDecodeTypedArgs(t *testing.T) {
+	argTypes := []reflect.Type{reflect.TypeOf(0), reflect.TypeOf(""), reflect.TypeOf(float32(0)), reflect.TypeOf([]int{}), reflect.TypeOf(false)}
+	data := pbAppendSint(nil, 1, -3)
+	data = pbAppendString(data, 2, "name")
+	data = pbAppendFloat(data, 3, 1.5)
+	data = pbAppendValue(data, 4, []interface{}{1, 2})
+	data = pbAppendUint(data, 10, 1) // unknown field
+
+	packedArgs, err := DecodeTypedArgs(data, argTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		a int
+		b string
+		c float32
+		d []int
+		e = true
+	)
+	for i, arg := range []interface{}{&a, &b, &c, &d, &e} {
+		if err := netutil.MSG_PACKER.UnpackMsg(packedArgs[i], arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a != -3 || b != "name" || c != 1.5 || !reflect.DeepEqual(d, []int{1, 2}) || e {
+		t.Errorf("wrong typed args: %v, %v, %v, %v, %v", a, b, c, d, e)
+	}
+
+	if _, err := DecodeTypedArgs(pbAppendString(nil, 1, "1"), argTypes); err == nil {
+		t.Errorf("typed args of wrong wire type should not be decoded")
+	}
+}
+
+func unpackRPCValuesForTest(packed [][]byte) ([]interface{}, error) {
+	values := make([]interface{}, len(packed))
+	for i, data := range packed {
+		if err := netutil.MSG_PACKER.UnpackMsg(data, &values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
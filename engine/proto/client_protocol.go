@@ -0,0 +1,142 @@
+package proto
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Client protocol versions
+//
+// Clients announce the latest protocol version they support by MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT, which should
+// be the first message sent after connected. Clients which send other messages first are of CLIENT_PROTOCOL_VERSION_BASE.
+// Gates select the version within the supported window [min, max] and reply MT_SET_CLIENT_PROTOCOL_VERSION to clients of
+// CLIENT_PROTOCOL_VERSION_NEGOTIATION or later, or send MT_CLIENT_PROTOCOL_VERSION_REJECTED and disconnect clients of
+// versions lower than min.
+//
+// Messages which change between versions are converted by shims registered for the message types, so that the game
+// always sends and receives messages of the latest version.
+
+// ClientEncodeShim sends the message of the latest protocol as messages of an older protocol version to the client
+//
+// payload is the message after the message type, including gate ID and client ID for messages redirected to clients.
+type ClientEncodeShim func(gwc *GoWorldConnection, payload []byte) error
+
+// ClientDecodeShim converts the message received from clients of an older protocol version to the message of the latest
+// protocol, which starts with the message type
+//
+// The read position of packet is after the message type.
+type ClientDecodeShim func(packet *netutil.Packet) (*netutil.Packet, error)
+
+type clientEncodeShimEntry struct {
+	version uint16
+	shim    ClientEncodeShim
+}
+
+type clientDecodeShimEntry struct {
+	version uint16
+	shim    ClientDecodeShim
+}
+
+var (
+	clientEncodeShims = map[MsgType][]clientEncodeShimEntry{}
+	clientDecodeShims = map[MsgType][]clientDecodeShimEntry{}
+)
+
+func init() {
+	RegisterClientEncodeShim(MT_NOTIFY_ATTR_PATCH_ON_CLIENT, CLIENT_PROTOCOL_VERSION_ATTR_PATCH, expandAttrPatchShim)
+}
+
+// RegisterClientEncodeShim registers the shim for messages of the type sent to clients of versions lower than version
+//
+// If multiple shims are registered for the message type, the one of the lowest version higher than the client version
+// is used. Shims should be registered in init functions.
+func RegisterClientEncodeShim(msgtype MsgType, version uint16, shim ClientEncodeShim) {
+	entries := append(clientEncodeShims[msgtype], clientEncodeShimEntry{version, shim})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].version < entries[j].version })
+	clientEncodeShims[msgtype] = entries
+}
+
+// RegisterClientDecodeShim registers the shim for messages of the type received from clients of versions lower than version
+//
+// If multiple shims are registered for the message type, the one of the lowest version higher than the client version
+// is used. Shims should be registered in init functions.
+func RegisterClientDecodeShim(msgtype MsgType, version uint16, shim ClientDecodeShim) {
+	entries := append(clientDecodeShims[msgtype], clientDecodeShimEntry{version, shim})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].version < entries[j].version })
+	clientDecodeShims[msgtype] = entries
+}
+
+// GetClientEncodeShim returns the shim for messages of the type sent to clients of the version, or nil if not needed
+func GetClientEncodeShim(msgtype MsgType, clientVersion uint16) ClientEncodeShim {
+	for _, entry := range clientEncodeShims[msgtype] {
+		if clientVersion < entry.version {
+			return entry.shim
+		}
+	}
+	return nil
+}
+
+// GetClientDecodeShim returns the shim for messages of the type received from clients of the version, or nil if not needed
+func GetClientDecodeShim(msgtype MsgType, clientVersion uint16) ClientDecodeShim {
+	for _, entry := range clientDecodeShims[msgtype] {
+		if clientVersion < entry.version {
+			return entry.shim
+		}
+	}
+	return nil
+}
+
+// NegotiateClientProtocolVersion selects the protocol version for the client announcing clientVersion, it returns false
+// if the client is older than minVersion
+//
+// Clients newer than maxVersion use maxVersion, since clients support all versions before the version announced.
+func NegotiateClientProtocolVersion(clientVersion, minVersion, maxVersion uint16) (uint16, bool) {
+	if clientVersion < minVersion {
+		return clientVersion, false
+	}
+	if clientVersion > maxVersion {
+		return maxVersion, true
+	}
+	return clientVersion, true
+}
+
+// expandAttrPatchShim sends the attribute patch as MT_NOTIFY_*_ATTR_* messages to clients which do not support
+// MT_NOTIFY_ATTR_PATCH_ON_CLIENT
+func expandAttrPatchShim(gwc *GoWorldConnection, payload []byte) (err error) {
+	const headerSize = 2 + common.CLIENTID_LENGTH + common.ENTITYID_LENGTH
+	if len(payload) < headerSize {
+		return errors.Errorf("attribute patch is too short: %d", len(payload))
+	}
+
+	gateid := netutil.NETWORK_ENDIAN.Uint16(payload)
+	clientid := common.ClientID(payload[2 : 2+common.CLIENTID_LENGTH])
+	entityID := common.EntityID(payload[2+common.CLIENTID_LENGTH : headerSize])
+	patch, err := DecodeAttrPatch(payload[headerSize:])
+	if err != nil {
+		return err
+	}
+
+	for _, op := range patch.Ops {
+		switch op.Op {
+		case ATTR_PATCH_MAP_CHANGE:
+			err = gwc.SendNotifyMapAttrChangeOnClient(gateid, clientid, entityID, op.Path, op.Key, op.Val)
+		case ATTR_PATCH_MAP_DEL:
+			err = gwc.SendNotifyMapAttrDelOnClient(gateid, clientid, entityID, op.Path, op.Key)
+		case ATTR_PATCH_MAP_CLEAR:
+			err = gwc.SendNotifyMapAttrClearOnClient(gateid, clientid, entityID, op.Path)
+		case ATTR_PATCH_LIST_CHANGE:
+			err = gwc.SendNotifyListAttrChangeOnClient(gateid, clientid, entityID, op.Path, op.Index, op.Val)
+		case ATTR_PATCH_LIST_POP:
+			err = gwc.SendNotifyListAttrPopOnClient(gateid, clientid, entityID, op.Path)
+		case ATTR_PATCH_LIST_APPEND:
+			err = gwc.SendNotifyListAttrAppendOnClient(gateid, clientid, entityID, op.Path, op.Val)
+		}
+		if err != nil {
+			return errors.Wrap(err, "send attribute change failed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func TestNegotiateClientProtocolVersion(t *testing.T) {
+	for _, c := range []struct {
+		clientVersion, minVersion, maxVersion uint16
+		version                               uint16
+		ok                                    bool
+	}{
+		{0, 0, CLIENT_PROTOCOL_VERSION, 0, true},
+		{CLIENT_PROTOCOL_VERSION, 0, CLIENT_PROTOCOL_VERSION, CLIENT_PROTOCOL_VERSION, true},
+		{CLIENT_PROTOCOL_VERSION + 1, 0, CLIENT_PROTOCOL_VERSION, CLIENT_PROTOCOL_VERSION, true},
+		{CLIENT_PROTOCOL_VERSION, 0, CLIENT_PROTOCOL_VERSION_ATTR_PATCH, CLIENT_PROTOCOL_VERSION_ATTR_PATCH, true},
+		{0, CLIENT_PROTOCOL_VERSION_ATTR_PATCH, CLIENT_PROTOCOL_VERSION, 0, false},
+	} {
+		version, ok := NegotiateClientProtocolVersion(c.clientVersion, c.minVersion, c.maxVersion)
+		if version != c.version || ok != c.ok {
+			t.Errorf("client version %d with supported versions %d ~ %d is negotiated as %d, %v", c.clientVersion, c.minVersion, c.maxVersion, version, ok)
+		}
+	}
+}
+
+func TestClientEncodeShims(t *testing.T) {
+	if GetClientEncodeShim(MT_NOTIFY_ATTR_PATCH_ON_CLIENT, CLIENT_PROTOCOL_VERSION_ATTR_PATCH) != nil {
+		t.Errorf("clients supporting attribute patches should not need shims")
+	}
+	shim := GetClientEncodeShim(MT_NOTIFY_ATTR_PATCH_ON_CLIENT, CLIENT_PROTOCOL_VERSION_BASE)
+	if shim == nil {
+		t.Fatalf("shim of attribute patches is not registered")
+	}
+
+	patch := &AttrPatch{Ops: []AttrPatchOp{
+		{Op: ATTR_PATCH_MAP_CHANGE, Path: compatPath, Key: "key", Val: "val"},
+		{Op: ATTR_PATCH_LIST_POP, Path: compatPath},
+	}}
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_ATTR_PATCH_ON_CLIENT)
+	packet.AppendUint16(1)
+	packet.AppendClientID(compatClientID)
+	packet.AppendEntityID(compatEntityID)
+	packet.AppendBytes(patch.Encode(nil))
+	defer packet.Release()
+
+	conn := &captureConn{}
+	gwc := NewGoWorldConnection(conn)
+	if err := shim(gwc, packet.Payload()[2:]); err != nil {
+		t.Fatal(err)
+	}
+	gwc.Flush("test")
+
+	expectedConn := &captureConn{}
+	expectedGwc := NewGoWorldConnection(expectedConn)
+	expectedGwc.SendNotifyMapAttrChangeOnClient(1, compatClientID, compatEntityID, compatPath, "key", "val")
+	expectedGwc.SendNotifyListAttrPopOnClient(1, compatClientID, compatEntityID, compatPath)
+	expectedGwc.Flush("test")
+	if !bytes.Equal(conn.buf.Bytes(), expectedConn.buf.Bytes()) {
+		t.Errorf("attribute patch is not expanded correctly")
+	}
+
+	if err := shim(gwc, packet.Payload()[2:10]); err == nil {
+		t.Errorf("truncated attribute patch should not be expanded")
+	}
+}
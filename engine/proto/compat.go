@@ -0,0 +1,360 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// PROTOCOL_VERSION is the version of internal protocol between dispatcher, gate and game
+//
+// It should be increased whenever an incompatible change is made to the internal protocol:
+//   - 2: entity sync packets are stamped with server tick and time
+//   - 3: MT_LOAD_ENTITY_SOMEWHERE carries the entity type name, which was changed without increasing the version
+const PROTOCOL_VERSION = 3
+
+// CompatCorpus is a corpus of message encodings for checking protocol compatibility between engine versions
+type CompatCorpus struct {
+	ProtocolVersion int
+	MsgTypes        map[string]MsgType
+	Messages        map[string][]byte
+}
+
+// captureConn is a fake connection which captures all written data
+type captureConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (cc *captureConn) Write(b []byte) (int, error) {
+	return cc.buf.Write(b)
+}
+
+func (cc *captureConn) Flush() error {
+	return nil
+}
+
+var (
+	compatEntityID  = common.EntityID("CompatEntity0001")
+	compatEntityID2 = common.EntityID("CompatEntity0002")
+	compatClientID  = common.ClientID("CompatClient0001")
+	compatArgs      = []interface{}{1, "str", true, 1.5, []interface{}{1, 2}, map[string]interface{}{"k": "v"}}
+	compatPath      = []interface{}{"a", 1}
+)
+
+// msgTypesByName are all message types by their names
+var msgTypesByName = map[string]MsgType{
+	"MT_SET_GAME_ID":                                    MT_SET_GAME_ID,
+	"MT_SET_GATE_ID":                                    MT_SET_GATE_ID,
+	"MT_NOTIFY_CREATE_ENTITY":                           MT_NOTIFY_CREATE_ENTITY,
+	"MT_NOTIFY_DESTROY_ENTITY":                          MT_NOTIFY_DESTROY_ENTITY,
+	"MT_KVREG_REGISTER":                                 MT_KVREG_REGISTER,
+	"MT_CALL_ENTITY_METHOD":                             MT_CALL_ENTITY_METHOD,
+	"MT_CREATE_ENTITY_SOMEWHERE":                        MT_CREATE_ENTITY_SOMEWHERE,
+	"MT_LOAD_ENTITY_SOMEWHERE":                          MT_LOAD_ENTITY_SOMEWHERE,
+	"MT_NOTIFY_CLIENT_CONNECTED":                        MT_NOTIFY_CLIENT_CONNECTED,
+	"MT_NOTIFY_CLIENT_DISCONNECTED":                     MT_NOTIFY_CLIENT_DISCONNECTED,
+	"MT_CALL_ENTITY_METHOD_FROM_CLIENT":                 MT_CALL_ENTITY_METHOD_FROM_CLIENT,
+	"MT_SYNC_POSITION_YAW_FROM_CLIENT":                  MT_SYNC_POSITION_YAW_FROM_CLIENT,
+	"MT_NOTIFY_GATE_DISCONNECTED":                       MT_NOTIFY_GATE_DISCONNECTED,
+	"MT_START_FREEZE_GAME":                              MT_START_FREEZE_GAME,
+	"MT_START_FREEZE_GAME_ACK":                          MT_START_FREEZE_GAME_ACK,
+	"MT_MIGRATE_REQUEST":                                MT_MIGRATE_REQUEST,
+	"MT_REAL_MIGRATE":                                   MT_REAL_MIGRATE,
+	"MT_QUERY_SPACE_GAMEID_FOR_MIGRATE":                 MT_QUERY_SPACE_GAMEID_FOR_MIGRATE,
+	"MT_CANCEL_MIGRATE":                                 MT_CANCEL_MIGRATE,
+	"MT_CALL_NIL_SPACES":                                MT_CALL_NIL_SPACES,
+	"MT_SET_GAME_ID_ACK":                                MT_SET_GAME_ID_ACK,
+	"MT_NOTIFY_GAME_CONNECTED":                          MT_NOTIFY_GAME_CONNECTED,
+	"MT_NOTIFY_GAME_DISCONNECTED":                       MT_NOTIFY_GAME_DISCONNECTED,
+	"MT_NOTIFY_DEPLOYMENT_READY":                        MT_NOTIFY_DEPLOYMENT_READY,
+	"MT_GAME_LBC_INFO":                                  MT_GAME_LBC_INFO,
+	"MT_CALL_ENTITY_METHOD_WITH_SEQ":                    MT_CALL_ENTITY_METHOD_WITH_SEQ,
+	"MT_RECONCILE_ENTITIES":                             MT_RECONCILE_ENTITIES,
+	"MT_DESTROY_STALE_ENTITIES":                         MT_DESTROY_STALE_ENTITIES,
+	"MT_CREATE_ENTITY_ON_CLIENT":                        MT_CREATE_ENTITY_ON_CLIENT,
+	"MT_DESTROY_ENTITY_ON_CLIENT":                       MT_DESTROY_ENTITY_ON_CLIENT,
+	"MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT":               MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT,
+	"MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT":                  MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT,
+	"MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT":              MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT,
+	"MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT":                 MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT,
+	"MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT":              MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT,
+	"MT_CALL_ENTITY_METHOD_ON_CLIENT":                   MT_CALL_ENTITY_METHOD_ON_CLIENT,
+	"MT_SET_CLIENTPROXY_FILTER_PROP":                    MT_SET_CLIENTPROXY_FILTER_PROP,
+	"MT_CLEAR_CLIENTPROXY_FILTER_PROPS":                 MT_CLEAR_CLIENTPROXY_FILTER_PROPS,
+	"MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT":                MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT,
+	"MT_CALL_FILTERED_CLIENTS":                          MT_CALL_FILTERED_CLIENTS,
+	"MT_SYNC_POSITION_YAW_ON_CLIENTS":                   MT_SYNC_POSITION_YAW_ON_CLIENTS,
+	"MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY":     MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY,
+	"MT_SET_CLIENT_CLIENTID":                            MT_SET_CLIENT_CLIENTID,
+	"MT_HEARTBEAT_FROM_CLIENT":                          MT_HEARTBEAT_FROM_CLIENT,
+	"MT_RECONNECT_DIRECTIVE":                            MT_RECONNECT_DIRECTIVE,
+	"MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT":         MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT,
+	"MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT":        MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT,
+	"MT_SYNC_INPUT_ACK_ON_CLIENTS":                      MT_SYNC_INPUT_ACK_ON_CLIENTS,
+	"MT_SESSION_TOKEN_CHALLENGE":                        MT_SESSION_TOKEN_CHALLENGE,
+	"MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT":             MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT,
+	"MT_NOTIFY_CLIENT_RESUMED":                          MT_NOTIFY_CLIENT_RESUMED,
+	"MT_RESUME_SESSION_FROM_CLIENT":                     MT_RESUME_SESSION_FROM_CLIENT,
+	"MT_RESUME_SESSION_ACK":                             MT_RESUME_SESSION_ACK,
+	"MT_SET_STANDBY":                                    MT_SET_STANDBY,
+	"MT_SYNC_ROUTING_TO_STANDBY":                        MT_SYNC_ROUTING_TO_STANDBY,
+	"MT_SYNC_SERVICE_SNAPSHOT":                          MT_SYNC_SERVICE_SNAPSHOT,
+	"MT_CREATE_ENTITY_ANYWHERE_QUEUED":                  MT_CREATE_ENTITY_ANYWHERE_QUEUED,
+	"MT_CREATE_ENTITY_REJECTED":                         MT_CREATE_ENTITY_REJECTED,
+	"MT_AUTO_MIGRATE_ENTITIES":                          MT_AUTO_MIGRATE_ENTITIES,
+	"MT_SET_GAME_DRAINING":                              MT_SET_GAME_DRAINING,
+	"MT_CALL_ENTITY_METHOD_WITH_RESULT":                 MT_CALL_ENTITY_METHOD_WITH_RESULT,
+	"MT_CALL_ENTITY_METHOD_RESULT":                      MT_CALL_ENTITY_METHOD_RESULT,
+	"MT_NOTIFY_ATTR_PATCH_ON_CLIENT":                    MT_NOTIFY_ATTR_PATCH_ON_CLIENT,
+	"MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT":        MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT,
+	"MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS":         MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS,
+	"MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT": MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT,
+	"MT_PUBLISH_CHANNEL":                                MT_PUBLISH_CHANNEL,
+	"MT_CLAIM_CRON_JOB":                                 MT_CLAIM_CRON_JOB,
+	"MT_SET_CLIENT_CODEC_FROM_CLIENT":                   MT_SET_CLIENT_CODEC_FROM_CLIENT,
+	"MT_SET_CLIENT_CODEC":                               MT_SET_CLIENT_CODEC,
+	"MT_SET_CLIENT_PROTOCOL_VERSION":                    MT_SET_CLIENT_PROTOCOL_VERSION,
+	"MT_CLIENT_PROTOCOL_VERSION_REJECTED":               MT_CLIENT_PROTOCOL_VERSION_REJECTED,
+	"MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION":        MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION,
+	"MT_SET_CLIENT_SYNC_VERSION":                        MT_SET_CLIENT_SYNC_VERSION,
+	"MT_CLOCK_SYNC":                                     MT_CLOCK_SYNC,
+	"MT_CLOCK_SYNC_ACK":                                 MT_CLOCK_SYNC_ACK,
+	"MT_ACK_ENTITY_RPCS":                                MT_ACK_ENTITY_RPCS,
+	"MT_BIND_CLIENT_CHANNEL_FROM_CLIENT":                MT_BIND_CLIENT_CHANNEL_FROM_CLIENT,
+	"MT_BIND_CLIENT_CHANNEL_ACK":                        MT_BIND_CLIENT_CHANNEL_ACK,
+}
+
+// MsgTypeByName returns the message type of the name, e.g. MT_SYNC_POSITION_YAW_ON_CLIENTS
+func MsgTypeByName(name string) (MsgType, bool) {
+	msgtype, ok := msgTypesByName[name]
+	return msgtype, ok
+}
+
+// MakeCompatCorpus encodes a fixed corpus of messages using current engine version
+func MakeCompatCorpus() *CompatCorpus {
+	corpus := &CompatCorpus{
+		ProtocolVersion: PROTOCOL_VERSION,
+		MsgTypes:        make(map[string]MsgType, len(msgTypesByName)),
+		Messages:        map[string][]byte{},
+	}
+	for name, msgtype := range msgTypesByName {
+		corpus.MsgTypes[name] = msgtype
+	}
+
+	conn := &captureConn{}
+	gwc := NewGoWorldConnection(conn)
+	capture := func(name string, send func() error) {
+		if err := send(); err != nil {
+			gwlog.Panicf("make compat corpus %s failed: %s", name, err)
+		}
+		gwc.Flush("compat")
+		corpus.Messages[name] = append([]byte(nil), conn.buf.Bytes()...)
+		conn.buf.Reset()
+	}
+	capturePacket := func(name string, packet *netutil.Packet) {
+		capture(name, func() error {
+			return gwc.SendPacketRelease(packet)
+		})
+	}
+
+	// handshake messages
+	capture("SetGameID", func() error {
+		return gwc.SendSetGameID(1, true, false, true, []common.EntityID{compatEntityID, compatEntityID2})
+	})
+	capture("SetGateID", func() error { return gwc.SendSetGateID(1) })
+	capture("SetGameIDAck", func() error {
+		return gwc.SendSetGameIDAck(1, true, []uint16{1, 2}, []common.EntityID{compatEntityID}, map[string]string{"srv": "info"})
+	})
+	capture("SetStandby", func() error { return gwc.SendSetStandby(1) })
+	capture("SyncRoutingToStandby", func() error {
+		return gwc.SendSyncRoutingToStandby([]uint16{1, 2}, map[string]string{"srv": "info"}, map[common.EntityID]uint16{compatEntityID: 1})
+	})
+	// entity messages
+	capture("NotifyCreateEntity", func() error { return gwc.SendNotifyCreateEntity(compatEntityID) })
+	capture("NotifyDestroyEntity", func() error { return gwc.SendNotifyDestroyEntity(compatEntityID) })
+	capture("NotifyClientConnected", func() error { return gwc.SendNotifyClientConnected(compatClientID, compatEntityID) })
+	capture("NotifyClientDisconnected", func() error { return gwc.SendNotifyClientDisconnected(compatClientID, compatEntityID) })
+	capture("NotifyClientResumed", func() error { return gwc.SendNotifyClientResumed(compatClientID, compatEntityID) })
+	capture("NotifyClientResumedWithSyncVersion", func() error {
+		return gwc.SendNotifyClientResumedWithSyncVersion(compatClientID, compatEntityID, 1, 1500000000)
+	})
+	capture("CreateEntitySomewhere", func() error {
+		return gwc.SendCreateEntitySomewhere(1, compatEntityID, "Avatar", map[string]interface{}{"name": "compat"})
+	})
+	capture("CreateEntityAnywhereQueued", func() error {
+		return gwc.SendCreateEntityAnywhereQueued(compatEntityID, "Avatar", 1, 1000, map[string]interface{}{"name": "compat"})
+	})
+	capturePacket("CreateEntityRejected", MakeCreateEntityRejectedPacket(compatEntityID, "timeout"))
+	capture("LoadEntitySomewhere", func() error { return gwc.SendLoadEntitySomewhere("Avatar", compatEntityID, 1) })
+	capture("ReconcileEntities", func() error {
+		return gwc.SendReconcileEntities([]common.EntityID{compatEntityID, compatEntityID2})
+	})
+	capture("DestroyStaleEntities", func() error { return gwc.SendDestroyStaleEntities([]common.EntityID{compatEntityID}) })
+	capture("SyncServiceSnapshot", func() error {
+		return gwc.SendSyncServiceSnapshot(2, 1, compatEntityID, "Service#0", map[string]interface{}{"name": "compat"})
+	})
+	capture("KvregRegister", func() error { return gwc.SendKvregRegister("srv", "info", true) })
+	capture("PublishChannel", func() error { return gwc.SendPublishChannel("channel", true, "Method", compatArgs) })
+	capture("ClaimCronJob", func() error { return gwc.SendClaimCronJob("DailyReset", 1500000000) })
+	capture("ClockSync", func() error { return gwc.SendClockSync(1500000000000000000) })
+	capture("ClockSyncAck", func() error { return gwc.SendClockSyncAck(1500000000000000000, 1500000000001000000) })
+	capture("CallEntityMethod", func() error { return gwc.SendCallEntityMethod(compatEntityID, "Method", compatArgs) })
+	capturePacket("CallEntityMethodWithSeq", MakeCallEntityMethodWithSeqPacket(compatEntityID, "Method", compatArgs, RPCSource{GameID: 1, DispatcherID: 1, Epoch: 1}, 2, 1))
+	capture("AckEntityRPCs", func() error { return gwc.SendAckEntityRPCs(RPCSource{GameID: 1, DispatcherID: 1}, []uint64{1, 2}) })
+	capture("CallEntityMethodWithResult", func() error {
+		return gwc.SendCallEntityMethodWithResult(compatEntityID, "Method", compatArgs, 1, 1)
+	})
+	capturePacket("CallEntityMethodResult", MakeCallEntityMethodResultPacket(1, 1, RPC_ERROR_PANIC, "error", [][]byte{[]byte("result")}))
+	capture("CallEntityMethodFromClient", func() error {
+		return gwc.SendCallEntityMethodFromClient(compatEntityID, "Method", compatArgs)
+	})
+	capture("SyncPositionYawFromClient", func() error {
+		return gwc.SendSyncPositionYawFromClient(compatEntityID, 1, 2, 3, 4)
+	})
+	capture("SyncPositionYawWithSeqFromClient", func() error {
+		return gwc.SendSyncPositionYawWithSeqFromClient(compatEntityID, 1, 1, 2, 3, 4)
+	})
+	capture("CallEntityMethodWithSeqFromClient", func() error {
+		return gwc.SendCallEntityMethodWithSeqFromClient(compatEntityID, 1, "Method", compatArgs)
+	})
+	capturePacket("CallNilSpaces", AllocCallNilSpacesPacket(1, "Method", compatArgs))
+	capturePacket("GameLBCInfo", AllocGameLBCInfoPacket(GameLBCInfo{CPUPercent: 0.5}))
+	capturePacket("GameLBCInfoWithLoad", AllocGameLBCInfoPacket(GameLBCInfo{CPUPercent: 0.5, EntityCount: 100, PacketBacklog: 10}))
+	capturePacket("AutoMigrateEntities", MakeAutoMigrateEntitiesPacket(2, 10))
+	capturePacket("SetGameDraining", MakeSetGameDrainingPacket())
+	capturePacket("StartFreezeGame", AllocStartFreezeGamePacket())
+	capturePacket("NotifyGameConnected", MakeNotifyGameConnectedPacket(1))
+	capturePacket("NotifyGameDisconnected", MakeNotifyGameDisconnectedPacket(1))
+	capturePacket("NotifyDeploymentReady", MakeNotifyDeploymentReadyPacket())
+	// migrate messages
+	capture("QuerySpaceGameIDForMigrate", func() error {
+		return gwc.SendQuerySpaceGameIDForMigrate(compatEntityID2, compatEntityID)
+	})
+	capture("MigrateRequest", func() error { return gwc.SendMigrateRequest(compatEntityID, compatEntityID2, 1) })
+	capture("CancelMigrate", func() error { return gwc.SendCancelMigrate(compatEntityID) })
+	capture("RealMigrate", func() error { return gwc.SendRealMigrate(compatEntityID, 1, []byte("migrate data")) })
+	// client messages
+	capture("CreateEntityOnClient", func() error {
+		return gwc.SendCreateEntityOnClient(1, compatClientID, "Avatar", compatEntityID, true, map[string]interface{}{"name": "compat"}, 1, 2, 3, 4)
+	})
+	capture("DestroyEntityOnClient", func() error {
+		return gwc.SendDestroyEntityOnClient(1, compatClientID, "Avatar", compatEntityID)
+	})
+	capture("NotifyMapAttrChangeOnClient", func() error {
+		return gwc.SendNotifyMapAttrChangeOnClient(1, compatClientID, compatEntityID, compatPath, "key", "val")
+	})
+	capture("NotifyMapAttrDelOnClient", func() error {
+		return gwc.SendNotifyMapAttrDelOnClient(1, compatClientID, compatEntityID, compatPath, "key")
+	})
+	capture("NotifyMapAttrClearOnClient", func() error {
+		return gwc.SendNotifyMapAttrClearOnClient(1, compatClientID, compatEntityID, compatPath)
+	})
+	capture("NotifyListAttrChangeOnClient", func() error {
+		return gwc.SendNotifyListAttrChangeOnClient(1, compatClientID, compatEntityID, compatPath, 1, "val")
+	})
+	capture("NotifyListAttrPopOnClient", func() error {
+		return gwc.SendNotifyListAttrPopOnClient(1, compatClientID, compatEntityID, compatPath)
+	})
+	capture("NotifyListAttrAppendOnClient", func() error {
+		return gwc.SendNotifyListAttrAppendOnClient(1, compatClientID, compatEntityID, compatPath, "val")
+	})
+	capture("NotifyAttrPatchOnClient", func() error {
+		patch := &AttrPatch{FloatPrecision: 0.01, Ops: []AttrPatchOp{
+			{Op: ATTR_PATCH_MAP_CHANGE, Path: compatPath, Key: "key", Val: 1.5},
+			{Op: ATTR_PATCH_MAP_DEL, Path: compatPath, Key: "key"},
+			{Op: ATTR_PATCH_MAP_CLEAR, Path: compatPath},
+			{Op: ATTR_PATCH_LIST_CHANGE, Path: compatPath, Index: 1, Val: "val"},
+			{Op: ATTR_PATCH_LIST_POP, Path: compatPath},
+			{Op: ATTR_PATCH_LIST_APPEND, Path: compatPath, Val: compatArgs},
+		}}
+		return gwc.SendNotifyAttrPatchOnClient(1, compatClientID, compatEntityID, patch.Encode(nil))
+	})
+	capture("CallEntityMethodOnClient", func() error {
+		return gwc.SendCallEntityMethodOnClient(1, compatClientID, compatEntityID, "Method", compatArgs)
+	})
+	capture("CallEntityMethodOnClientWithDelivery", func() error {
+		return gwc.SendCallEntityMethodOnClientWithDelivery(1, compatClientID, DELIVERY_UNRELIABLE, compatEntityID, "Method", compatArgs)
+	})
+	capture("ReconnectDirective", func() error { return gwc.SendReconnectDirective(time.Second * 5) })
+	capture("SessionTokenChallenge", func() error { return gwc.SendSessionTokenChallenge("token", time.Hour) })
+	capture("SessionTokenResponseFromClient", func() error { return gwc.SendSessionTokenResponseFromClient("token") })
+	capture("ResumeSessionFromClient", func() error { return gwc.SendResumeSessionFromClient("token") })
+	capture("ResumeSessionAck", func() error { return gwc.SendResumeSessionAck(true) })
+	capture("BindClientChannelFromClient", func() error { return gwc.SendBindClientChannelFromClient("token") })
+	capture("BindClientChannelAck", func() error { return gwc.SendBindClientChannelAck(true) })
+	capture("SetClientProtocolVersionFromClient", func() error {
+		return gwc.SendSetClientProtocolVersionFromClient(CLIENT_PROTOCOL_VERSION)
+	})
+	capture("CallEntityMethodWithTypedArgsFromClient", func() error {
+		return gwc.SendCallEntityMethodWithTypedArgsFromClient(compatEntityID, "Method", []byte("typed args"))
+	})
+	capture("SetClientCodecFromClient", func() error {
+		return gwc.SendSetClientCodecFromClient([]string{CLIENT_CODEC_MSGPACK, CLIENT_CODEC_PROTOBUF})
+	})
+	capture("SetClientCodec", func() error { return gwc.SendSetClientCodec(CLIENT_CODEC_PROTOBUF) })
+	capture("SetClientProtocolVersion", func() error { return gwc.SendSetClientProtocolVersion(CLIENT_PROTOCOL_VERSION) })
+	capture("ClientProtocolVersionRejected", func() error {
+		return gwc.SendClientProtocolVersionRejected(CLIENT_PROTOCOL_VERSION_BASE, CLIENT_PROTOCOL_VERSION_ATTR_PATCH, CLIENT_PROTOCOL_VERSION, "https://example.com/update")
+	})
+	capture("SetClientFilterProp", func() error { return gwc.SendSetClientFilterProp(1, compatClientID, "key", "val") })
+	capture("ClearClientFilterProp", func() error { return gwc.SendClearClientFilterProp(1, compatClientID) })
+	capture("SetClientSyncVersion", func() error { return gwc.SendSetClientSyncVersion(1, compatClientID, 1, 1500000000) })
+	capturePacket("CallFilteredClients", AllocCallFilterClientProxiesPacket(FILTER_CLIENTS_OP_EQ, "key", "val", "Method", compatArgs))
+	return corpus
+}
+
+// DumpCompatCorpus writes the compat corpus of current engine version in JSON format
+func DumpCompatCorpus(w io.Writer) error {
+	data, err := json.MarshalIndent(MakeCompatCorpus(), "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// CheckCompatCorpus compares compat corpuses of two engine versions and returns all incompatibilities found
+func CheckCompatCorpus(oldCorpus, newCorpus *CompatCorpus) (incompatibilities []string) {
+	if oldCorpus.ProtocolVersion != newCorpus.ProtocolVersion {
+		incompatibilities = append(incompatibilities, fmt.Sprintf("protocol version changed: %d => %d", oldCorpus.ProtocolVersion, newCorpus.ProtocolVersion))
+	}
+
+	msgTypeNames := make([]string, 0, len(oldCorpus.MsgTypes))
+	for name := range oldCorpus.MsgTypes {
+		msgTypeNames = append(msgTypeNames, name)
+	}
+	sort.Strings(msgTypeNames)
+	for _, name := range msgTypeNames {
+		oldMsgType := oldCorpus.MsgTypes[name]
+		newMsgType, ok := newCorpus.MsgTypes[name]
+		if !ok {
+			incompatibilities = append(incompatibilities, fmt.Sprintf("message type %s is removed", name))
+		} else if oldMsgType != newMsgType {
+			incompatibilities = append(incompatibilities, fmt.Sprintf("message type %s changed: %d => %d", name, oldMsgType, newMsgType))
+		}
+	}
+
+	messageNames := make([]string, 0, len(oldCorpus.Messages))
+	for name := range oldCorpus.Messages {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+	for _, name := range messageNames {
+		newEncoding, ok := newCorpus.Messages[name]
+		if !ok {
+			incompatibilities = append(incompatibilities, fmt.Sprintf("message %s is removed", name))
+		} else if !bytes.Equal(oldCorpus.Messages[name], newEncoding) {
+			incompatibilities = append(incompatibilities, fmt.Sprintf("message %s encoding changed: %x => %x", name, oldCorpus.Messages[name], newEncoding))
+		}
+	}
+	return
+}
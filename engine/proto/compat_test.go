@@ -0,0 +1,43 @@
+package proto
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestCompatCorpusDeterministic(t *testing.T) {
+	var buf bytes.Buffer
+	if err := DumpCompatCorpus(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var oldCorpus CompatCorpus
+	if err := json.Unmarshal(buf.Bytes(), &oldCorpus); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(oldCorpus.Messages) == 0 {
+		t.Fatalf("compat corpus is empty")
+	}
+
+	if problems := CheckCompatCorpus(&oldCorpus, MakeCompatCorpus()); len(problems) != 0 {
+		t.Fatalf("compat corpus is not deterministic: %v", problems)
+	}
+}
+
+func TestCheckCompatCorpus(t *testing.T) {
+	oldCorpus := MakeCompatCorpus()
+	newCorpus := MakeCompatCorpus()
+	newCorpus.MsgTypes["MT_CALL_ENTITY_METHOD"] += 1000
+	delete(newCorpus.MsgTypes, "MT_SET_GATE_ID")
+	for name, encoding := range newCorpus.Messages {
+		newCorpus.Messages[name] = append(encoding, 0)
+		break
+	}
+
+	problems := CheckCompatCorpus(oldCorpus, newCorpus)
+	if len(problems) != 3 {
+		t.Fatalf("should find 3 incompatibilities, but found: %v", problems)
+	}
+}
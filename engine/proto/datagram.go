@@ -0,0 +1,81 @@
+package proto
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Datagrams
+//
+// Gates send DELIVERY_UNRELIABLE messages to KCP clients which announce CLIENT_PROTOCOL_VERSION_DATAGRAM in UDP datagrams
+// on the socket of the KCP session instead of the KCP stream, so that they are never retransmitted, and never delay or
+// are delayed by lost segments of the stream. Each datagram is the datagram header followed by the payload of one
+// natively encoded packet, which starts with the message type. The header looks like a KCP FEC header with a flag which
+// is neither data nor parity, so KCP sessions which do not read datagrams drop them.
+
+const (
+	// DATAGRAM_HEADER_SIZE is the size of the header of datagrams
+	DATAGRAM_HEADER_SIZE = 6
+	// DATAGRAM_MAX_SIZE is the max size of datagrams, larger messages are sent in the KCP stream
+	DATAGRAM_MAX_SIZE = 1200
+
+	datagramMagic = 0x67647767 // "gwdg"
+	datagramFlag  = 0xf3       // KCP FEC flags of data and parity are 0xf1 and 0xf2
+)
+
+// AppendDatagram appends the datagram of the packet payload to buf
+func AppendDatagram(buf []byte, payload []byte) []byte {
+	var header [DATAGRAM_HEADER_SIZE]byte
+	binary.LittleEndian.PutUint32(header[:], datagramMagic)
+	binary.LittleEndian.PutUint16(header[4:], datagramFlag)
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// ParseDatagram returns the message type and the packet in the datagram, or false if it is not a datagram of gates
+//
+// The read position of the packet is after the message type, and the packet should be released by the caller.
+func ParseDatagram(datagram []byte) (MsgType, *netutil.Packet, bool) {
+	if len(datagram) < DATAGRAM_HEADER_SIZE+2 || binary.LittleEndian.Uint32(datagram) != datagramMagic ||
+		binary.LittleEndian.Uint16(datagram[4:]) != datagramFlag {
+		return 0, nil, false
+	}
+
+	packet := netutil.NewPacket()
+	packet.AppendBytes(datagram[DATAGRAM_HEADER_SIZE:])
+	return MsgType(packet.ReadUint16()), packet, true
+}
+
+// DatagramHandler handles messages received in datagrams
+type DatagramHandler func(msgtype MsgType, packet *netutil.Packet)
+
+// DatagramPacketConn reads datagrams of gates out of the packet connection of a KCP client session
+//
+// Datagrams are passed to the handler in the read routine of the KCP session, other packets are read by the session.
+type DatagramPacketConn struct {
+	net.PacketConn
+	handler DatagramHandler
+}
+
+// NewDatagramPacketConn creates the DatagramPacketConn, which should be passed to kcp.NewConn for the client session
+func NewDatagramPacketConn(conn net.PacketConn, handler DatagramHandler) *DatagramPacketConn {
+	return &DatagramPacketConn{PacketConn: conn, handler: handler}
+}
+
+// ReadFrom reads the next packet which is not a datagram of gates
+func (c *DatagramPacketConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	for {
+		n, addr, err = c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+
+		if msgtype, packet, ok := ParseDatagram(b[:n]); ok {
+			c.handler(msgtype, packet)
+			continue
+		}
+		return
+	}
+}
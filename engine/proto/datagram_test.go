@@ -0,0 +1,68 @@
+package proto
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func TestParseDatagram(t *testing.T) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_ON_CLIENT)
+	packet.AppendVarStr("Method")
+	datagram := AppendDatagram(nil, packet.Payload())
+	packet.Release()
+
+	msgtype, parsed, ok := ParseDatagram(datagram)
+	if !ok || msgtype != MT_CALL_ENTITY_METHOD_ON_CLIENT || parsed.ReadVarStr() != "Method" {
+		t.Fatalf("parse datagram failed")
+	}
+	parsed.Release()
+
+	// KCP segments with FEC headers are not datagrams
+	segment := make([]byte, 32)
+	segment[4] = 0xf1
+	if _, _, ok := ParseDatagram(segment); ok {
+		t.Fatalf("KCP segment should not be parsed as datagram")
+	}
+}
+
+func TestDatagramPacketConn(t *testing.T) {
+	gateConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gateConn.Close()
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received []MsgType
+	conn := NewDatagramPacketConn(clientConn, func(msgtype MsgType, packet *netutil.Packet) {
+		received = append(received, msgtype)
+		packet.Release()
+	})
+	defer conn.Close()
+
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_ON_CLIENT)
+	gateConn.WriteTo(AppendDatagram(nil, packet.Payload()), clientConn.LocalAddr())
+	packet.Release()
+	gateConn.WriteTo([]byte("kcp segment"), clientConn.LocalAddr())
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "kcp segment" {
+		t.Fatalf("packets other than datagrams should be read: %q", buf[:n])
+	}
+	if len(received) != 1 || received[0] != MT_CALL_ENTITY_METHOD_ON_CLIENT {
+		t.Fatalf("datagram should be handled: %v", received)
+	}
+}
@@ -0,0 +1,177 @@
+package proto
+
+// RPCSource identifies a sequence of entity RPCs: calls sent by one game through one dispatcher in one connection epoch
+type RPCSource struct {
+	GameID       uint16
+	DispatcherID uint16
+	Epoch        uint32 // increased each time the game reconnects to the dispatcher
+}
+
+type rpcSender struct {
+	GameID       uint16
+	DispatcherID uint16
+}
+
+// rpcWindow remembers sequence numbers of RPCs applied from one source, which may still be replayed by the sender
+type rpcWindow struct {
+	applied map[uint64]struct{}
+	order   []uint64 // applied sequence numbers in the order of arrival, for forgetting acked ones
+}
+
+func (w *rpcWindow) forget(ackFloor uint64) {
+	i := 0
+	for i < len(w.order) && w.order[i] < ackFloor {
+		delete(w.applied, w.order[i])
+		i += 1
+	}
+	w.order = w.order[i:]
+}
+
+// RPCDedup drops entity RPCs which are replayed by senders after reconnection but are applied before
+//
+// Senders number RPCs of each dispatcher with increasing sequence numbers and keep RPCs until receivers ack them.
+// When a sender reconnects to the dispatcher, unacked RPCs are sent again, some of which may be applied already. So
+// receivers remember sequence numbers of applied RPCs, and ack them to senders. Each RPC carries the ack floor of the
+// sender (the lowest unacked sequence number), RPCs below which are never replayed and are forgotten by receivers.
+// Calls are never dropped for being old (e.g. held by the dispatcher while the target entity is loading or migrating),
+// only replays of calls which are applied are.
+//
+// Each entity has its own RPCDedup, which is dumped into the migrate data and restored on the game the entity migrates
+// to, so that replays of calls applied before migration are dropped there. Acks are collected by RPCAcks of the game.
+type RPCDedup struct {
+	windows   map[rpcSender]map[uint32]*rpcWindow // windows of sources by epochs
+	ackFloors map[rpcSender]uint64
+}
+
+// RPCWindowState is the dumped window of applied RPCs from one source
+type RPCWindowState struct {
+	GameID       uint16   `msgpack:"G"`
+	DispatcherID uint16   `msgpack:"D"`
+	Epoch        uint32   `msgpack:"E"`
+	AckFloor     uint64   `msgpack:"F"`
+	Applied      []uint64 `msgpack:"A"` // in the order of arrival
+}
+
+// NewRPCDedup creates a new RPCDedup
+func NewRPCDedup() *RPCDedup {
+	return &RPCDedup{
+		windows:   map[rpcSender]map[uint32]*rpcWindow{},
+		ackFloors: map[rpcSender]uint64{},
+	}
+}
+
+// RestoreRPCDedup restores the RPCDedup from windows dumped by Dump
+func RestoreRPCDedup(states []RPCWindowState) *RPCDedup {
+	dd := NewRPCDedup()
+	for _, state := range states {
+		sender := rpcSender{state.GameID, state.DispatcherID}
+		if state.AckFloor > dd.ackFloors[sender] {
+			dd.ackFloors[sender] = state.AckFloor
+		}
+		w := dd.window(sender, state.Epoch)
+		for _, seq := range state.Applied {
+			if _, ok := w.applied[seq]; !ok {
+				w.applied[seq] = struct{}{}
+				w.order = append(w.order, seq)
+			}
+		}
+	}
+	return dd
+}
+
+func (dd *RPCDedup) window(sender rpcSender, epoch uint32) *rpcWindow {
+	epochs := dd.windows[sender]
+	if epochs == nil {
+		epochs = map[uint32]*rpcWindow{}
+		dd.windows[sender] = epochs
+	}
+	w := epochs[epoch]
+	if w == nil {
+		w = &rpcWindow{applied: map[uint64]struct{}{}}
+		epochs[epoch] = w
+	}
+	return w
+}
+
+// Accept returns if the RPC with the sequence number should be applied, or false if it is a replay of an applied RPC
+func (dd *RPCDedup) Accept(src RPCSource, seq uint64, ackFloor uint64) bool {
+	sender := rpcSender{src.GameID, src.DispatcherID}
+	if ackFloor > dd.ackFloors[sender] {
+		dd.ackFloors[sender] = ackFloor
+		for epoch, w := range dd.windows[sender] {
+			w.forget(ackFloor)
+			if len(w.applied) == 0 && epoch != src.Epoch {
+				delete(dd.windows[sender], epoch)
+			}
+		}
+	}
+
+	w := dd.window(sender, src.Epoch)
+	if _, ok := w.applied[seq]; ok {
+		return false
+	}
+	w.applied[seq] = struct{}{}
+	w.order = append(w.order, seq)
+	return true
+}
+
+// Dump returns windows of applied RPCs which are not acked yet
+func (dd *RPCDedup) Dump() (states []RPCWindowState) {
+	for sender, epochs := range dd.windows {
+		ackFloor := dd.ackFloors[sender]
+		for epoch, w := range epochs {
+			var applied []uint64
+			for _, seq := range w.order {
+				if seq >= ackFloor { // calls below the ack floor are never replayed
+					applied = append(applied, seq)
+				}
+			}
+			if len(applied) == 0 {
+				continue
+			}
+			states = append(states, RPCWindowState{
+				GameID:       sender.GameID,
+				DispatcherID: sender.DispatcherID,
+				Epoch:        epoch,
+				AckFloor:     ackFloor,
+				Applied:      applied,
+			})
+		}
+	}
+	return
+}
+
+// RememberedRPCs returns the number of applied RPCs remembered for deduplication
+func (dd *RPCDedup) RememberedRPCs() (n int) {
+	for _, epochs := range dd.windows {
+		for _, w := range epochs {
+			n += len(w.applied)
+		}
+	}
+	return
+}
+
+// RPCAcks collects sequence numbers of RPCs received from each source, to be acked to senders
+type RPCAcks struct {
+	acks map[RPCSource][]uint64
+}
+
+// NewRPCAcks creates a new RPCAcks
+func NewRPCAcks() *RPCAcks {
+	return &RPCAcks{acks: map[RPCSource][]uint64{}}
+}
+
+// Add adds the sequence number of the RPC received from the source, replays should also be acked since the ack might be lost
+func (ra *RPCAcks) Add(src RPCSource, seq uint64) {
+	ra.acks[src] = append(ra.acks[src], seq)
+}
+
+// Take returns sequence numbers of RPCs received from each source since the last call
+func (ra *RPCAcks) Take() map[RPCSource][]uint64 {
+	if len(ra.acks) == 0 {
+		return nil
+	}
+	acks := ra.acks
+	ra.acks = map[RPCSource][]uint64{}
+	return acks
+}
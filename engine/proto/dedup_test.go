@@ -0,0 +1,130 @@
+package proto
+
+import "testing"
+
+func TestRPCDedup(t *testing.T) {
+	dd := NewRPCDedup()
+	src := RPCSource{GameID: 1, DispatcherID: 1}
+	base := uint64(1000000)
+
+	for seq := base; seq < base+10; seq++ {
+		if !dd.Accept(src, seq, base) {
+			t.Fatalf("seq %d should be accepted", seq)
+		}
+	}
+	for seq := base; seq < base+10; seq++ {
+		if dd.Accept(src, seq, base) {
+			t.Fatalf("replayed seq %d should be dropped", seq)
+		}
+	}
+
+	// other sources are not affected
+	if !dd.Accept(RPCSource{GameID: 2, DispatcherID: 1}, base, base) {
+		t.Fatalf("seq of another game should be accepted")
+	}
+	if !dd.Accept(RPCSource{GameID: 1, DispatcherID: 2}, base, base) {
+		t.Fatalf("seq of another dispatcher should be accepted")
+	}
+
+	// out of order arrival
+	if !dd.Accept(src, base+20, base) {
+		t.Fatalf("seq %d should be accepted", base+20)
+	}
+	if !dd.Accept(src, base+15, base) {
+		t.Fatalf("out of order seq %d should be accepted", base+15)
+	}
+	if dd.Accept(src, base+15, base) {
+		t.Fatalf("replayed seq %d should be dropped", base+15)
+	}
+}
+
+func TestRPCDedupHeldCall(t *testing.T) {
+	dd := NewRPCDedup()
+	src := RPCSource{GameID: 1, DispatcherID: 1}
+	base := uint64(1000000)
+
+	// the call of base is held by the dispatcher (e.g. the entity is migrating) while later calls are applied, so the
+	// ack floor of the sender stays at base
+	for seq := base + 1; seq < base+5000; seq++ {
+		if !dd.Accept(src, seq, base) {
+			t.Fatalf("seq %d should be accepted", seq)
+		}
+	}
+	if !dd.Accept(src, base, base) {
+		t.Fatalf("held call should be accepted")
+	}
+	if dd.Accept(src, base, base) {
+		t.Fatalf("replayed held call should be dropped")
+	}
+}
+
+func TestRPCDedupEpochs(t *testing.T) {
+	dd := NewRPCDedup()
+	old := RPCSource{GameID: 1, DispatcherID: 1, Epoch: 1}
+	cur := RPCSource{GameID: 1, DispatcherID: 1, Epoch: 2}
+	base := uint64(1000000)
+
+	for seq := base; seq < base+10; seq++ {
+		dd.Accept(old, seq, base)
+	}
+	// after reconnection, unacked calls are replayed in the old epoch, and new calls are in the new epoch
+	if !dd.Accept(cur, base+10, base+5) {
+		t.Fatalf("call in the new epoch should be accepted")
+	}
+	if dd.Accept(old, base+7, base+5) {
+		t.Fatalf("replay of applied call should be dropped")
+	}
+	if dd.RememberedRPCs() != 6 {
+		t.Fatalf("acked calls should be forgotten, but %d calls are remembered", dd.RememberedRPCs())
+	}
+
+	// all calls of the old epoch are acked
+	dd.Accept(cur, base+11, base+10)
+	if dd.RememberedRPCs() != 2 {
+		t.Fatalf("acked calls should be forgotten, but %d calls are remembered", dd.RememberedRPCs())
+	}
+}
+
+func TestRPCDedupDump(t *testing.T) {
+	dd := NewRPCDedup()
+	old := RPCSource{GameID: 1, DispatcherID: 1, Epoch: 1}
+	cur := RPCSource{GameID: 1, DispatcherID: 1, Epoch: 2}
+	base := uint64(1000000)
+	for seq := base; seq < base+10; seq++ {
+		dd.Accept(old, seq, base+5)
+	}
+	dd.Accept(cur, base+10, base+5)
+
+	restored := RestoreRPCDedup(dd.Dump())
+	if restored.RememberedRPCs() != 6 {
+		t.Fatalf("unacked calls should be restored, but %d calls are remembered", restored.RememberedRPCs())
+	}
+	if restored.Accept(old, base+7, base+5) || restored.Accept(cur, base+10, base+5) {
+		t.Fatalf("replay of applied call should be dropped after restored")
+	}
+	if !restored.Accept(cur, base+11, base+5) {
+		t.Fatalf("new call should be accepted after restored")
+	}
+
+	// the ack floor is restored, so calls acked before are forgotten
+	restored.Accept(cur, base+12, base+11)
+	if restored.RememberedRPCs() != 2 {
+		t.Fatalf("acked calls should be forgotten, but %d calls are remembered", restored.RememberedRPCs())
+	}
+}
+
+func TestRPCAcks(t *testing.T) {
+	ra := NewRPCAcks()
+	src := RPCSource{GameID: 1, DispatcherID: 1}
+	ra.Add(src, 1)
+	ra.Add(src, 2)
+	ra.Add(src, 2) // replays are acked again
+
+	acks := ra.Take()
+	if len(acks[src]) != 3 || acks[src][0] != 1 || acks[src][1] != 2 || acks[src][2] != 2 {
+		t.Fatalf("wrong acks: %v", acks)
+	}
+	if acks := ra.Take(); acks != nil {
+		t.Fatalf("acks should be taken: %v", acks)
+	}
+}
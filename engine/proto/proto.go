@@ -73,8 +73,56 @@ const (
 	MT_NOTIFY_DEPLOYMENT_READY
 	// MT_GAME_LBC_INFO contains game load balacing info
 	MT_GAME_LBC_INFO
+	// MT_CALL_ENTITY_METHOD_WITH_SEQ is a message type for calling entity methods with sequence numbers for deduplication
+	MT_CALL_ENTITY_METHOD_WITH_SEQ
+	// MT_RECONCILE_ENTITIES is sent by game to dispatcher with all entities on the game for reconciliation
+	MT_RECONCILE_ENTITIES
+	// MT_DESTROY_STALE_ENTITIES is sent by dispatcher to game to destroy stale copies of entities found by reconciliation
+	MT_DESTROY_STALE_ENTITIES
+	// MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT is a message type for clients to sync position & yaw with input sequence number
+	MT_SYNC_POSITION_YAW_WITH_SEQ_FROM_CLIENT
+	// MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT is a message type for clients to call entity methods with input sequence number
+	MT_CALL_ENTITY_METHOD_WITH_SEQ_FROM_CLIENT
+	// MT_NOTIFY_CLIENT_RESUMED is sent by gate to the owner entity when a disconnected client resumes its session
+	MT_NOTIFY_CLIENT_RESUMED
+	// MT_SET_STANDBY is sent by a standby dispatcher to the active dispatcher to start mirroring routing state
+	MT_SET_STANDBY
+	// MT_SYNC_ROUTING_TO_STANDBY is sent by the active dispatcher to the standby dispatcher with changed routing state
+	MT_SYNC_ROUTING_TO_STANDBY
+	// MT_SYNC_SERVICE_SNAPSHOT is sent by the game of a service entity to the standby game of the service through the dispatcher of the entity
+	MT_SYNC_SERVICE_SNAPSHOT
+	// MT_CREATE_ENTITY_ANYWHERE_QUEUED is sent by game to create entity anywhere, which is queued by dispatcher if all games are overloaded
+	MT_CREATE_ENTITY_ANYWHERE_QUEUED
+	// MT_CREATE_ENTITY_REJECTED is sent by dispatcher to the game which requested a queued creation if the creation is rejected
+	MT_CREATE_ENTITY_REJECTED
+	// MT_AUTO_MIGRATE_ENTITIES is sent by dispatcher to a hot game to migrate auto-migratable entities to a cold game
+	MT_AUTO_MIGRATE_ENTITIES
+	// MT_SET_GAME_DRAINING is sent by a draining game to all dispatchers to stop placing entities on the game
+	MT_SET_GAME_DRAINING
+	// MT_CALL_ENTITY_METHOD_WITH_RESULT is a message type for calling entity methods, results are sent back to the calling game
+	MT_CALL_ENTITY_METHOD_WITH_RESULT
+	// MT_CALL_ENTITY_METHOD_RESULT is sent to the calling game with results or error of MT_CALL_ENTITY_METHOD_WITH_RESULT
+	MT_CALL_ENTITY_METHOD_RESULT
+	// MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT is a message type for clients to call entity methods with arguments in a protobuf message
+	MT_CALL_ENTITY_METHOD_WITH_TYPED_ARGS_FROM_CLIENT
+	// MT_PUBLISH_CHANNEL is sent to the dispatcher of the channel, which broadcasts it to all games for calling subscribers
+	MT_PUBLISH_CHANNEL
+	// MT_CLAIM_CRON_JOB is sent by games to the dispatcher of the cron job when it is due, and sent back to the first game claiming the run
+	MT_CLAIM_CRON_JOB
+	// MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION is sent by gate to the owner entity when a disconnected client resumes its session, with the last sync version delivered to the client
+	MT_NOTIFY_CLIENT_RESUMED_WITH_SYNC_VERSION
+	// MT_CLOCK_SYNC is sent by games and gates to dispatchers with their clocks for clock skew detection
+	MT_CLOCK_SYNC
+	// MT_CLOCK_SYNC_ACK is sent back by dispatchers for MT_CLOCK_SYNC with their clocks
+	MT_CLOCK_SYNC_ACK
+	// MT_ACK_ENTITY_RPCS is sent by games to the game sending MT_CALL_ENTITY_METHOD_WITH_SEQ to ack applied RPCs
+	MT_ACK_ENTITY_RPCS
 )
 
+// MT_TRACE_CONTEXT_FLAG is set in the message type of packets which are followed by span contexts at the end of
+// payloads, see InjectTraceContext
+const MT_TRACE_CONTEXT_FLAG MsgType = 0x8000
+
 // Alias message types
 const (
 	// MT_MIGRATE_REQUEST_ACK is a message type for entity migrations
@@ -109,6 +157,10 @@ const (
 	MT_CLEAR_CLIENTPROXY_FILTER_PROPS
 	// MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT message type
 	MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT
+	// MT_NOTIFY_ATTR_PATCH_ON_CLIENT message type: attribute changes of an entity encoded as AttrPatch
+	MT_NOTIFY_ATTR_PATCH_ON_CLIENT
+	// MT_SET_CLIENT_SYNC_VERSION message type: changes up to the sync version are sent to the client, recorded by gate but not redirected to the client
+	MT_SET_CLIENT_SYNC_VERSION
 	// MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP message type
 	MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP = 1499
 )
@@ -116,8 +168,12 @@ const (
 const (
 	// MT_CALL_FILTERED_CLIENTS message type: messages to be processed by GateService from Dispatcher, but not redirected to clients
 	MT_CALL_FILTERED_CLIENTS = 1501 + iota
-	// MT_SYNC_POSITION_YAW_ON_CLIENTS message type
+	// MT_SYNC_POSITION_YAW_ON_CLIENTS message type: server tick and server time followed by sync infos of entities
 	MT_SYNC_POSITION_YAW_ON_CLIENTS
+	// MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY message type: converted to MT_CALL_ENTITY_METHOD_ON_CLIENT by gate
+	MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY
+	// MT_SYNC_INPUT_ACK_ON_CLIENTS message type: acknowledges the last processed input sequence numbers with authoritative position & yaw
+	MT_SYNC_INPUT_ACK_ON_CLIENTS
 	// MT_GATE_SERVICE_MSG_TYPE_STOP message type
 	MT_GATE_SERVICE_MSG_TYPE_STOP = 1999
 )
@@ -130,12 +186,58 @@ const (
 	MT_UDP_SYNC_CONN_NOTIFY_CLIENTID_ACK
 	// MT_HEARTBEAT_FROM_CLIENT is sent by client to notify the gate server that the client is alive
 	MT_HEARTBEAT_FROM_CLIENT
+	// MT_RECONNECT_DIRECTIVE is sent to client before disconnecting, to tell the client how long to wait before reconnecting
+	MT_RECONNECT_DIRECTIVE
+	// MT_SESSION_TOKEN_CHALLENGE is sent to client with a challenge, which should be signed by the auth service and sent back before the current token expires
+	MT_SESSION_TOKEN_CHALLENGE
+	// MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT is sent by client with the new session token signed by the auth service
+	MT_SESSION_TOKEN_RESPONSE_FROM_CLIENT
+	// MT_RESUME_SESSION_FROM_CLIENT is sent by client with its session token to resume the session after reconnecting
+	MT_RESUME_SESSION_FROM_CLIENT
+	// MT_RESUME_SESSION_ACK is sent to client with the result of resuming session
+	MT_RESUME_SESSION_ACK
+	// MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT is sent by client to announce the client protocol version it supports
+	MT_SET_CLIENT_PROTOCOL_VERSION_FROM_CLIENT
+	// MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS is sent to client instead of MT_SYNC_POSITION_YAW_ON_CLIENTS with quantized sync infos
+	MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS
+	// MT_SET_CLIENT_CODEC_FROM_CLIENT is sent by client with codecs it supports to negotiate the codec of messages
+	MT_SET_CLIENT_CODEC_FROM_CLIENT
+	// MT_SET_CLIENT_CODEC is sent to client with the codec of following messages
+	MT_SET_CLIENT_CODEC
+	// MT_SET_CLIENT_PROTOCOL_VERSION is sent to clients of CLIENT_PROTOCOL_VERSION_NEGOTIATION or later with the protocol version selected by the gate
+	MT_SET_CLIENT_PROTOCOL_VERSION
+	// MT_CLIENT_PROTOCOL_VERSION_REJECTED is sent to client before disconnecting if its protocol version is not supported by the gate
+	MT_CLIENT_PROTOCOL_VERSION_REJECTED
+	// MT_BIND_CLIENT_CHANNEL_FROM_CLIENT is sent by client on an additional connection with its session token, to bind the connection as the channel of DELIVERY_UNORDERED_RELIABLE messages
+	MT_BIND_CLIENT_CHANNEL_FROM_CLIENT
+	// MT_BIND_CLIENT_CHANNEL_ACK is sent to client on the additional connection with the result of binding the channel
+	MT_BIND_CLIENT_CHANNEL_ACK
+)
+
+// Client protocol versions
+const (
+	// CLIENT_PROTOCOL_VERSION_BASE is the version of clients which do not announce their versions
+	CLIENT_PROTOCOL_VERSION_BASE = 0
+	// CLIENT_PROTOCOL_VERSION_ATTR_PATCH supports MT_NOTIFY_ATTR_PATCH_ON_CLIENT
+	CLIENT_PROTOCOL_VERSION_ATTR_PATCH = 1
+	// CLIENT_PROTOCOL_VERSION_QUANTIZED_SYNC supports MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS
+	CLIENT_PROTOCOL_VERSION_QUANTIZED_SYNC = 2
+	// CLIENT_PROTOCOL_VERSION_NEGOTIATION supports MT_SET_CLIENT_PROTOCOL_VERSION and MT_CLIENT_PROTOCOL_VERSION_REJECTED
+	CLIENT_PROTOCOL_VERSION_NEGOTIATION = 3
+	// CLIENT_PROTOCOL_VERSION_DATAGRAM supports DELIVERY_UNRELIABLE messages sent in datagrams to KCP clients, see ParseDatagram
+	CLIENT_PROTOCOL_VERSION_DATAGRAM = 4
+	// CLIENT_PROTOCOL_VERSION is the latest client protocol version
+	CLIENT_PROTOCOL_VERSION = CLIENT_PROTOCOL_VERSION_DATAGRAM
 )
 
 const (
 	// SYNC_INFO_SIZE_PER_ENTITY is the size of sync info per entity
 	SYNC_INFO_SIZE_PER_ENTITY = 16
 	UDP_SYNC_PACKET_SIZE      = common.ENTITYID_LENGTH + SYNC_INFO_SIZE_PER_ENTITY
+	// SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY is the size of input sequence number and sync info per entity
+	SYNC_INFO_WITH_SEQ_SIZE_PER_ENTITY = 4 + SYNC_INFO_SIZE_PER_ENTITY
+	// SYNC_HEADER_SIZE is the size of server tick (uint32) and server time in milliseconds (uint64) before sync infos of entities
+	SYNC_HEADER_SIZE = 4 + 8
 )
 
 // Operators for calling filtered clients
@@ -150,6 +252,20 @@ const (
 	FILTER_CLIENTS_OP_LTE
 )
 
+// DeliveryClass is the delivery class of client-bound messages
+type DeliveryClass byte
+
+const (
+	// DELIVERY_ORDERED_RELIABLE messages are delivered reliably and in order (default)
+	DELIVERY_ORDERED_RELIABLE DeliveryClass = iota
+	// DELIVERY_UNORDERED_RELIABLE messages are delivered reliably, but out of order with other messages if the client binds
+	// a channel connection by MT_BIND_CLIENT_CHANNEL_FROM_CLIENT
+	DELIVERY_UNORDERED_RELIABLE
+	// DELIVERY_UNRELIABLE messages are sent in datagrams to KCP clients supporting CLIENT_PROTOCOL_VERSION_DATAGRAM, or
+	// dropped by gates when the client connection is congested otherwise
+	DELIVERY_UNRELIABLE
+)
+
 // EntitySyncInfo defines fields of entity sync info
 type EntitySyncInfo struct {
 	X, Y, Z float32
@@ -164,5 +280,7 @@ func init() {
 
 // GameLBCInfo defines the info for game load balancing
 type GameLBCInfo struct {
-	CPUPercent float64 `msgpack:"cp"`
+	CPUPercent    float64 `msgpack:"cp"`
+	EntityCount   int     `msgpack:"ec,omitempty"` // number of entities on the game
+	PacketBacklog int     `msgpack:"pb,omitempty"` // number of packets waiting to be handled by the game
 }
@@ -0,0 +1,309 @@
+package proto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Protobuf wire format
+//
+// Messages of the protobuf client codec are encoded to and decoded from the protobuf wire format by hand, so the engine
+// does not depend on any protobuf runtime. Dynamic values such as attributes and RPC arguments are encoded as Value
+// messages defined in goworld_client.proto.
+
+// protobuf wire types
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// fields of Value
+const (
+	pbValueNull = 1 + iota
+	pbValueBool
+	pbValueInt
+	pbValueUint
+	pbValueDouble
+	pbValueString
+	pbValueBytes
+	pbValueList
+	pbValueMap
+)
+
+// pbMaxValueDepth is the max depth of nested lists and maps in Value received from clients
+const pbMaxValueDepth = 32
+
+func pbAppendTag(buf []byte, field int, wireType int) []byte {
+	return appendUvarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func pbAppendUint(buf []byte, field int, v uint64) []byte {
+	buf = pbAppendTag(buf, field, pbVarint)
+	return appendUvarint(buf, v)
+}
+
+func pbAppendSint(buf []byte, field int, v int64) []byte {
+	return pbAppendUint(buf, field, uint64(v<<1)^uint64(v>>63)) // zigzag
+}
+
+func pbAppendBool(buf []byte, field int, v bool) []byte {
+	if v {
+		return pbAppendUint(buf, field, 1)
+	}
+	return pbAppendUint(buf, field, 0)
+}
+
+func pbAppendFloat(buf []byte, field int, v float32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	buf = pbAppendTag(buf, field, pbFixed32)
+	return append(buf, b[:]...)
+}
+
+func pbAppendDouble(buf []byte, field int, v float64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	buf = pbAppendTag(buf, field, pbFixed64)
+	return append(buf, b[:]...)
+}
+
+func pbAppendBytes(buf []byte, field int, v []byte) []byte {
+	buf = pbAppendTag(buf, field, pbBytes)
+	buf = appendUvarint(buf, uint64(len(v)))
+	return append(buf, v...)
+}
+
+func pbAppendString(buf []byte, field int, v string) []byte {
+	buf = pbAppendTag(buf, field, pbBytes)
+	return appendVarStr(buf, v)
+}
+
+// pbAppendValue appends v as a Value message
+func pbAppendValue(buf []byte, field int, v interface{}) []byte {
+	return pbAppendBytes(buf, field, pbEncodeValue(nil, v))
+}
+
+// pbAppendValues appends items of the list as repeated Value messages
+func pbAppendValues(buf []byte, field int, list interface{}) []byte {
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		buf = pbAppendValue(buf, field, item)
+	}
+	return buf
+}
+
+// pbEncodeValue appends fields of the Value message of v, and panics if v can not be packed
+func pbEncodeValue(buf []byte, v interface{}) []byte {
+	if i, ok := toInt64(v); ok {
+		return pbAppendSint(buf, pbValueInt, i)
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return pbAppendBool(buf, pbValueNull, true)
+	case bool:
+		return pbAppendBool(buf, pbValueBool, v)
+	case uint:
+		return pbEncodeValue(buf, uint64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			return pbAppendUint(buf, pbValueUint, v)
+		}
+		return pbAppendSint(buf, pbValueInt, int64(v))
+	case float32:
+		return pbAppendDouble(buf, pbValueDouble, float64(v))
+	case float64:
+		return pbAppendDouble(buf, pbValueDouble, v)
+	case string:
+		return pbAppendString(buf, pbValueString, v)
+	case []byte:
+		return pbAppendBytes(buf, pbValueBytes, v)
+	case []interface{}:
+		var list []byte
+		for _, item := range v {
+			list = pbAppendValue(list, 1, item)
+		}
+		return pbAppendBytes(buf, pbValueList, list)
+	case map[string]interface{}:
+		var m []byte
+		for key, val := range v {
+			m = pbAppendMapEntry(m, key, val)
+		}
+		return pbAppendBytes(buf, pbValueMap, m)
+	case map[interface{}]interface{}:
+		var m []byte
+		for key, val := range v {
+			m = pbAppendMapEntry(m, fmt.Sprint(key), val)
+		}
+		return pbAppendBytes(buf, pbValueMap, m)
+	default:
+		// values of other types (structs, typed slices & maps, etc.) are converted to generic values by msgpack
+		data, err := netutil.MSG_PACKER.PackMsg(v, nil)
+		if err != nil {
+			panic(errors.Wrapf(err, "pack %T failed", v))
+		}
+		var generic interface{}
+		if err := netutil.MSG_PACKER.UnpackMsg(data, &generic); err != nil {
+			panic(errors.Wrapf(err, "unpack %T failed", v))
+		}
+		return pbEncodeValue(buf, generic)
+	}
+}
+
+func pbAppendMapEntry(buf []byte, key string, val interface{}) []byte {
+	entry := pbAppendString(nil, 1, key)
+	entry = pbAppendValue(entry, 2, val)
+	return pbAppendBytes(buf, 1, entry)
+}
+
+// pbField is a field of protobuf messages
+type pbField struct {
+	num      int
+	wireType int
+	u        uint64 // value of varint, fixed32 and fixed64 fields
+	b        []byte // value of length-delimited fields, which refers to the message data
+}
+
+func (f *pbField) sint() int64 {
+	return int64(f.u>>1) ^ -int64(f.u&1)
+}
+
+func (f *pbField) bool() bool {
+	return f.u != 0
+}
+
+func (f *pbField) float() float32 {
+	return math.Float32frombits(uint32(f.u))
+}
+
+func (f *pbField) double() float64 {
+	return math.Float64frombits(f.u)
+}
+
+func (f *pbField) string() string {
+	return string(f.b)
+}
+
+// pbDecode calls visit with each field of the protobuf message
+func pbDecode(data []byte, visit func(f *pbField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return errors.New("protobuf: invalid field tag")
+		}
+		data = data[n:]
+
+		f := pbField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case pbVarint:
+			if f.u, n = binary.Uvarint(data); n <= 0 {
+				return errors.Errorf("protobuf: invalid varint of field %d", f.num)
+			}
+			data = data[n:]
+		case pbFixed64:
+			if len(data) < 8 {
+				return errors.Errorf("protobuf: unexpected end of field %d", f.num)
+			}
+			f.u = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case pbFixed32:
+			if len(data) < 4 {
+				return errors.Errorf("protobuf: unexpected end of field %d", f.num)
+			}
+			f.u = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case pbBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errors.Errorf("protobuf: unexpected end of field %d", f.num)
+			}
+			f.b = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return errors.Errorf("protobuf: unsupported wire type %d of field %d", f.wireType, f.num)
+		}
+
+		if err := visit(&f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pbDecodeValue decodes the Value message to the value as unpacked by msgpack
+func pbDecodeValue(data []byte, depth int) (v interface{}, err error) {
+	if depth > pbMaxValueDepth {
+		return nil, errors.New("protobuf: value is nested too deep")
+	}
+
+	err = pbDecode(data, func(f *pbField) error {
+		var err error
+		switch f.num {
+		case pbValueNull:
+			v = nil
+		case pbValueBool:
+			v = f.bool()
+		case pbValueInt:
+			v = f.sint()
+		case pbValueUint:
+			v = f.u
+		case pbValueDouble:
+			v = f.double()
+		case pbValueString:
+			v = f.string()
+		case pbValueBytes:
+			v = append([]byte{}, f.b...)
+		case pbValueList:
+			list := []interface{}{}
+			err = pbDecode(f.b, func(item *pbField) error {
+				if item.num != 1 {
+					return nil
+				}
+				val, err := pbDecodeValue(item.b, depth+1)
+				list = append(list, val)
+				return err
+			})
+			v = list
+		case pbValueMap:
+			m := map[string]interface{}{}
+			err = pbDecode(f.b, func(entry *pbField) error {
+				if entry.num != 1 {
+					return nil
+				}
+				key, val, err := pbDecodeMapEntry(entry.b, depth+1)
+				m[key] = val
+				return err
+			})
+			v = m
+		}
+		return err
+	})
+	return
+}
+
+func pbDecodeMapEntry(data []byte, depth int) (key string, val interface{}, err error) {
+	err = pbDecode(data, func(f *pbField) error {
+		var err error
+		if f.num == 1 {
+			key = f.string()
+		} else if f.num == 2 {
+			val, err = pbDecodeValue(f.b, depth)
+		}
+		return err
+	})
+	return
+}
+
+func pbEntityID(s string) (common.EntityID, error) {
+	if len(s) != common.ENTITYID_LENGTH {
+		return "", errors.Errorf("protobuf: invalid entity ID: %q", s)
+	}
+	return common.EntityID(s), nil
+}
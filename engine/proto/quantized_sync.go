@@ -0,0 +1,115 @@
+package proto
+
+import (
+	"math"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Quantized sync infos
+//
+// Gates send MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS instead of MT_SYNC_POSITION_YAW_ON_CLIENTS to clients which announce
+// CLIENT_PROTOCOL_VERSION_QUANTIZED_SYNC if quantization is enabled. Positions are encoded as int16 offsets in multiples
+// of the precision from the origin of the packet, and yaws are encoded as uint16 fractions of 360 degrees. The payload is
+// the sync header, the origin (3 float32), the precision (float32), then entity IDs followed by quantized sync infos.
+
+const (
+	// QUANTIZED_SYNC_INFO_SIZE_PER_ENTITY is the size of quantized sync info per entity
+	QUANTIZED_SYNC_INFO_SIZE_PER_ENTITY = 2 * 4
+	// QUANTIZED_SYNC_ORIGIN_SIZE is the size of the origin and the precision before quantized sync infos
+	QUANTIZED_SYNC_ORIGIN_SIZE = 4 * 4
+)
+
+// QuantizeSyncInfos appends the origin, the precision and quantized sync infos to buf
+//
+// data is entity IDs followed by sync infos. It returns false if any position is too far from the origin to quantize.
+func QuantizeSyncInfos(buf []byte, data []byte, precision float32) ([]byte, bool) {
+	const stride = common.ENTITYID_LENGTH + SYNC_INFO_SIZE_PER_ENTITY
+	if precision <= 0 || len(data) < stride {
+		return buf, false
+	}
+
+	// use the center of all positions as the origin
+	var min, max [3]float32
+	for i := 0; i+stride <= len(data); i += stride {
+		for axis := 0; axis < 3; axis++ {
+			v := syncInfoFloat32(data, i+common.ENTITYID_LENGTH+axis*4)
+			if math.IsNaN(float64(v)) {
+				return buf, false
+			}
+			if i == 0 || v < min[axis] {
+				min[axis] = v
+			}
+			if i == 0 || v > max[axis] {
+				max[axis] = v
+			}
+		}
+	}
+
+	var origin [3]float32
+	for axis := 0; axis < 3; axis++ {
+		origin[axis] = min[axis] + (max[axis]-min[axis])/2
+		if (max[axis]-min[axis])/2/precision >= math.MaxInt16 {
+			return buf, false
+		}
+	}
+
+	for axis := 0; axis < 3; axis++ {
+		buf = appendFloat32(buf, origin[axis])
+	}
+	buf = appendFloat32(buf, precision)
+
+	for i := 0; i+stride <= len(data); i += stride {
+		buf = append(buf, data[i:i+common.ENTITYID_LENGTH]...)
+		for axis := 0; axis < 3; axis++ {
+			v := syncInfoFloat32(data, i+common.ENTITYID_LENGTH+axis*4)
+			q := int16(math.Round(float64((v - origin[axis]) / precision)))
+			buf = appendUint16(buf, uint16(q))
+		}
+		buf = appendUint16(buf, quantizeYaw(syncInfoFloat32(data, i+common.ENTITYID_LENGTH+12)))
+	}
+	return buf, true
+}
+
+// ReadQuantizedSyncOrigin reads the origin and the precision of quantized sync infos from the packet
+func ReadQuantizedSyncOrigin(packet *netutil.Packet) (origin [3]float32, precision float32) {
+	for axis := 0; axis < 3; axis++ {
+		origin[axis] = packet.ReadFloat32()
+	}
+	precision = packet.ReadFloat32()
+	return
+}
+
+// ReadQuantizedSyncInfo reads one quantized sync info from the packet
+func ReadQuantizedSyncInfo(packet *netutil.Packet, origin [3]float32, precision float32) EntitySyncInfo {
+	x := origin[0] + float32(int16(packet.ReadUint16()))*precision
+	y := origin[1] + float32(int16(packet.ReadUint16()))*precision
+	z := origin[2] + float32(int16(packet.ReadUint16()))*precision
+	yaw := float32(packet.ReadUint16()) * 360 / 65536
+	return EntitySyncInfo{x, y, z, yaw}
+}
+
+func quantizeYaw(yaw float32) uint16 {
+	yaw = float32(math.Mod(float64(yaw), 360))
+	if yaw < 0 {
+		yaw += 360
+	}
+	return uint16(uint32(math.Round(float64(yaw)*65536/360)) & 0xffff)
+}
+
+func syncInfoFloat32(data []byte, offset int) float32 {
+	return math.Float32frombits(netutil.NETWORK_ENDIAN.Uint32(data[offset:]))
+}
+
+func appendFloat32(buf []byte, f float32) []byte {
+	var b [4]byte
+	netutil.NETWORK_ENDIAN.PutUint32(b[:], math.Float32bits(f))
+	return append(buf, b[:]...)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	var b [2]byte
+	netutil.NETWORK_ENDIAN.PutUint16(b[:], v)
+	return append(buf, b[:]...)
+}
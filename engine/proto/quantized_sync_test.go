@@ -0,0 +1,55 @@
+package proto
+
+import (
+	"math"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func appendTestSyncInfo(data []byte, eid common.EntityID, info EntitySyncInfo) []byte {
+	data = append(data, eid...)
+	for _, v := range []float32{info.X, info.Y, info.Z, info.Yaw} {
+		data = appendFloat32(data, v)
+	}
+	return data
+}
+
+func TestQuantizeSyncInfos(t *testing.T) {
+	infos := []EntitySyncInfo{{100, 0, -50, 90}, {120.123, 1, -60, 359.9}, {80, 2, -55, -90}}
+	var data []byte
+	for _, info := range infos {
+		data = appendTestSyncInfo(data, compatEntityID, info)
+	}
+
+	quantized, ok := QuantizeSyncInfos(nil, data, 0.01)
+	if !ok {
+		t.Fatalf("sync infos should be quantized")
+	}
+	if len(quantized) != QUANTIZED_SYNC_ORIGIN_SIZE+len(infos)*(common.ENTITYID_LENGTH+QUANTIZED_SYNC_INFO_SIZE_PER_ENTITY) {
+		t.Fatalf("wrong quantized size: %d", len(quantized))
+	}
+
+	packet := netutil.NewPacket()
+	defer packet.Release()
+	packet.AppendBytes(quantized)
+	origin, precision := ReadQuantizedSyncOrigin(packet)
+	for _, info := range infos {
+		if eid := packet.ReadEntityID(); eid != compatEntityID {
+			t.Fatalf("wrong entity ID: %s", eid)
+		}
+		decoded := ReadQuantizedSyncInfo(packet, origin, precision)
+		if math.Abs(float64(decoded.X-info.X)) > 0.01 || math.Abs(float64(decoded.Y-info.Y)) > 0.01 || math.Abs(float64(decoded.Z-info.Z)) > 0.01 {
+			t.Errorf("wrong quantized position: %v => %v", info, decoded)
+		}
+		if yawDiff := math.Abs(math.Mod(float64(decoded.Yaw-info.Yaw)+720, 360)); yawDiff > 0.01 && yawDiff < 359.99 {
+			t.Errorf("wrong quantized yaw: %v => %v", info, decoded)
+		}
+	}
+
+	data = appendTestSyncInfo(data, compatEntityID2, EntitySyncInfo{1000, 0, 0, 0})
+	if _, ok := QuantizeSyncInfos(nil, data, 0.01); ok {
+		t.Errorf("sync infos too far apart should not be quantized")
+	}
+}
@@ -0,0 +1,57 @@
+package proto
+
+import (
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// RPCErrorCode is the error code of entity RPCs called with results
+type RPCErrorCode uint16
+
+const (
+	// RPC_OK means the RPC succeeded
+	RPC_OK RPCErrorCode = iota
+	// RPC_ERROR_TIMEOUT means the result is not received within the timeout
+	RPC_ERROR_TIMEOUT
+	// RPC_ERROR_ENTITY_NOT_FOUND means the called entity is not found by the dispatcher or the game
+	RPC_ERROR_ENTITY_NOT_FOUND
+	// RPC_ERROR_INVALID_METHOD means the method is not a valid RPC, or can not be called with the arguments
+	RPC_ERROR_INVALID_METHOD
+	// RPC_ERROR_PANIC means the method panicked
+	RPC_ERROR_PANIC
+	// RPC_ERROR_RETURNED means the method returned a non-nil error as the last result
+	RPC_ERROR_RETURNED
+)
+
+func (code RPCErrorCode) String() string {
+	switch code {
+	case RPC_OK:
+		return "ok"
+	case RPC_ERROR_TIMEOUT:
+		return "timeout"
+	case RPC_ERROR_ENTITY_NOT_FOUND:
+		return "entity not found"
+	case RPC_ERROR_INVALID_METHOD:
+		return "invalid method"
+	case RPC_ERROR_PANIC:
+		return "panic"
+	case RPC_ERROR_RETURNED:
+		return "returned error"
+	default:
+		return "unknown"
+	}
+}
+
+// MakeCallEntityMethodResultPacket makes a MT_CALL_ENTITY_METHOD_RESULT packet to the calling game
+func MakeCallEntityMethodResultPacket(gameid uint16, callID uint32, code RPCErrorCode, message string, results [][]byte) *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(MT_CALL_ENTITY_METHOD_RESULT)
+	pkt.AppendUint16(gameid)
+	pkt.AppendUint32(callID)
+	pkt.AppendUint16(uint16(code))
+	pkt.AppendVarStr(message)
+	pkt.AppendUint16(uint16(len(results))) // results are packed, but in the same format as arguments
+	for _, result := range results {
+		pkt.AppendVarBytes(result)
+	}
+	return pkt
+}
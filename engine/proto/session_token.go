@@ -0,0 +1,66 @@
+package proto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+const (
+	sessionChallengeNonceSize = 16
+)
+
+// MakeSessionTokenChallenge makes a challenge in the form of clientid:nonce for the client
+//
+// The client has to get the challenge signed by the auth service, which only signs challenges for clients with valid
+// credentials, so a challenge can not be answered by echoing it back.
+func MakeSessionTokenChallenge(clientid common.ClientID) string {
+	nonce := make([]byte, sessionChallengeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return string(clientid) + ":" + base64.RawURLEncoding.EncodeToString(nonce)
+}
+
+// SignSessionToken signs the challenge of the gate into a session token in the form of clientid:nonce:expire:signature
+//
+// This is called by the auth service with the session_secret shared with gates, after checking the credential of the
+// client.
+func SignSessionToken(secret []byte, challenge string, expireTime time.Time) string {
+	payload := challenge + ":" + strconv.FormatInt(expireTime.Unix(), 10)
+	return payload + ":" + sessionTokenSignature(secret, payload)
+}
+
+// VerifySessionToken checks the signature of the session token, and returns the challenge and expire time of the token
+func VerifySessionToken(secret []byte, token string) (clientid common.ClientID, challenge string, expireTime time.Time, ok bool) {
+	sep := strings.LastIndexByte(token, ':')
+	if sep < 0 {
+		return
+	}
+	payload := token[:sep]
+	if !hmac.Equal([]byte(token[sep+1:]), []byte(sessionTokenSignature(secret, payload))) {
+		return
+	}
+
+	fields := strings.Split(payload, ":")
+	if len(fields) != 3 {
+		return
+	}
+	expire, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+	return common.ClientID(fields[0]), fields[0] + ":" + fields[1], time.Unix(expire, 0), true
+}
+
+func sessionTokenSignature(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,36 @@
+package proto
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestSessionToken(t *testing.T) {
+	secret := []byte("secret")
+	clientid := common.GenClientID()
+	challenge := MakeSessionTokenChallenge(clientid)
+	if challenge == MakeSessionTokenChallenge(clientid) {
+		t.Fatalf("challenges should be random")
+	}
+
+	expireTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := SignSessionToken(secret, challenge, expireTime)
+	cid, ch, expire, ok := VerifySessionToken(secret, token)
+	if !ok || cid != clientid || ch != challenge || !expire.Equal(expireTime) {
+		t.Fatalf("verify session token failed: %v %v %v %v", ok, cid, ch, expire)
+	}
+
+	if _, _, _, ok := VerifySessionToken(secret, challenge); ok {
+		t.Fatalf("echoed challenge should not be a valid session token")
+	}
+	if _, _, _, ok := VerifySessionToken([]byte("other"), token); ok {
+		t.Fatalf("session token signed by another secret should be invalid")
+	}
+	forged := challenge + ":9999999999" + token[strings.LastIndexByte(token, ':'):]
+	if _, _, _, ok := VerifySessionToken(secret, forged); ok {
+		t.Fatalf("session token with modified expire time should be invalid")
+	}
+}
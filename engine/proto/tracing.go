@@ -0,0 +1,46 @@
+package proto
+
+import (
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// InjectTraceContext appends the span context to the end of the packet and sets MT_TRACE_CONTEXT_FLAG in the message
+// type, so that the receiver continues the trace; nothing is done if the span context is not valid
+//
+// The packet should not be changed after the span context is injected, except by injecting another span context.
+func InjectTraceContext(packet *netutil.Packet, sc tracing.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+
+	payload := packet.Payload()
+	msgtype := MsgType(netutil.NETWORK_ENDIAN.Uint16(payload[:2]))
+	if msgtype&MT_TRACE_CONTEXT_FLAG != 0 {
+		// replace the span context injected before
+		sc.Encode(payload[len(payload)-tracing.SpanContextSize:])
+		return
+	}
+
+	netutil.NETWORK_ENDIAN.PutUint16(payload[:2], uint16(msgtype|MT_TRACE_CONTEXT_FLAG))
+	var buf [tracing.SpanContextSize]byte
+	sc.Encode(buf[:])
+	packet.AppendBytes(buf[:])
+}
+
+// extractTraceContext removes the span context injected by InjectTraceContext from the received packet, and sets it as
+// the trace context of the packet
+func extractTraceContext(packet *netutil.Packet) {
+	payload := packet.Payload()
+	if len(payload) < 2 {
+		return
+	}
+	msgtype := MsgType(netutil.NETWORK_ENDIAN.Uint16(payload[:2]))
+	if msgtype&MT_TRACE_CONTEXT_FLAG == 0 || len(payload) < 2+tracing.SpanContextSize {
+		return
+	}
+
+	netutil.NETWORK_ENDIAN.PutUint16(payload[:2], uint16(msgtype&^MT_TRACE_CONTEXT_FLAG))
+	packet.SetTraceContext(tracing.DecodeSpanContext(payload[len(payload)-tracing.SpanContextSize:]))
+	packet.SetPayloadLen(uint32(len(payload) - tracing.SpanContextSize))
+}
@@ -0,0 +1,38 @@
+package proto
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+func TestTraceContextPropagation(t *testing.T) {
+	packet := netutil.NewPacket()
+	defer packet.Release()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD)
+	packet.AppendVarStr("Method")
+	payloadLen := packet.GetPayloadLen()
+
+	InjectTraceContext(packet, tracing.SpanContext{}) // not traced
+	if packet.GetPayloadLen() != payloadLen {
+		t.Fatalf("invalid span context should not be injected")
+	}
+
+	sc := tracing.SpanContext{TraceID: tracing.TraceID{1}, SpanID: tracing.SpanID{2}}
+	InjectTraceContext(packet, sc)
+	sc.SpanID = tracing.SpanID{3}
+	InjectTraceContext(packet, sc) // replaces the span context injected before
+	if packet.GetPayloadLen() != payloadLen+tracing.SpanContextSize {
+		t.Fatalf("wrong payload length after span context is injected: %d", packet.GetPayloadLen())
+	}
+
+	extractTraceContext(packet)
+	if packet.TraceContext() != sc {
+		t.Errorf("wrong span context extracted: %s", packet.TraceContext())
+	}
+	if msgtype := MsgType(packet.ReadUint16()); msgtype != MT_CALL_ENTITY_METHOD || packet.ReadVarStr() != "Method" ||
+		packet.HasUnreadPayload() {
+		t.Errorf("packet is changed after span context is extracted: msgtype=%d", msgtype)
+	}
+}
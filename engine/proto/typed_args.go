@@ -0,0 +1,101 @@
+package proto
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Typed RPC arguments
+//
+// Clients using the protobuf codec can call entity methods with arguments in one protobuf message of the method, which
+// is generated by running the game with -rpc-args-proto. The i-th argument of the method is field i of the message, and
+// its protobuf type is determined by the Go type of the argument as returned by TypedArgProtoType. Typed arguments are
+// converted to msgpack by the game, so methods are called in the same way as with dynamic arguments.
+
+// TypedArgProtoType returns the protobuf type of typed RPC arguments of the Go type
+func TypedArgProtoType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "sint64"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint64"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.String:
+		return "string"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+	}
+	return "Value"
+}
+
+var typedArgWireTypes = map[string]int{
+	"bool":   pbVarint,
+	"sint64": pbVarint,
+	"uint64": pbVarint,
+	"float":  pbFixed32,
+	"double": pbFixed64,
+}
+
+// DecodeTypedArgs converts typed RPC arguments to msgpack packed arguments of the Go types, missing arguments are zero
+func DecodeTypedArgs(data []byte, argTypes []reflect.Type) ([][]byte, error) {
+	args := make([]interface{}, len(argTypes))
+	for i, t := range argTypes {
+		args[i] = reflect.Zero(t).Interface()
+	}
+
+	err := pbDecode(data, func(f *pbField) (err error) {
+		i := f.num - 1
+		if i >= len(argTypes) {
+			return nil // unknown fields are ignored
+		}
+
+		protoType := TypedArgProtoType(argTypes[i])
+		wireType, ok := typedArgWireTypes[protoType]
+		if !ok {
+			wireType = pbBytes
+		}
+		if f.wireType != wireType {
+			return errors.Errorf("typed args: argument %d should be %s, but wire type is %d", f.num, protoType, f.wireType)
+		}
+
+		switch protoType {
+		case "bool":
+			args[i] = f.bool()
+		case "sint64":
+			args[i] = f.sint()
+		case "uint64":
+			args[i] = f.u
+		case "float":
+			args[i] = f.float()
+		case "double":
+			args[i] = f.double()
+		case "string":
+			args[i] = f.string()
+		case "bytes":
+			args[i] = append([]byte{}, f.b...)
+		default:
+			args[i], err = pbDecodeValue(f.b, 0)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packedArgs := make([][]byte, len(args))
+	for i, arg := range args {
+		if packedArgs[i], err = netutil.MSG_PACKER.PackMsg(arg, nil); err != nil {
+			return nil, errors.Wrapf(err, "typed args: pack argument %d failed", i+1)
+		}
+	}
+	return packedArgs, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+// Call graph
+//
+// Calls to services are counted by the caller (the type of the entity whose method is calling, or "game" outside
+// entity methods) and the service, so that hot or failing dependencies between services can be found from metrics
+// (goworld_service_calls_total) or from GET /admin/callgraph of games. Results of calls without results are only known
+// to be sent or unroutable (no service entity found).
+
+const (
+	// GameCaller is the caller of service calls outside entity methods
+	GameCaller = "game"
+
+	callResultSent       = "sent"
+	callResultOK         = "ok"
+	callResultUnroutable = "unroutable"
+)
+
+var (
+	serviceCalls        = metrics.NewCounterVec("goworld_service_calls_total", "Calls to services by callers, result is sent, unroutable, ok or the error of calls with results", "caller", "service", "result")
+	serviceCallDuration = metrics.NewHistogramVec("goworld_service_call_duration_seconds", "Durations of service calls with results", metrics.DefBuckets, "caller", "service")
+
+	callGraph = map[callEdgeKey]*CallEdge{}
+)
+
+type callEdgeKey struct {
+	caller  string
+	service string
+}
+
+// CallEdge is the statistics of calls from the caller to the service on this game
+type CallEdge struct {
+	Caller             string           `json:"caller"`
+	Service            string           `json:"service"`
+	Calls              int64            `json:"calls"`
+	Errors             int64            `json:"errors"`               // unroutable calls and failed calls with results
+	Results            map[string]int64 `json:"results"`              // calls by results
+	ResultCalls        int64            `json:"result_calls"`         // finished calls with results
+	DurationSeconds    float64          `json:"duration_seconds"`     // total duration of calls with results
+	MaxDurationSeconds float64          `json:"max_duration_seconds"` // max duration of calls with results
+}
+
+// ErrorRate returns the ratio of failed calls
+func (edge *CallEdge) ErrorRate() float64 {
+	if edge.Calls == 0 {
+		return 0
+	}
+	return float64(edge.Errors) / float64(edge.Calls)
+}
+
+func currentCaller() string {
+	if caller := entity.RunningEntityType(); caller != "" {
+		return caller
+	}
+	return GameCaller
+}
+
+func getCallEdge(caller string, serviceName string) *CallEdge {
+	key := callEdgeKey{caller, serviceName}
+	edge := callGraph[key]
+	if edge == nil {
+		edge = &CallEdge{Caller: caller, Service: serviceName, Results: map[string]int64{}}
+		callGraph[key] = edge
+	}
+	return edge
+}
+
+func (edge *CallEdge) count(result string) {
+	edge.Calls += 1
+	edge.Results[result] += 1
+	if result != callResultSent && result != callResultOK {
+		edge.Errors += 1
+	}
+	serviceCalls.With(edge.Caller, edge.Service, result).Inc()
+}
+
+// recordServiceCall records the call without results to the service, routed is false if no service entity is found
+func recordServiceCall(serviceName string, routed bool) {
+	result := callResultSent
+	if !routed {
+		result = callResultUnroutable
+	}
+	getCallEdge(currentCaller(), serviceName).count(result)
+}
+
+// observeServiceCall returns the callback which records the result of the call with results to the service
+func observeServiceCall(serviceName string, callback entity.RPCCallback) entity.RPCCallback {
+	caller := currentCaller()
+	startTime := time.Now()
+	return func(results []interface{}, err error) {
+		edge := getCallEdge(caller, serviceName)
+		result := callResultOK
+		if rpcErr, ok := err.(*entity.RPCError); ok {
+			result = strings.Replace(rpcErr.Code.String(), " ", "_", -1)
+		} else if err != nil {
+			result = "error"
+		}
+		edge.count(result)
+
+		d := time.Since(startTime).Seconds()
+		edge.ResultCalls += 1
+		edge.DurationSeconds += d
+		if d > edge.MaxDurationSeconds {
+			edge.MaxDurationSeconds = d
+		}
+		serviceCallDuration.With(caller, serviceName).Observe(d)
+		callback(results, err)
+	}
+}
+
+// GetCallGraph returns statistics of calls from callers to services on this game, ordered by callers and services
+func GetCallGraph() []*CallEdge {
+	edges := make([]*CallEdge, 0, len(callGraph))
+	for _, edge := range callGraph {
+		copied := *edge
+		copied.Results = make(map[string]int64, len(edge.Results))
+		for result, n := range edge.Results {
+			copied.Results[result] = n
+		}
+		edges = append(edges, &copied)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Service < edges[j].Service
+	})
+	return edges
+}
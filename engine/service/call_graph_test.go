@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func TestCallGraph(t *testing.T) {
+	callGraph = map[callEdgeKey]*CallEdge{}
+	defer func() {
+		callGraph = map[callEdgeKey]*CallEdge{}
+	}()
+
+	recordServiceCall("TestMailService", true)
+	recordServiceCall("TestMailService", false)
+	called := 0
+	callback := observeServiceCall("TestMailService", func(results []interface{}, err error) {
+		called++
+	})
+	callback(nil, nil)
+	callback(nil, &entity.RPCError{Code: proto.RPC_ERROR_TIMEOUT})
+	callback(nil, errors.New("other error"))
+	if called != 3 {
+		t.Fatalf("callback should be called 3 times, but called %d", called)
+	}
+
+	edges := GetCallGraph()
+	if len(edges) != 1 || edges[0].Caller != GameCaller || edges[0].Service != "TestMailService" {
+		t.Fatalf("wrong call graph: %+v", edges)
+	}
+	edge := edges[0]
+	if edge.Calls != 5 || edge.Errors != 3 || edge.ResultCalls != 3 || edge.ErrorRate() != 0.6 {
+		t.Fatalf("wrong call edge: %+v", edge)
+	}
+	if edge.Results["sent"] != 1 || edge.Results["unroutable"] != 1 || edge.Results["ok"] != 1 || edge.Results["timeout"] != 1 || edge.Results["error"] != 1 {
+		t.Fatalf("wrong results: %v", edge.Results)
+	}
+
+	edge.Results["ok"] = 100
+	if GetCallGraph()[0].Results["ok"] != 1 {
+		t.Fatalf("call graph should be copied")
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// ServiceInfo describes a registered service and its instances known by this game
+type ServiceInfo struct {
+	Name            string            `json:"name"`
+	ShardCount      int               `json:"shard_count"`
+	RoutePolicy     string            `json:"route_policy"`
+	SpreadPlacement bool              `json:"spread_placement"`
+	PlacementLabels map[string]string `json:"placement_labels,omitempty"` // instances are hosted by games with the labels
+	StandbyInterval string            `json:"standby_interval,omitempty"` // snapshot interval of warm standby
+	Instances       []ServiceInstance `json:"instances,omitempty"`
+}
+
+// ServiceInstance is an instance (shard) of the service
+type ServiceInstance struct {
+	ShardIndex    int             `json:"shard"`
+	EntityID      common.EntityID `json:"entity,omitempty"`
+	GameID        uint16          `json:"game,omitempty"`
+	StandbyGameID uint16          `json:"standby_game,omitempty"`
+}
+
+// ListServices returns infos of all registered services ordered by name, instances are empty before the deployment is ready
+func ListServices() []*ServiceInfo {
+	serviceNames := make([]string, 0, len(registeredServices))
+	for serviceName := range registeredServices {
+		serviceNames = append(serviceNames, serviceName)
+	}
+	sort.Strings(serviceNames)
+
+	infos := make([]*ServiceInfo, len(serviceNames))
+	for i, serviceName := range serviceNames {
+		info := &ServiceInfo{
+			Name:            serviceName,
+			ShardCount:      registeredServices[serviceName],
+			RoutePolicy:     routePolicies[serviceName].String(),
+			SpreadPlacement: spreadServices.Contains(serviceName),
+			PlacementLabels: placementLabels[serviceName],
+		}
+		if interval, ok := standbyServices[serviceName]; ok {
+			info.StandbyInterval = interval.String()
+		}
+		for shardIndex, eid := range serviceMap[serviceName] {
+			if eid.IsNil() {
+				continue
+			}
+			info.Instances = append(info.Instances, ServiceInstance{
+				ShardIndex:    shardIndex,
+				EntityID:      eid,
+				GameID:        GetServiceGameID(serviceName, shardIndex),
+				StandbyGameID: standbyGameIDs[getServiceId(serviceName, shardIndex)],
+			})
+		}
+		infos[i] = info
+	}
+	return infos
+}
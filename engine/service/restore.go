@@ -0,0 +1,61 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvreg"
+)
+
+// Service registrations in cluster snapshots
+//
+// Snapshots of the cluster include service IDs of service entities on each game. When the snapshot is restored, the
+// game restoring the service entity registers the service to itself when the deployment is ready, so that the restored
+// entity is used instead of creating a new one.
+
+var (
+	restoredServiceEntities = common.EntityIDSet{}            // service entities restored but not registered yet
+	restoredServices        = map[serviceId]common.EntityID{} // registered when the deployment is ready
+)
+
+// GetLocalServiceEntities returns service IDs (ServiceName#ShardIndex) of service entities on this game
+func GetLocalServiceEntities() map[string]common.EntityID {
+	services := map[string]common.EntityID{}
+	for serviceName, eids := range serviceMap {
+		for shardIndex, eid := range eids {
+			if !eid.IsNil() && entity.GetEntity(eid) != nil {
+				services[string(getServiceId(serviceName, shardIndex))] = eid
+			}
+		}
+	}
+	return services
+}
+
+// RestoreServiceEntities registers restored service entities to this game when the deployment is ready, service
+// entities which are not restored on this game are ignored
+func RestoreServiceEntities(services map[string]common.EntityID) {
+	for srvid, eid := range services {
+		serviceName, _ := splitServiceId(serviceId(srvid))
+		if _, ok := registeredServices[serviceName]; !ok {
+			gwlog.Warnf("service: restored service %s is not registered", srvid)
+			continue
+		}
+		if entity.GetEntity(eid) == nil {
+			continue
+		}
+
+		restoredServiceEntities.Add(eid)
+		restoredServices[serviceId(srvid)] = eid
+	}
+}
+
+func registerRestoredServices() {
+	for serviceId, eid := range restoredServices {
+		gwlog.Infof("service: registering restored %s %s ...", serviceId, eid)
+		kvreg.Register(getServiceRegKey(serviceId), fmt.Sprintf("game%d", gameid), true)
+		kvreg.Register(getServiceRegKey(serviceId)+"/EntityID", string(eid), true)
+	}
+	restoredServices = map[serviceId]common.EntityID{}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Routing and placement of service instances
+//
+// Each shard of a service is an instance of the service, registered in kvreg of dispatchers as
+// Service/ServiceName#ShardIndex = gameX. Every game keeps the registrations of all instances, so calls by CallService
+// are routed to an instance by the route policy of the service without asking dispatchers. By default, an instance is
+// hosted by the game registering it first, and services with spread placement prefer game (ShardIndex % DesiredGames + 1)
+// for each instance, so that instances are distributed evenly when all games are running.
+
+// RoutePolicy decides which instance of the service is called by CallService
+type RoutePolicy int
+
+const (
+	// RouteRandom calls a random instance
+	RouteRandom RoutePolicy = iota
+	// RouteRoundRobin calls instances in turn
+	RouteRoundRobin
+	// RouteConsistentHash calls the instance chosen by rendezvous hashing of the first argument, calls with the same first
+	// argument go to the same instance, and only calls to a lost instance are moved to others
+	RouteConsistentHash
+	// RouteLocalPreferred calls a random instance on the local game if any, otherwise a random instance
+	RouteLocalPreferred
+)
+
+const (
+	spreadPlacementDelay = time.Second * 2 // delay of registering instances preferring other games
+)
+
+var (
+	routePolicies      = map[string]RoutePolicy{} // ServiceName -> route policy
+	roundRobinCounters = map[string]int{}
+	spreadServices     = common.StringSet{}
+	serviceGameMap     = map[string][]uint16{} // ServiceName -> []gameid of instances
+	// ServiceName -> labels of games hosting instances
+	placementLabels = map[string]map[string]string{}
+)
+
+func (policy RoutePolicy) String() string {
+	switch policy {
+	case RouteRandom:
+		return "random"
+	case RouteRoundRobin:
+		return "round-robin"
+	case RouteConsistentHash:
+		return "consistent-hash"
+	case RouteLocalPreferred:
+		return "local-preferred"
+	default:
+		return "RoutePolicy(" + strconv.Itoa(int(policy)) + ")"
+	}
+}
+
+// SetRoutePolicy sets the route policy of calls to the registered service by CallService
+func SetRoutePolicy(serviceName string, policy RoutePolicy) {
+	if _, ok := registeredServices[serviceName]; !ok {
+		gwlog.Panicf("SetRoutePolicy: service %s is not registered", serviceName)
+	}
+	if policy < RouteRandom || policy > RouteLocalPreferred {
+		gwlog.Panicf("SetRoutePolicy: %s is using invalid route policy: %s", serviceName, policy)
+	}
+
+	routePolicies[serviceName] = policy
+}
+
+// EnableSpreadPlacement spreads instances of the registered service evenly across games
+func EnableSpreadPlacement(serviceName string) {
+	if _, ok := registeredServices[serviceName]; !ok {
+		gwlog.Panicf("EnableSpreadPlacement: service %s is not registered", serviceName)
+	}
+
+	spreadServices.Add(serviceName)
+}
+
+// SetPlacementLabels constrains instances of the registered service to games with all labels of the selector, e.g.
+// "tier:highmem", other games do not register instances of the service
+func SetPlacementLabels(serviceName string, selector string) {
+	if _, ok := registeredServices[serviceName]; !ok {
+		gwlog.Panicf("SetPlacementLabels: service %s is not registered", serviceName)
+	}
+	labels, err := config.ParseLabels(selector)
+	if err != nil {
+		gwlog.Panicf("SetPlacementLabels: invalid label selector of service %s: %s", serviceName, err)
+	}
+
+	placementLabels[serviceName] = labels
+}
+
+// canHostService returns if instances of the service can be hosted by this game
+func canHostService(serviceName string) bool {
+	labels, ok := placementLabels[serviceName]
+	return !ok || config.MatchLabels(conf.GetGame(gameid).Labels, labels)
+}
+
+// getRegisterDelay returns the delay of registering the service instance on this game
+//
+// Games register services after random delays, so that instances are registered by random games. Instances of
+// services with spread placement are registered by their preferred games first, and by others if the preferred game
+// is not running.
+func getRegisterDelay(serviceName string, shardIndex int) time.Duration {
+	randomDelay := time.Millisecond * time.Duration(rand.Intn(1000))
+	if !spreadServices.Contains(serviceName) {
+		return randomDelay
+	}
+
+	desiredGames := conf.GetDeployment().DesiredGames
+	if desiredGames <= 0 || uint16(shardIndex%desiredGames+1) == gameid {
+		return randomDelay / 10
+	}
+	return spreadPlacementDelay + randomDelay
+}
+
+// routeService returns the instance of the service to call by the route policy, or -1 if no instance is available
+func routeService(serviceName string, args []interface{}) (int, common.EntityID) {
+	serviceEids := serviceMap[serviceName]
+	available := make([]int, 0, len(serviceEids))
+	for shardIndex, eid := range serviceEids {
+		if !eid.IsNil() {
+			available = append(available, shardIndex)
+		}
+	}
+	if len(available) == 0 {
+		return -1, ""
+	}
+
+	var shardIndex int
+	switch routePolicies[serviceName] {
+	case RouteRoundRobin:
+		n := roundRobinCounters[serviceName]
+		roundRobinCounters[serviceName] = n + 1
+		shardIndex = available[n%len(available)]
+	case RouteConsistentHash:
+		var key string
+		if len(args) > 0 {
+			key = fmt.Sprint(args[0])
+		}
+		shardIndex = rendezvousHash(key, available)
+	case RouteLocalPreferred:
+		games := serviceGameMap[serviceName]
+		local := available[:0:0]
+		for _, i := range available {
+			if i < len(games) && games[i] == gameid {
+				local = append(local, i)
+			}
+		}
+		if len(local) > 0 {
+			available = local
+		}
+		shardIndex = available[rand.Intn(len(available))]
+	default:
+		shardIndex = available[rand.Intn(len(available))]
+	}
+	return shardIndex, serviceEids[shardIndex]
+}
+
+// rendezvousHash returns the shard index with the highest hash of the key and the shard index
+func rendezvousHash(key string, shardIndexes []int) int {
+	var best int
+	var bestHash uint32
+	for i, shardIndex := range shardIndexes {
+		h := common.HashString(key + serviceNameShardIndexSep + strconv.Itoa(shardIndex))
+		if i == 0 || h > bestHash {
+			best, bestHash = shardIndex, h
+		}
+	}
+	return best
+}
+
+// CallService calls the method of the service instance chosen by the route policy of the service
+func CallService(serviceName string, method string, args []interface{}) {
+	shardIndex, eid := routeService(serviceName, args)
+	if shardIndex < 0 {
+		gwlog.Errorf("CallService %s.%s: no service entity found!", serviceName, method)
+		recordServiceCall(serviceName, false)
+		return
+	}
+
+	recordServiceCall(serviceName, true)
+	entity.Call(eid, method, args)
+}
+
+// CallServiceWithResult calls the method of the service instance chosen by the route policy of the service with results
+func CallServiceWithResult(serviceName string, method string, args []interface{}, timeout time.Duration, callback entity.RPCCallback) {
+	callback = observeServiceCall(serviceName, callback)
+	shardIndex, eid := routeService(serviceName, args)
+	if shardIndex < 0 {
+		failServiceCall(callback, fmt.Sprintf("CallServiceWithResult %s.%s: no service entity found", serviceName, method))
+		return
+	}
+
+	entity.CallWithResult(eid, method, args, timeout, callback)
+}
+
+// GetServiceGameID returns the game hosting the service instance, 0 if the instance is not registered
+func GetServiceGameID(serviceName string, shardIndex int) uint16 {
+	games := serviceGameMap[serviceName]
+	if shardIndex >= 0 && shardIndex < len(games) {
+		return games[shardIndex]
+	}
+	return 0
+}
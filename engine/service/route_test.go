@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func setupTestRouteService(policy RoutePolicy, eids []common.EntityID, games []uint16) {
+	serviceMap = map[string][]common.EntityID{"TestRouteService": eids}
+	serviceGameMap = map[string][]uint16{"TestRouteService": games}
+	routePolicies = map[string]RoutePolicy{"TestRouteService": policy}
+	roundRobinCounters = map[string]int{}
+}
+
+func TestRouteService(t *testing.T) {
+	gameid = 2
+	defer setupTestRouteService(RouteRandom, nil, nil)
+	eids := []common.EntityID{common.GenEntityID(), "", common.GenEntityID(), common.GenEntityID()}
+
+	setupTestRouteService(RouteRoundRobin, eids, []uint16{1, 0, 2, 3})
+	var called []int
+	for i := 0; i < 4; i++ {
+		shardIndex, _ := routeService("TestRouteService", nil)
+		called = append(called, shardIndex)
+	}
+	if called[0] != 0 || called[1] != 2 || called[2] != 3 || called[3] != 0 {
+		t.Fatalf("round-robin should skip unregistered instances: %v", called)
+	}
+
+	setupTestRouteService(RouteLocalPreferred, eids, []uint16{1, 0, 2, 3})
+	for i := 0; i < 10; i++ {
+		if shardIndex, eid := routeService("TestRouteService", nil); shardIndex != 2 || eid != eids[2] {
+			t.Fatalf("local instance should be preferred, but called %d", shardIndex)
+		}
+	}
+
+	setupTestRouteService(RouteConsistentHash, eids, []uint16{1, 0, 2, 3})
+	moved := 0
+	for i := 0; i < 100; i++ {
+		key := common.GenEntityID()
+		shardIndex, _ := routeService("TestRouteService", []interface{}{key, i})
+		if again, _ := routeService("TestRouteService", []interface{}{key, -i}); again != shardIndex {
+			t.Fatalf("calls with the same first argument should go to the same instance")
+		}
+
+		// only calls to the lost instance are moved
+		serviceMap["TestRouteService"] = []common.EntityID{eids[0], "", "", eids[3]}
+		if after, _ := routeService("TestRouteService", []interface{}{key}); after != shardIndex {
+			if shardIndex != 2 {
+				t.Fatalf("call to instance %d is moved to %d", shardIndex, after)
+			}
+			moved++
+		}
+		serviceMap["TestRouteService"] = eids
+	}
+	if moved == 0 {
+		t.Fatalf("calls to the lost instance should be moved")
+	}
+
+	setupTestRouteService(RouteRandom, []common.EntityID{"", ""}, nil)
+	if shardIndex, _ := routeService("TestRouteService", nil); shardIndex != -1 {
+		t.Fatalf("no instance should be available")
+	}
+}
@@ -13,10 +13,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwvar"
 	"github.com/xiaonanln/goworld/engine/kvreg"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
 )
 
 const (
@@ -57,6 +60,7 @@ func splitServiceId(serviceId serviceId) (serviceName string, shardIndex int) {
 
 var (
 	registeredServices = map[string]int{}
+	conf               *config.Config // config of the game, set by Setup
 	gameid             uint16
 	serviceMap         = map[string][]common.EntityID{} // ServiceName -> []Entity ID
 	checkTimer         *timer.Timer
@@ -75,19 +79,24 @@ func RegisterService(typeName string, entityPtr entity.IEntity, shardCount int)
 	registeredServices[typeName] = shardCount
 }
 
-func Setup(gameid_ uint16) {
+func Setup(c *config.Config, gameid_ uint16) {
+	conf = c
 	gameid = gameid_
 	kvreg.AddPostCallback(checkServicesLater)
 }
 
 func OnDeploymentReady() {
 	timer.AddTimer(checkServicesInterval, checkServicesLater)
+	setupStandbySnapshots()
+	registerRestoredServices()
 	checkServicesLater()
 }
 
 type serviceInfo struct {
-	Registered bool
-	EntityID   common.EntityID
+	Registered    bool
+	GameID        uint16
+	EntityID      common.EntityID
+	StandbyGameID uint16
 }
 
 func checkServicesLater() {
@@ -113,6 +122,7 @@ func checkServices() {
 	localRegServiceIds := map[serviceId]struct{}{}             //service ids that are registered on this game server
 	localRegServiceEntities := map[string]common.EntityIDSet{} // local service entities that is registered, group by ServiceName
 	newServiceMap := make(map[string][]common.EntityID, len(registeredServices))
+	newServiceGameMap := make(map[string][]uint16, len(registeredServices))
 
 	// ServiceId == ServiceName#ShardIndex
 	getServiceInfo := func(serviceId serviceId) *serviceInfo {
@@ -136,6 +146,7 @@ func checkServices() {
 				gwlog.Panic(errors.Wrap(err, "parse gameid failed"))
 			}
 			getServiceInfo(serviceId).Registered = true
+			getServiceInfo(serviceId).GameID = uint16(regGameId)
 
 			// this service entity should be created on local game server
 			if int(gameid) == regGameId {
@@ -148,6 +159,8 @@ func checkServices() {
 			switch fieldName {
 			case "EntityID":
 				getServiceInfo(serviceId).EntityID = common.EntityID(val)
+			case "Standby":
+				getServiceInfo(serviceId).StandbyGameID = parseStandbyGameID(val)
 			default:
 				gwlog.Errorf("unknown kvreg info: %s = %s", key, val)
 			}
@@ -174,12 +187,15 @@ func checkServices() {
 		if serviceEids == nil {
 			serviceEids = make([]common.EntityID, regShardCount)
 			newServiceMap[serviceName] = serviceEids
+			newServiceGameMap[serviceName] = make([]uint16, regShardCount)
 		}
 
 		serviceEids[shardIndex] = info.EntityID
+		newServiceGameMap[serviceName][shardIndex] = info.GameID
 	}
 	// replace with new service map
 	serviceMap = newServiceMap
+	serviceGameMap = newServiceGameMap
 
 	// find all service entities that should be created on local game, group by service name
 	for serviceId := range localRegServiceIds {
@@ -191,6 +207,7 @@ func checkServices() {
 			}
 
 			localRegServiceEntities[serviceName].Add(serviceInfo.EntityID)
+			restoredServiceEntities.Del(serviceInfo.EntityID)
 		}
 	}
 
@@ -198,7 +215,7 @@ func checkServices() {
 	for serviceName := range registeredServices {
 		serviceEntities := entity.GetEntitiesByType(serviceName)
 		for eid, entity := range serviceEntities {
-			if !localRegServiceEntities[serviceName].Contains(eid) {
+			if !localRegServiceEntities[serviceName].Contains(eid) && !promotedServiceEntities.Contains(eid) && !restoredServiceEntities.Contains(eid) {
 				// this service entity is created locally, but not registered
 				// might be caused by registration delay (very low chance)
 				entity.Destroy()
@@ -218,6 +235,9 @@ func checkServices() {
 
 	// register all service ids that are not registered to dispatcher yet
 	for serviceName, shardCount := range registeredServices {
+		if !canHostService(serviceName) {
+			continue
+		}
 		for shardIndex := 0; shardIndex < shardCount; shardIndex++ {
 			serviceId := getServiceId(serviceName, shardIndex)
 			serviceInfo := getServiceInfo(serviceId)
@@ -229,12 +249,13 @@ func checkServices() {
 			gwlog.Warnf("service: %s not found, registering kvreg ...", serviceId)
 
 			// delay for a random time so that each game might register services randomly
-			randomDelay := time.Millisecond * time.Duration(rand.Intn(1000))
-			timer.AddCallback(randomDelay, func() {
+			timer.AddCallback(getRegisterDelay(serviceName, shardIndex), func() {
 				kvreg.Register(getServiceRegKey(serviceId), fmt.Sprintf("game%d", gameid), false)
 			})
 		}
 	}
+
+	checkStandbys(dispRegisteredServices)
 }
 
 func createServiceEntity(serviceId serviceId) {
@@ -259,15 +280,18 @@ func CallServiceAny(serviceName string, method string, args []interface{}) {
 	serviceEids := serviceMap[serviceName]
 	if len(serviceEids) == 0 {
 		gwlog.Errorf("CallServiceAny %s.%s: no service entity found!", serviceName, method)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
 	eid := serviceEids[rand.Intn(len(serviceEids))]
 	if eid.IsNil() {
 		gwlog.Errorf("CallServiceAny %s.%s: service entity is nil!", serviceName, method)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
+	recordServiceCall(serviceName, true)
 	entity.Call(eid, method, args)
 }
 
@@ -275,9 +299,11 @@ func CallServiceAll(serviceName string, method string, args []interface{}) {
 	serviceEids := serviceMap[serviceName]
 	if len(serviceEids) == 0 {
 		gwlog.Errorf("CallServiceAll %s.%s: no service entity found!", serviceName, method)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
+	recordServiceCall(serviceName, true)
 	for shardIndex, eid := range serviceEids {
 		if eid.IsNil() {
 			gwlog.Errorf("CallServiceAll %s.%s: service entity %d is nil!", serviceName, method, shardIndex)
@@ -293,15 +319,18 @@ func CallServiceShardIndex(serviceName string, shardIndex int, method string, ar
 	serviceEids := serviceMap[serviceName]
 	if shardIndex < 0 || shardIndex >= len(serviceEids) {
 		gwlog.Errorf("CallServiceShardIndex %s.%s: found %d service entities, but shard index is %d!", serviceName, method, len(serviceEids), shardIndex)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
 	eid := serviceEids[shardIndex]
 	if eid.IsNil() {
 		gwlog.Errorf("CallServiceShardIndex %s.%s: service entity %d is nil!", serviceName, method, shardIndex)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
+	recordServiceCall(serviceName, true)
 	entity.Call(eid, method, args)
 }
 
@@ -310,6 +339,7 @@ func CallServiceShardKey(serviceName string, shardKey string, method string, arg
 
 	if len(serviceEids) <= 0 {
 		gwlog.Errorf("CallServiceShardKey %s.%s: no service entities", serviceName, method)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
@@ -317,12 +347,59 @@ func CallServiceShardKey(serviceName string, shardKey string, method string, arg
 	eid := serviceEids[shardIndex]
 	if eid.IsNil() {
 		gwlog.Errorf("CallServiceShardKey %s.%s: service entity %d (shard key %+v) is nil!", serviceName, method, shardIndex, shardKey)
+		recordServiceCall(serviceName, false)
 		return
 	}
 
+	recordServiceCall(serviceName, true)
 	entity.Call(eid, method, args)
 }
 
+// CallServiceAnyWithResult calls the method of a random service entity with results
+func CallServiceAnyWithResult(serviceName string, method string, args []interface{}, timeout time.Duration, callback entity.RPCCallback) {
+	callback = observeServiceCall(serviceName, callback)
+	serviceEids := serviceMap[serviceName]
+	if len(serviceEids) == 0 {
+		failServiceCall(callback, fmt.Sprintf("CallServiceAnyWithResult %s.%s: no service entity found", serviceName, method))
+		return
+	}
+
+	eid := serviceEids[rand.Intn(len(serviceEids))]
+	if eid.IsNil() {
+		failServiceCall(callback, fmt.Sprintf("CallServiceAnyWithResult %s.%s: service entity is nil", serviceName, method))
+		return
+	}
+
+	entity.CallWithResult(eid, method, args, timeout, callback)
+}
+
+// CallServiceShardKeyWithResult calls the method of the service entity specified by shard key with results
+func CallServiceShardKeyWithResult(serviceName string, shardKey string, method string, args []interface{}, timeout time.Duration, callback entity.RPCCallback) {
+	callback = observeServiceCall(serviceName, callback)
+	serviceEids := serviceMap[serviceName]
+	if len(serviceEids) == 0 {
+		failServiceCall(callback, fmt.Sprintf("CallServiceShardKeyWithResult %s.%s: no service entities", serviceName, method))
+		return
+	}
+
+	shardIndex := shardByKey(shardKey, len(serviceEids))
+	eid := serviceEids[shardIndex]
+	if eid.IsNil() {
+		failServiceCall(callback, fmt.Sprintf("CallServiceShardKeyWithResult %s.%s: service entity %d is nil", serviceName, method, shardIndex))
+		return
+	}
+
+	entity.CallWithResult(eid, method, args, timeout, callback)
+}
+
+// failServiceCall fails the call with results to the service later, callbacks are never called before the call returns
+func failServiceCall(callback entity.RPCCallback, message string) {
+	gwlog.Errorf("%s", message)
+	post.Post(func() {
+		callback(nil, &entity.RPCError{Code: proto.RPC_ERROR_ENTITY_NOT_FOUND, Message: message})
+	})
+}
+
 func shardByKey(key string, shardCount int) int {
 	return int(common.HashString(key)) % shardCount
 }
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvreg"
+)
+
+// Warm standby of services
+//
+// A service with standby enabled is backed by a standby game for each shard, which is registered in kvreg as
+// Service/ServiceName#ShardIndex/Standby. The game of the service entity sends snapshots of all attributes of the entity
+// to the standby game periodically. When the game is down, the dispatcher of the entity redirects calls to the standby
+// game, and the standby game recreates the service entity with the same entity ID from the last snapshot. Changes after
+// the last snapshot are lost, and timers of the service entity should be restarted in OnCreated.
+
+var (
+	standbyServices         = map[string]time.Duration{}      // ServiceName -> snapshot interval
+	standbyGameIDs          = map[serviceId]uint16{}          // ServiceName#ShardIndex -> standby gameid
+	standbySnapshots        = map[common.EntityID]*snapshot{} // snapshots of service entities this game is standby for
+	promotedServiceEntities = common.EntityIDSet{}            // service entities taken over but not registered yet
+)
+
+type snapshot struct {
+	serviceId serviceId
+	gameid    uint16 // game of the service entity
+	data      map[string]interface{}
+}
+
+// EnableStandby enables warm standby of the registered service, snapshots are sent to standby games every snapshotInterval
+func EnableStandby(serviceName string, snapshotInterval time.Duration) {
+	if _, ok := registeredServices[serviceName]; !ok {
+		gwlog.Panicf("EnableStandby: service %s is not registered", serviceName)
+	}
+	if snapshotInterval <= 0 {
+		gwlog.Panicf("EnableStandby: %s is using invalid snapshot interval: %s", serviceName, snapshotInterval)
+	}
+
+	standbyServices[serviceName] = snapshotInterval
+}
+
+func setupStandbySnapshots() {
+	for serviceName, snapshotInterval := range standbyServices {
+		serviceName := serviceName
+		timer.AddTimer(snapshotInterval, func() {
+			sendStandbySnapshots(serviceName)
+		})
+	}
+}
+
+func parseStandbyGameID(val string) uint16 {
+	if val == "" {
+		return 0 // standby is cleared
+	}
+
+	standbyGameID, err := strconv.Atoi(strings.TrimPrefix(val, "game"))
+	if err != nil {
+		gwlog.Errorf("service: invalid standby game: %s", val)
+		return 0
+	}
+	return uint16(standbyGameID)
+}
+
+// checkStandbys registers this game as standby of services which are on other games and have no standby
+func checkStandbys(dispRegisteredServices map[serviceId]*serviceInfo) {
+	newStandbyGameIDs := map[serviceId]uint16{}
+	for serviceId, info := range dispRegisteredServices {
+		serviceName, _ := splitServiceId(serviceId)
+		if _, ok := standbyServices[serviceName]; !ok || !info.Registered {
+			continue
+		}
+
+		if info.GameID == gameid {
+			promotedServiceEntities.Del(info.EntityID)
+			if info.StandbyGameID == gameid {
+				// this game was the standby and has taken over the service, a new standby is needed
+				kvreg.Register(getServiceStandbyRegKey(serviceId), "", true)
+				continue
+			}
+		} else if info.StandbyGameID == 0 {
+			serviceId := serviceId
+			randomDelay := time.Millisecond * time.Duration(rand.Intn(1000))
+			timer.AddCallback(randomDelay, func() {
+				kvreg.Register(getServiceStandbyRegKey(serviceId), fmt.Sprintf("game%d", gameid), false)
+			})
+		}
+
+		if info.StandbyGameID != 0 {
+			newStandbyGameIDs[serviceId] = info.StandbyGameID
+		}
+	}
+	standbyGameIDs = newStandbyGameIDs
+
+	for eid, ss := range standbySnapshots {
+		if standbyGameIDs[ss.serviceId] != gameid {
+			delete(standbySnapshots, eid) // no longer the standby
+		}
+	}
+}
+
+// sendStandbySnapshots sends snapshots of local service entities to their standby games
+func sendStandbySnapshots(serviceName string) {
+	for shardIndex, eid := range serviceMap[serviceName] {
+		serviceId := getServiceId(serviceName, shardIndex)
+		standbyGameID := standbyGameIDs[serviceId]
+		if standbyGameID == 0 || standbyGameID == gameid {
+			continue
+		}
+
+		e := entity.GetEntity(eid)
+		if e == nil {
+			continue // the service entity is on other game
+		}
+		dispatchercluster.SendSyncServiceSnapshot(standbyGameID, gameid, eid, string(serviceId), e.Attrs.ToMap())
+	}
+}
+
+// OnServiceSnapshot is called when a snapshot of service entity is received by the standby game
+func OnServiceSnapshot(srcGameID uint16, eid common.EntityID, serviceID string, data map[string]interface{}) {
+	srvid := serviceId(serviceID)
+	if standbyGameIDs[srvid] != gameid {
+		gwlog.Warnf("service: snapshot of %s %s is ignored, because game%d is not the standby", srvid, eid, gameid)
+		return
+	}
+
+	standbySnapshots[eid] = &snapshot{serviceId: srvid, gameid: srcGameID, data: data}
+}
+
+// OnGameDown takes over services of the down game if this game is the standby, and clears the standby of local services
+// if the standby game is down
+func OnGameDown(downGameID uint16) {
+	for eid, ss := range standbySnapshots {
+		if ss.gameid == downGameID {
+			delete(standbySnapshots, eid)
+			takeOverService(eid, ss)
+		}
+	}
+
+	for serviceId, standbyGameID := range standbyGameIDs {
+		if standbyGameID != downGameID {
+			continue
+		}
+
+		serviceName, shardIndex := splitServiceId(serviceId)
+		if entity.GetEntity(GetServiceEntityID(serviceName, shardIndex)) != nil {
+			delete(standbyGameIDs, serviceId)
+			kvreg.Register(getServiceStandbyRegKey(serviceId), "", true)
+		}
+	}
+}
+
+func takeOverService(eid common.EntityID, ss *snapshot) {
+	if kvreg.Get(getServiceStandbyRegKey(ss.serviceId)) != fmt.Sprintf("game%d", gameid) || entity.GetEntity(eid) != nil {
+		return
+	}
+
+	serviceName, _ := splitServiceId(ss.serviceId)
+	gwlog.Warnf("service: game%d is down, taking over %s %s from standby snapshot ...", ss.gameid, ss.serviceId, eid)
+	promotedServiceEntities.Add(eid)
+	entity.CreateEntityLocallyWithID(serviceName, ss.data, eid)
+	kvreg.Register(getServiceRegKey(ss.serviceId), fmt.Sprintf("game%d", gameid), true)
+}
+
+func getServiceStandbyRegKey(serviceId serviceId) string {
+	return getServiceRegKey(serviceId) + "/Standby"
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	_STORAGE_POOLED_ASYNC_JOB_GROUP = "_storage_pooled"
+)
+
+var (
+	pooledStorages []storagecommon.EntityStorage // storages of pooled workers, each worker uses its own connection
+)
+
+// SaveAsyncCallbackFunc is the callback type of storage SaveAsync
+type SaveAsyncCallbackFunc func(err error)
+
+// LoadAsync loads entity data from storage in the worker pool, returns in callback on the game goroutine
+//
+// Operations of the same entity are executed in order by the same worker, while operations of different entities are
+// executed concurrently, so slow operations do not delay others. Data of pending write-behind saves is returned if any.
+// Async operations are not ordered with Load, Save, etc.
+func LoadAsync(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(res, err)
+		}
+	}
+
+	async.AppendPooledAsyncJob(_STORAGE_POOLED_ASYNC_JOB_GROUP, string(entityID), pooledStorageRoutine(func(es storagecommon.EntityStorage) (interface{}, error) {
+		if data, ok := getPendingSaveData(typeName, entityID); ok {
+			return data, nil
+		}
+
+		monop := opmon.StartOperation("storage.loadAsync")
+		data, err := es.Read(typeName, entityID)
+		monop.Finish(time.Millisecond * 100)
+		if err != nil {
+			gwlog.TraceError("storage: load %s %s failed: %s", typeName, entityID, err)
+			return nil, err
+		}
+		return data, nil
+	}), ac)
+}
+
+// SaveAsync saves entity data to storage in the worker pool, returns in callback on the game goroutine
+//
+// SaveAsync is not fenced by owner epochs, so it should not be used for entities which are loaded by games. The pending
+// write-behind save of the entity is discarded because it is superseded.
+func SaveAsync(typeName string, entityID common.EntityID, data map[string]interface{}, callback SaveAsyncCallbackFunc) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+
+	async.AppendPooledAsyncJob(_STORAGE_POOLED_ASYNC_JOB_GROUP, string(entityID), pooledStorageRoutine(func(es storagecommon.EntityStorage) (interface{}, error) {
+		superseded := takePendingSave(typeName, entityID)
+		monop := opmon.StartOperation("storage.saveAsync")
+		err := es.Write(typeName, entityID, data)
+		monop.Finish(time.Millisecond * 100)
+		if err != nil {
+			gwlog.Errorf("storage: save %s %s failed: %s", typeName, entityID, err)
+		}
+
+		if superseded != nil && superseded.Callback != nil {
+			post.Post(func() {
+				superseded.Callback(err)
+			})
+		}
+		return nil, err
+	}), ac)
+}
+
+// pooledStorageRoutine runs r with the storage of the worker, the storage is reopened if the connection is broken
+func pooledStorageRoutine(r func(es storagecommon.EntityStorage) (interface{}, error)) async.PooledAsyncRoutine {
+	return func(worker int) (res interface{}, err error) {
+		for pooledStorages[worker] == nil {
+			es, err := OpenStorage(conf.GetStorage())
+			if err == nil {
+				pooledStorages[worker] = es
+			} else {
+				gwlog.Errorf("Storage engine of worker %d is not ready: %s", worker, err)
+				time.Sleep(time.Second)
+			}
+		}
+
+		es := pooledStorages[worker]
+		res, err = r(es)
+		if err != nil && es.IsEOF(err) {
+			es.Close()
+			pooledStorages[worker] = nil
+		}
+		return
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/typeconv"
+)
+
+func TestLoadSaveAsync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_async")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	async.SetWorkerPoolSize(2)
+	defer async.SetWorkerPoolSize(1)
+	pooledStorages = make([]storagecommon.EntityStorage, 2)
+	for i := range pooledStorages {
+		if pooledStorages[i], err = entitystoragefilesystem.OpenDirectory(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wb := &writeBehindBuffer{
+		pending: map[pendingSaveKey]*list.Element{},
+		order:   list.New(),
+	}
+	writeBehind = wb
+	defer func() {
+		pooledStorages = nil
+		writeBehind = nil
+	}()
+
+	// wait runs posted callbacks until done is called
+	wait := func(op func(done func())) {
+		finished := false
+		op(func() { finished = true })
+		for deadline := time.Now().Add(time.Second * 5); !finished; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("async operation timeout")
+			}
+			post.Tick()
+		}
+	}
+	load := func(eid common.EntityID) (name string) {
+		wait(func(done func()) {
+			LoadAsync("Avatar", eid, func(data interface{}, err error) {
+				if err == nil && data != nil {
+					name = typeconv.String(typeconv.MapStringAnything(data)["name"])
+				}
+				done()
+			})
+		})
+		return
+	}
+
+	eid := common.GenEntityID()
+	wait(func(done func()) {
+		SaveAsync("Avatar", eid, map[string]interface{}{"name": "a1"}, func(err error) {
+			if err != nil {
+				t.Errorf("save failed: %s", err)
+			}
+			done()
+		})
+	})
+	if name := load(eid); name != "a1" {
+		t.Fatalf("load returns %q, expected a1", name)
+	}
+
+	// pending write-behind saves are visible to loads, and superseded by saves
+	supersededCallbacks := 0
+	wb.add(fencedSaveRequest{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"name": "a2"}, Epoch: 1, Callback: func(err error) {
+		supersededCallbacks += 1
+	}})
+	if name := load(eid); name != "a2" {
+		t.Fatalf("load returns %q, expected the pending save a2", name)
+	}
+	wait(func(done func()) {
+		SaveAsync("Avatar", eid, map[string]interface{}{"name": "a3"}, func(err error) {
+			done()
+		})
+	})
+	if name := load(eid); name != "a3" || wb.len() != 0 || supersededCallbacks != 1 {
+		t.Fatalf("load returns %q with %d pending saves and %d superseded callbacks, expected a3, 0, 1", name, wb.len(), supersededCallbacks)
+	}
+}
@@ -0,0 +1,132 @@
+package entitystoragefilesystem
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	_STALE_TEMP_FILE_AGE = time.Minute * 10 // younger temporary files may be written by running storages
+)
+
+// Compact compacts all entity types in the storage, it should run when no game is using the storage
+//
+// Files of the old flat layout are moved into shards, temporary files left by interrupted writes and empty shards are
+// removed, and index files are rewritten with only existing entities.
+func (es *FileSystemEntityStorage) Compact() ([]storagecommon.CompactResult, error) {
+	types, err := es.ListTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]storagecommon.CompactResult, 0, len(types))
+	for _, typeName := range types {
+		result, err := es.compactType(typeName)
+		if err != nil {
+			return results, err
+		}
+		gwlog.Infof("filesystem storage: %s is compacted: %d entities, %d files moved, %d files removed, %d bytes reclaimed",
+			typeName, result.Entities, result.MovedFiles, result.RemovedFiles, result.ReclaimedBytes)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (es *FileSystemEntityStorage) compactType(typeName string) (storagecommon.CompactResult, error) {
+	result := storagecommon.CompactResult{TypeName: typeName}
+
+	legacyIDs, err := es.listLegacy(typeName)
+	if err != nil {
+		return result, err
+	}
+	for _, entityID := range legacyIDs {
+		moved, err := es.moveLegacyFile(typeName, entityID)
+		if err != nil {
+			return result, err
+		}
+		if moved {
+			result.MovedFiles++
+		} else {
+			result.RemovedFiles++ // obsolete version of the entity written to shards
+		}
+	}
+
+	ids := common.EntityIDSet{}
+	staleTime := time.Now().Add(-_STALE_TEMP_FILE_AGE)
+	removeStaleTempFile := func(path string, info os.FileInfo) error {
+		if !strings.HasSuffix(info.Name(), _TEMP_FILE_SUFFIX) || info.ModTime().After(staleTime) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result.RemovedFiles++
+		result.ReclaimedBytes += info.Size()
+		return nil
+	}
+
+	err = es.walkShards(typeName, func(path string, info os.FileInfo) error {
+		if entityID, ok := parseFileName(info.Name()); ok {
+			ids.Add(entityID)
+			return nil
+		}
+		return removeStaleTempFile(path, info)
+	})
+	if err != nil {
+		return result, err
+	}
+
+	// temporary files of index files are in the type directory
+	typeDir, err := os.Open(es.getTypeDir(typeName))
+	if err != nil {
+		return result, err
+	}
+	infos, err := typeDir.Readdir(-1)
+	typeDir.Close()
+	if err != nil {
+		return result, err
+	}
+	for _, info := range infos {
+		path := filepath.Join(es.getTypeDir(typeName), info.Name())
+		if info.IsDir() {
+			os.Remove(path) // fails if the shard is not empty
+		} else if err := removeStaleTempFile(path, info); err != nil {
+			return result, err
+		}
+	}
+
+	var oldIndexSize int64
+	if info, err := os.Stat(es.getIndexPath(typeName)); err == nil {
+		oldIndexSize = info.Size()
+	}
+	if err := es.writeIndex(typeName, ids); err != nil {
+		return result, err
+	}
+	if info, err := os.Stat(es.getIndexPath(typeName)); err == nil && info.Size() < oldIndexSize {
+		result.ReclaimedBytes += oldIndexSize - info.Size()
+	}
+
+	result.Entities = len(ids)
+	return result, nil
+}
+
+// moveLegacyFile moves the entity file of the old flat layout into the shard, and returns false if the legacy file is
+// removed because the entity is already written to the shard
+func (es *FileSystemEntityStorage) moveLegacyFile(typeName string, entityID common.EntityID) (bool, error) {
+	legacyPath := es.getLegacyFilePath(typeName, entityID)
+	path := es.getFilePath(typeName, entityID)
+	if _, err := os.Stat(path); err == nil {
+		return false, os.Remove(legacyPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	return true, os.Rename(legacyPath, path)
+}
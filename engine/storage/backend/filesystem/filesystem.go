@@ -1,3 +1,16 @@
+// Package entitystoragefilesystem implements entity storage by files in a directory
+//
+// Entities of each type are stored in the directory of the type, one file for each entity. Files are sharded into
+// sub-directories by the hash of entity IDs, so that directories stay small with millions of entities. Each type
+// directory has an index file, which is a log of added and deleted entity IDs, so that entities are listed without
+// walking all shards:
+//
+//	<directory>/<type>/<shard>/<base64 of entity ID>
+//	<directory>/<type>/index.log
+//
+// Files of entities are written to temporary files and renamed, so that entities are never partially written.
+// Compact rewrites index files, removes temporary files left by interrupted writes, and moves files of the old flat
+// layout (<directory>/<type>$<base64 of entity ID>), which are still readable before compaction, into shards.
 package entitystoragefilesystem
 
 import (
@@ -8,9 +21,12 @@ import (
 	"encoding/json"
 
 	"encoding/base64"
+	"fmt"
+	"hash/fnv"
 	"os"
 
 	"strings"
+	"sync"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
@@ -18,17 +34,48 @@ import (
 	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 )
 
+const (
+	_SHARD_COUNT      = 256
+	_TEMP_FILE_SUFFIX = ".tmp"
+)
+
+var (
+	fencedWriteLock sync.Mutex // fenced writes of all storages in the process are serialized
+)
+
 // FileSystemEntityStorage is an implementation of Entity Storage using filesystem
 type FileSystemEntityStorage struct {
 	directory string
 }
 
-func getFileName(name string, entityID common.EntityID) string {
-	return name + "$" + base64.URLEncoding.EncodeToString([]byte(entityID))
+func getFileName(entityID common.EntityID) string {
+	return base64.URLEncoding.EncodeToString([]byte(entityID))
+}
+
+func getShard(entityID common.EntityID) string {
+	h := fnv.New32a()
+	h.Write([]byte(entityID))
+	return shardNames[h.Sum32()%_SHARD_COUNT]
+}
+
+var shardNames = func() (names [_SHARD_COUNT]string) {
+	for i := range names {
+		names[i] = fmt.Sprintf("%02x", i)
+	}
+	return
+}()
+
+func (es *FileSystemEntityStorage) getTypeDir(typeName string) string {
+	return filepath.Join(es.directory, typeName)
 }
 
 func (es *FileSystemEntityStorage) getFilePath(typeName string, entityID common.EntityID) string {
-	return filepath.Join(es.directory, getFileName(typeName, entityID))
+	return filepath.Join(es.getTypeDir(typeName), getShard(entityID), getFileName(entityID))
+}
+
+// getLegacyFilePath returns the path of the entity file in the old flat layout
+func (es *FileSystemEntityStorage) getLegacyFilePath(typeName string, entityID common.EntityID) string {
+	return filepath.Join(es.directory, typeName+"$"+getFileName(entityID))
 }
 
 // Write writes entity data to entity storage
@@ -42,13 +89,73 @@ func (es *FileSystemEntityStorage) Write(typeName string, entityID common.Entity
 	if consts.DEBUG_SAVE_LOAD {
 		gwlog.Debugf("Saving to file %s: %s", stringSaveFile, string(dataBytes))
 	}
-	return ioutil.WriteFile(stringSaveFile, dataBytes, 0644)
+
+	_, err = os.Stat(stringSaveFile)
+	isNew := os.IsNotExist(err)
+	if err := writeFileAtomic(stringSaveFile, dataBytes); err != nil {
+		return err
+	}
+	if !isNew {
+		return nil
+	}
+
+	// the legacy file is an obsolete version of the entity now
+	if err := os.Remove(es.getLegacyFilePath(typeName, entityID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return es.appendIndex(typeName, _INDEX_OP_ADD, entityID)
+}
+
+// WriteFenced writes entity data if the saved owner epoch is not newer than epoch
+//
+// The check is atomic in the process, since the directory should be used by only one process.
+func (es *FileSystemEntityStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	fencedWriteLock.Lock()
+	defer fencedWriteLock.Unlock()
+
+	saved, err := es.Read(typeName, entityID)
+	if err != nil {
+		return false, err
+	}
+	if storagecommon.OwnerEpochOf(saved) > epoch {
+		return false, nil
+	}
+	return true, es.Write(typeName, entityID, data)
+}
+
+// writeFileAtomic writes the file by renaming a temporary file, so that the file is never partially written
+func writeFileAtomic(path string, data []byte) error {
+	dir, name := filepath.Split(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, name+".*"+_TEMP_FILE_SUFFIX)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
 }
 
 // Read reads entity data from entity storage
 func (es *FileSystemEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
-	stringSaveFile := es.getFilePath(typeName, entityID)
-	dataBytes, err := ioutil.ReadFile(stringSaveFile)
+	dataBytes, err := ioutil.ReadFile(es.getFilePath(typeName, entityID))
+	if os.IsNotExist(err) {
+		dataBytes, err = ioutil.ReadFile(es.getLegacyFilePath(typeName, entityID))
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			// file not exist
@@ -67,14 +174,52 @@ func (es *FileSystemEntityStorage) Read(typeName string, entityID common.EntityI
 
 // Exists checks if entity is in entity storage
 func (es *FileSystemEntityStorage) Exists(typeName string, entityID common.EntityID) (exists bool, err error) {
-	stringSaveFile := es.getFilePath(typeName, entityID)
-	_, err = os.Stat(stringSaveFile)
-	exists = err == nil || os.IsExist(err)
-	return
+	for _, stringSaveFile := range []string{es.getFilePath(typeName, entityID), es.getLegacyFilePath(typeName, entityID)} {
+		_, err = os.Stat(stringSaveFile)
+		if err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Delete deletes entity from entity storage
+func (es *FileSystemEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	if err := os.Remove(es.getLegacyFilePath(typeName, entityID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err := os.Remove(es.getFilePath(typeName, entityID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return es.appendIndex(typeName, _INDEX_OP_DEL, entityID)
 }
 
 // List retrives all entity IDs in entity storage of specified type
 func (es *FileSystemEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	ids, err := es.readIndex(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyIDs, err := es.listLegacy(typeName)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range legacyIDs {
+		ids.Add(id)
+	}
+	return ids.ToList(), nil
+}
+
+// listLegacy lists entities of the type in the old flat layout
+func (es *FileSystemEntityStorage) listLegacy(typeName string) ([]common.EntityID, error) {
 	prefix := typeName + "$"
 	pat := filepath.Join(es.directory, prefix+"*")
 	files, err := filepath.Glob(pat)
@@ -99,6 +244,24 @@ func (es *FileSystemEntityStorage) List(typeName string) ([]common.EntityID, err
 	return res, nil
 }
 
+// ListTypes retrives all entity types in entity storage
+func (es *FileSystemEntityStorage) ListTypes() ([]string, error) {
+	files, err := ioutil.ReadDir(es.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	types := common.StringSet{}
+	for _, file := range files {
+		if file.IsDir() {
+			types.Add(file.Name())
+		} else if idx := strings.IndexByte(file.Name(), '$'); idx > 0 {
+			types.Add(file.Name()[:idx])
+		}
+	}
+	return types.ToList(), nil
+}
+
 // Close the entity storage
 func (es *FileSystemEntityStorage) Close() {
 	// need to do nothing
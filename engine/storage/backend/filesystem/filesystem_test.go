@@ -1,10 +1,15 @@
 package entitystoragefilesystem
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storagetest"
 )
 
 func TestFileSystemEntityStorage(t *testing.T) {
@@ -63,4 +68,78 @@ func TestFileSystemEntityStorage(t *testing.T) {
 		t.Logf("Read Avatar %s => %v", avatarID, data)
 	}
 
+	if err := es.Delete("Avatar", entityID); err != nil {
+		t.Error(err)
+	}
+	if exists, err := es.Exists("Avatar", entityID); err != nil || exists {
+		t.Errorf("Avatar %s should be deleted", entityID)
+	}
+	if err := es.Delete("Avatar", entityID); err != nil {
+		t.Errorf("delete not existing entity should not fail: %s", err)
+	}
+}
+
+func TestFileSystemConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_filesystem_conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+		return OpenDirectory(dir)
+	})
+}
+
+func TestFileSystemCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_filesystem_compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := es.(*FileSystemEntityStorage)
+
+	legacyID, savedID, deletedID := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	if err := ioutil.WriteFile(fs.getLegacyFilePath("Avatar", legacyID), []byte(`{"a": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	es.Write("Avatar", savedID, map[string]interface{}{"a": 2})
+	es.Write("Avatar", deletedID, map[string]interface{}{"a": 3})
+	es.Delete("Avatar", deletedID)
+
+	staleFile := fs.getFilePath("Avatar", savedID) + ".1" + _TEMP_FILE_SUFFIX
+	ioutil.WriteFile(staleFile, []byte("partial"), 0644)
+	staleTime := time.Now().Add(-_STALE_TEMP_FILE_AGE * 2)
+	os.Chtimes(staleFile, staleTime, staleTime)
+
+	if ids, err := es.List("Avatar"); err != nil || len(ids) != 2 {
+		t.Fatalf("wrong entities before compaction: %v, %v", ids, err)
+	}
+
+	results, err := fs.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Entities != 2 || results[0].MovedFiles != 1 || results[0].RemovedFiles != 1 || results[0].ReclaimedBytes <= 0 {
+		t.Fatalf("wrong compaction results: %+v", results)
+	}
+	if _, err := os.Stat(fs.getLegacyFilePath("Avatar", legacyID)); !os.IsNotExist(err) {
+		t.Fatalf("legacy file should be moved")
+	}
+	if _, err := os.Stat(staleFile); !os.IsNotExist(err) {
+		t.Fatalf("stale temporary file should be removed")
+	}
+	if data, err := es.Read("Avatar", legacyID); err != nil || data.(map[string]interface{})["a"].(float64) != 1 {
+		t.Fatalf("read moved entity failed: %v, %v", data, err)
+	}
+
+	os.Remove(fs.getIndexPath("Avatar"))
+	if ids, err := es.List("Avatar"); err != nil || len(ids) != 2 {
+		t.Fatalf("wrong entities listed by rebuilt index: %v, %v", ids, err)
+	}
 }
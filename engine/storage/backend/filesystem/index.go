@@ -0,0 +1,143 @@
+package entitystoragefilesystem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// The index file of a type is a log of records, one line for each record: '+' or '-' followed by the entity ID, for
+// entities added or deleted. Records are appended by writes and deletes, and the index file is rewritten with only
+// existing entities by Compact. The index file is rebuilt by walking shards if it is missing.
+
+const (
+	_INDEX_FILE_NAME = "index.log"
+	_INDEX_OP_ADD    = '+'
+	_INDEX_OP_DEL    = '-'
+)
+
+func (es *FileSystemEntityStorage) getIndexPath(typeName string) string {
+	return filepath.Join(es.getTypeDir(typeName), _INDEX_FILE_NAME)
+}
+
+// appendIndex appends the record to the index file, records are appended by one write, so that storages of the same
+// directory can append concurrently
+func (es *FileSystemEntityStorage) appendIndex(typeName string, op byte, entityID common.EntityID) error {
+	f, err := os.OpenFile(es.getIndexPath(typeName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, 0, 2+len(entityID))
+	record = append(record, op)
+	record = append(record, entityID...)
+	record = append(record, '\n')
+	_, err = f.Write(record)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readIndex reads entities of the type from the index file, the index file is rebuilt if it is missing
+func (es *FileSystemEntityStorage) readIndex(typeName string) (common.EntityIDSet, error) {
+	ids := common.EntityIDSet{}
+	data, err := ioutil.ReadFile(es.getIndexPath(typeName))
+	if os.IsNotExist(err) {
+		return es.rebuildIndex(typeName)
+	} else if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		record := scanner.Text()
+		if len(record) != 1+common.ENTITYID_LENGTH {
+			gwlog.Warnf("filesystem storage: invalid record in index of %s: %q", typeName, record)
+			continue
+		}
+
+		entityID := common.EntityID(record[1:])
+		switch record[0] {
+		case _INDEX_OP_ADD:
+			ids.Add(entityID)
+		case _INDEX_OP_DEL:
+			ids.Del(entityID)
+		default:
+			gwlog.Warnf("filesystem storage: invalid record in index of %s: %q", typeName, record)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// rebuildIndex rebuilds the index file of the type by walking shards
+func (es *FileSystemEntityStorage) rebuildIndex(typeName string) (common.EntityIDSet, error) {
+	ids := common.EntityIDSet{}
+	if _, err := os.Stat(es.getTypeDir(typeName)); os.IsNotExist(err) {
+		return ids, nil // no entity of the type
+	}
+
+	err := es.walkShards(typeName, func(path string, info os.FileInfo) error {
+		if entityID, ok := parseFileName(info.Name()); ok {
+			ids.Add(entityID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gwlog.Infof("filesystem storage: index of %s is rebuilt with %d entities", typeName, len(ids))
+	return ids, es.writeIndex(typeName, ids)
+}
+
+// writeIndex rewrites the index file with records of the entities
+func (es *FileSystemEntityStorage) writeIndex(typeName string, ids common.EntityIDSet) error {
+	var buf bytes.Buffer
+	for entityID := range ids {
+		buf.WriteByte(_INDEX_OP_ADD)
+		buf.WriteString(string(entityID))
+		buf.WriteByte('\n')
+	}
+	return writeFileAtomic(es.getIndexPath(typeName), buf.Bytes())
+}
+
+// walkShards calls f for each file in shards of the type
+func (es *FileSystemEntityStorage) walkShards(typeName string, f func(path string, info os.FileInfo) error) error {
+	for _, shard := range shardNames {
+		dir := filepath.Join(es.getTypeDir(typeName), shard)
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		for _, info := range files {
+			if err := f(filepath.Join(dir, info.Name()), info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFileName returns the entity ID of the file in shards, temporary files are not entity files
+func parseFileName(name string) (common.EntityID, bool) {
+	if strings.HasSuffix(name, _TEMP_FILE_SUFFIX) {
+		return "", false
+	}
+
+	idbytes, err := base64.URLEncoding.DecodeString(name)
+	if err != nil || len(idbytes) != common.ENTITYID_LENGTH {
+		return "", false
+	}
+	return common.EntityID(idbytes), true
+}
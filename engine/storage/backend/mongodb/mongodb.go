@@ -5,6 +5,7 @@ import (
 	"gopkg.in/mgo.v2/bson"
 
 	"io"
+	"strings"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -50,6 +51,23 @@ func (es *mongoDBEntityStorge) Write(typeName string, entityID common.EntityID,
 	return err
 }
 
+// WriteFenced upserts entity data only if the saved owner epoch is not newer than epoch. If the document exists with a
+// newer epoch, the filter does not match and the upsert fails with duplicate key.
+func (es *mongoDBEntityStorge) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	col := es.getCollection(typeName)
+	epochField := "data." + storagecommon.OWNER_EPOCH_KEY
+	_, err := col.Upsert(bson.M{
+		"_id":      entityID,
+		epochField: bson.M{"$not": bson.M{"$gt": int64(epoch)}},
+	}, bson.M{
+		"data": data,
+	})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (es *mongoDBEntityStorge) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	col := es.getCollection(typeName)
 	q := col.FindId(entityID)
@@ -112,6 +130,22 @@ func (es *mongoDBEntityStorge) List(typeName string) ([]common.EntityID, error)
 	return entityIDs, nil
 }
 
+// ListTypes lists collections in the database as entity types, so KVDB should not be stored in the same database
+func (es *mongoDBEntityStorge) ListTypes() ([]string, error) {
+	names, err := es.db.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, "system.") {
+			types = append(types, name)
+		}
+	}
+	return types, nil
+}
+
 func (es *mongoDBEntityStorge) Exists(typeName string, entityID common.EntityID) (bool, error) {
 	col := es.getCollection(typeName)
 	query := col.FindId(entityID)
@@ -127,6 +161,15 @@ func (es *mongoDBEntityStorge) Exists(typeName string, entityID common.EntityID)
 	}
 }
 
+func (es *mongoDBEntityStorge) Delete(typeName string, entityID common.EntityID) error {
+	col := es.getCollection(typeName)
+	err := col.RemoveId(entityID)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
 func (es *mongoDBEntityStorge) Close() {
 	es.db.Session.Close()
 }
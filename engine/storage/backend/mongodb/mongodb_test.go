@@ -5,6 +5,8 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storagetest"
 )
 
 func TestMongoDBEntityStorage(t *testing.T) {
@@ -64,3 +66,9 @@ func TestMongoDBEntityStorage(t *testing.T) {
 	}
 
 }
+
+func TestMongoDBConformance(t *testing.T) {
+	storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+		return OpenMongoDB("mongodb://localhost:27017/goworld", "goworld")
+	})
+}
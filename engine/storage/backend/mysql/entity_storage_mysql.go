@@ -44,11 +44,23 @@ func (es *mysqlEntityStorage) createTableForEntityTypeIfNotExists(typeName strin
 		return nil
 	}
 
-	es.visitedEntityTypes.Add(typeName)
-	_, err := es.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`(`id` CHAR(%d) NOT NULL PRIMARY KEY, `data` BLOB NOT NULL)", typeName, common.ENTITYID_LENGTH))
+	_, err := es.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s`(`id` CHAR(%d) NOT NULL PRIMARY KEY, `data` BLOB NOT NULL, `epoch` BIGINT NOT NULL DEFAULT 0)", typeName, common.ENTITYID_LENGTH))
+	if err != nil {
+		return err
+	}
+
+	// the owner epoch column is added to tables created before fenced writes
+	var hasEpoch int
+	err = es.db.QueryRow("SELECT COUNT(*) FROM `information_schema`.`columns` WHERE `table_schema` = DATABASE() AND `table_name` = ? AND `column_name` = 'epoch'", typeName).Scan(&hasEpoch)
 	if err != nil {
 		return err
 	}
+	if hasEpoch == 0 {
+		if _, err = es.db.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `epoch` BIGINT NOT NULL DEFAULT 0", typeName)); err != nil {
+			return err
+		}
+	}
+	es.visitedEntityTypes.Add(typeName)
 	return nil
 }
 
@@ -83,6 +95,25 @@ func (es *mysqlEntityStorage) List(typeName string) ([]common.EntityID, error) {
 	return eids, nil
 }
 
+// ListTypes lists tables of entities in the database
+func (es *mysqlEntityStorage) ListTypes() ([]string, error) {
+	rows, err := es.db.Query("SELECT `table_name` FROM `information_schema`.`columns` WHERE `table_schema` = DATABASE() AND `column_name` IN ('id', 'data') GROUP BY `table_name` HAVING COUNT(*) = 2")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []string{}
+	for rows.Next() {
+		var typeName string
+		if err = rows.Scan(&typeName); err != nil {
+			return nil, err
+		}
+		types = append(types, typeName)
+	}
+	return types, rows.Err()
+}
+
 func (es *mysqlEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
 	err := es.createTableForEntityTypeIfNotExists(typeName)
 	if err != nil {
@@ -94,11 +125,43 @@ func (es *mysqlEntityStorage) Write(typeName string, entityID common.EntityID, d
 		return err
 	}
 
-	_, err = es.db.Exec(fmt.Sprintf("INSERT INTO `%s`(`id`, `data`) VALUES(?, ?) ON DUPLICATE KEY UPDATE `data` = ?", typeName), string(entityID), b, b)
+	epoch := int64(storagecommon.OwnerEpochOf(data))
+	_, err = es.db.Exec(fmt.Sprintf("INSERT INTO `%s`(`id`, `data`, `epoch`) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE `data` = ?, `epoch` = ?", typeName), string(entityID), b, epoch, b, epoch)
 	//gwlog.Infof("INSERT ...: %v", err)
 	return err
 }
 
+// WriteFenced inserts or updates entity data only if the owner epoch column is not newer than epoch
+func (es *mysqlEntityStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	err := es.createTableForEntityTypeIfNotExists(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := packData(data)
+	if err != nil {
+		return false, err
+	}
+
+	// `data` is assigned before `epoch`, so both conditions compare the saved epoch
+	r, err := es.db.Exec(fmt.Sprintf("INSERT INTO `%s`(`id`, `data`, `epoch`) VALUES(?, ?, ?) ON DUPLICATE KEY UPDATE `data` = IF(`epoch` <= ?, VALUES(`data`), `data`), `epoch` = IF(`epoch` <= ?, VALUES(`epoch`), `epoch`)", typeName),
+		string(entityID), b, int64(storagecommon.OwnerEpochOf(data)), int64(epoch), int64(epoch))
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+
+	// no row is changed: either the saved epoch is newer, or the same data is written again
+	var savedEpoch int64
+	if err = es.db.QueryRow("SELECT `epoch` FROM `"+typeName+"` WHERE `id` = ?", string(entityID)).Scan(&savedEpoch); err != nil {
+		return false, err
+	}
+	return uint64(savedEpoch) <= epoch, nil
+}
+
 func (es *mysqlEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	if err := es.createTableForEntityTypeIfNotExists(typeName); err != nil {
 		return nil, err
@@ -138,6 +201,15 @@ func (es *mysqlEntityStorage) Exists(typeName string, entityID common.EntityID)
 	return true, nil
 }
 
+func (es *mysqlEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	if err := es.createTableForEntityTypeIfNotExists(typeName); err != nil {
+		return err
+	}
+
+	_, err := es.db.Exec("DELETE FROM `"+typeName+"` WHERE `id` = ?", string(entityID))
+	return err
+}
+
 func (es *mysqlEntityStorage) Close() {
 	es.db.Close()
 }
@@ -7,6 +7,8 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storagetest"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -87,3 +89,13 @@ func TestMySQLEntityStorage(t *testing.T) {
 	}
 
 }
+
+func TestMySQLConformance(t *testing.T) {
+	pwd := "testmysql"
+	if os.Getenv("TRAVIS") != "" {
+		pwd = ""
+	}
+	storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+		return OpenMySQL("root:" + pwd + "@tcp(127.0.0.1:3306)/goworld")
+	})
+}
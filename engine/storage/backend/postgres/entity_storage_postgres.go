@@ -0,0 +1,248 @@
+package entitystoragepostgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type postgresEntityStorage struct {
+	db         *sql.DB
+	lock       sync.Mutex
+	statements map[string]*entityTypeStatements // prepared statements of entity types
+}
+
+type entityTypeStatements struct {
+	list        *sql.Stmt
+	write       *sql.Stmt
+	writeFenced *sql.Stmt
+	read        *sql.Stmt
+	exists      *sql.Stmt
+	delete      *sql.Stmt
+}
+
+// OpenPostgres opens PostgreSQL as entity storage
+//
+// The database/sql driver must be registered by importing it in the game, e.g. github.com/lib/pq for driver postgres.
+// Entities are stored in tables named by entity types, with attributes in JSONB columns.
+func OpenPostgres(driverName string, url string, maxOpenConns int, maxIdleConns int, connMaxLifetime time.Duration) (storagecommon.EntityStorage, error) {
+	db, err := sql.Open(driverName, url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open postgres failed, is the %s driver imported?", driverName)
+	}
+
+	// pool settings which are not positive are left as database/sql defaults
+	if maxOpenConns > 0 {
+		db.SetMaxOpenConns(maxOpenConns)
+	}
+	if maxIdleConns > 0 {
+		db.SetMaxIdleConns(maxIdleConns)
+	}
+	if connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(connMaxLifetime)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &postgresEntityStorage{
+		db:         db,
+		statements: map[string]*entityTypeStatements{},
+	}, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// getStatements creates the table of the entity type if not exists, and returns the prepared statements
+func (es *postgresEntityStorage) getStatements(typeName string) (*entityTypeStatements, error) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	if stmts := es.statements[typeName]; stmts != nil {
+		return stmts, nil
+	}
+
+	table := quoteIdentifier(typeName)
+	if _, err := es.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		"id" CHAR(%d) NOT NULL PRIMARY KEY,
+		"data" JSONB NOT NULL,
+		"updated_at" TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, table, common.ENTITYID_LENGTH)); err != nil {
+		return nil, errors.Wrapf(err, "create table %s failed", table)
+	}
+
+	stmts := &entityTypeStatements{}
+	for _, prepare := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.list, `SELECT "id" FROM %s`},
+		{&stmts.write, `INSERT INTO %s("id", "data") VALUES($1, $2::jsonb) ON CONFLICT ("id") DO UPDATE SET "data" = EXCLUDED."data", "updated_at" = now()`},
+		{&stmts.writeFenced, `INSERT INTO %[1]s("id", "data") VALUES($1, $2::jsonb) ON CONFLICT ("id") DO UPDATE SET "data" = EXCLUDED."data", "updated_at" = now() WHERE COALESCE((%[1]s."data"->>'` + storagecommon.OWNER_EPOCH_KEY + `')::bigint, 0) <= $3`},
+		{&stmts.read, `SELECT "data" FROM %s WHERE "id" = $1`},
+		{&stmts.exists, `SELECT 1 FROM %s WHERE "id" = $1`},
+		{&stmts.delete, `DELETE FROM %s WHERE "id" = $1`},
+	} {
+		stmt, err := es.db.Prepare(fmt.Sprintf(prepare.query, table))
+		if err != nil {
+			stmts.close()
+			return nil, errors.Wrapf(err, "prepare statement for %s failed", table)
+		}
+		*prepare.stmt = stmt
+	}
+
+	es.statements[typeName] = stmts
+	return stmts, nil
+}
+
+func (stmts *entityTypeStatements) close() {
+	for _, stmt := range []*sql.Stmt{stmts.list, stmts.write, stmts.writeFenced, stmts.read, stmts.exists, stmts.delete} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (es *postgresEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmts.list.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	eids := []common.EntityID{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		eids = append(eids, common.EntityID(id))
+	}
+	return eids, rows.Err()
+}
+
+// ListTypes lists tables of entities in the current schema
+func (es *postgresEntityStorage) ListTypes() ([]string, error) {
+	rows, err := es.db.Query(`SELECT "table_name" FROM "information_schema"."columns" WHERE "table_schema" = current_schema() AND "column_name" IN ('id', 'data') GROUP BY "table_name" HAVING COUNT(*) = 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := []string{}
+	for rows.Next() {
+		var typeName string
+		if err = rows.Scan(&typeName); err != nil {
+			return nil, err
+		}
+		types = append(types, typeName)
+	}
+	return types, rows.Err()
+}
+
+func (es *postgresEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = stmts.write.Exec(string(entityID), string(b))
+	return err
+}
+
+// WriteFenced upserts entity data only if the owner epoch in the saved data is not newer than epoch
+func (es *postgresEntityStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+	r, err := stmts.writeFenced.Exec(string(entityID), string(b), int64(epoch))
+	if err != nil {
+		return false, err
+	}
+	n, err := r.RowsAffected()
+	return n > 0, err
+}
+
+func (es *postgresEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	if err = stmts.read.QueryRow(string(entityID)).Scan(&b); err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *postgresEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	var dummy int
+	if err = stmts.exists.QueryRow(string(entityID)).Scan(&dummy); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (es *postgresEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	stmts, err := es.getStatements(typeName)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmts.delete.Exec(string(entityID))
+	return err
+}
+
+func (es *postgresEntityStorage) Close() {
+	es.lock.Lock()
+	for _, stmts := range es.statements {
+		stmts.close()
+	}
+	es.statements = map[string]*entityTypeStatements{}
+	es.lock.Unlock()
+	es.db.Close()
+}
+
+func (es *postgresEntityStorage) IsEOF(err error) bool {
+	return true
+}
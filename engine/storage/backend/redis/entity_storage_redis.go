@@ -12,6 +12,18 @@ import (
 
 var (
 	dataPacker = netutil.MessagePackMsgPacker{}
+	// writeFencedScript sets the entity data if the owner epoch (keyed ARGV[3]) in the saved data is not newer than ARGV[2]
+	writeFencedScript = redis.NewScript(1, `
+local saved = redis.call('GET', KEYS[1])
+if saved then
+	local ok, data = pcall(cmsgpack.unpack, saved)
+	if ok and type(data) == 'table' and tonumber(data[ARGV[3]] or 0) > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
 )
 
 type redisEntityStorage struct {
@@ -92,6 +104,17 @@ func (es *redisEntityStorage) Write(typeName string, entityID common.EntityID, d
 	return err
 }
 
+// WriteFenced sets entity data by the script, which checks the owner epoch in the saved data atomically
+func (es *redisEntityStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	b, err := packData(data)
+	if err != nil {
+		return false, err
+	}
+
+	written, err := redis.Int(writeFencedScript.Do(es.c, entityKey(typeName, entityID), b, epoch, storagecommon.OWNER_EPOCH_KEY))
+	return written == 1, err
+}
+
 func (es *redisEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	b, err := redis.Bytes(es.c.Do("GET", entityKey(typeName, entityID)))
 	if err != nil {
@@ -110,6 +133,11 @@ func (es *redisEntityStorage) Exists(typeName string, entityID common.EntityID)
 	return exists, err
 }
 
+func (es *redisEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	_, err := es.c.Do("DEL", entityKey(typeName, entityID))
+	return err
+}
+
 func (es *redisEntityStorage) Close() {
 	es.c.Close()
 }
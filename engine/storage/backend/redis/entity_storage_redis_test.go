@@ -5,6 +5,8 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storagetest"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -65,3 +67,9 @@ func TestRedisEntityStorage(t *testing.T) {
 	}
 
 }
+
+func TestRedisConformance(t *testing.T) {
+	storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+		return OpenRedis("redis://localhost:6379", 0)
+	})
+}
@@ -14,6 +14,18 @@ import (
 
 var (
 	dataPacker = netutil.MessagePackMsgPacker{}
+	// writeFencedScript sets the entity data if the owner epoch (keyed ARGV[3]) in the saved data is not newer than ARGV[2]
+	writeFencedScript = `
+local saved = redis.call('GET', KEYS[1])
+if saved then
+	local ok, data = pcall(cmsgpack.unpack, saved)
+	if ok and type(data) == 'table' and tonumber(data[ARGV[3]] or 0) > tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`
 )
 
 type redisClusterEntityStorage struct {
@@ -96,6 +108,20 @@ func (es *redisClusterEntityStorage) Write(typeName string, entityID common.Enti
 	return err
 }
 
+// WriteFenced sets entity data by the script, which checks the owner epoch in the saved data atomically
+//
+// The cluster client routes commands by the first argument, so the script is redirected to the node of the entity key
+// by the cluster.
+func (es *redisClusterEntityStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	b, err := packData(data)
+	if err != nil {
+		return false, err
+	}
+
+	written, err := redis.Int(es.c.Do("EVAL", writeFencedScript, 1, entityKey(typeName, entityID), b, epoch, storagecommon.OWNER_EPOCH_KEY))
+	return written == 1, err
+}
+
 func (es *redisClusterEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	b, err := redis.Bytes(es.c.Do("GET", entityKey(typeName, entityID)))
 	if err != nil {
@@ -114,6 +140,11 @@ func (es *redisClusterEntityStorage) Exists(typeName string, entityID common.Ent
 	return exists, err
 }
 
+func (es *redisClusterEntityStorage) Delete(typeName string, entityID common.EntityID) error {
+	_, err := es.c.Do("DEL", entityKey(typeName, entityID))
+	return err
+}
+
 func (es *redisClusterEntityStorage) Close() {
 }
 
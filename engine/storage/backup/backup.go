@@ -0,0 +1,301 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/crontab"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+)
+
+const (
+	_BACKUP_FILE_PREFIX = "backup_"
+	_BACKUP_TIME_FORMAT = "20060102_150405"
+)
+
+var (
+	conf    *config.Config // config of the game, set by Setup
+	running int32
+
+	successCount    = expvar.NewInt("BackupSuccessCount")
+	failCount       = expvar.NewInt("BackupFailCount")
+	lastSuccessTime = expvar.NewInt("BackupLastSuccessTime")
+	lastBackupFile  = expvar.NewString("BackupLastFile")
+)
+
+// Setup setups periodic backups of entity storage according to [storage].backup_schedule of the config
+//
+// Backups should be coordinated by only one process in the cluster
+func Setup(c *config.Config) {
+	conf = c
+	cfg := conf.GetStorage()
+	if cfg.BackupSchedule == "" {
+		return
+	}
+
+	minute, hour, day, month, dayofweek, err := crontab.ParseSchedule(cfg.BackupSchedule)
+	if err != nil {
+		gwlog.Fatalf("invalid [storage].backup_schedule: %s", err)
+	}
+
+	gwlog.Infof("Storage backup is scheduled at %#v, backup directory = %s, retention = %s", cfg.BackupSchedule, cfg.BackupDir, cfg.BackupRetention)
+	crontab.Register(minute, hour, day, month, dayofweek, func() {
+		go Run()
+	})
+}
+
+// Run makes a backup of entity storage and prunes expired backups
+//
+// Run blocks until backup is finished, and does nothing if another backup is running
+func Run() {
+	if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+		gwlog.Warnf("backup: previous backup is still running, skip")
+		return
+	}
+	defer atomic.StoreInt32(&running, 0)
+
+	cfg := conf.GetStorage()
+	monop := opmon.StartOperation("storage.backup")
+	backupFile, err := makeBackup(cfg, time.Now())
+	monop.Finish(time.Minute)
+	if err != nil {
+		failCount.Add(1)
+		gwlog.Errorf("backup: backup %s storage failed: %s", cfg.Type, err)
+		return
+	}
+
+	successCount.Add(1)
+	lastSuccessTime.Set(time.Now().Unix())
+	lastBackupFile.Set(backupFile)
+	gwlog.Infof("backup: backup %s storage to %s successfully", cfg.Type, backupFile)
+
+	if err := pruneBackups(cfg.BackupDir, cfg.BackupRetention, time.Now()); err != nil {
+		gwlog.Errorf("backup: prune expired backups failed: %s", err)
+	}
+}
+
+func makeBackup(cfg *config.StorageConfig, now time.Time) (string, error) {
+	if err := os.MkdirAll(cfg.BackupDir, 0755); err != nil {
+		return "", err
+	}
+
+	backupFile := filepath.Join(cfg.BackupDir, _BACKUP_FILE_PREFIX+cfg.Type+"_"+now.Format(_BACKUP_TIME_FORMAT))
+	var err error
+	if cfg.Type == "filesystem" {
+		backupFile += ".tar.gz"
+		err = backupDirectory(cfg.Directory, backupFile)
+	} else if cfg.Type == "mongodb" {
+		backupFile += ".archive.gz"
+		err = backupMongoDB(cfg.Url, cfg.DB, backupFile)
+	} else if cfg.Type == "redis" {
+		backupFile += ".rdb"
+		dbURL, password := splitURLPassword(cfg.Url)
+		err = runBackupCommand(passwordEnv("REDISCLI_AUTH", password), "redis-cli", "-u", dbURL, "--rdb", backupFile)
+	} else if cfg.Type == "sql" && cfg.Driver == "mysql" {
+		backupFile += ".sql"
+		err = backupMySQL(cfg.Url, backupFile)
+	} else if cfg.Type == "postgres" {
+		backupFile += ".dump"
+		dbURL, password := splitURLPassword(cfg.Url)
+		err = runBackupCommand(passwordEnv("PGPASSWORD", password), "pg_dump", "--dbname="+dbURL, "--format=custom", "--file="+backupFile)
+	} else {
+		err = errors.Errorf("backup is not supported for %s storage", cfg.Type)
+	}
+
+	if err != nil {
+		os.Remove(backupFile)
+		return "", err
+	}
+	return backupFile, nil
+}
+
+// runBackupCommand runs the backup tool with extra environment variables
+//
+// Credentials must be passed by environment variables or files, since command lines are visible to all local users.
+func runBackupCommand(env []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s failed: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func backupMongoDB(dbURL string, db string, backupFile string) error {
+	// the uri may contain the password, so it is passed by the config file
+	configFile, err := writeSecretFile("mongodump", "uri: "+strconv.Quote(dbURL)+"\n")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(configFile)
+
+	return runBackupCommand(nil, "mongodump", "--config="+configFile, "--db="+db, "--archive="+backupFile, "--gzip")
+}
+
+func backupMySQL(dbURL string, backupFile string) error {
+	dsn, err := mysql.ParseDSN(dbURL)
+	if err != nil {
+		return errors.Wrap(err, "parse mysql url failed")
+	}
+
+	args := []string{"--single-transaction", "--result-file=" + backupFile, "--user=" + dsn.User}
+	if dsn.Passwd != "" {
+		// the password is passed by the option file, which must be the first argument
+		optionFile, err := writeSecretFile("mysqldump", "[client]\npassword="+quoteMySQLOption(dsn.Passwd)+"\n")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(optionFile)
+		args = append([]string{"--defaults-extra-file=" + optionFile}, args...)
+	}
+	if dsn.Net == "tcp" {
+		host, port, err := net.SplitHostPort(dsn.Addr)
+		if err != nil { // address without port
+			host, port = strings.TrimSuffix(strings.TrimPrefix(dsn.Addr, "["), "]"), "3306"
+		}
+		args = append(args, "--host="+host, "--port="+port)
+	} else if dsn.Net == "unix" {
+		args = append(args, "--socket="+dsn.Addr)
+	}
+	args = append(args, dsn.DBName)
+	return runBackupCommand(nil, "mysqldump", args...)
+}
+
+// writeSecretFile writes the content to a temporary file which is only readable by the current user
+func writeSecretFile(prefix string, content string) (string, error) {
+	f, err := ioutil.TempFile("", prefix+"_")
+	if err != nil {
+		return "", err
+	}
+	if err = f.Chmod(0600); err == nil {
+		_, err = f.WriteString(content)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func quoteMySQLOption(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// splitURLPassword removes the password from the url, urls which can not be parsed are returned as they are
+func splitURLPassword(dbURL string) (string, string) {
+	u, err := url.Parse(dbURL)
+	if err != nil || u.User == nil {
+		return dbURL, ""
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return dbURL, ""
+	}
+	if username := u.User.Username(); username != "" {
+		u.User = url.User(username)
+	} else {
+		u.User = nil
+	}
+	return u.String(), password
+}
+
+func passwordEnv(name string, password string) []string {
+	if password == "" {
+		return nil
+	}
+	return []string{name + "=" + password}
+}
+
+// backupDirectory packs all files in directory to a .tar.gz file
+func backupDirectory(directory string, backupFile string) error {
+	f, err := os.Create(backupFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(relPath)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// pruneBackups removes backups older than retention
+func pruneBackups(backupDir string, retention time.Duration, now time.Time) error {
+	files, err := filepath.Glob(filepath.Join(backupDir, _BACKUP_FILE_PREFIX+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		if now.Sub(info.ModTime()) <= retention {
+			continue
+		}
+
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+		gwlog.Infof("backup: pruned expired backup %s", file)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	// OWNER_EPOCH_KEY is the key of owner epoch in saved entity data
+	OWNER_EPOCH_KEY = storagecommon.OWNER_EPOCH_KEY
+)
+
+var (
+	// ErrStaleOwnerEpoch is returned by fenced saves when the entity data is already saved by a newer owner
+	ErrStaleOwnerEpoch = errors.New("stale owner epoch")
+
+	staleOwnerSavesRejected = expvar.NewInt("StaleOwnerSavesRejected")
+)
+
+// FencedSaveCallbackFunc is the callback type of storage SaveFenced
+type FencedSaveCallbackFunc func(err error)
+
+type fencedSaveRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Data     map[string]interface{}
+	Epoch    uint64
+	Callback FencedSaveCallbackFunc
+}
+
+// SaveFenced saves entity data to storage if it is not saved by a newer owner of the entity
+//
+// Owners of the entity should use monotonically increasing epochs, so that a stale copy of the entity can not overwrite
+// data saved by the authoritative copy. ErrStaleOwnerEpoch is passed to callback if the save is rejected.
+func SaveFenced(typeName string, entityID common.EntityID, data map[string]interface{}, epoch uint64, callback FencedSaveCallbackFunc) {
+	data[OWNER_EPOCH_KEY] = int64(epoch)
+	req := fencedSaveRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Data:     data,
+		Epoch:    epoch,
+		Callback: callback,
+	}
+	if writeBehind != nil {
+		writeBehind.add(req)
+		return
+	}
+
+	operationQueue.Push(req)
+	checkOperationQueueLen()
+}
+
+// handleFencedSave writes the fenced save request in storage routine, and retries until it succeeds or is rejected
+func handleFencedSave(saveReq fencedSaveRequest) {
+	monop := opmon.StartOperation("storage.saveFenced")
+	for {
+		err := assureStorageEngineReady()
+		if err != nil {
+			gwlog.Errorf("Storage engine is not ready: %s", err)
+			time.Sleep(time.Second) // wait for 1 second to retry
+			continue
+		}
+
+		err = writeFenced(saveReq.TypeName, saveReq.EntityID, saveReq.Data, saveReq.Epoch)
+		if err != nil && errors.Cause(err) != ErrStaleOwnerEpoch {
+			gwlog.Errorf("storage: save failed: %s", err)
+
+			if storageEngine.IsEOF(err) {
+				storageEngine.Close()
+				storageEngine = nil
+			}
+
+			continue // always retry if fail
+		}
+
+		monop.Finish(time.Millisecond * 100)
+		if saveReq.Callback != nil {
+			post.Post(func() {
+				saveReq.Callback(err)
+			})
+		}
+		break
+	}
+}
+
+// GetOwnerEpoch returns the owner epoch in saved entity data, or 0 if the data is saved without epoch
+func GetOwnerEpoch(data map[string]interface{}) uint64 {
+	return storagecommon.OwnerEpochOf(data)
+}
+
+// writeFenced writes entity data if the saved owner epoch is not newer than epoch, by a conditional write of the storage
+func writeFenced(typeName string, entityID common.EntityID, data map[string]interface{}, epoch uint64) error {
+	written, err := storagecommon.WriteFenced(storageEngine, typeName, entityID, data, epoch)
+	if err != nil {
+		return err
+	}
+	if !written {
+		staleOwnerSavesRejected.Add(1)
+		return errors.Wrapf(ErrStaleOwnerEpoch, "%s %s is saved by a newer owner, save of epoch %d", typeName, entityID, epoch)
+	}
+	return nil
+}
+
+// claimOwnerEpoch claims the owner epoch next to the saved one for the loaded entity data
+func claimOwnerEpoch(typeName string, entityID common.EntityID, data interface{}) error {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("%s %s: invalid entity data: %T", typeName, entityID, data)
+	}
+	epoch := storagecommon.OwnerEpochOf(m) + 1
+	claimed, err := storagecommon.ClaimOwnerEpoch(storageEngine, typeName, entityID, m, epoch)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		staleOwnerSavesRejected.Add(1)
+		return errors.Wrapf(ErrStaleOwnerEpoch, "%s %s is loaded by another owner, claim of epoch %d", typeName, entityID, epoch)
+	}
+	return nil
+}
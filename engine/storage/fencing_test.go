@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/typeconv"
+)
+
+func TestWriteFenced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_fencing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		storageEngine = nil
+	}()
+
+	eid := common.GenEntityID()
+	save := func(name string, epoch uint64) error {
+		return writeFenced("Avatar", eid, map[string]interface{}{"name": name, OWNER_EPOCH_KEY: int64(epoch)}, epoch)
+	}
+
+	if err := save("old", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("new", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("stale", 1); errors.Cause(err) != ErrStaleOwnerEpoch {
+		t.Fatalf("save of stale owner should be rejected: %v", err)
+	}
+
+	data, err := storageEngine.Read("Avatar", eid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := typeconv.MapStringAnything(data)
+	if saved["name"] != "new" || GetOwnerEpoch(saved) != 2 {
+		t.Fatalf("saved data is overwritten by stale owner: %v", saved)
+	}
+
+	// data saved without epoch can be overwritten by any owner
+	if err := storageEngine.Write("Avatar", eid, map[string]interface{}{"name": "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := save("any", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaimOwnerEpoch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_claim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		storageEngine = nil
+	}()
+
+	eid := common.GenEntityID()
+	if err := storageEngine.Write("Avatar", eid, map[string]interface{}{"name": "saved", OWNER_EPOCH_KEY: int64(3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// two games load the same data at the same time
+	load := func() map[string]interface{} {
+		data, err := storageEngine.Read("Avatar", eid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return typeconv.MapStringAnything(data)
+	}
+	winner, loser := load(), load()
+	if err := claimOwnerEpoch("Avatar", eid, winner); err != nil {
+		t.Fatal(err)
+	}
+	if GetOwnerEpoch(winner) != 4 {
+		t.Fatalf("claimed epoch should be next to the saved one, but is %d", GetOwnerEpoch(winner))
+	}
+	if err := claimOwnerEpoch("Avatar", eid, loser); errors.Cause(err) != ErrStaleOwnerEpoch {
+		t.Fatalf("claim of the same epoch by another owner should fail: %v", err)
+	}
+	if GetOwnerEpoch(load()) != 4 {
+		t.Fatalf("claimed epoch is not saved")
+	}
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Previous namespace
+//
+// Entities are partitioned by [storage].namespace (e.g. season ID). When the namespace is changed for a new season, the
+// data of the last season is kept in its namespace, and can be read by LoadPrevious and ExistsPrevious if
+// [storage].previous_namespace is set, e.g. for carrying over progress of players. The previous namespace is read-only.
+
+const (
+	_STORAGE_PREVIOUS_ASYNC_JOB_GROUP = "_storage_previous"
+)
+
+var (
+	previousStorage storagecommon.EntityStorage // opened lazily by the async job group
+)
+
+// LoadPrevious loads entity data from the previous namespace, returns in callback on the game goroutine
+func LoadPrevious(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(res, err)
+		}
+	}
+
+	async.AppendAsyncJob(_STORAGE_PREVIOUS_ASYNC_JOB_GROUP, previousStorageRoutine(func(es storagecommon.EntityStorage) (interface{}, error) {
+		monop := opmon.StartOperation("storage.loadPrevious")
+		data, err := es.Read(typeName, entityID)
+		monop.Finish(time.Millisecond * 100)
+		if err != nil {
+			gwlog.TraceError("storage: load previous %s %s failed: %s", typeName, entityID, err)
+		}
+		return data, err
+	}), ac)
+}
+
+// ExistsPrevious checks if entity exists in the previous namespace, returns in callback on the game goroutine
+func ExistsPrevious(typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			exists, _ := res.(bool)
+			callback(exists, err)
+		}
+	}
+
+	async.AppendAsyncJob(_STORAGE_PREVIOUS_ASYNC_JOB_GROUP, previousStorageRoutine(func(es storagecommon.EntityStorage) (interface{}, error) {
+		return es.Exists(typeName, entityID)
+	}), ac)
+}
+
+// previousStorageRoutine runs r with the storage of the previous namespace, the storage is reopened if the connection
+// is broken
+func previousStorageRoutine(r func(es storagecommon.EntityStorage) (interface{}, error)) async.AsyncRoutine {
+	return func() (res interface{}, err error) {
+		cfg := *conf.GetStorage()
+		if !cfg.UsePreviousNamespace {
+			return nil, errors.Errorf("[storage].previous_namespace is not set")
+		}
+
+		if previousStorage == nil {
+			cfg.Namespace = cfg.PreviousNamespace
+			if previousStorage, err = OpenStorage(&cfg); err != nil {
+				previousStorage = nil
+				return nil, err
+			}
+		}
+
+		res, err = r(previousStorage)
+		if err != nil && previousStorage.IsEOF(err) {
+			previousStorage.Close()
+			previousStorage = nil
+		}
+		return
+	}
+}
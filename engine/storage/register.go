@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/backend/mongodb"
+	"github.com/xiaonanln/goworld/engine/storage/backend/mysql"
+	"github.com/xiaonanln/goworld/engine/storage/backend/postgres"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redis"
+	"github.com/xiaonanln/goworld/engine/storage/backend/redis_cluster"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Factory opens the entity storage backend with the storage config
+//
+// Backends can read custom options from cfg.Options, which are set by option_<name> keys in the storage section.
+type Factory func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{}
+)
+
+func init() {
+	Register("filesystem", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		return entitystoragefilesystem.OpenDirectory(cfg.Directory)
+	})
+	Register("mongodb", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		return entitystoragemongodb.OpenMongoDB(cfg.Url, cfg.DB)
+	})
+	Register("redis", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		var dbindex int = -1
+		if cfg.DB != "" {
+			var err error
+			if dbindex, err = strconv.Atoi(cfg.DB); err != nil {
+				return nil, err
+			}
+		}
+		return entitystorageredis.OpenRedis(cfg.Url, dbindex)
+	})
+	Register("redis_cluster", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		return entitystoragerediscluster.OpenRedisCluster(cfg.StartNodes.ToList())
+	})
+	Register("postgres", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		return entitystoragepostgres.OpenPostgres(cfg.Driver, cfg.Url, cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxLifetime)
+	})
+	Register("sql", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		if cfg.Driver != "mysql" {
+			gwlog.Panicf("unknown sql driver: %s", cfg.Driver)
+		}
+		return entitystoragemysql.OpenMySQL(cfg.Url)
+	})
+}
+
+// Register registers the entity storage backend of the storage type, so that it can be used by type=<typeName> in config
+//
+// Backends in other modules should be registered in init functions, before config is loaded.
+func Register(typeName string, factory Factory) {
+	if typeName == "" || factory == nil {
+		gwlog.Panicf("storage.Register: invalid storage type %q", typeName)
+	}
+
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, ok := factories[typeName]; ok {
+		gwlog.Panicf("storage.Register: storage type %s is already registered", typeName)
+	}
+	factories[typeName] = factory
+	config.RegisterStorageType(typeName)
+}
+
+func getFactory(typeName string) Factory {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	return factories[typeName]
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type memoryStorage struct {
+	options map[string]string
+	data    map[common.EntityID]interface{}
+}
+
+func (es *memoryStorage) List(typeName string) (eids []common.EntityID, err error) {
+	for eid := range es.data {
+		eids = append(eids, eid)
+	}
+	return
+}
+
+func (es *memoryStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	es.data[entityID] = data
+	return nil
+}
+
+func (es *memoryStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	return es.data[entityID], nil
+}
+
+func (es *memoryStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	_, ok := es.data[entityID]
+	return ok, nil
+}
+
+func (es *memoryStorage) Delete(typeName string, entityID common.EntityID) error {
+	delete(es.data, entityID)
+	return nil
+}
+
+func (es *memoryStorage) Close() {}
+
+func (es *memoryStorage) IsEOF(err error) bool {
+	return false
+}
+
+func TestRegister(t *testing.T) {
+	Register("test_memory", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+		return &memoryStorage{options: cfg.Options, data: map[common.EntityID]interface{}{}}, nil
+	})
+
+	es, err := OpenStorage(&config.StorageConfig{Type: "test_memory", Options: map[string]string{"size": "100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms, ok := es.(*memoryStorage); !ok || ms.options["size"] != "100" {
+		t.Fatalf("wrong storage opened: %#v", es)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("registering the same storage type twice should panic")
+			}
+		}()
+		Register("test_memory", func(cfg *config.StorageConfig) (storagecommon.EntityStorage, error) {
+			return nil, nil
+		})
+	}()
+}
@@ -3,24 +3,19 @@ package storage
 import (
 	"time"
 
-	"strconv"
-
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/async"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
-	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
-	"github.com/xiaonanln/goworld/engine/storage/backend/mongodb"
-	"github.com/xiaonanln/goworld/engine/storage/backend/mysql"
-	"github.com/xiaonanln/goworld/engine/storage/backend/redis"
-	"github.com/xiaonanln/goworld/engine/storage/backend/redis_cluster"
 	"github.com/xiaonanln/goworld/engine/storage/storage_common"
 )
 
 var (
+	conf                     *config.Config // config of the game, set by Initialize
 	storageEngine            storagecommon.EntityStorage
 	operationQueue           = xnsyncutil.NewSyncQueue()
 	storageRoutineTerminated = xnsyncutil.NewOneTimeCond()
@@ -36,6 +31,7 @@ type saveRequest struct {
 type loadRequest struct {
 	TypeName string
 	EntityID common.EntityID
+	Claim    bool
 	Callback LoadCallbackFunc
 }
 
@@ -45,6 +41,13 @@ type existsRequest struct {
 	Callback ExistsCallbackFunc
 }
 
+type deleteRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Purge    bool
+	Callback DeleteCallbackFunc
+}
+
 type listEntityIDsRequest struct {
 	TypeName string
 	Callback ListCallbackFunc
@@ -62,6 +65,9 @@ type ExistsCallbackFunc func(exists bool, err error)
 // ListCallbackFunc is the callback type of storage List
 type ListCallbackFunc func([]common.EntityID, error)
 
+// DeleteCallbackFunc is the callback type of storage Delete
+type DeleteCallbackFunc func(err error)
+
 // Save saves entity data to storage
 func Save(typeName string, entityID common.EntityID, data interface{}, callback SaveCallbackFunc) {
 	operationQueue.Push(saveRequest{
@@ -83,6 +89,20 @@ func Load(typeName string, entityID common.EntityID, callback LoadCallbackFunc)
 	checkOperationQueueLen()
 }
 
+// LoadAndClaim loads entity data from storage and claims the next owner epoch of the entity for the loaded copy
+//
+// The claimed epoch is set in data by OWNER_EPOCH_KEY. If the epoch is claimed by another copy loading the entity at the
+// same time, ErrStaleOwnerEpoch is passed to callback, and the loaded copy must not be created.
+func LoadAndClaim(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
+	operationQueue.Push(loadRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Claim:    true,
+		Callback: callback,
+	})
+	checkOperationQueueLen()
+}
+
 // Exists checks if entity of specified ID exists in storage
 func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
 	operationQueue.Push(existsRequest{
@@ -93,6 +113,27 @@ func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFu
 	checkOperationQueueLen()
 }
 
+// Delete moves entity data to trash, which can be restored by Restore before trash retention expires
+func Delete(typeName string, entityID common.EntityID, callback DeleteCallbackFunc) {
+	operationQueue.Push(deleteRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Callback: callback,
+	})
+	checkOperationQueueLen()
+}
+
+// Purge deletes entity data and its trashed data from storage permanently
+func Purge(typeName string, entityID common.EntityID, callback DeleteCallbackFunc) {
+	operationQueue.Push(deleteRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Purge:    true,
+		Callback: callback,
+	})
+	checkOperationQueueLen()
+}
+
 // ListEntityIDs returns all entity IDs in storage
 //
 // Return values can be large for common entity types
@@ -116,16 +157,20 @@ func checkOperationQueueLen() {
 
 // Shutdown storage module
 func Shutdown() {
+	stopWriteBehind()
 	operationQueue.Close()
 	storageRoutineTerminated.Wait()
 }
 
-// Initialize is called by engine to initialize storage module
-func Initialize() {
+// Initialize is called by engine to initialize storage module with the config of the game
+func Initialize(c *config.Config) {
+	conf = c
 	err := assureStorageEngineReady()
 	if err != nil {
 		gwlog.Fatalf("Storage engine is not ready: %s", err)
 	}
+	setupWriteBehind(conf.GetStorage())
+	pooledStorages = make([]storagecommon.EntityStorage, async.GetWorkerPoolSize())
 	go storageRoutine()
 }
 
@@ -134,31 +179,21 @@ func assureStorageEngineReady() (err error) {
 		return
 	}
 
-	cfg := config.GetStorage()
-	if cfg.Type == "filesystem" {
-		storageEngine, err = entitystoragefilesystem.OpenDirectory(cfg.Directory)
-	} else if cfg.Type == "mongodb" {
-		storageEngine, err = entitystoragemongodb.OpenMongoDB(cfg.Url, cfg.DB)
-	} else if cfg.Type == "redis" {
-		var dbindex int = -1
-		if cfg.DB != "" {
-			if dbindex, err = strconv.Atoi(cfg.DB); err != nil {
-				return err
-			}
-		}
-		storageEngine, err = entitystorageredis.OpenRedis(cfg.Url, dbindex)
-	} else if cfg.Type == "redis_cluster" {
-		storageEngine, err = entitystoragerediscluster.OpenRedisCluster(cfg.StartNodes.ToList())
-	} else if cfg.Type == "sql" {
-		if cfg.Driver == "mysql" {
-			storageEngine, err = entitystoragemysql.OpenMySQL(cfg.Url)
-		} else {
-			gwlog.Panicf("unknown sql driver: %s", cfg.Driver)
-		}
-	} else {
+	storageEngine, err = OpenStorage(conf.GetStorage())
+	return
+}
+
+// OpenStorage opens the entity storage of the config, entities are in the namespace of the config if set
+func OpenStorage(cfg *config.StorageConfig) (es storagecommon.EntityStorage, err error) {
+	factory := getFactory(cfg.Type)
+	if factory == nil {
 		gwlog.Panicf("unknown storage type: %s", cfg.Type)
 	}
 
+	es, err = factory(cfg)
+	if err == nil && cfg.Namespace != "" {
+		es = storagecommon.NewNamespaceStorage(es, cfg.Namespace)
+	}
 	return
 }
 
@@ -228,11 +263,21 @@ func storageRoutine() {
 					break
 				}
 			}
+		} else if saveReq, ok := op.(fencedSaveRequest); ok {
+			handleFencedSave(saveReq)
+		} else if flushReq, ok := op.(flushRequest); ok {
+			flushPendingSaves(flushReq.All)
+		} else if flushReq, ok := op.(flushEntityRequest); ok {
+			flushPendingSave(flushReq.TypeName, flushReq.EntityID)
 		} else if loadReq, ok := op.(loadRequest); ok {
 			// handle load request
+			flushPendingSave(loadReq.TypeName, loadReq.EntityID)
 			gwlog.Debugf("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
 			monop = opmon.StartOperation("storage.load")
 			data, err := storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
+			if err == nil && loadReq.Claim {
+				err = claimOwnerEpoch(loadReq.TypeName, loadReq.EntityID, data)
+			}
 			if err != nil {
 				// save failed ?
 				gwlog.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
@@ -251,6 +296,7 @@ func storageRoutine() {
 				storageEngine = nil
 			}
 		} else if existsReq, ok := op.(existsRequest); ok {
+			flushPendingSave(existsReq.TypeName, existsReq.EntityID)
 			monop = opmon.StartOperation("storage.exists")
 			exists, err := storageEngine.Exists(existsReq.TypeName, existsReq.EntityID)
 			monop.Finish(time.Millisecond * 100)
@@ -263,7 +309,62 @@ func storageRoutine() {
 				storageEngine.Close()
 				storageEngine = nil
 			}
+		} else if deleteReq, ok := op.(deleteRequest); ok {
+			flushPendingSave(deleteReq.TypeName, deleteReq.EntityID)
+			if deleteReq.Purge {
+				monop = opmon.StartOperation("storage.purge")
+			} else {
+				monop = opmon.StartOperation("storage.delete")
+			}
+			err := deleteEntity(deleteReq.TypeName, deleteReq.EntityID, deleteReq.Purge)
+			if err != nil {
+				gwlog.TraceError("storage: delete %s %s failed: %s", deleteReq.TypeName, deleteReq.EntityID, err)
+			}
+			monop.Finish(time.Millisecond * 100)
+			if deleteReq.Callback != nil {
+				post.Post(func() {
+					deleteReq.Callback(err)
+				})
+			}
+			if err != nil && storageEngine.IsEOF(err) {
+				storageEngine.Close()
+				storageEngine = nil
+			}
+		} else if restoreReq, ok := op.(restoreRequest); ok {
+			flushPendingSave(restoreReq.TypeName, restoreReq.EntityID)
+			monop = opmon.StartOperation("storage.restore")
+			err := restoreEntity(restoreReq.TypeName, restoreReq.EntityID)
+			if err != nil {
+				gwlog.TraceError("storage: restore %s %s failed: %s", restoreReq.TypeName, restoreReq.EntityID, err)
+			}
+			monop.Finish(time.Millisecond * 100)
+			if restoreReq.Callback != nil {
+				post.Post(func() {
+					restoreReq.Callback(err)
+				})
+			}
+			if err != nil && storageEngine.IsEOF(err) {
+				storageEngine.Close()
+				storageEngine = nil
+			}
+		} else if purgeTrashReq, ok := op.(purgeTrashRequest); ok {
+			monop = opmon.StartOperation("storage.purgeTrash")
+			n, err := purgeExpiredTrash(purgeTrashReq.TypeName, purgeTrashReq.Retention)
+			if err != nil {
+				gwlog.TraceError("storage: purge trash of %s failed: %s", purgeTrashReq.TypeName, err)
+			}
+			monop.Finish(time.Millisecond * 1000)
+			if purgeTrashReq.Callback != nil {
+				post.Post(func() {
+					purgeTrashReq.Callback(n, err)
+				})
+			}
+			if err != nil && storageEngine.IsEOF(err) {
+				storageEngine.Close()
+				storageEngine = nil
+			}
 		} else if listReq, ok := op.(listEntityIDsRequest); ok {
+			flushPendingSavesOfType(listReq.TypeName)
 			monop = opmon.StartOperation("storage.list")
 			eids, err := storageEngine.List(listReq.TypeName)
 			if err != nil {
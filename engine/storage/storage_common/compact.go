@@ -0,0 +1,17 @@
+package storagecommon
+
+// Compactor is implemented by entity storages which reclaim space by compaction, e.g. the filesystem storage
+//
+// Compaction should run when no game is using the storage.
+type Compactor interface {
+	Compact() ([]CompactResult, error)
+}
+
+// CompactResult is the result of compacting one entity type
+type CompactResult struct {
+	TypeName       string
+	Entities       int   // number of entities after compaction
+	MovedFiles     int   // number of files moved to where they should be, e.g. from an old layout
+	RemovedFiles   int   // number of obsolete files removed, e.g. temporary files left by interrupted writes
+	ReclaimedBytes int64 // bytes reclaimed by removing obsolete files and records
+}
@@ -0,0 +1,66 @@
+package storagecommon
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/typeconv"
+)
+
+const (
+	// OWNER_EPOCH_KEY is the key of owner epoch in entity data written by fenced writes
+	OWNER_EPOCH_KEY = "_OwnerEpoch"
+)
+
+// FencedWriter is implemented by entity storages which check the saved owner epoch and write entity data in one atomic
+// conditional write, so that a stale owner in another process can not overwrite data saved by a newer owner
+type FencedWriter interface {
+	// WriteFenced writes data of the entity if the saved owner epoch is not newer than epoch, and returns false if the
+	// data is not written. The owner epoch in data is saved, which can be newer than epoch when the epoch is claimed.
+	// Data saved without owner epoch can be overwritten by any owner.
+	WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error)
+}
+
+// WriteFenced writes entity data to the storage if the saved owner epoch is not newer than epoch
+//
+// Storages not implementing FencedWriter are checked by reading the saved data before writing, which is not atomic
+// across processes.
+func WriteFenced(es EntityStorage, typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	if fw, ok := es.(FencedWriter); ok {
+		return fw.WriteFenced(typeName, entityID, data, epoch)
+	}
+
+	exists, err := es.Exists(typeName, entityID)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		saved, err := es.Read(typeName, entityID)
+		if err != nil {
+			return false, err
+		}
+		if OwnerEpochOf(saved) > epoch {
+			return false, nil
+		}
+	}
+	return true, es.Write(typeName, entityID, data)
+}
+
+// OwnerEpochOf returns the owner epoch in entity data, or 0 if the data is saved without epoch
+func OwnerEpochOf(data interface{}) uint64 {
+	if data == nil {
+		return 0
+	}
+	v, ok := typeconv.MapStringAnything(data)[OWNER_EPOCH_KEY]
+	if !ok || v == nil {
+		return 0
+	}
+	return uint64(typeconv.Int(v))
+}
+
+// ClaimOwnerEpoch writes the entity data with the owner epoch set to epoch, only if the saved owner epoch is older than
+// epoch, and returns false if the epoch is already claimed by another owner
+//
+// Copies loading the same data claim the same epoch, so only one of them can become the owner of the entity.
+func ClaimOwnerEpoch(es EntityStorage, typeName string, entityID common.EntityID, data map[string]interface{}, epoch uint64) (bool, error) {
+	data[OWNER_EPOCH_KEY] = int64(epoch)
+	return WriteFenced(es, typeName, entityID, data, epoch-1)
+}
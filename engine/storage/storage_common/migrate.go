@@ -0,0 +1,239 @@
+package storagecommon
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+const (
+	_MIGRATE_PROGRESS_INTERVAL = 1000 // progress is reported and saved after migrating every N entities
+)
+
+// TypeLister is implemented by entity storages which can list all stored entity types
+type TypeLister interface {
+	ListTypes() ([]string, error)
+}
+
+// MigrateOptions defines options of Migrate
+type MigrateOptions struct {
+	Types     []string                                       // Entity types to migrate, all types listed by the source storage if empty
+	StateFile string                                         // File to save the progress, so that interrupted migration can be resumed
+	Progress  func(typeName string, migrated int, total int) // Called periodically for reporting progress
+}
+
+// MigrateResult is the result of migrating one entity type
+type MigrateResult struct {
+	TypeName string
+	Total    int // number of entities in the source storage
+	Migrated int // number of entities written to the target storage, excluding entities migrated before resuming
+	Skipped  int // number of entities skipped because they are already migrated or deleted during migration
+}
+
+// migrateState is saved in the state file for resuming
+type migrateState struct {
+	CompletedTypes []string        `json:"completed_types"`
+	TypeName       string          `json:"type_name"`      // the type being migrated
+	LastEntityID   common.EntityID `json:"last_entity_id"` // entities of the type are migrated in order of IDs
+}
+
+// Migrate copies entities from one storage to another, and verifies that all entities exist in the target storage
+//
+// Entities should not be modified during migration, so games should be stopped.
+func Migrate(from EntityStorage, to EntityStorage, opts MigrateOptions) ([]MigrateResult, error) {
+	types, err := migrateTypes(from, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := loadMigrateState(opts.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	completed := common.StringSet{}
+	for _, typeName := range state.CompletedTypes {
+		completed.Add(typeName)
+	}
+
+	var results []MigrateResult
+	for _, typeName := range types {
+		if completed.Contains(typeName) {
+			continue
+		}
+
+		var lastEntityID common.EntityID
+		if state.TypeName == typeName {
+			lastEntityID = state.LastEntityID
+		}
+		result, err := migrateType(from, to, typeName, lastEntityID, func(last common.EntityID) error {
+			state.TypeName, state.LastEntityID = typeName, last
+			return saveMigrateState(opts.StateFile, state)
+		}, opts.Progress)
+		results = append(results, result)
+		if err != nil {
+			return results, err
+		}
+
+		state.CompletedTypes = append(state.CompletedTypes, typeName)
+		state.TypeName, state.LastEntityID = "", ""
+		if err := saveMigrateState(opts.StateFile, state); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// PlanMigrate makes the plan of Migrate without writing the target storage, entities are planned to be created in the
+// target storage, or to overwrite existing entities of the target storage
+func PlanMigrate(operation string, from EntityStorage, to EntityStorage, opts MigrateOptions) (*Plan, error) {
+	types, err := migrateTypes(from, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := NewPlan(operation)
+	for _, typeName := range types {
+		eids, err := from.List(typeName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list %s failed", typeName)
+		}
+		targetEids, err := to.List(typeName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "list %s in target storage failed", typeName)
+		}
+
+		existing := common.EntityIDSet{}
+		for _, eid := range targetEids {
+			existing.Add(eid)
+		}
+		for _, eid := range eids {
+			if existing.Contains(eid) {
+				plan.Add(typeName, "overwrite", eid)
+			} else {
+				plan.Add(typeName, "create", eid)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// migrateTypes returns entity types to migrate in order
+func migrateTypes(from EntityStorage, opts MigrateOptions) ([]string, error) {
+	if len(opts.Types) > 0 {
+		return opts.Types, nil
+	}
+
+	lister, ok := from.(TypeLister)
+	if !ok {
+		return nil, errors.New("source storage can not list entity types, types should be specified")
+	}
+	types, err := lister.ListTypes()
+	if err != nil {
+		return nil, errors.Wrap(err, "list entity types failed")
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+func migrateType(from EntityStorage, to EntityStorage, typeName string, lastEntityID common.EntityID,
+	checkpoint func(last common.EntityID) error, progress func(typeName string, migrated int, total int)) (MigrateResult, error) {
+	result := MigrateResult{TypeName: typeName}
+	eids, err := from.List(typeName)
+	if err != nil {
+		return result, errors.Wrapf(err, "list %s failed", typeName)
+	}
+	sort.Slice(eids, func(i, j int) bool {
+		return eids[i] < eids[j]
+	})
+	result.Total = len(eids)
+
+	migrated := common.EntityIDSet{}
+	for i, eid := range eids {
+		if eid <= lastEntityID {
+			result.Skipped += 1
+			migrated.Add(eid)
+			continue
+		}
+
+		data, err := from.Read(typeName, eid)
+		if err != nil {
+			return result, errors.Wrapf(err, "read %s %s failed", typeName, eid)
+		}
+		if data == nil {
+			result.Skipped += 1 // deleted during migration
+			continue
+		}
+		if err := to.Write(typeName, eid, data); err != nil {
+			return result, errors.Wrapf(err, "write %s %s failed", typeName, eid)
+		}
+		result.Migrated += 1
+		migrated.Add(eid)
+
+		if (i+1)%_MIGRATE_PROGRESS_INTERVAL == 0 {
+			if err := checkpoint(eid); err != nil {
+				return result, err
+			}
+			if progress != nil {
+				progress(typeName, i+1, len(eids))
+			}
+		}
+	}
+	if progress != nil {
+		progress(typeName, len(eids), len(eids))
+	}
+
+	// verify that all migrated entities exist in the target storage
+	targetEids, err := to.List(typeName)
+	if err != nil {
+		return result, errors.Wrapf(err, "list %s in target storage failed", typeName)
+	}
+	for _, eid := range targetEids {
+		migrated.Del(eid)
+	}
+	if len(migrated) > 0 {
+		return result, errors.Errorf("verify %s failed: %d of %d entities are missing in target storage", typeName, len(migrated), len(eids))
+	}
+	return result, nil
+}
+
+func loadMigrateState(stateFile string) (*migrateState, error) {
+	state := &migrateState{}
+	if stateFile == "" {
+		return state, nil
+	}
+
+	data, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "read migrate state failed")
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "parse migrate state %s failed", stateFile)
+	}
+	return state, nil
+}
+
+func saveMigrateState(stateFile string, state *migrateState) error {
+	if stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file and rename, so that the state file is not corrupted if interrupted
+	tmpFile := stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return errors.Wrap(err, "save migrate state failed")
+	}
+	return errors.Wrap(os.Rename(tmpFile, stateFile), "save migrate state failed")
+}
@@ -0,0 +1,104 @@
+package storagecommon_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from, err := entitystoragefilesystem.OpenDirectory(filepath.Join(dir, "from"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := entitystoragefilesystem.OpenDirectory(filepath.Join(dir, "to"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		from.Write("Avatar", common.GenEntityID(), map[string]interface{}{"level": i})
+	}
+	from.Write("Account", common.GenEntityID(), map[string]interface{}{"name": "test"})
+
+	opts := storagecommon.MigrateOptions{StateFile: filepath.Join(dir, "migrate.state")}
+	results, err := storagecommon.Migrate(from, to, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].TypeName != "Account" || results[1].Migrated != 10 {
+		t.Fatalf("wrong migrate results: %+v", results)
+	}
+	if eids, _ := to.List("Avatar"); len(eids) != 10 {
+		t.Fatalf("migrated %d avatars, expected 10", len(eids))
+	}
+
+	// completed types are skipped when resumed
+	from.Write("Avatar", common.GenEntityID(), map[string]interface{}{"level": 10})
+	if results, err = storagecommon.Migrate(from, to, opts); err != nil || len(results) != 0 {
+		t.Fatalf("completed migration should not be resumed: %+v, %v", results, err)
+	}
+
+	// verification fails if entities are missing in the target storage
+	eids, _ := to.List("Account")
+	to.Delete("Account", eids[0])
+	lossy := &lossyStorage{to}
+	if _, err := storagecommon.Migrate(from, lossy, storagecommon.MigrateOptions{Types: []string{"Account"}}); err == nil {
+		t.Fatalf("verification should fail")
+	}
+}
+
+func TestPlanMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_plan_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	from, err := entitystoragefilesystem.OpenDirectory(filepath.Join(dir, "from"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := entitystoragefilesystem.OpenDirectory(filepath.Join(dir, "to"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eid := common.GenEntityID()
+	from.Write("Avatar", eid, map[string]interface{}{"level": 1})
+	from.Write("Avatar", common.GenEntityID(), map[string]interface{}{"level": 2})
+	to.Write("Avatar", eid, map[string]interface{}{"level": 3})
+
+	plan, err := storagecommon.PlanMigrate("migrate-storage from to", from, to, storagecommon.MigrateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := plan.Changes()
+	if len(changes) != 2 || changes[0].Action != "create" || changes[1].Action != "overwrite" || changes[1].Samples[0] != eid {
+		t.Fatalf("wrong migrate plan: %+v", changes)
+	}
+	if data, _ := to.Read("Avatar", eid); fmt.Sprint(data) != "map[level:3]" {
+		t.Fatalf("planning should not write the target storage: %v", data)
+	}
+}
+
+// lossyStorage drops all writes
+type lossyStorage struct {
+	storagecommon.EntityStorage
+}
+
+func (es *lossyStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return nil
+}
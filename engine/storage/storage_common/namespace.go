@@ -0,0 +1,73 @@
+package storagecommon
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// namespaceStorage partitions the entity storage by the data namespace (e.g. season ID), entities of type T are stored
+// as type <namespace>_T
+type namespaceStorage struct {
+	EntityStorage
+	prefix string
+}
+
+// NewNamespaceStorage returns the entity storage which reads and writes entities in the namespace of es
+func NewNamespaceStorage(es EntityStorage, namespace string) EntityStorage {
+	return &namespaceStorage{EntityStorage: es, prefix: namespace + "_"}
+}
+
+func (ns *namespaceStorage) List(typeName string) ([]common.EntityID, error) {
+	return ns.EntityStorage.List(ns.prefix + typeName)
+}
+
+func (ns *namespaceStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return ns.EntityStorage.Write(ns.prefix+typeName, entityID, data)
+}
+
+func (ns *namespaceStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	return ns.EntityStorage.Read(ns.prefix+typeName, entityID)
+}
+
+func (ns *namespaceStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return ns.EntityStorage.Exists(ns.prefix+typeName, entityID)
+}
+
+func (ns *namespaceStorage) Delete(typeName string, entityID common.EntityID) error {
+	return ns.EntityStorage.Delete(ns.prefix+typeName, entityID)
+}
+
+func (ns *namespaceStorage) WriteFenced(typeName string, entityID common.EntityID, data interface{}, epoch uint64) (bool, error) {
+	return WriteFenced(ns.EntityStorage, ns.prefix+typeName, entityID, data, epoch)
+}
+
+// ListTypes returns entity types in the namespace
+func (ns *namespaceStorage) ListTypes() ([]string, error) {
+	lister, ok := ns.EntityStorage.(TypeLister)
+	if !ok {
+		return nil, errors.New("storage can not list entity types")
+	}
+
+	types, err := lister.ListTypes()
+	if err != nil {
+		return nil, err
+	}
+	var nsTypes []string
+	for _, typeName := range types {
+		if strings.HasPrefix(typeName, ns.prefix) {
+			nsTypes = append(nsTypes, typeName[len(ns.prefix):])
+		}
+	}
+	return nsTypes, nil
+}
+
+// Compact compacts the whole storage, including entities of other namespaces
+func (ns *namespaceStorage) Compact() ([]CompactResult, error) {
+	compactor, ok := ns.EntityStorage.(Compactor)
+	if !ok {
+		return nil, errors.New("storage does not support compaction")
+	}
+	return compactor.Compact()
+}
@@ -0,0 +1,47 @@
+package storagecommon_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/storage/storagetest"
+)
+
+func TestNamespaceStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+		return storagecommon.NewNamespaceStorage(es, "conformance"), nil
+	})
+
+	eid := common.GenEntityID()
+	es.Write("Avatar", eid, map[string]interface{}{"level": 1})
+	s1 := storagecommon.NewNamespaceStorage(es, "s1")
+	s2 := storagecommon.NewNamespaceStorage(es, "s2")
+	s1.Write("Avatar", eid, map[string]interface{}{"level": 10})
+	s2.Write("Account", common.GenEntityID(), map[string]interface{}{"name": "test"})
+
+	if data, err := s1.Read("Avatar", eid); err != nil || fmt.Sprint(data) != "map[level:10]" {
+		t.Errorf("wrong data in namespace: %v, %v", data, err)
+	}
+	if exists, _ := s2.Exists("Avatar", eid); exists {
+		t.Errorf("entity of other namespace should not exist")
+	}
+	types, err := s2.(storagecommon.TypeLister).ListTypes()
+	if err != nil || len(types) != 1 || types[0] != "Account" {
+		t.Errorf("wrong types in namespace: %v, %v", types, err)
+	}
+}
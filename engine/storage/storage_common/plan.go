@@ -0,0 +1,104 @@
+package storagecommon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+const (
+	_PLAN_SAMPLE_COUNT = 10
+)
+
+// Plan is the report of the dry run of a destructive operation, e.g. bulk entity deletion
+//
+// The operation reports the plan and its hash in the dry run, and is executed only if the plan made again before
+// execution has the hash approved by the operator, so that nothing is changed beyond what was reviewed.
+type Plan struct {
+	Operation string // the operation and its arguments, e.g. delete-entities Avatar
+	changes   map[[2]string][]common.EntityID
+}
+
+// PlanChange is the summary of entities of the type changed by the same action
+type PlanChange struct {
+	TypeName string            `json:"type_name"`
+	Action   string            `json:"action"`
+	Count    int               `json:"count"`
+	Samples  []common.EntityID `json:"samples"` // the first entity IDs in order
+}
+
+// NewPlan creates the empty plan of the operation
+func NewPlan(operation string) *Plan {
+	return &Plan{Operation: operation, changes: map[[2]string][]common.EntityID{}}
+}
+
+// Add adds the entity changed by the action to the plan
+func (p *Plan) Add(typeName string, action string, entityID common.EntityID) {
+	key := [2]string{typeName, action}
+	p.changes[key] = append(p.changes[key], entityID)
+}
+
+// Count returns the number of entities changed by the action
+func (p *Plan) Count(action string) int {
+	n := 0
+	for key, eids := range p.changes {
+		if key[1] == action {
+			n += len(eids)
+		}
+	}
+	return n
+}
+
+// Changes returns the summary of changes in order of types and actions
+func (p *Plan) Changes() []PlanChange {
+	changes := make([]PlanChange, 0, len(p.changes))
+	for key, eids := range p.changes {
+		sortEntityIDs(eids)
+		samples := eids
+		if len(samples) > _PLAN_SAMPLE_COUNT {
+			samples = samples[:_PLAN_SAMPLE_COUNT]
+		}
+		changes = append(changes, PlanChange{TypeName: key[0], Action: key[1], Count: len(eids), Samples: samples})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].TypeName != changes[j].TypeName {
+			return changes[i].TypeName < changes[j].TypeName
+		}
+		return changes[i].Action < changes[j].Action
+	})
+	return changes
+}
+
+// Hash returns the hash of the operation and all changed entities, which does not depend on the order of Add
+func (p *Plan) Hash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", p.Operation)
+	for _, change := range p.Changes() {
+		fmt.Fprintf(h, "%s %s %d\n", change.TypeName, change.Action, change.Count)
+		for _, eid := range p.changes[[2]string{change.TypeName, change.Action}] {
+			fmt.Fprintf(h, "%s\n", eid)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Verify returns error if the hash of the plan is not the approved hash
+func (p *Plan) Verify(approvedHash string) error {
+	if approvedHash == "" {
+		return errors.Errorf("%s is not approved, run it with dry run first", p.Operation)
+	}
+	if hash := p.Hash(); hash != approvedHash {
+		return errors.Errorf("plan of %s is changed since approved: hash is %s, but %s is approved", p.Operation, hash, approvedHash)
+	}
+	return nil
+}
+
+func sortEntityIDs(eids []common.EntityID) {
+	sort.Slice(eids, func(i, j int) bool {
+		return eids[i] < eids[j]
+	})
+}
@@ -0,0 +1,44 @@
+package storagecommon
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestPlan(t *testing.T) {
+	var eids []common.EntityID
+	for i := 0; i < 15; i++ {
+		eids = append(eids, common.GenEntityID())
+	}
+
+	p1, p2 := NewPlan("delete-entities Avatar"), NewPlan("delete-entities Avatar")
+	for i, eid := range eids {
+		p1.Add("Avatar", "delete", eid)
+		p2.Add("Avatar", "delete", eids[len(eids)-1-i])
+	}
+	p1.Add("Avatar", "skip", common.GenEntityID())
+	p2.Add("Avatar", "skip", p1.changes[[2]string{"Avatar", "skip"}][0])
+
+	if p1.Hash() != p2.Hash() {
+		t.Fatalf("hash should not depend on the order of changes: %s != %s", p1.Hash(), p2.Hash())
+	}
+	changes := p1.Changes()
+	if len(changes) != 2 || changes[0].Action != "delete" || changes[0].Count != 15 || len(changes[0].Samples) != _PLAN_SAMPLE_COUNT {
+		t.Fatalf("wrong changes: %+v", changes)
+	}
+	if err := p2.Verify(p1.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.Verify(""); err == nil {
+		t.Fatalf("plan without approved hash should not be verified")
+	}
+
+	p2.Add("Avatar", "delete", common.GenEntityID())
+	if err := p2.Verify(p1.Hash()); err == nil {
+		t.Fatalf("changed plan should not be verified")
+	}
+	if NewPlan("delete-entities Account").Hash() == NewPlan("delete-entities Avatar").Hash() {
+		t.Fatalf("hash should depend on the operation")
+	}
+}
@@ -0,0 +1,157 @@
+package storagecommon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/typeconv"
+)
+
+// Snapshots of the cluster are stored in entity storages as entities of two types:
+//
+//	__snapshot__<id>          one record for each entity, with the type, space and packed state of the entity
+//	__snapshot__<id>__games   one manifest for each game, written after all entities of the game are written
+//
+// A snapshot is complete if the number of entity records equals the total number of entities in manifests.
+
+const (
+	_SNAPSHOT_TYPE_PREFIX          = "__snapshot__"
+	_SNAPSHOT_MANIFEST_TYPE_SUFFIX = "__games"
+)
+
+var snapshotIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9-]{1,64}$`)
+
+// SnapshotEntity is the record of an entity in snapshots
+type SnapshotEntity struct {
+	TypeName string
+	SpaceID  common.EntityID // empty if the entity is in the nil space
+	Data     []byte          // packed state of the entity
+}
+
+// SnapshotManifest is the manifest of a game in snapshots
+type SnapshotManifest struct {
+	GameID   uint16
+	Time     int64                      // unix time of the snapshot
+	Entities int                        // number of entities written by the game
+	Services map[string]common.EntityID // service IDs (ServiceName#ShardIndex) of service entities on the game
+}
+
+// ValidateSnapshotID checks if the snapshot ID can be used in type names of all storages
+func ValidateSnapshotID(id string) error {
+	if !snapshotIDRegexp.MatchString(id) {
+		return errors.Errorf("invalid snapshot id %#v: should be 1~64 letters, digits or '-'", id)
+	}
+	return nil
+}
+
+// SnapshotTypeName returns the type name of entity records of the snapshot
+func SnapshotTypeName(id string) string {
+	return _SNAPSHOT_TYPE_PREFIX + id
+}
+
+// SnapshotManifestTypeName returns the type name of manifests of the snapshot
+func SnapshotManifestTypeName(id string) string {
+	return _SNAPSHOT_TYPE_PREFIX + id + _SNAPSHOT_MANIFEST_TYPE_SUFFIX
+}
+
+// SnapshotManifestID returns the entity ID of the manifest of the game
+func SnapshotManifestID(gameid uint16) common.EntityID {
+	return common.EntityID(fmt.Sprintf("game%012d", gameid))
+}
+
+// ToMap converts the record to data written to storages
+func (se *SnapshotEntity) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"T":  se.TypeName,
+		"SP": string(se.SpaceID),
+		"D":  base64.StdEncoding.EncodeToString(se.Data), // binary data is not supported by all storages
+	}
+}
+
+// ParseSnapshotEntity parses the record read from storages
+func ParseSnapshotEntity(data interface{}) (*SnapshotEntity, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid snapshot entity: %T", data)
+	}
+	typeName, _ := m["T"].(string)
+	spaceID, _ := m["SP"].(string)
+	encoded, _ := m["D"].(string)
+	packed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || typeName == "" {
+		return nil, errors.Errorf("invalid snapshot entity of type %#v", typeName)
+	}
+	return &SnapshotEntity{TypeName: typeName, SpaceID: common.EntityID(spaceID), Data: packed}, nil
+}
+
+// ToMap converts the manifest to data written to storages
+func (sm *SnapshotManifest) ToMap() map[string]interface{} {
+	services := make(map[string]interface{}, len(sm.Services))
+	for serviceID, eid := range sm.Services {
+		services[serviceID] = string(eid)
+	}
+	return map[string]interface{}{
+		"GameID":   int(sm.GameID),
+		"Time":     sm.Time,
+		"Entities": sm.Entities,
+		"Services": services,
+	}
+}
+
+// ParseSnapshotManifest parses the manifest read from storages
+func ParseSnapshotManifest(data interface{}) (*SnapshotManifest, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid snapshot manifest: %T", data)
+	}
+	sm := &SnapshotManifest{
+		GameID:   uint16(typeconv.Int(m["GameID"])),
+		Time:     typeconv.Int(m["Time"]),
+		Entities: int(typeconv.Int(m["Entities"])),
+		Services: map[string]common.EntityID{},
+	}
+	services, _ := m["Services"].(map[string]interface{})
+	for serviceID, eid := range services {
+		s, _ := eid.(string)
+		sm.Services[serviceID] = common.EntityID(s)
+	}
+	return sm, nil
+}
+
+// ReadSnapshotManifests reads manifests of all games in the snapshot, and checks if the snapshot is complete
+func ReadSnapshotManifests(es EntityStorage, id string) ([]*SnapshotManifest, error) {
+	manifestIDs, err := es.List(SnapshotManifestTypeName(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(manifestIDs) == 0 {
+		return nil, errors.Errorf("snapshot %s is not found", id)
+	}
+
+	manifests := make([]*SnapshotManifest, 0, len(manifestIDs))
+	total := 0
+	for _, manifestID := range manifestIDs {
+		data, err := es.Read(SnapshotManifestTypeName(id), manifestID)
+		if err != nil {
+			return nil, err
+		}
+		sm, err := ParseSnapshotManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, sm)
+		total += sm.Entities
+	}
+
+	eids, err := es.List(SnapshotTypeName(id))
+	if err != nil {
+		return nil, err
+	}
+	if len(eids) != total {
+		return nil, errors.Errorf("snapshot %s is incomplete: %d entities are found, but manifests have %d", id, len(eids), total)
+	}
+	return manifests, nil
+}
@@ -0,0 +1,72 @@
+package storagecommon_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const id = "before-maintenance"
+	if err := storagecommon.ValidateSnapshotID("../" + id); err == nil {
+		t.Fatalf("invalid snapshot id should be rejected")
+	}
+	if _, err := storagecommon.ReadSnapshotManifests(es, id); err == nil {
+		t.Fatalf("snapshot should not be found")
+	}
+
+	spaceID, avatarID := common.GenEntityID(), common.GenEntityID()
+	es.Write(storagecommon.SnapshotTypeName(id), spaceID, (&storagecommon.SnapshotEntity{TypeName: "__space__", Data: []byte{1, 2}}).ToMap())
+	es.Write(storagecommon.SnapshotTypeName(id), avatarID, (&storagecommon.SnapshotEntity{TypeName: "Avatar", SpaceID: spaceID, Data: []byte{3}}).ToMap())
+
+	manifest := &storagecommon.SnapshotManifest{GameID: 2, Time: 1000, Entities: 2, Services: map[string]common.EntityID{"OnlineService#0": avatarID}}
+	es.Write(storagecommon.SnapshotManifestTypeName(id), storagecommon.SnapshotManifestID(2), manifest.ToMap())
+	es.Write(storagecommon.SnapshotManifestTypeName(id), storagecommon.SnapshotManifestID(1), (&storagecommon.SnapshotManifest{GameID: 1, Entities: 1}).ToMap())
+
+	// game1 has not written its entity
+	if _, err := storagecommon.ReadSnapshotManifests(es, id); err == nil {
+		t.Fatalf("incomplete snapshot should not be read")
+	}
+
+	es.Write(storagecommon.SnapshotTypeName(id), common.GenEntityID(), (&storagecommon.SnapshotEntity{TypeName: "Account"}).ToMap())
+	manifests, err := storagecommon.ReadSnapshotManifests(es, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 2 {
+		t.Fatalf("read %d manifests, expected 2", len(manifests))
+	}
+	for _, sm := range manifests {
+		if sm.GameID == 2 && (sm.Time != 1000 || sm.Entities != 2 || sm.Services["OnlineService#0"] != avatarID) {
+			t.Fatalf("wrong manifest: %+v", sm)
+		}
+	}
+
+	data, err := es.Read(storagecommon.SnapshotTypeName(id), avatarID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se, err := storagecommon.ParseSnapshotEntity(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if se.TypeName != "Avatar" || se.SpaceID != spaceID || !bytes.Equal(se.Data, []byte{3}) {
+		t.Fatalf("wrong snapshot entity: %+v", se)
+	}
+}
@@ -3,11 +3,20 @@ package storagecommon
 import "github.com/xiaonanln/goworld/engine/common"
 
 // EntityStorage defines the interface of entity storage backends
+//
+// Backends are used by one goroutine at a time, and are registered by storage.Register to be used in config.
 type EntityStorage interface {
+	// List returns IDs of all entities of the type
 	List(typeName string) ([]common.EntityID, error)
+	// Write writes data of the entity, data is a map[string]interface{} which can be marshaled by msgpack or json
 	Write(typeName string, entityID common.EntityID, data interface{}) error
+	// Read reads data of the entity written by Write
 	Read(typeName string, entityID common.EntityID) (interface{}, error)
+	// Exists returns if the entity exists
 	Exists(typeName string, entityID common.EntityID) (bool, error)
+	// Delete deletes the entity, deleting entity which does not exist should not fail
+	Delete(typeName string, entityID common.EntityID) error
 	Close()
+	// IsEOF returns if the error is caused by broken connection, so that the storage should be reopened
 	IsEOF(err error) bool
 }
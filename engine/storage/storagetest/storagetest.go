@@ -0,0 +1,387 @@
+// Package storagetest provides the conformance test suite of entity storage backends
+//
+// Backends in this repository and in other modules are validated identically by calling RunConformance in tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.RunConformance(t, func() (storagecommon.EntityStorage, error) {
+//			return OpenMyStorage(...)
+//		})
+//	}
+package storagetest
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	_CONCURRENT_WORKERS  = 4
+	_CONCURRENT_ENTITIES = 10
+	_LARGE_BLOB_SIZE     = 1024 * 1024
+)
+
+// Factory opens a new connection of the entity storage backend
+//
+// Factory is called multiple times by concurrency tests, and storages opened are closed by the test suite.
+type Factory func() (storagecommon.EntityStorage, error)
+
+// RunConformance runs the conformance test suite on the entity storage backend
+//
+// Entities are written with random type names and deleted after tests, so the suite can run on storages in use.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("WriteRead", func(t *testing.T) { runWithStorage(t, factory, testWriteRead) })
+	t.Run("Overwrite", func(t *testing.T) { runWithStorage(t, factory, testOverwrite) })
+	t.Run("ExistsDelete", func(t *testing.T) { runWithStorage(t, factory, testExistsDelete) })
+	t.Run("List", func(t *testing.T) { runWithStorage(t, factory, testList) })
+	t.Run("LargeBlob", func(t *testing.T) { runWithStorage(t, factory, testLargeBlob) })
+	t.Run("Unicode", func(t *testing.T) { runWithStorage(t, factory, testUnicode) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+	t.Run("WriteFenced", func(t *testing.T) { runWithStorage(t, factory, testWriteFenced) })
+	t.Run("ConcurrentWriteFenced", func(t *testing.T) { testConcurrentWriteFenced(t, factory) })
+	t.Run("ConcurrentClaimOwnerEpoch", func(t *testing.T) { testConcurrentClaimOwnerEpoch(t, factory) })
+}
+
+func runWithStorage(t *testing.T, factory Factory, test func(t *testing.T, es storagecommon.EntityStorage, typeName string)) {
+	es := open(t, factory)
+	defer es.Close()
+	test(t, es, randomTypeName())
+}
+
+func open(t *testing.T, factory Factory) storagecommon.EntityStorage {
+	es, err := factory()
+	if err != nil {
+		t.Fatalf("open storage failed: %s", err)
+	}
+	return es
+}
+
+// randomTypeName returns a type name which is not used by other tests
+func randomTypeName() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return "Conformance" + string(b)
+}
+
+func testWriteRead(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	defer es.Delete(typeName, eid)
+
+	data := map[string]interface{}{
+		"int":    1,
+		"float":  1.5,
+		"string": "2",
+		"bool":   true,
+		"map":    map[string]interface{}{"a": 1, "b": "2"},
+		"list":   []interface{}{1, "2", false},
+	}
+	writeAndVerify(t, es, typeName, eid, data)
+}
+
+func testOverwrite(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	defer es.Delete(typeName, eid)
+
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{"a": 1, "b": 2})
+	// keys not in the new data should be removed
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{"a": 3})
+}
+
+func testExistsDelete(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	if exists, err := es.Exists(typeName, eid); err != nil || exists {
+		t.Fatalf("Exists returns %v, %v for entity not written", exists, err)
+	}
+
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{"a": 1})
+	if exists, err := es.Exists(typeName, eid); err != nil || !exists {
+		t.Fatalf("Exists returns %v, %v for entity written", exists, err)
+	}
+
+	if err := es.Delete(typeName, eid); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if exists, err := es.Exists(typeName, eid); err != nil || exists {
+		t.Fatalf("Exists returns %v, %v for entity deleted", exists, err)
+	}
+	if err := es.Delete(typeName, eid); err != nil {
+		t.Fatalf("Delete entity which does not exist failed: %s", err)
+	}
+}
+
+func testList(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	written := common.EntityIDSet{}
+	for i := 0; i < 10; i++ {
+		eid := common.GenEntityID()
+		defer es.Delete(typeName, eid)
+		if err := es.Write(typeName, eid, map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		written.Add(eid)
+	}
+
+	otherEid := common.GenEntityID() // entities of other types should not be listed
+	defer es.Delete(typeName+"Other", otherEid)
+	if err := es.Write(typeName+"Other", otherEid, map[string]interface{}{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	eids, err := es.List(typeName)
+	if err != nil {
+		t.Fatalf("List failed: %s", err)
+	}
+	listed := common.EntityIDSet{}
+	for _, eid := range eids {
+		listed.Add(eid)
+	}
+	if !reflect.DeepEqual(listed, written) {
+		t.Fatalf("List returns %v, expected %v", eids, written.ToList())
+	}
+}
+
+func testLargeBlob(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	defer es.Delete(typeName, eid)
+
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{
+		"blob": strings.Repeat("0123456789abcdef", _LARGE_BLOB_SIZE/16),
+	})
+}
+
+func testUnicode(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	defer es.Delete(typeName, eid)
+
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{
+		"名字":    "玩家一",
+		"имя":   "игрок",
+		"emoji": "🎮🐉",
+		"nested": map[string]interface{}{
+			"ключ": "値",
+		},
+	})
+}
+
+// testConcurrency writes and reads entities concurrently through multiple connections
+func testConcurrency(t *testing.T, factory Factory) {
+	typeName := randomTypeName()
+	sharedEid := common.GenEntityID()
+	var wait sync.WaitGroup
+	errs := make(chan error, _CONCURRENT_WORKERS*_CONCURRENT_ENTITIES)
+	for w := 0; w < _CONCURRENT_WORKERS; w++ {
+		es := open(t, factory)
+		defer es.Close()
+
+		wait.Add(1)
+		go func(w int, es storagecommon.EntityStorage) {
+			defer wait.Done()
+			for i := 0; i < _CONCURRENT_ENTITIES; i++ {
+				eid := common.GenEntityID()
+				data := map[string]interface{}{"worker": w, "i": i}
+				if err := es.Write(typeName, eid, data); err != nil {
+					errs <- err
+					return
+				}
+				if err := verify(es, typeName, eid, data); err != nil {
+					errs <- err
+				}
+				es.Delete(typeName, eid)
+
+				if err := es.Write(typeName, sharedEid, map[string]interface{}{"worker": w, "i": i}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w, es)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	es := open(t, factory)
+	defer es.Close()
+	defer es.Delete(typeName, sharedEid)
+	data, err := es.Read(typeName, sharedEid)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	// the entity written concurrently should be the last data written by any worker
+	m, ok := normalize(data).(map[string]interface{})
+	if !ok || m["i"] != float64(_CONCURRENT_ENTITIES-1) {
+		t.Fatalf("entity written concurrently is corrupted: %v", data)
+	}
+}
+
+func testWriteFenced(t *testing.T, es storagecommon.EntityStorage, typeName string) {
+	eid := common.GenEntityID()
+	defer es.Delete(typeName, eid)
+
+	writeFenced := func(name string, epoch uint64) bool {
+		written, err := storagecommon.WriteFenced(es, typeName, eid, map[string]interface{}{"name": name, storagecommon.OWNER_EPOCH_KEY: epoch}, epoch)
+		if err != nil {
+			t.Fatalf("WriteFenced failed: %s", err)
+		}
+		return written
+	}
+
+	if !writeFenced("old", 1) || !writeFenced("new", 2) {
+		t.Fatalf("data of newer owners should be written")
+	}
+	if writeFenced("stale", 1) {
+		t.Fatalf("data of stale owner should not be written")
+	}
+	if !writeFenced("new", 2) {
+		t.Fatalf("same data of the owner should be written again")
+	}
+	if err := verify(es, typeName, eid, map[string]interface{}{"name": "new", storagecommon.OWNER_EPOCH_KEY: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// data written without owner epoch can be overwritten by any owner
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{"name": "legacy"})
+	if !writeFenced("any", 1) {
+		t.Fatalf("data written without owner epoch should be overwritten")
+	}
+}
+
+// testConcurrentWriteFenced writes the entity with different owner epochs concurrently, the newest owner should win
+func testConcurrentWriteFenced(t *testing.T, factory Factory) {
+	typeName := randomTypeName()
+	eid := common.GenEntityID()
+	var wait sync.WaitGroup
+	errs := make(chan error, _CONCURRENT_WORKERS*_CONCURRENT_ENTITIES)
+	for w := 0; w < _CONCURRENT_WORKERS; w++ {
+		es := open(t, factory)
+		defer es.Close()
+
+		wait.Add(1)
+		go func(w int, es storagecommon.EntityStorage) {
+			defer wait.Done()
+			for i := 0; i < _CONCURRENT_ENTITIES; i++ {
+				epoch := uint64(i*_CONCURRENT_WORKERS + w)
+				data := map[string]interface{}{storagecommon.OWNER_EPOCH_KEY: epoch}
+				if _, err := storagecommon.WriteFenced(es, typeName, eid, data, epoch); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w, es)
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	es := open(t, factory)
+	defer es.Close()
+	defer es.Delete(typeName, eid)
+	data, err := es.Read(typeName, eid)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if epoch := storagecommon.OwnerEpochOf(data); epoch != _CONCURRENT_WORKERS*_CONCURRENT_ENTITIES-1 {
+		t.Fatalf("entity is overwritten by stale owner of epoch %d", epoch)
+	}
+}
+
+// testConcurrentClaimOwnerEpoch claims the same owner epoch concurrently, only one owner should succeed
+func testConcurrentClaimOwnerEpoch(t *testing.T, factory Factory) {
+	typeName := randomTypeName()
+	eid := common.GenEntityID()
+	es := open(t, factory)
+	defer es.Close()
+	defer es.Delete(typeName, eid)
+	writeAndVerify(t, es, typeName, eid, map[string]interface{}{"name": "saved", storagecommon.OWNER_EPOCH_KEY: 1})
+
+	var wait sync.WaitGroup
+	claimed := make(chan int, _CONCURRENT_WORKERS)
+	errs := make(chan error, _CONCURRENT_WORKERS)
+	for w := 0; w < _CONCURRENT_WORKERS; w++ {
+		es := open(t, factory)
+		defer es.Close()
+
+		wait.Add(1)
+		go func(w int, es storagecommon.EntityStorage) {
+			defer wait.Done()
+			ok, err := storagecommon.ClaimOwnerEpoch(es, typeName, eid, map[string]interface{}{"name": "saved", "worker": w}, 2)
+			if err != nil {
+				errs <- err
+			} else if ok {
+				claimed <- w
+			}
+		}(w, es)
+	}
+	wait.Wait()
+	close(errs)
+	close(claimed)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(claimed) != 1 {
+		t.Fatalf("owner epoch should be claimed by exactly one owner, but claimed by %d", len(claimed))
+	}
+	if err := verify(es, typeName, eid, map[string]interface{}{"name": "saved", "worker": <-claimed, storagecommon.OWNER_EPOCH_KEY: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeAndVerify(t *testing.T, es storagecommon.EntityStorage, typeName string, eid common.EntityID, data map[string]interface{}) {
+	if err := es.Write(typeName, eid, data); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err := verify(es, typeName, eid, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verify(es storagecommon.EntityStorage, typeName string, eid common.EntityID, data map[string]interface{}) error {
+	readData, err := es.Read(typeName, eid)
+	if err != nil {
+		return fmt.Errorf("Read %s %s failed: %s", typeName, eid, err)
+	}
+	if !reflect.DeepEqual(normalize(readData), normalize(data)) {
+		return fmt.Errorf("Read %s %s returns %v, expected %v", typeName, eid, readData, data)
+	}
+	return nil
+}
+
+// normalize converts numbers to float64 and maps to map[string]interface{}, because backends decode data differently
+func normalize(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = normalize(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v // []byte
+		}
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			l[i] = normalize(rv.Index(i).Interface())
+		}
+		return l
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return v
+}
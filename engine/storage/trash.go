@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/typeconv"
+)
+
+const (
+	_TRASH_TYPE_PREFIX     = "_trash_"
+	_TRASH_KEY_DATA        = "data"
+	_TRASH_KEY_DELETE_TIME = "delete_time"
+)
+
+type restoreRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Callback RestoreCallbackFunc
+}
+
+type purgeTrashRequest struct {
+	TypeName  string
+	Retention time.Duration
+	Callback  PurgeTrashCallbackFunc
+}
+
+// RestoreCallbackFunc is the callback type of storage Restore
+type RestoreCallbackFunc func(err error)
+
+// PurgeTrashCallbackFunc is the callback type of storage PurgeTrash
+type PurgeTrashCallbackFunc func(purgedCount int, err error)
+
+// Restore restores entity data from trash
+func Restore(typeName string, entityID common.EntityID, callback RestoreCallbackFunc) {
+	operationQueue.Push(restoreRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Callback: callback,
+	})
+	checkOperationQueueLen()
+}
+
+// PurgeTrash purges trashed entity data which is deleted before the retention
+func PurgeTrash(typeName string, retention time.Duration, callback PurgeTrashCallbackFunc) {
+	operationQueue.Push(purgeTrashRequest{
+		TypeName:  typeName,
+		Retention: retention,
+		Callback:  callback,
+	})
+	checkOperationQueueLen()
+}
+
+func trashTypeName(typeName string) string {
+	return _TRASH_TYPE_PREFIX + typeName
+}
+
+// deleteEntity moves entity data to trash, or deletes entity data permanently if purge is true
+func deleteEntity(typeName string, entityID common.EntityID, purge bool) error {
+	return DeleteFrom(storageEngine, typeName, entityID, purge)
+}
+
+// DeleteFrom moves entity data in the storage to trash, or deletes entity data permanently if purge is true
+//
+// DeleteFrom is used by tools on storages opened by OpenStorage, when games are stopped.
+func DeleteFrom(es storagecommon.EntityStorage, typeName string, entityID common.EntityID, purge bool) error {
+	if purge {
+		if err := es.Delete(typeName, entityID); err != nil {
+			return err
+		}
+		return es.Delete(trashTypeName(typeName), entityID)
+	}
+
+	exists, err := es.Exists(typeName, entityID)
+	if err != nil || !exists {
+		return err
+	}
+
+	data, err := es.Read(typeName, entityID)
+	if err != nil {
+		return err
+	}
+
+	err = es.Write(trashTypeName(typeName), entityID, map[string]interface{}{
+		_TRASH_KEY_DATA:        data,
+		_TRASH_KEY_DELETE_TIME: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return es.Delete(typeName, entityID)
+}
+
+// PlanDelete makes the plan of deleting entities by DeleteFrom without changing the storage, entities are planned to
+// be moved to trash ("delete") or deleted permanently with the trashed data ("purge"), missing entities are skipped
+func PlanDelete(es storagecommon.EntityStorage, operation string, typeName string, entityIDs []common.EntityID, purge bool) (*storagecommon.Plan, error) {
+	plan := storagecommon.NewPlan(operation)
+	for _, entityID := range entityIDs {
+		exists, err := es.Exists(typeName, entityID)
+		if err != nil {
+			return nil, err
+		}
+		if purge && !exists {
+			if exists, err = es.Exists(trashTypeName(typeName), entityID); err != nil {
+				return nil, err
+			}
+		}
+
+		if !exists {
+			plan.Add(typeName, "skip", entityID)
+		} else if purge {
+			plan.Add(typeName, "purge", entityID)
+		} else {
+			plan.Add(typeName, "delete", entityID)
+		}
+	}
+	return plan, nil
+}
+
+func restoreEntity(typeName string, entityID common.EntityID) error {
+	return RestoreFrom(storageEngine, typeName, entityID)
+}
+
+// RestoreFrom restores entity data in the storage from trash
+//
+// RestoreFrom is used by tools on storages opened by OpenStorage, when games are stopped.
+func RestoreFrom(es storagecommon.EntityStorage, typeName string, entityID common.EntityID) error {
+	trashType := trashTypeName(typeName)
+	exists, err := es.Exists(trashType, entityID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.Errorf("%s %s is not in trash", typeName, entityID)
+	}
+
+	exists, err = es.Exists(typeName, entityID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.Errorf("%s %s already exists", typeName, entityID)
+	}
+
+	trashData, err := es.Read(trashType, entityID)
+	if err != nil {
+		return err
+	}
+
+	data := typeconv.MapStringAnything(trashData)[_TRASH_KEY_DATA]
+	if err = es.Write(typeName, entityID, data); err != nil {
+		return err
+	}
+
+	gwlog.Infof("storage: restored %s %s from trash", typeName, entityID)
+	return es.Delete(trashType, entityID)
+}
+
+// TrashedEntity is an entity in trash
+type TrashedEntity struct {
+	EntityID   common.EntityID
+	DeleteTime time.Time
+}
+
+// ListTrash returns entities of the type in trash of the storage, ordered by delete time
+func ListTrash(es storagecommon.EntityStorage, typeName string) ([]TrashedEntity, error) {
+	trashType := trashTypeName(typeName)
+	eids, err := es.List(trashType)
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]TrashedEntity, 0, len(eids))
+	for _, eid := range eids {
+		trashData, err := es.Read(trashType, eid)
+		if err != nil {
+			return nil, err
+		}
+
+		deleteTime := typeconv.Int(typeconv.MapStringAnything(trashData)[_TRASH_KEY_DELETE_TIME])
+		trashed = append(trashed, TrashedEntity{EntityID: eid, DeleteTime: time.Unix(deleteTime, 0)})
+	}
+	sort.Slice(trashed, func(i, j int) bool {
+		return trashed[i].DeleteTime.Before(trashed[j].DeleteTime)
+	})
+	return trashed, nil
+}
+
+func purgeExpiredTrash(typeName string, retention time.Duration) (int, error) {
+	trashType := trashTypeName(typeName)
+	eids, err := storageEngine.List(trashType)
+	if err != nil {
+		return 0, err
+	}
+
+	expireTime := time.Now().Add(-retention).Unix()
+	purgedCount := 0
+	for _, eid := range eids {
+		trashData, err := storageEngine.Read(trashType, eid)
+		if err != nil {
+			return purgedCount, err
+		}
+
+		deleteTime := typeconv.Int(typeconv.MapStringAnything(trashData)[_TRASH_KEY_DELETE_TIME])
+		if deleteTime > expireTime {
+			continue
+		}
+
+		if err := storageEngine.Delete(trashType, eid); err != nil {
+			return purgedCount, err
+		}
+		purgedCount += 1
+	}
+
+	if purgedCount > 0 {
+		gwlog.Infof("storage: purged %d expired %s from trash", purgedCount, typeName)
+	}
+	return purgedCount, nil
+}
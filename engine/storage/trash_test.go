@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+)
+
+func TestTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		storageEngine = nil
+	}()
+
+	eid := common.GenEntityID()
+	if err := storageEngine.Write("Avatar", eid, map[string]interface{}{"name": "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteEntity("Avatar", eid, false); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := storageEngine.Exists("Avatar", eid); exists {
+		t.Fatalf("Avatar %s should be deleted", eid)
+	}
+
+	if err := restoreEntity("Avatar", eid); err != nil {
+		t.Fatal(err)
+	}
+	data, err := storageEngine.Read("Avatar", eid)
+	if err != nil || data.(map[string]interface{})["name"] != "test" {
+		t.Fatalf("restore Avatar %s failed: %v, %v", eid, data, err)
+	}
+
+	if err := deleteEntity("Avatar", eid, false); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := purgeExpiredTrash("Avatar", time.Hour); err != nil || n != 0 {
+		t.Fatalf("should not purge trash before retention: %d, %v", n, err)
+	}
+	if n, err := purgeExpiredTrash("Avatar", -time.Second); err != nil || n != 1 {
+		t.Fatalf("should purge expired trash: %d, %v", n, err)
+	}
+	if err := restoreEntity("Avatar", eid); err == nil {
+		t.Fatalf("restore purged Avatar %s should fail", eid)
+	}
+}
+
+func TestPlanDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_plan_delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, trashed, missing := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	es.Write("Account", existing, map[string]interface{}{"name": "a"})
+	es.Write("Account", trashed, map[string]interface{}{"name": "b"})
+	if err := DeleteFrom(es, "Account", trashed, false); err != nil {
+		t.Fatal(err)
+	}
+
+	eids := []common.EntityID{existing, trashed, missing}
+	plan, err := PlanDelete(es, "delete-entities Account", "Account", eids, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Count("delete") != 1 || plan.Count("skip") != 2 {
+		t.Fatalf("wrong plan: %+v", plan.Changes())
+	}
+	if plan, _ := PlanDelete(es, "delete-entities Account", "Account", eids, true); plan.Count("purge") != 2 || plan.Count("skip") != 1 {
+		t.Fatalf("wrong purge plan: %+v", plan.Changes())
+	}
+	if exists, _ := es.Exists("Account", existing); !exists {
+		t.Fatalf("planning should not delete entities")
+	}
+}
+
+func TestRestoreFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_restore_from")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := common.GenEntityID(), common.GenEntityID()
+	for _, eid := range []common.EntityID{first, second} {
+		es.Write("Account", eid, map[string]interface{}{"name": string(eid)})
+		if err := DeleteFrom(es, "Account", eid, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trashed, err := ListTrash(es, "Account")
+	if err != nil || len(trashed) != 2 || trashed[0].DeleteTime.IsZero() {
+		t.Fatalf("wrong trash: %+v, %v", trashed, err)
+	}
+
+	if err := RestoreFrom(es, "Account", first); err != nil {
+		t.Fatal(err)
+	}
+	data, err := es.Read("Account", first)
+	if err != nil || data.(map[string]interface{})["name"] != string(first) {
+		t.Fatalf("restore Account %s failed: %v, %v", first, data, err)
+	}
+	if trashed, _ := ListTrash(es, "Account"); len(trashed) != 1 || trashed[0].EntityID != second {
+		t.Fatalf("restored Account should be removed from trash: %+v", trashed)
+	}
+	if err := RestoreFrom(es, "Account", first); err == nil {
+		t.Fatalf("restore Account %s not in trash should fail", first)
+	}
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+var (
+	// writeBehind buffers entity saves if write-behind is enabled, otherwise it is nil
+	writeBehind *writeBehindBuffer
+
+	pendingSavesOverLimit = metrics.NewCounter("goworld_storage_pending_saves_over_limit_total", "Entity saves buffered by write-behind while pending saves exceed write_behind_max_pending")
+)
+
+func init() {
+	metrics.NewGaugeFunc("goworld_storage_pending_saves", "Number of entity saves buffered by write-behind", func() float64 {
+		if wb := writeBehind; wb != nil {
+			return float64(wb.len())
+		}
+		return 0
+	})
+}
+
+// flushRequest writes pending saves, or all pending saves including saves added during the flush
+type flushRequest struct {
+	All bool
+}
+
+// flushEntityRequest writes the pending save of the entity
+type flushEntityRequest struct {
+	TypeName string
+	EntityID common.EntityID
+}
+
+type pendingSaveKey struct {
+	TypeName string
+	EntityID common.EntityID
+}
+
+// writeBehindBuffer coalesces fenced saves of the same entity, and the storage routine writes them periodically
+//
+// Pending saves of an entity are written before other operations of the entity in this process. Other processes only
+// see pending saves after they are written, so saves of destroyed entities are flushed by FlushPendingSave at once.
+// Saves never block the game routine: if pending saves exceed maxPending, saves are still buffered and coalesced, and
+// flushed without waiting for the flush interval until the storage catches up.
+type writeBehindBuffer struct {
+	sync.Mutex
+	pending        map[pendingSaveKey]*list.Element
+	order          *list.List // of *fencedSaveRequest, in order of first saves
+	flushRequested bool
+	maxPending     int
+	overLimit      bool // pending saves exceed maxPending
+	stop           chan struct{}
+	stopped        sync.WaitGroup
+}
+
+func setupWriteBehind(cfg *config.StorageConfig) {
+	if cfg.WriteBehindInterval <= 0 {
+		return
+	}
+
+	gwlog.Infof("storage: write-behind is enabled: flush interval %s, max pending %d", cfg.WriteBehindInterval, cfg.WriteBehindMaxPending)
+	wb := &writeBehindBuffer{
+		pending:    map[pendingSaveKey]*list.Element{},
+		order:      list.New(),
+		maxPending: cfg.WriteBehindMaxPending,
+		stop:       make(chan struct{}),
+	}
+	writeBehind = wb
+
+	wb.stopped.Add(1)
+	go wb.flushRoutine(cfg.WriteBehindInterval)
+}
+
+// stopWriteBehind stops periodical flushes and writes all pending saves before storage is closed
+func stopWriteBehind() {
+	wb := writeBehind
+	if wb == nil {
+		return
+	}
+
+	close(wb.stop)
+	wb.stopped.Wait()
+	operationQueue.Push(flushRequest{All: true})
+}
+
+func (wb *writeBehindBuffer) flushRoutine(interval time.Duration) {
+	defer wb.stopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			wb.Lock()
+			if wb.order.Len() > 0 {
+				wb.requestFlush()
+			}
+			wb.Unlock()
+		case <-wb.stop:
+			return
+		}
+	}
+}
+
+func (wb *writeBehindBuffer) len() int {
+	wb.Lock()
+	defer wb.Unlock()
+	return wb.order.Len()
+}
+
+// add buffers the save, and warns if there are too many pending saves
+func (wb *writeBehindBuffer) add(req fencedSaveRequest) {
+	wb.Lock()
+	defer wb.Unlock()
+
+	key := pendingSaveKey{req.TypeName, req.EntityID}
+	if elem, ok := wb.pending[key]; ok {
+		// coalesce with the pending save, callbacks of both saves are called when the latest data is written
+		pending := elem.Value.(*fencedSaveRequest)
+		prevCallback, callback := pending.Callback, req.Callback
+		pending.Data, pending.Epoch = req.Data, req.Epoch
+		if prevCallback == nil {
+			pending.Callback = callback
+		} else if callback != nil {
+			pending.Callback = func(err error) {
+				prevCallback(err)
+				callback(err)
+			}
+		}
+		return
+	}
+
+	wb.pending[key] = wb.order.PushBack(&req)
+	if wb.maxPending > 0 && wb.order.Len() > wb.maxPending {
+		pendingSavesOverLimit.Inc()
+		if !wb.overLimit {
+			wb.overLimit = true
+			gwlog.Warnf("storage: %d saves are pending, exceeding write_behind_max_pending %d, storage is lagging behind", wb.order.Len(), wb.maxPending)
+		}
+		wb.requestFlush()
+	}
+}
+
+// checkCaughtUp logs when pending saves are back under maxPending
+func (wb *writeBehindBuffer) checkCaughtUp() {
+	if wb.overLimit && wb.order.Len() <= wb.maxPending {
+		wb.overLimit = false
+		gwlog.Infof("storage: pending saves are back under write_behind_max_pending %d", wb.maxPending)
+	}
+}
+
+func (wb *writeBehindBuffer) requestFlush() {
+	if !wb.flushRequested {
+		wb.flushRequested = true
+		operationQueue.Push(flushRequest{})
+	}
+}
+
+// take removes pending saves which are matched by filter, or all pending saves if filter is nil
+func (wb *writeBehindBuffer) take(filter func(key pendingSaveKey) bool) (reqs []*fencedSaveRequest) {
+	wb.Lock()
+	defer wb.Unlock()
+
+	for elem := wb.order.Front(); elem != nil; {
+		next := elem.Next()
+		req := elem.Value.(*fencedSaveRequest)
+		key := pendingSaveKey{req.TypeName, req.EntityID}
+		if filter == nil || filter(key) {
+			wb.order.Remove(elem)
+			delete(wb.pending, key)
+			reqs = append(reqs, req)
+		}
+		elem = next
+	}
+	wb.checkCaughtUp()
+	return
+}
+
+// write writes the saves one by one, since fenced writes of storages are conditional writes of single entities
+func (wb *writeBehindBuffer) write(reqs []*fencedSaveRequest) {
+	for _, req := range reqs {
+		handleFencedSave(*req)
+	}
+}
+
+// flushPendingSaves writes pending saves, or all pending saves including saves added during the flush
+func flushPendingSaves(all bool) {
+	wb := writeBehind
+	if wb == nil {
+		return
+	}
+
+	wb.Lock()
+	wb.flushRequested = false
+	wb.Unlock()
+
+	for {
+		reqs := wb.take(nil)
+		if len(reqs) == 0 {
+			return
+		}
+		wb.write(reqs)
+		if !all {
+			break
+		}
+	}
+
+	wb.Lock()
+	if wb.overLimit {
+		wb.requestFlush() // storage is lagging behind, flush saves added during the flush immediately
+	}
+	wb.Unlock()
+}
+
+// FlushPendingSave writes the save of the entity buffered by write-behind without waiting for the flush interval
+//
+// Entities should flush their saves when destroyed, so that other games loading them later see the latest data.
+func FlushPendingSave(typeName string, entityID common.EntityID) {
+	if writeBehind == nil {
+		return
+	}
+
+	operationQueue.Push(flushEntityRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+	})
+	checkOperationQueueLen()
+}
+
+// flushPendingSave writes the pending save of the entity
+func flushPendingSave(typeName string, entityID common.EntityID) {
+	if req := takePendingSave(typeName, entityID); req != nil {
+		writeBehind.write([]*fencedSaveRequest{req})
+	}
+}
+
+// takePendingSave removes the pending save of the entity, returns nil if not found
+func takePendingSave(typeName string, entityID common.EntityID) *fencedSaveRequest {
+	wb := writeBehind
+	if wb == nil {
+		return nil
+	}
+
+	key := pendingSaveKey{typeName, entityID}
+	wb.Lock()
+	defer wb.Unlock()
+	elem, ok := wb.pending[key]
+	if !ok {
+		return nil
+	}
+
+	wb.order.Remove(elem)
+	delete(wb.pending, key)
+	wb.checkCaughtUp()
+	return elem.Value.(*fencedSaveRequest)
+}
+
+// getPendingSaveData returns a copy of data of the pending save of the entity
+func getPendingSaveData(typeName string, entityID common.EntityID) (map[string]interface{}, bool) {
+	wb := writeBehind
+	if wb == nil {
+		return nil, false
+	}
+
+	wb.Lock()
+	defer wb.Unlock()
+	elem, ok := wb.pending[pendingSaveKey{typeName, entityID}]
+	if !ok {
+		return nil, false
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range elem.Value.(*fencedSaveRequest).Data {
+		data[k] = v
+	}
+	return data, true
+}
+
+// flushPendingSavesOfType writes all pending saves of the entity type
+func flushPendingSavesOfType(typeName string) {
+	wb := writeBehind
+	if wb == nil {
+		return
+	}
+
+	wb.write(wb.take(func(key pendingSaveKey) bool {
+		return key.TypeName == typeName
+	}))
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/typeconv"
+)
+
+func TestWriteBehind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_write_behind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb := &writeBehindBuffer{
+		pending: map[pendingSaveKey]*list.Element{},
+		order:   list.New(),
+	}
+	writeBehind = wb
+	defer func() {
+		storageEngine = nil
+		writeBehind = nil
+	}()
+
+	eids := []common.EntityID{common.GenEntityID(), common.GenEntityID(), common.GenEntityID()}
+	callbacks := 0
+	save := func(eid common.EntityID, name string) {
+		wb.add(fencedSaveRequest{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"name": name}, Epoch: 1, Callback: func(err error) {
+			if err != nil {
+				t.Errorf("save failed: %s", err)
+			}
+			callbacks += 1
+		}})
+	}
+	read := func(eid common.EntityID) string {
+		data, err := storageEngine.Read("Avatar", eid)
+		if err != nil || data == nil {
+			return ""
+		}
+		return typeconv.String(typeconv.MapStringAnything(data)["name"])
+	}
+
+	save(eids[0], "a1")
+	save(eids[0], "a2")
+	if wb.len() != 1 {
+		t.Fatalf("saves of the same entity should be coalesced, pending %d", wb.len())
+	}
+	if read(eids[0]) != "" {
+		t.Fatalf("save should be buffered")
+	}
+
+	flushPendingSave("Avatar", eids[0])
+	post.Tick()
+	if name := read(eids[0]); name != "a2" || callbacks != 2 {
+		t.Fatalf("flushed %q with %d callbacks, expected a2 with 2 callbacks", name, callbacks)
+	}
+
+	for _, eid := range eids {
+		save(eid, "b")
+	}
+	if wb.len() != 3 || wb.flushRequested {
+		t.Fatalf("saves should be buffered until the flush interval, pending %d", wb.len())
+	}
+	flushPendingSaves(false)
+	post.Tick()
+	for _, eid := range eids {
+		if read(eid) != "b" {
+			t.Fatalf("%s is not flushed", eid)
+		}
+	}
+	if wb.len() != 0 || callbacks != 5 {
+		t.Fatalf("pending %d, callbacks %d", wb.len(), callbacks)
+	}
+}
+
+func TestWriteBehindOverLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_write_behind_limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb := &writeBehindBuffer{
+		pending:    map[pendingSaveKey]*list.Element{},
+		order:      list.New(),
+		maxPending: 2,
+	}
+	writeBehind = wb
+	defer func() {
+		storageEngine = nil
+		writeBehind = nil
+	}()
+
+	eids := []common.EntityID{common.GenEntityID(), common.GenEntityID(), common.GenEntityID()}
+	for round := 0; round < 2; round++ {
+		for _, eid := range eids {
+			// saves do not block while the storage is lagging behind
+			wb.add(fencedSaveRequest{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"round": round}, Epoch: 1})
+		}
+	}
+	if wb.len() != 3 || !wb.overLimit || !wb.flushRequested {
+		t.Fatalf("saves over the limit should be coalesced and flushed: pending %d, over limit %v, flush requested %v", wb.len(), wb.overLimit, wb.flushRequested)
+	}
+
+	flushPendingSaves(false)
+	post.Tick()
+	if wb.len() != 0 || wb.overLimit {
+		t.Fatalf("pending saves should be written: pending %d, over limit %v", wb.len(), wb.overLimit)
+	}
+	for _, eid := range eids {
+		if data, _ := storageEngine.Read("Avatar", eid); typeconv.Int(typeconv.MapStringAnything(data)["round"]) != 1 {
+			t.Fatalf("latest save of %s is not written: %v", eid, data)
+		}
+	}
+}
+
+func TestFlushPendingSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goworld_storage_flush_pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storageEngine, err = entitystoragefilesystem.OpenDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wb := &writeBehindBuffer{
+		pending: map[pendingSaveKey]*list.Element{},
+		order:   list.New(),
+	}
+	writeBehind = wb
+	defer func() {
+		storageEngine = nil
+		writeBehind = nil
+	}()
+
+	for _, ok := operationQueue.TryPop(); ok; _, ok = operationQueue.TryPop() {
+		// drop operations queued by other tests
+	}
+
+	// the save of the destroyed entity is written by the next storage operation, without waiting for the flush interval
+	eid, other := common.GenEntityID(), common.GenEntityID()
+	wb.add(fencedSaveRequest{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"name": "destroyed"}, Epoch: 1})
+	wb.add(fencedSaveRequest{TypeName: "Avatar", EntityID: other, Data: map[string]interface{}{"name": "alive"}, Epoch: 1})
+	FlushPendingSave("Avatar", eid)
+	req, ok := operationQueue.Pop().(flushEntityRequest)
+	if !ok || req.TypeName != "Avatar" || req.EntityID != eid {
+		t.Fatalf("wrong flush request: %+v", req)
+	}
+	flushPendingSave(req.TypeName, req.EntityID)
+	if data, _ := storageEngine.Read("Avatar", eid); typeconv.String(typeconv.MapStringAnything(data)["name"]) != "destroyed" {
+		t.Fatalf("save of destroyed entity is not written: %v", data)
+	}
+	if wb.len() != 1 {
+		t.Fatalf("saves of other entities should be kept buffered, pending %d", wb.len())
+	}
+}
@@ -0,0 +1,286 @@
+// Package timerwheel implements a hierarchical timer wheel for timers of entities
+//
+// Timers are kept in slots of wheels by their fire ticks: the root wheel has a slot for each of the next 256 ticks, and
+// each upper wheel has 64 slots each covering a whole round of the wheel below it. Adding and cancelling a timer is
+// O(1), and all timers of a tick are fired in one batch. Timers of an upper wheel slot are moved to lower wheels when
+// the lower wheel finishes a round.
+//
+// The timer wheel is not goroutine-safe, it should only be used in the game routine, so no lock is needed.
+package timerwheel
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+const (
+	_ROOT_BITS  = 8
+	_ROOT_SIZE  = 1 << _ROOT_BITS
+	_LEVEL_BITS = 6
+	_LEVEL_SIZE = 1 << _LEVEL_BITS
+	_LEVELS     = 4 // upper wheels above the root wheel
+	_MAX_TICKS  = 1<<(_ROOT_BITS+_LEVELS*_LEVEL_BITS) - 1
+	_ROOT_MASK  = _ROOT_SIZE - 1
+	_LEVEL_MASK = _LEVEL_SIZE - 1
+	_MIN_TICKS  = 1 // repeat timers fire at most once per tick
+)
+
+// CallbackFunc is the type of timer callbacks
+type CallbackFunc func()
+
+// Timer is a callback or a repeat timer added to the timer wheel
+type Timer struct {
+	wheel      *Wheel
+	expireTick uint64
+	interval   uint64 // ticks between fires of repeat timers, 0 for callbacks
+	callback   CallbackFunc
+	prev, next *Timer // linked in the slot
+}
+
+// Cancel cancels the timer, it is fine to cancel a timer more than once
+func (t *Timer) Cancel() {
+	t.callback = nil
+	if t.next != nil {
+		t.unlink()
+		t.wheel.count--
+	}
+}
+
+// IsActive returns if the timer is not fired (for callbacks) or cancelled
+func (t *Timer) IsActive() bool {
+	return t.callback != nil
+}
+
+func (t *Timer) unlink() {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+}
+
+// timerList is a circular list of timers linked by the sentinel
+type timerList struct {
+	sentinel Timer
+}
+
+func (l *timerList) init() {
+	l.sentinel.prev, l.sentinel.next = &l.sentinel, &l.sentinel
+}
+
+func (l *timerList) empty() bool {
+	return l.sentinel.next == &l.sentinel
+}
+
+func (l *timerList) pushBack(t *Timer) {
+	t.prev, t.next = l.sentinel.prev, &l.sentinel
+	l.sentinel.prev.next = t
+	l.sentinel.prev = t
+}
+
+// moveTo moves all timers to the empty list
+func (l *timerList) moveTo(dst *timerList) {
+	if l.empty() {
+		return
+	}
+	dst.sentinel.next, dst.sentinel.prev = l.sentinel.next, l.sentinel.prev
+	dst.sentinel.next.prev = &dst.sentinel
+	dst.sentinel.prev.next = &dst.sentinel
+	l.init()
+}
+
+// Wheel is a hierarchical timer wheel
+type Wheel struct {
+	resolution time.Duration
+	startTime  time.Time
+	nextTick   uint64 // next tick to be processed
+	count      int    // timers in the wheel
+	root       [_ROOT_SIZE]timerList
+	levels     [_LEVELS][_LEVEL_SIZE]timerList
+}
+
+// New creates a timer wheel which ticks by the resolution
+func New(resolution time.Duration) *Wheel {
+	w := &Wheel{
+		resolution: resolution,
+		startTime:  clock(),
+		nextTick:   1,
+	}
+	for i := range w.root {
+		w.root[i].init()
+	}
+	for i := range w.levels {
+		for j := range w.levels[i] {
+			w.levels[i][j].init()
+		}
+	}
+	return w
+}
+
+// Len returns the number of timers in the wheel
+func (w *Wheel) Len() int {
+	return w.count
+}
+
+// AddCallback adds a callback which is called after the duration
+func (w *Wheel) AddCallback(d time.Duration, callback CallbackFunc) *Timer {
+	return w.addTimer(clock(), d, 0, callback)
+}
+
+// AddTimer adds a repeat timer which calls the callback every duration
+func (w *Wheel) AddTimer(d time.Duration, callback CallbackFunc) *Timer {
+	interval := w.ticksOf(d)
+	if interval < _MIN_TICKS {
+		interval = _MIN_TICKS
+	}
+	return w.addTimer(clock(), d, interval, callback)
+}
+
+func (w *Wheel) addTimer(now time.Time, d time.Duration, interval uint64, callback CallbackFunc) *Timer {
+	t := &Timer{
+		wheel:      w,
+		expireTick: w.ticksOf(now.Add(d).Sub(w.startTime)),
+		interval:   interval,
+		callback:   callback,
+	}
+	w.add(t)
+	return t
+}
+
+// ticksOf returns ticks of the duration rounded up, so that timers never fire early
+func (w *Wheel) ticksOf(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + w.resolution - 1) / w.resolution)
+}
+
+func (w *Wheel) add(t *Timer) {
+	w.count++
+	if t.expireTick < w.nextTick {
+		// fire at the next tick
+		w.root[w.nextTick&_ROOT_MASK].pushBack(t)
+		return
+	}
+
+	delta := t.expireTick - w.nextTick
+	if delta < _ROOT_SIZE {
+		w.root[t.expireTick&_ROOT_MASK].pushBack(t)
+		return
+	}
+
+	expireTick := t.expireTick
+	if delta > _MAX_TICKS {
+		// too far, put in the last slot of the top wheel and move down when the slot is reached
+		expireTick = w.nextTick + _MAX_TICKS
+		delta = _MAX_TICKS
+	}
+	for level := 0; level < _LEVELS; level++ {
+		shift := uint(_ROOT_BITS + level*_LEVEL_BITS)
+		if delta < 1<<(shift+_LEVEL_BITS) || level == _LEVELS-1 {
+			w.levels[level][(expireTick>>shift)&_LEVEL_MASK].pushBack(t)
+			return
+		}
+	}
+}
+
+// Tick fires all timers expired, it should be called by the game routine at every tick
+func (w *Wheel) Tick() {
+	w.advance(clock())
+}
+
+func (w *Wheel) advance(now time.Time) {
+	currentTick := uint64(now.Sub(w.startTime) / w.resolution)
+	if w.count == 0 && currentTick >= w.nextTick {
+		w.nextTick = currentTick + 1 // nothing to fire
+		return
+	}
+
+	var batch timerList
+	batch.init()
+	for w.nextTick <= currentTick {
+		index := w.nextTick & _ROOT_MASK
+		if index == 0 {
+			w.cascade()
+		}
+
+		w.root[index].moveTo(&batch)
+		w.nextTick++
+		w.fire(&batch, currentTick)
+	}
+}
+
+// cascade moves timers of upper wheel slots to lower wheels when the root wheel finishes a round
+func (w *Wheel) cascade() {
+	for level := 0; level < _LEVELS; level++ {
+		shift := uint(_ROOT_BITS + level*_LEVEL_BITS)
+		index := (w.nextTick >> shift) & _LEVEL_MASK
+
+		var timers timerList
+		timers.init()
+		w.levels[level][index].moveTo(&timers)
+		for !timers.empty() {
+			t := timers.sentinel.next
+			t.unlink()
+			w.count--
+			w.add(t)
+		}
+
+		if index != 0 {
+			break // upper wheels do not finish a round
+		}
+	}
+}
+
+// fire fires timers of the batch, timers cancelled by callbacks of the batch are not fired
+func (w *Wheel) fire(batch *timerList, currentTick uint64) {
+	for !batch.empty() {
+		t := batch.sentinel.next
+		t.unlink()
+		w.count--
+
+		callback := t.callback
+		if t.interval == 0 {
+			t.callback = nil
+		} else {
+			t.expireTick += t.interval
+			if t.expireTick <= currentTick {
+				t.expireTick = currentTick + 1 // fire once after the game is blocked for a long time
+			}
+			w.add(t)
+		}
+		gwutils.RunPanicless(callback)
+	}
+}
+
+var (
+	clock        = time.Now
+	defaultWheel = New(consts.GAME_SERVICE_TICK_INTERVAL)
+)
+
+// SetClock replaces the clock of timer wheels, which is time.Now by default, e.g. by the fake clock of test harnesses
+//
+// The clock should never go backwards, and timers added before are fired by the new clock.
+func SetClock(c func() time.Time) {
+	clock = c
+}
+
+// AddCallback adds a callback to the timer wheel of the game
+func AddCallback(d time.Duration, callback CallbackFunc) *Timer {
+	return defaultWheel.AddCallback(d, callback)
+}
+
+// AddTimer adds a repeat timer to the timer wheel of the game
+func AddTimer(d time.Duration, callback CallbackFunc) *Timer {
+	return defaultWheel.AddTimer(d, callback)
+}
+
+// Tick fires expired timers of the timer wheel of the game, called by engine
+func Tick() {
+	defaultWheel.Tick()
+}
+
+// Len returns the number of timers in the timer wheel of the game
+func Len() int {
+	return defaultWheel.Len()
+}
@@ -0,0 +1,113 @@
+package timerwheel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCallbacks(t *testing.T) {
+	w := New(time.Millisecond)
+	now := w.startTime
+
+	delays := []time.Duration{0, time.Millisecond, time.Millisecond * 255, time.Millisecond * 256, time.Millisecond * 300,
+		time.Second * 17, time.Minute * 5, time.Minute * 17}
+	fireTimes := make([]time.Time, len(delays))
+	for i, d := range delays {
+		i := i
+		w.addTimer(now, d, 0, func() {
+			fireTimes[i] = now
+		})
+	}
+	if w.Len() != len(delays) {
+		t.Fatalf("wrong timer count: %d", w.Len())
+	}
+
+	end := w.startTime.Add(time.Minute * 18)
+	for ; now.Before(end); now = now.Add(time.Millisecond * 7) {
+		w.advance(now)
+	}
+
+	for i, d := range delays {
+		fireTime := fireTimes[i]
+		if fireTime.IsZero() {
+			t.Errorf("callback after %s is not fired", d)
+		} else if fireTime.Before(w.startTime.Add(d)) || fireTime.After(w.startTime.Add(d+time.Millisecond*8)) {
+			t.Errorf("callback after %s is fired at %s", d, fireTime.Sub(w.startTime))
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("wrong timer count: %d", w.Len())
+	}
+}
+
+func TestRepeatTimer(t *testing.T) {
+	w := New(time.Millisecond)
+	now := w.startTime
+
+	count := 0
+	timer := w.addTimer(now, time.Millisecond*10, w.ticksOf(time.Millisecond*10), func() {
+		count++
+	})
+	for i := 0; i < 1000; i++ {
+		now = now.Add(time.Millisecond)
+		w.advance(now)
+	}
+	if count != 100 || !timer.IsActive() {
+		t.Errorf("repeat timer fired %d times", count)
+	}
+
+	// the repeat timer fires once when the game is blocked for a long time
+	now = now.Add(time.Second)
+	w.advance(now)
+	if count != 101 {
+		t.Errorf("repeat timer fired %d times", count)
+	}
+
+	timer.Cancel()
+	timer.Cancel()
+	now = now.Add(time.Second)
+	w.advance(now)
+	if count != 101 || timer.IsActive() || w.Len() != 0 {
+		t.Errorf("cancelled repeat timer fired %d times", count)
+	}
+}
+
+func TestCancelInBatch(t *testing.T) {
+	w := New(time.Millisecond)
+	now := w.startTime
+
+	var t2 *Timer
+	fired := 0
+	w.addTimer(now, time.Millisecond*5, 0, func() {
+		fired++
+		t2.Cancel()
+	})
+	t2 = w.addTimer(now, time.Millisecond*5, 0, func() {
+		fired++
+	})
+	w.addTimer(now, time.Millisecond*5, 0, func() {
+		fired++
+		w.addTimer(now, 0, 0, func() { // added by callbacks, fired at the next tick
+			fired++
+		})
+	})
+
+	w.advance(now.Add(time.Millisecond * 5))
+	if fired != 2 || t2.IsActive() || w.Len() != 1 {
+		t.Errorf("wrong timers fired: %d, %d left", fired, w.Len())
+	}
+	w.advance(now.Add(time.Millisecond * 6))
+	if fired != 3 || w.Len() != 0 {
+		t.Errorf("wrong timers fired: %d, %d left", fired, w.Len())
+	}
+}
+
+func BenchmarkAddCancel(b *testing.B) {
+	w := New(time.Millisecond)
+	for i := 0; i < b.N; i++ {
+		t := w.AddCallback(time.Duration(i%100000)*time.Millisecond, func() {})
+		if i%2 == 0 {
+			t.Cancel()
+		}
+	}
+}
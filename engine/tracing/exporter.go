@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+const (
+	_EXPORT_QUEUE_SIZE      = 8192
+	_EXPORT_BATCH_SIZE      = 512
+	_EXPORT_INTERVAL        = time.Second
+	_EXPORT_REQUEST_TIMEOUT = time.Second * 10
+)
+
+var (
+	spansExported = metrics.NewCounter("goworld_tracing_spans_exported_total", "Spans exported to the tracing endpoint")
+	spansDropped  = metrics.NewCounter("goworld_tracing_spans_dropped_total", "Spans dropped for full export queue or export errors")
+)
+
+// exporter exports spans in batches by OTLP/HTTP in JSON encoding
+type exporter struct {
+	serviceName string
+	endpoint    string
+	queue       chan *Span
+	client      *http.Client
+}
+
+func newExporter(serviceName string, endpoint string) *exporter {
+	return &exporter{
+		serviceName: serviceName,
+		endpoint:    endpoint,
+		queue:       make(chan *Span, _EXPORT_QUEUE_SIZE),
+		client:      &http.Client{Timeout: _EXPORT_REQUEST_TIMEOUT},
+	}
+}
+
+// export queues the span to be exported, the span is dropped if the queue is full, so tracing never blocks the caller
+func (e *exporter) export(span *Span) {
+	select {
+	case e.queue <- span:
+	default:
+		spansDropped.Inc()
+	}
+}
+
+func (e *exporter) run() {
+	ticker := time.NewTicker(_EXPORT_INTERVAL)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, _EXPORT_BATCH_SIZE)
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) < _EXPORT_BATCH_SIZE {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		e.post(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *exporter) post(batch []*Span) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		gwlog.Errorf("tracing: encode spans failed: %s", err)
+		spansDropped.Add(float64(len(batch)))
+		return
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = errors.Errorf("status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		gwlog.Warnf("tracing: export %d spans to %s failed: %s", len(batch), e.endpoint, err)
+		spansDropped.Add(float64(len(batch)))
+		return
+	}
+	spansExported.Add(float64(len(batch)))
+}
+
+// OTLP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (e *exporter) encode(batch []*Span) *otlpRequest {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		s := &spans[i]
+		s.TraceID = hex.EncodeToString(span.context.TraceID[:])
+		s.SpanID = hex.EncodeToString(span.context.SpanID[:])
+		if span.parentID != (SpanID{}) {
+			s.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		s.Name = span.name
+		s.Kind = span.kind
+		s.StartTimeUnixNano = strconv.FormatInt(span.startTime.UnixNano(), 10)
+		s.EndTimeUnixNano = strconv.FormatInt(span.endTime.UnixNano(), 10)
+		for _, attr := range span.attrs {
+			s.Attributes = append(s.Attributes, otlpAttr{attr.key, otlpAttrValue{attr.val}})
+		}
+	}
+
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttr{{"service.name", otlpAttrValue{e.serviceName}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "goworld"},
+				Spans: spans,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,186 @@
+// Package tracing traces RPC chains across gates, dispatchers and games, and exports spans to an OpenTelemetry
+// collector (e.g. Jaeger) by OTLP/HTTP in JSON encoding
+//
+// Gates start traces of RPCs from clients by the sample rate configured in [tracing]. The span context is propagated
+// in packets to dispatchers and games, which record spans of routing and handling the packets, and spans of entity
+// methods. RPCs between entities made by traced entity methods are traced in the same trace.
+//
+// Tracing is disabled if the endpoint of [tracing] is not configured, and all spans are nil, which is fine to use.
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// SpanContextSize is the size of encoded span contexts: trace ID, span ID and trace flags as in W3C trace context
+	SpanContextSize = 16 + 8 + 1
+
+	_TRACE_FLAG_SAMPLED = 0x01
+)
+
+// SpanKind is the kind of spans as defined by OpenTelemetry
+type SpanKind int
+
+const (
+	// SpanKindInternal is the kind of spans of internal operations, e.g. entity methods
+	SpanKindInternal SpanKind = 1
+	// SpanKindServer is the kind of spans of handling requests from remote, e.g. packets
+	SpanKindServer SpanKind = 2
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span in the trace
+type SpanID [8]byte
+
+// SpanContext is the context of a span propagated across processes, the zero SpanContext means not traced
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid returns if the span context is of a traced span
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{}
+}
+
+// Encode encodes the span context to b, which should be at least SpanContextSize long
+func (sc SpanContext) Encode(b []byte) {
+	copy(b[:16], sc.TraceID[:])
+	copy(b[16:24], sc.SpanID[:])
+	b[24] = _TRACE_FLAG_SAMPLED // only sampled spans are propagated
+}
+
+// DecodeSpanContext decodes the span context encoded by Encode
+func DecodeSpanContext(b []byte) SpanContext {
+	var sc SpanContext
+	copy(sc.TraceID[:], b[:16])
+	copy(sc.SpanID[:], b[16:24])
+	return sc
+}
+
+func (sc SpanContext) String() string {
+	return fmt.Sprintf("%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// Span records an operation in a trace
+//
+// Methods of Span can be called on nil spans, which are returned when tracing is disabled or traces are not sampled.
+type Span struct {
+	name      string
+	kind      SpanKind
+	context   SpanContext
+	parentID  SpanID
+	startTime time.Time
+	endTime   time.Time
+	attrs     []spanAttr
+}
+
+type spanAttr struct {
+	key string
+	val string
+}
+
+var (
+	enabled    int32 // accessed atomically
+	sampleRate float64
+	exp        *exporter
+
+	randLock sync.Mutex
+	random   = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	current SpanContext // span context of the entity method being called, only used by the game routine
+)
+
+// Setup enables tracing, and exports spans of the service to the OTLP/HTTP endpoint, e.g. http://jaeger:4318/v1/traces
+func Setup(serviceName string, endpoint string, rate float64) {
+	if endpoint == "" {
+		return
+	}
+
+	sampleRate = rate
+	exp = newExporter(serviceName, endpoint)
+	go exp.run()
+	atomic.StoreInt32(&enabled, 1)
+	gwlog.Infof("Tracing is enabled: service=%s, endpoint=%s, sample rate=%v", serviceName, endpoint, rate)
+}
+
+// IsEnabled returns if tracing is enabled
+func IsEnabled() bool {
+	return atomic.LoadInt32(&enabled) != 0
+}
+
+// StartSpan starts a span of the trace of the parent span, or a new trace by the sample rate if parent is not valid
+//
+// StartSpan returns nil if tracing is disabled or the new trace is not sampled.
+func StartSpan(name string, kind SpanKind, parent SpanContext) *Span {
+	if !IsEnabled() {
+		return nil
+	}
+
+	span := &Span{
+		name:      name,
+		kind:      kind,
+		startTime: time.Now(),
+	}
+
+	randLock.Lock()
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.parentID = parent.SpanID
+	} else if random.Float64() < sampleRate {
+		random.Read(span.context.TraceID[:])
+	} else {
+		randLock.Unlock()
+		return nil
+	}
+	random.Read(span.context.SpanID[:])
+	randLock.Unlock()
+	return span
+}
+
+// Context returns the span context of the span, which is not valid if the span is nil
+func (span *Span) Context() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return span.context
+}
+
+// SetAttr sets the attribute of the span
+func (span *Span) SetAttr(key string, val interface{}) {
+	if span == nil {
+		return
+	}
+	span.attrs = append(span.attrs, spanAttr{key, fmt.Sprint(val)})
+}
+
+// End ends the span and exports it
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.endTime = time.Now()
+	exp.export(span)
+}
+
+// Current returns the span context of the entity method being called by the game routine
+func Current() SpanContext {
+	return current
+}
+
+// SetCurrent sets the span context of the entity method being called by the game routine, and returns the previous one
+func SetCurrent(sc SpanContext) SpanContext {
+	prev := current
+	current = sc
+	return prev
+}
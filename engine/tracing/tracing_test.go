@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSpanContextEncoding(t *testing.T) {
+	sc := SpanContext{TraceID: TraceID{1, 2, 3}, SpanID: SpanID{4, 5, 6}}
+	var buf [SpanContextSize]byte
+	sc.Encode(buf[:])
+	if decoded := DecodeSpanContext(buf[:]); decoded != sc {
+		t.Errorf("decoded span context %s is different from %s", decoded, sc)
+	}
+	if !sc.IsValid() || (SpanContext{}).IsValid() {
+		t.Errorf("wrong validity of span contexts")
+	}
+}
+
+func TestTracing(t *testing.T) {
+	var span *Span // spans are nil if tracing is disabled
+	if span = StartSpan("disabled", SpanKindServer, SpanContext{}); span != nil {
+		t.Fatalf("span is started when tracing is disabled")
+	}
+	span.SetAttr("key", "val")
+	span.End()
+	if span.Context().IsValid() {
+		t.Fatalf("context of nil span should not be valid")
+	}
+
+	received := make(chan *otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid request: %s", err)
+		}
+		received <- &req
+	}))
+	defer server.Close()
+
+	Setup("game1", server.URL, 0)
+	if span := StartSpan("not sampled", SpanKindServer, SpanContext{}); span != nil {
+		t.Fatalf("span is sampled by sample rate 0")
+	}
+	sampleRate = 1
+	root := StartSpan("root", SpanKindServer, SpanContext{})
+	child := StartSpan("child", SpanKindInternal, root.Context())
+	if !root.Context().IsValid() || child.Context().TraceID != root.Context().TraceID || child.parentID != root.Context().SpanID {
+		t.Fatalf("wrong span contexts: root %s, child %s", root.Context(), child.Context())
+	}
+	child.SetAttr("goworld.entity_id", "abc")
+	child.End()
+	root.End()
+
+	select {
+	case req := <-received:
+		spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+		if len(spans) != 2 || spans[0].Name != "child" || spans[1].ParentSpanID != "" ||
+			spans[0].ParentSpanID != spans[1].SpanID || spans[0].Attributes[0].Value.StringValue != "abc" {
+			t.Errorf("wrong spans exported: %+v", spans)
+		}
+		if attr := req.ResourceSpans[0].Resource.Attributes[0]; attr.Value.StringValue != "game1" {
+			t.Errorf("wrong service name: %+v", attr)
+		}
+	case <-time.After(_EXPORT_INTERVAL * 3):
+		t.Fatalf("spans are not exported")
+	}
+}
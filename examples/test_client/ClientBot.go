@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/xiaonanln/netconnutil"
 	"net"
+	"strconv"
 	"sync"
 
 	"fmt"
@@ -21,6 +22,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/interp"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
@@ -51,10 +53,14 @@ type ClientBot struct {
 	logined            bool
 	startedDoingThings bool
 	syncPosTime        time.Time
+	inputSeq           uint32 // sequence number of last input sent to server
+	ackedInputSeq      uint32 // last input sequence number acknowledged by server
+	serverClock        interp.Clock
 	useKCP             bool
 	useWebSocket       bool
 	noEntitySync       bool
 	packetQueue        chan proto.Message
+	sessionSecret      []byte // session_secret of the gate, for signing session tokens like the auth service
 }
 
 func newClientBot(id int, useWebSocket bool, useKCP bool, noEntitySync bool, waiter *sync.WaitGroup, waitAllConnected *sync.WaitGroup) *ClientBot {
@@ -79,11 +85,12 @@ func (bot *ClientBot) run() {
 
 	gwlog.Infof("%s is running ...", bot)
 
-	desiredGates := config.GetDeployment().DesiredGates
+	desiredGates := conf.GetDeployment().DesiredGates
 	// choose a random gateid
 	gateid := uint16(rand.Intn(desiredGates) + 1)
 	gwlog.Debugf("%s is connecting to gate %d", bot, gateid)
-	cfg := config.GetGate(gateid)
+	cfg := conf.GetGate(gateid)
+	bot.sessionSecret = []byte(cfg.SessionSecret)
 
 	var netconn net.Conn
 	var err error
@@ -109,6 +116,7 @@ func (bot *ClientBot) run() {
 	conn = netconnutil.NewBufferedConn(conn, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE)
 	bot.conn = proto.NewGoWorldConnection(conn)
 	defer bot.conn.Close()
+	bot.conn.SendSetClientProtocolVersionFromClient(proto.CLIENT_PROTOCOL_VERSION)
 
 	if bot.useKCP {
 		gwlog.Infof("Notify KCP connected ...")
@@ -143,20 +151,42 @@ func (bot *ClientBot) connectServer(cfg *config.GateConfig) (net.Conn, error) {
 }
 
 func (bot *ClientBot) connectServerByKCP(cfg *config.GateConfig) (net.Conn, error) {
-	// just use tcp
 	_, listenPort, err := net.SplitHostPort(cfg.ListenAddr)
 	if err != nil {
 		gwlog.Fatalf("can not parse host:port: %s", cfg.ListenAddr)
 	}
+	if cfg.ListenKCPPort > 0 {
+		listenPort = strconv.Itoa(cfg.ListenKCPPort)
+	}
 
-	serverAddr := net.JoinHostPort(serverHost, listenPort)
-	conn, err := kcp.DialWithOptions(serverAddr, nil, 10, 3)
+	serverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(serverHost, listenPort))
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	// unreliable messages are sent in datagrams by the gate
+	datagramConn := proto.NewDatagramPacketConn(udpConn, func(msgtype proto.MsgType, packet *netutil.Packet) {
+		bot.packetQueue <- proto.Message{MsgType: msgtype, Packet: packet}
+	})
+	conn, err := kcp.NewConn2(serverAddr, nil, 10, 3, datagramConn)
 	if err != nil {
+		udpConn.Close()
 		return nil, err
 	}
 	conn.SetReadBuffer(64 * 1024)
 	conn.SetWriteBuffer(64 * 1024)
-	conn.SetNoDelay(consts.KCP_NO_DELAY, consts.KCP_INTERNAL_UPDATE_TIMER_INTERVAL, consts.KCP_ENABLE_FAST_RESEND, consts.KCP_DISABLE_CONGESTION_CONTROL)
+	nodelay, nc := 0, 0
+	if cfg.KCPNoDelay {
+		nodelay = 1
+	}
+	if cfg.KCPNoCongestion {
+		nc = 1
+	}
+	conn.SetNoDelay(nodelay, cfg.KCPInterval, cfg.KCPResend, nc)
+	conn.SetWindowSize(cfg.KCPSendWindow, cfg.KCPRecvWindow)
 	conn.SetStreamMode(consts.KCP_SET_STREAM_MODE)
 	conn.SetWriteDelay(consts.KCP_SET_WRITE_DELAY)
 	conn.SetACKNoDelay(consts.KCP_SET_ACK_NO_DELAY)
@@ -175,8 +205,13 @@ func (bot *ClientBot) connectServerByWebsocket(cfg *config.GateConfig) (net.Conn
 		gwlog.Fatalf("can not parse host:port: %s", cfg.HTTPAddr)
 	}
 
-	origin := fmt.Sprintf("%s://%s:%s/", originProto, serverHost, httpPort)
-	wsaddr := fmt.Sprintf("%s://%s:%s/ws", wsProto, serverHost, httpPort)
+	wsPort, wsPath := httpPort, "/ws"
+	if cfg.ListenWSPort > 0 { // connect to the dedicated WebSocket listener
+		wsPort, wsPath = strconv.Itoa(cfg.ListenWSPort), cfg.ListenWSPath
+	}
+
+	origin := fmt.Sprintf("%s://%s:%s/", originProto, serverHost, wsPort)
+	wsaddr := fmt.Sprintf("%s://%s:%s%s", wsProto, serverHost, wsPort, wsPath)
 
 	if cfg.EncryptConnection {
 		dialCfg, err := websocket.NewConfig(wsaddr, origin)
@@ -230,7 +265,8 @@ func (bot *ClientBot) loop() {
 							player.pos.Z += entity.Coord(-moveRange + moveRange*rand.Float32())
 							//gwlog.Infof("move to %f, %f", player.pos.X, player.pos.Z)
 							player.yaw = entity.Yaw(rand.Float32() * 3.14)
-							bot.conn.SendSyncPositionYawFromClient(player.ID, float32(player.pos.X), float32(player.pos.Y), float32(player.pos.Z), float32(player.yaw))
+							bot.inputSeq += 1
+							bot.conn.SendSyncPositionYawWithSeqFromClient(player.ID, bot.inputSeq, float32(player.pos.X), float32(player.pos.Y), float32(player.pos.Z), float32(player.yaw))
 						}
 
 						bot.syncPosTime = now
@@ -311,6 +347,14 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType, packet *netutil.Packet
 		packet.ReadData(&path)
 		//gwlog.Infof("Entity %s Attribute %v: pop", entityID, path)
 		bot.applyListAttrPop(entityID, path)
+	} else if msgtype == proto.MT_NOTIFY_ATTR_PATCH_ON_CLIENT {
+		entityID := packet.ReadEntityID()
+		patch, err := proto.DecodeAttrPatch(packet.UnreadPayload())
+		if err != nil {
+			Errorf("%s: %s", bot, err)
+			return
+		}
+		bot.applyAttrPatch(entityID, patch)
 	} else if msgtype == proto.MT_CREATE_ENTITY_ON_CLIENT {
 		isPlayer := packet.ReadBool()
 		entityID := packet.ReadEntityID()
@@ -363,6 +407,7 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType, packet *netutil.Packet
 
 		bot.callEntityMethod(bot.player.ID, method, args)
 	} else if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS {
+		bot.readSyncHeader(packet)
 		for packet.HasUnreadPayload() {
 			entityID := packet.ReadEntityID()
 			x := entity.Coord(packet.ReadFloat32())
@@ -372,6 +417,45 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType, packet *netutil.Packet
 			bot.updateEntityPosition(entityID, entity.Vector3{x, y, z})
 			bot.updateEntityYaw(entityID, yaw)
 		}
+	} else if msgtype == proto.MT_SYNC_QUANTIZED_POSITION_YAW_ON_CLIENTS {
+		bot.readSyncHeader(packet)
+		origin, precision := proto.ReadQuantizedSyncOrigin(packet)
+		for packet.HasUnreadPayload() {
+			entityID := packet.ReadEntityID()
+			info := proto.ReadQuantizedSyncInfo(packet, origin, precision)
+			bot.updateEntityPosition(entityID, entity.Vector3{X: entity.Coord(info.X), Y: entity.Coord(info.Y), Z: entity.Coord(info.Z)})
+			bot.updateEntityYaw(entityID, entity.Yaw(info.Yaw))
+		}
+	} else if msgtype == proto.MT_SYNC_INPUT_ACK_ON_CLIENTS {
+		bot.readSyncHeader(packet)
+		for packet.HasUnreadPayload() {
+			entityID := packet.ReadEntityID()
+			seq := packet.ReadUint32()
+			x := entity.Coord(packet.ReadFloat32())
+			y := entity.Coord(packet.ReadFloat32())
+			z := entity.Coord(packet.ReadFloat32())
+			yaw := entity.Yaw(packet.ReadFloat32())
+			// the bot does not predict, so inputs after the acknowledged one are not replayed
+			bot.ackedInputSeq = seq
+			gwlog.Debugf("%s: input %d of %s acknowledged at (%v, %v, %v), yaw %v, %d inputs pending", bot, seq, entityID, x, y, z, yaw, bot.inputSeq-seq)
+		}
+	} else if msgtype == proto.MT_RECONNECT_DIRECTIVE {
+		delay := time.Millisecond * time.Duration(packet.ReadUint32())
+		gwlog.Warnf("%s: gate is disconnecting, reconnect after %s", bot, delay)
+	} else if msgtype == proto.MT_SESSION_TOKEN_CHALLENGE {
+		challenge := packet.ReadVarStr()
+		expireIn := time.Second * time.Duration(packet.ReadUint32())
+		gwlog.Debugf("%s: session token expires in %s", bot, expireIn)
+		// test clients have no auth service, so they sign session tokens by themselves
+		bot.conn.SendSessionTokenResponseFromClient(proto.SignSessionToken(bot.sessionSecret, challenge, time.Now().Add(time.Hour)))
+	} else if msgtype == proto.MT_RESUME_SESSION_ACK {
+		// all entities are created again if the session is resumed
+		gwlog.Infof("%s: resume session: %v", bot, packet.ReadBool())
+	} else if msgtype == proto.MT_SET_CLIENT_PROTOCOL_VERSION {
+		gwlog.Debugf("%s: client protocol version %d is selected by gate", bot, packet.ReadUint16())
+	} else if msgtype == proto.MT_CLIENT_PROTOCOL_VERSION_REJECTED {
+		clientVersion, minVersion, maxVersion := packet.ReadUint16(), packet.ReadUint16(), packet.ReadUint16()
+		gwlog.Errorf("%s: client protocol version %d is rejected, supported versions: %d ~ %d, update at %s", bot, clientVersion, minVersion, maxVersion, packet.ReadVarStr())
 		//} else if msgtype == proto.MT_SET_CLIENT_CLIENTID {
 		//	clientid := packet.ReadClientID()
 		//	bot.setClientID(clientid)
@@ -380,6 +464,15 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType, packet *netutil.Packet
 	}
 }
 
+// readSyncHeader reads server tick & server time of sync packet
+//
+// The bot does not render, so positions are updated directly instead of being interpolated
+func (bot *ClientBot) readSyncHeader(packet *netutil.Packet) {
+	_ = packet.ReadUint32() // server tick
+	serverTime := packet.ReadUint64()
+	bot.serverClock.Observe(int64(serverTime), time.Now())
+}
+
 func (bot *ClientBot) updateEntityPosition(entityID common.EntityID, position entity.Vector3) {
 	//gwlog.Debugf("updateEntityPosition %s => %s", entityID, position)
 	if bot.entities[entityID] == nil {
@@ -410,6 +503,25 @@ func (bot *ClientBot) applyMapAttrChange(entityID common.EntityID, path []interf
 	entity.applyMapAttrChange(path, key, val)
 }
 
+func (bot *ClientBot) applyAttrPatch(entityID common.EntityID, patch *proto.AttrPatch) {
+	for _, op := range patch.Ops {
+		switch op.Op {
+		case proto.ATTR_PATCH_MAP_CHANGE:
+			bot.applyMapAttrChange(entityID, op.Path, op.Key, op.Val)
+		case proto.ATTR_PATCH_MAP_DEL:
+			bot.applyMapAttrDel(entityID, op.Path, op.Key)
+		case proto.ATTR_PATCH_MAP_CLEAR:
+			bot.applyMapAttrClear(entityID, op.Path)
+		case proto.ATTR_PATCH_LIST_CHANGE:
+			bot.applyListAttrChange(entityID, op.Path, int(op.Index), op.Val)
+		case proto.ATTR_PATCH_LIST_POP:
+			bot.applyListAttrPop(entityID, op.Path)
+		case proto.ATTR_PATCH_LIST_APPEND:
+			bot.applyListAttrAppend(entityID, op.Path, op.Val)
+		}
+	}
+}
+
 func (bot *ClientBot) applyMapAttrDel(entityID common.EntityID, path []interface{}, key string) {
 	//gwlog.Infof("DEL ATTR %s.%v: del %s", entityID, path, key)
 	if bot.entities[entityID] == nil {
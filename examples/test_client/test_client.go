@@ -19,6 +19,7 @@ import (
 )
 
 var (
+	conf          = config.Default()
 	quiet         bool
 	configFile    string
 	serverHost    string
@@ -51,10 +52,10 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 	parseArgs()
 	if configFile != "" {
-		config.SetConfigFile(configFile)
+		conf.SetConfigFile(configFile)
 	}
 
-	binutil.SetupGWLog("test_client", loglevel, "test_client.log", true)
+	binutil.SetupGWLog("test_client", loglevel, "test_client.log", true, "console", gwlog.Rotation{})
 	binutil.SetupHTTPServer("localhost:18888", nil)
 	if useWebSocket && useKCP {
 		gwlog.Errorf("Can not use both websocket and KCP")
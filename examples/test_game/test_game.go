@@ -32,6 +32,8 @@ func main() {
 	goworld.RegisterService("SpaceService", &SpaceService{}, 3)
 	// todo: implement sharding for MailService. Currently, MailService only allows 1 shard
 	goworld.RegisterService("MailService", &MailService{}, 1)
+	// MailService keeps its state in KVDB, so a standby game can take over immediately when the game is down
+	goworld.EnableServiceStandby("MailService", time.Second*5)
 
 	pubsub.RegisterService(3)
 
@@ -0,0 +1,234 @@
+// Package accountshard maps accounts to per-account singleton entities
+//
+// Games commonly spawn one entity per account (e.g. an Account or Player entity) and need to find it by account name:
+// create it on first use, load it when it is not in memory and unload it when it is idle. AccountShardService does
+// these for registered entity types, calls are routed by:
+//
+//	accountshard.Call("Player", "alice", "AddGold", 100)
+//
+// The account entity is created on first call, loaded on a game located by consistent hashing of the account, and
+// hibernated (saved & destroyed) after not being called through Call for a while. Reloading on the same game makes sure
+// the entity is loaded after its last save is written by the game.
+package accountshard
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	ServiceName = "AccountShardService"
+
+	_KVDB_KEY_PREFIX     = "accountshard/"
+	_IDLE_CHECK_INTERVAL = time.Second * 10
+	_HIBERNATE_TIMEOUT   = time.Second * 30 // the entity is considered hibernated if it does not respond in time
+)
+
+var (
+	hibernateAfter = map[string]time.Duration{}
+	onlineGames    = goworld.GetOnlineGames // replaced by tests which run without the game service
+)
+
+// AccountEntity should be embedded by account entity types instead of entity.Entity
+//
+// The entity is not hibernated if it has a client, or if it implements CanHibernate() bool which returns false.
+type AccountEntity struct {
+	entity.Entity
+}
+
+// Register registers the account entity type, which is hibernated after not being called for idle (never if 0)
+//
+// Account entity types should be registered on all games, and the entity type should be persistent.
+func Register(typeName string, entityPtr entity.IEntity, idle time.Duration) *entity.EntityTypeDesc {
+	hibernateAfter[typeName] = idle
+	return goworld.RegisterEntity(typeName, entityPtr)
+}
+
+// RegisterService registers AccountShardService to goworld
+func RegisterService(shardCount int) {
+	goworld.RegisterService(ServiceName, &AccountShardService{}, shardCount)
+}
+
+// Call calls the method of the account entity, the entity is created or loaded if necessary
+func Call(typeName string, account string, method string, args ...interface{}) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	goworld.CallServiceShardKey(ServiceName, accountKey(typeName, account), "Route", typeName, account, method, args)
+}
+
+// HibernateAccountEntity is called by AccountShardService when the entity is idle
+func (a *AccountEntity) HibernateAccountEntity(typeName string, account string) {
+	hibernated := false
+	if canHibernate, ok := a.I.(interface{ CanHibernate() bool }); a.GetClient() == nil && (!ok || canHibernate.CanHibernate()) {
+		gwlog.Infof("accountshard: %s of account %s is hibernating ...", a, account)
+		a.Destroy()
+		hibernated = true
+	}
+	goworld.CallServiceShardKey(ServiceName, accountKey(typeName, account), "OnHibernated", typeName, account, hibernated)
+}
+
+// AccountShardService routes calls to account entities
+type AccountShardService struct {
+	entity.Entity
+	accounts map[string]*accountEntry
+}
+
+type pendingCall struct {
+	method string
+	args   []interface{}
+}
+
+type accountEntry struct {
+	entityID       common.EntityID // empty while loading the entity ID from KVDB
+	gameid         uint16          // game of the entity, 0 if the entity is not awake
+	hibernateStart time.Time       // zero if the entity is not hibernating
+	lastAccess     time.Time
+	pending        []pendingCall
+}
+
+func (ass *AccountShardService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initializes AccountShardService fields
+func (ass *AccountShardService) OnInit() {
+	ass.accounts = map[string]*accountEntry{}
+}
+
+// OnCreated is called when AccountShardService is created
+func (ass *AccountShardService) OnCreated() {
+	gwlog.Infof("Registering AccountShardService ...")
+	ass.AddTimer(_IDLE_CHECK_INTERVAL, "CheckIdle")
+}
+
+// Route calls the method of the account entity, see accountshard.Call
+func (ass *AccountShardService) Route(typeName string, account string, method string, args []interface{}) {
+	key := accountKey(typeName, account)
+	ae := ass.accounts[key]
+	if ae == nil {
+		ae = &accountEntry{}
+		ass.accounts[key] = ae
+		ass.resolve(typeName, account, ae)
+	}
+
+	ae.lastAccess = time.Now()
+	ae.pending = append(ae.pending, pendingCall{method, args})
+	ass.flush(typeName, account, ae)
+}
+
+// resolve gets the entity ID of the account from KVDB, or creates the entity if the account is new
+func (ass *AccountShardService) resolve(typeName string, account string, ae *accountEntry) {
+	key := accountKey(typeName, account)
+	newID := common.GenEntityID()
+	goworld.GetOrPutKVDB(_KVDB_KEY_PREFIX+key, string(newID), func(oldVal string, err error) {
+		if ass.accounts[key] != ae {
+			return
+		}
+
+		if err != nil {
+			gwlog.Errorf("%s: resolve account %s failed, %d calls are dropped: %s", ass, key, len(ae.pending), err)
+			delete(ass.accounts, key)
+			return
+		}
+
+		if oldVal != "" {
+			ae.entityID = common.EntityID(oldVal)
+		} else {
+			ae.entityID = newID
+			ae.gameid = chooseGame(key)
+			gwlog.Infof("%s: creating %s %s for account %s on game%d ...", ass, typeName, newID, account, ae.gameid)
+			entity.CreateEntitySomewhereWithID(ae.gameid, typeName, newID)
+		}
+		ass.flush(typeName, account, ae)
+	})
+}
+
+// flush sends pending calls to the entity, and loads the entity if it is not awake
+func (ass *AccountShardService) flush(typeName string, account string, ae *accountEntry) {
+	if ae.entityID == "" || !ae.hibernateStart.IsZero() || len(ae.pending) == 0 {
+		return
+	}
+
+	if ae.gameid == 0 || !onlineGames().Contains(ae.gameid) {
+		ae.gameid = chooseGame(accountKey(typeName, account))
+	}
+	// loading is ignored by dispatcher if the entity is already loaded
+	goworld.LoadEntityOnGame(typeName, ae.entityID, ae.gameid)
+	for _, call := range ae.pending {
+		goworld.Call(ae.entityID, call.method, call.args...)
+	}
+	ae.pending = nil
+}
+
+// CheckIdle hibernates account entities which are not called for a while
+func (ass *AccountShardService) CheckIdle() {
+	now := time.Now()
+	for key, ae := range ass.accounts {
+		typeName, account := splitAccountKey(key)
+		if !ae.hibernateStart.IsZero() {
+			if now.Sub(ae.hibernateStart) > _HIBERNATE_TIMEOUT {
+				gwlog.Warnf("%s: %s %s of account %s does not respond to hibernation", ass, typeName, ae.entityID, account)
+				ass.OnHibernated(typeName, account, true)
+			}
+			continue
+		}
+
+		idle := hibernateAfter[typeName]
+		if ae.entityID == "" || idle <= 0 || now.Sub(ae.lastAccess) < idle {
+			continue
+		}
+		ae.hibernateStart = now
+		goworld.Call(ae.entityID, "HibernateAccountEntity", typeName, account)
+	}
+}
+
+// OnHibernated is called by the account entity when it is hibernated or refuses to hibernate
+func (ass *AccountShardService) OnHibernated(typeName string, account string, hibernated bool) {
+	key := accountKey(typeName, account)
+	ae := ass.accounts[key]
+	if ae == nil || ae.hibernateStart.IsZero() {
+		return
+	}
+
+	ae.hibernateStart = time.Time{}
+	if !hibernated {
+		ae.lastAccess = time.Now()
+		ass.flush(typeName, account, ae)
+		return
+	}
+
+	ae.gameid = 0
+	if len(ae.pending) > 0 {
+		ass.flush(typeName, account, ae) // called during hibernation, wake up the entity
+	} else {
+		delete(ass.accounts, key)
+	}
+}
+
+// chooseGame chooses the game of the key using rendezvous hashing, so that only keys of the joined or left game are
+// moved when games change
+func chooseGame(key string) (gameid uint16) {
+	var maxScore uint32
+	for id := range onlineGames() {
+		score := common.HashString(key + "@" + strconv.Itoa(int(id)))
+		if gameid == 0 || score > maxScore || (score == maxScore && id < gameid) {
+			gameid, maxScore = id, score
+		}
+	}
+	return
+}
+
+func accountKey(typeName string, account string) string {
+	return typeName + "/" + account
+}
+
+func splitAccountKey(key string) (typeName string, account string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
+}
@@ -0,0 +1,111 @@
+package accountshard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	gwtesting "github.com/xiaonanln/goworld/testing"
+)
+
+func init() {
+	engine := kvdbtest.NewMemoryEngine()
+	kvdb.Register("accountshardtest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return engine, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "accountshardtest")
+	kvdb.Initialize(conf)
+
+	onlineGames = func() common.Uint16Set {
+		return common.Uint16Set{gwtesting.GameID: {}}
+	}
+}
+
+type testAccount struct {
+	AccountEntity
+	calls int
+}
+
+func (a *testAccount) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (a *testAccount) AddCall() {
+	a.calls += 1
+}
+
+func newTestCluster() *gwtesting.Cluster {
+	c := gwtesting.New()
+	c.RegisterEntity(ServiceName, &AccountShardService{})
+	c.RegisterEntity("testAccount", &testAccount{})
+	return c
+}
+
+// accountEntities returns account entities in the cluster
+func accountEntities() (accounts []*testAccount) {
+	for _, e := range entity.Entities() {
+		if a, ok := e.I.(*testAccount); ok {
+			accounts = append(accounts, a)
+		}
+	}
+	return
+}
+
+// waitCalls delivers packets until account entities are called n times in total
+func waitCalls(t *testing.T, c *gwtesting.Cluster, n int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		calls := 0
+		for _, a := range accountEntities() {
+			calls += a.calls
+		}
+		if calls == n {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("account entities are called %d times, expected %d", calls, n)
+		}
+		c.Deliver()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrentCreation(t *testing.T) {
+	c := newTestCluster()
+	ass := c.CreateEntity(ServiceName, nil).I.(*AccountShardService)
+	account := string(common.GenEntityID())
+
+	// calls of the new account before its entity is created
+	for i := 0; i < 3; i++ {
+		ass.Route("testAccount", account, "AddCall", []interface{}{})
+	}
+	waitCalls(t, c, 3)
+	if accounts := accountEntities(); len(accounts) != 1 {
+		t.Fatalf("one entity should be created for the account, but %d are created", len(accounts))
+	}
+}
+
+func TestConcurrentCreationOnShards(t *testing.T) {
+	c := newTestCluster()
+	ass1 := c.CreateEntity(ServiceName, nil).I.(*AccountShardService)
+	ass2 := c.CreateEntity(ServiceName, nil).I.(*AccountShardService)
+	account := string(common.GenEntityID())
+
+	// the account is routed to different shards while shards are changing
+	ass1.Route("testAccount", account, "AddCall", []interface{}{})
+	ass2.Route("testAccount", account, "AddCall", []interface{}{})
+	waitCalls(t, c, 2)
+	accounts := accountEntities()
+	if len(accounts) != 1 {
+		t.Fatalf("one entity should be created for the account, but %d are created", len(accounts))
+	}
+
+	key := accountKey("testAccount", account)
+	if ass1.accounts[key].entityID != accounts[0].ID || ass2.accounts[key].entityID != accounts[0].ID {
+		t.Fatalf("shards should route the account to the same entity")
+	}
+}
@@ -0,0 +1,103 @@
+package bulkjob
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// RegisterAdminAPI registers HTTP handlers for managing jobs to the HTTP server of the game
+//
+// Requests are authenticated like the admin API, starting, pausing and resetting jobs require the gm role.
+//
+//	GET  /bulkjob                    responds with progresses of all registered jobs
+//	GET  /bulkjob?name=<job>         responds with the progress of the job
+//	POST /bulkjob/start?name=<job>   starts the job, or resumes the paused job
+//	POST /bulkjob/pause?name=<job>   pauses the running job
+//	POST /bulkjob/reset?name=<job>   resets the progress of the job which is not running
+func RegisterAdminAPI() {
+	http.HandleFunc("/bulkjob", binutil.RequireAdmin(binutil.AdminReadOnly, handleGetProgress))
+	http.HandleFunc("/bulkjob/start", binutil.RequireAdmin(binutil.AdminGM, serviceHandler("Start")))
+	http.HandleFunc("/bulkjob/pause", binutil.RequireAdmin(binutil.AdminGM, serviceHandler("Pause")))
+	http.HandleFunc("/bulkjob/reset", binutil.RequireAdmin(binutil.AdminGM, serviceHandler("Reset")))
+}
+
+func handleGetProgress(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if name := r.URL.Query().Get("name"); name != "" {
+		if jobs[name] == nil {
+			http.Error(w, "job "+name+" is not registered", http.StatusNotFound)
+			return
+		}
+		names = []string{name}
+	} else {
+		for name := range jobs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	type result struct {
+		progress *Progress
+		err      error
+	}
+	done := make(chan result, len(names))
+	post.Post(func() {
+		for _, name := range names {
+			loadProgress(name, func(progress *Progress, err error) {
+				done <- result{progress, err}
+			})
+		}
+	})
+
+	progresses := make([]*Progress, 0, len(names))
+	for range names {
+		res := <-done
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusInternalServerError)
+			return
+		}
+		progresses = append(progresses, res.progress)
+	}
+	sort.Slice(progresses, func(i, j int) bool {
+		return progresses[i].Job < progresses[j].Job
+	})
+	writeJSON(w, progresses)
+}
+
+// serviceHandler calls the method of BulkJobService with the job name
+func serviceHandler(method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("name")
+		if jobs[name] == nil {
+			http.Error(w, "job "+name+" is not registered", http.StatusNotFound)
+			return
+		}
+
+		done := make(chan error, 1)
+		post.Post(func() {
+			goworld.CallServiceWithResult(ServiceName, method, _SERVICE_CALL_TIMEOUT, func(results []interface{}, err error) {
+				done <- err
+			}, name)
+		})
+		if err := <-done; err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, map[string]string{"job": name, "action": method})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,372 @@
+// Package bulkjob runs administrative jobs over all persisted entities of a type, e.g. compensation grants or
+// recomputing stats
+//
+// Jobs are registered on all games and run by BulkJobService at a limited rate against storage:
+//
+//	bulkjob.Register(bulkjob.Job{
+//		Name:     "compensation-2026-10",
+//		TypeName: "Player",
+//		Offline:  func(id common.EntityID, data map[string]interface{}) (bool, error) { ... },
+//		Live:     func(e *entity.Entity) error { ... },
+//	})
+//
+// Jobs coexist with live loads: each entity is first called through RunBulkJob of JobEntity, which runs Live on the
+// loaded entity; entities which are not loaded are loaded from storage, changed by Offline and saved by fenced saves.
+// Since the entity might be loaded while its data is changed offline, it is called again after saving, so Offline and
+// Live should be idempotent, e.g. by recording the job in the data of the entity.
+//
+// Progress is checkpointed to KVDB, so jobs are resumed after BulkJobService is restarted, and reported by the admin API
+// (see RegisterAdminAPI).
+package bulkjob
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/typeconv"
+)
+
+const (
+	ServiceName = "BulkJobService"
+
+	// StateIdle means the job is never started or is reset
+	StateIdle = ""
+	// StateRunning means the job is running
+	StateRunning = "running"
+	// StatePaused means the job is paused, and is resumed from the checkpoint when started
+	StatePaused = "paused"
+	// StateFinished means all entities are processed, the job should be reset before it is started again
+	StateFinished = "finished"
+
+	_DEFAULT_RATE         = 100
+	_DEFAULT_CONCURRENCY  = 10
+	_MAX_ERRORS           = 10
+	_TICK_INTERVAL        = time.Millisecond * 100
+	_CHECKPOINT_INTERVAL  = time.Second
+	_LIVE_CALL_TIMEOUT    = time.Second * 10
+	_PROGRESS_KEY_PREFIX  = "bulkjob/progress/"
+	_RUN_BULK_JOB_METHOD  = "RunBulkJob"
+	_SERVICE_CALL_TIMEOUT = time.Second * 10
+	_CHECKPOINT_TICKS     = int(_CHECKPOINT_INTERVAL / _TICK_INTERVAL)
+)
+
+// Job is an administrative job over all persisted entities of a type
+type Job struct {
+	Name        string
+	TypeName    string
+	Offline     func(entityID common.EntityID, data map[string]interface{}) (changed bool, err error) // changes saved data of the entity which is not loaded
+	Live        func(e *entity.Entity) error                                                          // changes the loaded entity
+	Rate        int                                                                                   // max entities processed per second, 100 by default
+	Concurrency int                                                                                   // max entities processed at the same time, 10 by default
+}
+
+// Progress is the progress of the job, which is checkpointed to KVDB
+type Progress struct {
+	Job        string          `json:"job"`
+	State      string          `json:"state"`
+	Total      int             `json:"total"` // entities to process, counted when the job is started or resumed
+	Processed  int             `json:"processed"`
+	Live       int             `json:"live"`      // processed by Live on loaded entities
+	Offline    int             `json:"offline"`   // processed by Offline on saved data
+	Unchanged  int             `json:"unchanged"` // saved data not changed by Offline, or not found
+	Failed     int             `json:"failed"`
+	Errors     []string        `json:"errors,omitempty"` // last errors
+	Checkpoint common.EntityID `json:"checkpoint"`       // entities with IDs up to the checkpoint are processed
+	Started    int64           `json:"started"`          // unix seconds when the job is started
+	Updated    int64           `json:"updated"`          // unix seconds when the progress is checkpointed
+}
+
+var (
+	jobs = map[string]*Job{}
+)
+
+// Register registers the job, jobs should be registered on all games before the game is started
+func Register(job Job) {
+	if job.Name == "" || job.TypeName == "" || job.Offline == nil || job.Live == nil {
+		gwlog.Panicf("bulkjob: invalid job %q", job.Name)
+	} else if _, ok := jobs[job.Name]; ok {
+		gwlog.Panicf("bulkjob: job %s is already registered", job.Name)
+	}
+
+	if job.Rate <= 0 {
+		job.Rate = _DEFAULT_RATE
+	}
+	if job.Concurrency <= 0 {
+		job.Concurrency = _DEFAULT_CONCURRENCY
+	}
+	jobs[job.Name] = &job
+}
+
+// RegisterService registers BulkJobService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &BulkJobService{}, 1)
+}
+
+// JobEntity should be embedded by entity types of jobs instead of entity.Entity, so that jobs run on loaded entities
+type JobEntity struct {
+	entity.Entity
+}
+
+// RunBulkJob is called by BulkJobService to run the job on the loaded entity
+func (e *JobEntity) RunBulkJob(name string) error {
+	job := jobs[name]
+	if job == nil {
+		return errors.Errorf("job %s is not registered", name)
+	}
+	return job.Live(&e.Entity)
+}
+
+// BulkJobService runs registered jobs
+type BulkJobService struct {
+	entity.Entity
+	running map[string]*runningJob
+	ticks   int
+}
+
+// runningJob is a running job with entities to process in order of IDs
+type runningJob struct {
+	job      *Job
+	progress *Progress
+	ids      []common.EntityID
+	done     []bool
+	next     int // index of the next entity to process
+	base     int // index of the first entity not processed
+	inflight int
+	budget   float64
+	dirty    bool
+}
+
+func (bs *BulkJobService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initializes BulkJobService fields
+func (bs *BulkJobService) OnInit() {
+	bs.running = map[string]*runningJob{}
+}
+
+// OnCreated resumes running jobs, which are interrupted by restarting the service
+func (bs *BulkJobService) OnCreated() {
+	gwlog.Infof("Registering BulkJobService ...")
+	bs.AddTimer(_TICK_INTERVAL, "Tick")
+	for name := range jobs {
+		job := jobs[name]
+		loadProgress(name, func(progress *Progress, err error) {
+			if err != nil {
+				gwlog.Errorf("%s: load progress of job %s failed: %s", bs, name, err)
+			} else if progress.State == StateRunning && bs.running[name] == nil {
+				gwlog.Infof("%s: resuming job %s from %s ...", bs, name, progress.Checkpoint)
+				bs.run(job, progress)
+			}
+		})
+	}
+}
+
+// Start starts the job, or resumes the paused job from its checkpoint
+func (bs *BulkJobService) Start(name string) error {
+	job := jobs[name]
+	if job == nil {
+		return errors.Errorf("job %s is not registered", name)
+	} else if bs.running[name] != nil {
+		return errors.Errorf("job %s is already running", name)
+	}
+
+	loadProgress(name, func(progress *Progress, err error) {
+		if err != nil {
+			gwlog.Errorf("%s: load progress of job %s failed: %s", bs, name, err)
+			return
+		} else if progress.State == StateFinished || bs.running[name] != nil {
+			gwlog.Warnf("%s: job %s is %s, not started", bs, name, progress.State)
+			return
+		}
+		if progress.State == StateIdle {
+			progress.Started = time.Now().Unix()
+		}
+		gwlog.Infof("%s: starting job %s ...", bs, name)
+		bs.run(job, progress)
+	})
+	return nil
+}
+
+// Pause pauses the running job, entities being processed are finished before the progress is checkpointed
+func (bs *BulkJobService) Pause(name string) error {
+	rj := bs.running[name]
+	if rj == nil {
+		return errors.Errorf("job %s is not running", name)
+	}
+	rj.progress.State = StatePaused
+	rj.dirty = true
+	gwlog.Infof("%s: pausing job %s ...", bs, name)
+	return nil
+}
+
+// Reset clears the progress of the job which is not running, so that it can be started over
+func (bs *BulkJobService) Reset(name string) error {
+	if jobs[name] == nil {
+		return errors.Errorf("job %s is not registered", name)
+	} else if bs.running[name] != nil {
+		return errors.Errorf("job %s is running", name)
+	}
+	saveProgress(&Progress{Job: name})
+	gwlog.Infof("%s: job %s is reset", bs, name)
+	return nil
+}
+
+func (bs *BulkJobService) run(job *Job, progress *Progress) {
+	progress.State = StateRunning
+	rj := &runningJob{job: job, progress: progress, dirty: true}
+	bs.running[job.Name] = rj
+
+	storage.ListEntityIDs(job.TypeName, func(ids []common.EntityID, err error) {
+		if err != nil {
+			gwlog.Errorf("%s: list entities of job %s failed: %s", bs, job.Name, err)
+			progress.State = StatePaused
+			ids = nil
+		}
+
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i] < ids[j]
+		})
+		skip := sort.Search(len(ids), func(i int) bool {
+			return ids[i] > progress.Checkpoint
+		})
+		rj.ids = ids[skip:]
+		rj.done = make([]bool, len(rj.ids))
+		progress.Total = progress.Processed + len(rj.ids)
+	})
+}
+
+// Tick processes entities of running jobs within rate limits, and checkpoints progresses
+func (bs *BulkJobService) Tick() {
+	bs.ticks += 1
+	checkpoint := bs.ticks%_CHECKPOINT_TICKS == 0
+	for name, rj := range bs.running {
+		if rj.done == nil {
+			continue // listing entities
+		}
+
+		if rj.progress.State == StateRunning {
+			rj.budget += float64(rj.job.Rate) * _TICK_INTERVAL.Seconds()
+			if max := float64(rj.job.Concurrency); rj.budget > max {
+				rj.budget = max
+			}
+			for rj.budget >= 1 && rj.inflight < rj.job.Concurrency && rj.next < len(rj.ids) {
+				rj.budget -= 1
+				rj.inflight += 1
+				bs.process(rj, rj.next, false)
+				rj.next += 1
+			}
+			if rj.base == len(rj.ids) {
+				rj.progress.State = StateFinished
+				gwlog.Infof("%s: job %s is finished: %d processed, %d failed", bs, name, rj.progress.Processed, rj.progress.Failed)
+			}
+		}
+
+		if rj.progress.State != StateRunning && rj.inflight == 0 {
+			delete(bs.running, name)
+			saveProgress(rj.progress)
+		} else if checkpoint && rj.dirty {
+			rj.dirty = false
+			saveProgress(rj.progress)
+		}
+	}
+}
+
+// process runs the job on the entity if it is loaded, or on its saved data if not
+func (bs *BulkJobService) process(rj *runningJob, index int, savedOffline bool) {
+	id := rj.ids[index]
+	bs.CallWithResult(id, _RUN_BULK_JOB_METHOD, _LIVE_CALL_TIMEOUT, func(results []interface{}, err error) {
+		if err == nil {
+			rj.finish(index, &rj.progress.Live, nil)
+		} else if rpcErr, ok := err.(*entity.RPCError); !ok || rpcErr.Code != proto.RPC_ERROR_ENTITY_NOT_FOUND {
+			rj.finish(index, &rj.progress.Failed, errors.Wrapf(err, "run on %s failed", id))
+		} else if savedOffline {
+			rj.finish(index, &rj.progress.Offline, nil)
+		} else {
+			bs.processOffline(rj, index)
+		}
+	}, rj.job.Name)
+}
+
+func (bs *BulkJobService) processOffline(rj *runningJob, index int) {
+	id := rj.ids[index]
+	goworld.LoadEntityDataAsync(rj.job.TypeName, id, func(data interface{}, err error) {
+		if err != nil {
+			rj.finish(index, &rj.progress.Failed, errors.Wrapf(err, "load %s failed", id))
+			return
+		} else if data == nil {
+			rj.finish(index, &rj.progress.Unchanged, nil) // deleted
+			return
+		}
+
+		entityData := typeconv.MapStringAnything(data)
+		changed, err := rj.job.Offline(id, entityData)
+		if err != nil {
+			rj.finish(index, &rj.progress.Failed, errors.Wrapf(err, "run on saved data of %s failed", id))
+			return
+		} else if !changed {
+			rj.finish(index, &rj.progress.Unchanged, nil)
+			return
+		}
+
+		storage.SaveFenced(rj.job.TypeName, id, entityData, storage.GetOwnerEpoch(entityData), func(err error) {
+			if errors.Cause(err) == storage.ErrStaleOwnerEpoch {
+				bs.process(rj, index, false) // saved by the entity loaded meanwhile, run on the loaded entity
+			} else if err != nil {
+				rj.finish(index, &rj.progress.Failed, errors.Wrapf(err, "save %s failed", id))
+			} else {
+				bs.process(rj, index, true) // run again if the entity is loaded before the data is saved
+			}
+		})
+	})
+}
+
+// finish counts the processed entity and advances the checkpoint
+func (rj *runningJob) finish(index int, counter *int, err error) {
+	progress := rj.progress
+	*counter += 1
+	progress.Processed += 1
+	if err != nil {
+		gwlog.Errorf("bulkjob: job %s: %s", rj.job.Name, err)
+		if progress.Errors = append(progress.Errors, err.Error()); len(progress.Errors) > _MAX_ERRORS {
+			progress.Errors = progress.Errors[1:]
+		}
+	}
+
+	rj.inflight -= 1
+	rj.done[index] = true
+	for rj.base < rj.next && rj.done[rj.base] {
+		progress.Checkpoint = rj.ids[rj.base]
+		rj.base += 1
+	}
+	progress.Updated = time.Now().Unix()
+	rj.dirty = true
+}
+
+func saveProgress(progress *Progress) {
+	progress.Updated = time.Now().Unix()
+	data, _ := json.Marshal(progress)
+	goworld.PutKVDB(_PROGRESS_KEY_PREFIX+progress.Job, string(data), func(err error) {
+		if err != nil {
+			gwlog.Errorf("bulkjob: save progress of job %s failed: %s", progress.Job, err)
+		}
+	})
+}
+
+// loadProgress loads the progress of the job from KVDB, the progress is idle if the job is never started
+func loadProgress(name string, callback func(progress *Progress, err error)) {
+	goworld.GetKVDB(_PROGRESS_KEY_PREFIX+name, func(val string, err error) {
+		progress := &Progress{Job: name}
+		if err == nil && val != "" {
+			err = json.Unmarshal([]byte(val), progress)
+		}
+		callback(progress, err)
+	})
+}
@@ -0,0 +1,224 @@
+package bulkjob
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+var (
+	testKVDB   = kvdbtest.NewMemoryEngine()
+	storageDir string
+	testJobs   = 0
+	liveRuns   = map[common.EntityID]int{} // runs of jobs on each loaded entity
+)
+
+func init() {
+	var err error
+	storageDir, err = ioutil.TempDir("", "goworld_bulkjob")
+	if err != nil {
+		panic(err)
+	}
+
+	kvdb.Register("bulkjobtest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return testKVDB, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "bulkjobtest")
+	conf.SetOverride("storage", "type", "filesystem")
+	conf.SetOverride("storage", "directory", storageDir)
+	kvdb.Initialize(conf)
+	storage.Initialize(conf)
+
+	entity.RegisterEntity(ServiceName, &BulkJobService{}, false)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	os.RemoveAll(storageDir)
+	os.Exit(code)
+}
+
+type testJobEntity struct {
+	JobEntity
+}
+
+func (e *testJobEntity) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+// registerJob registers a job of a new entity type, so that tests do not see entities and progresses of previous runs
+func registerJob() string {
+	testJobs += 1
+	name := fmt.Sprintf("TestJobEntity%d", testJobs)
+	entity.RegisterEntity(name, &testJobEntity{}, false)
+	Register(Job{
+		Name:     name,
+		TypeName: name,
+		Offline: func(id common.EntityID, data map[string]interface{}) (bool, error) {
+			return false, nil
+		},
+		Live: func(e *entity.Entity) error {
+			liveRuns[e.ID] += 1
+			return nil
+		},
+		Rate: 20,
+	})
+	return name
+}
+
+// wait ticks the game routine until done
+func wait(t *testing.T, done func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("callback is not called in time")
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// createEntities saves and loads n entities of the type, and returns their IDs in order
+func createEntities(t *testing.T, typeName string, n int) []common.EntityID {
+	var ids []common.EntityID
+	saved := 0
+	for i := 0; i < n; i++ {
+		id := common.GenEntityID()
+		ids = append(ids, id)
+		storage.Save(typeName, id, map[string]interface{}{}, func() {
+			saved += 1
+		})
+		entity.CreateEntityLocallyWithID(typeName, nil, id)
+	}
+	wait(t, func() bool { return saved == n })
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func getProgress(t *testing.T, name string) (progress *Progress) {
+	loadProgress(name, func(p *Progress, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		progress = p
+	})
+	wait(t, func() bool { return progress != nil })
+	return
+}
+
+func newTestService() *BulkJobService {
+	return entity.CreateEntityLocally(ServiceName, nil).I.(*BulkJobService)
+}
+
+// tickUntilFinished ticks the service until the job is finished, and returns the progress saved in KVDB
+func tickUntilFinished(t *testing.T, bs *BulkJobService, name string) *Progress {
+	wait(t, func() bool {
+		bs.Tick()
+		return bs.running[name] == nil
+	})
+	var progress *Progress
+	wait(t, func() bool {
+		progress = getProgress(t, name)
+		return progress.State != StateRunning
+	})
+	return progress
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	name := registerJob()
+	ids := createEntities(t, name, 10)
+
+	// the job is interrupted after the first 4 entities are processed
+	interrupted := &Progress{Job: name, State: StateRunning, Processed: 4, Live: 4, Checkpoint: ids[3], Started: 1}
+	data, _ := json.Marshal(interrupted)
+	if err := testKVDB.Put(_PROGRESS_KEY_PREFIX+name, string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := newTestService()
+	wait(t, func() bool { return bs.running[name] != nil && bs.running[name].done != nil })
+	progress := tickUntilFinished(t, bs, name)
+	if progress.State != StateFinished || progress.Total != 10 || progress.Processed != 10 || progress.Live != 10 ||
+		progress.Checkpoint != ids[9] || progress.Started != 1 {
+		t.Fatalf("job should be resumed and finished: %+v", progress)
+	}
+	for i, id := range ids {
+		expected := 1
+		if i <= 3 {
+			expected = 0 // processed before the checkpoint
+		}
+		if liveRuns[id] != expected {
+			t.Fatalf("entity %d should be run %d times, but run %d times", i, expected, liveRuns[id])
+		}
+	}
+
+	if err := bs.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	getProgress(t, name) // the progress is loaded by Start before
+	if bs.running[name] != nil {
+		t.Fatalf("finished job should not be started before reset")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	name := registerJob()
+	ids := createEntities(t, name, 10)
+	bs := newTestService()
+	if err := bs.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, func() bool { return bs.running[name] != nil && bs.running[name].done != nil })
+
+	// rate of the job is 20 per second, so 2 entities are processed each tick
+	for ticks := 1; ticks <= 4; ticks++ {
+		bs.Tick()
+		wait(t, func() bool { return bs.running[name].inflight == 0 })
+		runs := 0
+		for _, id := range ids {
+			runs += liveRuns[id]
+		}
+		if runs != ticks*2 {
+			t.Fatalf("%d entities should be processed after %d ticks, but %d are processed", ticks*2, ticks, runs)
+		}
+	}
+
+	// the progress is checkpointed when the job is paused
+	if err := bs.Pause(name); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		bs.Tick()
+	}
+	progress := getProgress(t, name)
+	if progress.State != StatePaused || progress.Processed != 8 || progress.Checkpoint != ids[7] {
+		t.Fatalf("paused progress should be checkpointed: %+v", progress)
+	}
+
+	if err := bs.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, func() bool { return bs.running[name] != nil && bs.running[name].done != nil })
+	bs.Tick()
+	if rj := bs.running[name]; rj.next != 2 || len(rj.ids) != 2 {
+		t.Fatalf("resumed job should be processed at rate: %d of %d entities", rj.next, len(rj.ids))
+	}
+	if progress := tickUntilFinished(t, bs, name); progress.State != StateFinished || progress.Processed != 10 {
+		t.Fatalf("job should be finished: %+v", progress)
+	}
+}
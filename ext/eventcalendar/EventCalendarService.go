@@ -0,0 +1,202 @@
+package eventcalendar
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	ServiceName = "EventCalendarService"
+	// EventStartedMethod is called on targets with (name string, payload map[string]interface{}) when an event starts
+	EventStartedMethod = "OnEventStarted"
+	// EventStoppedMethod is called on targets with (name string, payload map[string]interface{}) when an event stops
+	EventStoppedMethod = "OnEventStopped"
+
+	_CHECK_INTERVAL = time.Second
+)
+
+// Event defines a gameplay event in the calendar
+type Event struct {
+	Name          string                 // unique name of the event
+	Start         time.Time              // start time of the first occurrence
+	Duration      time.Duration          // duration of each occurrence
+	Recurrence    time.Duration          // interval between occurrences, 0 for one-time events
+	Payload       map[string]interface{} // payload passed to targets
+	Spaces        []common.EntityID      // target spaces
+	Services      []string               // target services, all shards are notified
+	NotifyClients bool                   // notify all clients through gates
+}
+
+// EventCalendarService schedules gameplay events and notifies targets when events start and stop
+//
+// Events are saved in attributes of the service entity, so every start notification is followed by a stop
+// notification even if the service is restarted in the middle of an event. Occurrences which are entirely missed
+// while the service is down are skipped.
+type EventCalendarService struct {
+	entity.Entity
+}
+
+// RegisterService registers EventCalendarService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &EventCalendarService{}, 1)
+}
+
+// Schedule adds the event to calendar, or replaces the event with the same name
+func Schedule(ev *Event) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "Schedule", ev.Name, toUnixMillis(ev.Start), int64(ev.Duration/time.Millisecond),
+		int64(ev.Recurrence/time.Millisecond), ev.Payload, ev.Spaces, ev.Services, ev.NotifyClients)
+}
+
+// Cancel removes the event from calendar, targets are notified if the event is running
+func Cancel(name string) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "Cancel", name)
+}
+
+// QueryUpcoming calls method(events []map[string]interface{}) on the requester entity with events running or starting
+// within the duration, the requester can forward the events to its client
+//
+// Each event contains name, start, end (unix milliseconds), running and payload.
+func QueryUpcoming(within time.Duration, requester common.EntityID, method string) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "QueryUpcoming", int64(within/time.Millisecond), requester, method)
+}
+
+func (ecs *EventCalendarService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnCreated is called when EventCalendarService is created
+func (ecs *EventCalendarService) OnCreated() {
+	gwlog.Infof("Registering EventCalendarService ...")
+	if !ecs.Attrs.HasKey("events") {
+		ecs.Attrs.SetMapAttr("events", goworld.MapAttr())
+	}
+	ecs.AddTimer(_CHECK_INTERVAL, "CheckEvents")
+	ecs.CheckEvents()
+}
+
+// Schedule adds the event to calendar, see eventcalendar.Schedule
+func (ecs *EventCalendarService) Schedule(name string, start int64, duration int64, recurrence int64, payload map[string]interface{}, spaces []common.EntityID, services []string, notifyClients bool) {
+	if duration <= 0 || recurrence < 0 || (recurrence > 0 && recurrence < duration) {
+		gwlog.Errorf("%s: invalid event %s: duration %dms, recurrence %dms", ecs, name, duration, recurrence)
+		return
+	}
+
+	ecs.Cancel(name)
+	spacesList := make([]interface{}, len(spaces))
+	for i, spaceID := range spaces {
+		spacesList[i] = string(spaceID)
+	}
+	servicesList := make([]interface{}, len(services))
+	for i, service := range services {
+		servicesList[i] = service
+	}
+
+	ev := goworld.MapAttr()
+	ev.AssignMap(map[string]interface{}{
+		"start":      start,
+		"duration":   duration,
+		"recurrence": recurrence,
+		"payload":    payload,
+		"spaces":     spacesList,
+		"services":   servicesList,
+		"clients":    notifyClients,
+		"running":    false,
+	})
+	ecs.GetMapAttr("events").SetMapAttr(name, ev)
+	gwlog.Infof("%s: event %s is scheduled at %s", ecs, name, time.Unix(0, start*int64(time.Millisecond)))
+	ecs.checkEvent(name, ev, toUnixMillis(time.Now()))
+}
+
+// Cancel removes the event from calendar, see eventcalendar.Cancel
+func (ecs *EventCalendarService) Cancel(name string) {
+	events := ecs.GetMapAttr("events")
+	if !events.HasKey(name) {
+		return
+	}
+
+	ev := events.GetMapAttr(name)
+	if ev.GetBool("running") {
+		ecs.notifyTargets(name, ev, EventStoppedMethod)
+	}
+	events.Del(name)
+	gwlog.Infof("%s: event %s is cancelled", ecs, name)
+}
+
+// QueryUpcoming returns upcoming events to the requester, see eventcalendar.QueryUpcoming
+func (ecs *EventCalendarService) QueryUpcoming(within int64, requester common.EntityID, method string) {
+	now := toUnixMillis(time.Now())
+	upcoming := []map[string]interface{}{}
+	events := ecs.GetMapAttr("events")
+	events.ForEachKey(func(name string) {
+		ev := events.GetMapAttr(name)
+		start := ev.GetInt("start")
+		if start <= now+within {
+			upcoming = append(upcoming, map[string]interface{}{
+				"name":    name,
+				"start":   start,
+				"end":     start + ev.GetInt("duration"),
+				"running": ev.GetBool("running"),
+				"payload": ev.GetMapAttr("payload").ToMap(),
+			})
+		}
+	})
+	ecs.Call(requester, method, upcoming)
+}
+
+// CheckEvents starts and stops events according to current time
+func (ecs *EventCalendarService) CheckEvents() {
+	now := toUnixMillis(time.Now())
+	events := ecs.GetMapAttr("events")
+	for _, name := range events.Keys() {
+		ecs.checkEvent(name, events.GetMapAttr(name), now)
+	}
+}
+
+func (ecs *EventCalendarService) checkEvent(name string, ev *entity.MapAttr, now int64) {
+	start, duration, recurrence := ev.GetInt("start"), ev.GetInt("duration"), ev.GetInt("recurrence")
+	if ev.GetBool("running") {
+		if now < start+duration {
+			return
+		}
+
+		ev.SetBool("running", false)
+		ecs.notifyTargets(name, ev, EventStoppedMethod)
+	}
+
+	if start+duration <= now {
+		if recurrence == 0 {
+			gwlog.Infof("%s: event %s is finished", ecs, name)
+			ecs.GetMapAttr("events").Del(name)
+			return
+		}
+		// skip to the next occurrence which is not finished yet
+		start += ((now-start-duration)/recurrence + 1) * recurrence
+		ev.SetInt("start", start)
+	}
+
+	if start <= now {
+		ev.SetBool("running", true)
+		ecs.notifyTargets(name, ev, EventStartedMethod)
+	}
+}
+
+func (ecs *EventCalendarService) notifyTargets(name string, ev *entity.MapAttr, method string) {
+	gwlog.Infof("%s: event %s: %s", ecs, name, method)
+	payload := ev.GetMapAttr("payload").ToMap()
+	for _, spaceID := range ev.GetListAttr("spaces").ToList() {
+		ecs.Call(common.EntityID(spaceID.(string)), method, name, payload)
+	}
+	for _, service := range ev.GetListAttr("services").ToList() {
+		goworld.CallServiceAll(service.(string), method, name, payload)
+	}
+	if ev.GetBool("clients") {
+		ecs.CallFilteredClients("", "=", "", method, name, payload)
+	}
+}
+
+func toUnixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,136 @@
+package eventcalendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+func init() {
+	entity.RegisterEntity(ServiceName, &EventCalendarService{}, false)
+	entity.RegisterEntity("testTarget", &testTarget{}, false)
+}
+
+// testTarget records notifications of events as "<method> <name>"
+type testTarget struct {
+	entity.Entity
+	notifications []string
+}
+
+func (t *testTarget) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (t *testTarget) OnEventStarted(name string, payload map[string]interface{}) {
+	t.notifications = append(t.notifications, EventStartedMethod+" "+name)
+}
+
+func (t *testTarget) OnEventStopped(name string, payload map[string]interface{}) {
+	t.notifications = append(t.notifications, EventStoppedMethod+" "+name)
+}
+
+// takeNotifications delivers calls to the target and returns notifications since the last call
+func (t *testTarget) takeNotifications() []string {
+	post.Tick()
+	notifications := t.notifications
+	t.notifications = nil
+	return notifications
+}
+
+func newTestService() (*EventCalendarService, *testTarget) {
+	ecs := entity.CreateEntityLocally(ServiceName, nil).I.(*EventCalendarService)
+	target := entity.CreateEntityLocally("testTarget", nil).I.(*testTarget)
+	return ecs, target
+}
+
+// schedule schedules the event which starts in an hour, and returns the start time in unix milliseconds
+func schedule(ecs *EventCalendarService, target *testTarget, name string, duration time.Duration, recurrence time.Duration) int64 {
+	start := toUnixMillis(time.Now().Add(time.Hour))
+	ecs.Schedule(name, start, int64(duration/time.Millisecond), int64(recurrence/time.Millisecond),
+		map[string]interface{}{"reward": "gold"}, []common.EntityID{target.ID}, nil, false)
+	return start
+}
+
+func checkAt(ecs *EventCalendarService, name string, now int64) {
+	ecs.checkEvent(name, ecs.GetMapAttr("events").GetMapAttr(name), now)
+}
+
+func expectNotifications(t *testing.T, target *testTarget, expected ...string) {
+	t.Helper()
+	notifications := target.takeNotifications()
+	if len(notifications) != len(expected) {
+		t.Fatalf("expected notifications %v, but got %v", expected, notifications)
+	}
+	for i := range expected {
+		if notifications[i] != expected[i] {
+			t.Fatalf("expected notifications %v, but got %v", expected, notifications)
+		}
+	}
+}
+
+func TestOneTimeEvent(t *testing.T) {
+	ecs, target := newTestService()
+	start := schedule(ecs, target, "festival", time.Second, 0)
+	expectNotifications(t, target)
+
+	checkAt(ecs, "festival", start-1)
+	expectNotifications(t, target)
+	checkAt(ecs, "festival", start)
+	expectNotifications(t, target, "OnEventStarted festival")
+	checkAt(ecs, "festival", start+999)
+	expectNotifications(t, target)
+	checkAt(ecs, "festival", start+1000)
+	expectNotifications(t, target, "OnEventStopped festival")
+	if ecs.GetMapAttr("events").HasKey("festival") {
+		t.Fatalf("finished one-time event should be removed")
+	}
+}
+
+func TestRecurringEvent(t *testing.T) {
+	ecs, target := newTestService()
+	start := schedule(ecs, target, "boss", time.Second, time.Second*10)
+	ev := ecs.GetMapAttr("events").GetMapAttr("boss")
+
+	checkAt(ecs, "boss", start)
+	checkAt(ecs, "boss", start+1000)
+	expectNotifications(t, target, "OnEventStarted boss", "OnEventStopped boss")
+	if ev.GetInt("start") != start+10000 || ev.GetBool("running") {
+		t.Fatalf("event should wait for the next occurrence")
+	}
+
+	checkAt(ecs, "boss", start+10000)
+	expectNotifications(t, target, "OnEventStarted boss")
+
+	// occurrences missed entirely are skipped, and the running occurrence is stopped once
+	checkAt(ecs, "boss", start+40500)
+	expectNotifications(t, target, "OnEventStopped boss", "OnEventStarted boss")
+	if ev.GetInt("start") != start+40000 || !ev.GetBool("running") {
+		t.Fatalf("event should run the current occurrence, start = %d", ev.GetInt("start")-start)
+	}
+	checkAt(ecs, "boss", start+45000)
+	expectNotifications(t, target, "OnEventStopped boss")
+	if ev.GetInt("start") != start+50000 {
+		t.Fatalf("event should skip to the next occurrence, start = %d", ev.GetInt("start")-start)
+	}
+}
+
+func TestCancelRunningEvent(t *testing.T) {
+	ecs, target := newTestService()
+	start := schedule(ecs, target, "sale", time.Minute, 0)
+	checkAt(ecs, "sale", start)
+	expectNotifications(t, target, "OnEventStarted sale")
+
+	ecs.Cancel("sale")
+	expectNotifications(t, target, "OnEventStopped sale")
+	if ecs.GetMapAttr("events").HasKey("sale") {
+		t.Fatalf("cancelled event should be removed")
+	}
+
+	// rescheduling the running event stops the running one
+	start = schedule(ecs, target, "sale", time.Minute, 0)
+	checkAt(ecs, "sale", start)
+	schedule(ecs, target, "sale", time.Minute, 0)
+	expectNotifications(t, target, "OnEventStarted sale", "OnEventStopped sale")
+}
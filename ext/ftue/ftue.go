@@ -0,0 +1,182 @@
+// Package ftue tracks tutorial (first-time user experience) progress of entities
+//
+// Completion flags of steps are stored as a bitset in the ftue attribute, which is a list of 32-bit words so that it can
+// be decoded by clients in any language: step N is completed if word N/32 has bit N%32 set. The attribute is synced to
+// the own client and persisted, so entity types which use ftue should call DefineAttr in DescribeEntityType.
+package ftue
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+const (
+	// AttrName is the name of the attribute which stores the bitset of completed steps
+	AttrName = "ftue"
+
+	// Completed is the action of events when steps are completed
+	Completed = "completed"
+	// Reset is the action of events when steps are reset
+	Reset = "reset"
+
+	_WORD_BITS = 32
+)
+
+// Step defines a tutorial step
+type Step struct {
+	ID                int
+	Name              string
+	ClientCompletable bool // the step can be completed by client requests, see CompleteByClient
+}
+
+// Event is the analytics event emitted when steps are completed or reset
+type Event struct {
+	EntityID common.EntityID
+	TypeName string
+	Step     *Step
+	Action   string // Completed or Reset
+	Time     time.Time
+}
+
+var (
+	steps        = map[int]*Step{}
+	eventHandler func(ev *Event)
+
+	stepEvents = metrics.NewCounterVec("goworld_ftue_step_events_total", "Tutorial step events", "step", "action")
+)
+
+// RegisterStep registers a tutorial step, steps should be registered on all games
+func RegisterStep(step *Step) {
+	if step.ID < 0 {
+		gwlog.Panicf("ftue: invalid step ID: %d", step.ID)
+	}
+	if _, ok := steps[step.ID]; ok {
+		gwlog.Panicf("ftue: step %d already registered", step.ID)
+	}
+	steps[step.ID] = step
+}
+
+// SetEventHandler sets the handler of analytics events, which is called in the game routine
+func SetEventHandler(handler func(ev *Event)) {
+	eventHandler = handler
+}
+
+// DefineAttr defines the ftue attribute in DescribeEntityType
+func DefineAttr(desc *entity.EntityTypeDesc) {
+	desc.DefineAttr(AttrName, "Client", "Persistent")
+}
+
+// IsCompleted returns if the step is completed by the entity
+func IsCompleted(e *entity.Entity, stepID int) bool {
+	if !e.Attrs.HasKey(AttrName) {
+		return false
+	}
+
+	bits := e.GetListAttr(AttrName)
+	word := stepID / _WORD_BITS
+	if word >= bits.Size() {
+		return false
+	}
+	return bits.GetInt(word)&(1<<uint(stepID%_WORD_BITS)) != 0
+}
+
+// CompletedSteps returns IDs of steps completed by the entity
+func CompletedSteps(e *entity.Entity) (completed []int) {
+	if !e.Attrs.HasKey(AttrName) {
+		return
+	}
+
+	bits := e.GetListAttr(AttrName)
+	for word := 0; word < bits.Size(); word++ {
+		w := bits.GetInt(word)
+		for bit := 0; bit < _WORD_BITS; bit++ {
+			if w&(1<<uint(bit)) != 0 {
+				completed = append(completed, word*_WORD_BITS+bit)
+			}
+		}
+	}
+	return
+}
+
+// Complete marks the step completed by the entity, returns false if the step is already completed
+func Complete(e *entity.Entity, stepID int) bool {
+	step := getStep(stepID)
+	if IsCompleted(e, stepID) {
+		return false
+	}
+
+	bits := e.GetListAttr(AttrName)
+	word := stepID / _WORD_BITS
+	for bits.Size() <= word {
+		bits.AppendInt(0)
+	}
+	bits.SetInt(word, bits.GetInt(word)|(1<<uint(stepID%_WORD_BITS)))
+	emit(e, step, Completed)
+	return true
+}
+
+// CompleteByClient completes the step for requests from the client of the entity, returns false if the step is
+// unknown, not completable by clients or already completed
+func CompleteByClient(e *entity.Entity, stepID int) bool {
+	step := steps[stepID]
+	if step == nil || !step.ClientCompletable {
+		gwlog.Warnf("ftue: %s can not complete step %d by client", e, stepID)
+		return false
+	}
+	return Complete(e, stepID)
+}
+
+// ResetStep marks the step not completed by the entity
+func ResetStep(e *entity.Entity, stepID int) {
+	step := getStep(stepID)
+	if !IsCompleted(e, stepID) {
+		return
+	}
+
+	bits := e.GetListAttr(AttrName)
+	word := stepID / _WORD_BITS
+	bits.SetInt(word, bits.GetInt(word)&^(1<<uint(stepID%_WORD_BITS)))
+	emit(e, step, Reset)
+}
+
+// ResetAll marks all steps not completed by the entity
+func ResetAll(e *entity.Entity) {
+	completed := CompletedSteps(e)
+	if len(completed) == 0 {
+		return
+	}
+
+	e.Attrs.SetListAttr(AttrName, entity.NewListAttr())
+	for _, stepID := range completed {
+		if step := steps[stepID]; step != nil {
+			emit(e, step, Reset)
+		}
+	}
+}
+
+func getStep(stepID int) *Step {
+	step := steps[stepID]
+	if step == nil {
+		gwlog.Panicf("ftue: step %d is not registered", stepID)
+	}
+	return step
+}
+
+func emit(e *entity.Entity, step *Step, action string) {
+	gwlog.Infof("ftue: %s %s step %d (%s)", e, action, step.ID, step.Name)
+	stepEvents.With(strconv.Itoa(step.ID), action).Inc()
+	if eventHandler != nil {
+		eventHandler(&Event{
+			EntityID: e.ID,
+			TypeName: e.TypeName,
+			Step:     step,
+			Action:   action,
+			Time:     time.Now(),
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package ftue
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+type testPlayer struct {
+	entity.Entity
+}
+
+func (p *testPlayer) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+	DefineAttr(desc)
+}
+
+var events []Event
+
+func init() {
+	entity.RegisterEntity("testPlayer", &testPlayer{}, false)
+	for _, step := range []*Step{
+		{ID: 0, Name: "move"},
+		{ID: 1, Name: "attack", ClientCompletable: true},
+		{ID: 31, Name: "last of first word"},
+		{ID: 32, Name: "first of second word"},
+		{ID: 70, Name: "third word", ClientCompletable: true},
+	} {
+		RegisterStep(step)
+	}
+	SetEventHandler(func(ev *Event) {
+		events = append(events, *ev)
+	})
+}
+
+func newTestPlayer() *entity.Entity {
+	events = nil
+	return entity.CreateEntityLocally("testPlayer", nil)
+}
+
+func TestComplete(t *testing.T) {
+	e := newTestPlayer()
+	if IsCompleted(e, 0) || len(CompletedSteps(e)) != 0 {
+		t.Fatalf("no step should be completed by new entity")
+	}
+
+	for _, stepID := range []int{0, 31, 32, 70} {
+		if !Complete(e, stepID) {
+			t.Fatalf("step %d should be completed", stepID)
+		}
+	}
+	if Complete(e, 32) {
+		t.Fatalf("completed step should not be completed again")
+	}
+	for _, stepID := range []int{0, 31, 32, 70} {
+		if !IsCompleted(e, stepID) {
+			t.Fatalf("step %d should be completed", stepID)
+		}
+	}
+	if IsCompleted(e, 1) || IsCompleted(e, 33) || IsCompleted(e, 100) {
+		t.Fatalf("steps which are not completed should not be completed")
+	}
+	if completed := CompletedSteps(e); !reflect.DeepEqual(completed, []int{0, 31, 32, 70}) {
+		t.Fatalf("wrong completed steps: %v", completed)
+	}
+
+	// step N is bit N%32 of word N/32
+	bits := e.GetListAttr(AttrName)
+	if bits.Size() != 3 || bits.GetInt(0) != 1|1<<31 || bits.GetInt(1) != 1 || bits.GetInt(2) != 1<<6 {
+		t.Fatalf("wrong bitset: %v", bits.ToList())
+	}
+	if len(events) != 4 || events[3].Step.ID != 70 || events[3].Action != Completed || events[3].EntityID != e.ID {
+		t.Fatalf("wrong events: %+v", events)
+	}
+}
+
+func TestCompleteByClient(t *testing.T) {
+	e := newTestPlayer()
+	if CompleteByClient(e, 0) || CompleteByClient(e, 2) {
+		t.Fatalf("steps which are not client completable should not be completed by client")
+	}
+	if !CompleteByClient(e, 70) || CompleteByClient(e, 70) {
+		t.Fatalf("client completable step should be completed once")
+	}
+	if completed := CompletedSteps(e); !reflect.DeepEqual(completed, []int{70}) {
+		t.Fatalf("wrong completed steps: %v", completed)
+	}
+}
+
+func TestReset(t *testing.T) {
+	e := newTestPlayer()
+	for _, stepID := range []int{0, 31, 32, 70} {
+		Complete(e, stepID)
+	}
+
+	events = nil
+	ResetStep(e, 31)
+	ResetStep(e, 70)
+	ResetStep(e, 1) // not completed
+	if completed := CompletedSteps(e); !reflect.DeepEqual(completed, []int{0, 32}) {
+		t.Fatalf("wrong completed steps after reset: %v", completed)
+	}
+	if len(events) != 2 || events[0].Step.ID != 31 || events[0].Action != Reset {
+		t.Fatalf("wrong events: %+v", events)
+	}
+
+	events = nil
+	ResetAll(e)
+	if IsCompleted(e, 0) || IsCompleted(e, 32) || len(CompletedSteps(e)) != 0 {
+		t.Fatalf("all steps should be reset")
+	}
+	if len(events) != 2 {
+		t.Fatalf("reset of each completed step should be emitted: %+v", events)
+	}
+	if !Complete(e, 70) || !reflect.DeepEqual(CompletedSteps(e), []int{70}) {
+		t.Fatalf("step should be completed after reset")
+	}
+}
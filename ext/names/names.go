@@ -0,0 +1,307 @@
+// Package names reserves unique names (e.g. character names and guild names) across the cluster
+//
+// Names are claimed and released atomically by CompareAndSwap of KVDB, so racing claims of the same name on different
+// games never produce duplicates:
+//
+//	names.SetRules("character", names.Rules{MinLength: 2, MaxLength: 16, Reserved: []string{"admin", "gm"}})
+//	names.Claim("character", "Alice", player.ID, func(result string) { ... })
+//
+// Names are compared in normalized form: case folded, fullwidth forms folded to ASCII, format characters (e.g. zero
+// width spaces) removed and whitespaces collapsed, so "Alice", "ALICE" and "Ａｌｉｃｅ" are the same name. The name as
+// entered is kept as the display name.
+package names
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+const (
+	// ResultOK means the name is claimed or released
+	ResultOK = ""
+	// ResultInvalid means the name is too short, too long or has characters not allowed
+	ResultInvalid = "invalid"
+	// ResultReserved means the name is a reserved word or contains a forbidden word
+	ResultReserved = "reserved"
+	// ResultTaken means the name is claimed by another owner
+	ResultTaken = "taken"
+	// ResultNotOwner means the name to release is not claimed by the owner
+	ResultNotOwner = "not_owner"
+	// ResultError means the operation failed because of KVDB errors
+	ResultError = "error"
+
+	_KVDB_KEY_PREFIX = "names/"
+)
+
+// Rules are rules of names in a namespace
+type Rules struct {
+	MinLength  int      // min characters of the normalized name
+	MaxLength  int      // max characters of the normalized name, 0 means unlimited
+	AllowSpace bool     // names can have spaces between words
+	AllowChars string   // characters allowed besides letters and digits, e.g. "_-"
+	AllowMarks bool     // names can have combining marks, which are not composed, so "é" and "é" are different names
+	Reserved   []string // names which can not be claimed, e.g. "admin"
+	Forbidden  []string // words which can not be contained in names, compared without spaces
+}
+
+// Reservation is the name claimed in KVDB
+type Reservation struct {
+	Owner common.EntityID `json:"owner"`
+	Name  string          `json:"name"` // display name
+	Time  int64           `json:"time"` // unix seconds when the name is claimed
+}
+
+var (
+	rulesLock sync.RWMutex
+	rules     = map[string]*Rules{}
+
+	defaultRules = &Rules{MinLength: 1, MaxLength: 32}
+)
+
+// SetRules sets rules of names in the namespace, names are checked by the default rules (1 ~ 32 letters or digits) if
+// rules are not set, rules should be set on all games
+func SetRules(namespace string, r Rules) {
+	r.Reserved = normalizeWords(r.Reserved)
+	r.Forbidden = normalizeWords(r.Forbidden)
+	for i, word := range r.Forbidden {
+		r.Forbidden[i] = strings.Replace(word, " ", "", -1)
+	}
+
+	rulesLock.Lock()
+	rules[namespace] = &r
+	rulesLock.Unlock()
+}
+
+func getRules(namespace string) *Rules {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	if r := rules[namespace]; r != nil {
+		return r
+	}
+	return defaultRules
+}
+
+func normalizeWords(words []string) []string {
+	normalized := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = Normalize(word); word != "" {
+			normalized = append(normalized, word)
+		}
+	}
+	return normalized
+}
+
+// Normalize returns the normalized form of the name, which is used to compare names
+func Normalize(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range name {
+		if r >= 0xFF01 && r <= 0xFF5E { // fullwidth ASCII variants
+			r -= 0xFEE0
+		}
+		if unicode.IsSpace(r) {
+			space = b.Len() > 0
+			continue
+		}
+		if unicode.Is(unicode.Cf, r) || unicode.IsControl(r) {
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(unicode.ToLower(unicode.ToUpper(r)))
+	}
+	return b.String()
+}
+
+// Check returns the normalized name and ResultOK if the name is valid in the namespace, or the result of failure
+func Check(namespace string, name string) (normalized string, result string) {
+	if namespace == "" || strings.Contains(namespace, "/") {
+		gwlog.Panicf("names: invalid namespace %q", namespace)
+	}
+
+	r := getRules(namespace)
+	normalized = Normalize(name)
+	if n := utf8.RuneCountInString(normalized); n == 0 || n < r.MinLength || (r.MaxLength > 0 && n > r.MaxLength) {
+		return normalized, ResultInvalid
+	}
+	for _, c := range normalized {
+		if !(unicode.IsLetter(c) || unicode.IsDigit(c) || (c == ' ' && r.AllowSpace) ||
+			(unicode.IsMark(c) && r.AllowMarks) || strings.ContainsRune(r.AllowChars, c)) {
+			return normalized, ResultInvalid
+		}
+	}
+
+	for _, word := range r.Reserved {
+		if normalized == word {
+			return normalized, ResultReserved
+		}
+	}
+	compact := strings.Replace(normalized, " ", "", -1)
+	for _, word := range r.Forbidden {
+		if strings.Contains(compact, word) {
+			return normalized, ResultReserved
+		}
+	}
+	return normalized, ResultOK
+}
+
+func nameKey(namespace string, normalized string) string {
+	return _KVDB_KEY_PREFIX + namespace + "/" + normalized
+}
+
+func parseReservation(val string) (*Reservation, error) {
+	var claim Reservation
+	err := json.Unmarshal([]byte(val), &claim)
+	return &claim, err
+}
+
+// Claim claims the name in the namespace for the owner, callback is called with the result on the game goroutine
+//
+// Claiming a name which is already claimed by the owner succeeds, so failed claims can be retried.
+func Claim(namespace string, name string, owner common.EntityID, callback func(result string)) {
+	normalized, result := Check(namespace, name)
+	if result != ResultOK {
+		post.Post(func() {
+			callback(result)
+		})
+		return
+	}
+
+	key := nameKey(namespace, normalized)
+	val, _ := json.Marshal(Reservation{Owner: owner, Name: strings.TrimSpace(name), Time: time.Now().Unix()})
+	goworld.CompareAndSwapKVDB(key, "", string(val), func(swapped bool, err error) {
+		if err != nil {
+			gwlog.Errorf("names: claim %s for %s failed: %s", key, owner, err)
+			callback(ResultError)
+			return
+		} else if swapped {
+			callback(ResultOK)
+			return
+		}
+
+		Lookup(namespace, name, func(claim *Reservation, err error) {
+			if err != nil {
+				callback(ResultError)
+			} else if claim != nil && claim.Owner == owner {
+				callback(ResultOK)
+			} else if claim == nil {
+				Claim(namespace, name, owner, callback) // released after CompareAndSwap
+			} else {
+				callback(ResultTaken)
+			}
+		})
+	})
+}
+
+// Release releases the name in the namespace claimed by the owner, releasing a name which is not claimed succeeds
+func Release(namespace string, name string, owner common.EntityID, callback func(result string)) {
+	normalized, _ := Check(namespace, name) // names claimed before rules are changed can be released
+	key := nameKey(namespace, normalized)
+	goworld.GetKVDB(key, func(val string, err error) {
+		if err != nil {
+			gwlog.Errorf("names: get %s failed: %s", key, err)
+			callback(ResultError)
+			return
+		} else if val == "" {
+			callback(ResultOK)
+			return
+		}
+
+		if claim, err := parseReservation(val); err != nil || claim.Owner != owner {
+			callback(ResultNotOwner)
+			return
+		}
+		goworld.CompareAndSwapKVDB(key, val, "", func(swapped bool, err error) {
+			if err != nil {
+				gwlog.Errorf("names: release %s of %s failed: %s", key, owner, err)
+				callback(ResultError)
+			} else if swapped {
+				callback(ResultOK)
+			} else {
+				Release(namespace, name, owner, callback) // changed after Get
+			}
+		})
+	})
+}
+
+// Rename claims the new name for the owner and then releases the old name, the old name is kept if the new name can
+// not be claimed
+//
+// If only the display name is changed (e.g. alice -> Alice), the display name in the reservation is updated.
+func Rename(namespace string, oldName string, newName string, owner common.EntityID, callback func(result string)) {
+	oldNormalized, _ := Check(namespace, oldName)
+	if newNormalized, result := Check(namespace, newName); result == ResultOK && newNormalized == oldNormalized {
+		setDisplayName(namespace, newName, owner, callback)
+		return
+	}
+
+	Claim(namespace, newName, owner, func(result string) {
+		if result != ResultOK {
+			callback(result)
+			return
+		}
+		Release(namespace, oldName, owner, func(result string) {
+			if result != ResultOK {
+				gwlog.Warnf("names: release %s of %s after renamed to %s failed: %s", oldName, owner, newName, result)
+			}
+			callback(ResultOK)
+		})
+	})
+}
+
+// setDisplayName updates the display name of the reservation claimed by the owner, or claims the name if not claimed
+func setDisplayName(namespace string, name string, owner common.EntityID, callback func(result string)) {
+	normalized, _ := Check(namespace, name)
+	key := nameKey(namespace, normalized)
+	goworld.GetKVDB(key, func(val string, err error) {
+		if err != nil {
+			gwlog.Errorf("names: get %s failed: %s", key, err)
+			callback(ResultError)
+			return
+		} else if val == "" {
+			Claim(namespace, name, owner, callback)
+			return
+		}
+
+		claim, err := parseReservation(val)
+		if err != nil || claim.Owner != owner {
+			callback(ResultTaken)
+			return
+		}
+		claim.Name = strings.TrimSpace(name)
+		newVal, _ := json.Marshal(claim)
+		goworld.CompareAndSwapKVDB(key, val, string(newVal), func(swapped bool, err error) {
+			if err != nil {
+				gwlog.Errorf("names: rename %s of %s failed: %s", key, owner, err)
+				callback(ResultError)
+			} else if swapped {
+				callback(ResultOK)
+			} else {
+				setDisplayName(namespace, name, owner, callback) // changed after Get
+			}
+		})
+	})
+}
+
+// Lookup returns the reservation of the name in the namespace, or nil if the name is not claimed
+func Lookup(namespace string, name string, callback func(claim *Reservation, err error)) {
+	normalized, _ := Check(namespace, name)
+	goworld.GetKVDB(nameKey(namespace, normalized), func(val string, err error) {
+		if err != nil || val == "" {
+			callback(nil, err)
+			return
+		}
+		callback(parseReservation(val))
+	})
+}
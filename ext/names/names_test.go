@@ -0,0 +1,175 @@
+package names
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+func init() {
+	engine := kvdbtest.NewMemoryEngine()
+	kvdb.Register("namestest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return engine, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "namestest")
+	kvdb.Initialize(conf)
+}
+
+var namespaces = 0
+
+// newNamespace returns a new namespace of character names, so that tests do not see names claimed by previous runs
+func newNamespace() string {
+	namespaces += 1
+	namespace := fmt.Sprintf("character%d_%s", namespaces, common.GenEntityID())
+	SetRules(namespace, Rules{MinLength: 2, MaxLength: 8, AllowSpace: true, AllowChars: "_", Reserved: []string{"Admin"}, Forbidden: []string{"bad word"}})
+	return namespace
+}
+
+// wait ticks the game routine until the result is set
+func wait(t *testing.T, done func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("callback is not called in time")
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func claim(t *testing.T, namespace string, name string, owner common.EntityID) (result string) {
+	result = "pending"
+	Claim(namespace, name, owner, func(r string) { result = r })
+	wait(t, func() bool { return result != "pending" })
+	return
+}
+
+func rename(t *testing.T, namespace string, oldName string, newName string, owner common.EntityID) (result string) {
+	result = "pending"
+	Rename(namespace, oldName, newName, owner, func(r string) { result = r })
+	wait(t, func() bool { return result != "pending" })
+	return
+}
+
+func lookup(t *testing.T, namespace string, name string) (res *Reservation) {
+	done := false
+	Lookup(namespace, name, func(claim *Reservation, err error) {
+		if err != nil {
+			t.Errorf("lookup %s failed: %s", name, err)
+		}
+		res, done = claim, true
+	})
+	wait(t, func() bool { return done })
+	return
+}
+
+func TestNormalize(t *testing.T) {
+	for name, normalized := range map[string]string{
+		"Alice":         "alice",
+		"ＡＬＩＣＥ":         "alice",
+		"  Al​ice  ":    "alice",
+		"Big \t  Bob":   "big bob",
+		"ΣΊΣΥΦΟΣ":       "σίσυφοσ",
+		"Straße":        "straße",
+		"​​":            "",
+		"Alice\x00\x7f": "alice",
+		"ｂｏｂ＿ｔｈｅ＿ｂｕｉｌｄｅｒ": "bob_the_builder",
+	} {
+		if got := Normalize(name); got != normalized {
+			t.Errorf("Normalize(%q) = %q, expected %q", name, got, normalized)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	namespace := newNamespace()
+	for name, result := range map[string]string{
+		"Alice":     ResultOK,
+		"Big Bob":   ResultOK,
+		"bob_1":     ResultOK,
+		"a":         ResultInvalid,
+		"abcdefghi": ResultInvalid,
+		"bob-1":     ResultInvalid,
+		"​":         ResultInvalid,
+		"ADMIN":     ResultReserved,
+		"ａｄｍｉｎ":     ResultReserved,
+		"badword":   ResultReserved,
+		"Bad Word":  ResultReserved,
+	} {
+		if _, got := Check(namespace, name); got != result {
+			t.Errorf("Check(%q) = %q, expected %q", name, got, result)
+		}
+	}
+
+	// default rules
+	if _, result := Check("guild", "Big Bob"); result != ResultInvalid {
+		t.Errorf("spaces should not be allowed by default rules")
+	}
+}
+
+func TestClaim(t *testing.T) {
+	namespace := newNamespace()
+	alice, bob := common.GenEntityID(), common.GenEntityID()
+	if result := claim(t, namespace, "Alice", alice); result != ResultOK {
+		t.Fatalf("claim failed: %s", result)
+	}
+	if result := claim(t, namespace, "ALICE", alice); result != ResultOK {
+		t.Fatalf("claiming the name of the owner again should succeed: %s", result)
+	}
+	if result := claim(t, namespace, "ａｌｉｃｅ", bob); result != ResultTaken {
+		t.Fatalf("claiming the name of another owner should fail: %s", result)
+	}
+	if result := claim(t, namespace, "admin", bob); result != ResultReserved {
+		t.Fatalf("claiming reserved name should fail: %s", result)
+	}
+	if res := lookup(t, namespace, "alice"); res == nil || res.Owner != alice || res.Name != "Alice" {
+		t.Fatalf("wrong reservation: %+v", res)
+	}
+
+	// racing claims
+	results := map[common.EntityID]string{}
+	for _, owner := range []common.EntityID{alice, bob} {
+		owner := owner
+		Claim(namespace, "Carol", owner, func(result string) { results[owner] = result })
+	}
+	wait(t, func() bool { return len(results) == 2 })
+	if (results[alice] == ResultOK) == (results[bob] == ResultOK) {
+		t.Fatalf("only one racing claim should succeed: %v", results)
+	}
+}
+
+func TestRename(t *testing.T) {
+	namespace := newNamespace()
+	owner, other := common.GenEntityID(), common.GenEntityID()
+	if result := claim(t, namespace, "dave", owner); result != ResultOK {
+		t.Fatalf("claim failed: %s", result)
+	}
+
+	if result := rename(t, namespace, "dave", "Dave", owner); result != ResultOK {
+		t.Fatalf("rename failed: %s", result)
+	}
+	if res := lookup(t, namespace, "dave"); res == nil || res.Owner != owner || res.Name != "Dave" {
+		t.Fatalf("display name should be updated: %+v", res)
+	}
+	if result := rename(t, namespace, "Dave", "DAVE", other); result != ResultTaken {
+		t.Fatalf("renaming the name of another owner should fail: %s", result)
+	}
+
+	if result := rename(t, namespace, "Dave", "Eve", owner); result != ResultOK {
+		t.Fatalf("rename failed: %s", result)
+	}
+	if res := lookup(t, namespace, "dave"); res != nil {
+		t.Fatalf("old name should be released: %+v", res)
+	}
+	if res := lookup(t, namespace, "eve"); res == nil || res.Owner != owner || res.Name != "Eve" {
+		t.Fatalf("new name should be claimed: %+v", res)
+	}
+}
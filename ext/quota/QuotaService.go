@@ -0,0 +1,187 @@
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	ServiceName = "QuotaService"
+
+	_COUNTER_IDLE_TIMEOUT = time.Minute * 10 // cached counters are evicted if not accessed for a while
+	_KVDB_KEY_PREFIX      = "quota/"
+)
+
+// Window is the type of quota windows
+type Window int
+
+const (
+	// Total counters never reset
+	Total Window = iota
+	// Daily counters reset every day at the reset offset
+	Daily
+	// Weekly counters reset every Monday at the reset offset
+	Weekly
+)
+
+var (
+	resetOffset time.Duration
+)
+
+// SetResetOffset sets the time of day when daily and weekly counters reset (in local time), default is midnight
+//
+// All games should set the same reset offset.
+func SetResetOffset(offset time.Duration) {
+	resetOffset = offset
+}
+
+// WindowID returns the ID of the window which the time belongs to, counters reset when window ID changes
+func WindowID(window Window, t time.Time) int64 {
+	if window == Total {
+		return 0
+	}
+
+	y, m, d := t.Add(-resetOffset).Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	if window == Weekly {
+		day -= int64((time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Weekday() + 6) % 7) // Monday of the week
+	}
+	return day
+}
+
+// counterKey returns the KVDB key of the counter in the window which the time belongs to
+//
+// IDs of daily and weekly windows are both day numbers, so weekly keys are prefixed to not collide on Mondays.
+func counterKey(name string, owner string, window Window, t time.Time) string {
+	if window == Weekly {
+		return fmt.Sprintf("%s%s/%s/w%d", _KVDB_KEY_PREFIX, name, owner, WindowID(window, t))
+	}
+	return fmt.Sprintf("%s%s/%s/%d", _KVDB_KEY_PREFIX, name, owner, WindowID(window, t))
+}
+
+// QuotaService maintains persistent counters with windowed quotas, e.g. daily dungeon entries or weekly rewards
+//
+// Counters are stored in KVDB with window ID in keys, so counters reset automatically when windows change. Each counter
+// is owned by one shard of QuotaService according to the owner, so increments are atomic across games.
+type QuotaService struct {
+	entity.Entity
+	counters map[string]*counter
+}
+
+type counter struct {
+	loaded     bool
+	count      int64
+	pending    []func(c *counter)
+	lastAccess time.Time
+}
+
+// RegisterService registers QuotaService to goworld
+func RegisterService(shardCount int) {
+	goworld.RegisterService(ServiceName, &QuotaService{}, shardCount)
+}
+
+// TryIncr increases the counter of the owner by delta if the counter will not exceed limit, and calls
+// method(name string, ok bool, count int64) on the requester entity
+//
+// limit <= 0 means no limit. TryIncr with delta = 0 queries the counter.
+func TryIncr(name string, owner string, window Window, delta int64, limit int64, requester common.EntityID, method string) {
+	goworld.CallServiceShardKey(ServiceName, owner, "TryIncr", name, owner, int(window), delta, limit, requester, method)
+}
+
+// Get calls method(name string, ok bool, count int64) on the requester entity with the counter of the owner
+func Get(name string, owner string, window Window, requester common.EntityID, method string) {
+	TryIncr(name, owner, window, 0, 0, requester, method)
+}
+
+func (qs *QuotaService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initializes QuotaService fields
+func (qs *QuotaService) OnInit() {
+	qs.counters = map[string]*counter{}
+}
+
+// OnCreated is called when QuotaService is created
+func (qs *QuotaService) OnCreated() {
+	gwlog.Infof("Registering QuotaService ...")
+	qs.AddTimer(_COUNTER_IDLE_TIMEOUT, "EvictCounters")
+}
+
+// TryIncr increases the counter, see quota.TryIncr
+func (qs *QuotaService) TryIncr(name string, owner string, window int, delta int64, limit int64, requester common.EntityID, method string) {
+	key := counterKey(name, owner, Window(window), time.Now())
+	qs.withCounter(key, func(c *counter) {
+		if c == nil {
+			qs.Call(requester, method, name, false, int64(0))
+			return
+		}
+
+		if limit > 0 && c.count+delta > limit {
+			qs.Call(requester, method, name, false, c.count)
+			return
+		}
+
+		if delta != 0 {
+			c.count += delta
+			goworld.PutKVDB(key, strconv.FormatInt(c.count, 10), func(err error) {
+				if err != nil {
+					gwlog.Errorf("%s: save counter %s = %d failed: %s", qs, key, c.count, err)
+				}
+			})
+		}
+		qs.Call(requester, method, name, true, c.count)
+	})
+}
+
+// withCounter calls f with the counter after it is loaded from KVDB, or with nil if loading failed
+func (qs *QuotaService) withCounter(key string, f func(c *counter)) {
+	c := qs.counters[key]
+	if c == nil {
+		c = &counter{}
+		qs.counters[key] = c
+		goworld.GetKVDB(key, func(val string, err error) {
+			if err == nil && val != "" {
+				c.count, err = strconv.ParseInt(val, 10, 64)
+			}
+
+			pending := c.pending
+			c.pending = nil
+			if err != nil {
+				gwlog.Errorf("%s: load counter %s failed: %s", qs, key, err)
+				delete(qs.counters, key) // retry loading next time
+				for _, f := range pending {
+					f(nil)
+				}
+				return
+			}
+
+			c.loaded = true
+			for _, f := range pending {
+				f(c)
+			}
+		})
+	}
+
+	c.lastAccess = time.Now()
+	if c.loaded {
+		f(c)
+	} else {
+		c.pending = append(c.pending, f)
+	}
+}
+
+// EvictCounters removes idle counters from cache
+func (qs *QuotaService) EvictCounters() {
+	now := time.Now()
+	for key, c := range qs.counters {
+		if c.loaded && now.Sub(c.lastAccess) > _COUNTER_IDLE_TIMEOUT {
+			delete(qs.counters, key)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package quota
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+var testKVDB = kvdbtest.NewMemoryEngine()
+
+func init() {
+	kvdb.Register("quotatest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return testKVDB, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "quotatest")
+	kvdb.Initialize(conf)
+
+	entity.RegisterEntity(ServiceName, &QuotaService{}, false)
+	entity.RegisterEntity("TestRequester", &testRequester{}, false)
+}
+
+type quotaResult struct {
+	ok    bool
+	count int64
+}
+
+type testRequester struct {
+	entity.Entity
+	results []quotaResult
+}
+
+func (r *testRequester) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (r *testRequester) OnQuota(name string, ok bool, count int64) {
+	r.results = append(r.results, quotaResult{ok, count})
+}
+
+func newTestService() (*QuotaService, *testRequester) {
+	qs := entity.CreateEntityLocally(ServiceName, nil).I.(*QuotaService)
+	requester := entity.CreateEntityLocally("TestRequester", nil).I.(*testRequester)
+	return qs, requester
+}
+
+func tryIncr(t *testing.T, qs *QuotaService, requester *testRequester, name string, owner string, window Window, delta int64, limit int64) quotaResult {
+	n := len(requester.results)
+	qs.TryIncr(name, owner, int(window), delta, limit, requester.ID, "OnQuota")
+	deadline := time.Now().Add(time.Second * 5)
+	for len(requester.results) == n {
+		if time.Now().After(deadline) {
+			t.Fatalf("TryIncr %s is not done in time", name)
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+	return requester.results[n]
+}
+
+func TestWindowID(t *testing.T) {
+	monday := time.Date(2026, 10, 12, 12, 0, 0, 0, time.UTC)
+	if WindowID(Total, monday) != 0 || WindowID(Total, monday.AddDate(1, 0, 0)) != 0 {
+		t.Fatalf("total window should never change")
+	}
+
+	if WindowID(Daily, monday) != WindowID(Daily, time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) ||
+		WindowID(Daily, monday)+1 != WindowID(Daily, time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("daily window should change at midnight")
+	}
+	sunday := time.Date(2026, 10, 18, 23, 59, 59, 0, time.UTC)
+	if WindowID(Weekly, monday) != WindowID(Weekly, sunday) || WindowID(Weekly, sunday) == WindowID(Weekly, sunday.Add(time.Second)) {
+		t.Fatalf("weekly window should change on Monday")
+	}
+
+	SetResetOffset(time.Hour * 5)
+	defer SetResetOffset(0)
+	beforeReset, afterReset := time.Date(2026, 10, 13, 4, 59, 59, 0, time.UTC), time.Date(2026, 10, 13, 5, 0, 0, 0, time.UTC)
+	if WindowID(Daily, beforeReset) != WindowID(Daily, monday) || WindowID(Daily, afterReset) != WindowID(Daily, monday)+1 {
+		t.Fatalf("daily window should change at the reset offset")
+	}
+	if WindowID(Weekly, time.Date(2026, 10, 12, 4, 59, 59, 0, time.UTC)) == WindowID(Weekly, monday) {
+		t.Fatalf("weekly window should change at the reset offset on Monday")
+	}
+}
+
+func TestCounterKey(t *testing.T) {
+	monday := time.Date(2026, 10, 12, 12, 0, 0, 0, time.UTC)
+	if counterKey("reward", "owner", Daily, monday) == counterKey("reward", "owner", Weekly, monday) {
+		t.Fatalf("daily and weekly counters should not share keys on Monday")
+	}
+}
+
+func TestTryIncrLimit(t *testing.T) {
+	qs, requester := newTestService()
+	owner := string(common.GenEntityID())
+
+	for i := int64(1); i <= 3; i++ {
+		if res := tryIncr(t, qs, requester, "dungeon", owner, Daily, 1, 3); !res.ok || res.count != i {
+			t.Fatalf("TryIncr should succeed within limit: %+v", res)
+		}
+	}
+	if res := tryIncr(t, qs, requester, "dungeon", owner, Daily, 1, 3); res.ok || res.count != 3 {
+		t.Fatalf("TryIncr should fail over limit: %+v", res)
+	}
+	if res := tryIncr(t, qs, requester, "dungeon", string(common.GenEntityID()), Daily, 2, 3); !res.ok || res.count != 2 {
+		t.Fatalf("counters of other owners should not be affected: %+v", res)
+	}
+	if res := tryIncr(t, qs, requester, "dungeon", owner, Daily, -1, 0); !res.ok || res.count != 2 {
+		t.Fatalf("TryIncr without limit should succeed: %+v", res)
+	}
+
+	// counters are loaded from KVDB after evicted
+	qs.counters = map[string]*counter{}
+	if res := tryIncr(t, qs, requester, "dungeon", owner, Daily, 0, 0); !res.ok || res.count != 2 {
+		t.Fatalf("counter should be saved: %+v", res)
+	}
+}
+
+func TestTryIncrWindowRollover(t *testing.T) {
+	qs, requester := newTestService()
+	owner := string(common.GenEntityID())
+	now := time.Now()
+
+	// the counter of yesterday is used up
+	if err := testKVDB.Put(counterKey("reward", owner, Daily, now.AddDate(0, 0, -1)), strconv.Itoa(5)); err != nil {
+		t.Fatal(err)
+	}
+	if res := tryIncr(t, qs, requester, "reward", owner, Daily, 1, 5); !res.ok || res.count != 1 {
+		t.Fatalf("daily counter should reset in a new window: %+v", res)
+	}
+
+	// the counter of this week is used up
+	if err := testKVDB.Put(counterKey("reward", owner, Weekly, now), strconv.Itoa(5)); err != nil {
+		t.Fatal(err)
+	}
+	if res := tryIncr(t, qs, requester, "reward", owner, Weekly, 1, 5); res.ok || res.count != 5 {
+		t.Fatalf("weekly counter should be used up: %+v", res)
+	}
+	if res := tryIncr(t, qs, requester, "reward", owner, Total, 1, 5); !res.ok || res.count != 1 {
+		t.Fatalf("counters of different windows should not be affected: %+v", res)
+	}
+}
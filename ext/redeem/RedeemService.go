@@ -0,0 +1,267 @@
+package redeem
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	ServiceName = "RedeemService"
+
+	// ResultOK means the code is redeemed and rewards are granted
+	ResultOK = ""
+	// ResultInvalid means the code does not exist
+	ResultInvalid = "invalid"
+	// ResultDisabled means the batch of the code is disabled
+	ResultDisabled = "disabled"
+	// ResultNotStarted means the batch of the code is not started yet
+	ResultNotStarted = "not_started"
+	// ResultExpired means the batch of the code is expired
+	ResultExpired = "expired"
+	// ResultUsedUp means the code is redeemed for max times
+	ResultUsedUp = "used_up"
+	// ResultLimitReached means the account has redeemed codes of the batch for max times
+	ResultLimitReached = "limit_reached"
+	// ResultError means redeeming failed because of KVDB errors
+	ResultError = "error"
+
+	_ENTRY_IDLE_TIMEOUT  = time.Minute * 10 // cached entries are evicted if not accessed for a while
+	_BATCH_KEY_PREFIX    = "redeem/batch/"
+	_CODE_KEY_PREFIX     = "redeem/code/"
+	_ACCOUNT_KEY_PREFIX  = "redeem/account/"
+	_CODE_CHARS          = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // without I, O, 0 and 1 which are easily confused
+	_GENERATED_CODE_SIZE = 12
+)
+
+// Batch is a batch of redemption codes which grant the same rewards
+type Batch struct {
+	ID           string                 `json:"id"`
+	MaxUses      int                    `json:"max_uses"`      // max redemptions of each code, 0 means unlimited
+	AccountLimit int                    `json:"account_limit"` // max redemptions of codes in the batch by each account, 0 means unlimited
+	Start        int64                  `json:"start"`         // unix seconds when codes can be redeemed, 0 means no limit
+	Expire       int64                  `json:"expire"`        // unix seconds when codes expire, 0 means never
+	Rewards      map[string]interface{} `json:"rewards"`
+	Disabled     bool                   `json:"disabled"`
+}
+
+// GrantFunc grants rewards of the batch to the account, usually by sending a mail through the mail service
+type GrantFunc func(account common.EntityID, batch *Batch, code string)
+
+var (
+	grantFunc GrantFunc
+)
+
+// codeInfo is saved in KVDB for each code
+type codeInfo struct {
+	Batch string `json:"batch"`
+	Uses  int    `json:"uses"`
+}
+
+// RedeemService redeems codes generated in batches
+//
+// Batches and codes are stored in KVDB and managed through the HTTP admin API (see RegisterAdminAPI). Usages of codes
+// and accounts are cached by the service, so redemptions are atomic.
+type RedeemService struct {
+	entity.Entity
+	entries map[string]*entry
+}
+
+// entry caches a KVDB value which is only changed by RedeemService
+type entry struct {
+	loaded     bool
+	val        string
+	pending    []func(e *entry)
+	lastAccess time.Time
+	users      int // redemptions using the entry, which should not be evicted
+}
+
+// RegisterService registers RedeemService to goworld, grant is called on the game of RedeemService when codes are
+// redeemed
+func RegisterService(grant GrantFunc) {
+	grantFunc = grant
+	goworld.RegisterService(ServiceName, &RedeemService{}, 1)
+}
+
+// Redeem redeems the code for the account, and calls method(code string, result string) on the requester entity
+//
+// result is ResultOK if the code is redeemed, otherwise it is the reason of failure.
+func Redeem(code string, account common.EntityID, requester common.EntityID, method string) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "Redeem", code, account, requester, method)
+}
+
+// NormalizeCode converts the code entered by players to the stored form
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
+
+func (rs *RedeemService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initializes RedeemService fields
+func (rs *RedeemService) OnInit() {
+	rs.entries = map[string]*entry{}
+}
+
+// OnCreated is called when RedeemService is created
+func (rs *RedeemService) OnCreated() {
+	gwlog.Infof("Registering RedeemService ...")
+	rs.AddTimer(_ENTRY_IDLE_TIMEOUT, "EvictEntries")
+}
+
+// Redeem redeems the code, see redeem.Redeem
+func (rs *RedeemService) Redeem(code string, account common.EntityID, requester common.EntityID, method string) {
+	code = NormalizeCode(code)
+	var codeEntry *entry
+	reply := func(result string) {
+		if codeEntry != nil {
+			codeEntry.users -= 1
+		}
+		if result != ResultOK {
+			gwlog.Infof("%s: %s redeem %s failed: %s", rs, account, code, result)
+		}
+		rs.Call(requester, method, code, result)
+	}
+
+	codeKey := _CODE_KEY_PREFIX + code
+	rs.withEntry(codeKey, func(ce *entry) {
+		if ce == nil {
+			reply(ResultError)
+			return
+		} else if ce.val == "" {
+			reply(ResultInvalid)
+			return
+		}
+
+		// the code entry is used until the redemption is done, otherwise redemptions of the code after the entry is
+		// evicted would load the usage before this redemption
+		codeEntry = ce
+		ce.users += 1
+
+		var info codeInfo
+		if err := json.Unmarshal([]byte(ce.val), &info); err != nil {
+			gwlog.Errorf("%s: parse code %s failed: %s", rs, code, err)
+			reply(ResultError)
+			return
+		}
+
+		// batches are changed by the admin API, so they are not cached
+		goworld.GetKVDB(_BATCH_KEY_PREFIX+info.Batch, func(val string, err error) {
+			var batch Batch
+			if err == nil {
+				err = json.Unmarshal([]byte(val), &batch)
+			}
+			if err != nil {
+				gwlog.Errorf("%s: load batch %s of code %s failed: %s", rs, info.Batch, code, err)
+				reply(ResultError)
+				return
+			}
+
+			now := time.Now().Unix()
+			if batch.Disabled {
+				reply(ResultDisabled)
+				return
+			} else if batch.Start > 0 && now < batch.Start {
+				reply(ResultNotStarted)
+				return
+			} else if batch.Expire > 0 && now >= batch.Expire {
+				reply(ResultExpired)
+				return
+			}
+
+			accountKey := _ACCOUNT_KEY_PREFIX + batch.ID + "/" + string(account)
+			rs.withEntry(accountKey, func(ae *entry) {
+				if ae == nil {
+					reply(ResultError)
+					return
+				}
+
+				// the code might be redeemed by others while loading, so check the latest usage
+				var info codeInfo
+				json.Unmarshal([]byte(ce.val), &info)
+				accountUses, _ := strconv.Atoi(ae.val)
+				if batch.MaxUses > 0 && info.Uses >= batch.MaxUses {
+					reply(ResultUsedUp)
+					return
+				} else if batch.AccountLimit > 0 && accountUses >= batch.AccountLimit {
+					reply(ResultLimitReached)
+					return
+				}
+
+				info.Uses += 1
+				data, _ := json.Marshal(info)
+				rs.saveEntry(codeKey, ce, string(data))
+				rs.saveEntry(accountKey, ae, strconv.Itoa(accountUses+1))
+
+				gwlog.Infof("%s: %s redeemed %s of batch %s", rs, account, code, batch.ID)
+				grantFunc(account, &batch, code)
+				reply(ResultOK)
+			})
+		})
+	})
+}
+
+// withEntry calls f with the entry after it is loaded from KVDB, or with nil if loading failed
+//
+// Entries of keys which do not exist in KVDB are not cached, so that they can be created by the admin API.
+func (rs *RedeemService) withEntry(key string, f func(e *entry)) {
+	e := rs.entries[key]
+	if e == nil {
+		e = &entry{}
+		rs.entries[key] = e
+		goworld.GetKVDB(key, func(val string, err error) {
+			pending := e.pending
+			e.pending = nil
+			if err != nil {
+				gwlog.Errorf("%s: load %s failed: %s", rs, key, err)
+				delete(rs.entries, key) // retry loading next time
+				for _, f := range pending {
+					f(nil)
+				}
+				return
+			}
+
+			e.loaded = true
+			e.val = val
+			for _, f := range pending {
+				f(e)
+			}
+			if e.val == "" && rs.entries[key] == e {
+				delete(rs.entries, key)
+			}
+		})
+	}
+
+	e.lastAccess = time.Now()
+	if e.loaded {
+		f(e)
+	} else {
+		e.pending = append(e.pending, f)
+	}
+}
+
+func (rs *RedeemService) saveEntry(key string, e *entry, val string) {
+	e.val = val
+	rs.entries[key] = e
+	goworld.PutKVDB(key, val, func(err error) {
+		if err != nil {
+			gwlog.Errorf("%s: save %s = %s failed: %s", rs, key, val, err)
+		}
+	})
+}
+
+// EvictEntries removes idle entries from cache
+func (rs *RedeemService) EvictEntries() {
+	now := time.Now()
+	for key, e := range rs.entries {
+		if e.loaded && e.users == 0 && now.Sub(e.lastAccess) > _ENTRY_IDLE_TIMEOUT {
+			delete(rs.entries, key)
+		}
+	}
+}
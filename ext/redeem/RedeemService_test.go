@@ -0,0 +1,220 @@
+package redeem
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+var (
+	testKVDB = kvdbtest.NewMemoryEngine()
+	grants   = map[string]int{} // grants of each code
+	batches  = 0
+)
+
+func init() {
+	kvdb.Register("redeemtest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return testKVDB, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "redeemtest")
+	kvdb.Initialize(conf)
+
+	grantFunc = func(account common.EntityID, batch *Batch, code string) {
+		grants[code] += 1
+	}
+	entity.RegisterEntity(ServiceName, &RedeemService{}, false)
+	entity.RegisterEntity("TestRequester", &testRequester{}, false)
+}
+
+type testRequester struct {
+	entity.Entity
+	results map[string][]string // results of each code
+}
+
+func (r *testRequester) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (r *testRequester) OnInit() {
+	r.results = map[string][]string{}
+}
+
+func (r *testRequester) OnRedeemed(code string, result string) {
+	r.results[code] = append(r.results[code], result)
+}
+
+// newBatch saves a new batch with the code in KVDB, and returns the code
+func newBatch(t *testing.T, batch Batch) string {
+	batches += 1
+	batch.ID = fmt.Sprintf("batch%d_%s", batches, common.GenEntityID())
+	code := NormalizeCode(string(common.GenEntityID()))
+	batchData, _ := json.Marshal(batch)
+	codeData, _ := json.Marshal(codeInfo{Batch: batch.ID})
+	if err := testKVDB.Put(_BATCH_KEY_PREFIX+batch.ID, string(batchData)); err != nil {
+		t.Fatal(err)
+	}
+	if err := testKVDB.Put(_CODE_KEY_PREFIX+code, string(codeData)); err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func newTestService() (*RedeemService, *testRequester) {
+	rs := entity.CreateEntityLocally(ServiceName, nil).I.(*RedeemService)
+	requester := entity.CreateEntityLocally("TestRequester", nil).I.(*testRequester)
+	return rs, requester
+}
+
+// wait ticks the game routine until the requester receives n results of the code
+func wait(t *testing.T, requester *testRequester, code string, n int) []string {
+	deadline := time.Now().Add(time.Second * 5)
+	for len(requester.results[code]) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("redeem %s is not done in time", code)
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+	return requester.results[code]
+}
+
+func redeem(t *testing.T, rs *RedeemService, requester *testRequester, code string, account common.EntityID) string {
+	n := len(requester.results[code])
+	rs.Redeem(code, account, requester.ID, "OnRedeemed")
+	return wait(t, requester, code, n+1)[n]
+}
+
+func TestRedeemMaxUses(t *testing.T) {
+	rs, requester := newTestService()
+	code := newBatch(t, Batch{MaxUses: 2})
+
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultOK {
+		t.Fatalf("redeem should succeed: %s", result)
+	}
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultOK {
+		t.Fatalf("redeem should succeed: %s", result)
+	}
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultUsedUp {
+		t.Fatalf("redeem should fail if the code is used up: %s", result)
+	}
+	if grants[code] != 2 {
+		t.Fatalf("rewards should be granted twice, but granted %d times", grants[code])
+	}
+	if result := redeem(t, rs, requester, "NOSUCHCODE", common.GenEntityID()); result != ResultInvalid {
+		t.Fatalf("redeem should fail if the code does not exist: %s", result)
+	}
+}
+
+func TestRedeemAccountLimit(t *testing.T) {
+	rs, requester := newTestService()
+	code := newBatch(t, Batch{AccountLimit: 1})
+	account := common.GenEntityID()
+
+	if result := redeem(t, rs, requester, code, account); result != ResultOK {
+		t.Fatalf("redeem should succeed: %s", result)
+	}
+	if result := redeem(t, rs, requester, code, account); result != ResultLimitReached {
+		t.Fatalf("redeem should fail if the account limit is reached: %s", result)
+	}
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultOK {
+		t.Fatalf("redeem by another account should succeed: %s", result)
+	}
+
+	// the account usage is loaded from KVDB after the cache is evicted
+	rs.entries = map[string]*entry{}
+	if result := redeem(t, rs, requester, code, account); result != ResultLimitReached {
+		t.Fatalf("account usage should be saved: %s", result)
+	}
+}
+
+func TestRedeemStartExpire(t *testing.T) {
+	rs, requester := newTestService()
+	now := time.Now().Unix()
+
+	code := newBatch(t, Batch{Start: now + 3600})
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultNotStarted {
+		t.Fatalf("redeem should fail before start: %s", result)
+	}
+	code = newBatch(t, Batch{Expire: now - 1})
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultExpired {
+		t.Fatalf("redeem should fail after expire: %s", result)
+	}
+	code = newBatch(t, Batch{Start: now - 1, Expire: now + 3600})
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultOK {
+		t.Fatalf("redeem should succeed between start and expire: %s", result)
+	}
+	code = newBatch(t, Batch{Disabled: true})
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultDisabled {
+		t.Fatalf("redeem should fail if the batch is disabled: %s", result)
+	}
+}
+
+func TestRedeemConcurrent(t *testing.T) {
+	rs, requester := newTestService()
+	code := newBatch(t, Batch{MaxUses: 1})
+
+	for i := 0; i < 5; i++ {
+		rs.Redeem(code, common.GenEntityID(), requester.ID, "OnRedeemed")
+	}
+	results := wait(t, requester, code, 5)
+	ok := 0
+	for _, result := range results {
+		if result == ResultOK {
+			ok += 1
+		} else if result != ResultUsedUp {
+			t.Fatalf("concurrent redeem failed: %s", result)
+		}
+	}
+	if ok != 1 || grants[code] != 1 {
+		t.Fatalf("code should be redeemed once, but redeemed %d times", ok)
+	}
+}
+
+func TestRedeemEvictWhileLoading(t *testing.T) {
+	rs, requester := newTestService()
+	code := newBatch(t, Batch{MaxUses: 1})
+	codeKey := _CODE_KEY_PREFIX + code
+
+	// start the first redemption, and evict entries as soon as the code is loaded
+	rs.Redeem(code, common.GenEntityID(), requester.ID, "OnRedeemed")
+	deadline := time.Now().Add(time.Second * 5)
+	for rs.entries[codeKey] == nil || !rs.entries[codeKey].loaded {
+		if time.Now().After(deadline) {
+			t.Fatalf("code %s is not loaded in time", code)
+		}
+		post.Tick()
+		time.Sleep(time.Millisecond)
+	}
+	for _, e := range rs.entries {
+		e.lastAccess = time.Now().Add(-_ENTRY_IDLE_TIMEOUT * 2)
+	}
+	rs.EvictEntries()
+
+	// the second redemption starts while the first one is loading the batch and the account
+	rs.Redeem(code, common.GenEntityID(), requester.ID, "OnRedeemed")
+	results := wait(t, requester, code, 2)
+	if grants[code] != 1 || (results[0] == ResultOK) == (results[1] == ResultOK) {
+		t.Fatalf("code should be redeemed once: %v", results)
+	}
+
+	// idle entries are evicted after redemptions are done
+	for _, e := range rs.entries {
+		e.lastAccess = time.Now().Add(-_ENTRY_IDLE_TIMEOUT * 2)
+	}
+	rs.EvictEntries()
+	if len(rs.entries) != 0 {
+		t.Fatalf("idle entries should be evicted: %d entries", len(rs.entries))
+	}
+	if result := redeem(t, rs, requester, code, common.GenEntityID()); result != ResultUsedUp {
+		t.Fatalf("code usage should be saved: %s", result)
+	}
+}
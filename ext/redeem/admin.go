@@ -0,0 +1,201 @@
+package redeem
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+const (
+	_MAX_GENERATE_COUNT = 100000
+)
+
+// CreateBatchRequest is the request body of creating batches
+type CreateBatchRequest struct {
+	Batch Batch    `json:"batch"`
+	Count int      `json:"count"` // number of codes to generate
+	Codes []string `json:"codes"` // custom codes, e.g. WELCOME2018 shared by all players
+}
+
+// RegisterAdminAPI registers HTTP handlers for managing batches to the HTTP server of the game
+//
+// Requests are authenticated like the admin API, creating and disabling batches require the gm role.
+//
+//	POST /redeem/batch                     creates a batch with CreateBatchRequest, and responds with the codes
+//	GET  /redeem/batch?id=<batch>          responds with the batch
+//	POST /redeem/batch/disable?id=<batch>  disables the batch
+func RegisterAdminAPI() {
+	createBatch := binutil.RequireAdmin(binutil.AdminGM, handleCreateBatch)
+	getBatch := binutil.RequireAdmin(binutil.AdminReadOnly, handleGetBatch)
+	http.HandleFunc("/redeem/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			createBatch(w, r)
+		} else {
+			getBatch(w, r)
+		}
+	})
+	http.HandleFunc("/redeem/batch/disable", binutil.RequireAdmin(binutil.AdminGM, handleDisableBatch))
+}
+
+func handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, code := range req.Codes {
+		if req.Codes[i] = NormalizeCode(code); req.Codes[i] == "" {
+			http.Error(w, "invalid code", http.StatusBadRequest)
+			return
+		}
+	}
+
+	batch := &req.Batch
+	if batch.ID == "" || strings.Contains(batch.ID, "/") {
+		http.Error(w, "invalid batch id", http.StatusBadRequest)
+		return
+	} else if req.Count < 0 || req.Count+len(req.Codes) == 0 || req.Count > _MAX_GENERATE_COUNT {
+		http.Error(w, fmt.Sprintf("count should be in [1, %d]", _MAX_GENERATE_COUNT), http.StatusBadRequest)
+		return
+	}
+
+	batchData, _ := json.Marshal(batch)
+	codeData, _ := json.Marshal(codeInfo{Batch: batch.ID})
+	done := make(chan error, 1)
+	var codes []string
+
+	post.Post(func() {
+		kvdb.GetOrPut(_BATCH_KEY_PREFIX+batch.ID, string(batchData), func(oldVal string, err error) {
+			if err != nil {
+				done <- err
+				return
+			} else if oldVal != "" {
+				done <- fmt.Errorf("batch %s already exists", batch.ID)
+				return
+			}
+
+			remaining := req.Count + len(req.Codes)
+			var putCode func(code string, generated bool)
+			putCode = func(code string, generated bool) {
+				kvdb.GetOrPut(_CODE_KEY_PREFIX+code, string(codeData), func(oldVal string, err error) {
+					if err == nil && oldVal != "" {
+						if generated {
+							putCode(generateCode(), true) // collision, try another code
+							return
+						}
+						gwlog.Warnf("redeem: code %s of batch %s already exists", code, batch.ID)
+					} else if err != nil {
+						gwlog.Errorf("redeem: save code %s of batch %s failed: %s", code, batch.ID, err)
+					} else {
+						codes = append(codes, code)
+					}
+
+					if remaining -= 1; remaining == 0 {
+						done <- nil
+					}
+				})
+			}
+
+			for _, code := range req.Codes {
+				putCode(code, false)
+			}
+			for i := 0; i < req.Count; i++ {
+				putCode(generateCode(), true)
+			}
+		})
+	})
+
+	if err := <-done; err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	gwlog.Infof("redeem: batch %s is created with %d codes", batch.ID, len(codes))
+	writeJSON(w, map[string]interface{}{"batch": batch, "codes": codes})
+}
+
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := loadBatch(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, batch)
+}
+
+func handleDisableBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batch, err := loadBatch(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	batch.Disabled = true
+	data, _ := json.Marshal(batch)
+	done := make(chan error, 1)
+	post.Post(func() {
+		kvdb.Put(_BATCH_KEY_PREFIX+batch.ID, string(data), func(err error) {
+			done <- err
+		})
+	})
+	if err := <-done; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	gwlog.Infof("redeem: batch %s is disabled", batch.ID)
+	writeJSON(w, batch)
+}
+
+// loadBatch loads the batch from KVDB, it is called in HTTP handlers
+func loadBatch(id string) (*Batch, error) {
+	type result struct {
+		val string
+		err error
+	}
+	done := make(chan result, 1)
+	post.Post(func() {
+		kvdb.Get(_BATCH_KEY_PREFIX+id, func(val string, err error) {
+			done <- result{val, err}
+		})
+	})
+
+	res := <-done
+	if res.err != nil {
+		return nil, res.err
+	} else if id == "" || res.val == "" {
+		return nil, fmt.Errorf("batch %s not found", id)
+	}
+
+	var batch Batch
+	if err := json.Unmarshal([]byte(res.val), &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func generateCode() string {
+	b := make([]byte, _GENERATED_CODE_SIZE)
+	if _, err := rand.Read(b); err != nil {
+		gwlog.Panicf("redeem: generate code failed: %s", err)
+	}
+	for i := range b {
+		b[i] = _CODE_CHARS[int(b[i])%len(_CODE_CHARS)]
+	}
+	return string(b)
+}
@@ -0,0 +1,293 @@
+// Package shop implements server-side shop purchases with a versioned catalog
+//
+// The catalog is loaded from a data table on every game. Clients only send item IDs, counts and the price versions
+// they saw, prices and currencies are always taken from the catalog on the server. Buyer entities implement Wallet,
+// which integrates purchases with the economy of the game.
+//
+// Receipts are saved in KVDB by buyer and idempotency key, so retried purchase requests are not charged twice, and
+// purchase history can be queried for customer support. A pending receipt claims the idempotency key before the buyer
+// is charged, and is completed after charging. Receipts left pending by stopped games are kept for customer support,
+// and retries of them fail with ErrPurchaseInProgress.
+package shop
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+const (
+	_KVDB_KEY_PREFIX = "shop/"
+)
+
+var (
+	// ErrUnknownItem is returned if the item is not in catalog or disabled
+	ErrUnknownItem = errors.New("unknown item")
+	// ErrInvalidCount is returned if the count is not positive
+	ErrInvalidCount = errors.New("invalid count")
+	// ErrPriceChanged is returned if the client saw a different currency or price version of the item
+	ErrPriceChanged = errors.New("price changed")
+	// ErrInsufficientFunds is returned if the buyer can not afford the purchase
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrPurchaseInProgress is returned if a purchase with the same idempotency key is in progress
+	ErrPurchaseInProgress = errors.New("purchase in progress")
+	// ErrBuyerDestroyed is returned if the buyer is destroyed or migrated before the purchase is done
+	ErrBuyerDestroyed = errors.New("buyer destroyed")
+
+	catalog   = &Catalog{}
+	itemsByID = map[string]*Item{}
+)
+
+// Item is a shop item in catalog
+type Item struct {
+	ID           string                 `json:"id"`
+	Currency     string                 `json:"currency"`
+	Price        int64                  `json:"price"`
+	PriceVersion int                    `json:"price_version"` // should be increased whenever currency or price changes
+	Disabled     bool                   `json:"disabled"`
+	Data         map[string]interface{} `json:"data"` // static data of the item, e.g. contents of bundles
+}
+
+// Catalog is the list of shop items
+type Catalog struct {
+	Version string  `json:"version"`
+	Items   []*Item `json:"items"`
+}
+
+// Wallet is implemented by buyer entities to integrate purchases with the economy of the game
+type Wallet interface {
+	// Balance returns the balance of the currency
+	Balance(currency string) int64
+	// Debit takes the amount of currency, it is only called if balance is sufficient
+	Debit(currency string, amount int64)
+	// Grant gives count of the item to the buyer
+	Grant(item *Item, count int)
+}
+
+// Receipt is the record of a purchase
+type Receipt struct {
+	Key          string          `json:"key"` // idempotency key
+	Buyer        common.EntityID `json:"buyer"`
+	ItemID       string          `json:"item"`
+	Count        int             `json:"count"`
+	Currency     string          `json:"currency"`
+	Price        int64           `json:"price"` // unit price
+	PriceVersion int             `json:"price_version"`
+	Catalog      string          `json:"catalog"`           // catalog version
+	Time         int64           `json:"time"`              // unix milliseconds
+	Pending      bool            `json:"pending,omitempty"` // the buyer is being charged, or the game stopped while charging
+}
+
+// Total returns the total price of the purchase
+func (r *Receipt) Total() int64 {
+	return r.Price * int64(r.Count)
+}
+
+// PurchaseCallback is type of Purchase callback
+//
+// replayed is true if the purchase was already done with the same idempotency key, in which case the receipt of the
+// previous purchase is returned and the buyer is not charged again.
+type PurchaseCallback func(receipt *Receipt, replayed bool, err error)
+
+// SetCatalog replaces the catalog, catalogs should be the same on all games
+func SetCatalog(c *Catalog) {
+	items := make(map[string]*Item, len(c.Items))
+	for _, item := range c.Items {
+		if item.ID == "" {
+			gwlog.Panicf("SetCatalog: item ID is empty")
+		}
+		if _, ok := items[item.ID]; ok {
+			gwlog.Panicf("SetCatalog: duplicate item %s", item.ID)
+		}
+		if item.Currency == "" || item.Price < 0 {
+			gwlog.Panicf("SetCatalog: invalid price of item %s: %d %s", item.ID, item.Price, item.Currency)
+		}
+		items[item.ID] = item
+	}
+	catalog, itemsByID = c, items
+	gwlog.Infof("shop: catalog %s is loaded: %d items", c.Version, len(c.Items))
+}
+
+// LoadCatalog loads the catalog from the JSON file
+func LoadCatalog(file string) error {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "read shop catalog failed")
+	}
+
+	var c Catalog
+	if err := json.Unmarshal(content, &c); err != nil {
+		return errors.Wrapf(err, "parse shop catalog failed: %s", file)
+	}
+	SetCatalog(&c)
+	return nil
+}
+
+// GetCatalog returns the current catalog, which can be sent to clients
+func GetCatalog() *Catalog {
+	return catalog
+}
+
+// GetItem returns the item in catalog, or nil if not exists or disabled
+func GetItem(itemID string) *Item {
+	item := itemsByID[itemID]
+	if item == nil || item.Disabled {
+		return nil
+	}
+	return item
+}
+
+// Purchase buys count of the item for the buyer, which must implement Wallet
+//
+// currency and priceVersion are what the client saw, the purchase fails with ErrPriceChanged if they are outdated.
+// idempotencyKey is generated by the client for each purchase and reused when retrying the purchase. callback is
+// called in the game routine.
+func Purchase(buyer *entity.Entity, itemID string, count int, currency string, priceVersion int, idempotencyKey string, callback PurchaseCallback) {
+	wallet, ok := buyer.I.(Wallet)
+	if !ok {
+		gwlog.Panicf("shop: %s does not implement shop.Wallet", buyer)
+	}
+
+	item := GetItem(itemID)
+	if item == nil {
+		callback(nil, false, ErrUnknownItem)
+		return
+	}
+	if count <= 0 {
+		callback(nil, false, ErrInvalidCount)
+		return
+	}
+	if item.Currency != currency || item.PriceVersion != priceVersion {
+		callback(nil, false, ErrPriceChanged)
+		return
+	}
+
+	if buyer.IsDestroyed() {
+		callback(nil, false, ErrBuyerDestroyed)
+		return
+	}
+
+	key := receiptKey(buyer.ID, idempotencyKey)
+	receipt := &Receipt{
+		Key:          idempotencyKey,
+		Buyer:        buyer.ID,
+		ItemID:       item.ID,
+		Count:        count,
+		Currency:     item.Currency,
+		Price:        item.Price,
+		PriceVersion: item.PriceVersion,
+		Catalog:      catalog.Version,
+		Time:         time.Now().UnixNano() / int64(time.Millisecond),
+		Pending:      true,
+	}
+	pendingData, _ := json.Marshal(receipt)
+
+	// the idempotency key is claimed by the pending receipt before charging, so only one purchase of the key is charged
+	kvdb.CompareAndSwap(key, "", string(pendingData), func(swapped bool, err error) {
+		if err != nil {
+			callback(nil, false, err)
+			return
+		}
+		if !swapped {
+			replayPurchase(key, callback)
+			return
+		}
+
+		if buyer.IsDestroyed() {
+			releasePurchase(key)
+			callback(nil, false, ErrBuyerDestroyed)
+			return
+		}
+		if wallet.Balance(item.Currency) < receipt.Total() {
+			releasePurchase(key)
+			callback(nil, false, ErrInsufficientFunds)
+			return
+		}
+
+		wallet.Debit(item.Currency, receipt.Total())
+		wallet.Grant(item, count)
+		gwlog.Infof("shop: %s bought %d %s for %d %s (price version %d, key %s)", buyer, count, item.ID, receipt.Total(), item.Currency, item.PriceVersion, idempotencyKey)
+
+		receipt.Pending = false
+		data, _ := json.Marshal(receipt)
+		kvdb.CompareAndSwap(key, string(pendingData), string(data), func(swapped bool, err error) {
+			if err != nil || !swapped {
+				// the pending receipt is kept, so the purchase is never charged again
+				gwlog.Errorf("shop: save receipt %s failed: swapped=%v, err=%v", key, swapped, err)
+			}
+		})
+		callback(receipt, false, nil)
+	})
+}
+
+// replayPurchase returns the receipt of the purchase already done with the idempotency key
+func replayPurchase(key string, callback PurchaseCallback) {
+	kvdb.Get(key, func(val string, err error) {
+		if err != nil {
+			callback(nil, false, err)
+			return
+		}
+		if val == "" { // the claim is just released
+			callback(nil, false, ErrPurchaseInProgress)
+			return
+		}
+
+		var receipt Receipt
+		if err := json.Unmarshal([]byte(val), &receipt); err != nil {
+			callback(nil, false, errors.Wrapf(err, "parse receipt %s failed", key))
+			return
+		}
+		if receipt.Pending {
+			callback(nil, false, ErrPurchaseInProgress)
+			return
+		}
+		callback(&receipt, true, nil)
+	})
+}
+
+// releasePurchase releases the idempotency key claimed by the purchase which is not charged
+func releasePurchase(key string) {
+	kvdb.Del(key, func(err error) {
+		if err != nil {
+			gwlog.Errorf("shop: release purchase %s failed: %s", key, err)
+		}
+	})
+}
+
+// QueryPurchaseHistory returns receipts of the buyer ordered by time in callback
+func QueryPurchaseHistory(buyerID common.EntityID, callback func(receipts []*Receipt, err error)) {
+	prefix := receiptKey(buyerID, "")
+	kvdb.GetRange(prefix, kvdb.NextLargerKey(prefix), func(items []kvdbtypes.KVItem, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		receipts := make([]*Receipt, 0, len(items))
+		for _, item := range items {
+			var receipt Receipt
+			if err := json.Unmarshal([]byte(item.Val), &receipt); err != nil {
+				gwlog.Errorf("shop: parse receipt %s failed: %s", item.Key, err)
+				continue
+			}
+			receipts = append(receipts, &receipt)
+		}
+		sort.Slice(receipts, func(i, j int) bool {
+			return receipts[i].Time < receipts[j].Time
+		})
+		callback(receipts, nil)
+	})
+}
+
+func receiptKey(buyerID common.EntityID, idempotencyKey string) string {
+	return fmt.Sprintf("%s%s/%s", _KVDB_KEY_PREFIX, buyerID, idempotencyKey)
+}
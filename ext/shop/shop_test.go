@@ -0,0 +1,147 @@
+package shop
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/kvdbtest"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+var testKVDB = kvdbtest.NewMemoryEngine()
+
+func init() {
+	kvdb.Register("shoptest", func(cfg *config.KVDBConfig) (kvdbtypes.KVDBEngine, error) {
+		return testKVDB, nil
+	})
+	conf := config.New("../../goworld.ini")
+	conf.SetOverride("kvdb", "type", "shoptest")
+	kvdb.Initialize(conf)
+
+	SetCatalog(&Catalog{Version: "1", Items: []*Item{
+		{ID: "sword", Currency: "gold", Price: 100, PriceVersion: 1},
+	}})
+}
+
+type testWallet struct {
+	entity.Entity
+	balance int64
+	debits  int
+	grants  int
+}
+
+func (w *testWallet) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (w *testWallet) Balance(currency string) int64 {
+	return w.balance
+}
+
+func (w *testWallet) Debit(currency string, amount int64) {
+	w.balance -= amount
+	w.debits += 1
+}
+
+func (w *testWallet) Grant(item *Item, count int) {
+	w.grants += count
+}
+
+func newTestBuyer(balance int64) (*entity.Entity, *testWallet) {
+	wallet := &testWallet{balance: balance}
+	wallet.ID, wallet.TypeName, wallet.I = common.GenEntityID(), "Avatar", wallet
+	return &wallet.Entity, wallet
+}
+
+type purchaseResult struct {
+	receipt  *Receipt
+	replayed bool
+	err      error
+}
+
+// startPurchase starts the purchase of one sword, the result is set when the purchase is done
+func startPurchase(buyer *entity.Entity, key string) **purchaseResult {
+	res := new(*purchaseResult)
+	Purchase(buyer, "sword", 1, "gold", 1, key, func(receipt *Receipt, replayed bool, err error) {
+		*res = &purchaseResult{receipt, replayed, err}
+	})
+	return res
+}
+
+// waitPurchases ticks the game routine until all purchases are done
+func waitPurchases(t *testing.T, results ...**purchaseResult) {
+	deadline := time.Now().Add(time.Second * 5)
+	for _, res := range results {
+		for *res == nil {
+			if time.Now().After(deadline) {
+				t.Fatalf("purchase is not done in time")
+			}
+			post.Tick()
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func purchase(t *testing.T, buyer *entity.Entity, key string) *purchaseResult {
+	res := startPurchase(buyer, key)
+	waitPurchases(t, res)
+	return *res
+}
+
+func TestPurchaseReplay(t *testing.T) {
+	buyer, wallet := newTestBuyer(1000)
+	res := purchase(t, buyer, "key1")
+	if res.err != nil || res.replayed || res.receipt.Total() != 100 || res.receipt.Pending {
+		t.Fatalf("purchase failed: %+v", res)
+	}
+
+	replay := purchase(t, buyer, "key1")
+	if replay.err != nil || !replay.replayed || replay.receipt.Time != res.receipt.Time {
+		t.Fatalf("retried purchase should be replayed: %+v", replay)
+	}
+	if wallet.balance != 900 || wallet.debits != 1 || wallet.grants != 1 {
+		t.Fatalf("retried purchase should not be charged again: %+v", wallet)
+	}
+
+	if res := purchase(t, buyer, "key2"); res.err != nil || res.replayed || wallet.debits != 2 {
+		t.Fatalf("purchase of another key should be charged: %+v", res)
+	}
+}
+
+func TestPurchaseInProgress(t *testing.T) {
+	buyer, wallet := newTestBuyer(1000)
+	first, second := startPurchase(buyer, "key"), startPurchase(buyer, "key")
+	waitPurchases(t, first, second)
+	if (*first).err != nil || (*second).err != ErrPurchaseInProgress && !(*second).replayed {
+		t.Fatalf("concurrent purchases of the same key: %+v, %+v", *first, *second)
+	}
+	if wallet.debits != 1 {
+		t.Fatalf("concurrent purchases of the same key should be charged once, but charged %d times", wallet.debits)
+	}
+
+	// the receipt left pending by a stopped game
+	pending := &Receipt{Key: "stopped", Buyer: buyer.ID, ItemID: "sword", Count: 1, Currency: "gold", Price: 100, Pending: true}
+	data, _ := json.Marshal(pending)
+	testKVDB.Put(receiptKey(buyer.ID, "stopped"), string(data))
+	if res := purchase(t, buyer, "stopped"); res.err != ErrPurchaseInProgress || wallet.debits != 1 {
+		t.Fatalf("purchase of pending receipt should be in progress: %+v", res)
+	}
+}
+
+func TestPurchaseInsufficientFunds(t *testing.T) {
+	buyer, wallet := newTestBuyer(50)
+	if res := purchase(t, buyer, "key"); res.err != ErrInsufficientFunds || wallet.debits != 0 {
+		t.Fatalf("purchase should fail: %+v", res)
+	}
+
+	// the idempotency key is released, so the purchase can be retried
+	wallet.balance = 100
+	if res := purchase(t, buyer, "key"); res.err != nil || res.replayed || wallet.balance != 0 {
+		t.Fatalf("retried purchase should be charged: %+v", res)
+	}
+}
@@ -0,0 +1,113 @@
+package spacepool
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	ServiceName = "SpacePoolService"
+)
+
+// SpacePoolService pre-creates spaces from space templates ahead of scheduled events (e.g. raid opening at 20:00),
+// so that spaces are not created all at once when the event starts
+//
+// Each pool keeps spaces of one template & parameters, which are handed out by Acquire. Pre-warmed spaces should not be
+// destroyed by empty timeout before acquired, use TTL or match end destroy policies instead.
+type SpacePoolService struct {
+	entity.Entity
+}
+
+// RegisterService registers SpacePoolService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &SpacePoolService{}, 1)
+}
+
+// SchedulePrewarm schedules pre-warming count spaces of the template into the pool at warmAt, spaces are created across
+// the games round-robin, or on any game if gameids is empty
+func SchedulePrewarm(pool string, template string, params map[string]interface{}, count int, warmAt time.Time, gameids []uint16) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "SchedulePrewarm", pool, template, params, count, warmAt.Unix(), gameids)
+}
+
+// Acquire takes a space from the pool, and calls method(spaceID) on the requester entity
+//
+// A new space is created if the pool is empty, and spaceID is empty if the pool is never pre-warmed.
+func Acquire(pool string, requester common.EntityID, method string) {
+	goworld.CallServiceShardIndex(ServiceName, 0, "Acquire", pool, requester, method)
+}
+
+func (sps *SpacePoolService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnCreated is called when SpacePoolService is created
+func (sps *SpacePoolService) OnCreated() {
+	gwlog.Infof("Registering SpacePoolService ...")
+	if !sps.Attrs.HasKey("pools") {
+		sps.Attrs.SetMapAttr("pools", goworld.MapAttr())
+	}
+}
+
+// SchedulePrewarm schedules pre-warming of the pool, see spacepool.SchedulePrewarm
+func (sps *SpacePoolService) SchedulePrewarm(pool string, template string, params map[string]interface{}, count int, warmAt int64, gameids []uint16) {
+	delay := time.Until(time.Unix(warmAt, 0))
+	if delay < 0 {
+		delay = 0
+	}
+	gwlog.Infof("%s: pool %s will be pre-warmed with %d spaces of template %s after %s", sps, pool, count, template, delay)
+	sps.AddCallback(delay, "Prewarm", pool, template, params, count, gameids)
+}
+
+// Prewarm creates spaces of the template until there are count spaces in the pool
+func (sps *SpacePoolService) Prewarm(pool string, template string, params map[string]interface{}, count int, gameids []uint16) {
+	poolAttr := sps.getPool(pool)
+	poolAttr.SetStr("template", template)
+	paramsAttr := goworld.MapAttr()
+	paramsAttr.AssignMap(params)
+	poolAttr.SetMapAttr("params", paramsAttr)
+
+	spaces := poolAttr.GetListAttr("spaces")
+	for i := 0; spaces.Size() < count; i++ {
+		var gameid uint16
+		if len(gameids) > 0 {
+			gameid = gameids[i%len(gameids)]
+		}
+		spaceID := goworld.CreateSpaceFromTemplateOnGame(gameid, template, params)
+		spaces.AppendStr(string(spaceID))
+	}
+	gwlog.Infof("%s: pool %s is pre-warmed with %d spaces", sps, pool, spaces.Size())
+}
+
+// Acquire takes a space from the pool, see spacepool.Acquire
+func (sps *SpacePoolService) Acquire(pool string, requester common.EntityID, method string) {
+	pools := sps.GetMapAttr("pools")
+	if !pools.HasKey(pool) {
+		gwlog.Warnf("%s: pool %s is not pre-warmed", sps, pool)
+		sps.Call(requester, method, "")
+		return
+	}
+	poolAttr := pools.GetMapAttr(pool)
+	template := poolAttr.GetStr("template")
+
+	var spaceID common.EntityID
+	if spaces := poolAttr.GetListAttr("spaces"); spaces.Size() > 0 {
+		spaceID = common.EntityID(spaces.PopStr())
+	} else {
+		gwlog.Warnf("%s: pool %s is empty, creating space on demand", sps, pool)
+		spaceID = goworld.CreateSpaceFromTemplate(template, poolAttr.GetMapAttr("params").ToMap())
+	}
+	sps.Call(requester, method, spaceID)
+}
+
+func (sps *SpacePoolService) getPool(pool string) *entity.MapAttr {
+	pools := sps.GetMapAttr("pools")
+	if !pools.HasKey(pool) {
+		poolAttr := goworld.MapAttr()
+		poolAttr.SetListAttr("spaces", goworld.ListAttr())
+		pools.SetMapAttr(pool, poolAttr)
+	}
+	return pools.GetMapAttr(pool)
+}
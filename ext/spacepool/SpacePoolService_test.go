@@ -0,0 +1,119 @@
+package spacepool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	gwtesting "github.com/xiaonanln/goworld/testing"
+)
+
+func init() {
+	entity.RegisterSpaceTemplate("raid", &entity.SpaceTemplate{Kind: 1, Data: map[string]interface{}{"level": "$level"}})
+}
+
+type testRequester struct {
+	entity.Entity
+	spaces []common.EntityID
+}
+
+func (r *testRequester) DescribeEntityType(*entity.EntityTypeDesc) {
+}
+
+func (r *testRequester) OnSpaceAcquired(spaceID common.EntityID) {
+	r.spaces = append(r.spaces, spaceID)
+}
+
+func newTestCluster() (*gwtesting.Cluster, *SpacePoolService, *testRequester) {
+	c := gwtesting.New()
+	c.RegisterEntity(ServiceName, &SpacePoolService{})
+	c.RegisterEntity("testRequester", &testRequester{})
+	sps := c.CreateEntity(ServiceName, nil).I.(*SpacePoolService)
+	requester := c.CreateEntity("testRequester", nil).I.(*testRequester)
+	return c, sps, requester
+}
+
+func acquire(c *gwtesting.Cluster, sps *SpacePoolService, requester *testRequester, pool string) common.EntityID {
+	sps.Acquire(pool, requester.ID, "OnSpaceAcquired")
+	c.Deliver()
+	return requester.spaces[len(requester.spaces)-1]
+}
+
+func poolSize(sps *SpacePoolService, pool string) int {
+	return sps.GetMapAttr("pools").GetMapAttr(pool).GetListAttr("spaces").Size()
+}
+
+// assertRaidSpace fails the test if the space is not created from the raid template of the level
+func assertRaidSpace(t *testing.T, spaceID common.EntityID, level int) {
+	t.Helper()
+	e := entity.GetEntity(spaceID)
+	if e == nil || !e.IsSpaceEntity() {
+		t.Fatalf("space %s is not created", spaceID)
+	}
+	if space := e.AsSpace(); space.GetTemplateName() != "raid" || space.GetTemplateData()["level"] != int64(level) {
+		t.Fatalf("space %s is not created from the template: %s %v", spaceID, space.GetTemplateName(), space.GetTemplateData())
+	}
+}
+
+func TestAcquirePrewarmed(t *testing.T) {
+	c, sps, requester := newTestCluster()
+	if spaceID := acquire(c, sps, requester, "raid20"); spaceID != "" {
+		t.Fatalf("space should not be acquired from pool which is never pre-warmed: %s", spaceID)
+	}
+
+	sps.SchedulePrewarm("raid20", "raid", map[string]interface{}{"level": 20}, 3, time.Now().Add(time.Second*2).Unix(), nil)
+	c.Advance(time.Millisecond * 500)
+	if sps.GetMapAttr("pools").HasKey("raid20") {
+		t.Fatalf("pool should not be pre-warmed before scheduled")
+	}
+	c.Advance(time.Second * 3)
+	if size := poolSize(sps, "raid20"); size != 3 {
+		t.Fatalf("pool should be pre-warmed with 3 spaces, but has %d", size)
+	}
+
+	prewarmed := map[common.EntityID]bool{}
+	for _, spaceID := range sps.GetMapAttr("pools").GetMapAttr("raid20").GetListAttr("spaces").ToList() {
+		prewarmed[common.EntityID(spaceID.(string))] = true
+		assertRaidSpace(t, common.EntityID(spaceID.(string)), 20)
+	}
+	for i := 0; i < 3; i++ {
+		spaceID := acquire(c, sps, requester, "raid20")
+		if !prewarmed[spaceID] {
+			t.Fatalf("pre-warmed space should be acquired, but got %s", spaceID)
+		}
+		delete(prewarmed, spaceID)
+	}
+	if size := poolSize(sps, "raid20"); size != 0 {
+		t.Fatalf("pool should be empty after all spaces are acquired, but has %d", size)
+	}
+
+	// spaces are created on demand if the pool is empty
+	spaceID := acquire(c, sps, requester, "raid20")
+	if spaceID == "" {
+		t.Fatalf("space should be created on demand")
+	}
+	assertRaidSpace(t, spaceID, 20)
+}
+
+func TestPrewarmRefill(t *testing.T) {
+	c, sps, requester := newTestCluster()
+	sps.Prewarm("raid30", "raid", map[string]interface{}{"level": 30}, 2, nil)
+	c.Deliver()
+	first := acquire(c, sps, requester, "raid30")
+
+	// the pool is refilled up to the count, spaces left in the pool are kept
+	left := sps.GetMapAttr("pools").GetMapAttr("raid30").GetListAttr("spaces").GetStr(0)
+	sps.Prewarm("raid30", "raid", map[string]interface{}{"level": 30}, 3, []uint16{gwtesting.GameID})
+	c.Deliver()
+	spaces := sps.GetMapAttr("pools").GetMapAttr("raid30").GetListAttr("spaces")
+	if spaces.Size() != 3 || spaces.GetStr(0) != left {
+		t.Fatalf("pool should be refilled to 3 spaces: %v", spaces.ToList())
+	}
+	for _, spaceID := range spaces.ToList() {
+		if common.EntityID(spaceID.(string)) == first {
+			t.Fatalf("acquired space should not be put back to the pool")
+		}
+		assertRaidSpace(t, common.EntityID(spaceID.(string)), 30)
+	}
+}
@@ -0,0 +1,157 @@
+// Package voice mints session tokens of external voice services for channel members
+//
+// Voice rooms of third-party voice services (Agora/Vivox-style) are bound to pub/sub channels of entities, e.g. the
+// voice room of "guild:1001" is joined by subscribers of the channel. Tokens are minted only for subscribers and
+// delivered to clients by OnVoiceToken(channel, token, expire), and clients are told to leave the room by
+// OnVoiceLeave(channel) when they leave the channel, so voice room membership stays consistent with game state. Tokens
+// expire after the TTL, and clients refresh tokens by a client RPC defined by the game, e.g.
+//
+//	func (a *Avatar) RequestVoiceToken_Client(channel string) {
+//		voice.SendToken(&a.Entity, channel)
+//	}
+//
+// HMACMinter mints tokens which can be validated by the game or the voice service sharing the secret. Services with
+// their own token formats are integrated by implementing Minter with the SDKs of the services.
+package voice
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+const (
+	// DefaultTokenTTL is the TTL of tokens if not set by Setup
+	DefaultTokenTTL = time.Hour
+)
+
+// Minter mints tokens for users to join rooms of the voice service
+type Minter interface {
+	Mint(user string, room string, expire time.Time) (string, error)
+}
+
+// LeaveHandler removes the user from the room of the voice service, e.g. by the server API of the service
+type LeaveHandler func(user string, room string)
+
+var (
+	minter       Minter
+	tokenTTL     = DefaultTokenTTL
+	leaveHandler LeaveHandler
+
+	voiceTokens = metrics.NewCounterVec("goworld_voice_tokens_total", "Voice tokens requested, result is minted, denied or failed", "result")
+)
+
+// Setup sets the minter of tokens and the TTL of tokens, 0 for DefaultTokenTTL
+func Setup(m Minter, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultTokenTTL
+	}
+	minter, tokenTTL = m, ttl
+}
+
+// SetLeaveHandler sets the handler called when entities leave channels by Leave
+func SetLeaveHandler(handler LeaveHandler) {
+	leaveHandler = handler
+}
+
+// Join subscribes the channel and sends the token of the voice room to the client of the entity
+func Join(e *entity.Entity, channel string) error {
+	e.SubscribeChannel(channel)
+	return SendToken(e, channel)
+}
+
+// Leave unsubscribes the channel and tells the client of the entity to leave the voice room
+func Leave(e *entity.Entity, channel string) {
+	if !e.IsSubscribedChannel(channel) {
+		return
+	}
+
+	e.UnsubscribeChannel(channel)
+	e.CallClient("OnVoiceLeave", channel)
+	if leaveHandler != nil {
+		leaveHandler(string(e.ID), channel)
+	}
+}
+
+// SendToken sends a token of the voice room to the client of the entity, if the entity subscribes the channel
+func SendToken(e *entity.Entity, channel string) error {
+	if minter == nil {
+		gwlog.Panicf("voice: Setup is not called")
+	}
+	if !e.IsSubscribedChannel(channel) {
+		voiceTokens.With("denied").Inc()
+		return errors.Errorf("voice: %s is not a member of channel %s", e, channel)
+	}
+
+	expire := time.Now().Add(tokenTTL)
+	token, err := minter.Mint(string(e.ID), channel, expire)
+	if err != nil {
+		voiceTokens.With("failed").Inc()
+		return errors.Wrapf(err, "voice: mint token of channel %s for %s failed", channel, e)
+	}
+	voiceTokens.With("minted").Inc()
+	e.CallClient("OnVoiceToken", channel, token, expire.Unix())
+	return nil
+}
+
+// Claims are the claims of tokens minted by HMACMinter
+type Claims struct {
+	AppID  string `json:"app"`
+	User   string `json:"user"`
+	Room   string `json:"room"`
+	Expire int64  `json:"exp"` // unix seconds
+}
+
+// HMACMinter mints tokens in the form of base64url(claims JSON).base64url(HMAC-SHA256 signature)
+type HMACMinter struct {
+	AppID  string
+	Secret []byte
+}
+
+// Mint mints the token of the user to join the room
+func (m *HMACMinter) Mint(user string, room string, expire time.Time) (string, error) {
+	payload, err := json.Marshal(Claims{AppID: m.AppID, User: user, Room: room, Expire: expire.Unix()})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + m.signature(encoded), nil
+}
+
+// Validate checks the signature and expiration of the token, and returns the claims of the token
+func (m *HMACMinter) Validate(token string) (*Claims, error) {
+	sep := strings.LastIndexByte(token, '.')
+	if sep < 0 || !hmac.Equal([]byte(token[sep+1:]), []byte(m.signature(token[:sep]))) {
+		return nil, errors.Errorf("voice: invalid token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:sep])
+	if err != nil {
+		return nil, errors.Wrap(err, "voice: invalid token")
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "voice: invalid token")
+	}
+	if claims.AppID != m.AppID {
+		return nil, errors.Errorf("voice: token of app %s", claims.AppID)
+	}
+	if time.Now().Unix() >= claims.Expire {
+		return nil, errors.Errorf("voice: token expired")
+	}
+	return &claims, nil
+}
+
+func (m *HMACMinter) signature(payload string) string {
+	mac := hmac.New(sha256.New, m.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
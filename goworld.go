@@ -1,6 +1,8 @@
 package goworld
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/xiaonanln/goTimer"
@@ -8,8 +10,11 @@ import (
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gdpr"
+	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/service"
 	"github.com/xiaonanln/goworld/engine/storage"
 )
@@ -17,12 +22,73 @@ import (
 // Export useful types
 type Vector3 = entity.Vector3
 
+// DeliveryClass is the delivery class of client-bound messages
+type DeliveryClass = proto.DeliveryClass
+
+// Delivery classes of client-bound messages
+const (
+	DeliveryOrderedReliable   = proto.DELIVERY_ORDERED_RELIABLE
+	DeliveryUnorderedReliable = proto.DELIVERY_UNORDERED_RELIABLE
+	DeliveryUnreliable        = proto.DELIVERY_UNRELIABLE
+)
+
 // Entity type is the type of any entity in game
 type Entity = entity.Entity
 
 // Space is the type of spaces
 type Space = entity.Space
 
+// SpaceTemplate declares the kind, static data and initial entities of spaces
+type SpaceTemplate = entity.SpaceTemplate
+
+// Archetype is the immutable template data shared by entities and items, e.g. NPC stat blocks and item definitions
+type Archetype = entity.Archetype
+
+// InputState is the state replayed by ReplayInput of entity types for desync detection
+type InputState = entity.InputState
+
+// ClientInput is an input from the own client replayed for desync detection
+type ClientInput = entity.ClientInput
+
+// SpaceDestroyPolicy defines when spaces are destroyed automatically
+type SpaceDestroyPolicy = entity.SpaceDestroyPolicy
+
+// RPCCallback receives results of entity methods called with results, or the error if the call fails
+type RPCCallback = entity.RPCCallback
+
+// RPCError is the error of failed entity RPCs called with results
+type RPCError = entity.RPCError
+
+// RPC error codes of entity RPCs called with results
+const (
+	RPCErrorTimeout        = proto.RPC_ERROR_TIMEOUT
+	RPCErrorEntityNotFound = proto.RPC_ERROR_ENTITY_NOT_FOUND
+	RPCErrorInvalidMethod  = proto.RPC_ERROR_INVALID_METHOD
+	RPCErrorPanic          = proto.RPC_ERROR_PANIC
+	RPCErrorReturned       = proto.RPC_ERROR_RETURNED
+)
+
+// MissedRunPolicy decides how runs of cron jobs missed when the cluster is down are handled
+type MissedRunPolicy = crontab.MissedRunPolicy
+
+// Missed run policies of cron jobs
+const (
+	MissedRunSkip = crontab.MissedRunSkip
+	MissedRunOnce = crontab.MissedRunOnce
+	MissedRunAll  = crontab.MissedRunAll
+)
+
+// ServiceRoutePolicy decides which instance of the service is called by CallService
+type ServiceRoutePolicy = service.RoutePolicy
+
+// Route policies of services
+const (
+	ServiceRouteRandom         = service.RouteRandom
+	ServiceRouteRoundRobin     = service.RouteRoundRobin
+	ServiceRouteConsistentHash = service.RouteConsistentHash
+	ServiceRouteLocalPreferred = service.RouteLocalPreferred
+)
+
 // EntityID is a global unique ID for entities and spaces.
 // EntityID is unique in the whole game server, and also unique across multiple games.
 type EntityID = common.EntityID
@@ -35,6 +101,17 @@ func Run() {
 	game.Run()
 }
 
+// GameOptions are the options of running the game server embedded in another program
+type GameOptions = game.Options
+
+// RunContext runs the game server embedded in another program
+//
+// The game is terminated gracefully when ctx is done, and RunContext returns after the game is terminated.
+// Only one game can run in a process, and gates and dispatchers still run as separate processes.
+func RunContext(ctx context.Context, opts GameOptions) error {
+	return game.RunContext(ctx, opts)
+}
+
 // RegisterEntity registers the entity type so that entities can be created or loaded
 //
 // returns the entity type description object which can be used to define more properties
@@ -43,12 +120,47 @@ func RegisterEntity(typeName string, entityPtr entity.IEntity) *entity.EntityTyp
 	return entity.RegisterEntity(typeName, entityPtr, false)
 }
 
+// GenerateRPCArgsProto writes protobuf messages of typed arguments of RPCs which can be called by clients
+//
+// Entity types should be registered before. Running the game with -rpc-args-proto writes the same messages to stdout.
+func GenerateRPCArgsProto(w io.Writer) error {
+	return entity.GenerateRPCArgsProto(w)
+}
+
 // RegisterService registeres an service type
 // After registeration, the service entity will be created automatically on some game
 func RegisterService(typeName string, entityPtr entity.IEntity, shardCount int) {
 	service.RegisterService(typeName, entityPtr, shardCount)
 }
 
+// EnableServiceStandby keeps a warm standby of each shard of the registered service on another game
+//
+// Snapshots of service entity attributes are sent to the standby game every snapshotInterval. When the game of a service
+// entity is down, calls are redirected to the standby game, which recreates the service entity from the last snapshot.
+func EnableServiceStandby(serviceName string, snapshotInterval time.Duration) {
+	service.EnableStandby(serviceName, snapshotInterval)
+}
+
+// SetServiceRoutePolicy sets how calls by CallService are routed to instances (shards) of the registered service
+//
+// ServiceRouteConsistentHash routes calls by the first argument, e.g. the player ID.
+func SetServiceRoutePolicy(serviceName string, policy ServiceRoutePolicy) {
+	service.SetRoutePolicy(serviceName, policy)
+}
+
+// EnableServiceSpreadPlacement spreads instances (shards) of the registered service evenly across games
+//
+// Each instance prefers game (ShardIndex % DesiredGames + 1), and is hosted by another game if the preferred game is not running.
+func EnableServiceSpreadPlacement(serviceName string) {
+	service.EnableSpreadPlacement(serviceName)
+}
+
+// SetServicePlacementLabels constrains instances (shards) of the registered service to games with all labels of the
+// selector, e.g. "tier:highmem"
+func SetServicePlacementLabels(serviceName string, selector string) {
+	service.SetPlacementLabels(serviceName, selector)
+}
+
 // CreateSpaceAnywhere creates a space with specified kind in any game server
 func CreateSpaceAnywhere(kind int) EntityID {
 	return entity.CreateSpaceSomewhere(0, kind)
@@ -68,6 +180,37 @@ func CreateSpaceOnGame(gameid uint16, kind int) EntityID {
 	return entity.CreateSpaceSomewhere(gameid, kind)
 }
 
+// CreateSpaceOnLabeledGame creates a space with specified kind on a random online game with all labels of the selector,
+// e.g. "tier:highmem", which are set by labels in game sections of the config
+//
+// returns the space EntityID, or "" if no online game matches
+func CreateSpaceOnLabeledGame(kind int, selector string) EntityID {
+	gameid := game.ChooseGameByLabels(selector)
+	if gameid == 0 {
+		gwlog.Errorf("CreateSpaceOnLabeledGame: no online game matches labels %q", selector)
+		return ""
+	}
+	return entity.CreateSpaceSomewhere(gameid, kind)
+}
+
+// CreateSpaceFromTemplate creates a space from the registered template in any game server
+//
+// String values of the template which begin with $ are replaced by params (e.g. "$level" by params["level"]).
+// Initial entities of the template are created after OnSpaceCreated is called.
+func CreateSpaceFromTemplate(name string, params map[string]interface{}) EntityID {
+	return entity.CreateSpaceFromTemplateSomewhere(0, name, params)
+}
+
+// CreateSpaceFromTemplateOnGame creates a space from the registered template on the specified game
+func CreateSpaceFromTemplateOnGame(gameid uint16, name string, params map[string]interface{}) EntityID {
+	return entity.CreateSpaceFromTemplateSomewhere(gameid, name, params)
+}
+
+// CreateSpaceFromTemplateLocally creates a space from the registered template in the local game server
+func CreateSpaceFromTemplateLocally(name string, params map[string]interface{}) *Space {
+	return entity.CreateSpaceFromTemplateLocally(name, params)
+}
+
 // CreateEntityLocally creates a entity on the local server
 //
 // returns EntityID
@@ -80,10 +223,38 @@ func CreateEntityAnywhere(typeName string) EntityID {
 	return entity.CreateEntitySomewhere(0, typeName)
 }
 
+// QueuedCreateOptions are options of CreateEntityAnywhereQueued
+type QueuedCreateOptions = entity.QueuedCreateOptions
+
+// CreateEntityAnywhereQueued creates a entity on any server which is not overloaded
+//
+// The creation waits in the creation queue of the dispatcher if all servers are overloaded, and is rejected if it times
+// out or the queue is full
+func CreateEntityAnywhereQueued(typeName string, opts QueuedCreateOptions) EntityID {
+	return entity.CreateEntityAnywhereQueued(typeName, opts)
+}
+
 func CreateEntityOnGame(gameid uint16, typeName string) EntityID {
 	return entity.CreateEntitySomewhere(gameid, typeName)
 }
 
+// CreateEntityOnLabeledGame creates a entity on a random online game with all labels of the selector, e.g. "region:eu"
+//
+// returns EntityID, or "" if no online game matches
+func CreateEntityOnLabeledGame(typeName string, selector string) EntityID {
+	gameid := game.ChooseGameByLabels(selector)
+	if gameid == 0 {
+		gwlog.Errorf("CreateEntityOnLabeledGame: no online game matches labels %q", selector)
+		return ""
+	}
+	return entity.CreateEntitySomewhere(gameid, typeName)
+}
+
+// ChooseGameByLabels returns a random online game with all labels of the selector, or 0 if no online game matches
+func ChooseGameByLabels(selector string) uint16 {
+	return game.ChooseGameByLabels(selector)
+}
+
 // LoadEntityAnywhere loads the specified entity from entity storage
 func LoadEntityAnywhere(typeName string, entityID EntityID) {
 	entity.LoadEntityAnywhere(typeName, entityID)
@@ -108,6 +279,39 @@ func Exists(typeName string, entityID EntityID, callback storage.ExistsCallbackF
 	storage.Exists(typeName, entityID, callback)
 }
 
+// LoadEntityDataAsync loads entity data from storage in the async worker pool, operations of different entities run concurrently
+func LoadEntityDataAsync(typeName string, entityID EntityID, callback storage.LoadCallbackFunc) {
+	storage.LoadAsync(typeName, entityID, callback)
+}
+
+// SaveEntityDataAsync saves data of entity which is not loaded to storage in the async worker pool
+func SaveEntityDataAsync(typeName string, entityID EntityID, data map[string]interface{}, callback storage.SaveAsyncCallbackFunc) {
+	storage.SaveAsync(typeName, entityID, data, callback)
+}
+
+// DeleteEntityData moves entity data in entity storage to trash
+//
+// Deleted entity data can be restored by RestoreEntityData before trash retention expires
+func DeleteEntityData(typeName string, entityID EntityID, callback storage.DeleteCallbackFunc) {
+	storage.Delete(typeName, entityID, callback)
+}
+
+// RestoreEntityData restores deleted entity data from trash
+func RestoreEntityData(typeName string, entityID EntityID, callback storage.RestoreCallbackFunc) {
+	storage.Restore(typeName, entityID, callback)
+}
+
+// LoadPreviousEntityData loads entity data from the previous data namespace ([storage].previous_namespace), e.g. for
+// carrying over data of the last season
+func LoadPreviousEntityData(typeName string, entityID EntityID, callback storage.LoadCallbackFunc) {
+	storage.LoadPrevious(typeName, entityID, callback)
+}
+
+// ExistsPrevious checks if entityID exists in the previous data namespace ([storage].previous_namespace)
+func ExistsPrevious(typeName string, entityID EntityID, callback storage.ExistsCallbackFunc) {
+	storage.ExistsPrevious(typeName, entityID, callback)
+}
+
 // GetEntity gets the entity by EntityID
 func GetEntity(id EntityID) *Entity {
 	return entity.GetEntity(id)
@@ -143,6 +347,31 @@ func RegisterSpace(spacePtr entity.ISpace) {
 	entity.RegisterSpace(spacePtr)
 }
 
+// RegisterSpaceTemplate registers the space template, which should be registered on all games
+func RegisterSpaceTemplate(name string, template *SpaceTemplate) {
+	entity.RegisterSpaceTemplate(name, template)
+}
+
+// LoadSpaceTemplates registers space templates from the JSON file, which is an object of template names to templates
+func LoadSpaceTemplates(file string) error {
+	return entity.LoadSpaceTemplates(file)
+}
+
+// RegisterArchetype registers the archetype, which should be registered on all games
+func RegisterArchetype(name string, archetype *Archetype) {
+	entity.RegisterArchetype(name, archetype)
+}
+
+// LoadArchetypes registers archetypes from the JSON file, which is an object of archetype names to archetypes
+func LoadArchetypes(file string) error {
+	return entity.LoadArchetypes(file)
+}
+
+// GetArchetype returns the registered archetype of the name, or nil if not registered
+func GetArchetype(name string) *Archetype {
+	return entity.GetArchetype(name)
+}
+
 // Entities gets all entities as an EntityMap (do not modify it!)
 func Entities() entity.EntityMap {
 	return entity.Entities()
@@ -153,6 +382,11 @@ func Call(id EntityID, method string, args ...interface{}) {
 	entity.Call(id, method, args)
 }
 
+// CallWithResult calls other entities, results of the method or the error are delivered to the callback within the timeout
+func CallWithResult(id EntityID, method string, timeout time.Duration, callback RPCCallback, args ...interface{}) {
+	entity.CallWithResult(id, method, args, timeout, callback)
+}
+
 // CallServiceAny calls the method of a random service entity
 func CallServiceAny(serviceName string, method string, args ...interface{}) {
 	service.CallServiceAny(serviceName, method, args)
@@ -173,6 +407,31 @@ func CallServiceShardKey(serviceName string, shardKey string, method string, arg
 	service.CallServiceShardKey(serviceName, shardKey, method, args)
 }
 
+// CallServiceAnyWithResult calls the method of a random service entity with results
+func CallServiceAnyWithResult(serviceName string, method string, timeout time.Duration, callback RPCCallback, args ...interface{}) {
+	service.CallServiceAnyWithResult(serviceName, method, args, timeout, callback)
+}
+
+// CallServiceShardKeyWithResult calls the method of the service entity specified by shard key (string) with results
+func CallServiceShardKeyWithResult(serviceName string, shardKey string, method string, timeout time.Duration, callback RPCCallback, args ...interface{}) {
+	service.CallServiceShardKeyWithResult(serviceName, shardKey, method, args, timeout, callback)
+}
+
+// CallService calls the method of the service instance chosen by the route policy of the service
+func CallService(serviceName string, method string, args ...interface{}) {
+	service.CallService(serviceName, method, args)
+}
+
+// CallServiceWithResult calls the method of the service instance chosen by the route policy of the service with results
+func CallServiceWithResult(serviceName string, method string, timeout time.Duration, callback RPCCallback, args ...interface{}) {
+	service.CallServiceWithResult(serviceName, method, args, timeout, callback)
+}
+
+// GetServiceGameID returns the game hosting the service instance (shard), 0 if the instance is not registered yet
+func GetServiceGameID(serviceName string, shardIndex int) uint16 {
+	return service.GetServiceGameID(serviceName, shardIndex)
+}
+
 // GetServiceEntityID returns the entityid of the service
 func GetServiceEntityID(serviceName string, shardIndex int) common.EntityID {
 	return service.GetServiceEntityID(serviceName, shardIndex)
@@ -193,6 +452,16 @@ func CallNilSpaces(method string, args ...interface{}) {
 	entity.CallNilSpaces(method, args, game.GetGameID())
 }
 
+// Publish calls the method on all entities subscribing the channel on all games
+func Publish(channel string, method string, args ...interface{}) {
+	entity.Publish(channel, method, args)
+}
+
+// PublishToClients calls the client method on clients of all entities subscribing the channel on all games
+func PublishToClients(channel string, method string, args ...interface{}) {
+	entity.PublishToClients(channel, method, args)
+}
+
 // GetNilSpaceID returns the Entity ID of nil space on the specified game
 func GetNilSpaceID(gameid uint16) EntityID {
 	return entity.GetNilSpaceID(gameid)
@@ -222,6 +491,72 @@ func GetOrPutKVDB(key string, val string, callback kvdb.KVDBGetOrPutCallback) {
 	kvdb.GetOrPut(key, val, callback)
 }
 
+// DelKVDB deletes key from KVDB
+func DelKVDB(key string, callback kvdb.KVDBDelCallback) {
+	kvdb.Del(key, callback)
+}
+
+// GetKVDBAsync gets value of key from KVDB in the async worker pool, operations of different keys run concurrently
+func GetKVDBAsync(key string, callback kvdb.KVDBGetCallback) {
+	kvdb.GetAsync(key, callback)
+}
+
+// PutKVDBAsync puts key-value to KVDB in the async worker pool
+func PutKVDBAsync(key string, val string, callback kvdb.KVDBPutCallback) {
+	kvdb.PutAsync(key, val, callback)
+}
+
+// DelKVDBAsync deletes key from KVDB in the async worker pool
+func DelKVDBAsync(key string, callback kvdb.KVDBDelCallback) {
+	kvdb.DelAsync(key, callback)
+}
+
+// CompareAndSwapKVDB sets key to newVal if the value of key is oldVal ("" for not exists)
+func CompareAndSwapKVDB(key string, oldVal string, newVal string, callback kvdb.KVDBCompareAndSwapCallback) {
+	kvdb.CompareAndSwap(key, oldVal, newVal, callback)
+}
+
+// IncrKVDB adds delta to the integer value of key, and returns the new value
+func IncrKVDB(key string, delta int64, callback kvdb.KVDBIncrCallback) {
+	kvdb.Incr(key, delta, callback)
+}
+
+// PutKVDBWithTTL puts key-value which expires after ttl to KVDB
+func PutKVDBWithTTL(key string, val string, ttl time.Duration, callback kvdb.KVDBExpireCallback) {
+	kvdb.PutWithTTL(key, val, ttl, callback)
+}
+
+// ExpireKVDB sets key to expire after ttl
+func ExpireKVDB(key string, ttl time.Duration, callback kvdb.KVDBExpireCallback) {
+	kvdb.Expire(key, ttl, callback)
+}
+
+// GetPreviousKVDB gets value of key from the previous data namespace of KVDB ([kvdb].previous_namespace)
+func GetPreviousKVDB(key string, callback kvdb.KVDBGetCallback) {
+	kvdb.GetPrevious(key, callback)
+}
+
+// GetRangePreviousKVDB gets key-value items in [beginKey, endKey) from the previous data namespace of KVDB
+func GetRangePreviousKVDB(beginKey string, endKey string, callback kvdb.KVDBGetRangeCallback) {
+	kvdb.GetRangePrevious(beginKey, endKey, callback)
+}
+
+// RegisterOwnershipMapping registers which records are owned by entities of the type,
+// which is used by ExportAccountData and DeleteAccountData to find all records of an account
+func RegisterOwnershipMapping(typeName string, mapping gdpr.OwnershipMapping) {
+	gdpr.RegisterOwnershipMapping(typeName, mapping)
+}
+
+// ExportAccountData exports all records owned by the account as a zip archive
+func ExportAccountData(accountID EntityID, callback gdpr.ExportCallback) {
+	gdpr.ExportAccountData(accountID, callback)
+}
+
+// DeleteAccountData deletes all records owned by the account and verifies they are purged
+func DeleteAccountData(accountID EntityID, callback gdpr.DeleteCallback) {
+	gdpr.DeleteAccountData(accountID, callback)
+}
+
 // GetOnlineGames returns all online game IDs
 func GetOnlineGames() common.Uint16Set {
 	return game.GetOnlineGames()
@@ -254,3 +589,23 @@ func Post(callback post.PostCallback) {
 func RegisterCrontab(minute, hour, day, month, dayofweek int, cb func()) {
 	crontab.Register(minute, hour, day, month, dayofweek, cb)
 }
+
+// AddCronJob adds a cron job which is executed by only one game of the cluster at times matching the cron expression
+//
+// The job should be added with the same name on all games before calling Run. Runs missed when the cluster is down
+// are skipped. e.x. AddCronJob("0 4 * * *", "DailyReset", handler) executes handler at 04:00 every day
+func AddCronJob(expr string, name string, handler func(runTime time.Time)) {
+	crontab.AddCronJob(expr, name, crontab.MissedRunSkip, handler)
+}
+
+// AddCronJobWithPolicy adds a cron job like AddCronJob, runs missed when the cluster is down are handled by the policy
+//
+// KVDB should be configured for detecting missed runs.
+func AddCronJobWithPolicy(expr string, name string, policy MissedRunPolicy, handler func(runTime time.Time)) {
+	crontab.AddCronJob(expr, name, policy, handler)
+}
+
+// RemoveCronJob removes the cron job from this game
+func RemoveCronJob(name string) {
+	crontab.RemoveCronJob(name)
+}
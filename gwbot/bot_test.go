@@ -0,0 +1,97 @@
+package gwbot
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/netconnutil"
+)
+
+// fakeGate accepts one bot, creates the player on it, and replies RPCs of the bot
+func fakeGate(t *testing.T, ln net.Listener, playerID common.EntityID) {
+	netconn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept failed: %v", err)
+		return
+	}
+	conn := proto.NewGoWorldConnection(netconnutil.NewBufferedConn(netutil.NetConn{Conn: netconn}, consts.BUFFERED_READ_BUFFSIZE, consts.BUFFERED_WRITE_BUFFSIZE))
+	defer conn.Close()
+
+	clientid := common.GenClientID()
+	conn.SendCreateEntityOnClient(1, clientid, "Avatar", playerID, true, map[string]interface{}{
+		"name":  "bot",
+		"items": []interface{}{"sword"},
+	}, 1, 2, 3, 0)
+	conn.SendNotifyMapAttrChangeOnClient(1, clientid, playerID, nil, "level", 10)
+	conn.SendNotifyListAttrAppendOnClient(1, clientid, playerID, []interface{}{"items"}, "shield")
+	conn.Flush("test")
+
+	for {
+		var msgtype proto.MsgType
+		pkt, err := conn.Recv(&msgtype)
+		if err != nil {
+			return
+		}
+		if msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT {
+			eid := pkt.ReadEntityID()
+			method := pkt.ReadVarStr()
+			args := pkt.ReadArgs()
+			var n int
+			netutil.MSG_PACKER.UnpackMsg(args[0], &n)
+			conn.SendCallEntityMethodOnClient(1, clientid, eid, "On"+method, []interface{}{n + 1})
+			conn.Flush("test")
+		}
+		pkt.Release()
+	}
+}
+
+func TestBot(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	playerID := common.GenEntityID()
+	go fakeGate(t, ln, playerID)
+
+	bot, err := Connect(1, Config{Addr: ln.Addr().String(), DialTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	defer bot.Close()
+
+	player, err := bot.WaitPlayer("Avatar", time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.ID != playerID || player.Position().Y != 2 {
+		t.Fatalf("wrong player: %s at %v", player, player.Position())
+	}
+
+	reply, err := bot.CallAndWait(player.ID, "Echo", "OnEcho", time.Second*5, 41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply) != 1 || fmt.Sprint(reply[0]) != "42" { // integers are decoded as the smallest types
+		t.Fatalf("wrong reply: %#v", reply)
+	}
+
+	// attribute changes are received before the reply
+	if player.Attr("name") != "bot" || player.Attr("level") == nil {
+		t.Fatalf("wrong attributes: name=%v, level=%v", player.Attr("name"), player.Attr("level"))
+	}
+	if items, ok := player.Attr("items").([]interface{}); !ok || len(items) != 2 || items[1] != "shield" {
+		t.Fatalf("wrong items: %v", player.Attr("items"))
+	}
+
+	stats := TakeStats()
+	if stats.RPCs != 1 || stats.Connected != 1 {
+		t.Fatalf("wrong stats: %+v", stats)
+	}
+}
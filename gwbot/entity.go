@@ -0,0 +1,158 @@
+package gwbot
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Entity is an entity created on a bot, attributes and positions are synced by the receive routine of the bot
+type Entity struct {
+	ID       common.EntityID
+	TypeName string
+	IsPlayer bool
+
+	bot   *Bot
+	attrs map[string]interface{}
+	pos   entity.Vector3
+	yaw   entity.Yaw
+}
+
+func (e *Entity) String() string {
+	return e.TypeName + "<" + string(e.ID) + ">"
+}
+
+// Position returns the position of the entity
+func (e *Entity) Position() entity.Vector3 {
+	e.bot.Lock()
+	defer e.bot.Unlock()
+	return e.pos
+}
+
+// Yaw returns the yaw of the entity
+func (e *Entity) Yaw() entity.Yaw {
+	e.bot.Lock()
+	defer e.bot.Unlock()
+	return e.yaw
+}
+
+// Attr returns the top-level attribute of the entity, map and list attributes should not be modified
+func (e *Entity) Attr(key string) interface{} {
+	e.bot.Lock()
+	defer e.bot.Unlock()
+	return e.attrs[key]
+}
+
+// applyAttrOp applies the attribute change to the entity, bot should be locked
+func (e *Entity) applyAttrOp(op proto.AttrPatchOp) error {
+	var attr interface{} = e.attrs
+	// paths are from the attribute to the root
+	for i := len(op.Path) - 1; i >= 0; i-- {
+		switch a := attr.(type) {
+		case map[string]interface{}:
+			key, ok := op.Path[i].(string)
+			if !ok {
+				return errors.Errorf("invalid path %v", op.Path)
+			}
+			attr = a[key]
+		case []interface{}:
+			index, ok := pathIndex(op.Path[i])
+			if !ok || index < 0 || index >= len(a) {
+				return errors.Errorf("invalid path %v", op.Path)
+			}
+			attr = a[index]
+		default:
+			return errors.Errorf("invalid path %v", op.Path)
+		}
+	}
+
+	switch op.Op {
+	case proto.ATTR_PATCH_MAP_CHANGE, proto.ATTR_PATCH_MAP_DEL, proto.ATTR_PATCH_MAP_CLEAR:
+		m, ok := attr.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("attribute at %v is not a map", op.Path)
+		}
+		switch op.Op {
+		case proto.ATTR_PATCH_MAP_CHANGE:
+			m[op.Key] = op.Val
+		case proto.ATTR_PATCH_MAP_DEL:
+			delete(m, op.Key)
+		default:
+			for k := range m {
+				delete(m, k)
+			}
+		}
+	case proto.ATTR_PATCH_LIST_CHANGE:
+		l, ok := attr.([]interface{})
+		if !ok || int(op.Index) >= len(l) {
+			return errors.Errorf("attribute at %v is not a list of %d items", op.Path, op.Index+1)
+		}
+		l[op.Index] = op.Val
+	case proto.ATTR_PATCH_LIST_APPEND, proto.ATTR_PATCH_LIST_POP:
+		// lists are replaced in their parents, since appending and popping change the slice headers
+		l, ok := attr.([]interface{})
+		if !ok {
+			return errors.Errorf("attribute at %v is not a list", op.Path)
+		}
+		if op.Op == proto.ATTR_PATCH_LIST_APPEND {
+			l = append(l, op.Val)
+		} else if len(l) > 0 {
+			l = l[:len(l)-1]
+		}
+		return e.replaceAttr(op.Path, l)
+	}
+	return nil
+}
+
+// replaceAttr replaces the attribute at the path, the path should be valid
+func (e *Entity) replaceAttr(path []interface{}, val interface{}) error {
+	if len(path) == 0 {
+		return errors.Errorf("can not replace attributes of entity")
+	}
+
+	var parent interface{} = e.attrs
+	for i := len(path) - 1; i >= 1; i-- {
+		switch a := parent.(type) {
+		case map[string]interface{}:
+			parent = a[path[i].(string)]
+		case []interface{}:
+			index, _ := pathIndex(path[i])
+			parent = a[index]
+		}
+	}
+
+	switch a := parent.(type) {
+	case map[string]interface{}:
+		a[path[0].(string)] = val
+	case []interface{}:
+		index, _ := pathIndex(path[0])
+		a[index] = val
+	}
+	return nil
+}
+
+// pathIndex returns the list index in the path, which is decoded as an integer of any size
+func pathIndex(v interface{}) (int, bool) {
+	switch i := v.(type) {
+	case int:
+		return i, true
+	case int8:
+		return int(i), true
+	case int16:
+		return int(i), true
+	case int32:
+		return int(i), true
+	case int64:
+		return int(i), true
+	case uint8:
+		return int(i), true
+	case uint16:
+		return int(i), true
+	case uint32:
+		return int(i), true
+	case uint64:
+		return int(i), true
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package gwbot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+var (
+	connectSuccesses = metrics.NewCounter("goworld_bot_connects_total", "Bots connected to gates")
+	connectFailures  = metrics.NewCounter("goworld_bot_connect_failures_total", "Bots failed to connect to gates")
+	connectedBots    = metrics.NewGauge("goworld_bot_connected", "Number of bots connected to gates")
+	rpcTimeouts      = metrics.NewCounter("goworld_bot_rpc_timeouts_total", "RPCs of bots timed out waiting for replies")
+	rpcLatency       = metrics.NewHistogram("goworld_bot_rpc_latency_seconds", "Round-trip latencies of RPCs of bots", metrics.DefBuckets)
+
+	statsLock       sync.Mutex
+	rpcLatencySum   time.Duration
+	rpcLatencyMax   time.Duration
+	rpcLatencyCount int64
+)
+
+// Stats is the statistics of all bots in the process
+type Stats struct {
+	Connected      int     // bots connected now
+	ConnectSuccess float64 // successful connects since the process is started
+	ConnectFailure float64 // failed connects since the process is started
+	RPCTimeouts    float64 // RPCs timed out since the process is started
+	RPCs           int64   // RPCs replied since the last TakeStats
+	RPCLatencyAvg  time.Duration
+	RPCLatencyMax  time.Duration
+}
+
+func observeRPCLatency(latency time.Duration) {
+	rpcLatency.Observe(latency.Seconds())
+	statsLock.Lock()
+	rpcLatencySum += latency
+	rpcLatencyCount += 1
+	if latency > rpcLatencyMax {
+		rpcLatencyMax = latency
+	}
+	statsLock.Unlock()
+}
+
+// TakeStats returns the statistics of all bots, RPC latencies are reset
+func TakeStats() Stats {
+	stats := Stats{
+		Connected:      int(connectedBots.Value()),
+		ConnectSuccess: connectSuccesses.Value(),
+		ConnectFailure: connectFailures.Value(),
+		RPCTimeouts:    rpcTimeouts.Value(),
+	}
+
+	statsLock.Lock()
+	stats.RPCs, stats.RPCLatencyMax = rpcLatencyCount, rpcLatencyMax
+	if rpcLatencyCount > 0 {
+		stats.RPCLatencyAvg = rpcLatencySum / time.Duration(rpcLatencyCount)
+	}
+	rpcLatencySum, rpcLatencyMax, rpcLatencyCount = 0, 0, 0
+	statsLock.Unlock()
+	return stats
+}
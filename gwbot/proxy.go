@@ -0,0 +1,97 @@
+package gwbot
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// dial connects the address directly, or through the proxy if configured
+func dial(addr string, proxyURL string, timeout time.Duration) (net.Conn, error) {
+	if proxyURL == "" {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid proxy %s", proxyURL)
+	}
+	forward := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, forward)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial("tcp", addr)
+	case "http":
+		return dialHTTPConnect(u, addr, forward)
+	default:
+		return nil, errors.Errorf("unsupported proxy %s, should be socks5://host:port or http://host:port", proxyURL)
+	}
+}
+
+// dialHTTPConnect connects the address through the HTTP proxy by the CONNECT method
+func dialHTTPConnect(u *url.URL, addr string, forward *net.Dialer) (net.Conn, error) {
+	conn, err := forward.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if forward.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(forward.Timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "write CONNECT request failed")
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "read CONNECT response failed")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("proxy %s refused CONNECT %s: %s", u.Host, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 { // data sent by the gate is read with the response
+		return &bufferedReadConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedReadConn is a connection reading buffered data first
+type bufferedReadConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedReadConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,116 @@
+package gwbot
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeHTTPProxy serves one CONNECT request
+func fakeHTTPProxy(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		t.Errorf("bad CONNECT request: %v, %v", req, err)
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic cWE6c2VjcmV0" {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, br)
+	io.Copy(conn, target)
+}
+
+// fakeSOCKS5Proxy serves one CONNECT request without authentication
+func fakeSOCKS5Proxy(t *testing.T, ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		t.Errorf("bad SOCKS5 greeting: %v", err)
+		return
+	}
+	io.ReadFull(conn, buf[:buf[1]])
+	conn.Write([]byte{5, 0})
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil || buf[1] != 1 || buf[3] != 1 {
+		t.Errorf("bad SOCKS5 request: %v %v", buf[:4], err)
+		return
+	}
+	io.ReadFull(conn, buf[:6])
+	addr := net.JoinHostPort(net.IP(buf[:4]).String(), strconv.Itoa(int(buf[4])<<8|int(buf[5])))
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func TestDialProxy(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	for _, p := range []struct {
+		scheme string
+		serve  func(t *testing.T, ln net.Listener)
+	}{{"http://qa:secret@", fakeHTTPProxy}, {"socks5://", fakeSOCKS5Proxy}} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go p.serve(t, ln)
+
+		conn, err := dial(echo.Addr().String(), p.scheme+ln.Addr().String(), time.Second*5)
+		if err != nil {
+			t.Fatalf("dial through %s proxy failed: %v", p.scheme, err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		io.WriteString(conn, "ping")
+		reply := make([]byte, 4)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+			t.Errorf("echo through %s proxy failed: %q, %v", p.scheme, reply, err)
+		}
+		conn.Close()
+		ln.Close()
+	}
+
+	if _, err := dial(echo.Addr().String(), "ftp://127.0.0.1:21", time.Second); err == nil {
+		t.Errorf("unsupported proxy should fail")
+	}
+}
@@ -0,0 +1,147 @@
+package testing
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// ClientPacket is a packet sent to a client, fields which are not in packets of the message type are left zero
+type ClientPacket struct {
+	MsgType  proto.MsgType
+	EntityID common.EntityID
+	TypeName string                 // MT_CREATE_ENTITY_ON_CLIENT, MT_DESTROY_ENTITY_ON_CLIENT
+	IsPlayer bool                   // MT_CREATE_ENTITY_ON_CLIENT
+	Data     map[string]interface{} // client attributes of MT_CREATE_ENTITY_ON_CLIENT
+	Method   string                 // MT_CALL_ENTITY_METHOD_ON_CLIENT
+	Args     []interface{}          // MT_CALL_ENTITY_METHOD_ON_CLIENT
+	Path     []interface{}          // MT_NOTIFY_*_ATTR_*_ON_CLIENT
+	Key      string                 // MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT, MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT
+	Index    uint32                 // MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT
+	Val      interface{}            // MT_NOTIFY_*_ATTR_CHANGE_ON_CLIENT, MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT
+	Patch    *proto.AttrPatch       // MT_NOTIFY_ATTR_PATCH_ON_CLIENT
+}
+
+// Client is a fake client connected to an entity of the cluster, which records packets sent to it
+type Client struct {
+	ID      common.ClientID
+	cluster *Cluster
+	packets []*ClientPacket
+}
+
+// Call calls the entity method as the client, and delivers all packets sent by the call
+func (c *Client) Call(id common.EntityID, method string, args ...interface{}) {
+	entity.OnCall(id, method, packArgs(args), c.ID)
+	c.cluster.Deliver()
+}
+
+// Packets returns packets received by the client
+func (c *Client) Packets() []*ClientPacket {
+	return c.packets
+}
+
+// TakePackets returns packets received by the client, and clears them
+func (c *Client) TakePackets() []*ClientPacket {
+	packets := c.packets
+	c.packets = nil
+	return packets
+}
+
+// Calls returns calls of the method on the client
+func (c *Client) Calls(method string) []*ClientPacket {
+	var calls []*ClientPacket
+	for _, p := range c.packets {
+		if p.MsgType == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT && p.Method == method {
+			calls = append(calls, p)
+		}
+	}
+	return calls
+}
+
+// AssertCalled fails the test if the method is not called on the client
+func (c *Client) AssertCalled(t TB, method string) *ClientPacket {
+	t.Helper()
+	calls := c.Calls(method)
+	if len(calls) == 0 {
+		t.Fatalf("%s is not called on client %s", method, c.ID)
+		return nil
+	}
+	return calls[len(calls)-1]
+}
+
+// readClientPacket reads the packet sent to the client, the gate ID and client ID are already read
+func readClientPacket(msgtype proto.MsgType, pkt *netutil.Packet) *ClientPacket {
+	p := &ClientPacket{MsgType: msgtype}
+	switch msgtype {
+	case proto.MT_CREATE_ENTITY_ON_CLIENT:
+		p.IsPlayer = pkt.ReadBool()
+		p.EntityID = pkt.ReadEntityID()
+		p.TypeName = pkt.ReadVarStr()
+		_, _, _, _ = pkt.ReadFloat32(), pkt.ReadFloat32(), pkt.ReadFloat32(), pkt.ReadFloat32() // x, y, z, yaw
+		pkt.ReadData(&p.Data)
+	case proto.MT_DESTROY_ENTITY_ON_CLIENT:
+		p.TypeName = pkt.ReadVarStr()
+		p.EntityID = pkt.ReadEntityID()
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY:
+		p.MsgType = proto.MT_CALL_ENTITY_METHOD_ON_CLIENT // converted by gates
+		_ = pkt.ReadOneByte()                             // delivery
+		fallthrough
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		p.Method = pkt.ReadVarStr()
+		p.Args = unpackArgs(pkt.ReadArgs())
+	case proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		pkt.ReadData(&p.Path)
+		p.Key = pkt.ReadVarStr()
+		pkt.ReadData(&p.Val)
+	case proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		pkt.ReadData(&p.Path)
+		p.Key = pkt.ReadVarStr()
+	case proto.MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT, proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		pkt.ReadData(&p.Path)
+	case proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		pkt.ReadData(&p.Path)
+		p.Index = pkt.ReadUint32()
+		pkt.ReadData(&p.Val)
+	case proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		pkt.ReadData(&p.Path)
+		pkt.ReadData(&p.Val)
+	case proto.MT_NOTIFY_ATTR_PATCH_ON_CLIENT:
+		p.EntityID = pkt.ReadEntityID()
+		patch, err := proto.DecodeAttrPatch(pkt.UnreadPayload())
+		if err != nil {
+			gwlog.Panic(err)
+		}
+		p.Patch = patch
+	}
+	return p
+}
+
+func packArgs(args []interface{}) [][]byte {
+	packed := make([][]byte, len(args))
+	for i, arg := range args {
+		data, err := netutil.MSG_PACKER.PackMsg(arg, nil)
+		if err != nil {
+			gwlog.Panicf("pack argument %d (%v) failed: %s", i, arg, err)
+		}
+		packed[i] = data
+	}
+	return packed
+}
+
+func unpackArgs(args [][]byte) []interface{} {
+	unpacked := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err := netutil.MSG_PACKER.UnpackMsg(arg, &unpacked[i]); err != nil {
+			gwlog.Panicf("unpack argument %d failed: %s", i, err)
+		}
+	}
+	return unpacked
+}
@@ -0,0 +1,294 @@
+// Package testing runs a single-process cluster for fast and deterministic unit tests of entities
+//
+// The cluster runs entities of one game in the test process. Packets sent by the game to dispatchers are written to an
+// in-memory connection, and delivered synchronously by the cluster: RPCs, entity creations and channel messages are
+// handled by the game as if they were routed back by dispatchers, and packets to clients are recorded by fake clients.
+// Timers of entities are fired by a fake clock, which only moves forward by Advance.
+//
+//	c := testing.New()
+//	c.RegisterEntity("Avatar", &Avatar{})
+//	avatar := c.CreateEntity("Avatar", nil)
+//	client := c.ConnectClient(avatar)
+//	client.Call(avatar.ID, "Jump")
+//	c.Advance(time.Second)
+//	c.AssertAttr(t, avatar, "jumps", 1)
+//	client.AssertCalled(t, "OnJump")
+//
+// Clusters share the entity system of the process, so only one cluster can be used at a time, and tests using
+// clusters should not run in parallel. Creating a new cluster destroys entities of the previous one.
+package testing
+
+import (
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/timerwheel"
+)
+
+const (
+	// GameID is the game ID of the cluster
+	GameID = 1
+	// GateID is the gate ID of clients of the cluster
+	GateID = 1
+
+	_MAX_DELIVER_ROUNDS = 1000 // entities calling each other endlessly
+)
+
+// TB is the interface of *testing.T and *testing.B used by assertions
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+// Cluster is a single-process cluster with in-memory transport and fake clock
+type Cluster struct {
+	clients map[common.ClientID]*Client
+}
+
+var (
+	conn     *memConn
+	recvConn *proto.GoWorldConnection // receives packets sent by the game to dispatchers
+	now      time.Time
+)
+
+// New creates the cluster, entities of the previous cluster are destroyed
+func New() *Cluster {
+	if conn == nil {
+		conn = &memConn{}
+		recvConn = proto.NewGoWorldConnection(conn)
+		now = time.Now()
+		timerwheel.SetClock(func() time.Time {
+			return now
+		})
+		dispatchercluster.InitializeWithConns(GameID, []netutil.Connection{conn})
+		if entity.GetEntityTypeDesc("__space__") == nil {
+			entity.RegisterSpace(&entity.Space{})
+		}
+		entity.CreateNilSpace(GameID)
+	}
+
+	c := &Cluster{
+		clients: map[common.ClientID]*Client{},
+	}
+	c.reset()
+	return c
+}
+
+// reset destroys entities except the nil space, and drops packets sent by them
+func (c *Cluster) reset() {
+	nilSpaceID := entity.GetNilSpaceID(GameID)
+	for _, e := range entity.Entities() {
+		if e.ID != nilSpaceID {
+			e.Destroy()
+		}
+	}
+	c.Deliver()
+}
+
+// RegisterEntity registers the entity type, types which are already registered are kept as is, so that all tests can
+// register the types they use
+func (c *Cluster) RegisterEntity(typeName string, entityPtr entity.IEntity) *entity.EntityTypeDesc {
+	if desc := entity.GetEntityTypeDesc(typeName); desc != nil {
+		return desc
+	}
+	return entity.RegisterEntity(typeName, entityPtr, false)
+}
+
+// CreateEntity creates the entity in the nil space, and delivers all packets sent by the creation
+func (c *Cluster) CreateEntity(typeName string, data map[string]interface{}) *entity.Entity {
+	e := entity.CreateEntityLocally(typeName, data)
+	c.Deliver()
+	return e
+}
+
+// Entity returns the entity of the ID, or nil if the entity is not found
+func (c *Cluster) Entity(id common.EntityID) *entity.Entity {
+	return entity.GetEntity(id)
+}
+
+// Call calls the entity method as the server, and delivers all packets sent by the call
+func (c *Cluster) Call(id common.EntityID, method string, args ...interface{}) {
+	entity.OnCall(id, method, packArgs(args), "")
+	c.Deliver()
+}
+
+// ConnectClient connects a new client to the entity, and delivers all packets sent by the connection
+func (c *Cluster) ConnectClient(e *entity.Entity) *Client {
+	client := &Client{
+		ID:      common.GenClientID(),
+		cluster: c,
+	}
+	c.clients[client.ID] = client
+	e.SetClient(entity.MakeGameClient(client.ID, GateID))
+	c.Deliver()
+	return client
+}
+
+// Now returns the time of the fake clock
+func (c *Cluster) Now() time.Time {
+	return now
+}
+
+// Advance moves the fake clock forward by ticks of the game, timers are fired and packets are delivered at each tick
+func (c *Cluster) Advance(d time.Duration) {
+	for d > 0 {
+		step := consts.GAME_SERVICE_TICK_INTERVAL
+		if step > d {
+			step = d
+		}
+		now = now.Add(step)
+		d -= step
+
+		timerwheel.Tick()
+		c.Deliver()
+	}
+}
+
+// Deliver delivers packets sent by the game synchronously, until no more packets are sent
+func (c *Cluster) Deliver() {
+	for round := 0; ; round++ {
+		if round >= _MAX_DELIVER_ROUNDS {
+			gwlog.Panicf("Deliver: packets are still sent after %d rounds, entities may be calling each other endlessly", round)
+		}
+
+		post.Tick()
+		entity.FlushAttrPatches()
+		dispatchercluster.Flush("Deliver")
+		if !c.deliverPackets() {
+			return
+		}
+	}
+}
+
+// deliverPackets delivers all packets written to the connection, and returns if any packet is delivered
+func (c *Cluster) deliverPackets() bool {
+	delivered := false
+	for {
+		var msgtype proto.MsgType
+		pkt, err := recvConn.Recv(&msgtype)
+		if err == io.EOF {
+			return delivered
+		} else if err != nil {
+			gwlog.Panic(err)
+		}
+
+		delivered = true
+		c.deliverPacket(msgtype, pkt)
+		pkt.Release()
+	}
+}
+
+func (c *Cluster) deliverPacket(msgtype proto.MsgType, pkt *netutil.Packet) {
+	if (msgtype > proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype < proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP) ||
+		msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_WITH_DELIVERY {
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		if client := c.clients[clientid]; client != nil {
+			client.packets = append(client.packets, readClientPacket(msgtype, pkt))
+		}
+		return
+	}
+
+	switch msgtype {
+	case proto.MT_CALL_ENTITY_METHOD:
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnCall(eid, method, args, "")
+	case proto.MT_CALL_ENTITY_METHOD_WITH_SEQ:
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnCall(eid, method, args, "")
+		_ = pkt.ReadUint16() // gameid
+		dispid := pkt.ReadUint16()
+		_ = pkt.ReadUint32() // epoch
+		dispatchercluster.AckEntityRPCs(dispid, []uint64{pkt.ReadUint64()}) // calls are never lost in memory
+	case proto.MT_CALL_ENTITY_METHOD_WITH_RESULT:
+		eid := pkt.ReadEntityID()
+		srcGameID := pkt.ReadUint16()
+		callID := pkt.ReadUint32()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnCallWithResult(eid, method, args, srcGameID, callID)
+	case proto.MT_CALL_ENTITY_METHOD_RESULT:
+		_ = pkt.ReadUint16() // gameid
+		callID := pkt.ReadUint32()
+		code := proto.RPCErrorCode(pkt.ReadUint16())
+		message := pkt.ReadVarStr()
+		results := pkt.ReadArgs()
+		entity.OnCallResult(callID, code, message, results)
+	case proto.MT_CREATE_ENTITY_SOMEWHERE:
+		_ = pkt.ReadUint16() // gameid
+		entityid := pkt.ReadEntityID()
+		typeName := pkt.ReadVarStr()
+		var data map[string]interface{}
+		pkt.ReadData(&data)
+		entity.OnCreateEntitySomewhere(entityid, typeName, data)
+	case proto.MT_CALL_NIL_SPACES:
+		_ = pkt.ReadUint16() // except gameid, the only game is always called
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnCallNilSpaces(method, args)
+	case proto.MT_PUBLISH_CHANNEL:
+		channel := pkt.ReadVarStr()
+		toClients := pkt.ReadBool()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnPublish(channel, toClients, method, args)
+	default:
+		// other packets are handled by dispatchers, which are not needed by entities of one game
+	}
+}
+
+// AssertAttr fails the test if the attribute of the entity is not equal to expected
+//
+// Integers and floats are compared by value regardless of their types, maps and lists are compared recursively.
+func (c *Cluster) AssertAttr(t TB, e *entity.Entity, key string, expected interface{}) {
+	t.Helper()
+	val, ok := e.Attrs.ToMap()[key]
+	if !ok {
+		t.Fatalf("%s: attribute %s not found, expected %v", e, key, expected)
+		return
+	}
+	if !reflect.DeepEqual(uniformValue(val), uniformValue(expected)) {
+		t.Fatalf("%s: attribute %s is %v, expected %v", e, key, val, expected)
+	}
+}
+
+// uniformValue converts integers to int64, floats to float64, maps to map[string]interface{} and lists to
+// []interface{}, recursively
+func uniformValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[k.String()] = uniformValue(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			l[i] = uniformValue(rv.Index(i).Interface())
+		}
+		return l
+	default:
+		return v
+	}
+}
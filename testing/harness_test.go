@@ -0,0 +1,98 @@
+package testing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type testAvatar struct {
+	entity.Entity
+}
+
+func (a *testAvatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.DefineAttr("jumps", "AllClients")
+	desc.DefineAttr("pongs")
+	desc.DefineAttr("countdowns")
+}
+
+func (a *testAvatar) Jump_Client() {
+	a.Attrs.SetInt("jumps", a.GetInt("jumps")+1)
+	a.CallClient("OnJump", a.GetInt("jumps"))
+}
+
+func (a *testAvatar) Ping(from common.EntityID) {
+	a.Call(from, "Pong")
+}
+
+func (a *testAvatar) Pong() {
+	a.Attrs.SetInt("pongs", a.GetInt("pongs")+1)
+}
+
+func (a *testAvatar) StartCountdown() {
+	a.AddCallback(time.Second, "OnCountdown")
+}
+
+func (a *testAvatar) OnCountdown() {
+	a.Attrs.SetInt("countdowns", a.GetInt("countdowns")+1)
+}
+
+func newTestCluster() *Cluster {
+	c := New()
+	c.RegisterEntity("testAvatar", &testAvatar{})
+	return c
+}
+
+func TestClientCall(t *testing.T) {
+	c := newTestCluster()
+	avatar := c.CreateEntity("testAvatar", nil)
+	client := c.ConnectClient(avatar)
+	packets := client.Packets()
+	if p := packets[len(packets)-1]; p.MsgType != proto.MT_CREATE_ENTITY_ON_CLIENT || p.EntityID != avatar.ID || !p.IsPlayer {
+		t.Fatalf("entity is not created on client: %+v", p)
+	}
+
+	client.TakePackets()
+	client.Call(avatar.ID, "Jump")
+	c.AssertAttr(t, avatar, "jumps", 1)
+	call := client.AssertCalled(t, "OnJump")
+	if len(call.Args) != 1 || uniformValue(call.Args[0]) != int64(1) {
+		t.Fatalf("wrong args of OnJump: %v", call.Args)
+	}
+}
+
+func TestEntityCall(t *testing.T) {
+	c := newTestCluster()
+	a1 := c.CreateEntity("testAvatar", nil)
+	a2 := c.CreateEntity("testAvatar", nil)
+	c.Call(a1.ID, "Ping", a2.ID)
+	c.AssertAttr(t, a2, "pongs", 1)
+}
+
+func TestAdvance(t *testing.T) {
+	c := newTestCluster()
+	avatar := c.CreateEntity("testAvatar", nil)
+	c.Call(avatar.ID, "StartCountdown")
+	start := c.Now()
+	c.Advance(time.Millisecond * 900)
+	if avatar.GetInt("countdowns") != 0 {
+		t.Fatalf("callback is fired before due")
+	}
+	c.Advance(time.Millisecond * 200)
+	c.AssertAttr(t, avatar, "countdowns", 1)
+	if d := c.Now().Sub(start); d != time.Millisecond*1100 {
+		t.Fatalf("clock advanced by %s", d)
+	}
+}
+
+func TestNewDestroysEntities(t *testing.T) {
+	c := newTestCluster()
+	avatar := c.CreateEntity("testAvatar", nil)
+	c = newTestCluster()
+	if !avatar.IsDestroyed() || c.Entity(avatar.ID) != nil {
+		t.Fatalf("entity of the previous cluster is not destroyed")
+	}
+}
@@ -0,0 +1,69 @@
+package testing
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// memConn is an in-memory connection, bytes written to the connection are read back from the same connection
+type memConn struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+type memAddr struct{}
+
+func (memAddr) Network() string {
+	return "mem"
+}
+
+func (memAddr) String() string {
+	return "mem"
+}
+
+// Read reads bytes written to the connection, and returns io.EOF if there is nothing to read
+func (c *memConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.buffer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.buffer.Read(b)
+}
+
+func (c *memConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.buffer.Write(b)
+}
+
+func (c *memConn) Flush() error {
+	return nil
+}
+
+func (c *memConn) Close() error {
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return memAddr{}
+}
+
+func (c *memConn) RemoteAddr() net.Addr {
+	return memAddr{}
+}
+
+func (c *memConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}